@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/yeti47/ollama-proxy/internal/audit"
+)
+
+const auditUsage = `usage: ollama-proxy audit verify <file>
+
+Check the hash chain of an audit log written with -audit-log. Prints the
+number of records and the hash of the last one; compare it with a copy
+kept elsewhere to detect truncation.`
+
+// runAudit implements the "audit" subcommand and returns the exit status.
+func runAudit(args []string) int {
+	if len(args) != 2 || args[0] != "verify" {
+		fmt.Fprintln(os.Stderr, auditUsage)
+		return 2
+	}
+	f, err := os.Open(args[1])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "opening audit log: %v\n", err)
+		return 1
+	}
+	defer f.Close()
+	n, head, err := audit.Verify(f)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "audit log is NOT intact after %d good records: %v\n", n, err)
+		return 1
+	}
+	fmt.Printf("ok: %d records, head %s\n", n, head)
+	return 0
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/yeti47/ollama-proxy/internal/keyring"
+)
+
+const keysUsage = `usage: ollama-proxy keys <set|get|delete> [account]
+
+Manage the upstream API key in the OS keyring (macOS Keychain, Windows
+Credential Manager, or Secret Service on Linux). "set" reads the key from
+stdin. Start the proxy with -api-key-source keyring:// (or
+keyring://<account>) to use the stored key. The account defaults to
+"default".`
+
+// runKeys implements the "keys" subcommand and returns the exit status.
+func runKeys(args []string) int {
+	if len(args) < 1 || len(args) > 2 {
+		fmt.Fprintln(os.Stderr, keysUsage)
+		return 2
+	}
+	account := keyring.DefaultAccount
+	if len(args) == 2 {
+		account = args[1]
+	}
+	switch args[0] {
+	case "set":
+		fmt.Fprint(os.Stderr, "Ollama API key: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			fmt.Fprintf(os.Stderr, "\nreading key: %v\n", err)
+			return 1
+		}
+		if err := keyring.Set(account, strings.TrimSpace(line)); err != nil {
+			fmt.Fprintf(os.Stderr, "storing key: %v\n", err)
+			return 1
+		}
+		fmt.Fprintf(os.Stderr, "stored key for account %q\n", account)
+	case "get":
+		k, err := keyring.Get(account)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "reading key: %v\n", err)
+			return 1
+		}
+		fmt.Println(k)
+	case "delete":
+		if err := keyring.Delete(account); err != nil && !errors.Is(err, keyring.ErrNotFound) {
+			fmt.Fprintf(os.Stderr, "deleting key: %v\n", err)
+			return 1
+		}
+	default:
+		fmt.Fprintln(os.Stderr, keysUsage)
+		return 2
+	}
+	return 0
+}
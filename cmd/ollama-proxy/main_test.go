@@ -0,0 +1,91 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadKeyFile(t *testing.T) {
+	dir := t.TempDir()
+	cases := []struct {
+		name, contents string
+		want           string
+		err            string
+	}{
+		{"plain", "sk-1", "sk-1", ""},
+		{"trailing newline", "sk-1\n", "sk-1", ""},
+		{"surrounding whitespace", " \t sk-1 \r\n\n", "sk-1", ""},
+		{"several keys", "sk-1,sk-2\n", "sk-1,sk-2", ""},
+		{"empty", "", "", "is empty"},
+		{"only whitespace", " \n\t\n", "", "is empty"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(dir, strings.ReplaceAll(tc.name, " ", "-"))
+			if err := os.WriteFile(path, []byte(tc.contents), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			got, err := readKeyFile(path)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("err = %v, want %q", err, tc.err)
+				}
+				return
+			}
+			if err != nil || got != tc.want {
+				t.Fatalf("readKeyFile = %q, %v, want %q", got, err, tc.want)
+			}
+		})
+	}
+
+	if _, err := readKeyFile(filepath.Join(dir, "missing")); !os.IsNotExist(err) {
+		t.Errorf("missing file: %v", err)
+	}
+}
+
+// TestReadKeyFileReload follows a key file through a rotation, the way
+// reloadSecrets re-reads it on SIGHUP or a change.
+func TestReadKeyFileReload(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "key")
+	write := func(s string) {
+		t.Helper()
+		// secret mounts swap the file rather than writing it in place
+		tmp := filepath.Join(dir, "key.tmp")
+		if err := os.WriteFile(tmp, []byte(s), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	steps := []struct {
+		contents string
+		want     string
+		ok       bool
+	}{
+		{"sk-old\n", "sk-old", true},
+		{"sk-new\n", "sk-new", true},
+		// a file emptied halfway through a rotation must not clear the key
+		{"", "", false},
+		{"sk-newer", "sk-newer", true},
+	}
+	for i, s := range steps {
+		write(s.contents)
+		got, err := readKeyFile(path)
+		if (err == nil) != s.ok || got != s.want {
+			t.Fatalf("step %d: readKeyFile = %q, %v, want %q", i, got, err, s.want)
+		}
+	}
+}
+
+func TestRunKeysUsage(t *testing.T) {
+	for _, args := range [][]string{nil, {"get", "a", "b"}, {"list"}} {
+		if code := runKeys(args); code != 2 {
+			t.Errorf("runKeys(%q) = %d, want 2", args, code)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/yeti47/ollama-proxy/internal/usage"
+)
+
+const usageUsage = `usage: ollama-proxy usage export -state-file <file> [-from <date>] [-to <date>] [-key <key>] [-format csv|json]
+
+Print per-key, per-model usage from a -usage-state-file for chargeback
+spreadsheets. Dates are 2006-01-02 (midnight UTC) or RFC 3339 timestamps;
+the range defaults to the current month. A running proxy saves the file
+every minute, so the last minute may be missing; use the admin endpoint
+/admin/usage/export for live numbers.`
+
+// runUsage implements the "usage" subcommand and returns the exit status.
+func runUsage(args []string) int {
+	if len(args) < 1 || args[0] != "export" {
+		fmt.Fprintln(os.Stderr, usageUsage)
+		return 2
+	}
+	fs := flag.NewFlagSet("usage export", flag.ContinueOnError)
+	fs.Usage = func() { fmt.Fprintln(os.Stderr, usageUsage) }
+	stateFile := fs.String("state-file", "", "usage state file written by -usage-state-file")
+	from := fs.String("from", "", "start of the range (inclusive)")
+	to := fs.String("to", "", "end of the range (exclusive)")
+	key := fs.String("key", "", "only export this client key")
+	format := fs.String("format", "csv", "csv or json")
+	if err := fs.Parse(args[1:]); err != nil || *stateFile == "" || fs.NArg() > 0 {
+		if err == nil {
+			fmt.Fprintln(os.Stderr, usageUsage)
+		}
+		return 2
+	}
+	if _, err := os.Stat(*stateFile); err != nil {
+		fmt.Fprintf(os.Stderr, "reading usage state: %v\n", err)
+		return 1
+	}
+	l, err := usage.NewLedger(0, *stateFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "reading usage state: %v\n", err)
+		return 1
+	}
+	start, end, err := l.Range(*from, *to)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if err := usage.Export(os.Stdout, *format, start, end, l.Query(*key, start, end)); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
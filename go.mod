@@ -1,3 +1,8 @@
 module github.com/yeti47/ollama-proxy
 
 go 1.21
+
+require (
+	github.com/mattn/go-sqlite3 v1.14.22
+	golang.org/x/crypto v0.31.0
+)
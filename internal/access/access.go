@@ -0,0 +1,213 @@
+package access
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yeti47/ollama-proxy/internal/apierror"
+	"github.com/yeti47/ollama-proxy/internal/audit"
+	"github.com/yeti47/ollama-proxy/internal/auth"
+	"github.com/yeti47/ollama-proxy/internal/ollama"
+)
+
+// MutatingPaths are the Ollama endpoints that change the model store on
+// the upstream. Blob uploads are part of /api/create.
+var MutatingPaths = []string{
+	"/api/delete",
+	"/api/create",
+	"/api/push",
+	"/api/copy",
+	"/api/pull",
+	"/api/blobs/",
+}
+
+// CleanPath normalizes p so that variants like "//api/delete" or
+// "/api/./delete" match the same rule as "/api/delete". A trailing slash is
+// kept because it is significant for prefix rules.
+func CleanPath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	c := path.Clean("/" + p)
+	if strings.HasSuffix(p, "/") && c != "/" {
+		c += "/"
+	}
+	return c
+}
+
+// matchPath reports whether p matches rule. Rules ending in "/" match
+// everything below them; other rules match exactly.
+func matchPath(rule, p string) bool {
+	if strings.HasSuffix(rule, "/") {
+		return strings.HasPrefix(p, rule)
+	}
+	return p == rule || p == rule+"/"
+}
+
+// ReadOnly rejects requests to MutatingPaths with 403 while letting
+// chat, generate, embed and listing calls through.
+func ReadOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := CleanPath(r.URL.Path)
+		for _, rule := range MutatingPaths {
+			if matchPath(rule, p) {
+				slog.WarnContext(r.Context(), "read-only mode rejected", "remote", r.RemoteAddr, "method", r.Method, "path", r.URL.Path)
+				audit.Event(audit.Blocked, r, auth.ClientName(r.Context()), "read-only mode")
+				apierror.Write(w, http.StatusForbidden, "proxy is in read-only mode: "+strings.TrimSuffix(rule, "/")+" is disabled")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// PathFilter limits which upstream paths may be proxied. Rules ending in
+// "/" match a whole subtree, other rules match exactly. Deny rules win over
+// allow rules; an empty allow list allows everything not denied.
+type PathFilter struct {
+	Allow []string
+	Deny  []string
+}
+
+// ParseRules splits a comma-separated list of path rules and normalizes
+// each one.
+func ParseRules(s string) []string {
+	var out []string
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, CleanPath(p))
+		}
+	}
+	return out
+}
+
+// Check returns the status a request for p should be rejected with, or 0
+// if it may proceed. Paths outside the allow list get 404 so the proxy
+// doesn't advertise what exists upstream; explicitly denied paths get 403.
+func (f *PathFilter) Check(p string) int {
+	p = CleanPath(p)
+	for _, rule := range f.Deny {
+		if matchPath(rule, p) {
+			return http.StatusForbidden
+		}
+	}
+	if len(f.Allow) == 0 {
+		return 0
+	}
+	for _, rule := range f.Allow {
+		if matchPath(rule, p) {
+			return 0
+		}
+	}
+	return http.StatusNotFound
+}
+
+// PathMiddleware rejects requests whose path f does not allow.
+func PathMiddleware(f *PathFilter, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if code := f.Check(r.URL.Path); code != 0 {
+			slog.WarnContext(r.Context(), "path filter rejected", "remote", r.RemoteAddr, "method", r.Method, "path", r.URL.Path, "status", code)
+			audit.Event(audit.Blocked, r, auth.ClientName(r.Context()), "path filter")
+			msg := "not found"
+			if code == http.StatusForbidden {
+				msg = "endpoint is disabled on this proxy"
+			}
+			apierror.Write(w, code, msg)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ModelAllowed reports whether model matches one of patterns. Patterns may
+// use path.Match wildcards (e.g. "llama3*" or "*:8b"); names without a tag
+// are treated as ":latest" on both sides.
+func ModelAllowed(patterns []string, model string) bool {
+	norm := ollama.NormalizeModel(model)
+	for _, p := range patterns {
+		if strings.ContainsAny(p, "*?[") {
+			if ok, _ := path.Match(p, model); ok {
+				return true
+			}
+			if ok, _ := path.Match(p, norm); ok {
+				return true
+			}
+			continue
+		}
+		if ollama.NormalizeModel(p) == norm {
+			return true
+		}
+	}
+	return false
+}
+
+// ModelMiddleware enforces the model allowlist attached to the caller's
+// identity. Requests that don't name a model (tags, version, ...) and
+// callers without a list pass through.
+func ModelMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := auth.FromContext(r.Context())
+		if id == nil || len(id.Models) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		model, err := ollama.PeekModel(r)
+		if err != nil {
+			apierror.BodyError(w, err)
+			return
+		}
+		if model != "" && !ModelAllowed(id.Models, model) {
+			slog.WarnContext(r.Context(), "model rejected", "model", model, "client", id.Name)
+			audit.Event(audit.Blocked, r, id.Name, "model "+model+" not allowed")
+			apierror.Write(w, http.StatusForbidden, fmt.Sprintf("model %q is not allowed for this key", model))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// scheduleRefusal is the 403 body for requests outside a key's schedule.
+type scheduleRefusal struct {
+	Error      string `json:"error"`
+	Schedule   string `json:"schedule"`
+	NextWindow string `json:"next_window,omitempty"`
+}
+
+// ScheduleMiddleware rejects requests made outside the access schedule
+// attached to the caller's identity with 403. The response names the start
+// of the next allowed window, which is also sent as Retry-After.
+func ScheduleMiddleware(next http.Handler) http.Handler {
+	return scheduleMiddleware(time.Now, next)
+}
+
+func scheduleMiddleware(now func() time.Time, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := auth.FromContext(r.Context())
+		t := now()
+		if id == nil || id.Schedule.Allowed(t) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		slog.WarnContext(r.Context(), "schedule rejected", "client", id.Name, "method", r.Method, "path", r.URL.Path)
+		audit.Event(audit.Blocked, r, id.Name, "outside schedule "+id.Schedule.String())
+		body := scheduleRefusal{
+			Error:    "this key may not be used at this time",
+			Schedule: id.Schedule.String(),
+		}
+		if start, ok := id.Schedule.Next(t); ok {
+			body.NextWindow = start.Format(time.RFC3339)
+			body.Error += "; next window opens at " + body.NextWindow
+			w.Header().Set("Retry-After", strconv.Itoa(int(start.Sub(t).Seconds())+1))
+		}
+		b, _ := json.Marshal(body)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write(b)
+	})
+}
@@ -0,0 +1,137 @@
+package access
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yeti47/ollama-proxy/internal/auth"
+	"github.com/yeti47/ollama-proxy/internal/schedule"
+)
+
+func TestReadOnly(t *testing.T) {
+	h := ReadOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	cases := map[string]int{
+		"/api/chat":            http.StatusOK,
+		"/api/generate":        http.StatusOK,
+		"/api/embed":           http.StatusOK,
+		"/api/tags":            http.StatusOK,
+		"/api/delete":          http.StatusForbidden,
+		"/api/pull":            http.StatusForbidden,
+		"//api/./push":         http.StatusForbidden,
+		"/api/copy/":           http.StatusForbidden,
+		"/api/blobs/sha256:ab": http.StatusForbidden,
+		"/api/pullover":        http.StatusOK,
+	}
+	for p, want := range cases {
+		req := httptest.NewRequest("POST", "/", nil)
+		req.URL.Path = p
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Errorf("%s: expected %d got %d", p, want, rec.Code)
+		}
+	}
+}
+
+func TestPathFilter(t *testing.T) {
+	f := &PathFilter{
+		Allow: ParseRules("/api/chat, /api/embed,/api/tags,/api/version,/v1/"),
+		Deny:  ParseRules("/v1/completions"),
+	}
+	cases := map[string]int{
+		"/api/chat":             0,
+		"/api/tags/":            0,
+		"//api/embed":           0,
+		"/v1/chat/completions":  0,
+		"/v1/completions":       http.StatusForbidden,
+		"/api/generate":         http.StatusNotFound,
+		"/api/chat/../delete":   http.StatusNotFound,
+		"/":                     http.StatusNotFound,
+		"/api/version-override": http.StatusNotFound,
+	}
+	for p, want := range cases {
+		if got := f.Check(p); got != want {
+			t.Errorf("%s: expected %d got %d", p, want, got)
+		}
+	}
+}
+
+func TestModelMiddleware(t *testing.T) {
+	var forwardedBody string
+	h := ModelMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		forwardedBody = string(b)
+		w.WriteHeader(http.StatusOK)
+	}))
+	id := &auth.Identity{Name: "intern", Models: []string{"llama3.2", "qwen2.5:*"}}
+
+	cases := []struct {
+		body string
+		want int
+	}{
+		{`{"model":"llama3.2:latest","messages":[]}`, http.StatusOK},
+		{`{"model":"llama3.2","messages":[]}`, http.StatusOK},
+		{`{"model":"qwen2.5:7b","prompt":"hi"}`, http.StatusOK},
+		{`{"model":"llama3.1:70b","messages":[]}`, http.StatusForbidden},
+		{``, http.StatusOK},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest("POST", "/api/chat", strings.NewReader(tc.body))
+		req = req.WithContext(auth.WithIdentity(req.Context(), id))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("%s: expected %d got %d", tc.body, tc.want, rec.Code)
+		}
+		if tc.want == http.StatusOK && forwardedBody != tc.body {
+			t.Errorf("body not forwarded intact: %q", forwardedBody)
+		}
+	}
+}
+
+func TestScheduleMiddleware(t *testing.T) {
+	sched, err := schedule.New("UTC", []string{"mon-fri 08:00-20:00"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 10, 16, 21, 0, 0, 0, time.UTC) // Friday evening
+	h := scheduleMiddleware(func() time.Time { return now }, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	serve := func(id *auth.Identity) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/chat", nil)
+		if id != nil {
+			req = req.WithContext(auth.WithIdentity(req.Context(), id))
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := serve(nil); rec.Code != http.StatusOK {
+		t.Errorf("anonymous: %d", rec.Code)
+	}
+	if rec := serve(&auth.Identity{Name: "lab"}); rec.Code != http.StatusOK {
+		t.Errorf("no schedule: %d", rec.Code)
+	}
+	rec := serve(&auth.Identity{Name: "batch", Schedule: sched})
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("outside window: %d", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), `"next_window":"2026-10-19T08:00:00Z"`) {
+		t.Errorf("body = %s", rec.Body)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "212401" {
+		t.Errorf("Retry-After = %q", got)
+	}
+	now = time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)
+	if rec := serve(&auth.Identity{Name: "batch", Schedule: sched}); rec.Code != http.StatusOK {
+		t.Errorf("inside window: %d", rec.Code)
+	}
+}
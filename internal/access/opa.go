@@ -0,0 +1,177 @@
+package access
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/yeti47/ollama-proxy/internal/apierror"
+	"github.com/yeti47/ollama-proxy/internal/audit"
+	"github.com/yeti47/ollama-proxy/internal/auth"
+	"github.com/yeti47/ollama-proxy/internal/ipfilter"
+	"github.com/yeti47/ollama-proxy/internal/ollama"
+)
+
+// OPA asks an Open Policy Agent server whether a request may proceed. URL
+// points at a decision document, e.g.
+// http://localhost:8181/v1/data/ollama/authz. The policy may return a plain
+// boolean or an object:
+//
+//	{"allow": true, "reason": "...", "set_headers": {"X-Team": "a"}, "model": "llama3.2"}
+//
+// set_headers are added to the upstream request and model rewrites the
+// requested model. If OPA can't be reached the request is denied.
+type OPA struct {
+	URL    string
+	Client *http.Client
+	// TrustedProxies is used to resolve the client address passed to the
+	// policy.
+	TrustedProxies ipfilter.Prefixes
+}
+
+// NewOPA returns an OPA client for the decision at url.
+func NewOPA(url string, timeout time.Duration) *OPA {
+	return &OPA{URL: url, Client: &http.Client{Timeout: timeout}}
+}
+
+// OPAInput is the document sent to the policy as input.
+type OPAInput struct {
+	Method   string              `json:"method"`
+	Path     string              `json:"path"`
+	Query    string              `json:"query,omitempty"`
+	Headers  map[string][]string `json:"headers"`
+	Model    string              `json:"model,omitempty"`
+	ClientIP string              `json:"client_ip"`
+	Identity *OPAIdentity        `json:"identity,omitempty"`
+}
+
+// OPAIdentity is the caller identity as seen by the policy. Secrets such
+// as upstream keys are never included.
+type OPAIdentity struct {
+	Name   string         `json:"name"`
+	Tenant string         `json:"tenant,omitempty"`
+	Roles  []string       `json:"roles,omitempty"`
+	Claims map[string]any `json:"claims,omitempty"`
+}
+
+// OPADecision is the policy result.
+type OPADecision struct {
+	Allow      bool              `json:"allow"`
+	Reason     string            `json:"reason,omitempty"`
+	SetHeaders map[string]string `json:"set_headers,omitempty"`
+	Model      string            `json:"model,omitempty"`
+}
+
+// Decide queries the policy.
+func (o *OPA) Decide(ctx context.Context, in *OPAInput) (*OPADecision, error) {
+	body, err := json.Marshal(map[string]any{"input": in})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := o.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("opa status %d", resp.StatusCode)
+	}
+	var out struct {
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("decoding opa response: %w", err)
+	}
+	// an undefined decision means the policy didn't match: deny
+	if len(out.Result) == 0 {
+		return &OPADecision{Reason: "policy decision is undefined"}, nil
+	}
+	var allow bool
+	if json.Unmarshal(out.Result, &allow) == nil {
+		return &OPADecision{Allow: allow}, nil
+	}
+	var d OPADecision
+	if err := json.Unmarshal(out.Result, &d); err != nil {
+		return nil, fmt.Errorf("unexpected opa result: %w", err)
+	}
+	return &d, nil
+}
+
+// opaHeaders copies h for the policy input with credentials masked.
+func opaHeaders(h http.Header) map[string][]string {
+	out := make(map[string][]string, len(h))
+	for k, v := range h {
+		switch strings.ToLower(k) {
+		case "authorization", "cookie", "proxy-authorization":
+			out[k] = []string{"[REDACTED]"}
+		default:
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// OPAMiddleware authorizes each request with the policy and applies any
+// transformations it returns.
+func OPAMiddleware(o *OPA, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ollama.PeekBody(r)
+		if err != nil {
+			apierror.BodyError(w, err)
+			return
+		}
+		clientIP, _ := ipfilter.ClientIP(r, o.TrustedProxies)
+		in := &OPAInput{
+			Method:   r.Method,
+			Path:     CleanPath(r.URL.Path),
+			Query:    r.URL.RawQuery,
+			Headers:  opaHeaders(r.Header),
+			Model:    ollama.Model(body),
+			ClientIP: clientIP.String(),
+		}
+		if id := auth.FromContext(r.Context()); id != nil {
+			in.Identity = &OPAIdentity{Name: id.Name, Tenant: id.Tenant, Roles: id.Roles, Claims: id.Claims}
+		}
+
+		d, err := o.Decide(r.Context(), in)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "opa query failed", "method", r.Method, "path", r.URL.Path, "err", err)
+			apierror.Write(w, http.StatusForbidden, "authorization policy unavailable")
+			return
+		}
+		if !d.Allow {
+			slog.WarnContext(r.Context(), "opa denied", "remote", r.RemoteAddr, "method", r.Method, "path", r.URL.Path, "reason", d.Reason)
+			audit.Event(audit.Blocked, r, auth.ClientName(r.Context()), strings.TrimSpace("opa "+d.Reason))
+			msg := "denied by policy"
+			if d.Reason != "" {
+				msg += ": " + d.Reason
+			}
+			apierror.Write(w, http.StatusForbidden, msg)
+			return
+		}
+
+		for k, v := range d.SetHeaders {
+			r.Header.Set(k, v)
+		}
+		if d.Model != "" && d.Model != in.Model && len(body) > 0 {
+			var m map[string]any
+			if json.Unmarshal(body, &m) == nil {
+				m["model"] = d.Model
+				if nb, err := json.Marshal(m); err == nil {
+					ollama.ReplaceBody(r, nb)
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,68 @@
+package access
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/yeti47/ollama-proxy/internal/auth"
+)
+
+func TestOPAMiddleware(t *testing.T) {
+	var lastInput OPAInput
+	opaSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Input OPAInput `json:"input"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		lastInput = body.Input
+		switch body.Input.Model {
+		case "llama3.1:70b":
+			_, _ = w.Write([]byte(`{"result":{"allow":false,"reason":"70b models are reserved"}}`))
+		case "fast":
+			_, _ = w.Write([]byte(`{"result":{"allow":true,"model":"llama3.2:3b","set_headers":{"X-Policy":"rewritten"}}}`))
+		default:
+			_, _ = w.Write([]byte(`{"result":true}`))
+		}
+	}))
+	defer opaSrv.Close()
+
+	var gotBody, gotHeader string
+	h := OPAMiddleware(NewOPA(opaSrv.URL, 0), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		gotBody = string(b)
+		gotHeader = r.Header.Get("X-Policy")
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	do := func(body string) int {
+		req := httptest.NewRequest("POST", "/api/chat", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		req = req.WithContext(auth.WithIdentity(req.Context(), &auth.Identity{Name: "alice", Roles: []string{"user"}}))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := do(`{"model":"llama3.2"}`); code != http.StatusOK {
+		t.Fatalf("expected 200 got %d", code)
+	}
+	if lastInput.Identity == nil || lastInput.Identity.Name != "alice" || lastInput.Path != "/api/chat" {
+		t.Fatalf("unexpected policy input %+v", lastInput)
+	}
+	if got := lastInput.Headers["Authorization"]; len(got) != 1 || got[0] != "[REDACTED]" {
+		t.Fatalf("authorization header not redacted: %v", got)
+	}
+	if code := do(`{"model":"llama3.1:70b"}`); code != http.StatusForbidden {
+		t.Fatalf("expected 403 got %d", code)
+	}
+	if code := do(`{"model":"fast"}`); code != http.StatusOK {
+		t.Fatalf("expected 200 got %d", code)
+	}
+	if !strings.Contains(gotBody, `"model":"llama3.2:3b"`) || gotHeader != "rewritten" {
+		t.Fatalf("transformations not applied: body=%s header=%q", gotBody, gotHeader)
+	}
+}
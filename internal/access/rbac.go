@@ -0,0 +1,103 @@
+package access
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/yeti47/ollama-proxy/internal/apierror"
+	"github.com/yeti47/ollama-proxy/internal/audit"
+	"github.com/yeti47/ollama-proxy/internal/auth"
+)
+
+// Role grants access to a set of paths, optionally restricted to some
+// methods. Path rules follow PathFilter: a trailing "/" covers a subtree.
+type Role struct {
+	Paths   []string `json:"paths"`
+	Methods []string `json:"methods,omitempty"`
+}
+
+func (r Role) permits(method, p string) bool {
+	if len(r.Methods) > 0 {
+		ok := false
+		for _, m := range r.Methods {
+			if strings.EqualFold(m, method) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	for _, rule := range r.Paths {
+		if matchPath(CleanPath(rule), p) {
+			return true
+		}
+	}
+	return false
+}
+
+// RBAC authorizes requests based on the roles of the caller. Roles come
+// from the identity (client keys, tenants) or, for token-authenticated
+// callers, from the RolesClaim claim. Callers without any role get
+// DefaultRoles.
+type RBAC struct {
+	Roles        map[string]Role
+	DefaultRoles []string
+	RolesClaim   string
+}
+
+// RolesFor returns the effective roles of id.
+func (rb *RBAC) RolesFor(id *auth.Identity) []string {
+	if id != nil && len(id.Roles) > 0 {
+		return id.Roles
+	}
+	if id != nil && rb.RolesClaim != "" {
+		switch v := id.Claims[rb.RolesClaim].(type) {
+		case string:
+			return strings.Fields(v)
+		case []any:
+			var roles []string
+			for _, x := range v {
+				if s, ok := x.(string); ok {
+					roles = append(roles, s)
+				}
+			}
+			if len(roles) > 0 {
+				return roles
+			}
+		}
+	}
+	return rb.DefaultRoles
+}
+
+// Allowed reports whether any of the caller's roles permits method on p.
+// Unknown role names grant nothing.
+func (rb *RBAC) Allowed(id *auth.Identity, method, p string) bool {
+	p = CleanPath(p)
+	for _, name := range rb.RolesFor(id) {
+		if role, ok := rb.Roles[name]; ok && role.permits(method, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// RBACMiddleware rejects requests the caller's roles don't permit with 403.
+func RBACMiddleware(rb *RBAC, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := auth.FromContext(r.Context())
+		if !rb.Allowed(id, r.Method, r.URL.Path) {
+			name := "anonymous"
+			if id != nil {
+				name = id.Name
+			}
+			slog.WarnContext(r.Context(), "rbac rejected", "client", name, "roles", rb.RolesFor(id), "method", r.Method, "path", r.URL.Path)
+			audit.Event(audit.Blocked, r, auth.ClientName(r.Context()), "rbac")
+			apierror.Write(w, http.StatusForbidden, "your role does not permit "+r.Method+" "+CleanPath(r.URL.Path))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,46 @@
+package access
+
+import (
+	"testing"
+
+	"github.com/yeti47/ollama-proxy/internal/auth"
+)
+
+func TestRBAC(t *testing.T) {
+	rb := &RBAC{
+		Roles: map[string]Role{
+			"reader": {Paths: []string{"/api/tags", "/api/show", "/api/version"}},
+			"user":   {Paths: []string{"/api/chat", "/api/generate", "/api/embed"}, Methods: []string{"POST"}},
+			"admin":  {Paths: []string{"/"}},
+		},
+		DefaultRoles: []string{"reader"},
+		RolesClaim:   "roles",
+	}
+
+	anon := (*auth.Identity)(nil)
+	user := &auth.Identity{Name: "u", Roles: []string{"reader", "user"}}
+	jwtAdmin := &auth.Identity{Name: "jwt:a", Claims: map[string]any{"roles": []any{"admin"}}}
+	scoped := &auth.Identity{Name: "jwt:s", Claims: map[string]any{"roles": "user"}}
+
+	cases := []struct {
+		name   string
+		id     *auth.Identity
+		method string
+		path   string
+		want   bool
+	}{
+		{"default role can list", anon, "GET", "/api/tags", true},
+		{"default role cannot chat", anon, "POST", "/api/chat", false},
+		{"user can chat", user, "POST", "/api/chat", true},
+		{"user role is method-scoped", user, "GET", "/api/chat", false},
+		{"user cannot delete", user, "DELETE", "/api/delete", false},
+		{"admin from claim", jwtAdmin, "DELETE", "/api/delete", true},
+		{"space separated claim", scoped, "POST", "/api/embed", true},
+		{"claim roles replace defaults", scoped, "GET", "/api/tags", false},
+	}
+	for _, tc := range cases {
+		if got := rb.Allowed(tc.id, tc.method, tc.path); got != tc.want {
+			t.Errorf("%s: expected %t got %t", tc.name, tc.want, got)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/yeti47/ollama-proxy/internal/apierror"
+	"github.com/yeti47/ollama-proxy/internal/audit"
+)
+
+// Server collects the proxy's administrative endpoints under /admin/ and
+// guards them with a static bearer token. It can be mounted on the main
+// listener or served on a separate admin listener.
+type Server struct {
+	token string
+	mux   *http.ServeMux
+}
+
+// New returns an admin server protected by token.
+func New(token string) *Server {
+	return &Server{token: token, mux: http.NewServeMux()}
+}
+
+// Handle registers h for pattern, which should start with /admin/.
+func (s *Server) Handle(pattern string, h http.Handler) {
+	s.mux.Handle(pattern, h)
+}
+
+// EnablePprof serves the net/http/pprof profiles under
+// /admin/debug/pprof/, behind the admin token like everything else.
+func (s *Server) EnablePprof() {
+	pp := http.NewServeMux()
+	pp.HandleFunc("/debug/pprof/", pprof.Index)
+	pp.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	pp.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	pp.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	pp.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	// pprof.Index looks up profiles relative to /debug/pprof/
+	s.mux.Handle("/admin/debug/pprof/", http.StripPrefix("/admin", pp))
+}
+
+// ServeHTTP checks the admin token and dispatches to the registered
+// handlers.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	got := r.Header.Get("Authorization")
+	want := "Bearer " + s.token
+	if subtle.ConstantTimeCompare([]byte(got), []byte(want)) != 1 {
+		slog.WarnContext(r.Context(), "admin auth rejected", "remote", r.RemoteAddr, "method", r.Method, "path", r.URL.Path)
+		audit.Event(audit.AuthFailed, r, "", "admin token")
+		w.Header().Set("WWW-Authenticate", `Bearer realm="ollama-proxy-admin"`)
+		apierror.Write(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
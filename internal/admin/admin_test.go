@@ -0,0 +1,47 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServer(t *testing.T) {
+	s := New("s3cret")
+	s.Handle("/admin/ping", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	check := func(path, token string, want int) {
+		t.Helper()
+		req := httptest.NewRequest("GET", path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Errorf("GET %s with token %q: status %d, want %d", path, token, rec.Code, want)
+		}
+	}
+
+	check("/admin/ping", "", http.StatusUnauthorized)
+	check("/admin/ping", "guess", http.StatusUnauthorized)
+	check("/admin/ping", "s3cret", http.StatusNoContent)
+	// pprof is only served once enabled, and never without the token
+	check("/admin/debug/pprof/", "s3cret", http.StatusNotFound)
+	check("/admin/debug/pprof/", "", http.StatusUnauthorized)
+
+	s.EnablePprof()
+	check("/admin/debug/pprof/", "s3cret", http.StatusOK)
+	check("/admin/debug/pprof/heap", "s3cret", http.StatusOK)
+	check("/admin/debug/pprof/cmdline", "s3cret", http.StatusOK)
+	check("/admin/debug/pprof/", "", http.StatusUnauthorized)
+	check("/admin/debug/pprof/heap", "guess", http.StatusUnauthorized)
+
+	req := httptest.NewRequest("GET", "/admin/ping", nil)
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	if rec.Header().Get("WWW-Authenticate") == "" {
+		t.Error("401 without WWW-Authenticate")
+	}
+}
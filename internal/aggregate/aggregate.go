@@ -0,0 +1,165 @@
+// Package aggregate lets the proxy stream from the upstream even when the
+// client asked for a single JSON response. A long generation then keeps
+// the upstream connection busy instead of idling until the very end,
+// which idle and header timeouts of load balancers in between tend to cut
+// off. The chunks are assembled into the response the client expects.
+// Emulate does the reverse, for streaming clients that get a complete
+// response.
+package aggregate
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/yeti47/ollama-proxy/internal/apierror"
+	"github.com/yeti47/ollama-proxy/internal/metrics"
+	"github.com/yeti47/ollama-proxy/internal/ollama"
+)
+
+var aggregated = metrics.NewCounter("ollama_proxy_aggregated_total",
+	"Non-streaming requests served from a streamed upstream response, by endpoint.", "endpoint")
+
+// Middleware forwards /api/chat and /api/generate requests with
+// "stream": false as streaming requests and answers with the assembled
+// result. Other requests pass through untouched.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || (r.URL.Path != "/api/chat" && r.URL.Path != "/api/generate") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		body, err := ollama.PeekBody(r)
+		if err != nil {
+			apierror.BodyError(w, err)
+			return
+		}
+		var req map[string]json.RawMessage
+		if json.Unmarshal(body, &req) != nil || string(req["stream"]) != "false" {
+			// Ollama streams unless told otherwise
+			next.ServeHTTP(w, r)
+			return
+		}
+		req["stream"] = json.RawMessage("true")
+		b, _ := json.Marshal(req)
+		ollama.ReplaceBody(r, b)
+		r.Header.Del("Accept-Encoding")
+		aggregated.With(r.URL.Path).Inc()
+
+		bw := &bufferWriter{header: http.Header{}}
+		next.ServeHTTP(bw, r)
+		for k, v := range bw.header {
+			w.Header()[k] = v
+		}
+		mt, _, _ := mime.ParseMediaType(bw.header.Get("Content-Type"))
+		if bw.status != http.StatusOK || mt != "application/x-ndjson" {
+			// an error, or an upstream that answered in one piece anyway
+			w.WriteHeader(bw.status)
+			_, _ = w.Write(bw.buf.Bytes())
+			return
+		}
+		out, errMsg := assemble(bw.buf.Bytes(), r.URL.Path == "/api/chat")
+		if errMsg != "" {
+			w.Header().Del("Content-Length")
+			apierror.Write(w, http.StatusBadGateway, errMsg)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("Content-Length", strconv.Itoa(len(out)))
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(out)
+	})
+}
+
+// bufferWriter holds on to a whole response.
+type bufferWriter struct {
+	header http.Header
+	status int
+	buf    bytes.Buffer
+}
+
+func (w *bufferWriter) Header() http.Header { return w.header }
+
+func (w *bufferWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+}
+
+// Flush is a no-op: nothing reaches the client before the stream ends.
+func (w *bufferWriter) Flush() {}
+
+func (w *bufferWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.buf.Write(b)
+}
+
+// message is the part of a chat chunk that accumulates.
+type message struct {
+	Role      string            `json:"role"`
+	Content   string            `json:"content"`
+	Thinking  string            `json:"thinking,omitempty"`
+	ToolCalls []json.RawMessage `json:"tool_calls,omitempty"`
+	Images    json.RawMessage   `json:"images,omitempty"`
+}
+
+// assemble builds the non-streaming response from NDJSON chunks: the
+// final chunk, which carries done_reason, token counts, timings and for
+// /api/generate the context, with the text, thinking and tool calls of
+// all chunks put together. It returns the message of an error chunk if
+// the stream failed.
+func assemble(ndjson []byte, chat bool) ([]byte, string) {
+	var last map[string]json.RawMessage
+	var msg message
+	var response, thinking bytes.Buffer
+	for _, line := range bytes.Split(ndjson, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var chunk map[string]json.RawMessage
+		if err := json.Unmarshal(line, &chunk); err != nil {
+			return nil, "upstream sent an invalid stream chunk"
+		}
+		if e, ok := chunk["error"]; ok {
+			var s string
+			_ = json.Unmarshal(e, &s)
+			return nil, s
+		}
+		if chat {
+			var m message
+			_ = json.Unmarshal(chunk["message"], &m)
+			if m.Role != "" {
+				msg.Role = m.Role
+			}
+			msg.Content += m.Content
+			msg.Thinking += m.Thinking
+			msg.ToolCalls = append(msg.ToolCalls, m.ToolCalls...)
+		} else {
+			var c struct {
+				Response string `json:"response"`
+				Thinking string `json:"thinking"`
+			}
+			_ = json.Unmarshal(line, &c)
+			response.WriteString(c.Response)
+			thinking.WriteString(c.Thinking)
+		}
+		last = chunk
+	}
+	if last == nil {
+		return nil, "upstream sent an empty stream"
+	}
+	if chat {
+		last["message"], _ = json.Marshal(msg)
+	} else {
+		last["response"], _ = json.Marshal(response.String())
+		if thinking.Len() > 0 {
+			last["thinking"], _ = json.Marshal(thinking.String())
+		}
+	}
+	out, _ := json.Marshal(last)
+	return out, ""
+}
@@ -0,0 +1,101 @@
+package aggregate
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// upstream streams body as NDJSON and records the forwarded request.
+func upstream(t *testing.T, got *map[string]any, body string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(b, got); err != nil {
+			t.Errorf("forwarded body %s: %v", b, err)
+		}
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		_, _ = io.WriteString(w, body)
+	})
+}
+
+func TestChat(t *testing.T) {
+	var got map[string]any
+	h := Middleware(upstream(t, &got, strings.Join([]string{
+		`{"model":"llama3","message":{"role":"assistant","content":"","thinking":"hmm"},"done":false}`,
+		`{"model":"llama3","message":{"role":"assistant","content":"Hel"},"done":false}`,
+		`{"model":"llama3","message":{"role":"assistant","content":"lo","tool_calls":[{"function":{"name":"f","arguments":{}}}]},"done":false}`,
+		`{"model":"llama3","message":{"role":"assistant","content":""},"done":true,"done_reason":"stop","prompt_eval_count":3,"eval_count":5}`,
+	}, "\n")+"\n"))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/chat", strings.NewReader(
+		`{"model":"llama3","stream":false,"messages":[{"role":"user","content":"hi"}]}`)))
+
+	if got["stream"] != true {
+		t.Errorf("forwarded stream=%v", got["stream"])
+	}
+	var resp struct {
+		Message struct {
+			Role      string `json:"role"`
+			Content   string `json:"content"`
+			Thinking  string `json:"thinking"`
+			ToolCalls []any  `json:"tool_calls"`
+		} `json:"message"`
+		Done       bool   `json:"done"`
+		DoneReason string `json:"done_reason"`
+		EvalCount  int    `json:"eval_count"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("%v: %s", err, rec.Body)
+	}
+	m := resp.Message
+	if m.Role != "assistant" || m.Content != "Hello" || m.Thinking != "hmm" || len(m.ToolCalls) != 1 ||
+		!resp.Done || resp.DoneReason != "stop" || resp.EvalCount != 5 {
+		t.Errorf("response %s", rec.Body)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+		t.Errorf("Content-Type %q", ct)
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	var got map[string]any
+	h := Middleware(upstream(t, &got, `{"response":"The sky"}
+{"response":" is blue."}
+{"response":"","done":true,"context":[1,2,3],"eval_count":4}
+`))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(`{"model":"llama3","prompt":"sky?","stream":false}`)))
+
+	var resp map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("%v: %s", err, rec.Body)
+	}
+	if resp["response"] != "The sky is blue." || resp["thinking"] != nil || len(resp["context"].([]any)) != 3 {
+		t.Errorf("response %s", rec.Body)
+	}
+}
+
+func TestStreamError(t *testing.T) {
+	var got map[string]any
+	h := Middleware(upstream(t, &got, "{\"response\":\"The\"}\n{\"error\":\"model runner crashed\"}\n"))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(`{"model":"llama3","stream":false}`)))
+	if rec.Code != http.StatusBadGateway || !strings.Contains(rec.Body.String(), "model runner crashed") {
+		t.Errorf("%d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestStreamingRequestsUntouched(t *testing.T) {
+	for _, body := range []string{`{"model":"llama3"}`, `{"model":"llama3","stream":true}`} {
+		var got map[string]any
+		h := Middleware(upstream(t, &got, "{\"response\":\"a\"}\n{\"done\":true}\n"))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(body)))
+		if rec.Body.String() != "{\"response\":\"a\"}\n{\"done\":true}\n" {
+			t.Errorf("%s: %s", body, rec.Body)
+		}
+	}
+}
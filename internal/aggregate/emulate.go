@@ -0,0 +1,125 @@
+package aggregate
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"net/http"
+
+	"github.com/yeti47/ollama-proxy/internal/apierror"
+	"github.com/yeti47/ollama-proxy/internal/metrics"
+	"github.com/yeti47/ollama-proxy/internal/ollama"
+)
+
+var emulated = metrics.NewCounter("ollama_proxy_emulated_streams_total",
+	"Streaming requests answered by splitting a complete upstream response into chunks, by endpoint.", "endpoint")
+
+// Emulate is the converse of Middleware: when a streaming /api/chat or
+// /api/generate request gets a complete JSON response, for example from
+// an upstream that ignores "stream", the response is sent as a
+// well-formed NDJSON stream so streaming-only clients don't break.
+func Emulate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || (r.URL.Path != "/api/chat" && r.URL.Path != "/api/generate") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		body, err := ollama.PeekBody(r)
+		if err != nil {
+			apierror.BodyError(w, err)
+			return
+		}
+		var req struct {
+			Stream *bool `json:"stream"`
+		}
+		if json.Unmarshal(body, &req) != nil || (req.Stream != nil && !*req.Stream) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ew := &emulateWriter{ResponseWriter: w}
+		next.ServeHTTP(ew, r)
+		if !ew.buffering {
+			return
+		}
+		emulated.With(r.URL.Path).Inc()
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Del("Content-Length")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(split(ew.buf.Bytes(), r.URL.Path == "/api/chat"))
+	})
+}
+
+// emulateWriter passes a response through unless it is a complete JSON
+// result, which it holds back for splitting.
+type emulateWriter struct {
+	http.ResponseWriter
+	wrote     bool
+	buffering bool
+	buf       bytes.Buffer
+}
+
+func (w *emulateWriter) WriteHeader(code int) {
+	if w.wrote {
+		return
+	}
+	w.wrote = true
+	mt, _, _ := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	if code == http.StatusOK && mt == "application/json" {
+		w.buffering = true
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *emulateWriter) Write(b []byte) (int, error) {
+	if !w.wrote {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.buffering {
+		return w.buf.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *emulateWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok && !w.buffering {
+		f.Flush()
+	}
+}
+
+func (w *emulateWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+// split turns a complete response into the chunks Ollama would have
+// streamed: one carrying the generated text, thinking and tool calls,
+// then the final "done" chunk with everything else. A body that isn't a
+// JSON object is sent as the only line.
+func split(body []byte, chat bool) []byte {
+	var final map[string]json.RawMessage
+	if json.Unmarshal(body, &final) != nil {
+		return append(bytes.TrimSpace(body), '\n')
+	}
+	first := map[string]json.RawMessage{"done": json.RawMessage("false")}
+	for _, k := range []string{"model", "created_at"} {
+		if v, ok := final[k]; ok {
+			first[k] = v
+		}
+	}
+	if chat {
+		var m map[string]json.RawMessage
+		_ = json.Unmarshal(final["message"], &m)
+		rest := map[string]json.RawMessage{"role": m["role"], "content": json.RawMessage(`""`)}
+		first["message"], _ = json.Marshal(m)
+		final["message"], _ = json.Marshal(rest)
+	} else {
+		first["response"] = final["response"]
+		final["response"] = json.RawMessage(`""`)
+		if v, ok := final["thinking"]; ok {
+			first["thinking"] = v
+			delete(final, "thinking")
+		}
+	}
+	final["done"] = json.RawMessage("true")
+	a, _ := json.Marshal(first)
+	b, _ := json.Marshal(final)
+	return append(append(append(a, '\n'), b...), '\n')
+}
@@ -0,0 +1,76 @@
+package aggregate
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func complete(contentType, body string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Length", "999")
+		_, _ = io.WriteString(w, body)
+	})
+}
+
+func TestEmulateChat(t *testing.T) {
+	h := Emulate(complete("application/json; charset=utf-8",
+		`{"model":"llama3","created_at":"2026-10-16T09:00:00Z","message":{"role":"assistant","content":"Hello","tool_calls":[{"function":{"name":"f","arguments":{}}}]},"done":true,"done_reason":"stop","eval_count":2}`))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/chat", strings.NewReader(`{"model":"llama3","messages":[]}`)))
+
+	if ct := rec.Header().Get("Content-Type"); ct != "application/x-ndjson" || rec.Header().Get("Content-Length") != "" {
+		t.Errorf("headers %v", rec.Header())
+	}
+	lines := strings.Split(strings.TrimSuffix(rec.Body.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("lines %q", lines)
+	}
+	var first, last struct {
+		Model   string `json:"model"`
+		Message struct {
+			Content   string `json:"content"`
+			ToolCalls []any  `json:"tool_calls"`
+		} `json:"message"`
+		Done       bool   `json:"done"`
+		DoneReason string `json:"done_reason"`
+	}
+	_ = json.Unmarshal([]byte(lines[0]), &first)
+	_ = json.Unmarshal([]byte(lines[1]), &last)
+	if first.Done || first.Model != "llama3" || first.Message.Content != "Hello" || len(first.Message.ToolCalls) != 1 {
+		t.Errorf("first chunk %s", lines[0])
+	}
+	if !last.Done || last.DoneReason != "stop" || last.Message.Content != "" || last.Message.ToolCalls != nil {
+		t.Errorf("final chunk %s", lines[1])
+	}
+}
+
+func TestEmulateGenerate(t *testing.T) {
+	h := Emulate(complete("application/json", `{"model":"llama3","response":"Blue.","thinking":"sky","done":true,"context":[1]}`))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(`{"model":"llama3","stream":true}`)))
+
+	want := `{"done":false,"model":"llama3","response":"Blue.","thinking":"sky"}` + "\n" +
+		`{"context":[1],"done":true,"model":"llama3","response":""}` + "\n"
+	if rec.Body.String() != want {
+		t.Errorf("got %s\nwant %s", rec.Body, want)
+	}
+}
+
+func TestEmulatePassThrough(t *testing.T) {
+	for _, tc := range []struct{ req, contentType string }{
+		{`{"model":"llama3","stream":false}`, "application/json"},
+		{`{"model":"llama3"}`, "application/x-ndjson"},
+	} {
+		h := Emulate(complete(tc.contentType, `{"response":"x","done":true}`))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(tc.req)))
+		if rec.Body.String() != `{"response":"x","done":true}` || rec.Header().Get("Content-Type") != tc.contentType {
+			t.Errorf("%+v: %v %s", tc, rec.Header(), rec.Body)
+		}
+	}
+}
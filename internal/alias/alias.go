@@ -0,0 +1,222 @@
+// Package alias lets clients ask for models by friendly names such as
+// "fast" or "smart". Requests are forwarded with the concrete model tag
+// the name stands for; responses and /api/tags listings show the name
+// again, so clients never see the mapping change underneath them.
+package alias
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/yeti47/ollama-proxy/internal/apierror"
+	"github.com/yeti47/ollama-proxy/internal/metrics"
+	"github.com/yeti47/ollama-proxy/internal/ndjson"
+	"github.com/yeti47/ollama-proxy/internal/ollama"
+)
+
+var resolved = metrics.NewCounter("ollama_proxy_model_aliases_total",
+	"Requests whose model was an alias, by alias.", "alias")
+
+// modelPaths are the endpoints whose request names a model.
+var modelPaths = map[string]bool{
+	"/api/chat":       true,
+	"/api/generate":   true,
+	"/api/embed":      true,
+	"/api/embeddings": true,
+	"/api/show":       true,
+}
+
+// Load reads a mapping file: a JSON object of alias names to model tags.
+func Load(path string) (map[string]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]string
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for name, model := range m {
+		if name == "" || model == "" {
+			return nil, fmt.Errorf("%s: empty alias or model", path)
+		}
+	}
+	return m, nil
+}
+
+// Map resolves aliases. It is safe for concurrent use, and Set can swap
+// the mapping while requests are in flight.
+type Map struct {
+	mu sync.RWMutex
+	// targets holds the model of each alias, by its normalized name
+	targets map[string]string
+}
+
+// New returns a Map with the given aliases.
+func New(aliases map[string]string) *Map {
+	m := &Map{}
+	m.Set(aliases)
+	return m
+}
+
+// Set replaces the mapping.
+func (m *Map) Set(aliases map[string]string) {
+	targets := map[string]string{}
+	for name, model := range aliases {
+		targets[ollama.NormalizeModel(name)] = model
+	}
+	m.mu.Lock()
+	m.targets = targets
+	m.mu.Unlock()
+}
+
+// Len reports the number of aliases.
+func (m *Map) Len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.targets)
+}
+
+// Resolve returns the model an alias stands for.
+func (m *Map) Resolve(model string) (string, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	target, ok := m.targets[ollama.NormalizeModel(model)]
+	return target, ok
+}
+
+// listed returns the aliases by the normalized model they stand for.
+func (m *Map) listed() map[string][]string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	out := map[string][]string{}
+	for n, target := range m.targets {
+		t := ollama.NormalizeModel(target)
+		out[t] = append(out[t], n)
+	}
+	for _, names := range out {
+		sort.Strings(names)
+	}
+	return out
+}
+
+// Middleware resolves aliases in requests to next and restores them in
+// its responses.
+func (m *Map) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && r.URL.Path == "/api/tags" {
+			// the listing is rewritten here, so it must arrive uncompressed
+			r.Header.Del("Accept-Encoding")
+			aw := ndjson.NewWriter(w, func(b []byte, _ bool) []byte { return m.tags(b) })
+			next.ServeHTTP(aw, r)
+			aw.Finish()
+			return
+		}
+		if r.Method != http.MethodPost || !modelPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		body, err := ollama.PeekBody(r)
+		if err != nil {
+			apierror.BodyError(w, err)
+			return
+		}
+		var req map[string]json.RawMessage
+		if json.Unmarshal(body, &req) != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// /api/show also takes the older "name"
+		field := "model"
+		if _, ok := req[field]; !ok {
+			field = "name"
+		}
+		var name string
+		if json.Unmarshal(req[field], &name) != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		target, ok := m.Resolve(name)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		req[field], _ = json.Marshal(target)
+		b, err := marshal(req)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ollama.ReplaceBody(r, b)
+		resolved.With(name).Inc()
+
+		// Ollama writes compact JSON and echoes the model as requested, so
+		// the field can be swapped back without decoding every chunk
+		from, _ := json.Marshal(target)
+		to, _ := json.Marshal(name)
+		from, to = append([]byte(`"model":`), from...), append([]byte(`"model":`), to...)
+		r.Header.Del("Accept-Encoding")
+		aw := ndjson.NewWriter(w, func(b []byte, _ bool) []byte {
+			return bytes.Replace(b, from, to, 1)
+		})
+		next.ServeHTTP(aw, r)
+		aw.Finish()
+	})
+}
+
+// tags adds an entry for each alias whose model the upstream has.
+func (m *Map) tags(b []byte) []byte {
+	var doc map[string]json.RawMessage
+	if json.Unmarshal(b, &doc) != nil {
+		return b
+	}
+	var models []map[string]json.RawMessage
+	if json.Unmarshal(doc["models"], &models) != nil {
+		return b
+	}
+	aliases := m.listed()
+	if len(aliases) == 0 {
+		return b
+	}
+	var added []map[string]json.RawMessage
+	for _, model := range models {
+		var name string
+		if json.Unmarshal(model["name"], &name) != nil {
+			_ = json.Unmarshal(model["model"], &name)
+		}
+		for _, a := range aliases[ollama.NormalizeModel(name)] {
+			entry := make(map[string]json.RawMessage, len(model))
+			for k, v := range model {
+				entry[k] = v
+			}
+			quoted, _ := json.Marshal(a)
+			entry["name"], entry["model"] = quoted, quoted
+			added = append(added, entry)
+		}
+	}
+	if len(added) == 0 {
+		return b
+	}
+	doc["models"], _ = marshal(append(added, models...))
+	out, err := marshal(doc)
+	if err != nil {
+		return b
+	}
+	return out
+}
+
+// marshal encodes v without escaping <, > and &.
+func marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
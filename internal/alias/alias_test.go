@@ -0,0 +1,123 @@
+package alias
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "aliases.json")
+	if err := os.WriteFile(p, []byte(`{"fast":"llama3.2:3b","smart":"qwen3:32b"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	m, err := Load(p)
+	if err != nil || len(m) != 2 {
+		t.Fatalf("got %v, %v", m, err)
+	}
+	if err := os.WriteFile(p, []byte(`{"fast":""}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(p); err == nil {
+		t.Error("empty model accepted")
+	}
+}
+
+func TestResolve(t *testing.T) {
+	m := New(map[string]string{"fast": "llama3.2:3b"})
+	for _, c := range []struct {
+		model, want string
+		ok          bool
+	}{
+		{"fast", "llama3.2:3b", true},
+		{"fast:latest", "llama3.2:3b", true},
+		{"fast:v2", "", false},
+		{"llama3.2:3b", "", false},
+	} {
+		got, ok := m.Resolve(c.model)
+		if got != c.want || ok != c.ok {
+			t.Errorf("%s: got %q, %v", c.model, got, ok)
+		}
+	}
+	m.Set(nil)
+	if m.Len() != 0 {
+		t.Error("Set did not replace the mapping")
+	}
+}
+
+func TestChat(t *testing.T) {
+	var sent string
+	h := New(map[string]string{"fast": "llama3.2:3b"}).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct{ Model string }
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		sent = req.Model
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		_, _ = io.WriteString(w, `{"model":"`+req.Model+`","message":{"content":"Hi"},"done":false}`+"\n")
+		_, _ = io.WriteString(w, `{"model":"`+req.Model+`","done":true}`+"\n")
+	}))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/chat", strings.NewReader(`{"model":"fast","messages":[]}`)))
+	if sent != "llama3.2:3b" {
+		t.Errorf("forwarded model %q", sent)
+	}
+	want := `{"model":"fast","message":{"content":"Hi"},"done":false}` + "\n" + `{"model":"fast","done":true}` + "\n"
+	if rec.Body.String() != want {
+		t.Errorf("got %q", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/chat", strings.NewReader(`{"model":"qwen3","messages":[]}`)))
+	if sent != "qwen3" || !strings.Contains(rec.Body.String(), `"model":"qwen3"`) {
+		t.Errorf("other model changed: %q, %q", sent, rec.Body.String())
+	}
+}
+
+func TestShow(t *testing.T) {
+	var sent string
+	h := New(map[string]string{"smart": "qwen3:32b"}).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct{ Name string }
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		sent = req.Name
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"details":{"family":"qwen3"}}`)
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/show", strings.NewReader(`{"name":"smart"}`)))
+	if sent != "qwen3:32b" || rec.Code != http.StatusOK {
+		t.Errorf("forwarded %q, status %d", sent, rec.Code)
+	}
+	if rec.Header().Get("Content-Length") != "30" {
+		t.Errorf("Content-Length %q", rec.Header().Get("Content-Length"))
+	}
+}
+
+func TestTags(t *testing.T) {
+	h := New(map[string]string{"fast": "llama3.2:3b", "missing": "gone:1b"}).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"models":[{"name":"llama3.2:3b","model":"llama3.2:3b","size":2019393189},{"name":"qwen3:32b","model":"qwen3:32b"}]}`)
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/tags", nil))
+	var list struct {
+		Models []struct {
+			Name, Model string
+			Size        int64
+		}
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Models) != 3 {
+		t.Fatalf("got %+v", list.Models)
+	}
+	if a := list.Models[0]; a.Name != "fast:latest" || a.Model != "fast:latest" || a.Size != 2019393189 {
+		t.Errorf("alias entry %+v", a)
+	}
+}
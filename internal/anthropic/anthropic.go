@@ -0,0 +1,417 @@
+// Package anthropic translates Anthropic Messages API requests to Ollama's
+// /api/chat and the responses back, so clients that only speak to Claude
+// can use Ollama models through the proxy.
+package anthropic
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/yeti47/ollama-proxy/internal/ollama"
+	"github.com/yeti47/ollama-proxy/internal/translate"
+)
+
+// Middleware serves POST /v1/messages through next's /api/chat. Other
+// requests pass through untouched.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/messages" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		body, err := ollama.PeekBody(r)
+		if err != nil {
+			translate.BodyError(w, err, writeError)
+			return
+		}
+		var req messagesRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+			return
+		}
+		b, err := toOllamaChat(&req)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		// an Anthropic SDK always sends a key; it means nothing upstream
+		r.Header.Del("X-Api-Key")
+		m := &message{id: translate.NewID("msg_"), model: req.Model}
+		var line func([]byte)
+		if req.Stream {
+			line = func(b []byte) { m.chunk(w, b) }
+		}
+		t := translate.NewWriter(w, line, writeError)
+		translate.Forward(t, r, next, r.URL.Path, "/api/chat", b)
+		t.Finish(func(body []byte) { m.result(w, body) })
+	})
+}
+
+// errorType maps a status code to Anthropic's error types.
+func errorType(status int) string {
+	switch status {
+	case http.StatusUnauthorized:
+		return "authentication_error"
+	case http.StatusForbidden:
+		return "permission_error"
+	case http.StatusNotFound:
+		return "not_found_error"
+	case http.StatusRequestEntityTooLarge:
+		return "request_too_large"
+	case http.StatusTooManyRequests:
+		return "rate_limit_error"
+	case http.StatusServiceUnavailable:
+		return "overloaded_error"
+	}
+	if status >= 500 {
+		return "api_error"
+	}
+	return "invalid_request_error"
+}
+
+func errorBody(status int, msg string) []byte {
+	b, _ := json.Marshal(map[string]any{"type": "error", "error": map[string]string{"type": errorType(status), "message": msg}})
+	return b
+}
+
+// writeError sends an error in Anthropic's format.
+func writeError(w http.ResponseWriter, status int, msg string) {
+	b := errorBody(status, msg)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+	w.WriteHeader(status)
+	_, _ = w.Write(b)
+}
+
+type messagesRequest struct {
+	Model         string          `json:"model"`
+	MaxTokens     int             `json:"max_tokens"`
+	System        json.RawMessage `json:"system"` // a string or an array of text blocks
+	Messages      []inputMessage  `json:"messages"`
+	Stream        bool            `json:"stream"`
+	Temperature   *float64        `json:"temperature"`
+	TopP          *float64        `json:"top_p"`
+	TopK          *int            `json:"top_k"`
+	StopSequences []string        `json:"stop_sequences"`
+	Tools         []struct {
+		Name        string          `json:"name"`
+		Description string          `json:"description"`
+		InputSchema json.RawMessage `json:"input_schema"`
+	} `json:"tools"`
+}
+
+type inputMessage struct {
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"` // a string or an array of blocks
+}
+
+// block is a content block of any type; the fields used depend on Type.
+type block struct {
+	Type      string          `json:"type"`
+	Text      string          `json:"text"`
+	Source    *imageSource    `json:"source"`
+	ID        string          `json:"id"`
+	Name      string          `json:"name"`
+	Input     json.RawMessage `json:"input"`
+	ToolUseID string          `json:"tool_use_id"`
+	Content   json.RawMessage `json:"content"` // tool_result: a string or text blocks
+	IsError   bool            `json:"is_error"`
+}
+
+type imageSource struct {
+	Type string `json:"type"`
+	Data string `json:"data"`
+}
+
+type ollamaMessage struct {
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	Images    []string         `json:"images,omitempty"`
+	ToolCalls []ollamaToolCall `json:"tool_calls,omitempty"`
+	ToolName  string           `json:"tool_name,omitempty"`
+}
+
+type ollamaToolCall struct {
+	Function struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	} `json:"function"`
+}
+
+// blocks decodes message content, which is a plain string or an array of
+// blocks.
+func blocks(raw json.RawMessage) ([]block, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	if raw[0] == '"' {
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return nil, err
+		}
+		return []block{{Type: "text", Text: s}}, nil
+	}
+	var bs []block
+	if err := json.Unmarshal(raw, &bs); err != nil {
+		return nil, fmt.Errorf("content must be a string or an array of content blocks")
+	}
+	return bs, nil
+}
+
+// text joins the text blocks of content.
+func text(raw json.RawMessage) (string, error) {
+	bs, err := blocks(raw)
+	if err != nil {
+		return "", err
+	}
+	var parts []string
+	for _, b := range bs {
+		if b.Type == "text" {
+			parts = append(parts, b.Text)
+		}
+	}
+	return strings.Join(parts, "\n"), nil
+}
+
+// toOllamaChat converts a Messages API request to the body of an
+// /api/chat request. Tool results, which Anthropic puts in user messages,
+// become messages of their own with the "tool" role.
+func toOllamaChat(req *messagesRequest) ([]byte, error) {
+	if req.Model == "" {
+		return nil, fmt.Errorf("model: field required")
+	}
+	if req.MaxTokens <= 0 {
+		return nil, fmt.Errorf("max_tokens: must be greater than 0")
+	}
+	if len(req.Messages) == 0 {
+		return nil, fmt.Errorf("messages: at least one message is required")
+	}
+	var msgs []ollamaMessage
+	system, err := text(req.System)
+	if err != nil {
+		return nil, fmt.Errorf("system: %v", err)
+	}
+	if system != "" {
+		msgs = append(msgs, ollamaMessage{Role: "system", Content: system})
+	}
+	toolNames := map[string]string{} // tool_use ID -> tool name
+	for i, m := range req.Messages {
+		if m.Role != "user" && m.Role != "assistant" {
+			return nil, fmt.Errorf("messages.%d.role: must be user or assistant", i)
+		}
+		bs, err := blocks(m.Content)
+		if err != nil {
+			return nil, fmt.Errorf("messages.%d.content: %v", i, err)
+		}
+		om := ollamaMessage{Role: m.Role}
+		var texts []string
+		for _, b := range bs {
+			switch b.Type {
+			case "text":
+				texts = append(texts, b.Text)
+			case "image":
+				if b.Source == nil || b.Source.Type != "base64" {
+					return nil, fmt.Errorf("messages.%d.content: only base64 image sources are supported", i)
+				}
+				om.Images = append(om.Images, b.Source.Data)
+			case "tool_use":
+				var call ollamaToolCall
+				call.Function.Name = b.Name
+				call.Function.Arguments = b.Input
+				if len(call.Function.Arguments) == 0 {
+					call.Function.Arguments = json.RawMessage("{}")
+				}
+				om.ToolCalls = append(om.ToolCalls, call)
+				toolNames[b.ID] = b.Name
+			case "tool_result":
+				result, err := text(b.Content)
+				if err != nil {
+					return nil, fmt.Errorf("messages.%d.content: %v", i, err)
+				}
+				if b.IsError {
+					result = "Error: " + result
+				}
+				msgs = append(msgs, ollamaMessage{Role: "tool", Content: result, ToolName: toolNames[b.ToolUseID]})
+			case "thinking", "redacted_thinking":
+				// earlier reasoning is not sent back to the model
+			default:
+				return nil, fmt.Errorf("messages.%d.content: unsupported block type %q", i, b.Type)
+			}
+		}
+		om.Content = strings.Join(texts, "\n")
+		if om.Content != "" || om.Images != nil || om.ToolCalls != nil {
+			msgs = append(msgs, om)
+		}
+	}
+
+	opts := map[string]any{"num_predict": req.MaxTokens}
+	if req.Temperature != nil {
+		opts["temperature"] = *req.Temperature
+	}
+	if req.TopP != nil {
+		opts["top_p"] = *req.TopP
+	}
+	if req.TopK != nil {
+		opts["top_k"] = *req.TopK
+	}
+	if len(req.StopSequences) > 0 {
+		opts["stop"] = req.StopSequences
+	}
+	out := map[string]any{"model": req.Model, "messages": msgs, "stream": req.Stream, "options": opts}
+	if len(req.Tools) > 0 {
+		tools := make([]map[string]any, len(req.Tools))
+		for i, t := range req.Tools {
+			tools[i] = map[string]any{"type": "function", "function": map[string]any{
+				"name": t.Name, "description": t.Description, "parameters": t.InputSchema,
+			}}
+		}
+		out["tools"] = tools
+	}
+	return json.Marshal(out)
+}
+
+type ollamaChatResponse struct {
+	Message         ollamaMessage `json:"message"`
+	Done            bool          `json:"done"`
+	DoneReason      string        `json:"done_reason"`
+	PromptEvalCount int           `json:"prompt_eval_count"`
+	EvalCount       int           `json:"eval_count"`
+	Error           string        `json:"error"`
+}
+
+// message tracks one translated response.
+type message struct {
+	id    string
+	model string
+
+	// streaming state
+	started   bool
+	open      bool // a text block is open at index blocks-1
+	blocks    int
+	toolCalls bool
+}
+
+func (m *message) stopReason(doneReason string) string {
+	switch {
+	case m.toolCalls:
+		return "tool_use"
+	case doneReason == "length":
+		return "max_tokens"
+	}
+	return "end_turn"
+}
+
+func toolUse(tc ollamaToolCall) map[string]any {
+	input := tc.Function.Arguments
+	if len(input) == 0 {
+		input = json.RawMessage("{}")
+	}
+	return map[string]any{"type": "tool_use", "id": translate.NewID("toolu_"), "name": tc.Function.Name, "input": input}
+}
+
+// result converts a non-streamed /api/chat response.
+func (m *message) result(w http.ResponseWriter, body []byte) {
+	var r ollamaChatResponse
+	if err := json.Unmarshal(body, &r); err != nil {
+		writeError(w, http.StatusBadGateway, "upstream sent an invalid chat response")
+		return
+	}
+	if r.Error != "" {
+		writeError(w, http.StatusBadGateway, r.Error)
+		return
+	}
+	content := []map[string]any{}
+	if r.Message.Content != "" {
+		content = append(content, map[string]any{"type": "text", "text": r.Message.Content})
+	}
+	for _, tc := range r.Message.ToolCalls {
+		content = append(content, toolUse(tc))
+		m.toolCalls = true
+	}
+	b, _ := json.Marshal(map[string]any{
+		"id":            m.id,
+		"type":          "message",
+		"role":          "assistant",
+		"model":         m.model,
+		"content":       content,
+		"stop_reason":   m.stopReason(r.DoneReason),
+		"stop_sequence": nil,
+		"usage":         map[string]int{"input_tokens": r.PromptEvalCount, "output_tokens": r.EvalCount},
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(b)
+}
+
+// event writes one server-sent event.
+func event(w http.ResponseWriter, name string, data any) {
+	b, _ := json.Marshal(data)
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, b)
+}
+
+// closeText ends the open text block, if any.
+func (m *message) closeText(w http.ResponseWriter) {
+	if m.open {
+		event(w, "content_block_stop", map[string]any{"type": "content_block_stop", "index": m.blocks - 1})
+		m.open = false
+	}
+}
+
+// chunk converts one line of a streamed /api/chat response into events:
+// text goes into a text block, each tool call into a tool_use block of
+// its own.
+func (m *message) chunk(w http.ResponseWriter, line []byte) {
+	var r ollamaChatResponse
+	if len(strings.TrimSpace(string(line))) == 0 {
+		return
+	}
+	if err := json.Unmarshal(line, &r); err != nil || r.Error != "" {
+		msg := r.Error
+		if msg == "" {
+			msg = "upstream sent an invalid chat chunk"
+		}
+		event(w, "error", json.RawMessage(errorBody(http.StatusInternalServerError, msg)))
+		return
+	}
+	if !m.started {
+		m.started = true
+		event(w, "message_start", map[string]any{"type": "message_start", "message": map[string]any{
+			"id": m.id, "type": "message", "role": "assistant", "model": m.model, "content": []any{},
+			"stop_reason": nil, "stop_sequence": nil, "usage": map[string]int{"input_tokens": 0, "output_tokens": 0},
+		}})
+	}
+	if r.Message.Content != "" {
+		if !m.open {
+			event(w, "content_block_start", map[string]any{"type": "content_block_start", "index": m.blocks,
+				"content_block": map[string]string{"type": "text", "text": ""}})
+			m.open = true
+			m.blocks++
+		}
+		event(w, "content_block_delta", map[string]any{"type": "content_block_delta", "index": m.blocks - 1,
+			"delta": map[string]string{"type": "text_delta", "text": r.Message.Content}})
+	}
+	for _, tc := range r.Message.ToolCalls {
+		m.closeText(w)
+		block := toolUse(tc)
+		input := block["input"]
+		block["input"] = map[string]any{}
+		event(w, "content_block_start", map[string]any{"type": "content_block_start", "index": m.blocks, "content_block": block})
+		b, _ := json.Marshal(input)
+		event(w, "content_block_delta", map[string]any{"type": "content_block_delta", "index": m.blocks,
+			"delta": map[string]string{"type": "input_json_delta", "partial_json": string(b)}})
+		event(w, "content_block_stop", map[string]any{"type": "content_block_stop", "index": m.blocks})
+		m.blocks++
+		m.toolCalls = true
+	}
+	if !r.Done {
+		return
+	}
+	m.closeText(w)
+	event(w, "message_delta", map[string]any{"type": "message_delta",
+		"delta": map[string]any{"stop_reason": m.stopReason(r.DoneReason), "stop_sequence": nil},
+		"usage": map[string]int{"input_tokens": r.PromptEvalCount, "output_tokens": r.EvalCount}})
+	event(w, "message_stop", map[string]string{"type": "message_stop"})
+}
@@ -0,0 +1,143 @@
+package anthropic
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func upstream(t *testing.T, got *map[string]any, status int, body string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/chat" || r.Header.Get("X-Api-Key") != "" {
+			t.Errorf("forwarded to %s with X-Api-Key %q", r.URL.Path, r.Header.Get("X-Api-Key"))
+		}
+		b, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(b, got); err != nil {
+			t.Errorf("forwarded body %s: %v", b, err)
+		}
+		w.WriteHeader(status)
+		_, _ = io.WriteString(w, body)
+	})
+}
+
+func TestMessages(t *testing.T) {
+	var got map[string]any
+	h := Middleware(upstream(t, &got, http.StatusOK,
+		`{"message":{"role":"assistant","content":"It is sunny."},"done":true,"done_reason":"stop","prompt_eval_count":20,"eval_count":4}`))
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(`{
+		"model": "llama3", "max_tokens": 100, "temperature": 0.5, "stop_sequences": ["\n\nHuman:"],
+		"system": [{"type": "text", "text": "You are terse."}],
+		"tools": [{"name": "get_weather", "input_schema": {"type": "object"}}],
+		"messages": [
+			{"role": "user", "content": "Weather in Oslo?"},
+			{"role": "assistant", "content": [{"type": "tool_use", "id": "toolu_1", "name": "get_weather", "input": {"city": "Oslo"}}]},
+			{"role": "user", "content": [{"type": "tool_result", "tool_use_id": "toolu_1", "content": "sunny"},
+				{"type": "text", "text": "Summarise."}]}
+		]}`))
+	req.Header.Set("X-Api-Key", "sk-ant-dummy")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	msgs := got["messages"].([]any)
+	role := func(i int) string { return msgs[i].(map[string]any)["role"].(string) }
+	if len(msgs) != 5 || role(0) != "system" || role(2) != "assistant" || role(3) != "tool" || role(4) != "user" {
+		t.Fatalf("translated messages %v", msgs)
+	}
+	if msgs[3].(map[string]any)["tool_name"] != "get_weather" || got["options"].(map[string]any)["num_predict"] != 100.0 {
+		t.Errorf("translated request %v", got)
+	}
+	tool := got["tools"].([]any)[0].(map[string]any)["function"].(map[string]any)
+	if tool["name"] != "get_weather" || tool["parameters"] == nil {
+		t.Errorf("translated tools %v", got["tools"])
+	}
+
+	var resp struct {
+		ID         string `json:"id"`
+		Type       string `json:"type"`
+		StopReason string `json:"stop_reason"`
+		Content    []struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		} `json:"content"`
+		Usage struct {
+			InputTokens  int `json:"input_tokens"`
+			OutputTokens int `json:"output_tokens"`
+		} `json:"usage"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("%v: %s", err, rec.Body)
+	}
+	if !strings.HasPrefix(resp.ID, "msg_") || resp.Type != "message" || resp.StopReason != "end_turn" ||
+		resp.Content[0].Text != "It is sunny." || resp.Usage.InputTokens != 20 || resp.Usage.OutputTokens != 4 {
+		t.Errorf("response %s", rec.Body)
+	}
+}
+
+func TestMessagesStream(t *testing.T) {
+	var got map[string]any
+	h := Middleware(upstream(t, &got, http.StatusOK, strings.Join([]string{
+		`{"message":{"role":"assistant","content":"Let me"},"done":false}`,
+		`{"message":{"role":"assistant","content":" check."},"done":false}`,
+		`{"message":{"role":"assistant","content":"","tool_calls":[{"function":{"name":"get_weather","arguments":{"city":"Oslo"}}}]},"done":false}`,
+		`{"message":{"role":"assistant","content":""},"done":true,"done_reason":"stop","prompt_eval_count":8,"eval_count":6}`,
+	}, "\n")))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(
+		`{"model":"llama3","max_tokens":64,"stream":true,"messages":[{"role":"user","content":"hi"}]}`)))
+
+	if ct := rec.Header().Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type %q", ct)
+	}
+	var names []string
+	var last map[string]any
+	for _, ev := range strings.Split(strings.TrimSpace(rec.Body.String()), "\n\n") {
+		name, data, _ := strings.Cut(ev, "\n")
+		names = append(names, strings.TrimPrefix(name, "event: "))
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(data, "data: ")), &last); err != nil {
+			t.Fatalf("%q: %v", ev, err)
+		}
+		if last["type"] != names[len(names)-1] {
+			t.Errorf("event %s has type %v", names[len(names)-1], last["type"])
+		}
+		if last["type"] == "message_delta" {
+			if d := last["delta"].(map[string]any); d["stop_reason"] != "tool_use" {
+				t.Errorf("message_delta %v", last)
+			}
+		}
+	}
+	want := "message_start content_block_start content_block_delta content_block_delta content_block_stop " +
+		"content_block_start content_block_delta content_block_stop message_delta message_stop"
+	if strings.Join(names, " ") != want {
+		t.Errorf("events %v", names)
+	}
+	if !strings.Contains(rec.Body.String(), `"partial_json":"{\"city\":\"Oslo\"}"`) {
+		t.Errorf("tool input missing from %s", rec.Body)
+	}
+}
+
+func TestMessagesErrors(t *testing.T) {
+	var got map[string]any
+	h := Middleware(upstream(t, &got, http.StatusTooManyRequests, `{"error":"rate limit exceeded"}`))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(
+		`{"model":"llama3","max_tokens":10,"messages":[{"role":"user","content":"hi"}]}`)))
+	if rec.Code != http.StatusTooManyRequests ||
+		rec.Body.String() != `{"error":{"message":"rate limit exceeded","type":"rate_limit_error"},"type":"error"}` {
+		t.Errorf("upstream error: %d %s", rec.Code, rec.Body)
+	}
+
+	for _, body := range []string{
+		`{"model":"llama3","messages":[{"role":"user","content":"hi"}]}`,
+		`{"model":"llama3","max_tokens":10,"messages":[{"role":"system","content":"hi"}]}`,
+		`{"model":"llama3","max_tokens":10,"messages":[{"role":"user","content":[{"type":"image","source":{"type":"url","url":"http://x"}}]}]}`,
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body)))
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), `"invalid_request_error"`) {
+			t.Errorf("%s: %d %s", body, rec.Code, rec.Body)
+		}
+	}
+}
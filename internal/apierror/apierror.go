@@ -0,0 +1,30 @@
+package apierror
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+// Write sends an Ollama-style JSON error body ({"error": msg}) with the
+// given status code. Clients of the Ollama API already know how to surface
+// this shape, so the proxy uses it for every error it generates itself.
+func Write(w http.ResponseWriter, status int, msg string) {
+	b, _ := json.Marshal(map[string]string{"error": msg})
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+	w.WriteHeader(status)
+	_, _ = w.Write(b)
+}
+
+// BodyError reports a failure to read the request body: 413 if the body
+// was larger than the configured limit, 400 otherwise.
+func BodyError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		Write(w, http.StatusRequestEntityTooLarge, "request body too large (limit "+strconv.FormatInt(tooLarge.Limit, 10)+" bytes)")
+		return
+	}
+	Write(w, http.StatusBadRequest, "failed to read request body")
+}
@@ -0,0 +1,118 @@
+// Package attest signs requests the proxy forwards upstream, so a
+// self-hosted upstream can check that traffic really came through the
+// proxy.
+//
+// Three headers are attached:
+//
+//	X-Proxy-Attestation-Timestamp: <unix seconds>
+//	X-Proxy-Attestation-Digest:    sha256=<hex SHA-256 of the body>
+//	X-Proxy-Attestation:           sha256=<hex HMAC-SHA256>
+//
+// The HMAC is computed with the shared secret over
+//
+//	<timestamp>\n<METHOD>\n<request URI>\n<digest header value>
+//
+// An upstream verifies by recomputing the body digest and the HMAC and
+// checking that the timestamp is recent.
+package attest
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Header names of an attestation.
+const (
+	SignatureHeader = "X-Proxy-Attestation"
+	TimestampHeader = "X-Proxy-Attestation-Timestamp"
+	DigestHeader    = "X-Proxy-Attestation-Digest"
+)
+
+// Signature returns the signature header value for the given request
+// parts.
+func Signature(secret []byte, timestamp, method, requestURI, digest string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "\n" + method + "\n" + requestURI + "\n" + digest))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// digestBody reads and restores r's body and returns its digest header
+// value.
+func digestBody(r *http.Request) (string, error) {
+	h := sha256.New()
+	if r.Body != nil && r.Body != http.NoBody {
+		b, err := io.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			return "", err
+		}
+		h.Write(b)
+		r.Body = io.NopCloser(bytes.NewReader(b))
+	}
+	return "sha256=" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Sign attaches the attestation headers to r, replacing any sent by the
+// client. The body is buffered to compute its digest.
+func Sign(r *http.Request, secret []byte, now time.Time) error {
+	digest, err := digestBody(r)
+	if err != nil {
+		return err
+	}
+	ts := strconv.FormatInt(now.Unix(), 10)
+	r.Header.Set(TimestampHeader, ts)
+	r.Header.Set(DigestHeader, digest)
+	r.Header.Set(SignatureHeader, Signature(secret, ts, r.Method, r.URL.RequestURI(), digest))
+	return nil
+}
+
+// Verify checks the attestation on r as an upstream would, allowing maxSkew
+// between the signing time and now. The body is restored afterwards.
+func Verify(r *http.Request, secret []byte, maxSkew time.Duration, now time.Time) error {
+	ts := r.Header.Get(TimestampHeader)
+	sig := r.Header.Get(SignatureHeader)
+	if ts == "" || sig == "" {
+		return errors.New("missing attestation headers")
+	}
+	secs, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return errors.New("malformed timestamp")
+	}
+	if d := now.Sub(time.Unix(secs, 0)); d > maxSkew || d < -maxSkew {
+		return errors.New("timestamp outside allowed window")
+	}
+	digest, err := digestBody(r)
+	if err != nil {
+		return err
+	}
+	if digest != r.Header.Get(DigestHeader) {
+		return errors.New("body digest mismatch")
+	}
+	if !hmac.Equal([]byte(sig), []byte(Signature(secret, ts, r.Method, r.URL.RequestURI(), digest))) {
+		return errors.New("signature mismatch")
+	}
+	return nil
+}
+
+// Transport signs every request before passing it to Next.
+type Transport struct {
+	Secret []byte
+	Next   http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	// RoundTrippers must not modify the caller's request
+	r = r.Clone(r.Context())
+	if err := Sign(r, t.Secret, time.Now()); err != nil {
+		return nil, err
+	}
+	return t.Next.RoundTrip(r)
+}
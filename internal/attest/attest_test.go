@@ -0,0 +1,67 @@
+package attest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTransportSignsAndUpstreamVerifies(t *testing.T) {
+	secret := []byte("s3cret")
+	errs := make(chan error, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		errs <- Verify(r, secret, time.Minute, time.Now())
+	}))
+	defer upstream.Close()
+
+	client := &http.Client{Transport: &Transport{Secret: secret, Next: http.DefaultTransport}}
+	for _, body := range []string{`{"model":"llama3.2","prompt":"hi"}`, ""} {
+		req, _ := http.NewRequest("POST", upstream.URL+"/api/generate?x=1", strings.NewReader(body))
+		req.Header.Set(SignatureHeader, "sha256=forged")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if err := <-errs; err != nil {
+			t.Errorf("body %q: upstream rejected attestation: %v", body, err)
+		}
+		if req.Header.Get(SignatureHeader) != "sha256=forged" {
+			t.Error("caller's request was modified")
+		}
+	}
+}
+
+func TestVerifyRejectsTampering(t *testing.T) {
+	secret := []byte("s3cret")
+	now := time.Unix(1_800_000_000, 0)
+	signed := func() *http.Request {
+		r := httptest.NewRequest("POST", "/api/chat", strings.NewReader(`{"model":"a"}`))
+		if err := Sign(r, secret, now); err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+	if err := Verify(signed(), secret, time.Minute, now); err != nil {
+		t.Fatalf("valid attestation rejected: %v", err)
+	}
+
+	r := signed()
+	r.Body = http.NoBody
+	if Verify(r, secret, time.Minute, now) == nil {
+		t.Error("changed body accepted")
+	}
+	r = signed()
+	r.URL.Path = "/api/delete"
+	if Verify(r, secret, time.Minute, now) == nil {
+		t.Error("changed path accepted")
+	}
+	if Verify(signed(), []byte("other"), time.Minute, now) == nil {
+		t.Error("wrong secret accepted")
+	}
+	if Verify(signed(), secret, time.Minute, now.Add(2*time.Minute)) == nil {
+		t.Error("stale attestation accepted")
+	}
+}
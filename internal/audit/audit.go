@@ -0,0 +1,199 @@
+// Package audit writes an append-only, hash-chained log of security
+// relevant events: authentication failures, key usage, admin changes and
+// blocked requests.
+//
+// Each record is one JSON line carrying the SHA-256 hash of the previous
+// record, and its own hash covers that link. Editing, inserting or removing
+// a record breaks the chain from that point on, which Verify detects.
+// Truncating the tail of the file cannot be detected from the file alone;
+// keep a copy of the latest hash (it is logged at startup) or ship the log
+// elsewhere if that matters.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Event types recorded by the proxy.
+const (
+	AuthFailed  = "auth_failed"
+	KeyUsed     = "key_used"
+	AdminChange = "admin_change"
+	Blocked     = "blocked"
+)
+
+// genesis is the "previous hash" of the first record in a log.
+var genesis = strings.Repeat("0", 64)
+
+// Record is one audit log entry.
+type Record struct {
+	Seq    int64     `json:"seq"`
+	Time   time.Time `json:"time"`
+	Type   string    `json:"type"`
+	Remote string    `json:"remote,omitempty"`
+	Method string    `json:"method,omitempty"`
+	Path   string    `json:"path,omitempty"`
+	Client string    `json:"client,omitempty"`
+	Detail string    `json:"detail,omitempty"`
+	Prev   string    `json:"prev"`
+	Hash   string    `json:"hash"`
+}
+
+// sum returns the hash of rec, which covers every field except Hash.
+func (rec Record) sum() string {
+	rec.Hash = ""
+	b, _ := json.Marshal(rec)
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+// Log appends records to a file.
+type Log struct {
+	mu   sync.Mutex
+	f    *os.File
+	seq  int64
+	prev string
+}
+
+// Open opens or creates the audit log at path and continues the chain
+// from its last record. The existing records are not verified; use Verify
+// for that.
+func Open(path string) (*Log, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	l := &Log{f: f, prev: genesis}
+	last, err := lastLine(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if len(last) > 0 {
+		var rec Record
+		if err := json.Unmarshal(last, &rec); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("audit: last record in %s: %w", path, err)
+		}
+		l.seq, l.prev = rec.Seq, rec.Hash
+	}
+	return l, nil
+}
+
+// lastLine returns the last non-empty line of f.
+func lastLine(f *os.File) ([]byte, error) {
+	var last []byte
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1<<20)
+	for sc.Scan() {
+		if line := bytes.TrimSpace(sc.Bytes()); len(line) > 0 {
+			last = append(last[:0], line...)
+		}
+	}
+	return last, sc.Err()
+}
+
+// Head returns the sequence number and hash of the latest record.
+func (l *Log) Head() (int64, string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.seq, l.prev
+}
+
+// Write appends rec to the log, filling in Seq, Time (if zero), Prev and
+// Hash.
+func (l *Log) Write(rec Record) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	rec.Seq = l.seq + 1
+	if rec.Time.IsZero() {
+		rec.Time = time.Now()
+	}
+	rec.Time = rec.Time.UTC()
+	rec.Prev = l.prev
+	rec.Hash = rec.sum()
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := l.f.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	l.seq, l.prev = rec.Seq, rec.Hash
+	return nil
+}
+
+// Close closes the underlying file.
+func (l *Log) Close() error {
+	return l.f.Close()
+}
+
+// Verify reads a log and checks that sequence numbers are contiguous and
+// that every record's hash and link to its predecessor are intact. It
+// returns the number of valid records read before the first problem and
+// the hash of the last of them.
+func Verify(r io.Reader) (n int, head string, err error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1<<20)
+	prev := genesis
+	var seq int64
+	for sc.Scan() {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var rec Record
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return n, prev, fmt.Errorf("record %d: %w", n+1, err)
+		}
+		switch {
+		case rec.Seq != seq+1:
+			return n, prev, fmt.Errorf("record %d: sequence number %d, want %d", n+1, rec.Seq, seq+1)
+		case rec.Prev != prev:
+			return n, prev, fmt.Errorf("record %d: chain broken, previous hash does not match", rec.Seq)
+		case rec.sum() != rec.Hash:
+			return n, prev, fmt.Errorf("record %d: hash mismatch, record was modified", rec.Seq)
+		}
+		prev, seq = rec.Hash, rec.Seq
+		n++
+	}
+	return n, prev, sc.Err()
+}
+
+var std atomic.Pointer[Log]
+
+// SetDefault makes l the log used by Event. Passing nil disables auditing.
+func SetDefault(l *Log) {
+	std.Store(l)
+}
+
+// Event records an event of the given type in the default log. r, if not
+// nil, supplies the remote address, method and path; client names the
+// authenticated caller if known. It does nothing when no default log is
+// set.
+func Event(typ string, r *http.Request, client, detail string) {
+	l := std.Load()
+	if l == nil {
+		return
+	}
+	rec := Record{Type: typ, Client: client, Detail: detail}
+	if r != nil {
+		rec.Remote, rec.Method, rec.Path = r.RemoteAddr, r.Method, r.URL.Path
+	}
+	if err := l.Write(rec); err != nil {
+		slog.Error("audit: writing record", "err", err)
+	}
+}
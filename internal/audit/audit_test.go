@@ -0,0 +1,57 @@
+package audit
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestChainAndVerify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetDefault(l)
+	defer SetDefault(nil)
+	Event(AuthFailed, httptest.NewRequest("POST", "/api/chat", nil), "", "invalid credentials")
+	Event(KeyUsed, nil, "alice", "")
+	l.Close()
+
+	// Reopening continues the chain.
+	l, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seq, _ := l.Head(); seq != 2 {
+		t.Fatalf("seq after reopen = %d, want 2", seq)
+	}
+	if err := l.Write(Record{Type: AdminChange, Detail: "created key bob"}); err != nil {
+		t.Fatal(err)
+	}
+	_, head := l.Head()
+	l.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, got, err := Verify(bytes.NewReader(data))
+	if err != nil || n != 3 || got != head {
+		t.Fatalf("Verify = %d, %s, %v; want 3, %s, nil", n, got, err, head)
+	}
+
+	lines := strings.SplitAfter(string(data), "\n")
+	for name, tampered := range map[string]string{
+		"edited":  lines[0] + strings.Replace(lines[1], "alice", "mallory", 1) + lines[2],
+		"removed": lines[0] + lines[2],
+		"swapped": lines[1] + lines[0] + lines[2],
+	} {
+		if _, _, err := Verify(strings.NewReader(tampered)); err == nil {
+			t.Errorf("%s log verified", name)
+		}
+	}
+}
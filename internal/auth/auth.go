@@ -0,0 +1,353 @@
+package auth
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/yeti47/ollama-proxy/internal/apierror"
+	"github.com/yeti47/ollama-proxy/internal/audit"
+	"github.com/yeti47/ollama-proxy/internal/schedule"
+)
+
+var (
+	// ErrNoCredentials is returned when a request carries no credentials
+	// the authenticator understands.
+	ErrNoCredentials = errors.New("no credentials")
+	// ErrInvalidCredentials is returned when credentials are present but
+	// do not match.
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// Identity describes an authenticated client. Name is a non-secret label
+// that is safe to log. Tenant and UpstreamKey are set when the client key
+// belongs to a tenant with its own upstream account. Claims holds the
+// verified token claims for JWT-authenticated clients. Models, if set,
+// restricts which models the client may use; Roles feeds role-based
+// access control. Schedule, if set, limits when the key may be used.
+type Identity struct {
+	Name        string
+	Tenant      string
+	UpstreamKey string
+	Claims      map[string]any
+	Models      []string
+	Roles       []string
+	Schedule    *schedule.Schedule
+}
+
+// Authenticator validates the credentials on an incoming request.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Identity, error)
+}
+
+// Chain tries each authenticator in order and returns the first identity.
+// If none succeeds, an ErrInvalidCredentials error takes precedence over
+// ErrNoCredentials so clients that sent something get a useful log line.
+type Chain []Authenticator
+
+// Authenticate implements Authenticator.
+func (c Chain) Authenticate(r *http.Request) (*Identity, error) {
+	err := ErrNoCredentials
+	for _, a := range c {
+		id, aerr := a.Authenticate(r)
+		if aerr == nil {
+			return id, nil
+		}
+		if !errors.Is(aerr, ErrNoCredentials) {
+			err = aerr
+		}
+	}
+	return nil, err
+}
+
+func (c Chain) challenge() string {
+	var parts []string
+	for _, a := range c {
+		if ch, ok := a.(challenger); ok {
+			parts = append(parts, ch.challenge())
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// challenger is implemented by authenticators that need a specific
+// WWW-Authenticate challenge, e.g. Basic so browsers prompt for a login.
+type challenger interface {
+	challenge() string
+}
+
+type ctxKey struct{}
+
+// WithIdentity returns a copy of ctx carrying id.
+func WithIdentity(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, ctxKey{}, id)
+}
+
+// FromContext returns the identity stored in ctx, or nil.
+func FromContext(ctx context.Context) *Identity {
+	id, _ := ctx.Value(ctxKey{}).(*Identity)
+	return id
+}
+
+// ClientName returns the name of the identity stored in ctx, or "" for
+// unauthenticated requests.
+func ClientName(ctx context.Context) string {
+	if id := FromContext(ctx); id != nil {
+		return id.Name
+	}
+	return ""
+}
+
+// TenantName returns the tenant of the identity in ctx, or "" if there is
+// none.
+func TenantName(ctx context.Context) string {
+	if id := FromContext(ctx); id != nil {
+		return id.Tenant
+	}
+	return ""
+}
+
+// BearerToken extracts the token from an "Authorization: Bearer <token>"
+// header, or from an "X-Api-Key" header as sent by Anthropic's SDKs. It
+// returns an empty string if no token is present.
+func BearerToken(r *http.Request) string {
+	h := r.Header.Get("Authorization")
+	if len(h) < 7 || !strings.EqualFold(h[:7], "Bearer ") {
+		return strings.TrimSpace(r.Header.Get("X-Api-Key"))
+	}
+	return strings.TrimSpace(h[7:])
+}
+
+// KeySet authenticates requests against a fixed set of proxy-issued client
+// keys. Keys are indexed by their SHA-256 digest so lookups don't compare
+// the raw secret byte by byte. Entries that are bcrypt hashes (as printed
+// by HashKey) are checked with bcrypt instead, so key files and config
+// files don't have to contain the plaintext. Hashes are indexed by the key
+// name HashKey puts before them, so a token costs at most one bcrypt check
+// and tokens matching no name cost none.
+type KeySet struct {
+	keys map[[32]byte]*Identity
+	// named holds hashes by key name; legacy holds bare bcrypt hashes
+	// from older versions, which every unknown token is checked against.
+	named  map[string][]hashedKey
+	legacy []hashedKey
+
+	mu     sync.Mutex
+	cache  map[[32]byte]hashedCacheEntry
+	failed map[[32]byte]time.Time
+}
+
+type hashedKey struct {
+	hash []byte
+	id   *Identity
+}
+
+type hashedCacheEntry struct {
+	id      *Identity
+	expires time.Time
+}
+
+// compareHash is bcrypt.CompareHashAndPassword; tests count the calls.
+var compareHash = bcrypt.CompareHashAndPassword
+
+// Failed bcrypt checks are remembered for failedCacheTTL, for up to
+// failedCacheSize tokens, so retrying a wrong key doesn't cost another.
+const (
+	failedCacheTTL  = time.Minute
+	failedCacheSize = 1024
+)
+
+// NewKeySet builds a KeySet from keys. Empty entries are ignored.
+func NewKeySet(keys []string) *KeySet {
+	ks := &KeySet{
+		keys:   make(map[[32]byte]*Identity),
+		named:  make(map[string][]hashedKey),
+		cache:  make(map[[32]byte]hashedCacheEntry),
+		failed: make(map[[32]byte]time.Time),
+	}
+	for _, k := range keys {
+		k = strings.TrimSpace(k)
+		if k == "" {
+			continue
+		}
+		ks.Add(k, Identity{})
+	}
+	return ks
+}
+
+// Add registers key with the given identity, replacing any previous entry
+// for the same key. If id.Name is empty it is derived from the key.
+func (ks *KeySet) Add(key string, id Identity) {
+	if id.Name == "" {
+		id.Name = KeyName(key)
+	}
+	if IsKeyHash(key) {
+		name, hash := splitKeyHash(key)
+		if name == "" {
+			slog.Warn("client key hash without a key name: every unknown key is checked against it; regenerate it with -hash-key", "client", id.Name)
+			ks.legacy = append(ks.legacy, hashedKey{hash: []byte(hash), id: &id})
+			return
+		}
+		ks.named[name] = append(ks.named[name], hashedKey{hash: []byte(hash), id: &id})
+		return
+	}
+	ks.keys[sha256.Sum256([]byte(key))] = &id
+}
+
+// Len returns the number of keys in the set.
+func (ks *KeySet) Len() int {
+	n := len(ks.keys) + len(ks.legacy)
+	for _, hs := range ks.named {
+		n += len(hs)
+	}
+	return n
+}
+
+// Authenticate implements Authenticator.
+func (ks *KeySet) Authenticate(r *http.Request) (*Identity, error) {
+	token := BearerToken(r)
+	if token == "" {
+		return nil, ErrNoCredentials
+	}
+	sum := sha256.Sum256([]byte(token))
+	if id, ok := ks.keys[sum]; ok {
+		return id, nil
+	}
+	named := ks.named[KeyName(token)]
+	candidates := append(named[:len(named):len(named)], ks.legacy...)
+	if len(candidates) == 0 {
+		return nil, ErrInvalidCredentials
+	}
+
+	now := time.Now()
+	ks.mu.Lock()
+	e, cached := ks.cache[sum]
+	failedAt, failed := ks.failed[sum]
+	ks.mu.Unlock()
+	if cached && now.Before(e.expires) {
+		return e.id, nil
+	}
+	if failed && now.Before(failedAt.Add(failedCacheTTL)) {
+		return nil, ErrInvalidCredentials
+	}
+	for _, h := range candidates {
+		if compareHash(h.hash, []byte(token)) == nil {
+			ks.mu.Lock()
+			ks.cache[sum] = hashedCacheEntry{id: h.id, expires: now.Add(bcryptCacheTTL)}
+			delete(ks.failed, sum)
+			ks.mu.Unlock()
+			return h.id, nil
+		}
+	}
+	ks.mu.Lock()
+	if len(ks.failed) >= failedCacheSize {
+		for k, t := range ks.failed {
+			if now.After(t.Add(failedCacheTTL)) {
+				delete(ks.failed, k)
+			}
+		}
+		if len(ks.failed) >= failedCacheSize {
+			clear(ks.failed)
+		}
+	}
+	ks.failed[sum] = now
+	ks.mu.Unlock()
+	return nil, ErrInvalidCredentials
+}
+
+// HashKey returns a bcrypt hash of key for use in key files and the
+// config file in place of the plaintext. The hash is led by the key's
+// name, as in "key-1a2b3c4d:$2a$10$...", so it can be found without
+// trying every hash; the name is logged anyway, so it gives nothing away.
+func HashKey(key string) (string, error) {
+	h, err := bcrypt.GenerateFromPassword([]byte(key), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return KeyName(key) + ":" + string(h), nil
+}
+
+// IsKeyHash reports whether s looks like a hash from HashKey, or a bare
+// bcrypt hash, rather than a key.
+func IsKeyHash(s string) bool {
+	_, h := splitKeyHash(s)
+	return strings.HasPrefix(h, "$2a$") || strings.HasPrefix(h, "$2b$") || strings.HasPrefix(h, "$2y$")
+}
+
+// splitKeyHash splits a hash from HashKey into the key name and the
+// bcrypt hash. The name is empty for anything else.
+func splitKeyHash(s string) (name, hash string) {
+	if strings.HasPrefix(s, "key-") {
+		if i := strings.IndexByte(s, ':'); i > 0 && strings.HasPrefix(s[i+1:], "$2") {
+			return s[:i], s[i+1:]
+		}
+	}
+	return "", s
+}
+
+// KeyName derives a stable, non-secret label for a key from a prefix of
+// its SHA-256 digest. For a hash from HashKey it is the name of the key
+// hashed, so a key keeps its name when it is replaced by its hash.
+func KeyName(key string) string {
+	if name, _ := splitKeyHash(key); name != "" {
+		return name
+	}
+	sum := sha256.Sum256([]byte(key))
+	return "key-" + hex.EncodeToString(sum[:4])
+}
+
+// LoadKeysFile reads client keys from path, one per line. Blank lines and
+// lines starting with '#' are skipped.
+func LoadKeysFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var keys []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		keys = append(keys, line)
+	}
+	return keys, sc.Err()
+}
+
+// Middleware rejects requests that the authenticator a fails to
+// authenticate with 401. On success the identity is stored in the request
+// context and the client's Authorization and X-Api-Key headers are
+// removed so the proxy-issued key is never forwarded upstream.
+func Middleware(a Authenticator, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := a.Authenticate(r)
+		if err != nil {
+			slog.WarnContext(r.Context(), "auth rejected", "remote", r.RemoteAddr, "method", r.Method, "path", r.URL.Path, "err", err)
+			audit.Event(audit.AuthFailed, r, "", err.Error())
+			w.Header().Set("WWW-Authenticate", `Bearer realm="ollama-proxy"`)
+			if ch, ok := a.(challenger); ok && ch.challenge() != "" {
+				w.Header().Add("WWW-Authenticate", ch.challenge())
+			}
+			apierror.Write(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		audit.Event(audit.KeyUsed, r, id.Name, id.Tenant)
+		r = r.WithContext(WithIdentity(r.Context(), id))
+		r.Header.Del("Authorization")
+		r.Header.Del("X-Api-Key")
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,182 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestMiddlewareClientKeys(t *testing.T) {
+	ks := NewKeySet([]string{"client-a", " ", "client-b"})
+	if ks.Len() != 2 {
+		t.Fatalf("expected 2 keys got %d", ks.Len())
+	}
+
+	var gotAuth, gotAPIKey string
+	var gotID *Identity
+	h := Middleware(ks, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+		gotAPIKey = r.Header.Get("X-Api-Key")
+		gotID = FromContext(r.Context())
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	t.Run("missing key rejected", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/api/tags", nil))
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("expected 401 got %d", rec.Code)
+		}
+	})
+
+	t.Run("wrong key rejected", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/tags", nil)
+		req.Header.Set("Authorization", "Bearer nope")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("expected 401 got %d", rec.Code)
+		}
+	})
+
+	t.Run("valid key accepted and stripped", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/tags", nil)
+		req.Header.Set("Authorization", "Bearer client-b")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200 got %d", rec.Code)
+		}
+		if gotAuth != "" {
+			t.Fatalf("expected client Authorization to be stripped, got %q", gotAuth)
+		}
+		if gotID == nil || gotID.Name != KeyName("client-b") {
+			t.Fatalf("unexpected identity %+v", gotID)
+		}
+	})
+
+	t.Run("x-api-key accepted and stripped", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/v1/messages", nil)
+		req.Header.Set("X-Api-Key", "client-a")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200 got %d", rec.Code)
+		}
+		if gotAPIKey != "" {
+			t.Fatalf("expected client X-Api-Key to be stripped, got %q", gotAPIKey)
+		}
+		if gotID == nil || gotID.Name != KeyName("client-a") {
+			t.Fatalf("unexpected identity %+v", gotID)
+		}
+	})
+}
+
+func TestKeySetHashedKeys(t *testing.T) {
+	hash, err := HashKey("secret-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ks := NewKeySet([]string{"plain-key", hash})
+	for _, tc := range []struct {
+		token string
+		ok    bool
+	}{
+		{"plain-key", true},
+		{"secret-key", true},
+		{"secret-key", true}, // served from cache
+		{hash, false},
+		{"other", false},
+	} {
+		req := httptest.NewRequest("GET", "/api/tags", nil)
+		req.Header.Set("Authorization", "Bearer "+tc.token)
+		id, err := ks.Authenticate(req)
+		if (err == nil) != tc.ok {
+			t.Fatalf("token %q: err = %v", tc.token, err)
+		}
+		if tc.token == "secret-key" && id.Name != KeyName(hash) {
+			t.Fatalf("hashed key identity = %q", id.Name)
+		}
+	}
+}
+
+func TestReloadable(t *testing.T) {
+	r := NewReloadable(NewKeySet([]string{"old"}))
+	req := httptest.NewRequest("GET", "/api/tags", nil)
+	req.Header.Set("Authorization", "Bearer new")
+	if _, err := r.Authenticate(req); err == nil {
+		t.Fatal("new key accepted before reload")
+	}
+	r.Store(NewKeySet([]string{"new"}))
+	if _, err := r.Authenticate(req); err != nil {
+		t.Fatalf("new key rejected after reload: %v", err)
+	}
+}
+
+func TestKeySetBcryptChecks(t *testing.T) {
+	checks := 0
+	compareHash = func(hash, key []byte) error {
+		checks++
+		return bcrypt.CompareHashAndPassword(hash, key)
+	}
+	defer func() { compareHash = bcrypt.CompareHashAndPassword }()
+
+	var keys []string
+	for i := 0; i < 5; i++ {
+		h, err := bcrypt.GenerateFromPassword([]byte(fmt.Sprintf("key-%d", i)), bcrypt.MinCost)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, KeyName(fmt.Sprintf("key-%d", i))+":"+string(h))
+	}
+	ks := NewKeySet(keys)
+	if KeyName(keys[3]) != KeyName("key-3") {
+		t.Errorf("hash named %s, key %s", KeyName(keys[3]), KeyName("key-3"))
+	}
+	auth := func(token string) error {
+		req := httptest.NewRequest("GET", "/api/tags", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		_, err := ks.Authenticate(req)
+		return err
+	}
+	for _, tc := range []struct {
+		token  string
+		ok     bool
+		checks int
+	}{
+		{"key-3", true, 1},
+		{"key-3", true, 0}, // cached
+		{"unknown", false, 0},
+		{"unknown", false, 0},
+	} {
+		checks = 0
+		if err := auth(tc.token); (err == nil) != tc.ok || checks != tc.checks {
+			t.Errorf("%s: err %v after %d bcrypt checks, want %d", tc.token, err, checks, tc.checks)
+		}
+	}
+
+	// a wrong key whose name matches a hash is checked once, then
+	// remembered
+	other, _ := bcrypt.GenerateFromPassword([]byte("other"), bcrypt.MinCost)
+	ks.named[KeyName("wrong")] = []hashedKey{{hash: other, id: &Identity{}}}
+	for i, want := range []int{1, 0} {
+		checks = 0
+		if err := auth("wrong"); err == nil || checks != want {
+			t.Errorf("wrong key #%d: err %v after %d checks, want %d", i, err, checks, want)
+		}
+	}
+
+	// the memory of failures is bounded
+	ks.failed = map[[32]byte]time.Time{}
+	for i := 0; i < failedCacheSize; i++ {
+		ks.failed[[32]byte{byte(i), byte(i >> 8), 1}] = time.Now()
+	}
+	_ = auth("wrong")
+	if len(ks.failed) > failedCacheSize {
+		t.Errorf("%d failures remembered", len(ks.failed))
+	}
+}
@@ -0,0 +1,137 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yeti47/ollama-proxy/internal/apierror"
+	"github.com/yeti47/ollama-proxy/internal/audit"
+)
+
+const (
+	// SignatureHeader carries "sha256=<hex>" of the HMAC over the canonical
+	// request string.
+	SignatureHeader = "X-Proxy-Signature"
+	// TimestampHeader carries the signing time as unix seconds.
+	TimestampHeader = "X-Proxy-Timestamp"
+)
+
+// SignatureVerifier checks HMAC-SHA256 request signatures made with a
+// shared secret. The signed string is
+//
+//	<timestamp>\n<METHOD>\n<request URI>\n<body>
+//
+// Signatures older than MaxSkew are rejected, and each signature is only
+// accepted once within that window, so a captured request can't be
+// replayed.
+type SignatureVerifier struct {
+	Secret  []byte
+	MaxSkew time.Duration
+
+	mu   sync.Mutex
+	seen map[string]time.Time
+	// sweepAt is the size of seen at which expired signatures are swept
+	// out.
+	sweepAt int
+}
+
+// seenSweepSize is how many signatures are remembered before the first
+// sweep. Expired ones left until then are harmless, as their timestamps
+// are outside the window anyway.
+const seenSweepSize = 1024
+
+// NewSignatureVerifier returns a verifier for secret with a five minute
+// skew window.
+func NewSignatureVerifier(secret string) *SignatureVerifier {
+	return &SignatureVerifier{
+		Secret:  []byte(secret),
+		MaxSkew: 5 * time.Minute,
+		seen:    make(map[string]time.Time),
+		sweepAt: seenSweepSize,
+	}
+}
+
+// Sign returns the signature header value for the given request parts.
+func Sign(secret []byte, timestamp, method, requestURI string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "\n" + method + "\n" + requestURI + "\n"))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature on r. The body is read and restored so later
+// handlers still see it.
+func (v *SignatureVerifier) Verify(r *http.Request) error {
+	ts := r.Header.Get(TimestampHeader)
+	sig := r.Header.Get(SignatureHeader)
+	if ts == "" || sig == "" {
+		return errors.New("missing signature headers")
+	}
+	secs, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return errors.New("malformed timestamp")
+	}
+	now := time.Now()
+	signedAt := time.Unix(secs, 0)
+	if d := now.Sub(signedAt); d > v.MaxSkew || d < -v.MaxSkew {
+		return errors.New("timestamp outside allowed window")
+	}
+
+	var body []byte
+	if r.Body != nil {
+		body, err = io.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			return err
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	want := Sign(v.Secret, ts, r.Method, r.URL.RequestURI(), body)
+	if !hmac.Equal([]byte(strings.ToLower(sig)), []byte(want)) {
+		return errors.New("signature mismatch")
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if len(v.seen) >= v.sweepAt {
+		for s, exp := range v.seen {
+			if now.After(exp) {
+				delete(v.seen, s)
+			}
+		}
+		// the next sweep waits until the live entries have doubled, which
+		// keeps the cost per request constant
+		v.sweepAt = max(seenSweepSize, 2*len(v.seen))
+	}
+	if _, dup := v.seen[want]; dup {
+		return errors.New("replayed signature")
+	}
+	v.seen[want] = signedAt.Add(v.MaxSkew)
+	return nil
+}
+
+// RequireSignature rejects requests whose signature v does not accept.
+func RequireSignature(v *SignatureVerifier, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := v.Verify(r); err != nil {
+			slog.WarnContext(r.Context(), "signature rejected", "remote", r.RemoteAddr, "method", r.Method, "path", r.URL.Path, "err", err)
+			audit.Event(audit.AuthFailed, r, "", "signature: "+err.Error())
+			apierror.Write(w, http.StatusUnauthorized, "invalid request signature")
+			return
+		}
+		r.Header.Del(SignatureHeader)
+		r.Header.Del(TimestampHeader)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequireSignature(t *testing.T) {
+	v := NewSignatureVerifier("shared-secret")
+	var gotBody string
+	h := RequireSignature(v, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		gotBody = string(b)
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	body := `{"model":"llama3","prompt":"hi"}`
+	newReq := func(ts time.Time, secret string) *http.Request {
+		req := httptest.NewRequest("POST", "/api/generate?x=1", strings.NewReader(body))
+		stamp := strconv.FormatInt(ts.Unix(), 10)
+		req.Header.Set(TimestampHeader, stamp)
+		req.Header.Set(SignatureHeader, Sign([]byte(secret), stamp, "POST", "/api/generate?x=1", []byte(body)))
+		return req
+	}
+	do := func(req *http.Request) int {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	now := time.Now()
+	if code := do(newReq(now, "shared-secret")); code != http.StatusOK {
+		t.Fatalf("expected 200 got %d", code)
+	}
+	if gotBody != body {
+		t.Fatalf("body not restored: %q", gotBody)
+	}
+	if code := do(newReq(now, "shared-secret")); code != http.StatusUnauthorized {
+		t.Fatalf("expected replay to be rejected, got %d", code)
+	}
+	if code := do(newReq(now.Add(-time.Second), "wrong-secret")); code != http.StatusUnauthorized {
+		t.Fatalf("expected bad secret to be rejected, got %d", code)
+	}
+	if code := do(newReq(now.Add(-10*time.Minute), "shared-secret")); code != http.StatusUnauthorized {
+		t.Fatalf("expected stale timestamp to be rejected, got %d", code)
+	}
+	if code := do(httptest.NewRequest("GET", "/api/tags", nil)); code != http.StatusUnauthorized {
+		t.Fatalf("expected unsigned request to be rejected, got %d", code)
+	}
+}
+
+func TestSignatureVerifierSweep(t *testing.T) {
+	v := NewSignatureVerifier("shared-secret")
+	verify := func(ts time.Time) error {
+		req := httptest.NewRequest("GET", "/api/tags", nil)
+		stamp := strconv.FormatInt(ts.Unix(), 10)
+		req.Header.Set(TimestampHeader, stamp)
+		req.Header.Set(SignatureHeader, Sign(v.Secret, stamp, "GET", "/api/tags", nil))
+		return v.Verify(req)
+	}
+	expired := time.Now().Add(-time.Minute)
+	for i := 0; i < seenSweepSize-1; i++ {
+		v.seen[strconv.Itoa(i)] = expired
+	}
+
+	// below the threshold nothing is swept
+	now := time.Now()
+	if err := verify(now); err != nil {
+		t.Fatal(err)
+	}
+	if len(v.seen) != seenSweepSize {
+		t.Fatalf("%d signatures remembered, want %d", len(v.seen), seenSweepSize)
+	}
+	// at the threshold expired ones go, live ones stay
+	if err := verify(now.Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if len(v.seen) != 2 || v.sweepAt != seenSweepSize {
+		t.Fatalf("after sweep: %d remembered, next sweep at %d", len(v.seen), v.sweepAt)
+	}
+	if err := verify(now); err == nil {
+		t.Fatal("replay accepted after sweep")
+	}
+
+	// with many live signatures the sweeps get further apart
+	for i := 0; i < 3*seenSweepSize; i++ {
+		v.seen[strconv.Itoa(i)] = now.Add(time.Minute)
+	}
+	if err := verify(now.Add(-2 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if want := 2 * (3*seenSweepSize + 2); v.sweepAt != want {
+		t.Fatalf("next sweep at %d, want %d", v.sweepAt, want)
+	}
+}
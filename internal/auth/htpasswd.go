@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// bcryptCacheTTL is how long a successful bcrypt check (htpasswd entries
+// and hashed client keys) is remembered. bcrypt is deliberately slow, and
+// chat clients send many requests in a row with the same credentials.
+const bcryptCacheTTL = 5 * time.Minute
+
+// Htpasswd authenticates HTTP basic credentials against bcrypt entries from
+// an Apache htpasswd file (as created by `htpasswd -B`).
+type Htpasswd struct {
+	users map[string][]byte
+
+	mu    sync.Mutex
+	cache map[string]htpasswdEntry
+}
+
+type htpasswdEntry struct {
+	sum     [32]byte
+	expires time.Time
+}
+
+// LoadHtpasswd reads path. Entries that don't use bcrypt are skipped with a
+// warning, since MD5/SHA1/crypt hashes are too weak to rely on.
+func LoadHtpasswd(path string) (*Htpasswd, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := &Htpasswd{users: make(map[string][]byte), cache: make(map[string]htpasswdEntry)}
+	sc := bufio.NewScanner(f)
+	n := 0
+	for sc.Scan() {
+		n++
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		user, hash, ok := strings.Cut(line, ":")
+		if !ok || user == "" {
+			return nil, fmt.Errorf("%s:%d: malformed entry", path, n)
+		}
+		if !IsKeyHash(hash) {
+			slog.Warn("htpasswd: skipping user, only bcrypt entries are supported", "user", user)
+			continue
+		}
+		h.users[user] = []byte(hash)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// Len returns the number of usable entries.
+func (h *Htpasswd) Len() int { return len(h.users) }
+
+// Authenticate implements Authenticator.
+func (h *Htpasswd) Authenticate(r *http.Request) (*Identity, error) {
+	user, pass, ok := r.BasicAuth()
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+	hash, known := h.users[user]
+	if !known {
+		return nil, ErrInvalidCredentials
+	}
+	id := &Identity{Name: "user:" + user}
+	sum := sha256.Sum256([]byte(pass))
+
+	h.mu.Lock()
+	e, cached := h.cache[user]
+	h.mu.Unlock()
+	if cached && e.sum == sum && time.Now().Before(e.expires) {
+		return id, nil
+	}
+
+	if err := bcrypt.CompareHashAndPassword(hash, []byte(pass)); err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	h.mu.Lock()
+	h.cache[user] = htpasswdEntry{sum: sum, expires: time.Now().Add(bcryptCacheTTL)}
+	h.mu.Unlock()
+	return id, nil
+}
+
+func (h *Htpasswd) challenge() string { return `Basic realm="ollama-proxy"` }
@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestHtpasswd(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("s3cret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("bcrypt: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "users.htpasswd")
+	content := "# users\nalice:" + string(hash) + "\nbob:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	h, err := LoadHtpasswd(path)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if h.Len() != 1 {
+		t.Fatalf("expected only the bcrypt entry to load, got %d", h.Len())
+	}
+
+	check := func(user, pass string) error {
+		req := httptest.NewRequest("GET", "/api/tags", nil)
+		req.SetBasicAuth(user, pass)
+		_, err := h.Authenticate(req)
+		return err
+	}
+	for i := 0; i < 2; i++ { // second round hits the cache
+		if err := check("alice", "s3cret"); err != nil {
+			t.Fatalf("expected success, got %v", err)
+		}
+		if err := check("alice", "wrong"); err == nil {
+			t.Fatal("expected wrong password to fail")
+		}
+	}
+	if err := check("bob", "password"); err == nil {
+		t.Fatal("expected non-bcrypt user to fail")
+	}
+	if _, err := h.Authenticate(httptest.NewRequest("GET", "/", nil)); err != ErrNoCredentials {
+		t.Fatalf("expected ErrNoCredentials got %v", err)
+	}
+}
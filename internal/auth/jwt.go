@@ -0,0 +1,328 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jwtLeeway is the clock skew tolerated when checking exp/nbf.
+const jwtLeeway = 60 * time.Second
+
+// jwksRetry is how long after a fetch the JWKS isn't fetched again for an
+// unknown kid, or after a failure.
+const jwksRetry = time.Minute
+
+// JWTAuthenticator validates bearer JWTs against keys published at a JWKS
+// URL, with optional issuer and audience checks. Validated claims are made
+// available on the Identity for later middleware.
+type JWTAuthenticator struct {
+	JWKSURL  string
+	Issuer   string
+	Audience string
+	Client   *http.Client
+
+	// RefreshInterval controls how often the key set is refetched. An
+	// unknown kid also triggers a refetch, at most once per minute, and a
+	// failed fetch is retried after a minute.
+	RefreshInterval time.Duration
+
+	mu          sync.Mutex
+	keys        map[string]crypto.PublicKey
+	fetchedAt   time.Time
+	attemptedAt time.Time
+	fetchErr    error
+	// fetching is closed when the fetch under way ends.
+	fetching chan struct{}
+}
+
+// NewJWTAuthenticator returns a JWTAuthenticator with default settings.
+func NewJWTAuthenticator(jwksURL, issuer, audience string) *JWTAuthenticator {
+	return &JWTAuthenticator{
+		JWKSURL:         jwksURL,
+		Issuer:          issuer,
+		Audience:        audience,
+		Client:          &http.Client{Timeout: 10 * time.Second},
+		RefreshInterval: 10 * time.Minute,
+	}
+}
+
+// Authenticate implements Authenticator.
+func (a *JWTAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	token := BearerToken(r)
+	if token == "" || strings.Count(token, ".") != 2 {
+		return nil, ErrNoCredentials
+	}
+	claims, err := a.verify(token)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCredentials, err)
+	}
+	sub, _ := claims["sub"].(string)
+	return &Identity{Name: "jwt:" + sub, Claims: claims}, nil
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+func (a *JWTAuthenticator) verify(token string) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	hb, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errors.New("malformed header")
+	}
+	var hdr jwtHeader
+	if err := json.Unmarshal(hb, &hdr); err != nil {
+		return nil, errors.New("malformed header")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed signature")
+	}
+
+	key, err := a.key(hdr.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(hdr.Alg, key, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return nil, err
+	}
+
+	pb, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errors.New("malformed payload")
+	}
+	var claims map[string]any
+	if err := json.Unmarshal(pb, &claims); err != nil {
+		return nil, errors.New("malformed payload")
+	}
+	if err := a.checkClaims(claims, time.Now()); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (a *JWTAuthenticator) checkClaims(claims map[string]any, now time.Time) error {
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return errors.New("missing exp")
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(jwtLeeway)) {
+		return errors.New("token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(jwtLeeway).Before(time.Unix(int64(nbf), 0)) {
+		return errors.New("token not yet valid")
+	}
+	if a.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != a.Issuer {
+			return fmt.Errorf("unexpected issuer %q", iss)
+		}
+	}
+	if a.Audience != "" && !hasAudience(claims["aud"], a.Audience) {
+		return errors.New("audience mismatch")
+	}
+	return nil
+}
+
+// hasAudience reports whether the aud claim, which may be a string or a
+// list of strings, contains want.
+func hasAudience(aud any, want string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == want
+	case []any:
+		for _, x := range v {
+			if s, _ := x.(string); s == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func verifySignature(alg string, key crypto.PublicKey, signed, sig []byte) error {
+	var h crypto.Hash
+	switch alg {
+	case "RS256", "ES256":
+		h = crypto.SHA256
+	case "RS384", "ES384":
+		h = crypto.SHA384
+	case "RS512", "ES512":
+		h = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported alg %q", alg)
+	}
+	hasher := h.New()
+	hasher.Write(signed)
+	digest := hasher.Sum(nil)
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if alg[:2] != "RS" {
+			return errors.New("alg does not match key type")
+		}
+		if err := rsa.VerifyPKCS1v15(k, h, digest, sig); err != nil {
+			return errors.New("bad signature")
+		}
+	case *ecdsa.PublicKey:
+		if alg[:2] != "ES" {
+			return errors.New("alg does not match key type")
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return errors.New("bad signature")
+		}
+		rr := new(big.Int).SetBytes(sig[:size])
+		ss := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(k, digest, rr, ss) {
+			return errors.New("bad signature")
+		}
+	default:
+		return errors.New("unsupported key type")
+	}
+	return nil
+}
+
+// key returns the public key for kid, refreshing the JWKS when it is stale
+// or the kid is unknown. One request fetches while the others wait for it,
+// or keep using the cached key; after a fetch, failed or not, the JWKS is
+// fetched again for an unknown kid only once jwksRetry has passed.
+func (a *JWTAuthenticator) key(kid string) (crypto.PublicKey, error) {
+	for {
+		a.mu.Lock()
+		k, ok := a.lookup(kid)
+		recent := time.Since(a.attemptedAt) < jwksRetry
+		switch {
+		case ok && (recent || a.fetching != nil || time.Since(a.fetchedAt) < a.RefreshInterval):
+			// a stale key is still served while a refresh is under way or
+			// the JWKS endpoint fails
+			a.mu.Unlock()
+			return k, nil
+		case !ok && recent:
+			err := a.fetchErr
+			a.mu.Unlock()
+			if err != nil {
+				return nil, err
+			}
+			return nil, fmt.Errorf("unknown kid %q", kid)
+		case a.fetching != nil:
+			done := a.fetching
+			a.mu.Unlock()
+			<-done
+			continue
+		}
+		done := make(chan struct{})
+		a.fetching = done
+		a.mu.Unlock()
+
+		keys, err := a.fetch()
+		a.mu.Lock()
+		a.attemptedAt, a.fetchErr = time.Now(), err
+		if err == nil {
+			a.keys, a.fetchedAt = keys, a.attemptedAt
+		}
+		a.fetching = nil
+		close(done)
+		a.mu.Unlock()
+	}
+}
+
+// lookup finds kid in the cached set. A token without kid matches when the
+// set holds exactly one key.
+func (a *JWTAuthenticator) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(a.keys) == 1 {
+		for _, k := range a.keys {
+			return k, true
+		}
+	}
+	k, ok := a.keys[kid]
+	return k, ok
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (a *JWTAuthenticator) fetch() (map[string]crypto.PublicKey, error) {
+	resp, err := a.Client.Get(a.JWKSURL)
+	if err != nil {
+		return nil, fmt.Errorf("fetching jwks: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching jwks: status %d", resp.StatusCode)
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("decoding jwks: %w", err)
+	}
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		pk, err := k.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = pk
+	}
+	return keys, nil
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	}
+	return nil, fmt.Errorf("unsupported kty %q", k.Kty)
+}
@@ -0,0 +1,165 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func signRS256(t *testing.T, key *rsa.PrivateKey, kid string, claims map[string]any) string {
+	t.Helper()
+	hb, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": kid})
+	pb, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(hb) + "." + base64.RawURLEncoding.EncodeToString(pb)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestJWTAuthenticator(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "k1",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	}))
+	defer jwks.Close()
+
+	a := NewJWTAuthenticator(jwks.URL, "https://sso.example", "ollama-proxy")
+	exp := float64(time.Now().Add(time.Hour).Unix())
+
+	cases := []struct {
+		name   string
+		claims map[string]any
+		ok     bool
+	}{
+		{"valid", map[string]any{"sub": "alice", "iss": "https://sso.example", "aud": []any{"other", "ollama-proxy"}, "exp": exp}, true},
+		{"expired", map[string]any{"sub": "alice", "iss": "https://sso.example", "aud": "ollama-proxy", "exp": float64(time.Now().Add(-time.Hour).Unix())}, false},
+		{"wrong issuer", map[string]any{"sub": "alice", "iss": "https://evil.example", "aud": "ollama-proxy", "exp": exp}, false},
+		{"wrong audience", map[string]any{"sub": "alice", "iss": "https://sso.example", "aud": "other", "exp": exp}, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/chat", nil)
+			req.Header.Set("Authorization", "Bearer "+signRS256(t, key, "k1", tc.claims))
+			id, err := a.Authenticate(req)
+			if tc.ok {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if id.Name != "jwt:alice" || id.Claims["sub"] != "alice" {
+					t.Fatalf("unexpected identity %+v", id)
+				}
+			} else if err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+
+	t.Run("tampered signature", func(t *testing.T) {
+		other, _ := rsa.GenerateKey(rand.Reader, 2048)
+		req := httptest.NewRequest("GET", "/api/chat", nil)
+		req.Header.Set("Authorization", "Bearer "+signRS256(t, other, "k1", cases[0].claims))
+		if _, err := a.Authenticate(req); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+}
+
+func TestJWTAuthenticatorJWKSFailure(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	var fetches atomic.Int32
+	var failing atomic.Bool
+	failing.Store(true)
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		time.Sleep(20 * time.Millisecond) // long enough for requests to pile up
+		if failing.Load() {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "k1",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	}))
+	defer jwks.Close()
+
+	a := NewJWTAuthenticator(jwks.URL, "", "")
+	token := signRS256(t, key, "k1", map[string]any{"sub": "alice", "exp": float64(time.Now().Add(time.Hour).Unix())})
+	authenticate := func(n int) (failed int) {
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				req := httptest.NewRequest("GET", "/api/chat", nil)
+				req.Header.Set("Authorization", "Bearer "+token)
+				if _, err := a.Authenticate(req); err != nil {
+					mu.Lock()
+					failed++
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+		return failed
+	}
+
+	// concurrent requests share one fetch, and the failure is remembered
+	if failed := authenticate(20); failed != 20 || fetches.Load() != 1 {
+		t.Fatalf("endpoint down: %d failed, %d fetches", failed, fetches.Load())
+	}
+	if failed := authenticate(5); failed != 5 || fetches.Load() != 1 {
+		t.Fatalf("within the retry delay: %d failed, %d fetches", failed, fetches.Load())
+	}
+
+	// once the delay has passed, the endpoint is asked again
+	failing.Store(false)
+	a.mu.Lock()
+	a.attemptedAt = time.Now().Add(-jwksRetry)
+	a.mu.Unlock()
+	if failed := authenticate(5); failed != 0 || fetches.Load() != 2 {
+		t.Fatalf("endpoint back: %d failed, %d fetches", failed, fetches.Load())
+	}
+
+	// cached keys are served while a refresh fails, and the refresh isn't
+	// retried on every request
+	failing.Store(true)
+	a.mu.Lock()
+	a.fetchedAt = time.Now().Add(-2 * a.RefreshInterval)
+	a.attemptedAt = a.fetchedAt
+	a.mu.Unlock()
+	if failed := authenticate(10); failed != 0 || fetches.Load() != 3 {
+		t.Fatalf("refresh failing: %d failed, %d fetches", failed, fetches.Load())
+	}
+	if failed := authenticate(5); failed != 0 || fetches.Load() != 3 {
+		t.Fatalf("after failed refresh: %d failed, %d fetches", failed, fetches.Load())
+	}
+}
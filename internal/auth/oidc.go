@@ -0,0 +1,201 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// introspectionCacheTTL bounds how long an introspection result is reused,
+// so revoked tokens stop working reasonably quickly.
+const introspectionCacheTTL = time.Minute
+
+// discoveryRetry is how long after a failed discovery it isn't tried
+// again; requests fail with its error meanwhile.
+const discoveryRetry = time.Minute
+
+// IntrospectionAuthenticator validates opaque or JWT bearer tokens by
+// calling the OIDC provider's RFC 7662 token introspection endpoint. The
+// endpoint is discovered from the issuer's openid-configuration.
+type IntrospectionAuthenticator struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Client       *http.Client
+
+	mu       sync.Mutex
+	endpoint string
+	cache    map[[32]byte]introspectionEntry
+	// attemptedAt and discoveryErr are the time and error of the last
+	// failed discovery.
+	attemptedAt  time.Time
+	discoveryErr error
+	// discovering is closed when the discovery under way ends.
+	discovering chan struct{}
+}
+
+type introspectionEntry struct {
+	id      *Identity
+	expires time.Time
+}
+
+// NewIntrospectionAuthenticator returns an authenticator for issuer using
+// the given client credentials to call the introspection endpoint.
+func NewIntrospectionAuthenticator(issuer, clientID, clientSecret string) *IntrospectionAuthenticator {
+	return &IntrospectionAuthenticator{
+		Issuer:       strings.TrimRight(issuer, "/"),
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Client:       &http.Client{Timeout: 10 * time.Second},
+		cache:        make(map[[32]byte]introspectionEntry),
+	}
+}
+
+// Authenticate implements Authenticator.
+func (a *IntrospectionAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	token := BearerToken(r)
+	if token == "" {
+		return nil, ErrNoCredentials
+	}
+	sum := sha256.Sum256([]byte(token))
+
+	a.mu.Lock()
+	if e, ok := a.cache[sum]; ok && time.Now().Before(e.expires) {
+		a.mu.Unlock()
+		return e.id, nil
+	}
+	a.mu.Unlock()
+
+	claims, err := a.introspect(token)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCredentials, err)
+	}
+	sub, _ := claims["sub"].(string)
+	if sub == "" {
+		sub, _ = claims["username"].(string)
+	}
+	id := &Identity{Name: "oidc:" + sub, Claims: claims}
+
+	expires := time.Now().Add(introspectionCacheTTL)
+	if exp, ok := claims["exp"].(float64); ok {
+		if t := time.Unix(int64(exp), 0); t.Before(expires) {
+			expires = t
+		}
+	}
+	a.mu.Lock()
+	now := time.Now()
+	for k, e := range a.cache {
+		if now.After(e.expires) {
+			delete(a.cache, k)
+		}
+	}
+	a.cache[sum] = introspectionEntry{id: id, expires: expires}
+	a.mu.Unlock()
+	return id, nil
+}
+
+func (a *IntrospectionAuthenticator) introspect(token string) (map[string]any, error) {
+	endpoint, err := a.introspectionEndpoint()
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{"token": {token}, "token_type_hint": {"access_token"}}
+	req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(a.ClientID), url.QueryEscape(a.ClientSecret))
+
+	resp, err := a.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("introspection request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("introspection status %d", resp.StatusCode)
+	}
+	var claims map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&claims); err != nil {
+		return nil, fmt.Errorf("decoding introspection response: %w", err)
+	}
+	if active, _ := claims["active"].(bool); !active {
+		return nil, errors.New("token inactive")
+	}
+	return claims, nil
+}
+
+// introspectionEndpoint returns the provider's introspection endpoint,
+// discovering it on first use. Discovery runs without holding a.mu, so
+// cached tokens are served while the issuer is slow, and concurrent
+// requests wait for the same discovery.
+func (a *IntrospectionAuthenticator) introspectionEndpoint() (string, error) {
+	for {
+		a.mu.Lock()
+		switch {
+		case a.endpoint != "":
+			endpoint := a.endpoint
+			a.mu.Unlock()
+			return endpoint, nil
+		case a.discovering != nil:
+			done := a.discovering
+			a.mu.Unlock()
+			<-done
+			continue
+		case time.Since(a.attemptedAt) < discoveryRetry:
+			err := a.discoveryErr
+			a.mu.Unlock()
+			return "", err
+		}
+		done := make(chan struct{})
+		a.discovering = done
+		a.mu.Unlock()
+
+		endpoint, err := a.discover()
+		a.mu.Lock()
+		if err != nil {
+			a.attemptedAt, a.discoveryErr = time.Now(), err
+		} else {
+			a.endpoint = endpoint
+		}
+		a.discovering = nil
+		close(done)
+		a.mu.Unlock()
+	}
+}
+
+// discover reads the introspection endpoint from the issuer's
+// openid-configuration.
+func (a *IntrospectionAuthenticator) discover() (string, error) {
+	resp, err := a.Client.Get(a.Issuer + "/.well-known/openid-configuration")
+	if err != nil {
+		return "", fmt.Errorf("oidc discovery: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("oidc discovery: status %d", resp.StatusCode)
+	}
+	var doc struct {
+		Issuer                string `json:"issuer"`
+		IntrospectionEndpoint string `json:"introspection_endpoint"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return "", fmt.Errorf("oidc discovery: %w", err)
+	}
+	// OpenID Connect Discovery 1.0, section 4.3: the issuer must be the
+	// one asked, or the document may point at someone else's endpoint
+	if strings.TrimRight(doc.Issuer, "/") != strings.TrimRight(a.Issuer, "/") {
+		return "", fmt.Errorf("oidc discovery: issuer %q does not match %q", doc.Issuer, a.Issuer)
+	}
+	if doc.IntrospectionEndpoint == "" {
+		return "", errors.New("oidc discovery: provider does not advertise an introspection_endpoint")
+	}
+	return doc.IntrospectionEndpoint, nil
+}
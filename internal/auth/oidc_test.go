@@ -0,0 +1,177 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeIssuer serves discovery and introspection. Tokens starting with
+// "active" are active; issuer overrides the issuer discovery reports.
+func fakeIssuer(t *testing.T, issuer string) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var introspections atomic.Int32
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			iss := issuer
+			if iss == "" {
+				iss = srv.URL
+			}
+			_ = json.NewEncoder(w).Encode(map[string]string{"issuer": iss, "introspection_endpoint": srv.URL + "/introspect"})
+		case "/introspect":
+			introspections.Add(1)
+			if id, secret, _ := r.BasicAuth(); id != "proxy" || secret != "s3cret" {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			token := r.PostFormValue("token")
+			if !strings.HasPrefix(token, "active") {
+				_ = json.NewEncoder(w).Encode(map[string]any{"active": false})
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]any{"active": true, "sub": "alice", "exp": float64(time.Now().Add(time.Hour).Unix())})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &introspections
+}
+
+func introspect(a *IntrospectionAuthenticator, token string) (*Identity, error) {
+	req := httptest.NewRequest("GET", "/api/tags", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	return a.Authenticate(req)
+}
+
+func TestIntrospectionAuthenticator(t *testing.T) {
+	srv, introspections := fakeIssuer(t, "")
+	a := NewIntrospectionAuthenticator(srv.URL+"/", "proxy", "s3cret")
+
+	id, err := introspect(a, "active-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.Name != "oidc:alice" || id.Claims["sub"] != "alice" {
+		t.Errorf("identity %+v", id)
+	}
+	if _, err := introspect(a, "revoked"); err == nil {
+		t.Error("inactive token accepted")
+	}
+	if _, err := introspect(a, ""); err != ErrNoCredentials {
+		t.Errorf("no token: %v", err)
+	}
+
+	// active tokens are cached, inactive ones asked about again
+	n := introspections.Load()
+	if _, err := introspect(a, "active-1"); err != nil || introspections.Load() != n {
+		t.Errorf("cached token: %v, %d introspections", err, introspections.Load()-n)
+	}
+	if _, err := introspect(a, "revoked"); err == nil || introspections.Load() != n+1 {
+		t.Errorf("inactive token: %v, %d introspections", err, introspections.Load()-n)
+	}
+
+	// cache entries expire
+	a.mu.Lock()
+	for k, e := range a.cache {
+		e.expires = time.Now().Add(-time.Second)
+		a.cache[k] = e
+	}
+	a.mu.Unlock()
+	if _, err := introspect(a, "active-1"); err != nil || introspections.Load() != n+2 {
+		t.Errorf("expired entry: %v, %d introspections", err, introspections.Load()-n)
+	}
+}
+
+func TestIntrospectionDiscovery(t *testing.T) {
+	srv, introspections := fakeIssuer(t, "https://evil.example")
+	a := NewIntrospectionAuthenticator(srv.URL, "proxy", "s3cret")
+	_, err := introspect(a, "active-1")
+	if err == nil || !strings.Contains(err.Error(), "issuer") {
+		t.Errorf("mismatched issuer: %v", err)
+	}
+	if introspections.Load() != 0 {
+		t.Error("introspection endpoint of a mismatched issuer called")
+	}
+
+	srv, _ = fakeIssuer(t, "")
+	a = NewIntrospectionAuthenticator(srv.URL, "proxy", "wrong")
+	if _, err := introspect(a, "active-1"); err == nil {
+		t.Error("rejected client credentials accepted")
+	}
+
+	a = NewIntrospectionAuthenticator(srv.URL+"/nowhere", "proxy", "s3cret")
+	if _, err := introspect(a, "active-1"); err == nil || !strings.Contains(err.Error(), "discovery") {
+		t.Errorf("missing discovery document: %v", err)
+	}
+}
+
+func TestIntrospectionDiscoveryFailure(t *testing.T) {
+	var discoveries atomic.Int32
+	entered, release := make(chan struct{}), make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if discoveries.Add(1) == 1 {
+			close(entered)
+		}
+		<-release
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	a := NewIntrospectionAuthenticator(srv.URL, "proxy", "s3cret")
+	cached := &Identity{Name: "oidc:alice"}
+	a.cache[sha256.Sum256([]byte("cached"))] = introspectionEntry{id: cached, expires: time.Now().Add(time.Minute)}
+
+	// requests needing discovery wait for the same one
+	var wg sync.WaitGroup
+	var failed atomic.Int32
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := introspect(a, "new"); err != nil {
+				failed.Add(1)
+			}
+		}()
+	}
+	<-entered
+
+	// a cached token doesn't wait for the issuer
+	got := make(chan *Identity, 1)
+	go func() {
+		id, _ := introspect(a, "cached")
+		got <- id
+	}()
+	select {
+	case id := <-got:
+		if id != cached {
+			t.Errorf("cached token: %+v", id)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("cached token blocked by discovery")
+	}
+
+	close(release)
+	wg.Wait()
+	if failed.Load() != 5 || discoveries.Load() != 1 {
+		t.Fatalf("issuer down: %d failed, %d discoveries", failed.Load(), discoveries.Load())
+	}
+
+	// the failure is remembered for a while
+	if _, err := introspect(a, "new"); err == nil || discoveries.Load() != 1 {
+		t.Errorf("within the retry delay: %v, %d discoveries", err, discoveries.Load())
+	}
+	a.mu.Lock()
+	a.attemptedAt = time.Now().Add(-discoveryRetry)
+	a.mu.Unlock()
+	if _, err := introspect(a, "new"); err == nil || discoveries.Load() != 2 {
+		t.Errorf("after the retry delay: %v, %d discoveries", err, discoveries.Load())
+	}
+}
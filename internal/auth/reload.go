@@ -0,0 +1,39 @@
+package auth
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// Reloadable is an Authenticator whose underlying authenticator can be
+// replaced at runtime, e.g. after a key file changed. Requests that are
+// already past authentication are not affected by a swap.
+type Reloadable struct {
+	cur atomic.Pointer[reloadableEntry]
+}
+
+type reloadableEntry struct{ a Authenticator }
+
+// NewReloadable returns a Reloadable that initially delegates to a.
+func NewReloadable(a Authenticator) *Reloadable {
+	r := &Reloadable{}
+	r.Store(a)
+	return r
+}
+
+// Store replaces the underlying authenticator.
+func (r *Reloadable) Store(a Authenticator) {
+	r.cur.Store(&reloadableEntry{a: a})
+}
+
+// Authenticate implements Authenticator.
+func (r *Reloadable) Authenticate(req *http.Request) (*Identity, error) {
+	return r.cur.Load().a.Authenticate(req)
+}
+
+func (r *Reloadable) challenge() string {
+	if ch, ok := r.cur.Load().a.(challenger); ok {
+		return ch.challenge()
+	}
+	return ""
+}
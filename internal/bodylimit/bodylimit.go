@@ -0,0 +1,31 @@
+// Package bodylimit caps the size of incoming request bodies.
+package bodylimit
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/yeti47/ollama-proxy/internal/apierror"
+)
+
+// DefaultLimit is large enough for long chat histories with a few images
+// but stops accidental multi-gigabyte uploads such as model blobs.
+const DefaultLimit = 32 << 20
+
+// Middleware rejects bodies larger than limit bytes with 413. Requests
+// announcing a larger Content-Length are rejected before anything is
+// read; chunked bodies are cut off once they pass the limit.
+func Middleware(limit int64, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > limit {
+			slog.WarnContext(r.Context(), "request body too large", "remote", r.RemoteAddr, "method", r.Method, "path", r.URL.Path, "bytes", r.ContentLength)
+			apierror.Write(w, http.StatusRequestEntityTooLarge, "request body too large (limit "+strconv.FormatInt(limit, 10)+" bytes)")
+			return
+		}
+		if r.Body != nil && r.Body != http.NoBody {
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
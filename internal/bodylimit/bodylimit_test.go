@@ -0,0 +1,42 @@
+package bodylimit
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/yeti47/ollama-proxy/internal/apierror"
+)
+
+func TestMiddleware(t *testing.T) {
+	h := Middleware(10, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := io.ReadAll(r.Body); err != nil {
+			apierror.BodyError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	for _, tc := range []struct {
+		body    string
+		chunked bool
+		want    int
+	}{
+		{"short", false, http.StatusOK},
+		{"much too long body", false, http.StatusRequestEntityTooLarge},
+		{"much too long body", true, http.StatusRequestEntityTooLarge},
+		{"0123456789", true, http.StatusOK},
+	} {
+		req := httptest.NewRequest("POST", "/api/chat", strings.NewReader(tc.body))
+		if tc.chunked {
+			req.ContentLength = -1
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("body %q chunked=%t: status %d, want %d", tc.body, tc.chunked, rec.Code, tc.want)
+		}
+	}
+}
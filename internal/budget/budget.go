@@ -0,0 +1,283 @@
+package budget
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/yeti47/ollama-proxy/internal/apierror"
+	"github.com/yeti47/ollama-proxy/internal/auth"
+	"github.com/yeti47/ollama-proxy/internal/metrics"
+	"github.com/yeti47/ollama-proxy/internal/notify"
+	"github.com/yeti47/ollama-proxy/internal/ollama"
+)
+
+var (
+	spendTotal = metrics.NewCounter("ollama_proxy_spend_usd_total",
+		"Estimated spend in USD from the configured price table, by client, tenant and model.", "client", "tenant", "model")
+	budgetEvents = metrics.NewCounter("ollama_proxy_budget_events_total",
+		"Budget warnings and blocks, by client, tenant and action.", "client", "tenant", "action")
+)
+
+// anonymousKey tracks spend of unauthenticated clients.
+const anonymousKey = "anonymous"
+
+// Price is the cost of a model in USD per million tokens.
+type Price struct {
+	InputPer1M  float64 `json:"input_per_1m"`
+	OutputPer1M float64 `json:"output_per_1m"`
+}
+
+// Prices maps model names or path.Match patterns to prices. A "*" entry
+// acts as the fallback.
+type Prices map[string]Price
+
+// Lookup returns the price of model: an exact (tag-normalized) match
+// first, then the first matching pattern in sorted order, then "*".
+func (p Prices) Lookup(model string) (Price, bool) {
+	norm := ollama.NormalizeModel(model)
+	for name, pr := range p {
+		if name != "*" && ollama.NormalizeModel(name) == norm {
+			return pr, true
+		}
+	}
+	var best string
+	for name := range p {
+		if name == "*" {
+			continue
+		}
+		if ok, _ := path.Match(name, norm); ok && (best == "" || name < best) {
+			best = name
+		}
+	}
+	if best != "" {
+		return p[best], true
+	}
+	pr, ok := p["*"]
+	return pr, ok
+}
+
+// Cost returns the estimated cost of u.
+func (p Prices) Cost(u ollama.Usage) float64 {
+	pr, ok := p.Lookup(u.Model)
+	if !ok {
+		return 0
+	}
+	return float64(u.PromptTokens)/1e6*pr.InputPer1M + float64(u.CompletionTokens)/1e6*pr.OutputPer1M
+}
+
+// Limits are spend limits in USD per UTC day and month. Action is "block"
+// (the default) or "warn".
+type Limits struct {
+	DailyUSD   float64 `json:"daily_usd,omitempty"`
+	MonthlyUSD float64 `json:"monthly_usd,omitempty"`
+	Action     string  `json:"action,omitempty"`
+}
+
+type spend struct {
+	Day         string  `json:"day"`
+	DayUSD      float64 `json:"day_usd"`
+	Month       string  `json:"month"`
+	MonthUSD    float64 `json:"month_usd"`
+	warnedDay   string
+	warnedMonth string
+}
+
+func (s *spend) roll(now time.Time) {
+	if d := now.Format("2006-01-02"); s.Day != d {
+		s.Day, s.DayUSD = d, 0
+	}
+	if m := now.Format("2006-01"); s.Month != m {
+		s.Month, s.MonthUSD = m, 0
+	}
+}
+
+// Tracker accumulates estimated spend per client and blocks or warns once
+// a budget is reached.
+type Tracker struct {
+	Prices    Prices
+	Default   Limits
+	Overrides map[string]Limits
+	StateFile string
+
+	mu    sync.Mutex
+	spend map[string]*spend
+	dirty bool
+	now   func() time.Time
+}
+
+// NewTracker returns a Tracker, loading previous totals from stateFile if
+// it exists.
+func NewTracker(prices Prices, def Limits, stateFile string) (*Tracker, error) {
+	t := &Tracker{
+		Prices:    prices,
+		Default:   def,
+		Overrides: make(map[string]Limits),
+		StateFile: stateFile,
+		spend:     make(map[string]*spend),
+		now:       func() time.Time { return time.Now().UTC() },
+	}
+	if stateFile != "" {
+		b, err := os.ReadFile(stateFile)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if len(b) > 0 {
+			if err := json.Unmarshal(b, &t.spend); err != nil {
+				return nil, fmt.Errorf("parsing %s: %w", stateFile, err)
+			}
+		}
+	}
+	return t, nil
+}
+
+func (t *Tracker) keyAndLimits(id *auth.Identity) (string, Limits) {
+	if id == nil {
+		return anonymousKey, t.Default
+	}
+	if lim, ok := t.Overrides[id.Name]; ok {
+		return id.Name, lim
+	}
+	return id.Name, t.Default
+}
+
+// get returns the rolled-over spend for key. Callers must hold t.mu.
+func (t *Tracker) get(key string) *spend {
+	s, ok := t.spend[key]
+	if !ok {
+		s = &spend{}
+		t.spend[key] = s
+	}
+	s.roll(t.now())
+	return s
+}
+
+// Record adds the estimated cost of a completed response. It matches the
+// proxy's OnUsage hook.
+func (t *Tracker) Record(r *http.Request, u ollama.Usage) {
+	cost := t.Prices.Cost(u)
+	if cost == 0 {
+		return
+	}
+	key, _ := t.keyAndLimits(auth.FromContext(r.Context()))
+	spendTotal.With(key, auth.TenantName(r.Context()), u.Model).Add(cost)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := t.get(key)
+	s.DayUSD += cost
+	s.MonthUSD += cost
+	t.dirty = true
+}
+
+// exceeded returns which period's budget key has reached, if any. Callers
+// must hold t.mu.
+func (t *Tracker) exceeded(key string, lim Limits) (period string, spent, limit float64) {
+	s := t.get(key)
+	if lim.MonthlyUSD > 0 && s.MonthUSD >= lim.MonthlyUSD {
+		return "monthly", s.MonthUSD, lim.MonthlyUSD
+	}
+	if lim.DailyUSD > 0 && s.DayUSD >= lim.DailyUSD {
+		return "daily", s.DayUSD, lim.DailyUSD
+	}
+	return "", 0, 0
+}
+
+// Middleware enforces budgets. With the "warn" action requests still go
+// through but carry an X-Budget-Warning header, and the overrun is logged
+// once per period.
+func (t *Tracker) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, lim := t.keyAndLimits(auth.FromContext(r.Context()))
+		t.mu.Lock()
+		period, spent, limit := t.exceeded(key, lim)
+		firstWarning := false
+		if period != "" && lim.Action == "warn" {
+			s := t.spend[key]
+			marker := s.Day
+			if period == "monthly" {
+				marker = s.Month
+			}
+			if period == "daily" && s.warnedDay != marker {
+				s.warnedDay, firstWarning = marker, true
+			} else if period == "monthly" && s.warnedMonth != marker {
+				s.warnedMonth, firstWarning = marker, true
+			}
+		}
+		t.mu.Unlock()
+
+		if period == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		msg := fmt.Sprintf("%s budget of $%.2f reached ($%.2f spent)", period, limit, spent)
+		if lim.Action == "warn" {
+			if firstWarning {
+				budgetEvents.With(key, auth.TenantName(r.Context()), "warn").Inc()
+				slog.WarnContext(r.Context(), "budget warning", "client", key, "detail", msg)
+			}
+			w.Header().Set("X-Budget-Warning", msg)
+			next.ServeHTTP(w, r)
+			return
+		}
+		budgetEvents.With(key, auth.TenantName(r.Context()), "block").Inc()
+		slog.WarnContext(r.Context(), "budget exceeded", "client", key, "detail", msg)
+		notify.Send(notify.BudgetExceeded, key, msg+" for "+key,
+			"client", key, "period", period, "limit_usd", limit, "spent_usd", spent)
+		now := t.now()
+		reset := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+		if period == "monthly" {
+			reset = time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(int(reset.Sub(now).Seconds())+1))
+		apierror.Write(w, http.StatusTooManyRequests, msg)
+	})
+}
+
+// Save writes the totals to StateFile if they changed since the last save.
+func (t *Tracker) Save() error {
+	if t.StateFile == "" {
+		return nil
+	}
+	t.mu.Lock()
+	if !t.dirty {
+		t.mu.Unlock()
+		return nil
+	}
+	b, err := json.Marshal(t.spend)
+	t.dirty = false
+	t.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp := t.StateFile + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, t.StateFile)
+}
+
+// SaveEvery persists the totals every interval until stop is closed, and
+// once more on the way out.
+func (t *Tracker) SaveEvery(interval time.Duration, stop <-chan struct{}) {
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+			if err := t.Save(); err != nil {
+				slog.Error("saving budget state", "err", err)
+			}
+		case <-stop:
+			if err := t.Save(); err != nil {
+				slog.Error("saving budget state", "err", err)
+			}
+			return
+		}
+	}
+}
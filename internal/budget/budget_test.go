@@ -0,0 +1,71 @@
+package budget
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/yeti47/ollama-proxy/internal/auth"
+	"github.com/yeti47/ollama-proxy/internal/ollama"
+)
+
+func TestPricesLookup(t *testing.T) {
+	p := Prices{
+		"llama3.1:70b": {InputPer1M: 1, OutputPer1M: 2},
+		"llama3.1:*":   {InputPer1M: 0.5, OutputPer1M: 0.5},
+		"*":            {InputPer1M: 0.1, OutputPer1M: 0.1},
+	}
+	cases := map[string]float64{
+		"llama3.1:70b": 1,
+		"llama3.1:8b":  0.5,
+		"qwen2.5":      0.1,
+	}
+	for model, want := range cases {
+		if pr, _ := p.Lookup(model); pr.InputPer1M != want {
+			t.Errorf("%s: expected %v got %v", model, want, pr.InputPer1M)
+		}
+	}
+	if c := p.Cost(ollama.Usage{Model: "llama3.1:70b", PromptTokens: 1_000_000, CompletionTokens: 500_000}); c != 2 {
+		t.Fatalf("expected cost 2 got %v", c)
+	}
+}
+
+func TestBudgetMiddleware(t *testing.T) {
+	tr, err := NewTracker(Prices{"*": {InputPer1M: 10, OutputPer1M: 10}}, Limits{DailyUSD: 1}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr.now = func() time.Time { return time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC) }
+	tr.Overrides["lenient"] = Limits{DailyUSD: 1, Action: "warn"}
+
+	h := tr.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	req := func(name string) *http.Request {
+		r := httptest.NewRequest("POST", "/api/chat", nil)
+		return r.WithContext(auth.WithIdentity(r.Context(), &auth.Identity{Name: name}))
+	}
+
+	for _, name := range []string{"strict", "lenient"} {
+		tr.Record(req(name), ollama.Usage{Model: "m", PromptTokens: 60_000, CompletionTokens: 40_000})
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req("strict"))
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("strict: expected 429 got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req("lenient"))
+	if rec.Code != http.StatusOK || rec.Header().Get("X-Budget-Warning") == "" {
+		t.Fatalf("lenient: expected 200 with warning, got %d %q", rec.Code, rec.Header().Get("X-Budget-Warning"))
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req("fresh"))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("fresh: expected 200 got %d", rec.Code)
+	}
+}
@@ -0,0 +1,100 @@
+// Package buildinfo describes the running proxy build. Release builds set
+// the version, commit and date with -ldflags:
+//
+//	go build -ldflags "-X github.com/yeti47/ollama-proxy/internal/buildinfo.Version=v1.4.0 \
+//	  -X github.com/yeti47/ollama-proxy/internal/buildinfo.Commit=$(git rev-parse HEAD) \
+//	  -X github.com/yeti47/ollama-proxy/internal/buildinfo.Date=$(date -u +%Y-%m-%dT%H:%M:%SZ)" ./cmd/ollama-proxy
+//
+// Without them the commit and date come from the VCS stamp the Go
+// toolchain embeds, when there is one.
+package buildinfo
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sync"
+)
+
+// Set with -ldflags -X.
+var (
+	Version = "dev"
+	Commit  = ""
+	Date    = ""
+)
+
+// Info is the build description served on /proxy/info.
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	Date      string `json:"date,omitempty"`
+	Modified  bool   `json:"modified,omitempty"`
+	GoVersion string `json:"go_version"`
+	Platform  string `json:"platform"`
+}
+
+var get = sync.OnceValue(func() Info {
+	info := Info{
+		Version:   Version,
+		Commit:    Commit,
+		Date:      Date,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	if info.Version == "dev" && bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+		// go install module@version
+		info.Version = bi.Main.Version
+	}
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			if info.Commit == "" {
+				info.Commit = s.Value
+			}
+		case "vcs.time":
+			if info.Date == "" {
+				info.Date = s.Value
+			}
+		case "vcs.modified":
+			info.Modified = s.Value == "true" && Commit == ""
+		}
+	}
+	return info
+})
+
+// Get returns the build information.
+func Get() Info { return get() }
+
+// String formats the build information for -version.
+func (i Info) String() string {
+	s := "ollama-proxy " + i.Version
+	if i.Commit != "" {
+		c := i.Commit
+		if len(c) > 12 {
+			c = c[:12]
+		}
+		if i.Modified {
+			c += "-dirty"
+		}
+		s += " (" + c
+		if i.Date != "" {
+			s += ", " + i.Date
+		}
+		s += ")"
+	}
+	return fmt.Sprintf("%s %s %s", s, i.GoVersion, i.Platform)
+}
+
+// Handler serves the build information as JSON.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(Get())
+	})
+}
@@ -0,0 +1,14 @@
+package buildinfo
+
+import "testing"
+
+func TestString(t *testing.T) {
+	i := Info{Version: "v1.4.0", Commit: "5d80f948bf73a1b2c3d4", Date: "2026-10-16T09:12:44Z", Modified: true, GoVersion: "go1.21.13", Platform: "linux/amd64"}
+	if got, want := i.String(), "ollama-proxy v1.4.0 (5d80f948bf73-dirty, 2026-10-16T09:12:44Z) go1.21.13 linux/amd64"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	i = Info{Version: "dev", GoVersion: "go1.21.13", Platform: "linux/amd64"}
+	if got, want := i.String(), "ollama-proxy dev go1.21.13 linux/amd64"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
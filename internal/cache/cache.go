@@ -0,0 +1,300 @@
+// Package cache answers repeated requests from stored responses. Complete
+// (non-streaming) responses to embed, chat and generate requests are kept
+// under a hash of the endpoint, model and normalized request body, so an
+// identical request is answered without reaching the upstream.
+package cache
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yeti47/ollama-proxy/internal/apierror"
+	"github.com/yeti47/ollama-proxy/internal/audit"
+	"github.com/yeti47/ollama-proxy/internal/auth"
+	"github.com/yeti47/ollama-proxy/internal/metrics"
+	"github.com/yeti47/ollama-proxy/internal/ollama"
+)
+
+// Header tells the client how the cache handled its request: HIT, MISS
+// or BYPASS.
+const Header = "X-Proxy-Cache"
+
+// Defaults for the flags.
+const (
+	DefaultTTL           = 10 * time.Minute
+	DefaultMaxBytes      = 256 << 20
+	DefaultMaxEntryBytes = 4 << 20
+)
+
+var (
+	requests = metrics.NewCounter("ollama_proxy_cache_requests_total",
+		"Cacheable requests by result: hit, miss or bypass.", "result")
+	evictions = metrics.NewCounter("ollama_proxy_cache_evictions_total",
+		"Entries evicted from the in-memory response cache to make room.")
+	cachedBytes = metrics.NewGauge("ollama_proxy_cache_bytes",
+		"Size of the bodies in the in-memory response cache.")
+	cachedEntries = metrics.NewGauge("ollama_proxy_cache_entries",
+		"Entries in the in-memory response cache.")
+)
+
+// Entry is a stored response.
+type Entry struct {
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body"`
+	Stored time.Time   `json:"stored"`
+}
+
+func (e *Entry) size() int64 { return int64(len(e.Body)) }
+
+// Store holds entries. Get returns nil, nil for a missing entry.
+type Store interface {
+	Get(ctx context.Context, key string) (*Entry, error)
+	Set(ctx context.Context, key string, e *Entry, ttl time.Duration) error
+	Purger
+}
+
+// Generations says which chat and generate responses are cached.
+type Generations string
+
+const (
+	// Deterministic caches generations with a temperature of 0 or a
+	// fixed seed, which an identical request would reproduce.
+	Deterministic Generations = "deterministic"
+	All           Generations = "all"
+	None          Generations = "none"
+)
+
+// ParseGenerations reads the -cache-generations flag.
+func ParseGenerations(s string) (Generations, error) {
+	switch g := Generations(s); g {
+	case Deterministic, All, None:
+		return g, nil
+	}
+	return "", fmt.Errorf("want deterministic, all or none, got %q", s)
+}
+
+// Cache is the response cache middleware.
+type Cache struct {
+	Store Store
+	TTL   time.Duration
+	// MaxEntryBytes bounds the responses stored.
+	MaxEntryBytes int64
+	// Shared lets clients get responses cached for other clients. By
+	// default entries are kept per tenant, or per client without one.
+	Shared      bool
+	Generations Generations
+}
+
+// Key returns the cache key of a request to path with body by scope, and
+// whether the request may be cached at all.
+func (c *Cache) Key(path, scope string, body []byte) (string, bool) {
+	var req map[string]any
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if dec.Decode(&req) != nil {
+		return "", false
+	}
+	switch path {
+	case "/api/embed", "/api/embeddings":
+	case "/api/chat", "/api/generate":
+		// Ollama streams unless told otherwise
+		if stream, ok := req["stream"].(bool); !ok || stream || !c.generation(req) {
+			return "", false
+		}
+	default:
+		return "", false
+	}
+	// fields that don't change the response
+	delete(req, "keep_alive")
+	delete(req, "stream")
+	if model, ok := req["model"].(string); ok {
+		req["model"] = ollama.NormalizeModel(model)
+	}
+	// map keys are encoded in order, which normalizes the body
+	b, err := json.Marshal(req)
+	if err != nil {
+		return "", false
+	}
+	h := sha256.New()
+	h.Write([]byte(path + "\n" + scope + "\n"))
+	h.Write(b)
+	return hex.EncodeToString(h.Sum(nil)), true
+}
+
+// generation reports whether a chat or generate request may be cached.
+func (c *Cache) generation(req map[string]any) bool {
+	switch c.Generations {
+	case All:
+		return true
+	case None:
+		return false
+	}
+	opts, _ := req["options"].(map[string]any)
+	if seed, ok := opts["seed"]; ok && seed != nil {
+		return true
+	}
+	t, ok := opts["temperature"].(json.Number)
+	return ok && t.String() != "" && strings.Trim(t.String(), "0.") == ""
+}
+
+// scope returns who a request's entries are shared with.
+func (c *Cache) scope(r *http.Request) string {
+	if c.Shared {
+		return ""
+	}
+	id := auth.FromContext(r.Context())
+	switch {
+	case id == nil:
+		return ""
+	case id.Tenant != "":
+		return "tenant:" + id.Tenant
+	default:
+		return "client:" + id.Name
+	}
+}
+
+// Middleware answers cacheable requests from the store and stores the
+// responses of next to those it doesn't have. Clients can send
+// Cache-Control: no-cache to skip the lookup, or no-store to bypass the
+// cache entirely.
+func (c *Cache) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			next.ServeHTTP(w, r)
+			return
+		}
+		body, err := ollama.PeekBody(r)
+		if err != nil {
+			apierror.BodyError(w, err)
+			return
+		}
+		key, ok := c.Key(r.URL.Path, c.scope(r), body)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cc := strings.ToLower(r.Header.Get("Cache-Control"))
+		if strings.Contains(cc, "no-store") {
+			requests.With("bypass").Inc()
+			w.Header().Set(Header, "BYPASS")
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !strings.Contains(cc, "no-cache") {
+			e, err := c.Store.Get(r.Context(), key)
+			if err != nil {
+				slog.WarnContext(r.Context(), "response cache lookup failed", "err", err)
+			}
+			if e != nil {
+				requests.With("hit").Inc()
+				serve(w, e, "HIT")
+				return
+			}
+		}
+		requests.With("miss").Inc()
+
+		// the body is stored as sent, so it must arrive uncompressed
+		r.Header.Del("Accept-Encoding")
+		rec := &recorder{ResponseWriter: w, max: c.MaxEntryBytes}
+		next.ServeHTTP(rec, r)
+		mt, _, _ := mime.ParseMediaType(rec.Header().Get("Content-Type"))
+		if rec.status != http.StatusOK || mt != "application/json" || rec.over || r.Context().Err() != nil {
+			return
+		}
+		e := &Entry{Header: http.Header{}, Body: rec.buf.Bytes(), Stored: time.Now().UTC()}
+		e.Header.Set("Content-Type", rec.Header().Get("Content-Type"))
+		if err := c.Store.Set(context.WithoutCancel(r.Context()), key, e, c.TTL); err != nil {
+			slog.WarnContext(r.Context(), "storing response in cache failed", "err", err)
+		}
+	})
+}
+
+// serve writes a stored response, reporting result in Header.
+func serve(w http.ResponseWriter, e *Entry, result string) {
+	for k, v := range e.Header {
+		w.Header()[k] = append([]string(nil), v...)
+	}
+	w.Header().Set(Header, result)
+	w.Header().Set("Age", strconv.Itoa(int(time.Since(e.Stored).Seconds())))
+	w.Header().Set("Content-Length", strconv.Itoa(len(e.Body)))
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(e.Body)
+}
+
+// recorder passes a response through and keeps a copy of its body, up to
+// max bytes.
+type recorder struct {
+	http.ResponseWriter
+	max    int64
+	status int
+	buf    bytes.Buffer
+	over   bool
+}
+
+func (rec *recorder) WriteHeader(code int) {
+	if rec.status != 0 {
+		return
+	}
+	rec.status = code
+	rec.Header().Set(Header, "MISS")
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *recorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.WriteHeader(http.StatusOK)
+	}
+	if !rec.over {
+		if int64(rec.buf.Len()+len(b)) > rec.max {
+			rec.over = true
+			rec.buf = bytes.Buffer{}
+		} else {
+			rec.buf.Write(b)
+		}
+	}
+	return rec.ResponseWriter.Write(b)
+}
+
+func (rec *recorder) Flush() {
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (rec *recorder) Unwrap() http.ResponseWriter { return rec.ResponseWriter }
+
+// Purger is a cache the admin API can empty.
+type Purger interface {
+	Purge(ctx context.Context) error
+}
+
+// AdminHandler serves DELETE /admin/cache, which empties caches. With a
+// shared store, that invalidates the responses cached by every replica.
+func AdminHandler(caches ...Purger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			apierror.Write(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		for _, c := range caches {
+			if err := c.Purge(r.Context()); err != nil {
+				slog.ErrorContext(r.Context(), "admin: purging response cache failed", "err", err)
+				apierror.Write(w, http.StatusBadGateway, "purging the cache failed: "+err.Error())
+				return
+			}
+		}
+		slog.InfoContext(r.Context(), "admin: purged response cache")
+		audit.Event(audit.AdminChange, r, "", "purged response cache")
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
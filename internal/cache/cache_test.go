@@ -0,0 +1,497 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/yeti47/ollama-proxy/internal/auth"
+	"github.com/yeti47/ollama-proxy/internal/ollama"
+)
+
+func TestKey(t *testing.T) {
+	c := &Cache{Generations: Deterministic}
+	a, ok := c.Key("/api/embed", "", []byte(`{"model":"nomic","input":"x","keep_alive":"5m"}`))
+	b, _ := c.Key("/api/embed", "", []byte(`{"input":"x", "model":"nomic:latest"}`))
+	if !ok || a != b {
+		t.Errorf("equivalent requests: %q, %q", a, b)
+	}
+	if o, _ := c.Key("/api/embed", "client:a", []byte(`{"input":"x","model":"nomic"}`)); o == a {
+		t.Error("scope ignored")
+	}
+	for body, want := range map[string]bool{
+		`{"model":"m","prompt":"x"}`:                                              false,
+		`{"model":"m","prompt":"x","stream":false}`:                               false,
+		`{"model":"m","prompt":"x","stream":false,"options":{"temperature":0.7}}`: false,
+		`{"model":"m","prompt":"x","stream":false,"options":{"temperature":0}}`:   true,
+		`{"model":"m","prompt":"x","stream":false,"options":{"seed":42}}`:         true,
+		`{"model":"m","prompt":"x","stream":true,"options":{"seed":42}}`:          false,
+	} {
+		if _, ok := c.Key("/api/generate", "", []byte(body)); ok != want {
+			t.Errorf("%s: cacheable %v", body, ok)
+		}
+	}
+	c.Generations = All
+	if _, ok := c.Key("/api/chat", "", []byte(`{"model":"m","messages":[],"stream":false}`)); !ok {
+		t.Error("all: chat not cacheable")
+	}
+	if _, ok := c.Key("/api/pull", "", []byte(`{"model":"m"}`)); ok {
+		t.Error("pull cacheable")
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	calls := 0
+	c := &Cache{Store: NewMemory(1 << 20), TTL: time.Minute, MaxEntryBytes: 1 << 10, Generations: Deterministic}
+	h := c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		_, _ = w.Write([]byte(`{"embeddings":[[1,2]]}`))
+	}))
+	do := func(client, cc string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/api/embed", strings.NewReader(`{"model":"nomic","input":"x"}`))
+		r.Header.Set("Cache-Control", cc)
+		r = r.WithContext(auth.WithIdentity(context.Background(), &auth.Identity{Name: client}))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		return rec
+	}
+	for i, want := range []struct {
+		client, cc, result string
+		calls              int
+	}{
+		{"a", "", "MISS", 1},
+		{"a", "", "HIT", 1},
+		{"b", "", "MISS", 2},
+		{"a", "no-cache", "MISS", 3},
+		{"a", "no-store", "BYPASS", 4},
+		{"a", "", "HIT", 4},
+	} {
+		rec := do(want.client, want.cc)
+		if got := rec.Header().Get(Header); got != want.result || calls != want.calls {
+			t.Errorf("#%d: %s after %d calls, want %s after %d", i, got, calls, want.result, want.calls)
+		}
+		if rec.Body.String() != `{"embeddings":[[1,2]]}` {
+			t.Errorf("#%d: body %s", i, rec.Body)
+		}
+	}
+}
+
+func TestMemory(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory(10)
+	for _, k := range []string{"a", "b", "c"} {
+		_ = m.Set(ctx, k, &Entry{Body: []byte("1234")}, time.Minute)
+	}
+	if e, _ := m.Get(ctx, "a"); e != nil || m.Len() != 2 {
+		t.Errorf("a not evicted, %d entries", m.Len())
+	}
+	// b is now the most recently used, so d evicts c
+	_, _ = m.Get(ctx, "b")
+	_ = m.Set(ctx, "d", &Entry{Body: []byte("1234")}, time.Minute)
+	if e, _ := m.Get(ctx, "c"); e != nil {
+		t.Error("c not evicted")
+	}
+	if e, _ := m.Get(ctx, "b"); e == nil {
+		t.Error("b evicted")
+	}
+	_ = m.Set(ctx, "big", &Entry{Body: make([]byte, 11)}, time.Minute)
+	if e, _ := m.Get(ctx, "big"); e != nil {
+		t.Error("oversized entry stored")
+	}
+	_ = m.Set(ctx, "old", &Entry{Body: []byte("1")}, -time.Second)
+	if e, _ := m.Get(ctx, "old"); e != nil {
+		t.Error("expired entry returned")
+	}
+}
+
+// fakeRedis serves GET, SET, SCAN, DEL, AUTH and SELECT from a map.
+func fakeRedis(t *testing.T) (string, map[string]string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	var mu sync.Mutex
+	data := map[string]string{}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					v, err := readReply(r)
+					if err != nil {
+						return
+					}
+					var args []string
+					for _, a := range v.([]any) {
+						args = append(args, string(a.([]byte)))
+					}
+					mu.Lock()
+					switch args[0] {
+					case "AUTH":
+						if args[1] != "secret" {
+							fmt.Fprint(conn, "-WRONGPASS invalid password\r\n")
+							break
+						}
+						fmt.Fprint(conn, "+OK\r\n")
+					case "SELECT":
+						fmt.Fprint(conn, "+OK\r\n")
+					case "SET":
+						data[args[1]] = args[2]
+						fmt.Fprint(conn, "+OK\r\n")
+					case "GET":
+						if v, ok := data[args[1]]; ok {
+							fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(v), v)
+						} else {
+							fmt.Fprint(conn, "$-1\r\n")
+						}
+					case "SCAN":
+						prefix := strings.TrimSuffix(args[3], "*")
+						var keys []string
+						for k := range data {
+							if strings.HasPrefix(k, prefix) {
+								keys = append(keys, k)
+							}
+						}
+						fmt.Fprintf(conn, "*2\r\n$1\r\n0\r\n*%d\r\n", len(keys))
+						for _, k := range keys {
+							fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(k), k)
+						}
+					case "DEL":
+						for _, k := range args[1:] {
+							delete(data, k)
+						}
+						fmt.Fprintf(conn, ":%d\r\n", len(args)-1)
+					default:
+						fmt.Fprintf(conn, "-ERR unknown command '%s'\r\n", args[0])
+					}
+					mu.Unlock()
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String(), data
+}
+
+func TestRedis(t *testing.T) {
+	ctx := context.Background()
+	addr, data := fakeRedis(t)
+	if rd, _ := NewRedis("redis://:wrong@"+addr, "p:"); rd != nil {
+		if _, err := rd.Get(ctx, "k"); err == nil || !strings.Contains(err.Error(), "WRONGPASS") {
+			t.Errorf("bad password: %v", err)
+		}
+	}
+	rd, err := NewRedis("redis://:secret@"+addr+"/2", "p:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rd.Close()
+	if e, err := rd.Get(ctx, "k"); e != nil || err != nil {
+		t.Errorf("missing entry: %v, %v", e, err)
+	}
+	want := &Entry{Header: http.Header{"Content-Type": {"application/json"}}, Body: []byte(`{"a":1}`), Stored: time.Unix(1, 0).UTC()}
+	if err := rd.Set(ctx, "k", want, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	data["other"] = "x"
+	e, err := rd.Get(ctx, "k")
+	if err != nil || e == nil || string(e.Body) != `{"a":1}` || e.Header.Get("Content-Type") != "application/json" || !e.Stored.Equal(want.Stored) {
+		t.Fatalf("got %+v, %v", e, err)
+	}
+	if err := rd.Purge(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if e, _ := rd.Get(ctx, "k"); e != nil {
+		t.Error("entry survived purge")
+	}
+	if _, ok := data["other"]; !ok {
+		t.Error("purge deleted a key outside the prefix")
+	}
+
+	for _, u := range []string{"http://host", "redis://", "redis://host/db"} {
+		if _, err := NewRedis(u, "p:"); err == nil {
+			t.Errorf("%s accepted", u)
+		}
+	}
+}
+
+func TestSemantic(t *testing.T) {
+	vectors := map[string]string{
+		"What is the capital of France?":     "[1,0.1,0]",
+		"what's the capital of France":       "[1,0.12,0]",
+		"How do I bake bread?":               "[0,0.2,1]",
+		"What is the capital of France?!!!!": "[]",
+	}
+	calls := 0
+	s := &Semantic{Model: "nomic-embed-text", Threshold: 0.95, TTL: time.Minute, MaxEntries: 10, MaxEntryBytes: 1 << 10}
+	h := s.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/api/embed" {
+			var req struct{ Model, Input string }
+			_ = json.Unmarshal(body, &req)
+			if req.Model != "nomic-embed-text" {
+				t.Errorf("embedded with %s", req.Model)
+			}
+			fmt.Fprintf(w, `{"embeddings":[%s]}`, vectors[req.Input])
+			return
+		}
+		calls++
+		fmt.Fprintf(w, `{"response":"answer %d"}`, calls)
+	}))
+	for i, c := range []struct {
+		body, result, want string
+	}{
+		{`{"model":"llama3","prompt":"What is the capital of France?","stream":false}`, "MISS", "answer 1"},
+		{`{"model":"llama3:latest","prompt":"what's the capital of France","stream":false}`, "HIT", "answer 1"},
+		{`{"model":"llama3","prompt":"How do I bake bread?","stream":false}`, "MISS", "answer 2"},
+		// everything but the prompt must match
+		{`{"model":"mistral","prompt":"what's the capital of France","stream":false}`, "MISS", "answer 3"},
+		{`{"model":"llama3","prompt":"What is the capital of France?","stream":false,"system":"Be brief."}`, "MISS", "answer 4"},
+		// streamed requests and failed embeddings go through
+		{`{"model":"llama3","prompt":"What is the capital of France?"}`, "", "answer 5"},
+		{`{"model":"llama3","prompt":"What is the capital of France?!!!!","stream":false}`, "", "answer 6"},
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(c.body)))
+		if got := rec.Header().Get(Header); got != c.result || !strings.Contains(rec.Body.String(), c.want) {
+			t.Errorf("#%d: %q %s, want %q %s", i, got, rec.Body, c.result, c.want)
+		}
+	}
+
+	// chats compare their last user message
+	chat := func(q string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		body := `{"model":"llama3","stream":false,"messages":[{"role":"system","content":"Be brief."},{"role":"user","content":"` + q + `"}]}`
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/chat", strings.NewReader(body)))
+		return rec
+	}
+	chat("What is the capital of France?")
+	if rec := chat("what's the capital of France"); rec.Header().Get(Header) != "HIT" || rec.Header().Get(SimilarityHeader) == "" {
+		t.Errorf("chat: %q", rec.Header().Get(Header))
+	}
+
+	_ = s.Purge(context.Background())
+	if rec := chat("what's the capital of France"); rec.Header().Get(Header) != "MISS" {
+		t.Errorf("after purge: %q", rec.Header().Get(Header))
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	calls, fail := 0, false
+	m := &Metadata{Upstream: "http://ollama:11434", TTL: time.Hour, MaxStale: time.Hour}
+	h := m.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if fail {
+			http.Error(w, "bad gateway", http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"models":[],"n":%d}`, calls)
+	}))
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rec
+	}
+	check := func(step string, rec *httptest.ResponseRecorder, result string, n int) {
+		t.Helper()
+		if got := rec.Header().Get(Header); got != result || !strings.Contains(rec.Body.String(), fmt.Sprintf(`"n":%d`, n)) {
+			t.Errorf("%s: %q %s, want %q n=%d", step, got, rec.Body, result, n)
+		}
+	}
+	check("first", do(http.MethodGet, "/api/tags", ""), "MISS", 1)
+	check("repeat", do(http.MethodGet, "/api/tags", ""), "HIT", 1)
+	check("show", do(http.MethodPost, "/api/show", `{"model":"llama3"}`), "MISS", 2)
+	check("show by name", do(http.MethodPost, "/api/show", `{"name":"llama3:latest"}`), "HIT", 2)
+
+	// a pull changes the list
+	do(http.MethodPost, "/api/pull", `{"model":"mistral"}`)
+	check("after pull", do(http.MethodGet, "/api/tags", ""), "MISS", 4)
+
+	// expired entries are served while the upstream fails
+	m.mu.Lock()
+	for _, me := range m.entries {
+		me.expires = time.Now().Add(-time.Minute)
+	}
+	m.mu.Unlock()
+	fail = true
+	rec := do(http.MethodGet, "/api/tags", "")
+	check("stale", rec, "STALE", 4)
+	if rec.Header().Get("Warning") == "" {
+		t.Error("stale response without Warning")
+	}
+	if rec := do(http.MethodPost, "/api/show", `{"model":"mistral"}`); rec.Code != http.StatusBadGateway {
+		t.Errorf("uncached show while failing: %d", rec.Code)
+	}
+	fail = false
+	check("recovered", do(http.MethodGet, "/api/tags", ""), "MISS", 7)
+}
+
+func TestDedup(t *testing.T) {
+	var calls atomic.Int32
+	entered, release := make(chan struct{}), make(chan struct{})
+	h := (&Dedup{}).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			close(entered)
+			<-release
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"embeddings":[[%d]]}`, calls.Load())
+	}))
+	do := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/embed", strings.NewReader(body)))
+		return rec
+	}
+	const body = `{"model":"nomic","input":"x"}`
+	recs := make([]*httptest.ResponseRecorder, 4)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() { defer wg.Done(); recs[0] = do(body) }()
+	<-entered
+	for i := 1; i < len(recs); i++ {
+		wg.Add(1)
+		go func(i int) { defer wg.Done(); recs[i] = do(body) }(i)
+	}
+	// a different body isn't collapsed
+	if rec := do(`{"model":"nomic","input":"y"}`); rec.Body.String() != `{"embeddings":[[2]]}` {
+		t.Errorf("other request: %s", rec.Body)
+	}
+	time.Sleep(50 * time.Millisecond) // let the duplicates queue up
+	close(release)
+	wg.Wait()
+	for i, rec := range recs {
+		if rec.Code != http.StatusOK || rec.Body.String() != `{"embeddings":[[2]]}` {
+			t.Errorf("#%d: %d %s", i, rec.Code, rec.Body)
+		}
+		if dup := rec.Header().Get(DedupHeader) != ""; dup != (i > 0) {
+			t.Errorf("#%d: deduplicated %v", i, dup)
+		}
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("%d upstream calls", n)
+	}
+
+	// streamed requests are never collapsed
+	if _, ok := dedupKey(httptest.NewRequest(http.MethodPost, "/api/chat", nil), []byte(`{"model":"m","messages":[]}`)); ok {
+		t.Error("streamed chat deduplicated")
+	}
+}
+
+func TestDedupAbort(t *testing.T) {
+	var calls atomic.Int32
+	entered, release := make(chan struct{}), make(chan struct{})
+	h := (&Dedup{}).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			close(entered)
+			<-release
+			// what the reverse proxy does when the upstream breaks off
+			panic(http.ErrAbortHandler)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"embeddings":[[1]]}`)
+	}))
+	do := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/embed", strings.NewReader(`{"model":"nomic","input":"x"}`)))
+		return rec
+	}
+
+	panicked := make(chan any, 1)
+	go func() {
+		defer func() { panicked <- recover() }()
+		do()
+	}()
+	<-entered
+	follower := make(chan *httptest.ResponseRecorder, 1)
+	go func() { follower <- do() }()
+	time.Sleep(50 * time.Millisecond) // let the follower queue up
+	close(release)
+	if p := <-panicked; p != http.ErrAbortHandler {
+		t.Errorf("leader: recovered %v", p)
+	}
+	select {
+	case rec := <-follower:
+		if rec.Code != http.StatusOK || rec.Header().Get(DedupHeader) != "" {
+			t.Errorf("follower: %d, deduplicated %q", rec.Code, rec.Header().Get(DedupHeader))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("follower stuck after the leader panicked")
+	}
+
+	// the key is free again
+	if rec := do(); rec.Code != http.StatusOK || rec.Header().Get(DedupHeader) != "" {
+		t.Errorf("next request: %d, deduplicated %q", rec.Code, rec.Header().Get(DedupHeader))
+	}
+	if n := calls.Load(); n != 3 {
+		t.Errorf("%d upstream calls, want 3", n)
+	}
+}
+
+func TestDedupScope(t *testing.T) {
+	var calls atomic.Int32
+	entered, release := make(chan struct{}), make(chan struct{})
+	var mu sync.Mutex
+	charged := map[string]int{}
+	d := &Dedup{OnUsage: func(r *http.Request, u ollama.Usage) {
+		mu.Lock()
+		charged[auth.FromContext(r.Context()).Name] += u.CompletionTokens
+		mu.Unlock()
+	}}
+	h := d.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			close(entered)
+			<-release
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"model":"llama3","response":"hi","done":true,"prompt_eval_count":3,"eval_count":5}`)
+	}))
+	do := func(client string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(`{"model":"llama3","prompt":"hi","stream":false}`))
+		r = r.WithContext(auth.WithIdentity(r.Context(), &auth.Identity{Name: client}))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		return rec
+	}
+	var wg sync.WaitGroup
+	var first, follower *httptest.ResponseRecorder
+	wg.Add(2)
+	go func() { defer wg.Done(); first = do("alice") }()
+	<-entered
+	go func() { defer wg.Done(); follower = do("alice") }()
+	// the same body from another client gets its own call
+	if rec := do("bob"); rec.Header().Get(DedupHeader) != "" {
+		t.Error("requests of different clients collapsed")
+	}
+	time.Sleep(50 * time.Millisecond) // let the duplicate queue up
+	close(release)
+	wg.Wait()
+	if first.Header().Get(DedupHeader) != "" || follower.Header().Get(DedupHeader) == "" {
+		t.Errorf("deduplicated: first %q, follower %q", first.Header().Get(DedupHeader), follower.Header().Get(DedupHeader))
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("%d upstream calls, want 2", n)
+	}
+	// the upstream call is charged by the proxy; only the follower here
+	if charged["alice"] != 5 || charged["bob"] != 0 {
+		t.Errorf("charged %v", charged)
+	}
+}
@@ -0,0 +1,149 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/yeti47/ollama-proxy/internal/apierror"
+	"github.com/yeti47/ollama-proxy/internal/metrics"
+	"github.com/yeti47/ollama-proxy/internal/ollama"
+)
+
+// DedupHeader marks responses shared with an identical request in flight.
+const DedupHeader = "X-Proxy-Deduplicated"
+
+var deduplicated = metrics.NewCounter("ollama_proxy_deduplicated_requests_total",
+	"Requests answered with the response to an identical request in flight, by path.", "path")
+
+// Dedup collapses byte-identical non-streaming requests that arrive while
+// one of them is in flight into that one upstream call, and gives each
+// the response. Requests only count as identical from the same tenant, or
+// the same client without one, as system prompts, templates and rewrites
+// differ between them.
+type Dedup struct {
+	// OnUsage is called with the token counts of the responses shared,
+	// so quotas, budgets and rate limits charge every request.
+	OnUsage func(*http.Request, ollama.Usage)
+
+	mu    sync.Mutex
+	calls map[string]*call
+}
+
+// call is a request in flight and, once done is closed, its response.
+type call struct {
+	done   chan struct{}
+	status int
+	header http.Header
+	body   []byte
+	// failed is set when the first request was cancelled or its handler
+	// panicked, which leaves nothing to share.
+	failed bool
+}
+
+// dedupKey returns the key of a request, and whether it may be shared.
+func dedupKey(r *http.Request, body []byte) (string, bool) {
+	switch r.URL.Path {
+	case "/api/embed", "/api/embeddings":
+	case "/api/chat", "/api/generate":
+		var req struct {
+			Stream *bool `json:"stream"`
+		}
+		if json.Unmarshal(body, &req) != nil || req.Stream == nil || *req.Stream {
+			return "", false
+		}
+	default:
+		return "", false
+	}
+	h := sha256.New()
+	h.Write([]byte(r.URL.Path + "\n" + (&Cache{}).scope(r) + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil)), true
+}
+
+// Middleware shares the responses of next between identical requests in
+// flight.
+func (d *Dedup) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			next.ServeHTTP(w, r)
+			return
+		}
+		body, err := ollama.PeekBody(r)
+		if err != nil {
+			apierror.BodyError(w, err)
+			return
+		}
+		key, ok := dedupKey(r, body)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		d.mu.Lock()
+		if c, ok := d.calls[key]; ok {
+			d.mu.Unlock()
+			select {
+			case <-c.done:
+			case <-r.Context().Done():
+				return
+			}
+			if c.failed {
+				next.ServeHTTP(w, r)
+				return
+			}
+			deduplicated.With(r.URL.Path).Inc()
+			slog.DebugContext(r.Context(), "request deduplicated", "path", r.URL.Path)
+			w.Header().Set(DedupHeader, "true")
+			c.write(w)
+			if u, ok := ollama.ParseUsage(c.body); ok && c.status == http.StatusOK && d.OnUsage != nil {
+				d.OnUsage(r, u)
+			}
+			return
+		}
+		c := &call{done: make(chan struct{})}
+		if d.calls == nil {
+			d.calls = map[string]*call{}
+		}
+		d.calls[key] = c
+		d.mu.Unlock()
+
+		// the response is needed whole, and uncompressed, to share it; it is
+		// a single JSON object, as the request doesn't stream
+		r.Header.Del("Accept-Encoding")
+		d.lead(key, c, next, r)
+		c.write(w)
+	})
+}
+
+// lead serves r, the first request for key, and hands the response to
+// the requests waiting on c. They are released even if next panics, as
+// the reverse proxy does with http.ErrAbortHandler when the upstream or
+// the client breaks off; they then send their own request.
+func (d *Dedup) lead(key string, c *call, next http.Handler, r *http.Request) {
+	c.failed = true // unless next returns
+	defer func() {
+		d.mu.Lock()
+		delete(d.calls, key)
+		d.mu.Unlock()
+		close(c.done)
+	}()
+	rec := &collector{header: http.Header{}}
+	next.ServeHTTP(rec, r)
+	rec.WriteHeader(http.StatusOK)
+	c.status, c.header, c.body = rec.status, rec.header, rec.body.Bytes()
+	c.failed = r.Context().Err() != nil
+}
+
+// write writes the response of c to w.
+func (c *call) write(w http.ResponseWriter) {
+	for k, v := range c.header {
+		w.Header()[k] = append([]string(nil), v...)
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(c.body)))
+	w.WriteHeader(c.status)
+	_, _ = w.Write(c.body)
+}
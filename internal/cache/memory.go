@@ -0,0 +1,101 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// Memory is a Store in process memory, evicting the least recently used
+// entries beyond its size limit.
+type Memory struct {
+	// MaxBytes bounds the size of the bodies held.
+	MaxBytes int64
+
+	mu    sync.Mutex
+	lru   *list.List // of *memEntry, most recently used first
+	items map[string]*list.Element
+	size  int64
+}
+
+type memEntry struct {
+	key     string
+	e       *Entry
+	expires time.Time
+}
+
+// NewMemory returns an empty Memory store.
+func NewMemory(maxBytes int64) *Memory {
+	return &Memory{MaxBytes: maxBytes, lru: list.New(), items: map[string]*list.Element{}}
+}
+
+// Get returns the entry for key, or nil if there is none or it expired.
+func (m *Memory) Get(_ context.Context, key string) (*Entry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	el, ok := m.items[key]
+	if !ok {
+		return nil, nil
+	}
+	me := el.Value.(*memEntry)
+	if time.Now().After(me.expires) {
+		m.remove(el)
+		return nil, nil
+	}
+	m.lru.MoveToFront(el)
+	return me.e, nil
+}
+
+// Set stores e under key for ttl, evicting older entries to make room.
+// Entries bigger than MaxBytes are not stored.
+func (m *Memory) Set(_ context.Context, key string, e *Entry, ttl time.Duration) error {
+	n := e.size()
+	if n > m.MaxBytes {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if el, ok := m.items[key]; ok {
+		m.remove(el)
+	}
+	m.items[key] = m.lru.PushFront(&memEntry{key: key, e: e, expires: time.Now().Add(ttl)})
+	m.size += n
+	for m.size > m.MaxBytes {
+		m.remove(m.lru.Back())
+		evictions.With().Inc()
+	}
+	m.report()
+	return nil
+}
+
+// Purge removes every entry.
+func (m *Memory) Purge(context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lru.Init()
+	m.items = map[string]*list.Element{}
+	m.size = 0
+	m.report()
+	return nil
+}
+
+// Len reports the number of entries, including expired ones not yet
+// evicted.
+func (m *Memory) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.lru.Len()
+}
+
+func (m *Memory) remove(el *list.Element) {
+	me := m.lru.Remove(el).(*memEntry)
+	delete(m.items, me.key)
+	m.size -= me.e.size()
+	m.report()
+}
+
+func (m *Memory) report() {
+	cachedBytes.With().Set(float64(m.size))
+	cachedEntries.With().Set(float64(m.lru.Len()))
+}
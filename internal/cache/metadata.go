@@ -0,0 +1,160 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yeti47/ollama-proxy/internal/auth"
+	"github.com/yeti47/ollama-proxy/internal/metrics"
+	"github.com/yeti47/ollama-proxy/internal/ollama"
+)
+
+// DefaultMaxStale is how long Metadata serves an expired entry while the
+// upstream fails.
+const DefaultMaxStale = 10 * time.Minute
+
+var metadataRequests = metrics.NewCounter("ollama_proxy_metadata_cache_requests_total",
+	"Model list and model information requests by endpoint and result: hit, miss or stale (served expired while the upstream failed).", "endpoint", "result")
+
+// Metadata caches the model list (/api/tags) and model information
+// (/api/show), which clients such as IDE plugins poll every few seconds.
+// Entries are kept per upstream: the target and, for tenants with their
+// own upstream key, that key. When the upstream fails, expired entries are
+// served for up to MaxStale more.
+type Metadata struct {
+	// Upstream names the target, so entries don't outlive a change of it.
+	Upstream string
+	TTL      time.Duration
+	MaxStale time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*metaEntry
+}
+
+type metaEntry struct {
+	e       *Entry
+	expires time.Time
+}
+
+// key returns the key of a metadata request, and whether it is one.
+func (m *Metadata) key(r *http.Request) (string, bool) {
+	var body []byte
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/api/tags":
+	case r.Method == http.MethodPost && r.URL.Path == "/api/show":
+		b, err := ollama.PeekBody(r)
+		if err != nil {
+			return "", false
+		}
+		var req map[string]any
+		if json.Unmarshal(b, &req) != nil {
+			return "", false
+		}
+		// show takes "model", or "name" from older clients
+		model := ollama.Model(b)
+		if model == "" {
+			return "", false
+		}
+		delete(req, "name")
+		req["model"] = ollama.NormalizeModel(model)
+		if body, err = json.Marshal(req); err != nil {
+			return "", false
+		}
+	default:
+		return "", false
+	}
+	h := sha256.New()
+	h.Write([]byte(m.Upstream + "\n"))
+	if id := auth.FromContext(r.Context()); id != nil && id.UpstreamKey != "" {
+		h.Write([]byte(id.UpstreamKey))
+	}
+	h.Write([]byte("\n" + r.URL.Path + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil)), true
+}
+
+// Middleware answers model list and information requests from the cache,
+// and forgets the cached entries once a pull, push, create, copy or
+// delete goes through. Cache-Control: no-cache refreshes an entry.
+func (m *Metadata) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/pull", "/api/push", "/api/create", "/api/copy", "/api/delete":
+			next.ServeHTTP(w, r)
+			_ = m.Purge(r.Context())
+			return
+		}
+		key, ok := m.key(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		endpoint := r.URL.Path
+		m.mu.Lock()
+		me := m.entries[key]
+		m.mu.Unlock()
+		now := time.Now()
+		noCache := strings.Contains(strings.ToLower(r.Header.Get("Cache-Control")), "no-cache")
+		if me != nil && now.Before(me.expires) && !noCache {
+			metadataRequests.With(endpoint, "hit").Inc()
+			serve(w, me.e, "HIT")
+			return
+		}
+
+		// the whole response is needed before deciding between it and a
+		// stale entry; both endpoints answer in one small JSON object
+		sub := r.Clone(r.Context())
+		sub.Header.Del("Accept-Encoding")
+		rec := &collector{header: http.Header{}}
+		next.ServeHTTP(rec, sub)
+		rec.WriteHeader(http.StatusOK)
+		mt, _, _ := mime.ParseMediaType(rec.header.Get("Content-Type"))
+		switch {
+		case rec.status == http.StatusOK && mt == "application/json":
+			e := &Entry{Header: http.Header{}, Body: rec.body.Bytes(), Stored: now.UTC()}
+			e.Header.Set("Content-Type", rec.header.Get("Content-Type"))
+			m.mu.Lock()
+			if m.entries == nil {
+				m.entries = map[string]*metaEntry{}
+			}
+			for k, old := range m.entries {
+				if now.After(old.expires.Add(m.MaxStale)) {
+					delete(m.entries, k)
+				}
+			}
+			m.entries[key] = &metaEntry{e: e, expires: now.Add(m.TTL)}
+			m.mu.Unlock()
+			metadataRequests.With(endpoint, "miss").Inc()
+		case rec.status >= http.StatusInternalServerError && me != nil && now.Before(me.expires.Add(m.MaxStale)) && r.Context().Err() == nil:
+			metadataRequests.With(endpoint, "stale").Inc()
+			slog.WarnContext(r.Context(), "upstream failed, serving cached metadata", "path", endpoint, "status", rec.status, "age", now.Sub(me.e.Stored).Round(time.Second))
+			w.Header().Set("Warning", `110 - "Response is Stale"`)
+			serve(w, me.e, "STALE")
+			return
+		}
+		for k, v := range rec.header {
+			w.Header()[k] = v
+		}
+		w.Header().Set(Header, "MISS")
+		w.Header().Set("Content-Length", strconv.Itoa(rec.body.Len()))
+		w.WriteHeader(rec.status)
+		_, _ = w.Write(rec.body.Bytes())
+	})
+}
+
+// Purge implements Purger.
+func (m *Metadata) Purge(context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries = nil
+	return nil
+}
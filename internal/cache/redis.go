@@ -0,0 +1,277 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultRedisPrefix is put before the keys the Redis store writes.
+const DefaultRedisPrefix = "ollama-proxy:cache:"
+
+// redisTimeout bounds a command without a context deadline.
+const redisTimeout = 5 * time.Second
+
+// Redis is a Store on a Redis server, shared by every proxy replica that
+// uses it: a response cached by one is served by all, and a purge empties
+// the cache for all. Entries expire through Redis; eviction beyond its
+// memory limit is up to the server's maxmemory-policy.
+type Redis struct {
+	addr   string
+	tls    bool
+	user   string
+	pass   string
+	db     int
+	prefix string
+
+	idle chan *redisConn
+}
+
+type redisConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+}
+
+// redisError is an error reply. Unlike network errors, it leaves the
+// connection usable.
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+// NewRedis returns a store for rawURL, redis://[user:pass@]host:6379[/db]
+// or rediss:// for TLS, writing keys under prefix. It connects lazily.
+func NewRedis(rawURL, prefix string) (*Redis, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "redis" && u.Scheme != "rediss") || u.Host == "" {
+		return nil, fmt.Errorf("redis url must be redis://host:port/db or rediss://host:port/db")
+	}
+	rd := &Redis{addr: u.Host, tls: u.Scheme == "rediss", prefix: prefix, idle: make(chan *redisConn, 16)}
+	if u.Port() == "" {
+		rd.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		rd.user = u.User.Username()
+		rd.pass, _ = u.User.Password()
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if rd.db, err = strconv.Atoi(db); err != nil || rd.db < 0 {
+			return nil, fmt.Errorf("redis database must be a number, got %q", db)
+		}
+	}
+	return rd, nil
+}
+
+// Get implements Store.
+func (rd *Redis) Get(ctx context.Context, key string) (*Entry, error) {
+	v, err := rd.do(ctx, "GET", rd.prefix+key)
+	if err != nil || v == nil {
+		return nil, err
+	}
+	b, ok := v.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("redis: unexpected GET reply %T", v)
+	}
+	var e Entry
+	if err := json.Unmarshal(b, &e); err != nil {
+		return nil, fmt.Errorf("redis: decoding entry: %w", err)
+	}
+	return &e, nil
+}
+
+// Set implements Store.
+func (rd *Redis) Set(ctx context.Context, key string, e *Entry, ttl time.Duration) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = rd.do(ctx, "SET", rd.prefix+key, string(b), "PX", strconv.FormatInt(max(ttl.Milliseconds(), 1), 10))
+	return err
+}
+
+// Purge implements Store, deleting every key under the prefix.
+func (rd *Redis) Purge(ctx context.Context) error {
+	cursor := "0"
+	for {
+		v, err := rd.do(ctx, "SCAN", cursor, "MATCH", globEscape(rd.prefix)+"*", "COUNT", "500")
+		if err != nil {
+			return err
+		}
+		reply, ok := v.([]any)
+		if !ok || len(reply) != 2 {
+			return fmt.Errorf("redis: unexpected SCAN reply")
+		}
+		next, _ := reply[0].([]byte)
+		keys, _ := reply[1].([]any)
+		if len(keys) > 0 {
+			args := []string{"DEL"}
+			for _, k := range keys {
+				if k, ok := k.([]byte); ok {
+					args = append(args, string(k))
+				}
+			}
+			if _, err := rd.do(ctx, args...); err != nil {
+				return err
+			}
+		}
+		if cursor = string(next); cursor == "0" || cursor == "" {
+			return nil
+		}
+	}
+}
+
+// Close closes the idle connections.
+func (rd *Redis) Close() error {
+	for {
+		select {
+		case c := <-rd.idle:
+			c.conn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+// do runs a command on an idle connection, or a new one, and returns its
+// reply: nil, a string for a status, an int64, []byte for a bulk string
+// or []any for an array.
+func (rd *Redis) do(ctx context.Context, args ...string) (any, error) {
+	var c *redisConn
+	select {
+	case c = <-rd.idle:
+	default:
+		var err error
+		if c, err = rd.dial(ctx); err != nil {
+			return nil, err
+		}
+	}
+	v, err := c.do(ctx, args...)
+	var re redisError
+	if err != nil && !errors.As(err, &re) {
+		c.conn.Close()
+		return nil, err
+	}
+	select {
+	case rd.idle <- c:
+	default:
+		c.conn.Close()
+	}
+	return v, err
+}
+
+// dial connects, authenticates and selects the database.
+func (rd *Redis) dial(ctx context.Context) (*redisConn, error) {
+	d := net.Dialer{Timeout: redisTimeout}
+	conn, err := d.DialContext(ctx, "tcp", rd.addr)
+	if err != nil {
+		return nil, err
+	}
+	if rd.tls {
+		host, _, _ := net.SplitHostPort(rd.addr)
+		tc := tls.Client(conn, &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12})
+		if err := tc.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tc
+	}
+	c := &redisConn{conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn)}
+	var setup [][]string
+	switch {
+	case rd.pass != "" && rd.user != "":
+		setup = append(setup, []string{"AUTH", rd.user, rd.pass})
+	case rd.pass != "" || rd.user != "":
+		// redis://:pass@host, or a bare password in the user part
+		setup = append(setup, []string{"AUTH", rd.pass + rd.user})
+	}
+	if rd.db != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(rd.db)})
+	}
+	for _, args := range setup {
+		if _, err := c.do(ctx, args...); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *redisConn) do(ctx context.Context, args ...string) (any, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(redisTimeout)
+	}
+	_ = c.conn.SetDeadline(deadline)
+	fmt.Fprintf(c.w, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(c.w, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if err := c.w.Flush(); err != nil {
+		return nil, err
+	}
+	return readReply(c.r)
+}
+
+// readReply reads one RESP2 reply.
+func readReply(r *bufio.Reader) (any, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, fmt.Errorf("redis: empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		a := make([]any, n)
+		for i := range a {
+			if a[i], err = readReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return a, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
+
+// globEscape quotes the characters SCAN MATCH treats as wildcards.
+func globEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`*?[]\`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,288 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yeti47/ollama-proxy/internal/apierror"
+	"github.com/yeti47/ollama-proxy/internal/metrics"
+	"github.com/yeti47/ollama-proxy/internal/ollama"
+)
+
+// Defaults for the semantic cache flags.
+const (
+	DefaultThreshold  = 0.95
+	DefaultMaxEntries = 1000
+)
+
+// SimilarityHeader carries the similarity of the prompt a semantic cache
+// hit was stored for.
+const SimilarityHeader = "X-Proxy-Cache-Similarity"
+
+var semanticRequests = metrics.NewCounter("ollama_proxy_semantic_cache_requests_total",
+	"Requests looked up in the semantic cache by result: hit, miss, bypass or error (embedding the prompt failed).", "result")
+
+// Semantic is a response cache for chat and generate requests keyed by
+// the meaning of their prompt: a request whose prompt embeds close enough
+// to that of a stored one, with everything else equal, gets the stored
+// response. Prompts are embedded by the upstream, with Model.
+type Semantic struct {
+	// Model is the embedding model.
+	Model string
+	// Threshold is the cosine similarity from which prompts count as the
+	// same.
+	Threshold     float64
+	TTL           time.Duration
+	MaxEntries    int
+	MaxEntryBytes int64
+	// Shared is as for Cache.
+	Shared bool
+
+	mu      sync.Mutex
+	entries []*semEntry // oldest first
+}
+
+type semEntry struct {
+	// key hashes the request without its prompt, and the scope; only
+	// entries with the same key are compared.
+	key     string
+	vec     []float64
+	e       *Entry
+	expires time.Time
+}
+
+// split returns the prompt of a non-streaming chat or generate request,
+// and the hash of the rest of it. The prompt is the prompt of a generate
+// request, or the content of the last message of a chat, if the user
+// wrote it.
+func split(path, scope string, body []byte) (prompt, key string, ok bool) {
+	var req map[string]any
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if dec.Decode(&req) != nil {
+		return "", "", false
+	}
+	if stream, ok := req["stream"].(bool); !ok || stream {
+		return "", "", false
+	}
+	switch path {
+	case "/api/generate":
+		prompt, _ = req["prompt"].(string)
+		delete(req, "prompt")
+	case "/api/chat":
+		messages, _ := req["messages"].([]any)
+		if len(messages) == 0 {
+			return "", "", false
+		}
+		last, _ := messages[len(messages)-1].(map[string]any)
+		if role, _ := last["role"].(string); role != "user" {
+			return "", "", false
+		}
+		prompt, _ = last["content"].(string)
+		req["messages"] = messages[:len(messages)-1]
+	default:
+		return "", "", false
+	}
+	if strings.TrimSpace(prompt) == "" {
+		return "", "", false
+	}
+	delete(req, "keep_alive")
+	delete(req, "stream")
+	if model, ok := req["model"].(string); ok {
+		req["model"] = ollama.NormalizeModel(model)
+	}
+	b, err := json.Marshal(req)
+	if err != nil {
+		return "", "", false
+	}
+	h := sha256.New()
+	h.Write([]byte(path + "\n" + scope + "\n"))
+	h.Write(b)
+	return prompt, hex.EncodeToString(h.Sum(nil)), true
+}
+
+// Middleware answers chat and generate requests from the semantic cache
+// and stores the responses of next to those it can't. Cache-Control works
+// as for Cache.
+func (s *Semantic) Middleware(next http.Handler) http.Handler {
+	c := &Cache{Shared: s.Shared}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || (r.URL.Path != "/api/chat" && r.URL.Path != "/api/generate") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		body, err := ollama.PeekBody(r)
+		if err != nil {
+			apierror.BodyError(w, err)
+			return
+		}
+		prompt, key, ok := split(r.URL.Path, c.scope(r), body)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cc := strings.ToLower(r.Header.Get("Cache-Control"))
+		if strings.Contains(cc, "no-store") {
+			semanticRequests.With("bypass").Inc()
+			w.Header().Set(Header, "BYPASS")
+			next.ServeHTTP(w, r)
+			return
+		}
+		vec, err := s.embed(r, next, prompt)
+		if err != nil {
+			semanticRequests.With("error").Inc()
+			slog.WarnContext(r.Context(), "semantic cache: embedding the prompt failed", "model", s.Model, "err", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !strings.Contains(cc, "no-cache") {
+			if e, sim := s.lookup(key, vec); e != nil {
+				semanticRequests.With("hit").Inc()
+				w.Header().Set(SimilarityHeader, strconv.FormatFloat(sim, 'f', 4, 64))
+				serve(w, e, "HIT")
+				return
+			}
+		}
+		semanticRequests.With("miss").Inc()
+
+		r.Header.Del("Accept-Encoding")
+		rec := &recorder{ResponseWriter: w, max: s.MaxEntryBytes}
+		next.ServeHTTP(rec, r)
+		mt, _, _ := mime.ParseMediaType(rec.Header().Get("Content-Type"))
+		if rec.status != http.StatusOK || mt != "application/json" || rec.over || r.Context().Err() != nil {
+			return
+		}
+		e := &Entry{Header: http.Header{}, Body: rec.buf.Bytes(), Stored: time.Now().UTC()}
+		e.Header.Set("Content-Type", rec.Header().Get("Content-Type"))
+		s.store(&semEntry{key: key, vec: vec, e: e, expires: time.Now().Add(s.TTL)})
+	})
+}
+
+// lookup returns the unexpired entry under key most similar to vec, if it
+// reaches the threshold.
+func (s *Semantic) lookup(key string, vec []float64) (*Entry, float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	var best *semEntry
+	bestSim := -1.0
+	kept := s.entries[:0]
+	for _, se := range s.entries {
+		if now.After(se.expires) {
+			continue
+		}
+		kept = append(kept, se)
+		if se.key != key {
+			continue
+		}
+		if sim := cosine(se.vec, vec); sim > bestSim {
+			best, bestSim = se, sim
+		}
+	}
+	clear(s.entries[len(kept):])
+	s.entries = kept
+	if best == nil || bestSim < s.Threshold {
+		return nil, 0
+	}
+	return best.e, bestSim
+}
+
+func (s *Semantic) store(se *semEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = append(s.entries, se)
+	if n := len(s.entries) - s.MaxEntries; n > 0 {
+		clear(s.entries[:n])
+		s.entries = s.entries[n:]
+	}
+}
+
+// Purge implements Purger.
+func (s *Semantic) Purge(context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = nil
+	return nil
+}
+
+// embed embeds prompt with a request to /api/embed through next, made
+// with the identity of r.
+func (s *Semantic) embed(r *http.Request, next http.Handler, prompt string) ([]float64, error) {
+	body, err := json.Marshal(map[string]any{"model": s.Model, "input": prompt})
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+	sub := r.Clone(ctx)
+	sub.URL.Path, sub.URL.RawPath, sub.URL.RawQuery = "/api/embed", "", ""
+	sub.Header.Del("Accept-Encoding")
+	sub.Header.Set("Content-Type", "application/json")
+	ollama.ReplaceBody(sub, body)
+	rec := &collector{header: http.Header{}}
+	next.ServeHTTP(rec, sub)
+	if rec.status != http.StatusOK {
+		return nil, fmt.Errorf("/api/embed answered %d: %s", rec.status, bytes.TrimSpace(rec.body.Bytes()))
+	}
+	var resp struct {
+		Embeddings [][]float64 `json:"embeddings"`
+	}
+	if err := json.Unmarshal(rec.body.Bytes(), &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Embeddings) != 1 || len(resp.Embeddings[0]) == 0 {
+		return nil, errors.New("/api/embed returned no embedding")
+	}
+	return resp.Embeddings[0], nil
+}
+
+// cosine returns the cosine similarity of a and b, or 0 if they differ in
+// length.
+func cosine(a, b []float64) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += a[i] * b[i]
+		na += a[i] * a[i]
+		nb += b[i] * b[i]
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(na*nb)
+}
+
+// collector collects the response of the embedding request.
+type collector struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *collector) Header() http.Header { return rec.header }
+
+func (rec *collector) WriteHeader(code int) {
+	if rec.status == 0 {
+		rec.status = code
+	}
+}
+
+func (rec *collector) Write(b []byte) (int, error) {
+	rec.WriteHeader(http.StatusOK)
+	return rec.body.Write(b)
+}
@@ -0,0 +1,195 @@
+// Package capture records proxied exchanges for debugging client
+// incompatibilities: as HTTP Archive (HAR) files for a time window, as
+// one file per request, or as log records for a sample of requests. Credentials in headers are redacted, and an
+// optional redact.Redactor masks configured patterns in headers and
+// bodies.
+package capture
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/yeti47/ollama-proxy/internal/auth"
+	"github.com/yeti47/ollama-proxy/internal/logging"
+	"github.com/yeti47/ollama-proxy/internal/redact"
+)
+
+// DefaultMaxBody is how many bytes of each request and response body are
+// kept. Streamed generations can run to megabytes; the rest is counted but
+// dropped. A limit of zero records sizes only.
+const DefaultMaxBody = 1 << 20
+
+// redacted replaces the values of credential headers.
+const redacted = "[REDACTED]"
+
+// sensitive are headers whose values never end up in a capture.
+var sensitive = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+	"X-Api-Key":           true,
+	auth.SignatureHeader:  true,
+}
+
+// redactHeaders returns a copy of h with credential values replaced.
+func redactHeaders(h http.Header) http.Header {
+	out := h.Clone()
+	for k, vs := range out {
+		if sensitive[http.CanonicalHeaderKey(k)] {
+			for i := range vs {
+				vs[i] = redacted
+			}
+		}
+	}
+	return out
+}
+
+// exchange is one recorded request and response.
+type exchange struct {
+	ID        string
+	Started   time.Time
+	Duration  time.Duration
+	FirstByte time.Duration
+	Remote    string
+
+	Method    string
+	URL       string
+	Proto     string
+	ReqHeader http.Header
+	ReqBody   body
+
+	Status     int
+	RespHeader http.Header
+	RespBody   body
+}
+
+// mask applies red to the recorded headers and bodies; base64 blobs are
+// stripped even if red is nil.
+func (x *exchange) mask(red *redact.Redactor) {
+	x.URL = red.Mask(x.URL)
+	for _, h := range []http.Header{x.ReqHeader, x.RespHeader} {
+		for _, vs := range h {
+			for i, v := range vs {
+				vs[i] = red.Mask(v)
+			}
+		}
+	}
+	for _, b := range []*body{&x.ReqBody, &x.RespBody} {
+		masked := red.Mask(b.buf.String())
+		b.buf.Reset()
+		b.buf.WriteString(masked)
+	}
+}
+
+// body keeps the first max bytes written to it and counts the rest.
+type body struct {
+	max   int
+	buf   bytes.Buffer
+	Size  int64
+	Trunc bool
+}
+
+func (b *body) Write(p []byte) (int, error) {
+	b.Size += int64(len(p))
+	if room := b.max - b.buf.Len(); room > 0 {
+		if len(p) > room {
+			b.buf.Write(p[:room])
+			b.Trunc = true
+		} else {
+			b.buf.Write(p)
+		}
+	} else if len(p) > 0 {
+		b.Trunc = true
+	}
+	return len(p), nil
+}
+
+// Bytes returns the kept part of the body.
+func (b *body) Bytes() []byte { return b.buf.Bytes() }
+
+// record serves r with next and returns what passed through, keeping at
+// most maxBody bytes of each body.
+func record(maxBody int, next http.Handler, w http.ResponseWriter, r *http.Request) *exchange {
+	url := *r.URL
+	url.Scheme, url.Host = "http", r.Host
+	if r.TLS != nil {
+		url.Scheme = "https"
+	}
+	x := &exchange{
+		ID:        logging.RequestID(r.Context()),
+		Started:   time.Now(),
+		Remote:    r.RemoteAddr,
+		Method:    r.Method,
+		URL:       url.String(),
+		Proto:     r.Proto,
+		ReqHeader: redactHeaders(r.Header),
+		ReqBody:   body{max: maxBody},
+		RespBody:  body{max: maxBody},
+	}
+	var tee *teeBody
+	if r.Body != nil && r.Body != http.NoBody {
+		tee = &teeBody{ReadCloser: r.Body, w: &x.ReqBody}
+		r.Body = tee
+	}
+	rw := &recorder{ResponseWriter: w, x: x}
+	next.ServeHTTP(rw, r)
+	x.Duration = time.Since(x.Started)
+	if tee != nil {
+		// requests rejected before their body was read still show it,
+		// or at least its size
+		_, _ = io.Copy(io.Discard, io.LimitReader(tee, int64(max(maxBody, DefaultMaxBody))))
+	}
+	if !rw.wrote {
+		// net/http sends an implicit 200 once the handler returns
+		x.Status, x.FirstByte, x.RespHeader = http.StatusOK, x.Duration, redactHeaders(w.Header())
+	}
+	return x
+}
+
+// teeBody copies what the handler reads from the request body.
+type teeBody struct {
+	io.ReadCloser
+	w io.Writer
+}
+
+func (t *teeBody) Read(p []byte) (int, error) {
+	n, err := t.ReadCloser.Read(p)
+	_, _ = t.w.Write(p[:n])
+	return n, err
+}
+
+// recorder copies the response. It passes Flush through so streamed
+// responses keep streaming.
+type recorder struct {
+	http.ResponseWriter
+	x     *exchange
+	wrote bool
+}
+
+func (w *recorder) WriteHeader(code int) {
+	if !w.wrote {
+		w.wrote = true
+		w.x.Status, w.x.FirstByte = code, time.Since(w.x.Started)
+		w.x.RespHeader = redactHeaders(w.Header())
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *recorder) Write(b []byte) (int, error) {
+	if !w.wrote {
+		w.WriteHeader(http.StatusOK)
+	}
+	_, _ = w.x.RespBody.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *recorder) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *recorder) Unwrap() http.ResponseWriter { return w.ResponseWriter }
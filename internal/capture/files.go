@@ -0,0 +1,195 @@
+package capture
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/yeti47/ollama-proxy/internal/apierror"
+	"github.com/yeti47/ollama-proxy/internal/audit"
+	"github.com/yeti47/ollama-proxy/internal/redact"
+)
+
+// IndexFile is the name of the index in the per-request capture
+// directory. It has one JSON line per captured exchange.
+const IndexFile = "index.jsonl"
+
+// Files writes every exchange to its own file in Dir, while enabled, and
+// appends a line describing it to Dir/index.jsonl.
+type Files struct {
+	Dir      string
+	MaxBody  int
+	Redactor *redact.Redactor
+
+	enabled atomic.Bool
+	mu      sync.Mutex // serialises index writes
+	count   int
+}
+
+// NewFiles returns a per-request recorder writing to dir. It starts
+// disabled.
+func NewFiles(dir string, maxBody int) *Files {
+	return &Files{Dir: dir, MaxBody: maxBody}
+}
+
+// SetEnabled turns capturing on or off.
+func (f *Files) SetEnabled(on bool) {
+	f.enabled.Store(on)
+}
+
+// IndexEntry is one line of the index.
+type IndexEntry struct {
+	Time          time.Time `json:"time"`
+	ID            string    `json:"id,omitempty"`
+	Method        string    `json:"method"`
+	URL           string    `json:"url"`
+	Status        int       `json:"status"`
+	DurationMS    float64   `json:"duration_ms"`
+	RequestBytes  int64     `json:"request_bytes"`
+	ResponseBytes int64     `json:"response_bytes"`
+	File          string    `json:"file"`
+}
+
+// Middleware records exchanges while capturing is enabled.
+func (f *Files) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !f.enabled.Load() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		x := record(f.MaxBody, next, w, r)
+		x.mask(f.Redactor)
+		if err := f.write(x); err != nil {
+			slog.ErrorContext(r.Context(), "writing request capture", "err", err)
+		}
+	})
+}
+
+// unsafeName matches characters not allowed in capture file names; the
+// request id comes from the client.
+var unsafeName = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+func (f *Files) write(x *exchange) error {
+	if err := os.MkdirAll(f.Dir, 0o700); err != nil {
+		return err
+	}
+	name := x.Started.UTC().Format("20060102T150405.000000Z")
+	if x.ID != "" {
+		name += "-" + unsafeName.ReplaceAllString(x.ID, "_")
+	}
+	name += ".txt"
+	// captures contain prompts and completions, so keep them private
+	out, err := os.OpenFile(filepath.Join(f.Dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(out)
+	writeMessage(bw, fmt.Sprintf("%s %s %s", x.Method, x.URL, x.Proto), x.ReqHeader, &x.ReqBody)
+	fmt.Fprintf(bw, "\n\n### response after %s (first byte after %s)\n\n",
+		x.Duration.Round(time.Millisecond), x.FirstByte.Round(time.Millisecond))
+	writeMessage(bw, fmt.Sprintf("%s %d %s", x.Proto, x.Status, http.StatusText(x.Status)), x.RespHeader, &x.RespBody)
+	if err := bw.Flush(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	line, err := json.Marshal(IndexEntry{
+		Time:          x.Started.UTC(),
+		ID:            x.ID,
+		Method:        x.Method,
+		URL:           x.URL,
+		Status:        x.Status,
+		DurationMS:    ms(x.Duration),
+		RequestBytes:  x.ReqBody.Size,
+		ResponseBytes: x.RespBody.Size,
+		File:          name,
+	})
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	idx, err := os.OpenFile(filepath.Join(f.Dir, IndexFile), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = idx.Write(append(line, '\n'))
+	if cerr := idx.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		f.count++
+	}
+	return err
+}
+
+// writeMessage writes a start line, headers and body in HTTP message
+// form. Streamed bodies are the chunks concatenated.
+func writeMessage(w *bufio.Writer, start string, h http.Header, b *body) {
+	w.WriteString(start + "\n")
+	names := make([]string, 0, len(h))
+	for k := range h {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		for _, v := range h[k] {
+			fmt.Fprintf(w, "%s: %s\n", k, v)
+		}
+	}
+	w.WriteString("\n")
+	w.Write(b.Bytes())
+	switch {
+	case b.Trunc && b.max == 0:
+		fmt.Fprintf(w, "[%d bytes not recorded]", b.Size)
+	case b.Trunc:
+		fmt.Fprintf(w, "\n[truncated: %d of %d bytes shown]", b.buf.Len(), b.Size)
+	}
+}
+
+// AdminHandler toggles capturing at /admin/capture/requests:
+//
+//	GET          {"enabled": bool, "dir": ..., "captured": n}
+//	PUT or POST  {"enabled": bool}
+func (f *Files) AdminHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut, http.MethodPost:
+			var req struct {
+				Enabled *bool `json:"enabled"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Enabled == nil {
+				apierror.Write(w, http.StatusBadRequest, `body must be {"enabled": true|false}`)
+				return
+			}
+			f.SetEnabled(*req.Enabled)
+			state := "disabled"
+			if *req.Enabled {
+				state = "enabled"
+			}
+			slog.InfoContext(r.Context(), "admin: per-request capture "+state, "dir", f.Dir)
+			audit.Event(audit.AdminChange, r, "", "per-request capture "+state)
+		default:
+			apierror.Write(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		f.mu.Lock()
+		n := f.count
+		f.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"enabled": f.enabled.Load(), "dir": f.Dir, "captured": n})
+	})
+}
@@ -0,0 +1,84 @@
+package capture
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yeti47/ollama-proxy/internal/logging"
+)
+
+func TestFiles(t *testing.T) {
+	dir := t.TempDir()
+	f := NewFiles(dir, 1<<10)
+	handler := logging.RequestIDMiddleware(f.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		for _, chunk := range []string{`{"response":"Hel"}` + "\n", `{"response":"lo","done":true}` + "\n"} {
+			_, _ = w.Write([]byte(chunk))
+			w.(http.Flusher).Flush()
+		}
+	})))
+	send := func(id string) {
+		req := httptest.NewRequest("POST", "/api/generate", strings.NewReader(`{"model":"llama3","prompt":"hi"}`))
+		req.Header.Set("Authorization", "Bearer sk-client")
+		req.Header.Set(logging.RequestIDHeader, id)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	send("before")
+	rec := httptest.NewRecorder()
+	f.AdminHandler().ServeHTTP(rec, httptest.NewRequest("PUT", "/admin/capture/requests", strings.NewReader(`{"enabled":true}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("enable: status %d", rec.Code)
+	}
+	send("../../etc/passwd")
+	f.SetEnabled(false)
+	send("after")
+
+	idx, err := os.Open(filepath.Join(dir, IndexFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+	var entries []IndexEntry
+	sc := bufio.NewScanner(idx)
+	for sc.Scan() {
+		var e IndexEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, e)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("index %+v", entries)
+	}
+	e := entries[0]
+	if e.Status != 200 || e.RequestBytes != 32 || e.ResponseBytes != 49 || strings.ContainsAny(e.File, "/\\") {
+		t.Errorf("index entry %+v", e)
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, e.File))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(b)
+	for _, want := range []string{
+		"POST http://example.com/api/generate HTTP/1.1\n",
+		"Authorization: [REDACTED]\n",
+		`{"model":"llama3","prompt":"hi"}`,
+		"HTTP/1.1 200 OK\n",
+		`{"response":"Hel"}` + "\n" + `{"response":"lo","done":true}`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("capture is missing %q:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "sk-client") {
+		t.Error("credentials leaked into the capture")
+	}
+}
@@ -0,0 +1,402 @@
+package capture
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/yeti47/ollama-proxy/internal/apierror"
+	"github.com/yeti47/ollama-proxy/internal/audit"
+	"github.com/yeti47/ollama-proxy/internal/redact"
+)
+
+// maxEntries caps the exchanges in one HAR file, and with it the memory a
+// capture holds; later ones are counted but not recorded.
+const maxEntries = 1000
+
+// ErrRecording is returned by Start while a capture is already running.
+var ErrRecording = errors.New("a HAR capture is already running")
+
+// HAR records exchanges for a time window and writes them to an HTTP
+// Archive file in Dir when the window ends.
+type HAR struct {
+	Dir      string
+	MaxBody  int
+	Redactor *redact.Redactor
+
+	mu      sync.Mutex // serialises Start and Stop
+	session atomic.Pointer[harSession]
+	last    string // file written by the last capture
+}
+
+type harSession struct {
+	started time.Time
+	until   time.Time
+	timer   *time.Timer
+
+	mu      sync.Mutex
+	entries []harEntry
+	dropped int
+	closed  bool
+}
+
+// NewHAR returns a recorder writing to dir. Nothing is recorded until
+// Start is called.
+func NewHAR(dir string, maxBody int) *HAR {
+	return &HAR{Dir: dir, MaxBody: maxBody}
+}
+
+// Start begins recording for d. The file is written when d has passed or
+// Stop is called.
+func (h *HAR) Start(d time.Duration) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.session.Load() != nil {
+		return ErrRecording
+	}
+	now := time.Now()
+	s := &harSession{started: now, until: now.Add(d)}
+	s.timer = time.AfterFunc(d, func() {
+		if _, _, err := h.stop(s); err != nil {
+			slog.Error("writing HAR capture", "err", err)
+		}
+	})
+	h.session.Store(s)
+	slog.Info("HAR capture started", "until", s.until.Format(time.RFC3339), "dir", h.Dir)
+	return nil
+}
+
+// Stop ends the running capture early and writes its file. It returns
+// the file path and the number of recorded exchanges; the path is empty
+// if no capture was running.
+func (h *HAR) Stop() (string, int, error) {
+	s := h.session.Load()
+	if s == nil {
+		return "", 0, nil
+	}
+	s.timer.Stop()
+	return h.stop(s)
+}
+
+func (h *HAR) stop(s *harSession) (string, int, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.session.CompareAndSwap(s, nil) {
+		return "", 0, nil // already stopped
+	}
+	s.mu.Lock()
+	s.closed = true
+	entries, dropped := s.entries, s.dropped
+	s.mu.Unlock()
+	if entries == nil {
+		entries = []harEntry{}
+	}
+
+	path := filepath.Join(h.Dir, "capture-"+s.started.UTC().Format("20060102T150405.000Z")+".har")
+	b, err := json.MarshalIndent(harFile{Log: harLog{
+		Version: "1.2",
+		Creator: harCreator{Name: "ollama-proxy", Version: "1"},
+		Entries: entries,
+	}}, "", "  ")
+	if err != nil {
+		return "", 0, err
+	}
+	if err := os.MkdirAll(h.Dir, 0o700); err != nil {
+		return "", 0, err
+	}
+	// captures contain prompts and completions, so keep them private
+	if err := os.WriteFile(path, b, 0o600); err != nil {
+		return "", 0, err
+	}
+	h.last = path
+	slog.Info("HAR capture written", "file", path, "entries", len(entries), "dropped", dropped)
+	return path, len(entries), nil
+}
+
+// Middleware records exchanges while a capture is running.
+func (h *HAR) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := h.session.Load()
+		if s == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		x := record(h.MaxBody, next, w, r)
+		x.mask(h.Redactor)
+		e := harEntryFor(x)
+		s.mu.Lock()
+		switch {
+		case s.closed:
+			// the window ended while this request was running
+		case len(s.entries) >= maxEntries:
+			s.dropped++
+		default:
+			s.entries = append(s.entries, e)
+		}
+		s.mu.Unlock()
+	})
+}
+
+// AdminHandler controls captures at /admin/capture/har:
+//
+//	GET    status of the running capture and the last file written
+//	POST   start a capture; ?duration=5m (default 5m)
+//	DELETE stop the running capture and write its file
+func (h *HAR) AdminHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(h.status())
+		case http.MethodPost:
+			d := 5 * time.Minute
+			if s := r.URL.Query().Get("duration"); s != "" {
+				v, err := time.ParseDuration(s)
+				if err != nil || v <= 0 {
+					apierror.Write(w, http.StatusBadRequest, "duration must be a positive duration such as 5m")
+					return
+				}
+				d = v
+			}
+			if err := h.Start(d); err != nil {
+				apierror.Write(w, http.StatusConflict, err.Error())
+				return
+			}
+			slog.InfoContext(r.Context(), "admin: started HAR capture", "duration", d)
+			audit.Event(audit.AdminChange, r, "", "started HAR capture for "+d.String())
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(h.status())
+		case http.MethodDelete:
+			path, n, err := h.Stop()
+			if err != nil {
+				apierror.Write(w, http.StatusInternalServerError, "writing HAR capture: "+err.Error())
+				return
+			}
+			if path == "" {
+				apierror.Write(w, http.StatusNotFound, "no HAR capture is running")
+				return
+			}
+			slog.InfoContext(r.Context(), "admin: stopped HAR capture", "file", path, "entries", n)
+			audit.Event(audit.AdminChange, r, "", "stopped HAR capture")
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(map[string]any{"file": path, "entries": n})
+		default:
+			apierror.Write(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	})
+}
+
+type harStatus struct {
+	Recording bool       `json:"recording"`
+	Started   *time.Time `json:"started,omitempty"`
+	Until     *time.Time `json:"until,omitempty"`
+	Entries   int        `json:"entries"`
+	LastFile  string     `json:"last_file,omitempty"`
+}
+
+func (h *HAR) status() harStatus {
+	h.mu.Lock()
+	st := harStatus{LastFile: h.last}
+	h.mu.Unlock()
+	if s := h.session.Load(); s != nil {
+		started, until := s.started.UTC(), s.until.UTC()
+		st.Recording, st.Started, st.Until = true, &started, &until
+		s.mu.Lock()
+		st.Entries = len(s.entries)
+		s.mu.Unlock()
+	}
+	return st
+}
+
+// The HAR 1.2 format, see http://www.softwareishard.com/blog/har-12-spec/.
+// Fields the proxy can't know, such as header sizes, are -1 as the spec
+// asks.
+
+type harFile struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	RequestID       string      `json:"_requestId,omitempty"`
+	Remote          string      `json:"_remote,omitempty"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+func harHeaders(h http.Header) []harNameValue {
+	out := []harNameValue{}
+	names := make([]string, 0, len(h))
+	for k := range h {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		for _, v := range h[k] {
+			out = append(out, harNameValue{k, v})
+		}
+	}
+	return out
+}
+
+// harText returns b as HAR content text, base64-encoding binary bodies.
+func harText(b []byte) (text, encoding string) {
+	if utf8.Valid(b) {
+		return string(b), ""
+	}
+	return base64.StdEncoding.EncodeToString(b), "base64"
+}
+
+func truncatedComment(b *body) string {
+	if !b.Trunc {
+		return ""
+	}
+	if b.max == 0 {
+		return fmt.Sprintf("%d bytes not recorded", b.Size)
+	}
+	return fmt.Sprintf("truncated to %d of %d bytes", b.buf.Len(), b.Size)
+}
+
+func ms(d time.Duration) float64 { return float64(d.Microseconds()) / 1000 }
+
+func harEntryFor(x *exchange) harEntry {
+	e := harEntry{
+		StartedDateTime: x.Started.UTC().Format(time.RFC3339Nano),
+		Time:            ms(x.Duration),
+		RequestID:       x.ID,
+		Remote:          x.Remote,
+		Timings:         harTimings{Send: 0, Wait: ms(x.FirstByte), Receive: ms(x.Duration - x.FirstByte)},
+	}
+	query := []harNameValue{}
+	if u, err := url.Parse(x.URL); err == nil {
+		q := u.Query()
+		keys := make([]string, 0, len(q))
+		for k := range q {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			for _, v := range q[k] {
+				query = append(query, harNameValue{k, v})
+			}
+		}
+	}
+	e.Request = harRequest{
+		Method:      x.Method,
+		URL:         x.URL,
+		HTTPVersion: x.Proto,
+		Cookies:     []harNameValue{},
+		Headers:     harHeaders(x.ReqHeader),
+		QueryString: query,
+		HeadersSize: -1,
+		BodySize:    x.ReqBody.Size,
+	}
+	if x.ReqBody.Size > 0 {
+		// HAR has no encoding field for request bodies, so binary data
+		// is kept as base64 with a comment
+		text, enc := harText(x.ReqBody.Bytes())
+		comment := truncatedComment(&x.ReqBody)
+		if enc != "" {
+			comment = strings.TrimPrefix(comment+"; base64-encoded", "; ")
+		}
+		e.Request.PostData = &harPostData{MimeType: x.ReqHeader.Get("Content-Type"), Text: text, Comment: comment}
+	}
+	text, enc := harText(x.RespBody.Bytes())
+	mt := x.RespHeader.Get("Content-Type")
+	if mt == "" {
+		mt = "application/octet-stream"
+	}
+	e.Response = harResponse{
+		Status:      x.Status,
+		StatusText:  http.StatusText(x.Status),
+		HTTPVersion: x.Proto,
+		Cookies:     []harNameValue{},
+		Headers:     harHeaders(x.RespHeader),
+		Content: harContent{
+			Size:     x.RespBody.Size,
+			MimeType: mt,
+			Text:     text,
+			Encoding: enc,
+			Comment:  truncatedComment(&x.RespBody),
+		},
+		RedirectURL: x.RespHeader.Get("Location"),
+		HeadersSize: -1,
+		BodySize:    x.RespBody.Size,
+	}
+	return e
+}
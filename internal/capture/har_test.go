@@ -0,0 +1,107 @@
+package capture
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHAR(t *testing.T) {
+	h := NewHAR(t.TempDir(), 16)
+	handler := h.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Set-Cookie", "session=secret")
+		_, _ = w.Write([]byte(`{"echo":` + string(b) + `}`))
+	}))
+	send := func() {
+		req := httptest.NewRequest("POST", "http://proxy.local/api/generate?debug=1", strings.NewReader(`"hi"`))
+		req.Header.Set("Authorization", "Bearer sk-client")
+		req.Header.Set("Content-Type", "application/json")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	send() // not recording yet
+	if err := h.Start(time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Start(time.Hour); err != ErrRecording {
+		t.Fatalf("second start: %v", err)
+	}
+	send()
+	path, n, err := h.Stop()
+	if err != nil || n != 1 {
+		t.Fatalf("stop: %s %d %v", path, n, err)
+	}
+	send() // stopped again
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(raw), "sk-client") || strings.Contains(string(raw), "session=secret") {
+		t.Fatal("credentials leaked into the HAR file")
+	}
+	var f harFile
+	if err := json.Unmarshal(raw, &f); err != nil {
+		t.Fatal(err)
+	}
+	if f.Log.Version != "1.2" || len(f.Log.Entries) != 1 {
+		t.Fatalf("log %+v", f.Log)
+	}
+	e := f.Log.Entries[0]
+	if e.Request.URL != "http://proxy.local/api/generate?debug=1" || e.Request.PostData == nil || e.Request.PostData.Text != `"hi"` {
+		t.Errorf("request %+v", e.Request)
+	}
+	if len(e.Request.QueryString) != 1 || e.Request.QueryString[0] != (harNameValue{"debug", "1"}) {
+		t.Errorf("query %+v", e.Request.QueryString)
+	}
+	c := e.Response.Content
+	if e.Response.Status != 200 || c.Text != `{"echo":"hi"}` || c.Size != 13 || c.MimeType != "application/x-ndjson" {
+		t.Errorf("response %+v", e.Response)
+	}
+
+	// bodies past MaxBody are cut off and say so
+	if err := h.Start(time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("POST", "/api/generate", strings.NewReader(`"a much longer prompt"`))
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	path, _, _ = h.Stop()
+	raw, _ = os.ReadFile(path)
+	f = harFile{}
+	_ = json.Unmarshal(raw, &f)
+	if c := f.Log.Entries[0].Response.Content; len(c.Text) != 16 || c.Comment != "truncated to 16 of 31 bytes" {
+		t.Errorf("truncated content %+v", c)
+	}
+}
+
+func TestHARWithoutBodies(t *testing.T) {
+	h := NewHAR(t.TempDir(), 0)
+	handler := h.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("secret completion"))
+	}))
+	if err := h.Start(time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/generate", strings.NewReader("secret prompt")))
+	path, _, err := h.Stop()
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := os.ReadFile(path)
+	if strings.Contains(string(raw), "secret") {
+		t.Fatalf("body content recorded: %s", raw)
+	}
+	var f harFile
+	_ = json.Unmarshal(raw, &f)
+	e := f.Log.Entries[0]
+	if e.Request.BodySize != 13 || e.Response.Content.Size != 17 || e.Response.Content.Comment != "17 bytes not recorded" {
+		t.Errorf("entry %+v", e)
+	}
+}
@@ -0,0 +1,88 @@
+package capture
+
+import (
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/yeti47/ollama-proxy/internal/redact"
+)
+
+// DefaultSampleMaxBody is how many bytes of each body a sampled request
+// logs; log lines are not the place for whole generations.
+const DefaultSampleMaxBody = 16 << 10
+
+// Sampler logs the full detail of some requests: headers and bodies of
+// both the request and the response. It picks a random fraction of
+// requests, plus every request carrying a trigger header, so production
+// traffic can be inspected without turning on verbose logging for all of
+// it.
+type Sampler struct {
+	// Rate is the fraction of requests logged, from 0 to 1.
+	Rate float64
+	// Header and Value select requests that are always logged. An empty
+	// Value matches any non-empty header value.
+	Header, Value string
+	// MaxBody is how many bytes of each body are logged; zero logs sizes
+	// only.
+	MaxBody  int
+	Redactor *redact.Redactor
+}
+
+// ParseTrigger splits a "Name" or "Name=value" trigger header spec.
+func ParseTrigger(s string) (name, value string) {
+	name, value, _ = strings.Cut(s, "=")
+	return http.CanonicalHeaderKey(strings.TrimSpace(name)), strings.TrimSpace(value)
+}
+
+func (s *Sampler) sampled(r *http.Request) bool {
+	if s.Header != "" {
+		if v := r.Header.Get(s.Header); v != "" && (s.Value == "" || v == s.Value) {
+			return true
+		}
+	}
+	return s.Rate > 0 && rand.Float64() < s.Rate
+}
+
+// Middleware logs the detail of sampled requests once they complete.
+func (s *Sampler) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.sampled(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		x := record(s.MaxBody, next, w, r)
+		x.mask(s.Redactor)
+		attrs := []any{
+			"method", x.Method,
+			"url", x.URL,
+			"status", x.Status,
+			"duration", x.Duration,
+			"first_byte", x.FirstByte,
+			"request_headers", headerString(x.ReqHeader),
+			"request_bytes", x.ReqBody.Size,
+			"response_headers", headerString(x.RespHeader),
+			"response_bytes", x.RespBody.Size,
+		}
+		if s.MaxBody > 0 {
+			attrs = append(attrs, "request_body", string(x.ReqBody.Bytes()), "response_body", string(x.RespBody.Bytes()))
+		}
+		slog.InfoContext(r.Context(), "request detail", attrs...)
+	})
+}
+
+// headerString formats h as "Name: value; ..." in a stable order.
+func headerString(h http.Header) string {
+	names := make([]string, 0, len(h))
+	for k := range h {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	parts := make([]string, 0, len(names))
+	for _, k := range names {
+		parts = append(parts, k+": "+strings.Join(h[k], ","))
+	}
+	return strings.Join(parts, "; ")
+}
@@ -0,0 +1,62 @@
+package capture
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSampler(t *testing.T) {
+	var logs bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	defer slog.SetDefault(prev)
+
+	s := &Sampler{MaxBody: 8}
+	s.Header, s.Value = ParseTrigger("x-debug=1")
+	h := s.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("generated text"))
+	}))
+	send := func(debug string) {
+		req := httptest.NewRequest("POST", "/api/generate", strings.NewReader(`{"prompt":"hi"}`))
+		req.Header.Set("Authorization", "Bearer sk-client")
+		if debug != "" {
+			req.Header.Set("X-Debug", debug)
+		}
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	send("")
+	send("0")
+	if logs.Len() != 0 {
+		t.Fatalf("unsampled requests were logged: %s", logs.String())
+	}
+	send("1")
+	out := logs.String()
+	for _, want := range []string{`msg="request detail"`, "status=200", `request_body="{\"prompt"`, "response_bytes=14", "response_body=generate", "Authorization: [REDACTED]"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("log is missing %s: %s", want, out)
+		}
+	}
+	if strings.Contains(out, "sk-client") {
+		t.Error("credentials leaked into the log")
+	}
+
+	// without a body limit only sizes are logged
+	logs.Reset()
+	s.MaxBody = 0
+	send("1")
+	if out := logs.String(); strings.Contains(out, "request_body") || !strings.Contains(out, "request_bytes=15") {
+		t.Errorf("sizes-only log: %s", out)
+	}
+
+	logs.Reset()
+	s.Rate = 1
+	send("")
+	if logs.Len() == 0 {
+		t.Error("rate 1 did not log the request")
+	}
+}
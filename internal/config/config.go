@@ -0,0 +1,232 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/yeti47/ollama-proxy/internal/access"
+	"github.com/yeti47/ollama-proxy/internal/budget"
+	"github.com/yeti47/ollama-proxy/internal/contextlimit"
+	"github.com/yeti47/ollama-proxy/internal/params"
+	"github.com/yeti47/ollama-proxy/internal/prompttemplate"
+	"github.com/yeti47/ollama-proxy/internal/quota"
+	"github.com/yeti47/ollama-proxy/internal/ratelimit"
+	"github.com/yeti47/ollama-proxy/internal/redact"
+	"github.com/yeti47/ollama-proxy/internal/rewrite"
+	"github.com/yeti47/ollama-proxy/internal/schedule"
+	"github.com/yeti47/ollama-proxy/internal/sysprompt"
+)
+
+// File is the optional JSON configuration file passed via -config. It holds
+// settings that don't fit comfortably into flags, such as per-tenant key
+// mappings.
+type File struct {
+	Tenants []Tenant `json:"tenants"`
+	Clients []Client `json:"clients"`
+	RBAC    *RBAC    `json:"rbac,omitempty"`
+	// Pricing holds per-model token prices used to estimate spend.
+	Pricing budget.Prices `json:"pricing,omitempty"`
+	// Redaction configures masking of personal data in request bodies.
+	Redaction *redact.Config `json:"redaction,omitempty"`
+	// LogRedaction masks matches in headers and bodies written to logs
+	// and debug captures; request bodies forwarded upstream are not
+	// touched.
+	LogRedaction *redact.Config `json:"log_redaction,omitempty"`
+	// SecurityHeaders adds to or overrides the default security headers
+	// sent to clients; an empty value removes a default.
+	SecurityHeaders map[string]string `json:"security_headers,omitempty"`
+	// ResponseRewrites edit upstream JSON responses, after the built-in
+	// /api/version fixup.
+	ResponseRewrites []rewrite.Rule `json:"response_rewrites,omitempty"`
+	// RequestRewrites edit request bodies before they are forwarded.
+	RequestRewrites []rewrite.RequestRule `json:"request_rewrites,omitempty"`
+	// SystemPrompts are added to chat and generate requests.
+	SystemPrompts []sysprompt.Rule `json:"system_prompts,omitempty"`
+	// ParameterPolicies limit request options.
+	ParameterPolicies []params.Policy `json:"parameter_policies,omitempty"`
+	// ContextLimits caps the estimated prompt size per model.
+	ContextLimits *contextlimit.Config `json:"context_limits,omitempty"`
+	// PromptTemplates wrap the user prompt before forwarding.
+	PromptTemplates []prompttemplate.Rule `json:"prompt_templates,omitempty"`
+}
+
+// RBAC defines named roles and how callers are assigned to them.
+type RBAC struct {
+	Roles map[string]access.Role `json:"roles"`
+	// DefaultRoles apply to callers that have no roles of their own.
+	DefaultRoles []string `json:"default_roles,omitempty"`
+	// RolesClaim names the JWT/introspection claim holding a caller's
+	// roles (a list or a space-separated string).
+	RolesClaim string `json:"roles_claim,omitempty"`
+}
+
+// Tenant maps a set of proxy-issued client keys to a specific upstream API
+// key. Name is used to tag log lines and metrics.
+type Tenant struct {
+	Name        string   `json:"name"`
+	ClientKeys  []string `json:"client_keys"`
+	UpstreamKey string   `json:"upstream_key"`
+	// Models optionally restricts all of the tenant's keys to these models.
+	Models []string `json:"models,omitempty"`
+	Roles  []string `json:"roles,omitempty"`
+	// Schedule optionally limits when the tenant's keys may be used.
+	Schedule *schedule.Schedule `json:"schedule,omitempty"`
+	// RateLimit, Quota and Budget override the defaults for each of the tenant's
+	// keys.
+	RateLimit *ratelimit.Limits `json:"rate_limit,omitempty"`
+	Quota     *quota.Limits     `json:"quota,omitempty"`
+	Budget    *budget.Limits    `json:"budget,omitempty"`
+	// StripThinking overrides -strip-thinking for the tenant's keys.
+	StripThinking *bool `json:"strip_thinking,omitempty"`
+}
+
+// Client is an individual proxy-issued key with its own settings.
+type Client struct {
+	Name string `json:"name"`
+	Key  string `json:"key"`
+	// Models lists the models this key may use. Entries may contain
+	// wildcards such as "llama3*". An empty list allows every model.
+	Models    []string           `json:"models,omitempty"`
+	Roles     []string           `json:"roles,omitempty"`
+	Schedule  *schedule.Schedule `json:"schedule,omitempty"`
+	RateLimit *ratelimit.Limits  `json:"rate_limit,omitempty"`
+	Quota     *quota.Limits      `json:"quota,omitempty"`
+	Budget    *budget.Limits     `json:"budget,omitempty"`
+	// StripThinking overrides -strip-thinking for this key.
+	StripThinking *bool `json:"strip_thinking,omitempty"`
+}
+
+// Load reads and validates the config file at path.
+func Load(path string) (*File, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f File
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if err := f.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &f, nil
+}
+
+func (f *File) validate() error {
+	names := make(map[string]bool)
+	keys := make(map[string]bool)
+	for i, t := range f.Tenants {
+		if t.Name == "" {
+			return fmt.Errorf("tenants[%d]: name is required", i)
+		}
+		if names[t.Name] {
+			return fmt.Errorf("tenants[%d]: duplicate name %q", i, t.Name)
+		}
+		names[t.Name] = true
+		if len(t.ClientKeys) == 0 {
+			return fmt.Errorf("tenant %q: at least one client key is required", t.Name)
+		}
+		for _, k := range t.ClientKeys {
+			if k == "" {
+				return fmt.Errorf("tenant %q: empty client key", t.Name)
+			}
+			if keys[k] {
+				return fmt.Errorf("tenant %q: client key is already assigned to another tenant", t.Name)
+			}
+			keys[k] = true
+		}
+	}
+	for i, c := range f.Clients {
+		if c.Name == "" {
+			return fmt.Errorf("clients[%d]: name is required", i)
+		}
+		if names[c.Name] {
+			return fmt.Errorf("clients[%d]: duplicate name %q", i, c.Name)
+		}
+		names[c.Name] = true
+		if c.Key == "" {
+			return fmt.Errorf("client %q: key is required", c.Name)
+		}
+		if keys[c.Key] {
+			return fmt.Errorf("client %q: key is already assigned elsewhere", c.Name)
+		}
+		keys[c.Key] = true
+	}
+	for _, b := range f.budgets() {
+		if b.Action != "" && b.Action != "block" && b.Action != "warn" {
+			return fmt.Errorf("budget action must be block or warn, got %q", b.Action)
+		}
+	}
+	if f.Redaction != nil {
+		if _, err := redact.New(*f.Redaction); err != nil {
+			return err
+		}
+	}
+	if f.LogRedaction != nil {
+		if _, err := redact.New(*f.LogRedaction); err != nil {
+			return fmt.Errorf("log_redaction: %w", err)
+		}
+	}
+	if _, err := rewrite.New(f.ResponseRewrites); err != nil {
+		return err
+	}
+	if _, err := rewrite.NewRequest(f.RequestRewrites); err != nil {
+		return err
+	}
+	if _, err := sysprompt.New(f.SystemPrompts); err != nil {
+		return err
+	}
+	if _, err := params.New(f.ParameterPolicies); err != nil {
+		return err
+	}
+	if _, err := prompttemplate.New(f.PromptTemplates); err != nil {
+		return err
+	}
+	if f.ContextLimits != nil {
+		if _, err := contextlimit.New(*f.ContextLimits); err != nil {
+			return err
+		}
+	}
+	return f.validateRoles()
+}
+
+func (f *File) budgets() []*budget.Limits {
+	var out []*budget.Limits
+	for _, t := range f.Tenants {
+		if t.Budget != nil {
+			out = append(out, t.Budget)
+		}
+	}
+	for _, c := range f.Clients {
+		if c.Budget != nil {
+			out = append(out, c.Budget)
+		}
+	}
+	return out
+}
+
+// validateRoles checks that every role referenced by a tenant, client or
+// the defaults is defined.
+func (f *File) validateRoles() error {
+	var refs []string
+	for _, t := range f.Tenants {
+		refs = append(refs, t.Roles...)
+	}
+	for _, c := range f.Clients {
+		refs = append(refs, c.Roles...)
+	}
+	if f.RBAC == nil {
+		if len(refs) > 0 {
+			return fmt.Errorf("role %q is assigned but no rbac section is defined", refs[0])
+		}
+		return nil
+	}
+	refs = append(refs, f.RBAC.DefaultRoles...)
+	for _, r := range refs {
+		if _, ok := f.RBAC.Roles[r]; !ok {
+			return fmt.Errorf("rbac: unknown role %q", r)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package config
+
+import (
+	"encoding/json"
+	"flag"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+
+	"github.com/yeti47/ollama-proxy/internal/apierror"
+)
+
+// masked replaces secret values in dumps.
+const masked = "[REDACTED]"
+
+// Setting is the effective value of one flag and where it came from:
+// "flag" for the command line, "env" for the environment variable named
+// in Env, or "default".
+type Setting struct {
+	Value  string `json:"value"`
+	Source string `json:"source"`
+	Env    string `json:"env,omitempty"`
+}
+
+// envHint finds the fallback environment variable a flag's usage text
+// names, as in "(can also set PROXY_ADMIN_TOKEN env var)".
+var envHint = regexp.MustCompile(`can also set ([A-Z][A-Z0-9_]*)(?: or ([A-Z][A-Z0-9_]*))?`)
+
+// Flags returns the effective value of every flag in fs. Values of flags
+// for which secret returns true are masked, as are passwords in URLs.
+func Flags(fs *flag.FlagSet, secret func(name string) bool) map[string]Setting {
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	out := map[string]Setting{}
+	fs.VisitAll(func(f *flag.Flag) {
+		s := Setting{Value: f.Value.String(), Source: "default"}
+		if set[f.Name] {
+			s.Source = "flag"
+		} else if m := envHint.FindStringSubmatch(f.Usage); m != nil {
+			for _, env := range m[1:] {
+				if v := os.Getenv(env); env != "" && v != "" {
+					s.Value, s.Source, s.Env = v, "env", env
+					break
+				}
+			}
+		}
+		if s.Value != "" && secret != nil && secret(f.Name) {
+			s.Value = masked
+		} else if u, err := url.Parse(s.Value); err == nil && u.User != nil {
+			// credentials embedded in a URL
+			s.Value = u.Redacted()
+		}
+		out[f.Name] = s
+	})
+	return out
+}
+
+// Masked returns a copy of f with client and upstream keys replaced by
+// "[REDACTED]", safe to show to operators.
+func (f *File) Masked() *File {
+	c := *f
+	c.Tenants = make([]Tenant, len(f.Tenants))
+	for i, t := range f.Tenants {
+		keys := make([]string, len(t.ClientKeys))
+		for j := range keys {
+			keys[j] = masked
+		}
+		t.ClientKeys = keys
+		if t.UpstreamKey != "" {
+			t.UpstreamKey = masked
+		}
+		c.Tenants[i] = t
+	}
+	c.Clients = make([]Client, len(f.Clients))
+	for i, cl := range f.Clients {
+		if cl.Key != "" {
+			cl.Key = masked
+		}
+		c.Clients[i] = cl
+	}
+	return &c
+}
+
+// Dump is the effective configuration served on /admin/config.
+type Dump struct {
+	Flags      map[string]Setting `json:"flags"`
+	ConfigFile string             `json:"config_file,omitempty"`
+	Config     *File              `json:"config,omitempty"`
+}
+
+// AdminHandler serves dump as JSON at /admin/config. The configuration
+// is fixed at startup, so it is computed once by the caller.
+func AdminHandler(dump Dump) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			apierror.Write(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(dump)
+	})
+}
@@ -0,0 +1,271 @@
+// Package contextlimit estimates the prompt size of chat and generate
+// requests and rejects or truncates those over a per-model context
+// budget, instead of letting the upstream silently cut the prompt or run
+// out of memory.
+package contextlimit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/yeti47/ollama-proxy/internal/access"
+	"github.com/yeti47/ollama-proxy/internal/apierror"
+	"github.com/yeti47/ollama-proxy/internal/metrics"
+	"github.com/yeti47/ollama-proxy/internal/ollama"
+)
+
+// TruncatedHeader is set on responses to truncated requests. It says what
+// was dropped, e.g. "12 messages, ~5300 tokens".
+const TruncatedHeader = "X-Proxy-Truncated"
+
+// messageOverhead approximates the template tokens around each chat
+// message.
+const messageOverhead = 4
+
+var (
+	enforced = metrics.NewCounter("ollama_proxy_context_limit_total",
+		"Requests over their context budget, by action: rejected or truncated.", "action")
+	estimated = metrics.NewCounter("ollama_proxy_context_estimated_tokens_total",
+		"Estimated prompt tokens of checked requests.")
+)
+
+// Config is the "context_limits" section of the config file.
+type Config struct {
+	// DefaultTokens is the budget of models no entry of Models names; 0
+	// leaves them unchecked.
+	DefaultTokens int `json:"default_tokens,omitempty"`
+	// Models sets budgets per model; the first match wins.
+	Models []ModelLimit `json:"models,omitempty"`
+	// Action is "reject" (the default) or "truncate".
+	Action string `json:"action,omitempty"`
+}
+
+// ModelLimit is the budget of the models matching Models, with wildcards
+// as in model allowlists.
+type ModelLimit struct {
+	Models []string `json:"models"`
+	Tokens int      `json:"tokens"`
+}
+
+// Limiter enforces a Config.
+type Limiter struct {
+	cfg Config
+}
+
+// New checks cfg.
+func New(cfg Config) (*Limiter, error) {
+	switch cfg.Action {
+	case "":
+		cfg.Action = "reject"
+	case "reject", "truncate":
+	default:
+		return nil, fmt.Errorf("context_limits: action must be reject or truncate, got %q", cfg.Action)
+	}
+	if cfg.DefaultTokens < 0 {
+		return nil, fmt.Errorf("context_limits: default_tokens is negative")
+	}
+	for i, m := range cfg.Models {
+		if len(m.Models) == 0 || m.Tokens <= 0 {
+			return nil, fmt.Errorf("context_limits: models[%d] needs models and positive tokens", i)
+		}
+	}
+	return &Limiter{cfg: cfg}, nil
+}
+
+// Enabled reports whether any model has a budget.
+func (l *Limiter) Enabled() bool {
+	return l != nil && (l.cfg.DefaultTokens > 0 || len(l.cfg.Models) > 0)
+}
+
+// Budget returns the context budget of model, 0 for none.
+func (l *Limiter) Budget(model string) int {
+	for _, m := range l.cfg.Models {
+		if access.ModelAllowed(m.Models, model) {
+			return m.Tokens
+		}
+	}
+	return l.cfg.DefaultTokens
+}
+
+// Estimate approximates the number of tokens in s: about four characters
+// per token for ASCII text, one per character for everything else, which
+// errs high for accented Latin and is close for CJK scripts.
+func Estimate(s string) int {
+	ascii, other := 0, 0
+	for _, r := range s {
+		if r < utf8.RuneSelf {
+			ascii++
+		} else {
+			other++
+		}
+	}
+	return (ascii+3)/4 + other
+}
+
+type message struct {
+	raw    map[string]json.RawMessage
+	system bool
+	tokens int
+}
+
+// Middleware checks requests to next against their model's budget.
+func (l *Limiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !l.Enabled() || r.Method != http.MethodPost || (r.URL.Path != "/api/chat" && r.URL.Path != "/api/generate") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		body, err := ollama.PeekBody(r)
+		if err != nil {
+			apierror.BodyError(w, err)
+			return
+		}
+		var req map[string]json.RawMessage
+		if json.Unmarshal(body, &req) != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		var model string
+		_ = json.Unmarshal(req["model"], &model)
+		budget := l.Budget(model)
+		// a smaller num_ctx of the request's own is where the upstream
+		// would cut
+		var opts struct {
+			NumCtx int `json:"num_ctx"`
+		}
+		if json.Unmarshal(req["options"], &opts) == nil && opts.NumCtx > 0 && (budget == 0 || opts.NumCtx < budget) {
+			budget = opts.NumCtx
+		}
+		if budget == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var truncated string
+		if r.URL.Path == "/api/chat" {
+			truncated, err = l.chat(req, budget)
+		} else {
+			truncated, err = l.generate(req, budget)
+		}
+		if err != nil {
+			enforced.With("rejected").Inc()
+			slog.InfoContext(r.Context(), "request over its context budget", "model", model, "budget", budget, "err", err)
+			apierror.Write(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if truncated != "" {
+			b, err := marshal(req)
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ollama.ReplaceBody(r, b)
+			enforced.With("truncated").Inc()
+			w.Header().Set(TruncatedHeader, truncated)
+			slog.InfoContext(r.Context(), "request truncated to its context budget", "model", model, "budget", budget, "dropped", truncated)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// tooLong is the error for a prompt of n estimated tokens.
+func tooLong(n, budget int) error {
+	return fmt.Errorf("prompt is about %d tokens, over the context budget of %d", n, budget)
+}
+
+// chat checks the messages of req. Truncation drops the oldest messages
+// that aren't system messages, always keeping the last one.
+func (l *Limiter) chat(req map[string]json.RawMessage, budget int) (string, error) {
+	var raw []map[string]json.RawMessage
+	if json.Unmarshal(req["messages"], &raw) != nil {
+		return "", nil
+	}
+	total := 0
+	if tools, ok := req["tools"]; ok {
+		total += Estimate(string(tools))
+	}
+	msgs := make([]message, len(raw))
+	for i, m := range raw {
+		var role, content string
+		_ = json.Unmarshal(m["role"], &role)
+		_ = json.Unmarshal(m["content"], &content)
+		n := Estimate(content) + messageOverhead
+		if calls, ok := m["tool_calls"]; ok {
+			n += Estimate(string(calls))
+		}
+		msgs[i] = message{raw: m, system: role == "system", tokens: n}
+		total += n
+	}
+	estimated.With().Add(float64(total))
+	if total <= budget {
+		return "", nil
+	}
+	if l.cfg.Action != "truncate" {
+		return "", tooLong(total, budget)
+	}
+	dropped, droppedTokens := 0, 0
+	kept := make([]map[string]json.RawMessage, 0, len(msgs))
+	for i, m := range msgs {
+		if total > budget && !m.system && i < len(msgs)-1 {
+			total -= m.tokens
+			dropped++
+			droppedTokens += m.tokens
+			continue
+		}
+		kept = append(kept, m.raw)
+	}
+	if total > budget {
+		return "", tooLong(total, budget)
+	}
+	req["messages"], _ = marshal(kept)
+	return fmt.Sprintf("%d messages, ~%d tokens", dropped, droppedTokens), nil
+}
+
+// generate checks the prompt and system of req. Truncation keeps the end
+// of the prompt, which usually holds the question.
+func (l *Limiter) generate(req map[string]json.RawMessage, budget int) (string, error) {
+	var prompt, system, suffix string
+	_ = json.Unmarshal(req["prompt"], &prompt)
+	_ = json.Unmarshal(req["system"], &system)
+	_ = json.Unmarshal(req["suffix"], &suffix)
+	fixed := Estimate(system) + Estimate(suffix)
+	total := fixed + Estimate(prompt)
+	estimated.With().Add(float64(total))
+	if total <= budget {
+		return "", nil
+	}
+	if l.cfg.Action != "truncate" || fixed >= budget {
+		return "", tooLong(total, budget)
+	}
+	// drop from the front until the rest fits; Estimate is monotonic in
+	// the runes kept, so a binary search finds the longest tail
+	runes := []rune(prompt)
+	lo, hi := 0, len(runes)
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if fixed+Estimate(string(runes[mid:])) <= budget {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+	rest := string(runes[lo:])
+	req["prompt"], _ = marshal(rest)
+	return strconv.Itoa(lo) + " characters, ~" + strconv.Itoa(total-fixed-Estimate(rest)) + " tokens", nil
+}
+
+// marshal encodes v without escaping <, > and &.
+func marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
@@ -0,0 +1,121 @@
+package contextlimit
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestEstimate(t *testing.T) {
+	for _, c := range []struct {
+		s    string
+		want int
+	}{
+		{"", 0},
+		{"abcd", 1},
+		{"abcde", 2},
+		{"日本語", 3},
+	} {
+		if got := Estimate(c.s); got != c.want {
+			t.Errorf("Estimate(%q) = %d, want %d", c.s, got, c.want)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, c := range []Config{
+		{Action: "drop"},
+		{DefaultTokens: -1},
+		{Models: []ModelLimit{{Models: []string{"llama3*"}}}},
+	} {
+		if _, err := New(c); err == nil {
+			t.Errorf("%+v accepted", c)
+		}
+	}
+}
+
+func TestBudget(t *testing.T) {
+	l, _ := New(Config{DefaultTokens: 4096, Models: []ModelLimit{
+		{Models: []string{"qwen3:32b"}, Tokens: 8192},
+		{Models: []string{"qwen3*"}, Tokens: 32768},
+	}})
+	for model, want := range map[string]int{"qwen3:32b": 8192, "qwen3": 32768, "llama3": 4096} {
+		if got := l.Budget(model); got != want {
+			t.Errorf("%s: %d, want %d", model, got, want)
+		}
+	}
+}
+
+func serve(l *Limiter, path, body string) (*httptest.ResponseRecorder, string) {
+	var got string
+	h := l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		got = string(b)
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+	return rec, got
+}
+
+func TestReject(t *testing.T) {
+	l, _ := New(Config{DefaultTokens: 10})
+	long := strings.Repeat("word ", 20)
+	rec, _ := serve(l, "/api/generate", `{"model":"llama3","prompt":"`+long+`"}`)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "context budget of 10") {
+		t.Errorf("got %d %s", rec.Code, rec.Body)
+	}
+	rec, got := serve(l, "/api/generate", `{"model":"llama3","prompt":"short"}`)
+	if rec.Code != http.StatusOK || got != `{"model":"llama3","prompt":"short"}` {
+		t.Errorf("short prompt: %d, forwarded %s", rec.Code, got)
+	}
+	// a smaller num_ctx of the request's own is the budget
+	rec, _ = serve(l, "/api/generate", `{"model":"llama3","prompt":"twelve chars","options":{"num_ctx":2}}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("num_ctx ignored: %d", rec.Code)
+	}
+}
+
+func TestTruncateChat(t *testing.T) {
+	l, _ := New(Config{DefaultTokens: 30, Action: "truncate"})
+	long := strings.Repeat("x", 40) // 10 tokens, 14 with the overhead
+	body := `{"model":"llama3","messages":[` +
+		`{"role":"system","content":"Be nice."},` +
+		`{"role":"user","content":"` + long + `"},` +
+		`{"role":"assistant","content":"` + long + `"},` +
+		`{"role":"user","content":"Why?"}]}`
+	rec, got := serve(l, "/api/chat", body)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var req struct {
+		Messages []struct{ Role, Content string }
+	}
+	if err := json.Unmarshal([]byte(got), &req); err != nil {
+		t.Fatal(err)
+	}
+	if len(req.Messages) != 3 || req.Messages[0].Role != "system" || req.Messages[1].Role != "assistant" || req.Messages[2].Content != "Why?" {
+		t.Errorf("kept %+v", req.Messages)
+	}
+	if h := rec.Header().Get(TruncatedHeader); h != "1 messages, ~14 tokens" {
+		t.Errorf("header %q", h)
+	}
+}
+
+func TestTruncateGenerate(t *testing.T) {
+	l, _ := New(Config{DefaultTokens: 5, Action: "truncate"})
+	rec, got := serve(l, "/api/generate", `{"model":"llama3","prompt":"aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa the end"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var req struct{ Prompt string }
+	_ = json.Unmarshal([]byte(got), &req)
+	if Estimate(req.Prompt) > 5 || !strings.HasSuffix(req.Prompt, " the end") || len(req.Prompt) != 20 {
+		t.Errorf("prompt %q", req.Prompt)
+	}
+	if rec.Header().Get(TruncatedHeader) == "" {
+		t.Error("no truncation header")
+	}
+}
@@ -0,0 +1,144 @@
+package cors
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Config describes which cross-origin browser requests are allowed.
+type Config struct {
+	// Origins lists allowed origins such as "http://localhost:3000". A
+	// single "*" allows any origin.
+	Origins []string
+	Methods []string
+	Headers []string
+	// MaxAge is how long, in seconds, browsers may cache a preflight result.
+	MaxAge int
+	// AllowCredentials sets Access-Control-Allow-Credentials. With "*" the
+	// request's own origin is echoed, since browsers refuse a wildcard with
+	// credentials.
+	AllowCredentials bool
+}
+
+// SplitList splits a comma-separated flag value, dropping empty entries.
+func SplitList(s string) []string {
+	var out []string
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func (c *Config) originAllowed(origin string) bool {
+	for _, o := range c.Origins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Config) wildcard() bool {
+	return len(c.Origins) == 1 && c.Origins[0] == "*"
+}
+
+// setOriginHeaders sets the response headers common to preflight and
+// actual requests.
+func (c *Config) setOriginHeaders(h http.Header, origin string) {
+	if c.wildcard() && !c.AllowCredentials {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+		h.Add("Vary", "Origin")
+	}
+	if c.AllowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// Middleware answers CORS preflight requests itself instead of forwarding
+// them upstream, and adds CORS headers to responses for allowed origins.
+// Any CORS headers set by the upstream are replaced so browsers never see
+// conflicting values.
+func Middleware(c *Config, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		allowed := c.originAllowed(origin)
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			if !allowed {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			h := w.Header()
+			c.setOriginHeaders(h, origin)
+			h.Set("Access-Control-Allow-Methods", strings.Join(c.Methods, ", "))
+			if len(c.Headers) > 0 {
+				h.Set("Access-Control-Allow-Headers", strings.Join(c.Headers, ", "))
+			} else if req := r.Header.Get("Access-Control-Request-Headers"); req != "" {
+				h.Set("Access-Control-Allow-Headers", req)
+			}
+			if c.MaxAge > 0 {
+				h.Set("Access-Control-Max-Age", strconv.Itoa(c.MaxAge))
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		if !allowed {
+			next.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(&responseWriter{ResponseWriter: w, cfg: c, origin: origin}, r)
+	})
+}
+
+// responseWriter applies the CORS headers right before the status line is
+// written, after the upstream's headers have been copied in.
+type responseWriter struct {
+	http.ResponseWriter
+	cfg         *Config
+	origin      string
+	wroteHeader bool
+}
+
+func (rw *responseWriter) WriteHeader(code int) {
+	if !rw.wroteHeader {
+		rw.wroteHeader = true
+		h := rw.Header()
+		for k := range h {
+			if strings.HasPrefix(k, "Access-Control-") {
+				h.Del(k)
+			}
+		}
+		rw.cfg.setOriginHeaders(h, rw.origin)
+	}
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+	return rw.ResponseWriter.Write(b)
+}
+
+// Flush keeps streaming responses streaming.
+func (rw *responseWriter) Flush() {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (rw *responseWriter) Unwrap() http.ResponseWriter { return rw.ResponseWriter }
@@ -0,0 +1,63 @@
+package cors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMiddleware(t *testing.T) {
+	var forwarded int
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded++
+		w.Header().Set("Access-Control-Allow-Origin", "https://upstream.example")
+		w.WriteHeader(http.StatusOK)
+	})
+	h := Middleware(&Config{
+		Origins: []string{"http://localhost:3000"},
+		Methods: []string{"GET", "POST"},
+		Headers: []string{"Authorization", "Content-Type"},
+		MaxAge:  600,
+	}, next)
+
+	t.Run("preflight answered locally", func(t *testing.T) {
+		req := httptest.NewRequest("OPTIONS", "/api/chat", nil)
+		req.Header.Set("Origin", "http://localhost:3000")
+		req.Header.Set("Access-Control-Request-Method", "POST")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusNoContent {
+			t.Fatalf("expected 204 got %d", rec.Code)
+		}
+		if got := rec.Header().Get("Access-Control-Allow-Methods"); got != "GET, POST" {
+			t.Fatalf("unexpected allow-methods %q", got)
+		}
+		if got := rec.Header().Get("Access-Control-Max-Age"); got != "600" {
+			t.Fatalf("unexpected max-age %q", got)
+		}
+		if forwarded != 0 {
+			t.Fatal("preflight must not be forwarded")
+		}
+	})
+
+	t.Run("preflight from unknown origin rejected", func(t *testing.T) {
+		req := httptest.NewRequest("OPTIONS", "/api/chat", nil)
+		req.Header.Set("Origin", "https://evil.example")
+		req.Header.Set("Access-Control-Request-Method", "POST")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusForbidden {
+			t.Fatalf("expected 403 got %d", rec.Code)
+		}
+	})
+
+	t.Run("actual request gets our origin header", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/api/chat", nil)
+		req.Header.Set("Origin", "http://localhost:3000")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if got := rec.Header().Values("Access-Control-Allow-Origin"); len(got) != 1 || got[0] != "http://localhost:3000" {
+			t.Fatalf("unexpected allow-origin %q", got)
+		}
+	})
+}
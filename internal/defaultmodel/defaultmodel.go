@@ -0,0 +1,163 @@
+// Package defaultmodel fills in the model of chat and generate requests
+// that don't name one, so a bare curl call or script works against the
+// proxy without knowing which models the upstream has.
+package defaultmodel
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/yeti47/ollama-proxy/internal/apierror"
+	"github.com/yeti47/ollama-proxy/internal/metrics"
+	"github.com/yeti47/ollama-proxy/internal/ollama"
+)
+
+// Header tells the client which model its request was given.
+const Header = "X-Proxy-Default-Model"
+
+// DefaultTTL is how long the upstream's model list is kept by default.
+const DefaultTTL = 30 * time.Second
+
+var injected = metrics.NewCounter("ollama_proxy_default_model_total",
+	"Requests given the default model, by reason: unset or missing.", "reason")
+
+// Injector sets the model of requests to /api/chat and /api/generate
+// that have none, and, if Missing is set, of requests for models the
+// upstream doesn't have.
+type Injector struct {
+	Model string
+	// Missing also replaces models that the upstream's /api/tags doesn't
+	// list. The list is fetched through the handler the middleware wraps,
+	// so aliases and aggregated upstreams count.
+	Missing bool
+	// TTL is how long the list is kept; DefaultTTL if zero.
+	TTL time.Duration
+
+	mu      sync.Mutex
+	models  map[string]bool
+	fetched time.Time
+}
+
+// Middleware injects the model into requests to next.
+func (in *Injector) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || (r.URL.Path != "/api/chat" && r.URL.Path != "/api/generate") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		body, err := ollama.PeekBody(r)
+		if err != nil {
+			apierror.BodyError(w, err)
+			return
+		}
+		var req map[string]json.RawMessage
+		if json.Unmarshal(body, &req) != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		var model string
+		_ = json.Unmarshal(req["model"], &model)
+		reason := ""
+		switch {
+		case model == "":
+			reason = "unset"
+		case in.Missing && ollama.NormalizeModel(model) != ollama.NormalizeModel(in.Model):
+			models, err := in.list(r, next)
+			if err != nil {
+				slog.WarnContext(r.Context(), "listing upstream models for the default model", "err", err)
+			} else if !models[ollama.NormalizeModel(model)] {
+				reason = "missing"
+			}
+		}
+		if reason == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		req["model"], _ = json.Marshal(in.Model)
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(req); err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ollama.ReplaceBody(r, bytes.TrimSuffix(buf.Bytes(), []byte("\n")))
+		injected.With(reason).Inc()
+		slog.DebugContext(r.Context(), "default model injected", "model", in.Model, "requested", model, "reason", reason)
+		w.Header().Set(Header, in.Model)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// list returns the models next serves, by normalized name.
+func (in *Injector) list(r *http.Request, next http.Handler) (map[string]bool, error) {
+	ttl := in.TTL
+	if ttl == 0 {
+		ttl = DefaultTTL
+	}
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if in.models != nil && time.Since(in.fetched) < ttl {
+		return in.models, nil
+	}
+
+	// the list doesn't depend on the caller, but the request does carry
+	// its identity through the checks in next
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+	sub := r.Clone(ctx)
+	sub.Method, sub.Body, sub.ContentLength = http.MethodGet, http.NoBody, 0
+	sub.URL.Path, sub.URL.RawPath, sub.URL.RawQuery = "/api/tags", "", ""
+	sub.Header.Del("Content-Length")
+	sub.Header.Del("Content-Type")
+	sub.Header.Del("Accept-Encoding")
+	rec := &recorder{header: http.Header{}}
+	next.ServeHTTP(rec, sub)
+	if rec.status != http.StatusOK {
+		return nil, errors.New("/api/tags answered " + http.StatusText(rec.status))
+	}
+	var tags struct {
+		Models []struct {
+			Name  string `json:"name"`
+			Model string `json:"model"`
+		} `json:"models"`
+	}
+	if err := json.Unmarshal(rec.body.Bytes(), &tags); err != nil {
+		return nil, err
+	}
+	models := map[string]bool{}
+	for _, m := range tags.Models {
+		models[ollama.NormalizeModel(m.Name)] = true
+		if m.Model != "" {
+			models[ollama.NormalizeModel(m.Model)] = true
+		}
+	}
+	in.models, in.fetched = models, time.Now()
+	return models, nil
+}
+
+// recorder collects the response of the model list request.
+type recorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *recorder) Header() http.Header { return rec.header }
+
+func (rec *recorder) WriteHeader(code int) {
+	if rec.status == 0 {
+		rec.status = code
+	}
+}
+
+func (rec *recorder) Write(b []byte) (int, error) {
+	rec.WriteHeader(http.StatusOK)
+	return rec.body.Write(b)
+}
@@ -0,0 +1,66 @@
+package defaultmodel
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func upstream(sent *string, listed *int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/tags" {
+			*listed++
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = io.WriteString(w, `{"models":[{"name":"llama3.2:3b","model":"llama3.2:3b"},{"name":"qwen3:latest","model":"qwen3:latest"}]}`)
+			return
+		}
+		var req struct{ Model string }
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		*sent = req.Model
+	})
+}
+
+func TestInject(t *testing.T) {
+	var sent string
+	var listed int
+	h := (&Injector{Model: "llama3.2:3b"}).Middleware(upstream(&sent, &listed))
+
+	for _, c := range []struct{ body, want, header string }{
+		{`{"prompt":"hi"}`, "llama3.2:3b", "llama3.2:3b"},
+		{`{"model":"","prompt":"hi"}`, "llama3.2:3b", "llama3.2:3b"},
+		{`{"model":"gone","prompt":"hi"}`, "gone", ""},
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(c.body)))
+		if sent != c.want || rec.Header().Get(Header) != c.header {
+			t.Errorf("%s: sent %q, header %q", c.body, sent, rec.Header().Get(Header))
+		}
+	}
+	if listed != 0 {
+		t.Errorf("listed models %d times without Missing", listed)
+	}
+}
+
+func TestMissing(t *testing.T) {
+	var sent string
+	var listed int
+	h := (&Injector{Model: "llama3.2:3b", Missing: true}).Middleware(upstream(&sent, &listed))
+
+	for _, c := range []struct{ model, want string }{
+		{"qwen3", "qwen3"},
+		{"gone", "llama3.2:3b"},
+		{"llama3.2:3b", "llama3.2:3b"},
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/chat", strings.NewReader(`{"model":"`+c.model+`","messages":[]}`)))
+		if sent != c.want {
+			t.Errorf("%s: sent %q, want %q", c.model, sent, c.want)
+		}
+	}
+	if listed != 1 {
+		t.Errorf("listed models %d times, want once", listed)
+	}
+}
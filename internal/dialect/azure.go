@@ -0,0 +1,42 @@
+package dialect
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// NewAzure returns a target for an Azure OpenAI resource. deployments maps
+// model names, as clients send them, to deployment names; without it the
+// model name is taken as the deployment name. /api/tags lists the mapped
+// models, or else the models of the resource, which need not all be
+// deployed.
+func NewAzure(apiVersion string, deployments map[string]string) *Target {
+	query := "?api-version=" + url.QueryEscape(apiVersion)
+	t := &Target{Version: "azure-" + apiVersion}
+	for m := range deployments {
+		t.Models = append(t.Models, m)
+	}
+	sort.Strings(t.Models)
+	t.Path = func(endpoint, model string) (string, error) {
+		if endpoint == "models" {
+			return "/openai/models" + query, nil
+		}
+		dep := model
+		if len(deployments) > 0 {
+			var ok bool
+			if dep, ok = deployments[model]; !ok {
+				dep, ok = deployments[strings.TrimSuffix(model, ":latest")]
+			}
+			if !ok {
+				return "", fmt.Errorf("model '%s' not found", model)
+			}
+		}
+		if dep == "" {
+			return "", fmt.Errorf("model is required")
+		}
+		return "/openai/deployments/" + url.PathEscape(dep) + "/" + endpoint + query, nil
+	}
+	return t
+}
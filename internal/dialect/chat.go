@@ -0,0 +1,380 @@
+package dialect
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/yeti47/ollama-proxy/internal/apierror"
+	"github.com/yeti47/ollama-proxy/internal/ollama"
+)
+
+type chatRequest struct {
+	Model    string          `json:"model"`
+	Messages []message       `json:"messages"`
+	Prompt   string          `json:"prompt"` // /api/generate
+	System   string          `json:"system"` // /api/generate
+	Images   []string        `json:"images"` // /api/generate
+	Stream   *bool           `json:"stream"`
+	Format   json.RawMessage `json:"format"`
+	Tools    json.RawMessage `json:"tools"`
+	Options  struct {
+		Temperature      *float64 `json:"temperature"`
+		TopP             *float64 `json:"top_p"`
+		NumPredict       *int     `json:"num_predict"`
+		Seed             *int     `json:"seed"`
+		FrequencyPenalty *float64 `json:"frequency_penalty"`
+		PresencePenalty  *float64 `json:"presence_penalty"`
+		Stop             []string `json:"stop"`
+	} `json:"options"`
+}
+
+type message struct {
+	Role      string     `json:"role"`
+	Content   string     `json:"content"`
+	Thinking  string     `json:"thinking,omitempty"`
+	Images    []string   `json:"images,omitempty"`
+	ToolCalls []toolCall `json:"tool_calls,omitempty"`
+	ToolName  string     `json:"tool_name,omitempty"`
+}
+
+type toolCall struct {
+	Function struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	} `json:"function"`
+}
+
+// imageType guesses the media type of base64 image data from its first
+// bytes; Ollama requests carry no type, OpenAI's data URLs need one.
+func imageType(b64 string) string {
+	switch {
+	case strings.HasPrefix(b64, "/9j/"):
+		return "image/jpeg"
+	case strings.HasPrefix(b64, "R0lGOD"):
+		return "image/gif"
+	case strings.HasPrefix(b64, "UklGR"):
+		return "image/webp"
+	}
+	return "image/png"
+}
+
+// toOpenAI converts an /api/chat or /api/generate request to a chat
+// completion request for model, the upstream's name of req.Model. Ollama's tool results carry no call ID, so calls
+// get IDs here and each result is matched to the oldest open call of
+// the same tool.
+func toOpenAI(req *chatRequest, generate bool, model string) ([]byte, error) {
+	if req.Model == "" {
+		return nil, fmt.Errorf("model is required")
+	}
+	msgs := req.Messages
+	if generate {
+		msgs = nil
+		if req.System != "" {
+			msgs = append(msgs, message{Role: "system", Content: req.System})
+		}
+		msgs = append(msgs, message{Role: "user", Content: req.Prompt, Images: req.Images})
+	}
+	var out []map[string]any
+	var open []struct{ id, name string }
+	calls := 0
+	for _, m := range msgs {
+		om := map[string]any{"role": m.Role, "content": m.Content}
+		if len(m.Images) > 0 {
+			parts := []map[string]any{{"type": "text", "text": m.Content}}
+			for _, img := range m.Images {
+				parts = append(parts, map[string]any{"type": "image_url",
+					"image_url": map[string]string{"url": "data:" + imageType(img) + ";base64," + img}})
+			}
+			om["content"] = parts
+		}
+		if len(m.ToolCalls) > 0 {
+			var tcs []map[string]any
+			for _, tc := range m.ToolCalls {
+				calls++
+				id := fmt.Sprintf("call_%d", calls)
+				open = append(open, struct{ id, name string }{id, tc.Function.Name})
+				var args bytes.Buffer
+				if json.Compact(&args, tc.Function.Arguments) != nil || args.String() == "null" {
+					args.Reset()
+					args.WriteString("{}")
+				}
+				tcs = append(tcs, map[string]any{"id": id, "type": "function",
+					"function": map[string]string{"name": tc.Function.Name, "arguments": args.String()}})
+			}
+			om["tool_calls"] = tcs
+		}
+		if m.Role == "tool" {
+			for i, c := range open {
+				if m.ToolName == "" || c.name == m.ToolName {
+					om["tool_call_id"] = c.id
+					open = append(open[:i], open[i+1:]...)
+					break
+				}
+			}
+		}
+		out = append(out, om)
+	}
+
+	stream := req.Stream == nil || *req.Stream
+	oa := map[string]any{"model": model, "messages": out, "stream": stream}
+	if stream {
+		oa["stream_options"] = map[string]bool{"include_usage": true}
+	}
+	o := req.Options
+	set := func(name string, v any, ok bool) {
+		if ok {
+			oa[name] = v
+		}
+	}
+	set("temperature", o.Temperature, o.Temperature != nil)
+	set("top_p", o.TopP, o.TopP != nil)
+	set("max_tokens", o.NumPredict, o.NumPredict != nil && *o.NumPredict > 0)
+	set("seed", o.Seed, o.Seed != nil)
+	set("frequency_penalty", o.FrequencyPenalty, o.FrequencyPenalty != nil)
+	set("presence_penalty", o.PresencePenalty, o.PresencePenalty != nil)
+	set("stop", o.Stop, len(o.Stop) > 0)
+	switch f := bytes.TrimSpace(req.Format); {
+	case len(f) == 0 || string(f) == "null" || string(f) == `""`:
+	case string(f) == `"json"`:
+		oa["response_format"] = map[string]string{"type": "json_object"}
+	case f[0] == '{':
+		oa["response_format"] = map[string]any{"type": "json_schema",
+			"json_schema": map[string]any{"name": "response", "schema": f}}
+	default:
+		return nil, fmt.Errorf("unsupported format %s", f)
+	}
+	if len(req.Tools) > 0 && string(req.Tools) != "null" {
+		oa["tools"] = req.Tools
+	}
+	return json.Marshal(oa)
+}
+
+// completion is a chat completion, or a chunk of a streamed one.
+type completion struct {
+	Choices []struct {
+		Message      delta  `json:"message"`
+		Delta        delta  `json:"delta"`
+		FinishReason string `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage"`
+	Error json.RawMessage `json:"error"`
+}
+
+type delta struct {
+	Content string `json:"content"`
+	// reasoning models behind vLLM, OpenRouter and others
+	Reasoning        string `json:"reasoning"`
+	ReasoningContent string `json:"reasoning_content"`
+	ToolCalls        []struct {
+		Index    int `json:"index"`
+		Function struct {
+			Name      string `json:"name"`
+			Arguments string `json:"arguments"`
+		} `json:"function"`
+	} `json:"tool_calls"`
+}
+
+// response builds the Ollama response, or stream, of one request.
+type response struct {
+	model    string
+	generate bool
+	start    time.Time
+
+	// streaming state
+	calls  []*toolCallState
+	reason string
+	prompt int
+	eval   int
+	done   bool
+}
+
+type toolCallState struct {
+	name string
+	args strings.Builder
+}
+
+// arguments turns OpenAI's JSON-encoded arguments into the object Ollama
+// uses; anything unparsable is passed on as a string.
+func arguments(s string) json.RawMessage {
+	if strings.TrimSpace(s) == "" {
+		return json.RawMessage("{}")
+	}
+	if json.Valid([]byte(s)) {
+		return json.RawMessage(s)
+	}
+	b, _ := json.Marshal(s)
+	return b
+}
+
+func doneReason(finish string) string {
+	if finish == "length" {
+		return "length"
+	}
+	return "stop"
+}
+
+// chunk is one Ollama response object; done ones carry the final stats.
+func (s *response) chunk(content, thinking string, calls []toolCall, done bool) map[string]any {
+	c := map[string]any{"model": s.model, "created_at": now(), "done": done}
+	if s.generate {
+		c["response"] = content
+		if thinking != "" {
+			c["thinking"] = thinking
+		}
+	} else {
+		c["message"] = message{Role: "assistant", Content: content, Thinking: thinking, ToolCalls: calls}
+	}
+	if done {
+		c["done_reason"] = doneReason(s.reason)
+		c["prompt_eval_count"] = s.prompt
+		c["eval_count"] = s.eval
+		c["total_duration"] = time.Since(s.start).Nanoseconds()
+	}
+	return c
+}
+
+func line(w http.ResponseWriter, v any) {
+	b, _ := json.Marshal(v)
+	_, _ = w.Write(append(b, '\n'))
+}
+
+// result converts a complete chat completion.
+func (s *response) result(w http.ResponseWriter, body []byte) {
+	var c completion
+	if err := json.Unmarshal(body, &c); err != nil || len(c.Choices) == 0 {
+		apierror.Write(w, http.StatusBadGateway, "upstream sent an invalid chat completion")
+		return
+	}
+	m := c.Choices[0].Message
+	var calls []toolCall
+	for _, tc := range m.ToolCalls {
+		var call toolCall
+		call.Function.Name = tc.Function.Name
+		call.Function.Arguments = arguments(tc.Function.Arguments)
+		calls = append(calls, call)
+	}
+	s.reason = c.Choices[0].FinishReason
+	if c.Usage != nil {
+		s.prompt, s.eval = c.Usage.PromptTokens, c.Usage.CompletionTokens
+	}
+	writeJSON(w, s.chunk(m.Content, m.Reasoning+m.ReasoningContent, calls, true))
+}
+
+// event converts one line of a streamed chat completion. Tool calls
+// arrive in fragments and are sent as a whole once the stream ends.
+func (s *response) event(w http.ResponseWriter, l []byte) {
+	data, ok := bytes.CutPrefix(bytes.TrimSpace(l), []byte("data:"))
+	if !ok {
+		return // blank lines, comments, event names
+	}
+	data = bytes.TrimSpace(data)
+	if string(data) == "[DONE]" {
+		s.end(w)
+		return
+	}
+	var c completion
+	if err := json.Unmarshal(data, &c); err != nil {
+		return
+	}
+	if len(c.Error) > 0 {
+		line(w, map[string]string{"error": errorText(c.Error)})
+		s.done = true
+		return
+	}
+	if c.Usage != nil {
+		s.prompt, s.eval = c.Usage.PromptTokens, c.Usage.CompletionTokens
+	}
+	if len(c.Choices) == 0 {
+		return
+	}
+	ch := c.Choices[0]
+	if ch.FinishReason != "" {
+		s.reason = ch.FinishReason
+	}
+	for _, tc := range ch.Delta.ToolCalls {
+		for len(s.calls) <= tc.Index {
+			s.calls = append(s.calls, &toolCallState{})
+		}
+		if tc.Function.Name != "" {
+			s.calls[tc.Index].name = tc.Function.Name
+		}
+		s.calls[tc.Index].args.WriteString(tc.Function.Arguments)
+	}
+	if d := ch.Delta; d.Content != "" || d.Reasoning != "" || d.ReasoningContent != "" {
+		line(w, s.chunk(d.Content, d.Reasoning+d.ReasoningContent, nil, false))
+	}
+}
+
+// end sends the collected tool calls and the final chunk, unless the
+// stream already ended.
+func (s *response) end(w http.ResponseWriter) {
+	if s.done {
+		return
+	}
+	s.done = true
+	if len(s.calls) > 0 && !s.generate {
+		var calls []toolCall
+		for _, c := range s.calls {
+			var call toolCall
+			call.Function.Name = c.name
+			call.Function.Arguments = arguments(c.args.String())
+			calls = append(calls, call)
+		}
+		line(w, s.chunk("", "", calls, false))
+	}
+	line(w, s.chunk("", "", nil, true))
+}
+
+// errorText returns the message of an error object or string.
+func errorText(raw json.RawMessage) string {
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s
+	}
+	var e struct {
+		Message string `json:"message"`
+	}
+	_ = json.Unmarshal(raw, &e)
+	return e.Message
+}
+
+// chat serves /api/chat and /api/generate from chat completions.
+func (t *Target) chat(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	body, err := ollama.PeekBody(r)
+	if err != nil {
+		apierror.BodyError(w, err)
+		return
+	}
+	var req chatRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		apierror.Write(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	generate := r.URL.Path == "/api/generate"
+	model := t.upstream(req.Model)
+	b, err := toOpenAI(&req, generate, model)
+	if err != nil {
+		apierror.Write(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	s := &response{model: req.Model, generate: generate, start: time.Now()}
+	var stream func([]byte)
+	if req.Stream == nil || *req.Stream {
+		stream = func(l []byte) { s.event(w, l) }
+	}
+	tw := t.forward(w, r, next, "chat/completions", model, b, stream)
+	if tw == nil {
+		return
+	}
+	tw.Finish(func(body []byte) { s.result(w, body) })
+	if stream != nil && tw.Streamed() {
+		s.end(w)
+	}
+}
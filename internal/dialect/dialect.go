@@ -0,0 +1,137 @@
+// Package dialect lets Ollama-native clients use upstreams that speak the
+// OpenAI API instead, such as Azure OpenAI. Requests to /api/chat,
+// /api/generate, /api/embed and /api/tags are translated to chat
+// completions, embeddings and model listings, and the responses back to
+// what Ollama would have answered.
+package dialect
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/yeti47/ollama-proxy/internal/apierror"
+	"github.com/yeti47/ollama-proxy/internal/ollama"
+	"github.com/yeti47/ollama-proxy/internal/translate"
+)
+
+// Target describes an OpenAI-compatible upstream.
+type Target struct {
+	// Path returns the upstream path, with query, of an OpenAI endpoint
+	// ("chat/completions", "embeddings" or "models") for model. An error
+	// means the model isn't available there.
+	Path func(endpoint, model string) (string, error)
+	// Models, if not nil, is served for /api/tags instead of the
+	// upstream's model listing.
+	Models []string
+	// Aliases maps model names clients use to the upstream's names. Other
+	// names are passed on unchanged. /api/tags lists them along with the
+	// upstream's models.
+	Aliases map[string]string
+	// Version is reported for /api/version.
+	Version string
+}
+
+// Middleware translates Ollama API requests for next, which forwards them
+// to the upstream. Management endpoints without an OpenAI equivalent,
+// such as pulling or deleting models, are answered with 501.
+func (t *Target) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/", "":
+			_, _ = io.WriteString(w, "Ollama is running")
+		case "/api/version":
+			writeJSON(w, map[string]string{"version": t.Version})
+		case "/api/chat", "/api/generate":
+			t.chat(w, r, next)
+		case "/api/embed", "/api/embeddings":
+			t.embed(w, r, next)
+		case "/api/tags":
+			t.tags(w, r, next)
+		case "/api/show":
+			t.show(w, r)
+		case "/api/ps":
+			writeJSON(w, map[string]any{"models": []any{}})
+		default:
+			apierror.Write(w, http.StatusNotImplemented, r.URL.Path+" is not supported by this upstream")
+		}
+	})
+}
+
+// forward sends an OpenAI request for model to next. Upstream errors come
+// back in Ollama's format.
+func (t *Target) forward(w http.ResponseWriter, r *http.Request, next http.Handler, endpoint, model string, body []byte, line func([]byte)) *translate.Writer {
+	path, err := t.Path(endpoint, model)
+	if err != nil {
+		apierror.Write(w, http.StatusNotFound, err.Error())
+		return nil
+	}
+	tw := translate.NewWriter(w, line, apierror.Write)
+	tw.ContentType = "application/x-ndjson"
+	translate.Forward(tw, r, next, r.URL.Path, path, body)
+	return tw
+}
+
+// upstream returns the upstream's name for model.
+func (t *Target) upstream(model string) string {
+	if name, ok := t.Aliases[model]; ok {
+		return name
+	}
+	if name, ok := t.Aliases[strings.TrimSuffix(model, ":latest")]; ok {
+		return name
+	}
+	return model
+}
+
+// show answers /api/show with what a client needs to treat the model as
+// usable; an OpenAI upstream has no modelfile or parameters to report.
+func (t *Target) show(w http.ResponseWriter, r *http.Request) {
+	body, err := ollama.PeekBody(r)
+	if err != nil {
+		apierror.BodyError(w, err)
+		return
+	}
+	model := ollama.Model(body)
+	if _, err := t.Path("chat/completions", t.upstream(model)); model == "" || err != nil {
+		apierror.Write(w, http.StatusNotFound, fmt.Sprintf("model '%s' not found", model))
+		return
+	}
+	writeJSON(w, map[string]any{
+		"modelfile":    "",
+		"parameters":   "",
+		"template":     "",
+		"details":      map[string]any{"format": "", "family": "", "parameter_size": "", "quantization_level": ""},
+		"model_info":   map[string]any{},
+		"capabilities": []string{"completion", "tools"},
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	b, _ := json.Marshal(v)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_, _ = w.Write(b)
+}
+
+// now is the created_at of translated responses.
+func now() string { return time.Now().UTC().Format(time.RFC3339Nano) }
+
+// ParseModelMap parses "model=name,...", the model names clients use and
+// what they stand for upstream.
+func ParseModelMap(s string) (map[string]string, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	m := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		model, name, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		model, name = strings.TrimSpace(model), strings.TrimSpace(name)
+		if !ok || model == "" || name == "" {
+			return nil, fmt.Errorf("invalid model mapping %q, want model=name", pair)
+		}
+		m[model] = name
+	}
+	return m, nil
+}
@@ -0,0 +1,245 @@
+package dialect
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// upstream answers requests to path with body, recording the forwarded
+// request body in got.
+func upstream(t *testing.T, path string, got *map[string]any, status int, body string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.RequestURI() != path {
+			t.Errorf("forwarded to %s, want %s", r.URL.RequestURI(), path)
+		}
+		if b, _ := io.ReadAll(r.Body); len(b) > 0 {
+			if err := json.Unmarshal(b, got); err != nil {
+				t.Errorf("forwarded body %s: %v", b, err)
+			}
+		}
+		w.WriteHeader(status)
+		_, _ = io.WriteString(w, body)
+	})
+}
+
+func serve(h http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+	return rec
+}
+
+const chatPath = "/openai/deployments/gpt4o-prod/chat/completions?api-version=2024-10-21"
+
+func TestChat(t *testing.T) {
+	var got map[string]any
+	target := NewAzure("2024-10-21", map[string]string{"gpt-4o": "gpt4o-prod"})
+	h := target.Middleware(upstream(t, chatPath, &got, http.StatusOK,
+		`{"choices":[{"message":{"role":"assistant","content":"It is sunny.","tool_calls":[{"id":"x","type":"function","function":{"name":"get_weather","arguments":"{\"city\":\"Oslo\"}"}}]},"finish_reason":"tool_calls"}],"usage":{"prompt_tokens":20,"completion_tokens":4}}`))
+	rec := serve(h, http.MethodPost, "/api/chat", `{
+		"model": "gpt-4o", "stream": false, "format": "json", "options": {"temperature": 0.5, "num_predict": 100},
+		"messages": [
+			{"role": "user", "content": "Weather in Oslo?", "images": ["iVBORw0KGgo="]},
+			{"role": "assistant", "content": "", "tool_calls": [{"function": {"name": "get_weather", "arguments": {"city": "Oslo"}}}]},
+			{"role": "tool", "content": "sunny", "tool_name": "get_weather"}
+		]}`)
+
+	msgs := got["messages"].([]any)
+	if len(msgs) != 3 || got["max_tokens"] != 100.0 || got["temperature"] != 0.5 || got["stream"] != false {
+		t.Fatalf("translated request %v", got)
+	}
+	img := msgs[0].(map[string]any)["content"].([]any)[1].(map[string]any)["image_url"].(map[string]any)["url"]
+	if img != "data:image/png;base64,iVBORw0KGgo=" {
+		t.Errorf("image url %v", img)
+	}
+	call := msgs[1].(map[string]any)["tool_calls"].([]any)[0].(map[string]any)
+	if call["id"] != msgs[2].(map[string]any)["tool_call_id"] || call["function"].(map[string]any)["arguments"] != `{"city":"Oslo"}` {
+		t.Errorf("tool call %v answered by %v", call, msgs[2])
+	}
+	if got["response_format"].(map[string]any)["type"] != "json_object" {
+		t.Errorf("response_format %v", got["response_format"])
+	}
+
+	var resp struct {
+		Message    message `json:"message"`
+		Done       bool    `json:"done"`
+		DoneReason string  `json:"done_reason"`
+		Prompt     int     `json:"prompt_eval_count"`
+		Eval       int     `json:"eval_count"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("%v: %s", err, rec.Body)
+	}
+	if !resp.Done || resp.DoneReason != "stop" || resp.Message.Content != "It is sunny." || resp.Prompt != 20 || resp.Eval != 4 ||
+		len(resp.Message.ToolCalls) != 1 || string(resp.Message.ToolCalls[0].Function.Arguments) != `{"city":"Oslo"}` {
+		t.Errorf("response %s", rec.Body)
+	}
+}
+
+func TestChatStream(t *testing.T) {
+	var got map[string]any
+	target := NewAzure("2024-10-21", map[string]string{"gpt-4o": "gpt4o-prod"})
+	h := target.Middleware(upstream(t, chatPath, &got, http.StatusOK, strings.Join([]string{
+		`data: {"choices":[{"delta":{"role":"assistant","content":"Hel"}}]}`, ``,
+		`data: {"choices":[{"delta":{"content":"lo"}}]}`, ``,
+		`data: {"choices":[{"delta":{"tool_calls":[{"index":0,"function":{"name":"get_weather","arguments":"{\"ci"}}]}}]}`, ``,
+		`data: {"choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":"ty\":\"Oslo\"}"}}]},"finish_reason":"tool_calls"}]}`, ``,
+		`data: {"choices":[],"usage":{"prompt_tokens":7,"completion_tokens":3}}`, ``,
+		`data: [DONE]`, ``, ``,
+	}, "\n")))
+	rec := serve(h, http.MethodPost, "/api/chat", `{"model":"gpt-4o:latest","messages":[{"role":"user","content":"hi"}]}`)
+
+	if got["stream"] != true || got["stream_options"].(map[string]any)["include_usage"] != true {
+		t.Errorf("translated request %v", got)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("Content-Type %q", ct)
+	}
+	lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("got %d lines: %s", len(lines), rec.Body)
+	}
+	var content string
+	var last struct {
+		Message message `json:"message"`
+		Done    bool    `json:"done"`
+		Prompt  int     `json:"prompt_eval_count"`
+		Eval    int     `json:"eval_count"`
+	}
+	for _, l := range lines {
+		last.Message = message{}
+		if err := json.Unmarshal([]byte(l), &last); err != nil {
+			t.Fatalf("%v: %s", err, l)
+		}
+		content += last.Message.Content
+		if len(last.Message.ToolCalls) == 1 && string(last.Message.ToolCalls[0].Function.Arguments) != `{"city":"Oslo"}` {
+			t.Errorf("tool call in %s", l)
+		}
+	}
+	if content != "Hello" || !last.Done || last.Prompt != 7 || last.Eval != 3 {
+		t.Errorf("stream %s", rec.Body)
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	var got map[string]any
+	target := NewAzure("2024-10-21", nil)
+	h := target.Middleware(upstream(t, "/openai/deployments/gpt-4o/chat/completions?api-version=2024-10-21", &got, http.StatusOK,
+		`data: {"choices":[{"delta":{"content":"Hi"},"finish_reason":"length"}]}`+"\n\n"))
+	rec := serve(h, http.MethodPost, "/api/generate", `{"model":"gpt-4o","system":"Be brief.","prompt":"Greet me"}`)
+
+	if msgs := got["messages"].([]any); len(msgs) != 2 || msgs[1].(map[string]any)["content"] != "Greet me" {
+		t.Errorf("translated request %v", got)
+	}
+	// the upstream ended without [DONE]; the final chunk is still sent
+	lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], `"response":"Hi"`) ||
+		!strings.Contains(lines[1], `"done":true`) || !strings.Contains(lines[1], `"done_reason":"length"`) {
+		t.Errorf("stream %s", rec.Body)
+	}
+}
+
+func TestEmbed(t *testing.T) {
+	var got map[string]any
+	target := NewAzure("2024-10-21", map[string]string{"embed": "ada"})
+	h := target.Middleware(upstream(t, "/openai/deployments/ada/embeddings?api-version=2024-10-21", &got, http.StatusOK,
+		`{"data":[{"index":1,"embedding":[0.3]},{"index":0,"embedding":[0.1,0.2]}],"usage":{"prompt_tokens":5}}`))
+	rec := serve(h, http.MethodPost, "/api/embed", `{"model":"embed","input":["a","b"],"dimensions":2}`)
+	if got["dimensions"] != 2.0 || len(got["input"].([]any)) != 2 {
+		t.Errorf("translated request %v", got)
+	}
+	var resp struct {
+		Embeddings [][]float64 `json:"embeddings"`
+		Prompt     int         `json:"prompt_eval_count"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || len(resp.Embeddings) != 2 || resp.Embeddings[1][0] != 0.3 || resp.Prompt != 5 {
+		t.Errorf("response %s (%v)", rec.Body, err)
+	}
+
+	rec = serve(h, http.MethodPost, "/api/embeddings", `{"model":"embed","prompt":"a"}`)
+	if got["input"] != "a" || !strings.Contains(rec.Body.String(), `"embedding":[0.1,0.2]`) {
+		t.Errorf("legacy embeddings: request %v, response %s", got, rec.Body)
+	}
+}
+
+func TestLocal(t *testing.T) {
+	target := NewAzure("2024-10-21", map[string]string{"gpt-4o": "a", "embed": "b"})
+	h := target.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("%s forwarded", r.URL.Path)
+	}))
+	if rec := serve(h, http.MethodGet, "/api/tags", ""); !strings.Contains(rec.Body.String(), `"name":"embed"`) ||
+		strings.Index(rec.Body.String(), "embed") > strings.Index(rec.Body.String(), "gpt-4o") {
+		t.Errorf("tags %s", rec.Body)
+	}
+	if rec := serve(h, http.MethodGet, "/api/version", ""); !strings.Contains(rec.Body.String(), "azure-2024-10-21") {
+		t.Errorf("version %s", rec.Body)
+	}
+	if rec := serve(h, http.MethodPost, "/api/show", `{"model":"gpt-4o"}`); rec.Code != http.StatusOK {
+		t.Errorf("show: %d %s", rec.Code, rec.Body)
+	}
+	for _, c := range []struct {
+		path, body string
+		status     int
+	}{
+		{"/api/show", `{"model":"llama3"}`, http.StatusNotFound},
+		{"/api/chat", `{"model":"llama3","messages":[]}`, http.StatusNotFound},
+		{"/api/pull", `{"model":"llama3"}`, http.StatusNotImplemented},
+		{"/api/chat", `{"model":"gpt-4o","format":42}`, http.StatusBadRequest},
+	} {
+		if rec := serve(h, http.MethodPost, c.path, c.body); rec.Code != c.status || !strings.Contains(rec.Body.String(), `"error"`) {
+			t.Errorf("%s %s: %d %s, want %d", c.path, c.body, rec.Code, rec.Body, c.status)
+		}
+	}
+}
+
+func TestUpstreamError(t *testing.T) {
+	var got map[string]any
+	target := NewAzure("2024-10-21", nil)
+	h := target.Middleware(upstream(t, "/openai/deployments/gpt-4o/chat/completions?api-version=2024-10-21", &got, http.StatusTooManyRequests,
+		`{"error":{"code":"429","message":"Rate limit exceeded"}}`))
+	rec := serve(h, http.MethodPost, "/api/chat", `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`)
+	if rec.Code != http.StatusTooManyRequests || !strings.Contains(rec.Body.String(), `{"error":"Rate limit exceeded"}`) {
+		t.Errorf("%d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestParseModelMap(t *testing.T) {
+	m, err := ParseModelMap(" gpt-4o = gpt4o-prod, embed=ada ")
+	if err != nil || len(m) != 2 || m["gpt-4o"] != "gpt4o-prod" || m["embed"] != "ada" {
+		t.Errorf("got %v, %v", m, err)
+	}
+	if m, err := ParseModelMap(""); m != nil || err != nil {
+		t.Errorf("empty: %v, %v", m, err)
+	}
+	if _, err := ParseModelMap("gpt-4o"); err == nil {
+		t.Error("missing deployment accepted")
+	}
+}
+
+func TestOpenAI(t *testing.T) {
+	var got map[string]any
+	target := NewOpenAI(map[string]string{"llama3": "meta-llama/llama-3.1-8b-instruct"})
+	h := target.Middleware(upstream(t, "/v1/chat/completions", &got, http.StatusOK,
+		`{"choices":[{"message":{"role":"assistant","content":"Hi"},"finish_reason":"stop"}]}`))
+	rec := serve(h, http.MethodPost, "/api/chat", `{"model":"llama3:latest","stream":false,"messages":[{"role":"user","content":"hi"}]}`)
+	if got["model"] != "meta-llama/llama-3.1-8b-instruct" {
+		t.Errorf("upstream model %v", got["model"])
+	}
+	if !strings.Contains(rec.Body.String(), `"model":"llama3:latest"`) {
+		t.Errorf("response %s", rec.Body)
+	}
+
+	h = target.Middleware(upstream(t, "/v1/models", &got, http.StatusOK,
+		`{"object":"list","data":[{"id":"qwen/qwen3-8b","created":1700000000}]}`))
+	rec = serve(h, http.MethodGet, "/api/tags", "")
+	var tags struct {
+		Models []tag `json:"models"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &tags); err != nil || len(tags.Models) != 2 ||
+		tags.Models[0].Name != "llama3" || tags.Models[1].Name != "qwen/qwen3-8b" || tags.Models[1].ModifiedAt.Unix() != 1700000000 {
+		t.Errorf("tags %s (%v)", rec.Body, err)
+	}
+}
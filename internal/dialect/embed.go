@@ -0,0 +1,88 @@
+package dialect
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/yeti47/ollama-proxy/internal/apierror"
+	"github.com/yeti47/ollama-proxy/internal/ollama"
+)
+
+// embed serves /api/embed, and the older /api/embeddings, from the
+// OpenAI embeddings endpoint.
+func (t *Target) embed(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	body, err := ollama.PeekBody(r)
+	if err != nil {
+		apierror.BodyError(w, err)
+		return
+	}
+	var req struct {
+		Model      string          `json:"model"`
+		Input      json.RawMessage `json:"input"`
+		Prompt     *string         `json:"prompt"` // /api/embeddings
+		Dimensions int             `json:"dimensions"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		apierror.Write(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	legacy := r.URL.Path == "/api/embeddings"
+	input := any(req.Input)
+	if legacy {
+		if req.Prompt == nil {
+			apierror.Write(w, http.StatusBadRequest, "prompt is required")
+			return
+		}
+		input = *req.Prompt
+	} else if len(req.Input) == 0 {
+		apierror.Write(w, http.StatusBadRequest, "input is required")
+		return
+	}
+	if req.Model == "" {
+		apierror.Write(w, http.StatusBadRequest, "model is required")
+		return
+	}
+	model := t.upstream(req.Model)
+	oa := map[string]any{"model": model, "input": input}
+	if req.Dimensions > 0 {
+		oa["dimensions"] = req.Dimensions
+	}
+	b, _ := json.Marshal(oa)
+	start := time.Now()
+	tw := t.forward(w, r, next, "embeddings", model, b, nil)
+	if tw == nil {
+		return
+	}
+	tw.Finish(func(body []byte) {
+		var res struct {
+			Data []struct {
+				Index     int       `json:"index"`
+				Embedding []float64 `json:"embedding"`
+			} `json:"data"`
+			Usage struct {
+				PromptTokens int `json:"prompt_tokens"`
+			} `json:"usage"`
+		}
+		if err := json.Unmarshal(body, &res); err != nil || len(res.Data) == 0 {
+			apierror.Write(w, http.StatusBadGateway, "upstream sent an invalid embeddings response")
+			return
+		}
+		vecs := make([][]float64, len(res.Data))
+		for _, d := range res.Data {
+			if d.Index >= 0 && d.Index < len(vecs) {
+				vecs[d.Index] = d.Embedding
+			}
+		}
+		if legacy {
+			writeJSON(w, map[string]any{"embedding": vecs[0]})
+			return
+		}
+		writeJSON(w, map[string]any{
+			"model":             req.Model,
+			"embeddings":        vecs,
+			"total_duration":    time.Since(start).Nanoseconds(),
+			"prompt_eval_count": res.Usage.PromptTokens,
+		})
+	})
+}
@@ -0,0 +1,16 @@
+package dialect
+
+// NewOpenAI returns a target for an upstream with an OpenAI-compatible
+// API under /v1, such as vLLM, LM Studio or OpenRouter (whose -target
+// would be https://openrouter.ai/api). aliases maps model names clients
+// use to the upstream's, which are often longer, e.g.
+// "llama3" to "meta-llama/llama-3.1-8b-instruct".
+func NewOpenAI(aliases map[string]string) *Target {
+	return &Target{
+		Version: "openai",
+		Aliases: aliases,
+		Path: func(endpoint, _ string) (string, error) {
+			return "/v1/" + endpoint, nil
+		},
+	}
+}
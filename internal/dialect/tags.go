@@ -0,0 +1,73 @@
+package dialect
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/yeti47/ollama-proxy/internal/apierror"
+)
+
+// tag is an /api/tags entry. An OpenAI upstream reports neither size nor
+// digest, so those stay empty.
+type tag struct {
+	Name       string         `json:"name"`
+	Model      string         `json:"model"`
+	ModifiedAt time.Time      `json:"modified_at"`
+	Size       int64          `json:"size"`
+	Digest     string         `json:"digest"`
+	Details    map[string]any `json:"details"`
+}
+
+func newTag(name string, created int64) tag {
+	return tag{Name: name, Model: name, ModifiedAt: time.Unix(created, 0).UTC(), Details: map[string]any{}}
+}
+
+// tags serves /api/tags from Models or the upstream's model listing.
+func (t *Target) tags(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	if t.Models != nil {
+		list := make([]tag, 0, len(t.Models))
+		for _, m := range t.Models {
+			list = append(list, newTag(m, 0))
+		}
+		writeJSON(w, map[string]any{"models": list})
+		return
+	}
+	r = r.Clone(r.Context())
+	r.Method = http.MethodGet
+	tw := t.forward(w, r, next, "models", "", nil, nil)
+	if tw == nil {
+		return
+	}
+	tw.Finish(func(body []byte) {
+		var res struct {
+			Data []struct {
+				ID      string `json:"id"`
+				Created int64  `json:"created"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(body, &res); err != nil {
+			apierror.Write(w, http.StatusBadGateway, "upstream sent an invalid model list")
+			return
+		}
+		list := make([]tag, 0, len(t.Aliases)+len(res.Data))
+		for _, m := range t.aliases() {
+			list = append(list, newTag(m, 0))
+		}
+		for _, m := range res.Data {
+			list = append(list, newTag(m.ID, m.Created))
+		}
+		writeJSON(w, map[string]any{"models": list})
+	})
+}
+
+// aliases returns the names of Aliases, sorted.
+func (t *Target) aliases() []string {
+	names := make([]string, 0, len(t.Aliases))
+	for m := range t.Aliases {
+		names = append(names, m)
+	}
+	sort.Strings(names)
+	return names
+}
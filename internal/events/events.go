@@ -0,0 +1,243 @@
+// Package events publishes one JSON event per completed request to a
+// message stream, NATS or Kafka, so analytics and billing systems can
+// consume proxy traffic as it happens instead of parsing logs.
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/yeti47/ollama-proxy/internal/auth"
+	"github.com/yeti47/ollama-proxy/internal/logging"
+	"github.com/yeti47/ollama-proxy/internal/metrics"
+	"github.com/yeti47/ollama-proxy/internal/ollama"
+)
+
+var published = metrics.NewCounter("ollama_proxy_events_total",
+	"Request events for the event stream, by result (published, failed, dropped).", "result")
+
+// Event describes one completed request. It carries metadata and token
+// usage, never prompt or completion content.
+type Event struct {
+	Time             time.Time `json:"time"`
+	RequestID        string    `json:"request_id,omitempty"`
+	Method           string    `json:"method"`
+	Path             string    `json:"path"`
+	Status           int       `json:"status"`
+	DurationMS       float64   `json:"duration_ms"`
+	Client           string    `json:"client,omitempty"`
+	Tenant           string    `json:"tenant,omitempty"`
+	Model            string    `json:"model,omitempty"`
+	PromptTokens     int       `json:"prompt_tokens,omitempty"`
+	CompletionTokens int       `json:"completion_tokens,omitempty"`
+	TotalTokens      int       `json:"total_tokens,omitempty"`
+	RequestBytes     int64     `json:"request_bytes"`
+	ResponseBytes    int64     `json:"response_bytes"`
+}
+
+// Publisher delivers batches of encoded events. Key is the partitioning
+// key of each event, the client name.
+type Publisher interface {
+	Publish(msgs []Message) error
+	Close() error
+}
+
+// Message is one encoded event.
+type Message struct {
+	Key   string
+	Value []byte
+}
+
+const (
+	// queueSize bounds the events waiting for delivery; more are dropped
+	// so a slow broker can't hold up requests.
+	queueSize = 10000
+	// batchSize is the most events handed to a Publisher at once.
+	batchSize = 100
+	// flushInterval bounds how long an event waits for its batch.
+	flushInterval = time.Second
+)
+
+// Stream queues events and publishes them in the background.
+type Stream struct {
+	pub   Publisher
+	queue chan Message
+	done  chan struct{}
+
+	mu     sync.RWMutex // guards closed against concurrent sends
+	closed bool
+}
+
+// New returns a stream publishing to pub.
+func New(pub Publisher) *Stream {
+	s := &Stream{pub: pub, queue: make(chan Message, queueSize), done: make(chan struct{})}
+	go s.run()
+	return s
+}
+
+type ctxKey struct{}
+
+// pending is the event being built for a request in flight; usage
+// arrives from the proxy's OnUsage hook before the handler returns.
+type pending struct {
+	mu    sync.Mutex
+	usage ollama.Usage
+	seen  bool
+}
+
+// Middleware publishes an event for every request passing through next.
+// It must run inside authentication so events carry the client.
+func (s *Stream) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		var model string
+		var reqBytes int
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			if body, err := ollama.PeekBody(r); err == nil {
+				model, reqBytes = ollama.Model(body), len(body)
+			}
+		}
+		p := &pending{}
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), ctxKey{}, p)))
+
+		e := Event{
+			Time:          start.UTC(),
+			RequestID:     logging.RequestID(r.Context()),
+			Method:        r.Method,
+			Path:          r.URL.Path,
+			Status:        sw.status,
+			DurationMS:    float64(time.Since(start).Microseconds()) / 1000,
+			Model:         model,
+			RequestBytes:  int64(reqBytes),
+			ResponseBytes: sw.bytes,
+		}
+		if id := auth.FromContext(r.Context()); id != nil {
+			e.Client, e.Tenant = id.Name, id.Tenant
+		}
+		p.mu.Lock()
+		if p.seen {
+			if p.usage.Model != "" {
+				e.Model = p.usage.Model
+			}
+			e.PromptTokens, e.CompletionTokens, e.TotalTokens = p.usage.PromptTokens, p.usage.CompletionTokens, p.usage.TotalTokens()
+		}
+		p.mu.Unlock()
+		if e.Model != "" {
+			e.Model = ollama.NormalizeModel(e.Model)
+		}
+		s.send(e)
+	})
+}
+
+// RecordUsage is an OnUsage hook adding token usage to the request's
+// event.
+func RecordUsage(r *http.Request, u ollama.Usage) {
+	if p, ok := r.Context().Value(ctxKey{}).(*pending); ok {
+		p.mu.Lock()
+		p.usage, p.seen = u, true
+		p.mu.Unlock()
+	}
+}
+
+func (s *Stream) send(e Event) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return
+	}
+	select {
+	case s.queue <- Message{Key: e.Client, Value: b}:
+	default:
+		published.With("dropped").Inc()
+	}
+}
+
+func (s *Stream) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	batch := make([]Message, 0, batchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := s.pub.Publish(batch); err != nil {
+			published.With("failed").Add(float64(len(batch)))
+			slog.Warn("publishing request events", "events", len(batch), "err", err)
+		} else {
+			published.With("published").Add(float64(len(batch)))
+		}
+		batch = batch[:0]
+	}
+	for {
+		select {
+		case m, ok := <-s.queue:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, m)
+			if len(batch) == batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// Close publishes the queued events and closes the publisher. It is safe
+// to call on a nil Stream.
+func (s *Stream) Close() error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.queue)
+	}
+	s.mu.Unlock()
+	<-s.done
+	return s.pub.Close()
+}
+
+// statusWriter records the response status and size. It passes Flush
+// through so streamed responses keep streaming.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	wrote  bool
+	bytes  int64
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if !w.wrote {
+		w.status, w.wrote = code, true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.wrote = true
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
@@ -0,0 +1,183 @@
+package events
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/yeti47/ollama-proxy/internal/auth"
+	"github.com/yeti47/ollama-proxy/internal/ollama"
+)
+
+type recorder struct {
+	mu   sync.Mutex
+	msgs []Message
+}
+
+func (r *recorder) Publish(msgs []Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.msgs = append(r.msgs, msgs...)
+	return nil
+}
+
+func (r *recorder) Close() error { return nil }
+
+func TestMiddleware(t *testing.T) {
+	rec := &recorder{}
+	s := New(rec)
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			_, _ = io.WriteString(w, `{"models":[]}`)
+			return
+		}
+		RecordUsage(r, ollama.Usage{Model: "llama3:latest", PromptTokens: 12, CompletionTokens: 30})
+		w.WriteHeader(http.StatusCreated)
+		_, _ = io.WriteString(w, `{"done":true}`)
+	})
+	h := s.Middleware(upstream)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/chat", strings.NewReader(`{"model":"llama3","messages":[]}`))
+	req = req.WithContext(auth.WithIdentity(req.Context(), &auth.Identity{Name: "alice", Tenant: "acme"}))
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/tags", nil))
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rec.msgs) != 2 {
+		t.Fatalf("published %d events, want 2", len(rec.msgs))
+	}
+	var e Event
+	if err := json.Unmarshal(rec.msgs[0].Value, &e); err != nil {
+		t.Fatal(err)
+	}
+	if rec.msgs[0].Key != "alice" || e.Client != "alice" || e.Tenant != "acme" || e.Status != http.StatusCreated ||
+		e.Model != "llama3:latest" || e.TotalTokens != 42 || e.ResponseBytes != 13 || e.RequestBytes == 0 {
+		t.Errorf("event %s", rec.msgs[0].Value)
+	}
+	e = Event{}
+	if err := json.Unmarshal(rec.msgs[1].Value, &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Path != "/api/tags" || e.Status != http.StatusOK || e.Client != "" {
+		t.Errorf("event %s", rec.msgs[1].Value)
+	}
+}
+
+func TestNATS(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	type pub struct{ subject, payload string }
+	got := make(chan pub, 10)
+	connect := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = io.WriteString(conn, "INFO {\"server_id\":\"test\"}\r\n")
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			f := strings.Fields(line)
+			switch f[0] {
+			case "CONNECT":
+				connect <- strings.TrimSpace(strings.TrimPrefix(line, "CONNECT"))
+			case "PING":
+				_, _ = io.WriteString(conn, "PONG\r\n")
+			case "PUB":
+				n, _ := strconv.Atoi(f[2])
+				b := make([]byte, n+2)
+				if _, err := io.ReadFull(r, b); err != nil {
+					return
+				}
+				got <- pub{f[1], string(b[:n])}
+			}
+		}
+	}()
+
+	n, err := NewNATS("nats://s3cret@"+ln.Addr().String(), "proxy.requests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+	if err := n.Publish([]Message{{Value: []byte(`{"a":1}`)}, {Value: []byte(`{"b":2}`)}}); err != nil {
+		t.Fatal(err)
+	}
+	var opts map[string]any
+	if err := json.Unmarshal([]byte(<-connect), &opts); err != nil || opts["auth_token"] != "s3cret" {
+		t.Errorf("CONNECT %v, %v", opts, err)
+	}
+	for _, want := range []string{`{"a":1}`, `{"b":2}`} {
+		if p := <-got; p.subject != "proxy.requests" || p.payload != want {
+			t.Errorf("PUB %+v, want %s", p, want)
+		}
+	}
+}
+
+func TestNATSURL(t *testing.T) {
+	for _, u := range []string{"", "localhost:4222", "http://localhost:4222"} {
+		if _, err := NewNATS(u, "s"); err == nil {
+			t.Errorf("%q accepted", u)
+		}
+	}
+	if _, err := NewNATS("nats://localhost", "bad subject"); err == nil {
+		t.Error("subject with a space accepted")
+	}
+}
+
+func TestKafkaREST(t *testing.T) {
+	var body struct {
+		Records []struct {
+			Key   *string         `json:"key"`
+			Value json.RawMessage `json:"value"`
+		} `json:"records"`
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/topics/proxy-requests" || r.Header.Get("Content-Type") != "application/vnd.kafka.json.v2+json" {
+			t.Errorf("request to %s with %q", r.URL.Path, r.Header.Get("Content-Type"))
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		_, _ = io.WriteString(w, `{"offsets":[{"partition":0,"offset":1},{"partition":0,"offset":2}]}`)
+	}))
+	defer srv.Close()
+
+	k, err := NewKafkaREST(srv.URL, "proxy-requests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := k.Publish([]Message{{Key: "alice", Value: []byte(`{"a":1}`)}, {Value: []byte(`{"b":2}`)}}); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Records) != 2 || *body.Records[0].Key != "alice" || body.Records[1].Key != nil || string(body.Records[1].Value) != `{"b":2}` {
+		t.Errorf("records %+v", body.Records)
+	}
+}
+
+func TestKafkaRESTError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, `{"offsets":[{"error_code":40403,"error":"Topic not found"}]}`)
+	}))
+	defer srv.Close()
+	k, _ := NewKafkaREST(srv.URL, "missing")
+	if err := k.Publish([]Message{{Value: []byte(`{}`)}}); err == nil || !strings.Contains(err.Error(), "Topic not found") {
+		t.Errorf("err %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// KafkaREST publishes events to a Kafka topic through a REST proxy that
+// speaks the Confluent REST Proxy v2 API, such as Confluent REST Proxy,
+// Redpanda's HTTP Proxy or Karapace. This keeps a Kafka client library
+// out of the proxy.
+type KafkaREST struct {
+	url    string
+	client *http.Client
+}
+
+// NewKafkaREST returns a publisher for topic behind the REST proxy at
+// baseURL. Basic auth credentials can be part of the URL.
+func NewKafkaREST(baseURL, topic string) (*KafkaREST, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("kafka rest proxy url must be http(s)://host:port")
+	}
+	if topic == "" {
+		return nil, fmt.Errorf("kafka topic must not be empty")
+	}
+	return &KafkaREST{
+		url:    strings.TrimSuffix(u.String(), "/") + "/topics/" + url.PathEscape(topic),
+		client: &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// Publish implements Publisher. Events are keyed by client so each
+// client's events stay in order on one partition.
+func (k *KafkaREST) Publish(msgs []Message) error {
+	type record struct {
+		Key   *string         `json:"key"`
+		Value json.RawMessage `json:"value"`
+	}
+	recs := make([]record, len(msgs))
+	for i, m := range msgs {
+		recs[i].Value = m.Value
+		if m.Key != "" {
+			key := m.Key
+			recs[i].Key = &key
+		}
+	}
+	body, err := json.Marshal(map[string]any{"records": recs})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, k.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/vnd.kafka.json.v2+json")
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json")
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("kafka rest proxy: %s: %s", resp.Status, b)
+	}
+	// per-record failures are reported in offsets
+	var result struct {
+		Offsets []struct {
+			Error string `json:"error"`
+		} `json:"offsets"`
+	}
+	if json.NewDecoder(resp.Body).Decode(&result) == nil {
+		for _, o := range result.Offsets {
+			if o.Error != "" {
+				return fmt.Errorf("kafka rest proxy: %s", o.Error)
+			}
+		}
+	}
+	return nil
+}
+
+// Close implements Publisher.
+func (k *KafkaREST) Close() error { return nil }
@@ -0,0 +1,189 @@
+package events
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NATS publishes events to a subject on a NATS server using the plain
+// text client protocol. It connects lazily and reconnects after errors.
+type NATS struct {
+	addr    string
+	tls     bool
+	subject string
+	connect []byte // the CONNECT line, with credentials from the URL
+
+	mu   sync.Mutex
+	conn net.Conn
+	w    *bufio.Writer
+	errs chan error // -ERR and connection failures from the reader
+}
+
+// NewNATS returns a publisher for rawURL, nats://[user:pass@]host:4222 or
+// tls://host:4222. A URL user without a password is sent as a token.
+func NewNATS(rawURL, subject string) (*NATS, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "nats" && u.Scheme != "tls") || u.Host == "" {
+		return nil, fmt.Errorf("nats url must be nats://host:port or tls://host:port")
+	}
+	if subject == "" || strings.ContainsAny(subject, " \t\r\n") {
+		return nil, fmt.Errorf("invalid nats subject %q", subject)
+	}
+	opts := map[string]any{"verbose": false, "pedantic": false, "name": "ollama-proxy", "lang": "go", "version": "1", "protocol": 0}
+	if u.User != nil {
+		if pass, ok := u.User.Password(); ok {
+			opts["user"], opts["pass"] = u.User.Username(), pass
+		} else {
+			opts["auth_token"] = u.User.Username()
+		}
+	}
+	if u.Scheme == "tls" {
+		opts["tls_required"] = true
+	}
+	b, _ := json.Marshal(opts)
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "4222")
+	}
+	return &NATS{addr: addr, tls: u.Scheme == "tls", subject: subject, connect: append(append([]byte("CONNECT "), b...), "\r\nPING\r\n"...)}, nil
+}
+
+// dial connects, reads the server's INFO, authenticates and waits for the
+// PONG confirming the connection is usable.
+func (n *NATS) dial() error {
+	conn, err := net.DialTimeout("tcp", n.addr, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+	r := bufio.NewReader(conn)
+	line, err := r.ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "INFO ") {
+		conn.Close()
+		return fmt.Errorf("nats: unexpected greeting %q: %v", strings.TrimSpace(line), err)
+	}
+	if n.tls {
+		host, _, _ := net.SplitHostPort(n.addr)
+		tc := tls.Client(conn, &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12})
+		if err := tc.Handshake(); err != nil {
+			conn.Close()
+			return err
+		}
+		conn, r = tc, bufio.NewReader(tc)
+	}
+	if _, err := conn.Write(n.connect); err != nil {
+		conn.Close()
+		return err
+	}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			conn.Close()
+			return err
+		}
+		switch line = strings.TrimSpace(line); {
+		case line == "PONG":
+			_ = conn.SetDeadline(time.Time{})
+			n.conn, n.w, n.errs = conn, bufio.NewWriter(conn), make(chan error, 1)
+			go n.read(conn, r, n.errs)
+			return nil
+		case strings.HasPrefix(line, "-ERR"):
+			conn.Close()
+			return fmt.Errorf("nats: %s", line)
+		}
+		// +OK or a repeated INFO
+	}
+}
+
+// read answers server PINGs and reports errors, until the connection
+// fails.
+func (n *NATS) read(conn net.Conn, r *bufio.Reader, errs chan<- error) {
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			select {
+			case errs <- err:
+			default:
+			}
+			return
+		}
+		switch line = strings.TrimSpace(line); {
+		case line == "PING":
+			n.mu.Lock()
+			if n.conn == conn {
+				_, _ = n.w.WriteString("PONG\r\n")
+				_ = n.w.Flush()
+			}
+			n.mu.Unlock()
+		case strings.HasPrefix(line, "-ERR"):
+			select {
+			case errs <- errors.New("nats: " + line):
+			default:
+			}
+		}
+	}
+}
+
+// Publish implements Publisher. The events of a batch are written in one
+// go; a connection found broken is re-established once.
+func (n *NATS) Publish(msgs []Message) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if n.conn != nil {
+			select {
+			case err = <-n.errs:
+				n.reset()
+			default:
+			}
+		}
+		if n.conn == nil {
+			if err = n.dial(); err != nil {
+				continue
+			}
+		}
+		if err = n.write(msgs); err == nil {
+			return nil
+		}
+		n.reset()
+	}
+	return err
+}
+
+func (n *NATS) write(msgs []Message) error {
+	_ = n.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	for _, m := range msgs {
+		fmt.Fprintf(n.w, "PUB %s %d\r\n", n.subject, len(m.Value))
+		n.w.Write(m.Value)
+		n.w.WriteString("\r\n")
+	}
+	return n.w.Flush()
+}
+
+func (n *NATS) reset() {
+	if n.conn != nil {
+		n.conn.Close()
+		n.conn = nil
+	}
+}
+
+// Close implements Publisher.
+func (n *NATS) Close() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.conn == nil {
+		return nil
+	}
+	err := n.w.Flush()
+	n.reset()
+	return err
+}
@@ -0,0 +1,198 @@
+// Package framing converts streamed responses between Ollama's NDJSON
+// framing and server-sent events, for clients that can only consume one
+// of them: browsers' EventSource only reads text/event-stream, while some
+// Ollama clients only parse newline-delimited JSON.
+package framing
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// Framings a response can be converted to.
+const (
+	SSE    = "sse"
+	NDJSON = "ndjson"
+)
+
+const (
+	ndjsonType = "application/x-ndjson"
+	sseType    = "text/event-stream"
+)
+
+// Converter picks the framing of each response, by route or by the
+// client's Accept header.
+type Converter struct {
+	// Routes forces a framing for responses to these paths, whatever
+	// the client accepts.
+	Routes map[string]string
+}
+
+// ParseRoutes parses comma-separated path=framing rules such as
+// "/api/chat=sse,/v1/chat/completions=ndjson".
+func ParseRoutes(s string) (map[string]string, error) {
+	routes := map[string]string{}
+	for _, rule := range strings.Split(s, ",") {
+		if rule = strings.TrimSpace(rule); rule == "" {
+			continue
+		}
+		path, f, ok := strings.Cut(rule, "=")
+		if !ok || !strings.HasPrefix(path, "/") || (f != SSE && f != NDJSON) {
+			return nil, fmt.Errorf("stream format rule %q: want /path=sse or /path=ndjson", rule)
+		}
+		routes[path] = f
+	}
+	return routes, nil
+}
+
+// target returns the framing r's response should have, or "" to leave it
+// alone. A route rule wins over the Accept header.
+func (c *Converter) target(r *http.Request) string {
+	if f, ok := c.Routes[r.URL.Path]; ok {
+		return f
+	}
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mt, _, _ := mime.ParseMediaType(strings.TrimSpace(part))
+		switch mt {
+		case sseType:
+			return SSE
+		case ndjsonType:
+			return NDJSON
+		}
+	}
+	return ""
+}
+
+// Middleware converts the framing of streamed responses from next where a
+// route or the Accept header asks for it. Responses in any other format,
+// and error responses, pass through untouched.
+func (c *Converter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		to := c.target(r)
+		if to == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// the body is rewritten on the way back, so it must arrive
+		// uncompressed
+		r.Header.Del("Accept-Encoding")
+		cw := &convertWriter{ResponseWriter: w, to: to}
+		next.ServeHTTP(cw, r)
+		cw.finish()
+	})
+}
+
+type convertWriter struct {
+	http.ResponseWriter
+	to      string
+	wrote   bool
+	from    string // the framing being converted, once known
+	buf     []byte
+	pending [][]byte // data lines of the SSE event being read
+}
+
+func (w *convertWriter) WriteHeader(code int) {
+	if w.wrote {
+		return
+	}
+	w.wrote = true
+	h := w.Header()
+	mt, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	switch {
+	case code != http.StatusOK:
+	case w.to == SSE && mt == ndjsonType:
+		w.from = NDJSON
+		h.Set("Content-Type", sseType)
+		h.Set("Cache-Control", "no-cache")
+		h.Del("Content-Length")
+	case w.to == NDJSON && mt == sseType:
+		w.from = SSE
+		h.Set("Content-Type", ndjsonType)
+		h.Del("Content-Length")
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *convertWriter) Write(b []byte) (int, error) {
+	if !w.wrote {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.from == "" {
+		return w.ResponseWriter.Write(b)
+	}
+	w.buf = append(w.buf, b...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i == -1 {
+			break
+		}
+		if err := w.line(w.buf[:i]); err != nil {
+			return 0, err
+		}
+		w.buf = w.buf[i+1:]
+	}
+	return len(b), nil
+}
+
+// line converts one line of the response.
+func (w *convertWriter) line(l []byte) error {
+	l = bytes.TrimRight(l, "\r")
+	if w.from == NDJSON {
+		if len(bytes.TrimSpace(l)) == 0 {
+			return nil
+		}
+		_, err := fmt.Fprintf(w.ResponseWriter, "data: %s\n\n", l)
+		return err
+	}
+	// SSE: data lines accumulate until the blank line ending the event;
+	// event names, IDs, retry hints and comments have no NDJSON equivalent
+	if len(l) == 0 {
+		return w.dispatch()
+	}
+	if data, ok := bytes.CutPrefix(l, []byte("data:")); ok {
+		w.pending = append(w.pending, bytes.TrimPrefix(data, []byte(" ")))
+	}
+	return nil
+}
+
+// dispatch writes the SSE event read so far as one NDJSON line. OpenAI's
+// closing [DONE] marker is dropped: NDJSON streams simply end.
+func (w *convertWriter) dispatch() error {
+	if len(w.pending) == 0 {
+		return nil
+	}
+	data := bytes.Join(w.pending, []byte("\n"))
+	w.pending = w.pending[:0]
+	if string(data) == "[DONE]" {
+		return nil
+	}
+	// a multi-line payload would break NDJSON framing
+	data = bytes.ReplaceAll(data, []byte("\n"), []byte(" "))
+	_, err := w.ResponseWriter.Write(append(data, '\n'))
+	return err
+}
+
+// finish converts what is left after the handler returned.
+func (w *convertWriter) finish() {
+	if w.from == "" {
+		return
+	}
+	if len(w.buf) > 0 {
+		_ = w.line(w.buf)
+		w.buf = nil
+	}
+	if w.from == SSE {
+		_ = w.dispatch()
+	}
+}
+
+func (w *convertWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *convertWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
@@ -0,0 +1,79 @@
+package framing
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func serve(contentType, body string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Length", "999")
+		// split mid-line to check lines are reassembled
+		_, _ = io.WriteString(w, body[:10])
+		w.(http.Flusher).Flush()
+		_, _ = io.WriteString(w, body[10:])
+	})
+}
+
+func TestNDJSONToSSE(t *testing.T) {
+	c := &Converter{}
+	h := c.Middleware(serve("application/x-ndjson", "{\"response\":\"Hel\"}\n{\"response\":\"lo\"}\n\n{\"done\":true}"))
+	req := httptest.NewRequest(http.MethodPost, "/api/generate", nil)
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	want := "data: {\"response\":\"Hel\"}\n\ndata: {\"response\":\"lo\"}\n\ndata: {\"done\":true}\n\n"
+	if rec.Body.String() != want || rec.Header().Get("Content-Type") != "text/event-stream" || rec.Header().Get("Content-Length") != "" {
+		t.Errorf("%v %q", rec.Header(), rec.Body)
+	}
+	if req.Header.Get("Accept-Encoding") != "" {
+		t.Error("Accept-Encoding was forwarded")
+	}
+}
+
+func TestSSEToNDJSON(t *testing.T) {
+	c := &Converter{Routes: map[string]string{"/v1/chat/completions": NDJSON}}
+	h := c.Middleware(serve("text/event-stream; charset=utf-8",
+		": keep-alive\n\nevent: chunk\nid: 1\ndata: {\"a\":1}\r\n\r\ndata: {\"b\":\ndata: 2}\n\ndata: [DONE]\n\n"))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil))
+
+	if want := "{\"a\":1}\n{\"b\": 2}\n"; rec.Body.String() != want || rec.Header().Get("Content-Type") != "application/x-ndjson" {
+		t.Errorf("%v %q, want %q", rec.Header(), rec.Body, want)
+	}
+}
+
+func TestPassThrough(t *testing.T) {
+	c := &Converter{}
+	for _, tc := range []struct{ accept, contentType string }{
+		{"", "application/x-ndjson"},
+		{"text/event-stream", "application/json"},
+		{"application/x-ndjson", "application/x-ndjson"},
+	} {
+		h := c.Middleware(serve(tc.contentType, `{"model":"llama3","done":true}`))
+		req := httptest.NewRequest(http.MethodPost, "/api/chat", nil)
+		req.Header.Set("Accept", tc.accept)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Body.String() != `{"model":"llama3","done":true}` || rec.Header().Get("Content-Type") != tc.contentType {
+			t.Errorf("%+v: %v %q", tc, rec.Header(), rec.Body)
+		}
+	}
+}
+
+func TestParseRoutes(t *testing.T) {
+	routes, err := ParseRoutes(" /api/chat=sse, /v1/chat/completions=ndjson,")
+	if err != nil || len(routes) != 2 || routes["/api/chat"] != SSE || routes["/v1/chat/completions"] != NDJSON {
+		t.Errorf("%v, %v", routes, err)
+	}
+	for _, s := range []string{"/api/chat", "api/chat=sse", "/api/chat=json"} {
+		if _, err := ParseRoutes(s); err == nil {
+			t.Errorf("%q accepted", s)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+// Package geoip restricts access by the country of the client address,
+// using a MaxMind GeoIP2/GeoLite2 Country or City database.
+package geoip
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/yeti47/ollama-proxy/internal/apierror"
+	"github.com/yeti47/ollama-proxy/internal/audit"
+	"github.com/yeti47/ollama-proxy/internal/ipfilter"
+	"github.com/yeti47/ollama-proxy/internal/metrics"
+)
+
+var rejections = metrics.NewCounter("ollama_proxy_geoip_rejections_total",
+	"Requests rejected by the GeoIP country filter.", "country")
+
+// ParseCountries parses a comma-separated list of ISO country codes.
+func ParseCountries(s string) map[string]bool {
+	var out map[string]bool
+	for _, c := range strings.Split(s, ",") {
+		c = strings.ToUpper(strings.TrimSpace(c))
+		if c == "" {
+			continue
+		}
+		if out == nil {
+			out = make(map[string]bool)
+		}
+		out[c] = true
+	}
+	return out
+}
+
+// Filter decides whether a client may use the proxy based on its country.
+// Deny rules win over allow rules; an empty allow list allows every
+// country not denied. Private and loopback addresses are always allowed.
+// Public addresses the database has no country for are rejected only when
+// an allow list is set.
+type Filter struct {
+	db      atomic.Pointer[DB]
+	Allow   map[string]bool
+	Deny    map[string]bool
+	Trusted ipfilter.Prefixes
+}
+
+// NewFilter returns a filter using db.
+func NewFilter(db *DB, allow, deny map[string]bool, trusted ipfilter.Prefixes) *Filter {
+	f := &Filter{Allow: allow, Deny: deny, Trusted: trusted}
+	f.db.Store(db)
+	return f
+}
+
+// SetDB replaces the database, e.g. after a weekly update.
+func (f *Filter) SetDB(db *DB) {
+	f.db.Store(db)
+}
+
+// Allowed reports whether r may proceed, along with the country it was
+// judged on.
+func (f *Filter) Allowed(r *http.Request) (string, bool) {
+	addr, ok := ipfilter.ClientIP(r, f.Trusted)
+	if !ok {
+		return "", false
+	}
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast() {
+		return "", true
+	}
+	country, err := f.db.Load().Country(addr)
+	if err != nil {
+		slog.WarnContext(r.Context(), "geoip lookup failed", "addr", addr, "err", err)
+	}
+	if country == "" {
+		return "", len(f.Allow) == 0
+	}
+	if f.Deny[country] {
+		return country, false
+	}
+	return country, len(f.Allow) == 0 || f.Allow[country]
+}
+
+// Middleware rejects requests from countries f does not allow with 403.
+func Middleware(f *Filter, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		country, ok := f.Allowed(r)
+		if !ok {
+			label := country
+			if label == "" {
+				label = "unknown"
+			}
+			rejections.With(label).Inc()
+			slog.WarnContext(r.Context(), "geoip rejected", "remote", r.RemoteAddr, "country", label, "method", r.Method, "path", r.URL.Path)
+			audit.Event(audit.Blocked, r, "", "geoip: "+label)
+			apierror.Write(w, http.StatusForbidden, "forbidden")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
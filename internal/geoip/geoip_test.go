@@ -0,0 +1,158 @@
+package geoip
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+// buildDB writes a minimal IPv6 MaxMind DB with 24-bit records mapping
+// each prefix to a {"country": {"iso_code": code}} record.
+func buildDB(t *testing.T, countries map[string]string) []byte {
+	t.Helper()
+	type node struct {
+		child [2]*node
+		data  int
+	}
+	root := &node{data: -1}
+	var data []byte
+	str := func(s string) []byte { return append([]byte{2<<5 | byte(len(s))}, s...) }
+	first := map[string]int{}
+	for prefix, code := range countries {
+		p := netip.MustParsePrefix(prefix)
+		bits := p.Bits()
+		a := p.Addr().As16()
+		if p.Addr().Is4() {
+			// IPv4 lives under ::/96 in an IPv6 tree
+			a = [16]byte{}
+			v4 := p.Addr().As4()
+			copy(a[12:], v4[:])
+			bits += 96
+		}
+		n := root
+		for i := 0; i < bits; i++ {
+			b := a[i/8] >> (7 - i%8) & 1
+			if n.child[b] == nil {
+				n.child[b] = &node{data: -1}
+			}
+			n = n.child[b]
+		}
+		if off, ok := first[code]; ok {
+			// reuse the record through a pointer, as real databases do
+			n.data = len(data)
+			data = append(data, 1<<5|byte(off>>8), byte(off))
+			continue
+		}
+		first[code] = len(data)
+		n.data = len(data)
+		data = append(data, 7<<5|1)
+		data = append(data, str("country")...)
+		data = append(data, 7<<5|1)
+		data = append(data, str("iso_code")...)
+		data = append(data, str(code)...)
+	}
+
+	var nodes []*node
+	ids := map[*node]int{}
+	queue := []*node{root}
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		ids[n] = len(nodes)
+		nodes = append(nodes, n)
+		for _, c := range n.child {
+			if c != nil && c.data < 0 {
+				queue = append(queue, c)
+			}
+		}
+	}
+	count := len(nodes)
+	var buf []byte
+	for _, n := range nodes {
+		for _, c := range n.child {
+			v := count
+			switch {
+			case c == nil:
+			case c.data >= 0:
+				v = count + 16 + c.data
+			default:
+				v = ids[c]
+			}
+			buf = append(buf, byte(v>>16), byte(v>>8), byte(v))
+		}
+	}
+	buf = append(buf, make([]byte, 16)...)
+	buf = append(buf, data...)
+	buf = append(buf, metadataMarker...)
+	buf = append(buf, 7<<5|4)
+	buf = append(buf, str("node_count")...)
+	buf = append(buf, 6<<5|4, byte(count>>24), byte(count>>16), byte(count>>8), byte(count))
+	buf = append(buf, str("record_size")...)
+	buf = append(buf, 5<<5|1, 24)
+	buf = append(buf, str("ip_version")...)
+	buf = append(buf, 5<<5|1, 6)
+	buf = append(buf, str("database_type")...)
+	buf = append(buf, str("Test-Country")...)
+	return buf
+}
+
+func TestCountry(t *testing.T) {
+	db, err := Load(buildDB(t, map[string]string{
+		"81.2.69.0/24":  "GB",
+		"1.128.0.0/11":  "AU",
+		"2.125.0.0/16":  "GB",
+		"2001:db8::/32": "DE",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.DBType != "Test-Country" {
+		t.Errorf("DBType = %q", db.DBType)
+	}
+	for ip, want := range map[string]string{
+		"81.2.69.160":      "GB",
+		"1.130.4.4":        "AU",
+		"2.125.160.216":    "GB",
+		"::ffff:2.125.1.1": "GB",
+		"2001:db8::1":      "DE",
+		"8.8.8.8":          "",
+		"2a00::1":          "",
+	} {
+		got, err := db.Country(netip.MustParseAddr(ip))
+		if err != nil || got != want {
+			t.Errorf("Country(%s) = %q, %v; want %q", ip, got, err, want)
+		}
+	}
+	if _, err := Load(bytes.Repeat([]byte{0}, 64)); err == nil {
+		t.Error("Load accepted garbage")
+	}
+}
+
+func TestFilter(t *testing.T) {
+	db, err := Load(buildDB(t, map[string]string{"81.2.69.0/24": "GB", "1.128.0.0/11": "AU"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		allow, deny string
+		remote      string
+		want        bool
+	}{
+		{"", "AU", "1.130.4.4:1234", false},
+		{"", "AU", "81.2.69.1:1234", true},
+		{"", "AU", "8.8.8.8:1234", true},
+		{"gb", "", "81.2.69.1:1234", true},
+		{"gb", "", "1.130.4.4:1234", false},
+		{"gb", "", "8.8.8.8:1234", false},
+		{"gb", "", "192.168.1.5:1234", true},
+		{"gb", "", "127.0.0.1:1234", true},
+	} {
+		f := NewFilter(db, ParseCountries(tc.allow), ParseCountries(tc.deny), nil)
+		r := httptest.NewRequest("GET", "/api/tags", nil)
+		r.RemoteAddr = tc.remote
+		if _, got := f.Allowed(r); got != tc.want {
+			t.Errorf("allow=%q deny=%q %s: allowed=%t, want %t", tc.allow, tc.deny, tc.remote, got, tc.want)
+		}
+	}
+}
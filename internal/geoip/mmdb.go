@@ -0,0 +1,302 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net/netip"
+	"os"
+)
+
+// metadataMarker precedes the metadata map at the end of a MaxMind DB file.
+var metadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// DB is a MaxMind DB (.mmdb) file loaded into memory. Only the parts of
+// the format needed for lookups are implemented; see
+// https://maxmind.github.io/MaxMind-DB/ for the specification.
+type DB struct {
+	buf        []byte
+	data       []byte // data section
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	ipv4Start  uint
+	DBType     string
+}
+
+// Open loads the database at path.
+func Open(path string) (*DB, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Load(buf)
+}
+
+// Load parses a database from buf.
+func Load(buf []byte) (*DB, error) {
+	i := bytes.LastIndex(buf, metadataMarker)
+	if i < 0 {
+		return nil, errors.New("geoip: not a MaxMind DB file")
+	}
+	meta, _, err := decode(buf[i+len(metadataMarker):], 0)
+	if err != nil {
+		return nil, fmt.Errorf("geoip: metadata: %w", err)
+	}
+	m, ok := meta.(map[string]any)
+	if !ok {
+		return nil, errors.New("geoip: metadata is not a map")
+	}
+	db := &DB{buf: buf}
+	db.nodeCount = uint(asUint(m["node_count"]))
+	db.recordSize = uint(asUint(m["record_size"]))
+	db.ipVersion = uint(asUint(m["ip_version"]))
+	db.DBType, _ = m["database_type"].(string)
+	switch db.recordSize {
+	case 24, 28, 32:
+	default:
+		return nil, fmt.Errorf("geoip: unsupported record size %d", db.recordSize)
+	}
+	treeSize := db.nodeCount * db.recordSize / 4
+	if treeSize+16 > uint(i) {
+		return nil, errors.New("geoip: search tree exceeds file size")
+	}
+	db.data = buf[treeSize+16 : i]
+
+	if db.ipVersion == 6 {
+		node := uint(0)
+		for n := 0; n < 96 && node < db.nodeCount; n++ {
+			node = db.record(node, 0)
+		}
+		db.ipv4Start = node
+	}
+	return db, nil
+}
+
+// record returns the left (bit 0) or right (bit 1) record of node.
+func (db *DB) record(node, bit uint) uint {
+	switch db.recordSize {
+	case 24:
+		off := node*6 + bit*3
+		b := db.buf[off : off+3]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		b := db.buf[node*7 : node*7+7]
+		if bit == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		off := node*8 + bit*4
+		return uint(binary.BigEndian.Uint32(db.buf[off : off+4]))
+	}
+}
+
+// Lookup returns the record for addr, or nil if the database has none.
+func (db *DB) Lookup(addr netip.Addr) (any, error) {
+	addr = addr.Unmap()
+	var ip []byte
+	node := uint(0)
+	if addr.Is4() {
+		a := addr.As4()
+		ip = a[:]
+		node = db.ipv4Start
+	} else {
+		if db.ipVersion == 4 {
+			return nil, nil
+		}
+		a := addr.As16()
+		ip = a[:]
+	}
+	for i := 0; i < len(ip)*8 && node < db.nodeCount; i++ {
+		bit := uint(ip[i/8]>>(7-uint(i%8))) & 1
+		node = db.record(node, bit)
+	}
+	switch {
+	case node == db.nodeCount:
+		return nil, nil
+	case node < db.nodeCount:
+		return nil, errors.New("geoip: invalid search tree")
+	}
+	off := node - db.nodeCount - 16
+	if off >= uint(len(db.data)) {
+		return nil, errors.New("geoip: data pointer out of range")
+	}
+	v, _, err := decode(db.data, off)
+	return v, err
+}
+
+// Country returns the ISO 3166-1 alpha-2 code of the country addr is
+// located in, falling back to the registered country, or "" if unknown.
+func (db *DB) Country(addr netip.Addr) (string, error) {
+	v, err := db.Lookup(addr)
+	if err != nil {
+		return "", err
+	}
+	m, _ := v.(map[string]any)
+	for _, field := range []string{"country", "registered_country"} {
+		if c, ok := m[field].(map[string]any); ok {
+			if code, ok := c["iso_code"].(string); ok && code != "" {
+				return code, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// Data section field types.
+const (
+	typeExtended = iota
+	typePointer
+	typeString
+	typeDouble
+	typeBytes
+	typeUint16
+	typeUint32
+	typeMap
+	typeInt32
+	typeUint64
+	typeUint128
+	typeArray
+	typeContainer
+	typeEndMarker
+	typeBool
+	typeFloat
+)
+
+var errTruncated = errors.New("geoip: truncated data")
+
+// decode decodes the value at off in data and returns it with the offset
+// just past it. Maps decode to map[string]any, arrays to []any, unsigned
+// integers to uint64 and 128-bit integers to []byte.
+func decode(data []byte, off uint) (any, uint, error) {
+	if off >= uint(len(data)) {
+		return nil, 0, errTruncated
+	}
+	ctrl := data[off]
+	off++
+	typ := uint(ctrl >> 5)
+	if typ == typePointer {
+		ptr, next, err := pointer(data, ctrl, off)
+		if err != nil {
+			return nil, 0, err
+		}
+		v, _, err := decode(data, ptr)
+		return v, next, err
+	}
+	if typ == typeExtended {
+		if off >= uint(len(data)) {
+			return nil, 0, errTruncated
+		}
+		typ = 7 + uint(data[off])
+		off++
+	}
+	size := uint(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if off+n > uint(len(data)) {
+			return nil, 0, errTruncated
+		}
+		var ext uint
+		for _, b := range data[off : off+n] {
+			ext = ext<<8 | uint(b)
+		}
+		off += n
+		size = [...]uint{29, 285, 65821}[n-1] + ext
+	}
+
+	switch typ {
+	case typeMap:
+		m := make(map[string]any, size)
+		for i := uint(0); i < size; i++ {
+			k, next, err := decode(data, off)
+			if err != nil {
+				return nil, 0, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, 0, errors.New("geoip: map key is not a string")
+			}
+			v, next, err := decode(data, next)
+			if err != nil {
+				return nil, 0, err
+			}
+			m[key] = v
+			off = next
+		}
+		return m, off, nil
+	case typeArray:
+		a := make([]any, 0, size)
+		for i := uint(0); i < size; i++ {
+			v, next, err := decode(data, off)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, v)
+			off = next
+		}
+		return a, off, nil
+	case typeBool:
+		return size != 0, off, nil
+	}
+
+	if off+size > uint(len(data)) {
+		return nil, 0, errTruncated
+	}
+	b := data[off : off+size]
+	off += size
+	switch typ {
+	case typeString:
+		return string(b), off, nil
+	case typeBytes, typeUint128:
+		return append([]byte(nil), b...), off, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, errors.New("geoip: bad double size")
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), off, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, errors.New("geoip: bad float size")
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), off, nil
+	case typeUint16, typeUint32, typeUint64:
+		var v uint64
+		for _, c := range b {
+			v = v<<8 | uint64(c)
+		}
+		return v, off, nil
+	case typeInt32:
+		var v uint32
+		for _, c := range b {
+			v = v<<8 | uint32(c)
+		}
+		return int64(int32(v)), off, nil
+	}
+	return nil, 0, fmt.Errorf("geoip: unsupported data type %d", typ)
+}
+
+// pointer decodes a pointer whose control byte is ctrl and whose payload
+// starts at off, returning the target offset and the offset after it.
+func pointer(data []byte, ctrl byte, off uint) (uint, uint, error) {
+	n := uint(ctrl>>3)&3 + 1
+	if off+n > uint(len(data)) {
+		return 0, 0, errTruncated
+	}
+	var p uint
+	if n < 4 {
+		p = uint(ctrl & 7)
+	}
+	for _, b := range data[off : off+n] {
+		p = p<<8 | uint(b)
+	}
+	p += [...]uint{0, 2048, 526336, 0}[n-1]
+	return p, off + n, nil
+}
+
+func asUint(v any) uint64 {
+	n, _ := v.(uint64)
+	return n
+}
@@ -0,0 +1,296 @@
+// Package grpcapi serves the proxied API over gRPC: the Chat, Generate,
+// Embed and List calls of proto/ollama/v1/ollama.proto are translated to
+// /api/chat, /api/generate, /api/embed and /api/tags, for services that
+// prefer typed messages and deadlines to HTTP streaming. It speaks the
+// gRPC wire protocol itself, over the HTTP/2 of net/http, so it needs no
+// gRPC library, only a TLS listener for HTTP/2.
+package grpcapi
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yeti47/ollama-proxy/internal/ollama"
+	"github.com/yeti47/ollama-proxy/internal/translate"
+)
+
+// Service is the path prefix of the service's methods.
+const Service = "/ollama.proxy.v1.Ollama/"
+
+// gRPC status codes.
+const (
+	codeOK                = 0
+	codeCanceled          = 1
+	codeUnknown           = 2
+	codeInvalidArgument   = 3
+	codeDeadlineExceeded  = 4
+	codeNotFound          = 5
+	codePermissionDenied  = 7
+	codeResourceExhausted = 8
+	codeUnimplemented     = 12
+	codeInternal          = 13
+	codeUnavailable       = 14
+	codeUnauthenticated   = 16
+)
+
+// Middleware serves gRPC calls to Service through next. Other requests
+// pass through untouched.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, ok := strings.CutPrefix(r.URL.Path, Service)
+		if !ok || r.Method != http.MethodPost {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+			http.Error(w, "gRPC requests must have Content-Type application/grpc", http.StatusUnsupportedMediaType)
+			return
+		}
+		c := &call{w: w}
+		if t := r.Header.Get("Grpc-Timeout"); t != "" {
+			d, err := parseTimeout(t)
+			if err != nil {
+				c.finish(codeInvalidArgument, err.Error())
+				return
+			}
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+			r = r.WithContext(ctx)
+		}
+		body, err := ollama.PeekBody(r)
+		if err != nil {
+			c.finish(codeInvalidArgument, "reading request: "+err.Error())
+			return
+		}
+		msg, err := readFrame(body)
+		if err != nil {
+			c.finish(codeInvalidArgument, err.Error())
+			return
+		}
+		switch method {
+		case "Chat":
+			var req chatRequest
+			c.stream(r, next, "/api/chat", msg, req.unmarshal, req.ollama, (*chunk).chatResponse)
+		case "Generate":
+			var req generateRequest
+			c.stream(r, next, "/api/generate", msg, req.unmarshal, req.ollama, (*chunk).generateResponse)
+		case "Embed":
+			var req embedRequest
+			c.unary(r, next, "/api/embed", msg, req.unmarshal, req.ollama, embedResponse)
+		case "List":
+			get := r.Clone(r.Context())
+			get.Method = http.MethodGet
+			c.unary(get, next, "/api/tags", msg, func([]byte) error { return nil }, nil, listResponse)
+		default:
+			c.finish(codeUnimplemented, "unknown method "+method)
+		}
+	})
+}
+
+// call writes the response of one gRPC call.
+type call struct {
+	w       http.ResponseWriter
+	started bool
+	code    int
+	msg     string
+}
+
+// start sends the response headers.
+func (c *call) start() {
+	if !c.started {
+		c.started = true
+		c.w.Header().Set("Content-Type", "application/grpc")
+		c.w.Header().Del("Content-Length")
+		c.w.WriteHeader(http.StatusOK)
+	}
+}
+
+// send writes one length-prefixed message.
+func (c *call) send(m []byte) {
+	c.start()
+	frame := make([]byte, 5, 5+len(m))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(m)))
+	_, _ = c.w.Write(append(frame, m...))
+	if f, ok := c.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// finish ends the call with a status: in the trailers after messages, or
+// in the headers of a response without any.
+func (c *call) finish(code int, msg string) {
+	h, prefix := c.w.Header(), http.TrailerPrefix
+	if !c.started {
+		h.Set("Content-Type", "application/grpc")
+		h.Del("Content-Length")
+		prefix = ""
+	}
+	h.Set(prefix+"Grpc-Status", strconv.Itoa(code))
+	if msg != "" {
+		h.Set(prefix+"Grpc-Message", encodeMessage(msg))
+	}
+	if !c.started {
+		c.started = true
+		c.w.WriteHeader(http.StatusOK)
+	}
+}
+
+// writeError is the translate.ErrorFunc of a call: an HTTP error becomes
+// the call's status.
+func (c *call) writeError(_ http.ResponseWriter, status int, msg string) {
+	c.code, c.msg = code(status), msg
+}
+
+// stream serves a server-streaming call from an NDJSON endpoint.
+func (c *call) stream(r *http.Request, next http.Handler, path string, msg []byte,
+	unmarshal func([]byte) error, toOllama func() ([]byte, error), encode func(*chunk) []byte) {
+	body, err := c.request(msg, unmarshal, toOllama)
+	if err != nil {
+		c.finish(codeInvalidArgument, err.Error())
+		return
+	}
+	t := translate.NewWriter(c.w, func(line []byte) {
+		c.started = true // the Writer sent the headers
+		if c.code != codeOK || len(strings.TrimSpace(string(line))) == 0 {
+			return
+		}
+		var ch struct {
+			chunk
+			Error string `json:"error"`
+		}
+		if err := json.Unmarshal(line, &ch); err != nil {
+			c.code, c.msg = codeInternal, "upstream sent an invalid stream chunk"
+			return
+		}
+		if ch.Error != "" {
+			c.code, c.msg = codeInternal, ch.Error
+			return
+		}
+		c.send(encode(&ch.chunk))
+	}, c.writeError)
+	t.ContentType = "application/grpc"
+	translate.Forward(t, r, next, r.URL.Path, path, body)
+	t.Finish(nil)
+	c.started = c.started || t.Streamed()
+	c.end(r)
+}
+
+// unary serves a call answered with a single message.
+func (c *call) unary(r *http.Request, next http.Handler, path string, msg []byte,
+	unmarshal func([]byte) error, toOllama func() ([]byte, error), encode func([]byte) ([]byte, error)) {
+	body, err := c.request(msg, unmarshal, toOllama)
+	if err != nil {
+		c.finish(codeInvalidArgument, err.Error())
+		return
+	}
+	t := translate.NewWriter(c.w, nil, c.writeError)
+	translate.Forward(t, r, next, r.URL.Path, path, body)
+	t.Finish(func(body []byte) {
+		m, err := encode(body)
+		if err != nil {
+			c.code, c.msg = codeInternal, "upstream sent an invalid response"
+			return
+		}
+		c.send(m)
+	})
+	c.end(r)
+}
+
+// request decodes msg and returns the Ollama request body, nil for none.
+func (c *call) request(msg []byte, unmarshal func([]byte) error, toOllama func() ([]byte, error)) ([]byte, error) {
+	if err := unmarshal(msg); err != nil {
+		return nil, err
+	}
+	if toOllama == nil {
+		return nil, nil
+	}
+	return toOllama()
+}
+
+// end sends the status once next is done. A missed deadline wins over
+// the error the upstream request failed with because of it.
+func (c *call) end(r *http.Request) {
+	switch err := r.Context().Err(); {
+	case errors.Is(err, context.DeadlineExceeded):
+		c.code, c.msg = codeDeadlineExceeded, "deadline exceeded"
+	case err != nil && c.code != codeOK:
+		c.code, c.msg = codeCanceled, "canceled"
+	}
+	c.finish(c.code, c.msg)
+}
+
+// code maps an HTTP status to the gRPC code clients expect for it.
+func code(status int) int {
+	switch status {
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity:
+		return codeInvalidArgument
+	case http.StatusUnauthorized:
+		return codeUnauthenticated
+	case http.StatusForbidden:
+		return codePermissionDenied
+	case http.StatusNotFound:
+		return codeNotFound
+	case http.StatusTooManyRequests, http.StatusPaymentRequired:
+		return codeResourceExhausted
+	case http.StatusNotImplemented:
+		return codeUnimplemented
+	case http.StatusGatewayTimeout:
+		return codeDeadlineExceeded
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		return codeUnavailable
+	}
+	if status >= 500 {
+		return codeInternal
+	}
+	return codeUnknown
+}
+
+// readFrame returns the message of a unary request body.
+func readFrame(body []byte) ([]byte, error) {
+	if len(body) < 5 {
+		return nil, errors.New("request has no gRPC message")
+	}
+	if body[0] != 0 {
+		return nil, errors.New("compressed messages are not supported")
+	}
+	n := binary.BigEndian.Uint32(body[1:5])
+	if uint64(n) != uint64(len(body)-5) {
+		return nil, errors.New("request must hold exactly one gRPC message")
+	}
+	return body[5:], nil
+}
+
+// parseTimeout parses a grpc-timeout header, such as 1500m or 30S.
+func parseTimeout(s string) (time.Duration, error) {
+	units := map[byte]time.Duration{'H': time.Hour, 'M': time.Minute, 'S': time.Second,
+		'm': time.Millisecond, 'u': time.Microsecond, 'n': time.Nanosecond}
+	if len(s) < 2 || len(s) > 9 {
+		return 0, fmt.Errorf("invalid grpc-timeout %q", s)
+	}
+	unit, ok := units[s[len(s)-1]]
+	n, err := strconv.ParseInt(s[:len(s)-1], 10, 64)
+	if !ok || err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid grpc-timeout %q", s)
+	}
+	return time.Duration(n) * unit, nil
+}
+
+// encodeMessage percent-encodes a grpc-message value.
+func encodeMessage(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c < 0x20 || c > 0x7e || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
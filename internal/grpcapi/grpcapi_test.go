@@ -0,0 +1,217 @@
+package grpcapi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// server serves Middleware over HTTP/2 with TLS, as gRPC clients expect,
+// in front of upstream.
+func server(t *testing.T, upstream http.HandlerFunc) *httptest.Server {
+	srv := httptest.NewUnstartedServer(Middleware(upstream))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// invoke calls method with msg and returns the response messages and
+// the gRPC status.
+func invoke(t *testing.T, srv *httptest.Server, method string, msg []byte, header http.Header) ([][]byte, string, string) {
+	t.Helper()
+	frame := make([]byte, 5)
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+Service+method, bytes.NewReader(append(frame, msg...)))
+	req.Header.Set("Content-Type", "application/grpc")
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.ProtoMajor != 2 || resp.Header.Get("Content-Type") != "application/grpc" {
+		t.Fatalf("%s, Content-Type %q", resp.Proto, resp.Header.Get("Content-Type"))
+	}
+	body, _ := io.ReadAll(resp.Body)
+	var msgs [][]byte
+	for len(body) >= 5 {
+		n := binary.BigEndian.Uint32(body[1:5])
+		msgs = append(msgs, body[5:5+n])
+		body = body[5+n:]
+	}
+	status, message := resp.Trailer.Get("Grpc-Status"), resp.Trailer.Get("Grpc-Message")
+	if status == "" { // trailers-only response
+		status, message = resp.Header.Get("Grpc-Status"), resp.Header.Get("Grpc-Message")
+	}
+	return msgs, status, message
+}
+
+func TestChat(t *testing.T) {
+	var got map[string]any
+	srv := server(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/chat" {
+			t.Errorf("forwarded to %s", r.URL.Path)
+		}
+		b, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(b, &got)
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		_, _ = io.WriteString(w, `{"model":"llama3","message":{"role":"assistant","content":"Hel"},"done":false}`+"\n")
+		_, _ = io.WriteString(w, `{"model":"llama3","message":{"role":"assistant","content":"","tool_calls":[{"function":{"name":"f","arguments":{"a":1}}}]},"done":false}`+"\n")
+		_, _ = io.WriteString(w, `{"model":"llama3","message":{"role":"assistant","content":"lo"},"done":true,"done_reason":"stop","prompt_eval_count":7,"eval_count":2}`+"\n")
+	})
+
+	var m, req encoder
+	m.string(1, "user")
+	m.string(2, "Hi")
+	m.message(3, []byte{0x89, 'P', 'N', 'G'})
+	req.string(1, "llama3")
+	req.message(2, m.b)
+	req.string(4, `{"temperature":0.1}`)
+	// think = false: an optional field, present though zero
+	req.tag(7, wireVarint)
+	req.b = append(req.b, 0)
+	msgs, status, errMsg := invoke(t, srv, "Chat", req.b, nil)
+	if status != "0" || len(msgs) != 3 {
+		t.Fatalf("status %s %q, %d messages", status, errMsg, len(msgs))
+	}
+	if got["stream"] != true || got["think"] != false || got["options"].(map[string]any)["temperature"] != 0.1 ||
+		got["messages"].([]any)[0].(map[string]any)["images"].([]any)[0] != "iVBORw==" {
+		t.Errorf("forwarded %v", got)
+	}
+
+	var text string
+	var last struct {
+		done         bool
+		prompt, eval uint64
+		calls        []string
+	}
+	for _, b := range msgs {
+		err := decode(b, func(f field) error {
+			switch f.num {
+			case 2:
+				var msg message
+				if err := msg.unmarshal(f.data); err != nil {
+					return err
+				}
+				text += msg.Content
+				for _, tc := range msg.ToolCalls {
+					last.calls = append(last.calls, tc.Name+tc.ArgumentsJSON)
+				}
+			case 3:
+				last.done = f.v == 1
+			case 5:
+				last.prompt = f.v
+			case 6:
+				last.eval = f.v
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if text != "Hello" || !last.done || last.prompt != 7 || last.eval != 2 || len(last.calls) != 1 || last.calls[0] != `f{"a":1}` {
+		t.Errorf("text %q, final %+v", text, last)
+	}
+}
+
+func TestEmbedAndList(t *testing.T) {
+	srv := server(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api/embed":
+			b, _ := io.ReadAll(r.Body)
+			if !strings.Contains(string(b), `"input":["a","b"]`) {
+				t.Errorf("forwarded %s", b)
+			}
+			_, _ = io.WriteString(w, `{"model":"e","embeddings":[[0.5,-1],[2]],"prompt_eval_count":3}`)
+		case "/api/tags":
+			if r.Method != http.MethodGet {
+				t.Errorf("tags requested with %s", r.Method)
+			}
+			_, _ = io.WriteString(w, `{"models":[{"name":"llama3:latest","size":42,"digest":"abc","modified_at":"2024-05-01T00:00:00Z"}]}`)
+		}
+	})
+
+	var req encoder
+	req.string(1, "e")
+	req.string(2, "a")
+	req.string(2, "b")
+	msgs, status, _ := invoke(t, srv, "Embed", req.b, nil)
+	if status != "0" || len(msgs) != 1 {
+		t.Fatalf("status %s, %d messages", status, len(msgs))
+	}
+	var embs [][]float32
+	_ = decode(msgs[0], func(f field) error {
+		if f.num == 2 {
+			var vals []float32
+			_ = decode(f.data, func(f field) error {
+				var err error
+				vals, err = f.floats(vals)
+				return err
+			})
+			embs = append(embs, vals)
+		}
+		return nil
+	})
+	if len(embs) != 2 || len(embs[0]) != 2 || embs[0][1] != -1 || embs[1][0] != 2 {
+		t.Errorf("embeddings %v", embs)
+	}
+
+	msgs, status, _ = invoke(t, srv, "List", nil, nil)
+	if status != "0" || len(msgs) != 1 || !bytes.Contains(msgs[0], []byte("llama3:latest")) {
+		t.Fatalf("status %s, messages %q", status, msgs)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	srv := server(t, func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.Header.Get("X-Test"), "slow") {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+			http.Error(w, "timeout", http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = io.WriteString(w, `{"error":"model \"nope\" not found, try pulling it first"}`)
+	})
+	var req encoder
+	req.string(1, "nope")
+	if _, status, msg := invoke(t, srv, "Generate", req.b, nil); status != "5" || msg != `model "nope" not found, try pulling it first` {
+		t.Errorf("not found: %s %q", status, msg)
+	}
+	if _, status, _ := invoke(t, srv, "Pull", req.b, nil); status != "12" {
+		t.Errorf("unknown method: %s", status)
+	}
+	if _, status, _ := invoke(t, srv, "Chat", req.b, http.Header{"Grpc-Timeout": {"50m"}, "X-Test": {"slow"}}); status != "4" {
+		t.Errorf("deadline: %s", status)
+	}
+	if _, status, _ := invoke(t, srv, "Chat", []byte{0xff}, nil); status != "3" {
+		t.Errorf("malformed message: %s", status)
+	}
+}
+
+func TestParseTimeout(t *testing.T) {
+	for s, want := range map[string]time.Duration{"1500m": 1500 * time.Millisecond, "30S": 30 * time.Second, "1H": time.Hour} {
+		if d, err := parseTimeout(s); d != want || err != nil {
+			t.Errorf("%s: %v, %v", s, d, err)
+		}
+	}
+	for _, s := range []string{"", "5", "5x", "-1S", "123456789S"} {
+		if _, err := parseTimeout(s); err == nil {
+			t.Errorf("%q accepted", s)
+		}
+	}
+}
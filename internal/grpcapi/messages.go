@@ -0,0 +1,395 @@
+package grpcapi
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// The messages of proto/ollama/v1/ollama.proto, each with its conversion
+// to or from the Ollama JSON it stands for.
+
+type message struct {
+	Role      string
+	Content   string
+	Images    [][]byte
+	Thinking  string
+	ToolCalls []toolCall
+	ToolName  string
+}
+
+type toolCall struct {
+	Name          string
+	ArgumentsJSON string
+}
+
+func (m *message) unmarshal(b []byte) error {
+	return decode(b, func(f field) error {
+		switch f.num {
+		case 1:
+			m.Role = string(f.data)
+		case 2:
+			m.Content = string(f.data)
+		case 3:
+			m.Images = append(m.Images, f.data)
+		case 4:
+			m.Thinking = string(f.data)
+		case 5:
+			var tc toolCall
+			err := decode(f.data, func(f field) error {
+				switch f.num {
+				case 1:
+					tc.Name = string(f.data)
+				case 2:
+					tc.ArgumentsJSON = string(f.data)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			m.ToolCalls = append(m.ToolCalls, tc)
+		case 6:
+			m.ToolName = string(f.data)
+		}
+		return nil
+	})
+}
+
+func (m *message) marshal() []byte {
+	var e encoder
+	e.string(1, m.Role)
+	e.string(2, m.Content)
+	for _, img := range m.Images {
+		e.message(3, img)
+	}
+	e.string(4, m.Thinking)
+	for _, tc := range m.ToolCalls {
+		var te encoder
+		te.string(1, tc.Name)
+		te.string(2, tc.ArgumentsJSON)
+		e.message(5, te.b)
+	}
+	e.string(6, m.ToolName)
+	return e.b
+}
+
+// ollamaMessage is a message as /api/chat has it.
+type ollamaMessage struct {
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	Images    []string         `json:"images,omitempty"`
+	Thinking  string           `json:"thinking,omitempty"`
+	ToolCalls []ollamaToolCall `json:"tool_calls,omitempty"`
+	ToolName  string           `json:"tool_name,omitempty"`
+}
+
+type ollamaToolCall struct {
+	Function struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	} `json:"function"`
+}
+
+func (m *message) toOllama() (ollamaMessage, error) {
+	om := ollamaMessage{Role: m.Role, Content: m.Content, Thinking: m.Thinking, ToolName: m.ToolName}
+	om.Images = images(m.Images)
+	for _, tc := range m.ToolCalls {
+		args := json.RawMessage(tc.ArgumentsJSON)
+		if len(args) == 0 {
+			args = json.RawMessage("{}")
+		} else if !json.Valid(args) {
+			return om, fmt.Errorf("arguments_json of tool call %s is not valid JSON", tc.Name)
+		}
+		var call ollamaToolCall
+		call.Function.Name, call.Function.Arguments = tc.Name, args
+		om.ToolCalls = append(om.ToolCalls, call)
+	}
+	return om, nil
+}
+
+func fromOllama(om ollamaMessage) message {
+	m := message{Role: om.Role, Content: om.Content, Thinking: om.Thinking, ToolName: om.ToolName}
+	for _, img := range om.Images {
+		if b, err := base64.StdEncoding.DecodeString(img); err == nil {
+			m.Images = append(m.Images, b)
+		}
+	}
+	for _, tc := range om.ToolCalls {
+		m.ToolCalls = append(m.ToolCalls, toolCall{Name: tc.Function.Name, ArgumentsJSON: string(tc.Function.Arguments)})
+	}
+	return m
+}
+
+func images(raw [][]byte) []string {
+	var out []string
+	for _, img := range raw {
+		out = append(out, base64.StdEncoding.EncodeToString(img))
+	}
+	return out
+}
+
+// common holds the request fields chat and generate share.
+type common struct {
+	Model       string
+	FormatJSON  string
+	OptionsJSON string
+	KeepAlive   string
+	Think       *bool
+}
+
+// ollama adds the common fields to an Ollama request body.
+func (c *common) ollama(req map[string]any) error {
+	req["model"] = c.Model
+	for name, raw := range map[string]string{"format": c.FormatJSON, "options": c.OptionsJSON} {
+		if raw == "" {
+			continue
+		}
+		if !json.Valid([]byte(raw)) {
+			return fmt.Errorf("%s_json is not valid JSON", name)
+		}
+		req[name] = json.RawMessage(raw)
+	}
+	if c.KeepAlive != "" {
+		req["keep_alive"] = c.KeepAlive
+	}
+	if c.Think != nil {
+		req["think"] = *c.Think
+	}
+	return nil
+}
+
+func boolField(f field) *bool {
+	b := f.v != 0
+	return &b
+}
+
+type chatRequest struct {
+	common
+	Messages  []message
+	ToolsJSON string
+}
+
+func (r *chatRequest) unmarshal(b []byte) error {
+	return decode(b, func(f field) error {
+		switch f.num {
+		case 1:
+			r.Model = string(f.data)
+		case 2:
+			var m message
+			if err := m.unmarshal(f.data); err != nil {
+				return err
+			}
+			r.Messages = append(r.Messages, m)
+		case 3:
+			r.FormatJSON = string(f.data)
+		case 4:
+			r.OptionsJSON = string(f.data)
+		case 5:
+			r.ToolsJSON = string(f.data)
+		case 6:
+			r.KeepAlive = string(f.data)
+		case 7:
+			r.Think = boolField(f)
+		}
+		return nil
+	})
+}
+
+// ollama returns the /api/chat body.
+func (r *chatRequest) ollama() ([]byte, error) {
+	req := map[string]any{"stream": true}
+	if err := r.common.ollama(req); err != nil {
+		return nil, err
+	}
+	msgs := make([]ollamaMessage, 0, len(r.Messages))
+	for _, m := range r.Messages {
+		om, err := m.toOllama()
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, om)
+	}
+	req["messages"] = msgs
+	if r.ToolsJSON != "" {
+		if !json.Valid([]byte(r.ToolsJSON)) {
+			return nil, fmt.Errorf("tools_json is not valid JSON")
+		}
+		req["tools"] = json.RawMessage(r.ToolsJSON)
+	}
+	return json.Marshal(req)
+}
+
+// chunk is a line of an /api/chat or /api/generate stream.
+type chunk struct {
+	Model           string        `json:"model"`
+	Message         ollamaMessage `json:"message"`
+	Response        string        `json:"response"`
+	Thinking        string        `json:"thinking"`
+	Done            bool          `json:"done"`
+	DoneReason      string        `json:"done_reason"`
+	PromptEvalCount int64         `json:"prompt_eval_count"`
+	EvalCount       int64         `json:"eval_count"`
+	TotalDuration   int64         `json:"total_duration"`
+}
+
+// chatResponse encodes c as a ChatResponse.
+func (c *chunk) chatResponse() []byte {
+	var e encoder
+	e.string(1, c.Model)
+	m := fromOllama(c.Message)
+	e.message(2, m.marshal())
+	e.bool(3, c.Done)
+	e.string(4, c.DoneReason)
+	e.int(5, c.PromptEvalCount)
+	e.int(6, c.EvalCount)
+	e.int(7, c.TotalDuration)
+	return e.b
+}
+
+type generateRequest struct {
+	common
+	Prompt string
+	System string
+	Images [][]byte
+}
+
+func (r *generateRequest) unmarshal(b []byte) error {
+	return decode(b, func(f field) error {
+		switch f.num {
+		case 1:
+			r.Model = string(f.data)
+		case 2:
+			r.Prompt = string(f.data)
+		case 3:
+			r.System = string(f.data)
+		case 4:
+			r.Images = append(r.Images, f.data)
+		case 5:
+			r.FormatJSON = string(f.data)
+		case 6:
+			r.OptionsJSON = string(f.data)
+		case 7:
+			r.KeepAlive = string(f.data)
+		case 8:
+			r.Think = boolField(f)
+		}
+		return nil
+	})
+}
+
+// ollama returns the /api/generate body.
+func (r *generateRequest) ollama() ([]byte, error) {
+	req := map[string]any{"stream": true, "prompt": r.Prompt}
+	if err := r.common.ollama(req); err != nil {
+		return nil, err
+	}
+	if r.System != "" {
+		req["system"] = r.System
+	}
+	if len(r.Images) > 0 {
+		req["images"] = images(r.Images)
+	}
+	return json.Marshal(req)
+}
+
+// generateResponse encodes c as a GenerateResponse.
+func (c *chunk) generateResponse() []byte {
+	var e encoder
+	e.string(1, c.Model)
+	e.string(2, c.Response)
+	e.string(3, c.Thinking)
+	e.bool(4, c.Done)
+	e.string(5, c.DoneReason)
+	e.int(6, c.PromptEvalCount)
+	e.int(7, c.EvalCount)
+	e.int(8, c.TotalDuration)
+	return e.b
+}
+
+type embedRequest struct {
+	Model      string
+	Input      []string
+	Dimensions int64
+	KeepAlive  string
+}
+
+func (r *embedRequest) unmarshal(b []byte) error {
+	return decode(b, func(f field) error {
+		switch f.num {
+		case 1:
+			r.Model = string(f.data)
+		case 2:
+			r.Input = append(r.Input, string(f.data))
+		case 3:
+			r.Dimensions = int64(int32(f.v))
+		case 4:
+			r.KeepAlive = string(f.data)
+		}
+		return nil
+	})
+}
+
+// ollama returns the /api/embed body.
+func (r *embedRequest) ollama() ([]byte, error) {
+	req := map[string]any{"model": r.Model, "input": r.Input}
+	if r.Input == nil {
+		req["input"] = []string{}
+	}
+	if r.Dimensions > 0 {
+		req["dimensions"] = r.Dimensions
+	}
+	if r.KeepAlive != "" {
+		req["keep_alive"] = r.KeepAlive
+	}
+	return json.Marshal(req)
+}
+
+// embedResponse converts an /api/embed response to an EmbedResponse.
+func embedResponse(body []byte) ([]byte, error) {
+	var res struct {
+		Model           string      `json:"model"`
+		Embeddings      [][]float32 `json:"embeddings"`
+		PromptEvalCount int64       `json:"prompt_eval_count"`
+	}
+	if err := json.Unmarshal(body, &res); err != nil {
+		return nil, err
+	}
+	var e encoder
+	e.string(1, res.Model)
+	for _, emb := range res.Embeddings {
+		var ee encoder
+		ee.floats(1, emb)
+		e.message(2, ee.b)
+	}
+	e.int(3, res.PromptEvalCount)
+	return e.b, nil
+}
+
+// listResponse converts an /api/tags response to a ListResponse.
+func listResponse(body []byte) ([]byte, error) {
+	var res struct {
+		Models []struct {
+			Name       string    `json:"name"`
+			Size       int64     `json:"size"`
+			Digest     string    `json:"digest"`
+			ModifiedAt time.Time `json:"modified_at"`
+		} `json:"models"`
+	}
+	if err := json.Unmarshal(body, &res); err != nil {
+		return nil, err
+	}
+	var e encoder
+	for _, m := range res.Models {
+		var me encoder
+		me.string(1, m.Name)
+		me.int(2, m.Size)
+		me.string(3, m.Digest)
+		if !m.ModifiedAt.IsZero() {
+			me.int(4, m.ModifiedAt.Unix())
+		}
+		e.message(1, me.b)
+	}
+	return e.b, nil
+}
@@ -0,0 +1,139 @@
+package grpcapi
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// The protobuf wire format, as far as the messages of ollama.proto need
+// it: varints, 64-bit values, length-delimited fields and packed floats.
+
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var errTruncated = errors.New("truncated protobuf message")
+
+// encoder appends fields to a message. Zero values are left out, as
+// proto3 does.
+type encoder struct{ b []byte }
+
+func (e *encoder) tag(field, wire int) {
+	e.b = binary.AppendUvarint(e.b, uint64(field)<<3|uint64(wire))
+}
+
+func (e *encoder) uint(field int, v uint64) {
+	if v != 0 {
+		e.tag(field, wireVarint)
+		e.b = binary.AppendUvarint(e.b, v)
+	}
+}
+
+func (e *encoder) int(field int, v int64) { e.uint(field, uint64(v)) }
+
+func (e *encoder) bool(field int, v bool) {
+	if v {
+		e.uint(field, 1)
+	}
+}
+
+func (e *encoder) bytes(field int, b []byte) {
+	if len(b) > 0 {
+		e.tag(field, wireBytes)
+		e.b = binary.AppendUvarint(e.b, uint64(len(b)))
+		e.b = append(e.b, b...)
+	}
+}
+
+func (e *encoder) string(field int, s string) { e.bytes(field, []byte(s)) }
+
+// message embeds m, even when empty, so repeated messages keep their
+// count.
+func (e *encoder) message(field int, m []byte) {
+	e.tag(field, wireBytes)
+	e.b = binary.AppendUvarint(e.b, uint64(len(m)))
+	e.b = append(e.b, m...)
+}
+
+func (e *encoder) floats(field int, fs []float32) {
+	if len(fs) == 0 {
+		return
+	}
+	e.tag(field, wireBytes)
+	e.b = binary.AppendUvarint(e.b, uint64(4*len(fs)))
+	for _, f := range fs {
+		e.b = binary.LittleEndian.AppendUint32(e.b, math.Float32bits(f))
+	}
+}
+
+// field is one decoded field: v holds varints and fixed values, data the
+// contents of length-delimited ones.
+type field struct {
+	num  int
+	wire int
+	v    uint64
+	data []byte
+}
+
+// decode calls f for each field of b. Unknown fields are the caller's to
+// skip, so newer clients can talk to an older proxy.
+func decode(b []byte, f func(field) error) error {
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errTruncated
+		}
+		b = b[n:]
+		fd := field{num: int(key >> 3), wire: int(key & 7)}
+		switch fd.wire {
+		case wireVarint:
+			if fd.v, n = binary.Uvarint(b); n <= 0 {
+				return errTruncated
+			}
+			b = b[n:]
+		case wireFixed64:
+			if len(b) < 8 {
+				return errTruncated
+			}
+			fd.v, b = binary.LittleEndian.Uint64(b), b[8:]
+		case wireFixed32:
+			if len(b) < 4 {
+				return errTruncated
+			}
+			fd.v, b = uint64(binary.LittleEndian.Uint32(b)), b[4:]
+		case wireBytes:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < l {
+				return errTruncated
+			}
+			fd.data, b = b[n:n+int(l)], b[n+int(l):]
+		default:
+			return errors.New("unsupported protobuf wire type")
+		}
+		if err := f(fd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// floats appends the values of a repeated float field, packed or not.
+func (fd field) floats(dst []float32) ([]float32, error) {
+	switch fd.wire {
+	case wireFixed32:
+		return append(dst, math.Float32frombits(uint32(fd.v))), nil
+	case wireBytes:
+		if len(fd.data)%4 != 0 {
+			return nil, errTruncated
+		}
+		for i := 0; i < len(fd.data); i += 4 {
+			dst = append(dst, math.Float32frombits(binary.LittleEndian.Uint32(fd.data[i:])))
+		}
+		return dst, nil
+	}
+	return nil, errors.New("invalid encoding of a float field")
+}
@@ -0,0 +1,102 @@
+package health
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Checker probes the upstream's /api/version to decide whether the proxy
+// is ready for traffic. Results are cached for TTL so frequent load
+// balancer checks don't turn into upstream load.
+type Checker struct {
+	URL     string
+	Client  *http.Client
+	Timeout time.Duration
+	TTL     time.Duration
+	// AnyAnswer counts any answer below 500 as ready, for upstreams
+	// without /api/version that are probed at their base URL.
+	AnyAnswer bool
+
+	mu      sync.Mutex // held while probing, so concurrent checks share one probe
+	checked time.Time
+	err     error
+	now     func() time.Time
+}
+
+// NewChecker returns a checker for the upstream at target, connecting
+// with tlsConfig (nil for the defaults).
+func NewChecker(target *url.URL, tlsConfig *tls.Config, timeout, ttl time.Duration) *Checker {
+	return &Checker{
+		URL: target.JoinPath("/api/version").String(),
+		Client: &http.Client{Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsConfig,
+		}},
+		Timeout: timeout,
+		TTL:     ttl,
+		now:     time.Now,
+	}
+}
+
+// Check returns nil if the upstream answered its last probe, probing
+// again once the cached result is older than TTL.
+func (c *Checker) Check() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.checked.IsZero() && c.now().Sub(c.checked) < c.TTL {
+		return c.err
+	}
+	err := c.probe()
+	if err != nil && c.err == nil {
+		slog.Warn("readiness check failed", "url", c.URL, "err", err)
+	} else if err == nil && c.err != nil {
+		slog.Info("readiness check recovered", "url", c.URL)
+	}
+	c.checked, c.err = c.now(), err
+	return err
+}
+
+func (c *Checker) probe() error {
+	// not tied to the caller's request: the result is shared and cached
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.URL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if c.AnyAnswer && resp.StatusCode < 500 {
+		return nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("upstream answered %s", resp.Status)
+	}
+	return nil
+}
+
+// ReadyHandler serves /readyz: 200 while the upstream is reachable, 503
+// otherwise.
+func (c *Checker) ReadyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		if err := c.Check(); err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte("upstream unreachable"))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("ok"))
+	})
+}
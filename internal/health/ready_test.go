@@ -0,0 +1,74 @@
+package health
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReadyz(t *testing.T) {
+	var probes atomic.Int32
+	var down atomic.Bool
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		probes.Add(1)
+		if r.URL.Path != "/ollama/api/version" {
+			t.Errorf("probed %s", r.URL.Path)
+		}
+		if down.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		_, _ = w.Write([]byte(`{"version":"0.5.1"}`))
+	}))
+	defer upstream.Close()
+
+	u, _ := url.Parse(upstream.URL + "/ollama")
+	c := NewChecker(u, nil, time.Second, time.Minute)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+	ready := func() int {
+		rec := httptest.NewRecorder()
+		c.ReadyHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+		return rec.Code
+	}
+
+	if code := ready(); code != http.StatusOK {
+		t.Fatalf("status %d", code)
+	}
+	down.Store(true)
+	if code := ready(); code != http.StatusOK || probes.Load() != 1 {
+		t.Fatalf("cached result not used: status %d, %d probes", code, probes.Load())
+	}
+	now = now.Add(time.Minute)
+	if code := ready(); code != http.StatusServiceUnavailable {
+		t.Fatalf("upstream error: status %d", code)
+	}
+
+	upstream.Close()
+	now = now.Add(time.Minute)
+	if code := ready(); code != http.StatusServiceUnavailable {
+		t.Fatalf("unreachable upstream: status %d", code)
+	}
+}
+
+func TestReadyzAnyAnswer(t *testing.T) {
+	status := http.StatusNotFound
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer upstream.Close()
+
+	u, _ := url.Parse(upstream.URL)
+	c := NewChecker(u, nil, time.Second, 0)
+	c.URL, c.AnyAnswer = upstream.URL, true
+	if err := c.Check(); err != nil {
+		t.Errorf("404: %v", err)
+	}
+	status = http.StatusServiceUnavailable
+	if err := c.Check(); err == nil {
+		t.Error("503 counted as ready")
+	}
+}
@@ -0,0 +1,288 @@
+// Package images limits the base64 images of multimodal requests: how
+// many a request may carry and how large each may be. Images over a size
+// can be downscaled and recompressed in the proxy instead of rejected, so
+// a phone photo doesn't blow past what the upstream accepts.
+package images
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif" // decoders for image.Decode
+	"image/jpeg"
+	"image/png"
+	"log/slog"
+	"net/http"
+
+	"github.com/yeti47/ollama-proxy/internal/apierror"
+	"github.com/yeti47/ollama-proxy/internal/metrics"
+	"github.com/yeti47/ollama-proxy/internal/ollama"
+)
+
+// DefaultQuality is the JPEG quality of recompressed images.
+const DefaultQuality = 85
+
+// maxPixels bounds the images the proxy decodes, so a small file that
+// claims huge dimensions can't exhaust memory.
+const maxPixels = 64 << 20
+
+var (
+	rejected = metrics.NewCounter("ollama_proxy_image_rejections_total",
+		"Requests rejected for their images, by reason: count or size.", "reason")
+	resized = metrics.NewCounter("ollama_proxy_images_resized_total",
+		"Images downscaled or recompressed before forwarding.")
+	saved = metrics.NewCounter("ollama_proxy_image_bytes_saved_total",
+		"Bytes removed from images by downscaling and recompression.")
+)
+
+// Limits are the image limits of a request. Zero fields are unlimited.
+type Limits struct {
+	// MaxCount is the most images a request may carry, over all messages.
+	MaxCount int
+	// MaxBytes is the largest decoded size of an image.
+	MaxBytes int64
+	// MaxDimension downscales images wider or taller than this many
+	// pixels, keeping their aspect ratio. Images over MaxBytes are
+	// recompressed even if they fit.
+	MaxDimension int
+	// Quality is the JPEG quality of recompressed images; DefaultQuality
+	// if zero.
+	Quality int
+}
+
+// Enabled reports whether l limits anything.
+func (l Limits) Enabled() bool {
+	return l.MaxCount > 0 || l.MaxBytes > 0 || l.MaxDimension > 0
+}
+
+// Middleware enforces l on requests to next. Requests over MaxCount, or
+// with images over MaxBytes that can't be shrunk below it, get a 413.
+func (l Limits) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !l.Enabled() || r.Method != http.MethodPost || (r.URL.Path != "/api/chat" && r.URL.Path != "/api/generate") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		body, err := ollama.PeekBody(r)
+		if err != nil {
+			apierror.BodyError(w, err)
+			return
+		}
+		// cheap check first: most requests carry no images
+		if !bytes.Contains(body, []byte(`"images"`)) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		var req map[string]json.RawMessage
+		if json.Unmarshal(body, &req) != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		changed, err := l.request(req)
+		if err != nil {
+			slog.InfoContext(r.Context(), "request rejected for its images", "err", err)
+			apierror.Write(w, http.StatusRequestEntityTooLarge, err.Error())
+			return
+		}
+		if changed {
+			if b, err := marshal(req); err == nil {
+				ollama.ReplaceBody(r, b)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// request applies l to the images of a chat or generate request,
+// reporting whether any were changed.
+func (l Limits) request(req map[string]json.RawMessage) (bool, error) {
+	// each holds an images list: the request itself, or its messages
+	var holders []map[string]json.RawMessage
+	if _, ok := req["images"]; ok {
+		holders = append(holders, req)
+	}
+	var messages []map[string]json.RawMessage
+	if raw, ok := req["messages"]; ok && json.Unmarshal(raw, &messages) == nil {
+		for _, m := range messages {
+			if _, ok := m["images"]; ok {
+				holders = append(holders, m)
+			}
+		}
+	}
+	count, changed := 0, false
+	for _, h := range holders {
+		var imgs []string
+		if json.Unmarshal(h["images"], &imgs) != nil {
+			continue // not base64 strings; the upstream will complain
+		}
+		count += len(imgs)
+		if l.MaxCount > 0 && count > l.MaxCount {
+			rejected.With("count").Inc()
+			return false, fmt.Errorf("too many images: at most %d per request", l.MaxCount)
+		}
+		hChanged := false
+		for i, s := range imgs {
+			out, ok, err := l.image(s)
+			if err != nil {
+				rejected.With("size").Inc()
+				return false, fmt.Errorf("image %d: %w", count-len(imgs)+i+1, err)
+			}
+			if ok {
+				imgs[i], hChanged = out, true
+			}
+		}
+		if hChanged {
+			h["images"], _ = marshal(imgs)
+			changed = true
+		}
+	}
+	if changed && len(messages) > 0 {
+		req["messages"], _ = marshal(messages)
+	}
+	return changed, nil
+}
+
+// image applies l to one base64 image, returning the replacement if it
+// was shrunk.
+func (l Limits) image(s string) (string, bool, error) {
+	size := int64(base64.StdEncoding.DecodedLen(len(s)))
+	if l.MaxDimension == 0 && (l.MaxBytes == 0 || size <= l.MaxBytes) {
+		return "", false, nil
+	}
+	tooBig := func() error {
+		return fmt.Errorf("%d bytes is over the limit of %d", size, l.MaxBytes)
+	}
+	raw, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return "", false, nil // not ours to judge
+	}
+	size = int64(len(raw))
+	over := l.MaxBytes > 0 && size > l.MaxBytes
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(raw))
+	if err != nil {
+		// a format the proxy can't decode, such as WebP
+		if over {
+			return "", false, tooBig()
+		}
+		return "", false, nil
+	}
+	big := l.MaxDimension > 0 && (cfg.Width > l.MaxDimension || cfg.Height > l.MaxDimension)
+	if !big && !over {
+		return "", false, nil
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > maxPixels {
+		return "", false, fmt.Errorf("%dx%d pixels is too large to process", cfg.Width, cfg.Height)
+	}
+	img, _, err := image.Decode(bytes.NewReader(raw))
+	if err != nil {
+		if over {
+			return "", false, tooBig()
+		}
+		return "", false, nil
+	}
+	if big {
+		img = downscale(img, l.MaxDimension)
+	}
+	out, err := l.encode(img)
+	if err != nil || (len(out) >= len(raw) && !big) {
+		if over {
+			return "", false, tooBig()
+		}
+		return "", false, nil
+	}
+	if l.MaxBytes > 0 && int64(len(out)) > l.MaxBytes {
+		size = int64(len(out))
+		return "", false, tooBig()
+	}
+	resized.With().Inc()
+	if len(out) < len(raw) {
+		saved.With().Add(float64(len(raw) - len(out)))
+	}
+	return base64.StdEncoding.EncodeToString(out), true, nil
+}
+
+// encode writes img as JPEG, or as PNG if it has transparent pixels,
+// which JPEG would lose.
+func (l Limits) encode(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if !opaque(img) {
+		err := (&png.Encoder{CompressionLevel: png.BestCompression}).Encode(&buf, img)
+		return buf.Bytes(), err
+	}
+	q := l.Quality
+	if q == 0 {
+		q = DefaultQuality
+	}
+	err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: q})
+	return buf.Bytes(), err
+}
+
+func opaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	return false
+}
+
+// downscale shrinks img so neither side exceeds limit, averaging the
+// source pixels that fall into each target pixel.
+func downscale(img image.Image, limit int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= limit && h <= limit {
+		return img
+	}
+	dw, dh := limit, limit
+	if w > h {
+		dh = (h*limit + w/2) / w
+	} else {
+		dw = (w*limit + h/2) / h
+	}
+	dw, dh = max(dw, 1), max(dh, 1)
+
+	src := image.NewNRGBA(b)
+	draw.Draw(src, b, img, b.Min, draw.Src)
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		y0, y1 := y*h/dh, max((y+1)*h/dh, y*h/dh+1)
+		for x := 0; x < dw; x++ {
+			x0, x1 := x*w/dw, max((x+1)*w/dw, x*w/dw+1)
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					p := row[sx*4 : sx*4+4]
+					// weight colour by alpha so transparent pixels
+					// don't darken the edges
+					pa := uint64(p[3])
+					r += uint64(p[0]) * pa
+					g += uint64(p[1]) * pa
+					bl += uint64(p[2]) * pa
+					a += pa
+					n++
+				}
+			}
+			c := color.NRGBA{A: uint8(a / n)}
+			if a > 0 {
+				c.R, c.G, c.B = uint8(r/a), uint8(g/a), uint8(bl/a)
+			}
+			dst.SetNRGBA(x, y, c)
+		}
+	}
+	return dst
+}
+
+// marshal encodes v without escaping <, > and &.
+func marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
@@ -0,0 +1,119 @@
+package images
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// pngImage returns a base64 PNG of w x h pixels, opaque unless alpha.
+func pngImage(t *testing.T, w, h int, alpha bool) string {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	rnd := rand.New(rand.NewSource(1))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			a := uint8(255)
+			if alpha && x < w/2 {
+				a = 0
+			}
+			img.SetNRGBA(x, y, color.NRGBA{R: uint8(x + rnd.Intn(32)), G: uint8(y + rnd.Intn(32)), B: uint8(x ^ y), A: a})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+func decode(t *testing.T, s string) (image.Config, string) {
+	t.Helper()
+	raw, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg, format
+}
+
+func serve(l Limits, path, body string) (*httptest.ResponseRecorder, []byte) {
+	var got []byte
+	h := l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = io.ReadAll(r.Body)
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+	return rec, got
+}
+
+func TestCount(t *testing.T) {
+	img := pngImage(t, 4, 4, false)
+	body := `{"model":"llava","messages":[{"role":"user","content":"a","images":["` + img + `"]},{"role":"user","content":"b","images":["` + img + `","` + img + `"]}]}`
+	rec, _ := serve(Limits{MaxCount: 2}, "/api/chat", body)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status %d", rec.Code)
+	}
+	rec, got := serve(Limits{MaxCount: 3}, "/api/chat", body)
+	if rec.Code != http.StatusOK || string(got) != body {
+		t.Errorf("status %d, forwarded a changed body", rec.Code)
+	}
+}
+
+func TestDownscale(t *testing.T) {
+	body := `{"model":"llava","prompt":"what is this?","images":["` + pngImage(t, 400, 200, false) + `"]}`
+	rec, got := serve(Limits{MaxDimension: 100}, "/api/generate", body)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d", rec.Code)
+	}
+	var req struct{ Images []string }
+	if err := json.Unmarshal(got, &req); err != nil || len(req.Images) != 1 {
+		t.Fatalf("forwarded %s", got)
+	}
+	cfg, format := decode(t, req.Images[0])
+	if cfg.Width != 100 || cfg.Height != 50 || format != "jpeg" {
+		t.Errorf("got %dx%d %s", cfg.Width, cfg.Height, format)
+	}
+
+	// transparency survives as PNG
+	body = `{"model":"llava","prompt":"x","images":["` + pngImage(t, 300, 300, true) + `"]}`
+	_, got = serve(Limits{MaxDimension: 100}, "/api/generate", body)
+	_ = json.Unmarshal(got, &req)
+	if cfg, format := decode(t, req.Images[0]); cfg.Width != 100 || format != "png" {
+		t.Errorf("got %dx%d %s", cfg.Width, cfg.Height, format)
+	}
+}
+
+func TestMaxBytes(t *testing.T) {
+	img := pngImage(t, 300, 300, false)
+	body := `{"model":"llava","prompt":"x","images":["` + img + `"]}`
+	// recompressing as JPEG brings the noisy PNG under the limit
+	raw, _ := base64.StdEncoding.DecodeString(img)
+	size := int64(len(raw))
+	rec, got := serve(Limits{MaxBytes: size - 1}, "/api/generate", body)
+	if rec.Code != http.StatusOK || string(got) == body {
+		t.Errorf("status %d, body unchanged: %v", rec.Code, string(got) == body)
+	}
+	rec, _ = serve(Limits{MaxBytes: 100}, "/api/generate", body)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status %d", rec.Code)
+	}
+	// undecodable data over the limit is rejected as it is
+	junk := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte("x"), 300))
+	rec, _ = serve(Limits{MaxBytes: 200}, "/api/generate", `{"images":["`+junk+`"]}`)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("junk: status %d", rec.Code)
+	}
+}
@@ -0,0 +1,182 @@
+// Package inflight tracks the requests the proxy is currently handling so
+// an operator can see them and cancel a runaway generation.
+package inflight
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/yeti47/ollama-proxy/internal/apierror"
+	"github.com/yeti47/ollama-proxy/internal/audit"
+	"github.com/yeti47/ollama-proxy/internal/auth"
+	"github.com/yeti47/ollama-proxy/internal/logging"
+	"github.com/yeti47/ollama-proxy/internal/ollama"
+)
+
+// ErrCancelled is the cause given to the context of a request cancelled
+// through the admin API.
+var ErrCancelled = errors.New("cancelled by an administrator")
+
+// Request describes an active request.
+type Request struct {
+	ID             string    `json:"id"`
+	Client         string    `json:"client,omitempty"`
+	Model          string    `json:"model,omitempty"`
+	Method         string    `json:"method"`
+	Path           string    `json:"path"`
+	Remote         string    `json:"remote"`
+	Started        time.Time `json:"started"`
+	ElapsedSeconds float64   `json:"elapsed_seconds"`
+	BytesStreamed  int64     `json:"bytes_streamed"`
+}
+
+type entry struct {
+	Request
+	bytes  atomic.Int64
+	cancel context.CancelCauseFunc
+}
+
+// Registry holds the active requests.
+type Registry struct {
+	mu     sync.Mutex
+	active map[string]*entry
+}
+
+// New returns an empty registry.
+func New() *Registry {
+	return &Registry{active: make(map[string]*entry)}
+}
+
+// Middleware registers every request passing through next for as long as
+// it is being handled. Requests are identified by their request id, so it
+// must run inside logging.RequestIDMiddleware.
+func (reg *Registry) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := logging.RequestID(r.Context())
+		if id == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		var model string
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			m, err := ollama.PeekModel(r)
+			if err != nil {
+				apierror.BodyError(w, err)
+				return
+			}
+			model = m
+		}
+		ctx, cancel := context.WithCancelCause(r.Context())
+		e := &entry{
+			Request: Request{
+				ID:      id,
+				Client:  auth.ClientName(r.Context()),
+				Model:   model,
+				Method:  r.Method,
+				Path:    r.URL.Path,
+				Remote:  r.RemoteAddr,
+				Started: time.Now(),
+			},
+			cancel: cancel,
+		}
+		reg.mu.Lock()
+		if _, dup := reg.active[id]; dup {
+			// a client reused a request id; keep the ids unique
+			e.ID = id + "-" + time.Now().Format("150405.000000000")
+		}
+		reg.active[e.ID] = e
+		reg.mu.Unlock()
+		defer func() {
+			reg.mu.Lock()
+			delete(reg.active, e.ID)
+			reg.mu.Unlock()
+			cancel(nil)
+		}()
+		next.ServeHTTP(&countingWriter{ResponseWriter: w, n: &e.bytes}, r.WithContext(ctx))
+	})
+}
+
+// List returns the active requests, oldest first.
+func (reg *Registry) List() []Request {
+	reg.mu.Lock()
+	out := make([]Request, 0, len(reg.active))
+	for _, e := range reg.active {
+		req := e.Request
+		req.ElapsedSeconds = time.Since(e.Started).Seconds()
+		req.BytesStreamed = e.bytes.Load()
+		out = append(out, req)
+	}
+	reg.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].Started.Before(out[j].Started) })
+	return out
+}
+
+// Cancel cancels the request with the given id, which aborts the upstream
+// request and ends the response. It reports whether the request was found.
+func (reg *Registry) Cancel(id string) (Request, bool) {
+	reg.mu.Lock()
+	e, ok := reg.active[id]
+	reg.mu.Unlock()
+	if !ok {
+		return Request{}, false
+	}
+	e.cancel(ErrCancelled)
+	return e.Request, true
+}
+
+// AdminHandler serves the in-flight request API. It expects to be mounted
+// at /admin/requests and /admin/requests/:
+//
+//	GET    /admin/requests       list active requests
+//	DELETE /admin/requests/{id}  cancel a request
+func (reg *Registry) AdminHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/requests"), "/")
+		switch {
+		case id == "" && r.Method == http.MethodGet:
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(map[string]any{"requests": reg.List()})
+		case id != "" && r.Method == http.MethodDelete:
+			req, ok := reg.Cancel(id)
+			if !ok {
+				apierror.Write(w, http.StatusNotFound, "no active request with that id")
+				return
+			}
+			slog.InfoContext(r.Context(), "admin: cancelled request", "id", req.ID, "client", req.Client, "model", req.Model)
+			audit.Event(audit.AdminChange, r, "", "cancelled request "+req.ID)
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(req)
+		default:
+			apierror.Write(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	})
+}
+
+// countingWriter counts the response bytes. It passes Flush through so
+// streamed responses keep streaming.
+type countingWriter struct {
+	http.ResponseWriter
+	n *atomic.Int64
+}
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.n.Add(int64(n))
+	return n, err
+}
+
+func (w *countingWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *countingWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
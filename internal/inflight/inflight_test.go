@@ -0,0 +1,75 @@
+package inflight
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yeti47/ollama-proxy/internal/auth"
+	"github.com/yeti47/ollama-proxy/internal/logging"
+)
+
+func TestListAndCancel(t *testing.T) {
+	reg := New()
+	started := make(chan struct{})
+	done := make(chan error, 1)
+	h := logging.RequestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = r.WithContext(auth.WithIdentity(r.Context(), &auth.Identity{Name: "alice"}))
+		reg.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("partial"))
+			close(started)
+			<-r.Context().Done()
+			done <- context.Cause(r.Context())
+		})).ServeHTTP(w, r)
+	}))
+
+	req := httptest.NewRequest("POST", "/api/generate", strings.NewReader(`{"model":"llama3"}`))
+	req.Header.Set(logging.RequestIDHeader, "gen-1")
+	go h.ServeHTTP(httptest.NewRecorder(), req)
+	<-started
+
+	rec := httptest.NewRecorder()
+	reg.AdminHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/admin/requests", nil))
+	var list struct{ Requests []Request }
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Requests) != 1 {
+		t.Fatalf("requests %+v", list.Requests)
+	}
+	got := list.Requests[0]
+	if got.ID != "gen-1" || got.Client != "alice" || got.Model != "llama3" || got.Path != "/api/generate" || got.BytesStreamed != 7 {
+		t.Errorf("request %+v", got)
+	}
+
+	rec = httptest.NewRecorder()
+	reg.AdminHandler().ServeHTTP(rec, httptest.NewRequest("DELETE", "/admin/requests/nope", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("cancel unknown id: status %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	reg.AdminHandler().ServeHTTP(rec, httptest.NewRequest("DELETE", "/admin/requests/gen-1", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("cancel: status %d", rec.Code)
+	}
+	select {
+	case cause := <-done:
+		if cause != ErrCancelled {
+			t.Errorf("cause %v, want ErrCancelled", cause)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("request was not cancelled")
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(reg.List()) != 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := len(reg.List()); n != 0 {
+		t.Errorf("%d requests still listed after completion", n)
+	}
+}
@@ -0,0 +1,128 @@
+package ipfilter
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/yeti47/ollama-proxy/internal/apierror"
+	"github.com/yeti47/ollama-proxy/internal/audit"
+)
+
+// Prefixes is a list of CIDR ranges.
+type Prefixes []netip.Prefix
+
+// ParsePrefixes parses a comma-separated list of CIDRs. Bare addresses are
+// accepted and treated as single-host ranges.
+func ParsePrefixes(s string) (Prefixes, error) {
+	var out Prefixes
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if !strings.Contains(part, "/") {
+			addr, err := netip.ParseAddr(part)
+			if err != nil {
+				return nil, fmt.Errorf("invalid address %q: %w", part, err)
+			}
+			out = append(out, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", part, err)
+		}
+		out = append(out, p.Masked())
+	}
+	return out, nil
+}
+
+// Contains reports whether addr falls in any of the ranges.
+func (ps Prefixes) Contains(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, p := range ps {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the address of the client that sent r. The TCP peer is
+// used unless it is one of the trusted reverse proxies, in which case
+// X-Forwarded-For is walked from the right and the first address that is
+// not itself a trusted proxy wins. Unparseable entries stop the walk so a
+// client can't smuggle an arbitrary address past a trusted hop.
+func ClientIP(r *http.Request, trusted Prefixes) (netip.Addr, bool) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	peer, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	peer = peer.Unmap()
+	if len(trusted) == 0 || !trusted.Contains(peer) {
+		return peer, true
+	}
+
+	var hops []string
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		client = addr.Unmap()
+		if !trusted.Contains(client) {
+			break
+		}
+	}
+	return client, true
+}
+
+// Filter decides whether a client address may use the proxy. Deny rules win
+// over allow rules; an empty allow list allows everything not denied.
+type Filter struct {
+	Allow   Prefixes
+	Deny    Prefixes
+	Trusted Prefixes
+}
+
+// Allowed reports whether r may proceed, along with the client address it
+// was judged on.
+func (f *Filter) Allowed(r *http.Request) (netip.Addr, bool) {
+	addr, ok := ClientIP(r, f.Trusted)
+	if !ok {
+		return addr, false
+	}
+	if f.Deny.Contains(addr) {
+		return addr, false
+	}
+	if len(f.Allow) > 0 && !f.Allow.Contains(addr) {
+		return addr, false
+	}
+	return addr, true
+}
+
+// Middleware rejects requests from addresses f does not allow with 403.
+func Middleware(f *Filter, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		addr, ok := f.Allowed(r)
+		if !ok {
+			slog.WarnContext(r.Context(), "ip filter rejected", "remote", r.RemoteAddr, "client_ip", addr, "method", r.Method, "path", r.URL.Path)
+			audit.Event(audit.Blocked, r, "", "ip filter: "+addr.String())
+			apierror.Write(w, http.StatusForbidden, "forbidden")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,48 @@
+package ipfilter
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFilter(t *testing.T) {
+	mustParse := func(s string) Prefixes {
+		p, err := ParsePrefixes(s)
+		if err != nil {
+			t.Fatalf("parse %q: %v", s, err)
+		}
+		return p
+	}
+	f := &Filter{
+		Allow:   mustParse("192.168.1.0/24, 10.0.0.0/8"),
+		Deny:    mustParse("192.168.1.66"),
+		Trusted: mustParse("10.0.0.1"),
+	}
+
+	cases := []struct {
+		name   string
+		remote string
+		xff    string
+		want   bool
+	}{
+		{"lan client", "192.168.1.10:5000", "", true},
+		{"denied host", "192.168.1.66:5000", "", false},
+		{"outside allow list", "203.0.113.5:5000", "", false},
+		{"spoofed xff from untrusted peer ignored", "203.0.113.5:5000", "192.168.1.10", false},
+		{"via trusted proxy", "10.0.0.1:5000", "192.168.1.10", true},
+		{"via trusted proxy from outside", "10.0.0.1:5000", "192.168.1.10, 203.0.113.5", false},
+		{"ipv4-mapped ipv6", "[::ffff:192.168.1.10]:5000", "", true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/tags", nil)
+			req.RemoteAddr = tc.remote
+			if tc.xff != "" {
+				req.Header.Set("X-Forwarded-For", tc.xff)
+			}
+			if _, got := f.Allowed(req); got != tc.want {
+				t.Fatalf("expected allowed=%t got %t", tc.want, got)
+			}
+		})
+	}
+}
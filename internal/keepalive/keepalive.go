@@ -0,0 +1,152 @@
+// Package keepalive sets the keep_alive of requests, which decides how
+// long the upstream keeps a model loaded. Most clients never set it, so
+// models load and unload as traffic comes and goes; a dedicated box wants
+// them resident, a shared one wants them evicted promptly.
+package keepalive
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yeti47/ollama-proxy/internal/apierror"
+	"github.com/yeti47/ollama-proxy/internal/metrics"
+	"github.com/yeti47/ollama-proxy/internal/ollama"
+)
+
+// Forever is the keep_alive that keeps a model loaded until the upstream
+// restarts; Ollama takes any negative value as that.
+const Forever time.Duration = -1
+
+var applied = metrics.NewCounter("ollama_proxy_keep_alive_total",
+	"Requests whose keep_alive was changed, by action: default, cap or force.", "action")
+
+// paths are the endpoints that take keep_alive.
+var paths = map[string]bool{
+	"/api/chat": true, "/api/generate": true, "/api/embed": true, "/api/embeddings": true,
+}
+
+// Policy changes keep_alive. A nil field does nothing.
+type Policy struct {
+	// Default is set on requests without a keep_alive.
+	Default *time.Duration
+	// Max caps keep_alive; Forever counts as above any Max.
+	Max *time.Duration
+	// Force replaces any keep_alive, including 0, which unloads a model.
+	Force *time.Duration
+}
+
+// Parse reads a keep_alive as Ollama does: a duration such as "10m", a
+// number of seconds, or a negative number for Forever.
+func Parse(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if n, err := strconv.ParseFloat(s, 64); err == nil {
+		if n < 0 {
+			return Forever, nil
+		}
+		return time.Duration(n * float64(time.Second)), nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("keep_alive %q: want a duration such as 10m, seconds, or -1", s)
+	}
+	if d < 0 {
+		return Forever, nil
+	}
+	return d, nil
+}
+
+// Format writes d the way Ollama reads it.
+func Format(d time.Duration) json.RawMessage {
+	if d < 0 {
+		return json.RawMessage(`-1`)
+	}
+	b, _ := json.Marshal(d.String())
+	return b
+}
+
+// Enabled reports whether p changes anything.
+func (p *Policy) Enabled() bool {
+	return p != nil && (p.Default != nil || p.Max != nil || p.Force != nil)
+}
+
+// apply returns the keep_alive for a request that has raw, or none if
+// ok is false, and the action taken.
+func (p *Policy) apply(raw json.RawMessage, ok bool) (time.Duration, string) {
+	if p.Force != nil {
+		return *p.Force, "force"
+	}
+	if !ok {
+		if p.Default != nil {
+			return *p.Default, "default"
+		}
+		return 0, ""
+	}
+	if p.Max == nil {
+		return 0, ""
+	}
+	var d time.Duration
+	var s string
+	var n float64
+	switch {
+	case json.Unmarshal(raw, &s) == nil:
+		var err error
+		if d, err = Parse(s); err != nil {
+			return 0, "" // the upstream will reject it
+		}
+	case json.Unmarshal(raw, &n) == nil:
+		d, _ = Parse(strconv.FormatFloat(n, 'f', -1, 64))
+	default:
+		return 0, ""
+	}
+	if (d < 0 && *p.Max >= 0) || d > *p.Max {
+		return *p.Max, "cap"
+	}
+	return 0, ""
+}
+
+// Middleware applies p to requests to next.
+func (p *Policy) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !p.Enabled() || r.Method != http.MethodPost || !paths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		body, err := ollama.PeekBody(r)
+		if err != nil {
+			apierror.BodyError(w, err)
+			return
+		}
+		var req map[string]json.RawMessage
+		if json.Unmarshal(body, &req) != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		raw, ok := req["keep_alive"]
+		if ok && string(raw) == "null" {
+			ok = false
+		}
+		d, action := p.apply(raw, ok)
+		if action == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		req["keep_alive"] = Format(d)
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(req); err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ollama.ReplaceBody(r, bytes.TrimSuffix(buf.Bytes(), []byte("\n")))
+		applied.With(action).Inc()
+		slog.DebugContext(r.Context(), "keep_alive changed", "action", action, "from", string(raw), "to", string(req["keep_alive"]))
+		next.ServeHTTP(w, r)
+	})
+}
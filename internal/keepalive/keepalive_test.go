@@ -0,0 +1,59 @@
+package keepalive
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	for _, c := range []struct {
+		s    string
+		want time.Duration
+	}{
+		{"10m", 10 * time.Minute},
+		{"300", 5 * time.Minute},
+		{"-1", Forever},
+		{"-5m", Forever},
+		{"0", 0},
+	} {
+		got, err := Parse(c.s)
+		if err != nil || got != c.want {
+			t.Errorf("Parse(%q) = %v, %v", c.s, got, err)
+		}
+	}
+	if _, err := Parse("soon"); err == nil {
+		t.Error("Parse accepted soon")
+	}
+}
+
+func dur(d time.Duration) *time.Duration { return &d }
+
+func TestMiddleware(t *testing.T) {
+	for _, c := range []struct {
+		policy     Policy
+		body, want string
+	}{
+		{Policy{Default: dur(30 * time.Minute)}, `{"model":"m"}`, `{"keep_alive":"30m0s","model":"m"}`},
+		{Policy{Default: dur(30 * time.Minute)}, `{"model":"m","keep_alive":0}`, `{"model":"m","keep_alive":0}`},
+		{Policy{Default: dur(Forever)}, `{"model":"m","keep_alive":null}`, `{"keep_alive":-1,"model":"m"}`},
+		{Policy{Max: dur(10 * time.Minute)}, `{"model":"m","keep_alive":-1}`, `{"keep_alive":"10m0s","model":"m"}`},
+		{Policy{Max: dur(10 * time.Minute)}, `{"model":"m","keep_alive":"1h"}`, `{"keep_alive":"10m0s","model":"m"}`},
+		{Policy{Max: dur(10 * time.Minute)}, `{"model":"m","keep_alive":300}`, `{"model":"m","keep_alive":300}`},
+		{Policy{Max: dur(10 * time.Minute)}, `{"model":"m"}`, `{"model":"m"}`},
+		{Policy{Force: dur(Forever)}, `{"model":"m","keep_alive":0}`, `{"keep_alive":-1,"model":"m"}`},
+	} {
+		var got string
+		h := c.policy.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			b, _ := io.ReadAll(r.Body)
+			got = string(b)
+		}))
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(c.body)))
+		if got != c.want {
+			t.Errorf("%s: forwarded %s, want %s", c.body, got, c.want)
+		}
+	}
+}
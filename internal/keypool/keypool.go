@@ -0,0 +1,199 @@
+// Package keypool spreads upstream traffic over several Ollama API keys,
+// moving on to the next key when the current one is rejected or rate
+// limited.
+package keypool
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yeti47/ollama-proxy/internal/auth"
+	"github.com/yeti47/ollama-proxy/internal/metrics"
+	"github.com/yeti47/ollama-proxy/internal/notify"
+)
+
+var (
+	keyActive = metrics.NewGauge("ollama_proxy_upstream_key_active",
+		"1 for the upstream key currently in use, 0 for the others.", "key")
+	keyCooldowns = metrics.NewCounter("ollama_proxy_upstream_key_cooldowns_total",
+		"Times an upstream key was put on cooldown, by upstream status.", "key", "status")
+)
+
+// rejectedCooldown is how long a key the upstream refused (401/403) is
+// skipped. Such keys are usually revoked, so they are retried rarely.
+const rejectedCooldown = time.Hour
+
+// Pool hands out one key at a time. The current key is used until the
+// upstream answers 401, 403 or 429 for it; it then cools down and the
+// next available key takes over.
+type Pool struct {
+	// Cooldown is how long a rate-limited key is skipped when the upstream
+	// sends no Retry-After.
+	Cooldown time.Duration
+
+	mu      sync.Mutex
+	keys    []*entry
+	current int
+	now     func() time.Time
+}
+
+type entry struct {
+	key   string
+	name  string
+	until time.Time
+}
+
+// Split parses a key list separated by commas or newlines.
+func Split(s string) []string {
+	var keys []string
+	for _, k := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == '\n' || r == '\r' }) {
+		if k = strings.TrimSpace(k); k != "" {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// New returns a pool of keys.
+func New(keys []string, cooldown time.Duration) *Pool {
+	p := &Pool{Cooldown: cooldown, now: time.Now}
+	p.SetKeys(keys)
+	return p
+}
+
+// SetKeys replaces the pool's keys, e.g. after a rotation. Cooldowns of
+// keys that stay in the pool are kept.
+func (p *Pool) SetKeys(keys []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	old := make(map[string]*entry, len(p.keys))
+	for _, e := range p.keys {
+		old[e.key] = e
+		keyActive.With(e.name).Set(0)
+	}
+	var cur string
+	if len(p.keys) > 0 {
+		cur = p.keys[p.current].key
+	}
+	p.keys = p.keys[:0:0]
+	p.current = 0
+	for _, k := range keys {
+		e, ok := old[k]
+		if !ok {
+			e = &entry{key: k, name: auth.KeyName(k)}
+		}
+		if k == cur {
+			p.current = len(p.keys)
+		}
+		p.keys = append(p.keys, e)
+	}
+	if len(p.keys) > 0 {
+		keyActive.With(p.keys[p.current].name).Set(1)
+	}
+}
+
+// Len returns the number of keys in the pool.
+func (p *Pool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.keys)
+}
+
+// Key returns the key to use for the next request, or "" for an empty
+// pool. If every key is cooling down, the one that becomes available
+// first is returned.
+func (p *Pool) Key() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.keys) == 0 {
+		return ""
+	}
+	now := p.now()
+	if p.keys[p.current].until.After(now) {
+		next := p.current
+		for i := 1; i <= len(p.keys); i++ {
+			j := (p.current + i) % len(p.keys)
+			if !p.keys[j].until.After(now) {
+				next = j
+				break
+			}
+			if p.keys[j].until.Before(p.keys[next].until) {
+				next = j
+			}
+		}
+		p.activate(next)
+	}
+	return p.keys[p.current].key
+}
+
+func (p *Pool) activate(i int) {
+	if i == p.current {
+		return
+	}
+	keyActive.With(p.keys[p.current].name).Set(0)
+	p.current = i
+	keyActive.With(p.keys[i].name).Set(1)
+}
+
+// Report inspects the upstream response to a request made with key and
+// puts the key on cooldown if it was rejected or rate limited.
+func (p *Pool) Report(key string, resp *http.Response) {
+	var d time.Duration
+	switch resp.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		d = rejectedCooldown
+	case http.StatusTooManyRequests:
+		d = retryAfter(resp.Header.Get("Retry-After"), p.now())
+		if d <= 0 {
+			d = p.Cooldown
+		}
+	default:
+		return
+	}
+	p.mu.Lock()
+	now := p.now()
+	found := false
+	for _, e := range p.keys {
+		if e.key == key {
+			e.until = now.Add(d)
+			keyCooldowns.With(e.name, strconv.Itoa(resp.StatusCode)).Inc()
+			found = true
+			break
+		}
+	}
+	// with every key cooling down the proxy has nothing usable left to
+	// send upstream, which is as good as an open circuit
+	var reopen time.Time
+	for _, e := range p.keys {
+		if !e.until.After(now) {
+			found = false
+			break
+		}
+		if reopen.IsZero() || e.until.Before(reopen) {
+			reopen = e.until
+		}
+	}
+	n := len(p.keys)
+	p.mu.Unlock()
+	if found {
+		notify.Send(notify.CircuitOpen, "", "every upstream key is on cooldown after "+resp.Status,
+			"keys", n, "status", resp.StatusCode, "until", reopen.UTC())
+	}
+}
+
+// retryAfter parses a Retry-After value given in seconds or as a date.
+func retryAfter(v string, now time.Time) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if s, err := strconv.Atoi(v); err == nil {
+		return time.Duration(s) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return t.Sub(now)
+	}
+	return 0
+}
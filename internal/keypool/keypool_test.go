@@ -0,0 +1,46 @@
+package keypool
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestPoolRotation(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	p := New(Split("k1, k2\nk3"), time.Minute)
+	p.now = func() time.Time { return now }
+
+	if p.Len() != 3 || p.Key() != "k1" {
+		t.Fatalf("initial key = %q (len %d)", p.Key(), p.Len())
+	}
+	p.Report("k1", &http.Response{StatusCode: http.StatusOK})
+	if got := p.Key(); got != "k1" {
+		t.Fatalf("key after 200 = %q", got)
+	}
+
+	p.Report("k1", &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": {"30"}}})
+	if got := p.Key(); got != "k2" {
+		t.Fatalf("key after 429 = %q", got)
+	}
+	p.Report("k2", &http.Response{StatusCode: http.StatusUnauthorized, Header: http.Header{}})
+	if got := p.Key(); got != "k3" {
+		t.Fatalf("key after 401 = %q", got)
+	}
+	p.Report("k3", &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{}})
+	// all cooling down: k1 frees up first (30s)
+	if got := p.Key(); got != "k1" {
+		t.Fatalf("key with all on cooldown = %q", got)
+	}
+
+	now = now.Add(2 * time.Minute)
+	p.SetKeys([]string{"k2", "k1"})
+	if got := p.Key(); got != "k1" {
+		t.Fatalf("current key not kept across SetKeys: %q", got)
+	}
+	p.Report("k1", &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{}})
+	// k2 is still rejected; k1 is the earliest available again after its cooldown
+	if got := p.Key(); got != "k1" {
+		t.Fatalf("key = %q, want k1 (k2 rejected for an hour)", got)
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !windows
+
+package keyring
+
+import "os/exec"
+
+// command starts the credential store's command line tool: security on
+// macOS, secret-tool elsewhere. Tests replace it.
+var command = exec.Command
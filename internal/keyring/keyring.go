@@ -0,0 +1,33 @@
+// Package keyring stores secrets in the operating system's credential
+// store: the macOS Keychain, the Windows Credential Manager, or a Secret
+// Service implementation (GNOME Keyring, KWallet) on Linux and BSD.
+package keyring
+
+import "errors"
+
+// Service is the service name entries are stored under.
+const Service = "ollama-proxy"
+
+// DefaultAccount is the account used when none is given.
+const DefaultAccount = "default"
+
+// ErrNotFound is returned by Get and Delete when no entry exists.
+var ErrNotFound = errors.New("secret not found in keyring")
+
+// Get returns the secret stored for account.
+func Get(account string) (string, error) { return get(Service, account) }
+
+// Lookup returns the secret stored for account under another service, such
+// as an entry written by a different tool.
+func Lookup(service, account string) (string, error) { return get(service, account) }
+
+// Set stores secret for account, replacing any previous value.
+func Set(account, secret string) error {
+	if secret == "" {
+		return errors.New("refusing to store an empty secret")
+	}
+	return set(Service, account, secret)
+}
+
+// Delete removes the entry for account.
+func Delete(account string) error { return del(Service, account) }
@@ -0,0 +1,50 @@
+package keyring
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// The security tool's exit status when no matching item exists.
+const errSecItemNotFound = 44
+
+func get(service, account string) (string, error) {
+	out, err := command("/usr/bin/security", "find-generic-password", "-s", service, "-a", account, "-w").Output()
+	if err != nil {
+		var ee *exec.ExitError
+		if errors.As(err, &ee) && ee.ExitCode() == errSecItemNotFound {
+			return "", ErrNotFound
+		}
+		return "", fmt.Errorf("security find-generic-password: %w", err)
+	}
+	return strings.TrimRight(string(out), "\n"), nil
+}
+
+func set(service, account, secret string) error {
+	// pass the command on stdin (-i) with the password hex encoded (-X)
+	// so the secret never appears in the process list
+	cmd := command("/usr/bin/security", "-i")
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("add-generic-password -U -s %s -a %s -X %s\n",
+		quote(service), quote(account), hex.EncodeToString([]byte(secret))))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("security add-generic-password: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func del(service, account string) error {
+	err := command("/usr/bin/security", "delete-generic-password", "-s", service, "-a", account).Run()
+	var ee *exec.ExitError
+	if errors.As(err, &ee) && ee.ExitCode() == errSecItemNotFound {
+		return ErrNotFound
+	}
+	return err
+}
+
+// quote wraps s in double quotes for the security tool's interactive mode.
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
@@ -0,0 +1,131 @@
+//go:build !windows
+
+package keyring
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// notFound is the tool's exit status for a missing entry: security's
+// errSecItemNotFound, or secret-tool's plain failure without output.
+func notFound() int {
+	if runtime.GOOS == "darwin" {
+		return 44
+	}
+	return 1
+}
+
+// fakeTool makes command run this test binary in place of the credential
+// tool; it prints stdout and exits with code. It returns the arguments
+// of each call, and the file the last call's stdin is saved to.
+func fakeTool(t *testing.T, stdout string, code int) (*[][]string, string) {
+	t.Helper()
+	var calls [][]string
+	stdin := filepath.Join(t.TempDir(), "stdin")
+	orig := command
+	t.Cleanup(func() { command = orig })
+	command = func(name string, args ...string) *exec.Cmd {
+		calls = append(calls, append([]string{name}, args...))
+		cmd := exec.Command(os.Args[0], "-test.run=TestHelperProcess")
+		cmd.Env = append(os.Environ(), "KEYRING_FAKE_TOOL=1",
+			"KEYRING_FAKE_STDOUT="+stdout, "KEYRING_FAKE_EXIT="+strconv.Itoa(code), "KEYRING_FAKE_STDIN="+stdin)
+		return cmd
+	}
+	return &calls, stdin
+}
+
+// TestHelperProcess is the fake credential tool started by fakeTool.
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("KEYRING_FAKE_TOOL") != "1" {
+		return
+	}
+	b, _ := io.ReadAll(os.Stdin)
+	_ = os.WriteFile(os.Getenv("KEYRING_FAKE_STDIN"), b, 0o600)
+	fmt.Print(os.Getenv("KEYRING_FAKE_STDOUT"))
+	code, _ := strconv.Atoi(os.Getenv("KEYRING_FAKE_EXIT"))
+	os.Exit(code)
+}
+
+// names reports whether args name the entry of service and account, in
+// secret-tool's attributes or security's flags.
+func names(args []string, service, account string) bool {
+	pair := func(a, b string) bool {
+		for i := 0; i+1 < len(args); i++ {
+			if args[i] == a && args[i+1] == b {
+				return true
+			}
+		}
+		return false
+	}
+	return (pair("service", service) || pair("-s", service)) && (pair("account", account) || pair("-a", account))
+}
+
+func TestGet(t *testing.T) {
+	calls, _ := fakeTool(t, "sk-1", 0)
+	got, err := Get("work")
+	if err != nil || got != "sk-1" {
+		t.Fatalf("Get = %q, %v", got, err)
+	}
+	if args := (*calls)[0]; !names(args, Service, "work") {
+		t.Errorf("Get args %q", args)
+	}
+
+	if _, err := Lookup("other-app", "work"); err != nil {
+		t.Fatal(err)
+	}
+	if args := (*calls)[1]; !names(args, "other-app", "work") {
+		t.Errorf("Lookup args %q", args)
+	}
+}
+
+func TestGetNotFound(t *testing.T) {
+	fakeTool(t, "", notFound())
+	if _, err := Get("work"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get = %v, want ErrNotFound", err)
+	}
+	if err := Delete("work"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Delete = %v, want ErrNotFound", err)
+	}
+}
+
+func TestGetCommandFailure(t *testing.T) {
+	orig := command
+	t.Cleanup(func() { command = orig })
+	command = func(string, ...string) *exec.Cmd {
+		return exec.Command(filepath.Join(t.TempDir(), "missing"))
+	}
+	_, err := Get("work")
+	if err == nil || errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get = %v, want a command error", err)
+	}
+	if err := Set("work", "sk-1"); err == nil {
+		t.Fatal("Set succeeded without the tool")
+	}
+}
+
+func TestSet(t *testing.T) {
+	calls, stdin := fakeTool(t, "", 0)
+	if err := Set("work", ""); err == nil || len(*calls) != 0 {
+		t.Fatalf("empty secret: %v, %d calls", err, len(*calls))
+	}
+	if err := Set("work", "sk-secret"); err != nil {
+		t.Fatal(err)
+	}
+	// the secret goes in on stdin, never in the process list
+	if args := strings.Join((*calls)[0], " "); strings.Contains(args, "sk-secret") {
+		t.Errorf("secret in args %q", args)
+	}
+	b, _ := os.ReadFile(stdin)
+	if !strings.Contains(string(b), "sk-secret") && !strings.Contains(string(b), fmt.Sprintf("%x", "sk-secret")) {
+		t.Errorf("stdin %q", b)
+	}
+}
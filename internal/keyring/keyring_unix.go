@@ -0,0 +1,45 @@
+//go:build !darwin && !windows
+
+package keyring
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// The Secret Service is reached through secret-tool (libsecret-tools),
+// which avoids linking a D-Bus client into the proxy.
+
+func secretTool(args ...string) *exec.Cmd {
+	return command("secret-tool", args...)
+}
+
+func get(service, account string) (string, error) {
+	out, err := secretTool("lookup", "service", service, "account", account).Output()
+	if err != nil {
+		var ee *exec.ExitError
+		if errors.As(err, &ee) && len(out) == 0 {
+			return "", ErrNotFound
+		}
+		return "", fmt.Errorf("secret-tool lookup: %w", err)
+	}
+	return string(out), nil
+}
+
+func set(service, account, secret string) error {
+	cmd := secretTool("store", "--label="+service+" ("+account+")", "service", service, "account", account)
+	cmd.Stdin = strings.NewReader(secret)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("secret-tool store: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func del(service, account string) error {
+	if _, err := get(service, account); err != nil {
+		return err
+	}
+	return secretTool("clear", "service", service, "account", account).Run()
+}
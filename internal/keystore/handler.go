@@ -0,0 +1,103 @@
+package keystore
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/yeti47/ollama-proxy/internal/apierror"
+	"github.com/yeti47/ollama-proxy/internal/audit"
+)
+
+// AdminHandler serves the key management API. It expects to be mounted at
+// /admin/keys and /admin/keys/:
+//
+//	GET    /admin/keys       list keys
+//	POST   /admin/keys       create a key; the secret is only returned here
+//	GET    /admin/keys/{id}  show a key
+//	PATCH  /admin/keys/{id}  annotate owner, note, expiry, models, roles
+//	DELETE /admin/keys/{id}  revoke a key
+func AdminHandler(s *Store) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/keys"), "/")
+		switch {
+		case id == "" && r.Method == http.MethodGet:
+			keys, err := s.List()
+			if err != nil {
+				serverError(w, err)
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]any{"keys": keys})
+		case id == "" && r.Method == http.MethodPost:
+			var k Key
+			if err := json.NewDecoder(r.Body).Decode(&k); err != nil {
+				apierror.Write(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+				return
+			}
+			created, secret, err := s.Create(k)
+			if err != nil {
+				apierror.Write(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			slog.InfoContext(r.Context(), "admin: created key", "name", created.Name, "id", created.ID)
+			audit.Event(audit.AdminChange, r, "", "created key "+created.Name+" ("+created.ID+")")
+			writeJSON(w, http.StatusCreated, struct {
+				*Key
+				Secret string `json:"secret"`
+			}{created, secret})
+		case id != "" && r.Method == http.MethodGet:
+			k, err := s.Get(id)
+			if err != nil {
+				notFoundOr500(w, err)
+				return
+			}
+			writeJSON(w, http.StatusOK, k)
+		case id != "" && r.Method == http.MethodPatch:
+			var u Update
+			if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
+				apierror.Write(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+				return
+			}
+			k, err := s.Annotate(id, u)
+			if err != nil {
+				notFoundOr500(w, err)
+				return
+			}
+			slog.InfoContext(r.Context(), "admin: updated key", "name", k.Name, "id", k.ID)
+			audit.Event(audit.AdminChange, r, "", "updated key "+k.Name+" ("+k.ID+")")
+			writeJSON(w, http.StatusOK, k)
+		case id != "" && r.Method == http.MethodDelete:
+			k, err := s.Revoke(id)
+			if err != nil {
+				notFoundOr500(w, err)
+				return
+			}
+			slog.InfoContext(r.Context(), "admin: revoked key", "name", k.Name, "id", k.ID)
+			audit.Event(audit.AdminChange, r, "", "revoked key "+k.Name+" ("+k.ID+")")
+			writeJSON(w, http.StatusOK, k)
+		default:
+			apierror.Write(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func notFoundOr500(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrNotFound) {
+		apierror.Write(w, http.StatusNotFound, err.Error())
+		return
+	}
+	serverError(w, err)
+}
+
+func serverError(w http.ResponseWriter, err error) {
+	slog.Error("admin: key store error", "err", err)
+	apierror.Write(w, http.StatusInternalServerError, "internal error")
+}
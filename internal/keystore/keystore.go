@@ -0,0 +1,388 @@
+package keystore
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3" // registers the "sqlite3" driver
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/yeti47/ollama-proxy/internal/auth"
+	"github.com/yeti47/ollama-proxy/internal/schedule"
+)
+
+// ErrNotFound is returned for unknown key ids.
+var ErrNotFound = errors.New("key not found")
+
+// secretPrefix marks keys issued by the store.
+const secretPrefix = "opk_"
+
+// lookupLen is how much of a secret is stored in the clear to find its
+// row. It is short enough to reveal nothing useful and long enough to
+// make collisions rare.
+const lookupLen = len(secretPrefix) + 8
+
+// cacheTTL is how long a successful bcrypt check is remembered.
+const cacheTTL = 5 * time.Minute
+
+// Key is a proxy-issued client key as stored in the database. The secret
+// itself is never part of this struct and is not stored; Prefix is its
+// first few characters, to help recognise a key.
+type Key struct {
+	ID        string             `json:"id"`
+	Prefix    string             `json:"prefix"`
+	Name      string             `json:"name"`
+	Owner     string             `json:"owner,omitempty"`
+	Note      string             `json:"note,omitempty"`
+	Models    []string           `json:"models,omitempty"`
+	Roles     []string           `json:"roles,omitempty"`
+	Schedule  *schedule.Schedule `json:"schedule,omitempty"`
+	CreatedAt time.Time          `json:"created_at"`
+	ExpiresAt *time.Time         `json:"expires_at,omitempty"`
+	RevokedAt *time.Time         `json:"revoked_at,omitempty"`
+}
+
+// Active reports whether k may currently be used.
+func (k *Key) Active(now time.Time) bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || now.Before(*k.ExpiresAt))
+}
+
+// Store persists client keys in SQLite. Only bcrypt hashes of the secrets
+// are stored.
+type Store struct {
+	db   *sql.DB
+	now  func() time.Time
+	cost int
+
+	mu    sync.Mutex
+	cache map[[32]byte]cacheEntry
+}
+
+type cacheEntry struct {
+	id      string
+	expires time.Time
+}
+
+const schema = `
+CREATE TABLE IF NOT EXISTS client_keys (
+	id          TEXT PRIMARY KEY,
+	prefix      TEXT NOT NULL,
+	secret_hash TEXT NOT NULL,
+	name        TEXT NOT NULL UNIQUE,
+	owner       TEXT NOT NULL DEFAULT '',
+	note        TEXT NOT NULL DEFAULT '',
+	models      TEXT NOT NULL DEFAULT '[]',
+	roles       TEXT NOT NULL DEFAULT '[]',
+	schedule    TEXT NOT NULL DEFAULT '',
+	created_at  INTEGER NOT NULL,
+	expires_at  INTEGER,
+	revoked_at  INTEGER
+);
+CREATE INDEX IF NOT EXISTS client_keys_prefix ON client_keys (prefix)`
+
+// Open opens (and if needed creates) the key database at path.
+func Open(path string) (*Store, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite serializes writers anyway; one connection avoids
+	// "database is locked" errors under concurrent requests
+	db.SetMaxOpenConns(1)
+	s := &Store{db: db, now: time.Now, cost: bcrypt.DefaultCost, cache: make(map[[32]byte]cacheEntry)}
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("creating schema: %w", err)
+	}
+	return s, nil
+}
+
+func lookupPrefix(secret string) string {
+	if len(secret) > lookupLen {
+		return secret[:lookupLen]
+	}
+	return secret
+}
+
+// Close closes the database.
+func (s *Store) Close() error { return s.db.Close() }
+
+func randomToken(prefix string, n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return prefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Create stores a new key and returns it together with the secret to hand
+// to the client.
+func (s *Store) Create(k Key) (*Key, string, error) {
+	if k.Name == "" {
+		return nil, "", errors.New("name is required")
+	}
+	id, err := randomToken("", 9)
+	if err != nil {
+		return nil, "", err
+	}
+	secret, err := randomToken(secretPrefix, 32)
+	if err != nil {
+		return nil, "", err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(secret), s.cost)
+	if err != nil {
+		return nil, "", err
+	}
+	k.ID = id
+	k.Prefix = lookupPrefix(secret)
+	k.CreatedAt = s.now().UTC().Truncate(time.Second)
+	k.RevokedAt = nil
+	models, _ := json.Marshal(nonNil(k.Models))
+	roles, _ := json.Marshal(nonNil(k.Roles))
+	_, err = s.db.Exec(`INSERT INTO client_keys (id, prefix, secret_hash, name, owner, note, models, roles, schedule, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		k.ID, k.Prefix, string(hash), k.Name, k.Owner, k.Note, string(models), string(roles), scheduleJSON(k.Schedule), k.CreatedAt.Unix(), unixOrNil(k.ExpiresAt))
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE") {
+			return nil, "", fmt.Errorf("a key named %q already exists", k.Name)
+		}
+		return nil, "", err
+	}
+	return &k, secret, nil
+}
+
+const selectCols = `id, prefix, name, owner, note, models, roles, schedule, created_at, expires_at, revoked_at`
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanKey(row scanner) (*Key, error) {
+	var k Key
+	var models, roles, sched string
+	var created int64
+	var expires, revoked sql.NullInt64
+	if err := row.Scan(&k.ID, &k.Prefix, &k.Name, &k.Owner, &k.Note, &models, &roles, &sched, &created, &expires, &revoked); err != nil {
+		return nil, err
+	}
+	_ = json.Unmarshal([]byte(models), &k.Models)
+	_ = json.Unmarshal([]byte(roles), &k.Roles)
+	if sched != "" {
+		if err := json.Unmarshal([]byte(sched), &k.Schedule); err != nil {
+			return nil, fmt.Errorf("key %s: %w", k.ID, err)
+		}
+	}
+	k.CreatedAt = time.Unix(created, 0).UTC()
+	k.ExpiresAt = timeOrNil(expires)
+	k.RevokedAt = timeOrNil(revoked)
+	return &k, nil
+}
+
+// List returns all keys, newest first.
+func (s *Store) List() ([]*Key, error) {
+	rows, err := s.db.Query(`SELECT ` + selectCols + ` FROM client_keys ORDER BY created_at DESC, name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	keys := []*Key{}
+	for rows.Next() {
+		k, err := scanKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// Get returns the key with the given id.
+func (s *Store) Get(id string) (*Key, error) {
+	k, err := scanKey(s.db.QueryRow(`SELECT `+selectCols+` FROM client_keys WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return k, err
+}
+
+// Update is a partial update of a key's annotations. Nil fields are left
+// unchanged; ClearExpiry and ClearSchedule remove the expiry and the
+// schedule.
+type Update struct {
+	Owner         *string            `json:"owner"`
+	Note          *string            `json:"note"`
+	Models        *[]string          `json:"models"`
+	Roles         *[]string          `json:"roles"`
+	Schedule      *schedule.Schedule `json:"schedule"`
+	ExpiresAt     *time.Time         `json:"expires_at"`
+	ClearExpiry   bool               `json:"clear_expiry"`
+	ClearSchedule bool               `json:"clear_schedule"`
+}
+
+// Annotate applies u to the key with the given id.
+func (s *Store) Annotate(id string, u Update) (*Key, error) {
+	k, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if u.Owner != nil {
+		k.Owner = *u.Owner
+	}
+	if u.Note != nil {
+		k.Note = *u.Note
+	}
+	if u.Models != nil {
+		k.Models = *u.Models
+	}
+	if u.Roles != nil {
+		k.Roles = *u.Roles
+	}
+	if u.Schedule != nil {
+		k.Schedule = u.Schedule
+	}
+	if u.ClearSchedule {
+		k.Schedule = nil
+	}
+	if u.ExpiresAt != nil {
+		k.ExpiresAt = u.ExpiresAt
+	}
+	if u.ClearExpiry {
+		k.ExpiresAt = nil
+	}
+	models, _ := json.Marshal(nonNil(k.Models))
+	roles, _ := json.Marshal(nonNil(k.Roles))
+	_, err = s.db.Exec(`UPDATE client_keys SET owner = ?, note = ?, models = ?, roles = ?, schedule = ?, expires_at = ? WHERE id = ?`,
+		k.Owner, k.Note, string(models), string(roles), scheduleJSON(k.Schedule), unixOrNil(k.ExpiresAt), id)
+	if err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Revoke marks the key as revoked. Revoking twice keeps the first time.
+func (s *Store) Revoke(id string) (*Key, error) {
+	res, err := s.db.Exec(`UPDATE client_keys SET revoked_at = COALESCE(revoked_at, ?) WHERE id = ?`, s.now().Unix(), id)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, ErrNotFound
+	}
+	return s.Get(id)
+}
+
+// Authenticate implements auth.Authenticator for keys issued by the store.
+// The row is found by the secret's prefix and the secret checked against
+// its bcrypt hash; successful checks are cached, but revocation and expiry
+// are always read from the database.
+func (s *Store) Authenticate(r *http.Request) (*auth.Identity, error) {
+	token := auth.BearerToken(r)
+	if !strings.HasPrefix(token, secretPrefix) {
+		return nil, auth.ErrNoCredentials
+	}
+	sum := sha256.Sum256([]byte(token))
+	s.mu.Lock()
+	e, cached := s.cache[sum]
+	s.mu.Unlock()
+
+	var k *Key
+	if cached && s.now().Before(e.expires) {
+		var err error
+		if k, err = s.Get(e.id); err != nil {
+			return nil, fmt.Errorf("%w: key store: %v", auth.ErrInvalidCredentials, err)
+		}
+	} else {
+		var err error
+		if k, err = s.verify(token); err != nil {
+			return nil, err
+		}
+		s.mu.Lock()
+		s.cache[sum] = cacheEntry{id: k.ID, expires: s.now().Add(cacheTTL)}
+		s.mu.Unlock()
+	}
+	if !k.Active(s.now()) {
+		return nil, fmt.Errorf("%w: key %s is revoked or expired", auth.ErrInvalidCredentials, k.Name)
+	}
+	return &auth.Identity{Name: k.Name, Models: k.Models, Roles: k.Roles, Schedule: k.Schedule}, nil
+}
+
+// verify finds the key whose hash matches token.
+func (s *Store) verify(token string) (*Key, error) {
+	rows, err := s.db.Query(`SELECT secret_hash, `+selectCols+` FROM client_keys WHERE prefix = ?`, lookupPrefix(token))
+	if err != nil {
+		return nil, fmt.Errorf("%w: key store: %v", auth.ErrInvalidCredentials, err)
+	}
+	type candidate struct {
+		hash string
+		key  *Key
+	}
+	var cands []candidate
+	for rows.Next() {
+		var c candidate
+		c.key, err = scanKey(hashScanner{rows, &c.hash})
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("%w: key store: %v", auth.ErrInvalidCredentials, err)
+		}
+		cands = append(cands, c)
+	}
+	rows.Close()
+	// compare after releasing the connection; bcrypt is slow
+	for _, c := range cands {
+		if bcrypt.CompareHashAndPassword([]byte(c.hash), []byte(token)) == nil {
+			return c.key, nil
+		}
+	}
+	return nil, auth.ErrInvalidCredentials
+}
+
+// hashScanner prepends the secret_hash column to a key scan.
+type hashScanner struct {
+	scanner
+	hash *string
+}
+
+func (h hashScanner) Scan(dest ...any) error {
+	return h.scanner.Scan(append([]any{h.hash}, dest...)...)
+}
+
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
+
+// scheduleJSON encodes a schedule for the schedule column; no schedule is
+// stored as an empty string.
+func scheduleJSON(sc *schedule.Schedule) string {
+	if sc == nil {
+		return ""
+	}
+	b, _ := json.Marshal(sc)
+	return string(b)
+}
+
+func unixOrNil(t *time.Time) any {
+	if t == nil {
+		return nil
+	}
+	return t.Unix()
+}
+
+func timeOrNil(v sql.NullInt64) *time.Time {
+	if !v.Valid {
+		return nil
+	}
+	t := time.Unix(v.Int64, 0).UTC()
+	return &t
+}
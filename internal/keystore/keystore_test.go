@@ -0,0 +1,155 @@
+package keystore
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/yeti47/ollama-proxy/internal/auth"
+)
+
+func openTest(t *testing.T) *Store {
+	t.Helper()
+	s, err := Open(filepath.Join(t.TempDir(), "keys.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	s.cost = bcrypt.MinCost
+	return s
+}
+
+func bearer(token string) *http.Request {
+	r := httptest.NewRequest("POST", "/api/chat", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	return r
+}
+
+func TestCreateAuthenticateRevoke(t *testing.T) {
+	s := openTest(t)
+	k, secret, err := s.Create(Key{Name: "alice", Models: []string{"llama3*"}, Roles: []string{"user"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(secret, "opk_") || !strings.HasPrefix(secret, k.Prefix) {
+		t.Fatalf("secret %q doesn't match prefix %q", secret, k.Prefix)
+	}
+	var stored string
+	if err := s.db.QueryRow(`SELECT secret_hash FROM client_keys WHERE id = ?`, k.ID).Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(stored, secret) || bcrypt.CompareHashAndPassword([]byte(stored), []byte(secret)) != nil {
+		t.Fatalf("stored secret is not a hash of the key: %q", stored)
+	}
+	if _, _, err := s.Create(Key{Name: "alice"}); err == nil {
+		t.Fatal("duplicate name accepted")
+	}
+
+	id, err := s.Authenticate(bearer(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.Name != "alice" || len(id.Models) != 1 || id.Roles[0] != "user" {
+		t.Fatalf("identity = %+v", id)
+	}
+	if _, err := s.Authenticate(bearer(k.Prefix + "tampered")); !errors.Is(err, auth.ErrInvalidCredentials) {
+		t.Fatalf("wrong secret with known prefix err = %v", err)
+	}
+	if _, err := s.Authenticate(bearer("opk_nope")); !errors.Is(err, auth.ErrInvalidCredentials) {
+		t.Fatalf("unknown key err = %v", err)
+	}
+	if _, err := s.Authenticate(bearer("some-static-key")); !errors.Is(err, auth.ErrNoCredentials) {
+		t.Fatalf("foreign key err = %v", err)
+	}
+
+	if _, err := s.Revoke(k.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Authenticate(bearer(secret)); !errors.Is(err, auth.ErrInvalidCredentials) {
+		t.Fatalf("revoked key err = %v", err)
+	}
+	if _, err := s.Revoke("missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("revoke missing err = %v", err)
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	s := openTest(t)
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+	exp := now.Add(time.Hour)
+	_, secret, err := s.Create(Key{Name: "temp", ExpiresAt: &exp})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Authenticate(bearer(secret)); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(2 * time.Hour)
+	if _, err := s.Authenticate(bearer(secret)); !errors.Is(err, auth.ErrInvalidCredentials) {
+		t.Fatalf("expired key err = %v", err)
+	}
+}
+
+func TestAdminHandler(t *testing.T) {
+	s := openTest(t)
+	h := AdminHandler(s)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("POST", "/admin/keys", strings.NewReader(`{"name":"bob","owner":"team-a"}`)))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create status = %d: %s", rec.Code, rec.Body)
+	}
+	var created struct {
+		ID     string `json:"id"`
+		Secret string `json:"secret"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil || created.Secret == "" {
+		t.Fatalf("create body %s: %v", rec.Body, err)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("PATCH", "/admin/keys/"+created.ID, strings.NewReader(`{"note":"ci"}`)))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"note":"ci"`) {
+		t.Fatalf("patch = %d %s", rec.Code, rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("PATCH", "/admin/keys/"+created.ID, strings.NewReader(`{"schedule":{"timezone":"UTC","windows":["mon-fri 08:00-20:00"]}}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("patch schedule = %d %s", rec.Code, rec.Body)
+	}
+	if k, err := s.Get(created.ID); err != nil || k.Schedule == nil || k.Schedule.Windows[0] != "mon-fri 08:00-20:00" {
+		t.Fatalf("schedule not stored: %+v, %v", k, err)
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("PATCH", "/admin/keys/"+created.ID, strings.NewReader(`{"schedule":{"windows":["someday 08:00-20:00"]}}`)))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("patch invalid schedule = %d %s", rec.Code, rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/admin/keys", nil))
+	if rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), created.Secret) {
+		t.Fatalf("list = %d %s", rec.Code, rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("DELETE", "/admin/keys/"+created.ID, nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "revoked_at") {
+		t.Fatalf("delete = %d %s", rec.Code, rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/admin/keys/nope", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("get missing = %d", rec.Code)
+	}
+}
@@ -0,0 +1,107 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yeti47/ollama-proxy/internal/ipfilter"
+)
+
+// AccessLog writes one line per request in the Common or Combined Log
+// Format, followed by the duration in microseconds (Apache's %D), e.g.
+//
+//	10.0.0.8 - alice [16/Oct/2026:09:12:44 +0000] "POST /api/chat HTTP/1.1" 200 5120 "-" "curl/8.5.0" 1843211
+//
+// The user field is the authenticated client name, or "-".
+type AccessLog struct {
+	// Combined adds the Referer and User-Agent fields.
+	Combined bool
+	// Trusted are reverse proxies whose X-Forwarded-For is used for the
+	// client address.
+	Trusted ipfilter.Prefixes
+
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewAccessLog returns an access log writing to w.
+func NewAccessLog(w io.Writer, combined bool, trusted ipfilter.Prefixes) *AccessLog {
+	return &AccessLog{Combined: combined, Trusted: trusted, w: w}
+}
+
+type accessKey struct{}
+
+// accessEntry collects what inner handlers learn about a request.
+type accessEntry struct {
+	user string
+}
+
+// Middleware logs every request passing through next, including those
+// rejected before authentication.
+func (l *AccessLog) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		e := &accessEntry{}
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), accessKey{}, e)))
+		l.write(r, e, sw, start, time.Since(start))
+	})
+}
+
+func (l *AccessLog) write(r *http.Request, e *accessEntry, sw *statusWriter, start time.Time, d time.Duration) {
+	host := "-"
+	if addr, ok := ipfilter.ClientIP(r, l.Trusted); ok {
+		host = addr.String()
+	}
+	user := "-"
+	if e.user != "" {
+		user = strings.ReplaceAll(quote(e.user), " ", "%20")
+	}
+	size := "-"
+	if sw.bytes > 0 {
+		size = strconv.FormatInt(sw.bytes, 10)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s - %s [%s] \"%s %s %s\" %d %s", host, user, start.Format("02/Jan/2006:15:04:05 -0700"),
+		quote(r.Method), quote(r.RequestURI), quote(r.Proto), sw.status, size)
+	if l.Combined {
+		fmt.Fprintf(&b, " \"%s\" \"%s\"", orDash(quote(r.Referer())), orDash(quote(r.UserAgent())))
+	}
+	fmt.Fprintf(&b, " %d\n", d.Microseconds())
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, _ = io.WriteString(l.w, b.String())
+}
+
+// quote escapes quotes, backslashes and non-printable bytes so a field
+// can't break the line format.
+func quote(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < 0x20 || c >= 0x7f:
+			fmt.Fprintf(&b, "\\x%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
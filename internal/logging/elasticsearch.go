@@ -0,0 +1,230 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// esQueueSize bounds the records waiting to be indexed. When
+	// Elasticsearch falls behind, newer records are dropped rather than
+	// holding up requests.
+	esQueueSize = 10000
+	// esBatchSize is the number of records per bulk request.
+	esBatchSize = 500
+	// esFlushInterval bounds how long a record waits for its batch.
+	esFlushInterval = 2 * time.Second
+	// esAttempts is how often a bulk request is tried before the batch is
+	// dropped.
+	esAttempts = 5
+)
+
+// Elasticsearch ships log records to Elasticsearch or OpenSearch with the
+// bulk API. Records are queued and indexed in batches by a background
+// goroutine, which backs off while the cluster rejects or fails requests.
+type Elasticsearch struct {
+	bulkURL string
+	index   string
+	apiKey  string
+	client  *http.Client
+	backoff time.Duration
+
+	queue   chan []byte
+	done    chan struct{}
+	dropped atomic.Int64
+	mu      sync.RWMutex // guards closed against concurrent Send
+	closed  bool
+}
+
+// DialElasticsearch returns a shipper indexing into index at rawURL, the
+// cluster's base URL. Basic auth credentials can be part of the URL; a
+// non-empty apiKey is sent as an ApiKey authorization instead.
+func DialElasticsearch(rawURL, index, apiKey string) (*Elasticsearch, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("elasticsearch url must be http(s)://host:port")
+	}
+	if index == "" {
+		return nil, fmt.Errorf("elasticsearch index must not be empty")
+	}
+	e := &Elasticsearch{
+		bulkURL: strings.TrimSuffix(u.String(), "/") + "/_bulk",
+		index:   index,
+		apiKey:  apiKey,
+		client:  &http.Client{Timeout: 30 * time.Second},
+		backoff: time.Second,
+		queue:   make(chan []byte, esQueueSize),
+		done:    make(chan struct{}),
+	}
+	go e.run()
+	return e, nil
+}
+
+// Send queues one JSON document. It never blocks; when the queue is full
+// the record is dropped and counted.
+func (e *Elasticsearch) Send(_ slog.Level, _ time.Time, msg []byte) error {
+	doc := bytes.TrimRight(msg, "\n")
+	doc = append(make([]byte, 0, len(doc)), doc...)
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.closed {
+		return nil
+	}
+	select {
+	case e.queue <- doc:
+	default:
+		e.dropped.Add(1)
+	}
+	return nil
+}
+
+func (e *Elasticsearch) run() {
+	defer close(e.done)
+	ticker := time.NewTicker(esFlushInterval)
+	defer ticker.Stop()
+	batch := make([][]byte, 0, esBatchSize)
+	flush := func() {
+		if len(batch) > 0 {
+			e.bulk(batch)
+			batch = batch[:0]
+		}
+	}
+	for {
+		select {
+		case doc, ok := <-e.queue:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, doc)
+			if len(batch) == esBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// bulk indexes batch, retrying with exponential backoff while the
+// cluster is unreachable, overloaded (429) or failing (5xx). While it
+// retries the queue fills up, and Send starts dropping records.
+func (e *Elasticsearch) bulk(batch [][]byte) {
+	var body bytes.Buffer
+	action, _ := json.Marshal(map[string]any{"create": map[string]string{"_index": e.index}})
+	for _, doc := range batch {
+		body.Write(action)
+		body.WriteByte('\n')
+		body.Write(doc)
+		body.WriteByte('\n')
+	}
+	var err error
+	for i := 0; i < esAttempts; i++ {
+		if i > 0 {
+			time.Sleep(e.backoff << (i - 1))
+		}
+		var retry bool
+		if retry, err = e.post(body.Bytes()); err == nil || !retry {
+			break
+		}
+	}
+	if n := e.dropped.Swap(0); n > 0 {
+		err = fmt.Errorf("%d records dropped because the queue was full; last error: %v", n, err)
+	}
+	if err != nil {
+		// not through slog: that would feed the failure back into the queue
+		fmt.Fprintf(os.Stderr, "elasticsearch log shipping: %v\n", err)
+	}
+}
+
+// post sends one bulk request and reports whether a failure is worth
+// retrying.
+func (e *Elasticsearch) post(body []byte) (retry bool, err error) {
+	req, err := http.NewRequest(http.MethodPost, e.bulkURL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	if e.apiKey != "" {
+		req.Header.Set("Authorization", "ApiKey "+e.apiKey)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return true, fmt.Errorf("bulk request: %s", resp.Status)
+	}
+	if resp.StatusCode >= 300 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return false, fmt.Errorf("bulk request: %s: %s", resp.Status, b)
+	}
+	// a 200 can still carry per-document failures, e.g. mapping conflicts
+	var result struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			Status int `json:"status"`
+			Error  struct {
+				Reason string `json:"reason"`
+			} `json:"error"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil || !result.Errors {
+		return false, nil
+	}
+	failed, reason := 0, ""
+	for _, item := range result.Items {
+		for _, r := range item {
+			if r.Status >= 300 {
+				failed++
+				reason = r.Error.Reason
+			}
+		}
+	}
+	return false, fmt.Errorf("%d of %d documents rejected: %s", failed, len(result.Items), reason)
+}
+
+// Close indexes the queued records and stops the shipper.
+func (e *Elasticsearch) Close() error {
+	e.mu.Lock()
+	if !e.closed {
+		e.closed = true
+		close(e.queue)
+	}
+	e.mu.Unlock()
+	<-e.done
+	return nil
+}
+
+// NewElasticsearch returns a logger shipping to e. Records are always
+// JSON documents, with the time as @timestamp as Kibana and OpenSearch
+// Dashboards expect.
+func NewElasticsearch(e *Elasticsearch, level slog.Leveler) *slog.Logger {
+	h := &sinkHandler{
+		mu:   new(sync.Mutex),
+		buf:  new(bytes.Buffer),
+		send: e.Send,
+	}
+	h.Handler = slog.NewJSONHandler(h.buf, &slog.HandlerOptions{
+		Level: level,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) == 0 && a.Key == slog.TimeKey {
+				a.Key = "@timestamp"
+			}
+			return a
+		},
+	})
+	return slog.New(contextHandler{h})
+}
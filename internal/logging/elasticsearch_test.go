@@ -0,0 +1,77 @@
+package logging
+
+import (
+	"bufio"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestElasticsearch(t *testing.T) {
+	var mu sync.Mutex
+	var docs []map[string]any
+	overloaded := 1
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/_bulk" || r.Header.Get("Authorization") != "ApiKey k1" {
+			t.Errorf("bulk request to %s with %q", r.URL.Path, r.Header.Get("Authorization"))
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if overloaded > 0 {
+			// the first attempt is rejected and must be retried
+			overloaded--
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		sc := bufio.NewScanner(r.Body)
+		for i := 0; sc.Scan(); i++ {
+			var m map[string]any
+			if err := json.Unmarshal(sc.Bytes(), &m); err != nil {
+				t.Errorf("line %d: %v", i, err)
+			}
+			if i%2 == 0 {
+				if create, _ := m["create"].(map[string]any); create["_index"] != "proxy-logs" {
+					t.Errorf("action %v", m)
+				}
+				continue
+			}
+			docs = append(docs, m)
+		}
+		_, _ = w.Write([]byte(`{"errors":false,"items":[]}`))
+	}))
+	defer srv.Close()
+
+	es, err := DialElasticsearch(srv.URL, "proxy-logs", "k1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	es.backoff = time.Millisecond
+	logger := NewElasticsearch(es, slog.LevelInfo)
+	logger.Info("request", "method", "POST", "path", "/api/chat", "status", 200)
+	logger.Debug("not shipped")
+	logger.Warn("upstream response", "status", 502)
+	es.Close()
+	logger.Info("after close")
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(docs) != 2 {
+		t.Fatalf("indexed %d documents, want 2: %v", len(docs), docs)
+	}
+	if d := docs[0]; d["msg"] != "request" || d["path"] != "/api/chat" || d["@timestamp"] == nil || d["level"] != "INFO" {
+		t.Errorf("document %v", d)
+	}
+}
+
+func TestElasticsearchURL(t *testing.T) {
+	for _, u := range []string{"", "es.internal:9200", "ftp://es.internal"} {
+		if _, err := DialElasticsearch(u, "logs", ""); err == nil || !strings.Contains(err.Error(), "http(s)") {
+			t.Errorf("%q: err %v", u, err)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// JournaldSocket is where journald receives native protocol messages.
+const JournaldSocket = "/run/systemd/journal/socket"
+
+// Journald sends messages to journald over its native protocol.
+type Journald struct {
+	conn  *net.UnixConn
+	ident string
+}
+
+// DialJournald connects to the local journald.
+func DialJournald() (*Journald, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: JournaldSocket, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return &Journald{conn: conn, ident: filepath.Base(os.Args[0])}, nil
+}
+
+// Close closes the connection.
+func (j *Journald) Close() error {
+	return j.conn.Close()
+}
+
+// NewJournald returns a logger sending to j. Every attribute becomes a
+// journal field (request_id as REQUEST_ID, and so on) that journalctl can
+// filter on.
+func NewJournald(j *Journald, level slog.Leveler) *slog.Logger {
+	return slog.New(contextHandler{&journaldHandler{j: j, level: level}})
+}
+
+type journaldHandler struct {
+	j      *Journald
+	level  slog.Leveler
+	prefix string
+	attrs  []byte
+}
+
+func (h *journaldHandler) Enabled(_ context.Context, l slog.Level) bool {
+	return l >= h.level.Level()
+}
+
+func (h *journaldHandler) Handle(_ context.Context, r slog.Record) error {
+	var b bytes.Buffer
+	writeField(&b, "MESSAGE", r.Message)
+	writeField(&b, "PRIORITY", strconv.Itoa(severity(r.Level)))
+	writeField(&b, "SYSLOG_IDENTIFIER", h.j.ident)
+	writeField(&b, "LEVEL", r.Level.String())
+	b.Write(h.attrs)
+	r.Attrs(func(a slog.Attr) bool {
+		appendAttr(&b, h.prefix, a)
+		return true
+	})
+	_, err := h.j.conn.Write(b.Bytes())
+	return err
+}
+
+func (h *journaldHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := *h
+	var b bytes.Buffer
+	b.Write(h.attrs)
+	for _, a := range attrs {
+		appendAttr(&b, h.prefix, a)
+	}
+	c.attrs = b.Bytes()
+	return &c
+}
+
+func (h *journaldHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	c := *h
+	c.prefix = h.prefix + name + "_"
+	return &c
+}
+
+func appendAttr(b *bytes.Buffer, prefix string, a slog.Attr) {
+	v := a.Value.Resolve()
+	if v.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "_"
+		}
+		for _, ga := range v.Group() {
+			appendAttr(b, prefix, ga)
+		}
+		return
+	}
+	if a.Key == "" {
+		return
+	}
+	var s string
+	if v.Kind() == slog.KindTime {
+		s = v.Time().Format(time.RFC3339Nano)
+	} else {
+		s = v.String()
+	}
+	writeField(b, fieldName(prefix+a.Key), s)
+}
+
+// fieldName converts an attribute key to a journal field name: upper
+// case letters, digits and underscores, not starting with an underscore
+// (those are trusted fields set by journald itself).
+func fieldName(key string) string {
+	var b strings.Builder
+	for _, c := range strings.ToUpper(key) {
+		if c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' {
+			b.WriteRune(c)
+		} else {
+			b.WriteByte('_')
+		}
+	}
+	name := strings.TrimLeft(b.String(), "_")
+	if name == "" || name[0] >= '0' && name[0] <= '9' {
+		name = "F_" + name
+	}
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	return name
+}
+
+// writeField encodes one field. Values containing a newline use the
+// length-prefixed binary form.
+func writeField(b *bytes.Buffer, name, value string) {
+	b.WriteString(name)
+	if !strings.Contains(value, "\n") {
+		b.WriteByte('=')
+		b.WriteString(value)
+		b.WriteByte('\n')
+		return
+	}
+	b.WriteByte('\n')
+	_ = binary.Write(b, binary.LittleEndian, uint64(len(value)))
+	b.WriteString(value)
+	b.WriteByte('\n')
+}
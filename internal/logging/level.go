@@ -0,0 +1,135 @@
+package logging
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yeti47/ollama-proxy/internal/apierror"
+	"github.com/yeti47/ollama-proxy/internal/audit"
+)
+
+// ParseLevel parses debug, info, warn or error (case-insensitive).
+func ParseLevel(s string) (slog.Level, error) {
+	var l slog.Level
+	err := l.UnmarshalText([]byte(strings.TrimSpace(s)))
+	return l, err
+}
+
+// Level is the proxy's adjustable log level. A temporary change reverts to
+// the previous level on its own, so debug logging switched on for an
+// investigation doesn't stay on.
+type Level struct {
+	slog.LevelVar
+
+	mu     sync.Mutex
+	base   slog.Level
+	revert *time.Timer
+	until  time.Time
+}
+
+// NewLevel returns a Level set to l.
+func NewLevel(l slog.Level) *Level {
+	lv := &Level{base: l}
+	lv.Set(l)
+	return lv
+}
+
+// SetFor changes the level. If d > 0 the level reverts to the current
+// permanent level after d; otherwise the change is permanent. Either way
+// a pending revert is cancelled.
+func (lv *Level) SetFor(l slog.Level, d time.Duration) {
+	lv.mu.Lock()
+	defer lv.mu.Unlock()
+	if lv.revert != nil {
+		lv.revert.Stop()
+		lv.revert, lv.until = nil, time.Time{}
+	}
+	lv.Set(l)
+	if d <= 0 {
+		lv.base = l
+		return
+	}
+	base := lv.base
+	lv.until = time.Now().Add(d)
+	var t *time.Timer
+	t = time.AfterFunc(d, func() {
+		lv.mu.Lock()
+		defer lv.mu.Unlock()
+		if lv.revert != t {
+			return
+		}
+		lv.Set(base)
+		lv.revert, lv.until = nil, time.Time{}
+		slog.Info("log level reverted", "level", base)
+	})
+	lv.revert = t
+}
+
+type levelState struct {
+	Level string     `json:"level"`
+	Until *time.Time `json:"until,omitempty"`
+}
+
+func (lv *Level) state() levelState {
+	lv.mu.Lock()
+	defer lv.mu.Unlock()
+	st := levelState{Level: strings.ToLower(lv.Level().String())}
+	if !lv.until.IsZero() {
+		u := lv.until.UTC()
+		st.Until = &u
+	}
+	return st
+}
+
+// AdminHandler serves the log level. It expects to be mounted at
+// /admin/log-level:
+//
+//	GET /admin/log-level  show the level
+//	PUT /admin/log-level  change it: {"level": "debug", "duration": "10m"}
+//
+// duration is optional; without it the change lasts until the next one or
+// a restart.
+func (lv *Level) AdminHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut, http.MethodPost:
+			var req struct {
+				Level    string `json:"level"`
+				Duration string `json:"duration"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				apierror.Write(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+				return
+			}
+			l, err := ParseLevel(req.Level)
+			if err != nil {
+				apierror.Write(w, http.StatusBadRequest, "level must be debug, info, warn or error")
+				return
+			}
+			var d time.Duration
+			if req.Duration != "" {
+				if d, err = time.ParseDuration(req.Duration); err != nil || d <= 0 {
+					apierror.Write(w, http.StatusBadRequest, "invalid duration")
+					return
+				}
+			}
+			lv.SetFor(l, d)
+			slog.InfoContext(r.Context(), "admin: log level changed", "level", l, "duration", d)
+			detail := "log level " + strings.ToLower(l.String())
+			if d > 0 {
+				detail += " for " + d.String()
+			}
+			audit.Event(audit.AdminChange, r, "", detail)
+		default:
+			apierror.Write(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(lv.state())
+	})
+}
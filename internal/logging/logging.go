@@ -0,0 +1,181 @@
+// Package logging sets up the proxy's structured logger and tags log
+// records with a per-request id.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yeti47/ollama-proxy/internal/auth"
+	"github.com/yeti47/ollama-proxy/internal/tracing"
+)
+
+// New returns a logger writing to w in the given format, "text" or
+// "json", at the given level.
+func New(w io.Writer, format string, level slog.Leveler) (*slog.Logger, error) {
+	h, err := newHandler(w, format, &slog.HandlerOptions{Level: level})
+	if err != nil {
+		return nil, err
+	}
+	return slog.New(contextHandler{h}), nil
+}
+
+func newHandler(w io.Writer, format string, opts *slog.HandlerOptions) (slog.Handler, error) {
+	switch format {
+	case "text", "":
+		return slog.NewTextHandler(w, opts), nil
+	case "json":
+		return slog.NewJSONHandler(w, opts), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q (want text or json)", format)
+	}
+}
+
+// contextHandler adds the request and trace ids from the record's
+// context.
+type contextHandler struct{ slog.Handler }
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	if id := tracing.TraceID(ctx); id != "" {
+		r.AddAttrs(slog.String("trace_id", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// RequestIDHeader carries the request id to and from clients.
+const RequestIDHeader = "X-Request-Id"
+
+type ctxKey struct{}
+
+// RequestID returns the request id stored in ctx, or "".
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(ctxKey{}).(string)
+	return id
+}
+
+// validID accepts ids a client or load balancer may reasonably send;
+// anything else is replaced so ids can't be used to inject log content.
+func validID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("-_.:", c)) {
+			return false
+		}
+	}
+	return true
+}
+
+// RequestIDMiddleware assigns each request an id, reusing a well-formed
+// X-Request-Id from the client, stores it in the request context and
+// echoes it in the response.
+func RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validID(id) {
+			var b [8]byte
+			_, _ = rand.Read(b[:])
+			id = hex.EncodeToString(b[:])
+		}
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ctxKey{}, id)))
+	})
+}
+
+// Requests logs one line per completed request with its method, path,
+// status, duration, upstream and, for authenticated requests, the client
+// and tenant. Handlers further in can add fields with AddFields.
+func Requests(upstream string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		extra := &fields{}
+		next.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), fieldsKey{}, extra)))
+		attrs := []any{
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", sw.status,
+			"duration", time.Since(start),
+			"upstream", upstream,
+			"remote", r.RemoteAddr,
+		}
+		if id := auth.FromContext(r.Context()); id != nil {
+			attrs = append(attrs, "client", id.Name, "tenant", id.Tenant)
+			if e, ok := r.Context().Value(accessKey{}).(*accessEntry); ok {
+				e.user = id.Name
+			}
+		}
+		extra.mu.Lock()
+		attrs = append(attrs, extra.args...)
+		extra.mu.Unlock()
+		slog.InfoContext(r.Context(), "request", attrs...)
+	})
+}
+
+type fieldsKey struct{}
+
+type fields struct {
+	mu   sync.Mutex
+	args []any
+}
+
+// AddFields adds key/value pairs to the request log record of the request
+// ctx belongs to. It does nothing outside Requests.
+func AddFields(ctx context.Context, args ...any) {
+	if f, ok := ctx.Value(fieldsKey{}).(*fields); ok {
+		f.mu.Lock()
+		f.args = append(f.args, args...)
+		f.mu.Unlock()
+	}
+}
+
+// statusWriter records the response status and size. It passes Flush
+// through so streamed responses keep streaming.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	wrote  bool
+	bytes  int64
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if !w.wrote {
+		w.status, w.wrote = code, true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.wrote = true
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
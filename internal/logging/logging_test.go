@@ -0,0 +1,201 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yeti47/ollama-proxy/internal/auth"
+	"github.com/yeti47/ollama-proxy/internal/tracing"
+)
+
+func TestRequests(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, "json", slog.LevelInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := slog.Default()
+	slog.SetDefault(logger)
+	defer slog.SetDefault(prev)
+
+	h := RequestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = r.WithContext(auth.WithIdentity(r.Context(), &auth.Identity{Name: "alice", Tenant: "acme"}))
+		Requests("upstream.example", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTeapot)
+		})).ServeHTTP(w, r)
+	}))
+
+	req := httptest.NewRequest("POST", "/api/chat", nil)
+	req.Header.Set(RequestIDHeader, "abc-123")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if got := rec.Header().Get(RequestIDHeader); got != "abc-123" {
+		t.Errorf("response request id %q, want abc-123", got)
+	}
+	var rec1 map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &rec1); err != nil {
+		t.Fatalf("log output %q: %v", buf.String(), err)
+	}
+	want := map[string]any{
+		"msg": "request", "method": "POST", "path": "/api/chat", "status": float64(418),
+		"upstream": "upstream.example", "client": "alice", "tenant": "acme", "request_id": "abc-123",
+	}
+	for k, v := range want {
+		if rec1[k] != v {
+			t.Errorf("%s = %v, want %v", k, rec1[k], v)
+		}
+	}
+	if _, ok := rec1["duration"]; !ok {
+		t.Error("duration missing")
+	}
+}
+
+func TestRequestIDReplacesInvalid(t *testing.T) {
+	var seen string
+	h := RequestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = RequestID(r.Context())
+	}))
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(RequestIDHeader, "bad id\nlevel=ERROR")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if seen == "" || seen == req.Header.Get(RequestIDHeader) || !validID(seen) {
+		t.Errorf("request id %q", seen)
+	}
+}
+
+func TestNewUnknownFormat(t *testing.T) {
+	if _, err := New(&bytes.Buffer{}, "xml", slog.LevelInfo); err == nil {
+		t.Error("expected error for unknown format")
+	}
+}
+
+func TestLevelSetFor(t *testing.T) {
+	lv := NewLevel(slog.LevelInfo)
+	lv.SetFor(slog.LevelDebug, 20*time.Millisecond)
+	if lv.Level() != slog.LevelDebug {
+		t.Fatalf("level %v, want DEBUG", lv.Level())
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for lv.Level() != slog.LevelInfo && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if lv.Level() != slog.LevelInfo {
+		t.Fatalf("level %v after revert, want INFO", lv.Level())
+	}
+
+	lv.SetFor(slog.LevelDebug, time.Hour)
+	lv.SetFor(slog.LevelWarn, 0)
+	if lv.Level() != slog.LevelWarn || lv.state().Until != nil {
+		t.Errorf("permanent change: %+v", lv.state())
+	}
+}
+
+func TestLevelAdminHandler(t *testing.T) {
+	lv := NewLevel(slog.LevelInfo)
+	h := lv.AdminHandler()
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("PUT", "/admin/log-level", strings.NewReader(`{"level":"debug","duration":"5m"}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("PUT status %d: %s", rec.Code, rec.Body)
+	}
+	var st levelState
+	if err := json.Unmarshal(rec.Body.Bytes(), &st); err != nil || st.Level != "debug" || st.Until == nil {
+		t.Errorf("PUT response %s (%v)", rec.Body, err)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("PUT", "/admin/log-level", strings.NewReader(`{"level":"loud"}`)))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid level: status %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/admin/log-level", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"level":"debug"`) {
+		t.Errorf("GET: %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	al := NewAccessLog(&buf, true, nil)
+	h := al.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = r.WithContext(auth.WithIdentity(r.Context(), &auth.Identity{Name: "alice"}))
+		Requests("upstream.example", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("hello"))
+		})).ServeHTTP(w, r)
+	}))
+
+	req := httptest.NewRequest("GET", "/api/tags?x=1", nil)
+	req.RemoteAddr = "10.0.0.8:51234"
+	req.Header.Set("User-Agent", `evil"agent`)
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	line := buf.String()
+	for _, want := range []string{
+		`10.0.0.8 - alice [`,
+		`] "GET /api/tags?x=1 HTTP/1.1" 200 5 "-" "evil\"agent" `,
+	} {
+		if !strings.Contains(line, want) {
+			t.Errorf("line %q does not contain %q", line, want)
+		}
+	}
+	if !strings.HasSuffix(line, "\n") || strings.Count(line, "\n") != 1 {
+		t.Errorf("want a single line, got %q", line)
+	}
+
+	buf.Reset()
+	al.Combined = false
+	h = al.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if line := buf.String(); !strings.Contains(line, `10.0.0.8 - - [`) || !strings.Contains(line, `" 401 - `) || strings.Contains(line, "evil") {
+		t.Errorf("common line %q", line)
+	}
+}
+
+func TestTraceIDInLogs(t *testing.T) {
+	var buf bytes.Buffer
+	logger, _ := New(&buf, "json", slog.LevelInfo)
+	ctx, _ := tracing.NewPropagator().Start(context.Background(), "x", tracing.KindServer)
+	logger.InfoContext(ctx, "hello")
+
+	var rec map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec["trace_id"] != tracing.TraceID(ctx) || rec["trace_id"] == "" {
+		t.Errorf("trace_id %v, want %s", rec["trace_id"], tracing.TraceID(ctx))
+	}
+}
+
+func TestAddFields(t *testing.T) {
+	var buf bytes.Buffer
+	logger, _ := New(&buf, "json", slog.LevelInfo)
+	prev := slog.Default()
+	slog.SetDefault(logger)
+	defer slog.SetDefault(prev)
+
+	AddFields(context.Background(), "ignored", true)
+	Requests("upstream.example", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		AddFields(r.Context(), "model", "llama3:latest", "completion_tokens", 30)
+	})).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/chat", nil))
+
+	var rec map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec["model"] != "llama3:latest" || rec["completion_tokens"] != float64(30) {
+		t.Errorf("record %v", rec)
+	}
+}
@@ -0,0 +1,198 @@
+package logging
+
+import (
+	"compress/gzip"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTime names rotated files, e.g. proxy.log.20261016T091244.123Z;
+// the names sort chronologically.
+const backupTime = "20060102T150405.000Z"
+
+// RotateOptions configures a RotatingFile.
+type RotateOptions struct {
+	// MaxSize is the size in bytes at which the file is rotated; 0 means
+	// no limit.
+	MaxSize int64
+	// MaxAge is how long a file is written to before it is rotated; 0
+	// means no limit.
+	MaxAge time.Duration
+	// MaxBackups is the number of rotated files kept; 0 keeps all.
+	MaxBackups int
+	// Compress gzips rotated files.
+	Compress bool
+}
+
+// RotatingFile is a log file that is rotated once it grows past MaxSize
+// or gets older than MaxAge. Rotated files are renamed with a timestamp
+// suffix, optionally gzipped in the background, and pruned to MaxBackups.
+type RotatingFile struct {
+	path string
+	opts RotateOptions
+	now  func() time.Time
+
+	mu     sync.Mutex
+	f      *os.File
+	size   int64
+	opened time.Time
+
+	// bg serializes compression and pruning
+	bg sync.Mutex
+	wg sync.WaitGroup
+}
+
+// OpenRotating opens (or continues) the log file at path.
+func OpenRotating(path string, opts RotateOptions) (*RotatingFile, error) {
+	rf := &RotatingFile{path: path, opts: opts, now: time.Now}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *RotatingFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o640)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	rf.f, rf.size, rf.opened = f, fi.Size(), rf.now()
+	if rf.size > 0 {
+		// an existing file counts from its last rotation, approximated by
+		// its modification time
+		rf.opened = fi.ModTime()
+	}
+	return nil
+}
+
+// Write appends p, rotating first if p would take the file past MaxSize
+// or the file has reached MaxAge.
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.f == nil {
+		return 0, os.ErrClosed
+	}
+	if rf.size > 0 && (rf.opts.MaxSize > 0 && rf.size+int64(len(p)) > rf.opts.MaxSize ||
+		rf.opts.MaxAge > 0 && rf.now().Sub(rf.opened) >= rf.opts.MaxAge) {
+		if err := rf.rotate(); err != nil {
+			// keep logging to the current file rather than losing records
+			slog.New(slog.NewTextHandler(os.Stderr, nil)).Error("rotating log file", "file", rf.path, "err", err)
+		}
+	}
+	n, err := rf.f.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+// Rotate rotates the file now.
+func (rf *RotatingFile) Rotate() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return rf.rotate()
+}
+
+func (rf *RotatingFile) rotate() error {
+	backup := rf.path + "." + rf.now().UTC().Format(backupTime)
+	if err := os.Rename(rf.path, backup); err != nil {
+		return err
+	}
+	old := rf.f
+	if err := rf.open(); err != nil {
+		// put the file back so writes keep working
+		_ = os.Rename(backup, rf.path)
+		return err
+	}
+	rf.opened = rf.now()
+	old.Close()
+	rf.wg.Add(1)
+	go func() {
+		defer rf.wg.Done()
+		rf.bg.Lock()
+		defer rf.bg.Unlock()
+		if rf.opts.Compress {
+			if err := compress(backup); err != nil {
+				slog.New(slog.NewTextHandler(os.Stderr, nil)).Error("compressing log file", "file", backup, "err", err)
+			}
+		}
+		rf.prune()
+	}()
+	return nil
+}
+
+// Close waits for background compression and closes the file.
+func (rf *RotatingFile) Close() error {
+	rf.mu.Lock()
+	f := rf.f
+	rf.f = nil
+	rf.mu.Unlock()
+	rf.wg.Wait()
+	if f == nil {
+		return nil
+	}
+	return f.Close()
+}
+
+func compress(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(path+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o640)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	if _, err := io.Copy(zw, src); err != nil {
+		dst.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		dst.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+	return os.Remove(path)
+}
+
+// backups returns the rotated files of rf, oldest first.
+func (rf *RotatingFile) backups() []string {
+	matches, _ := filepath.Glob(rf.path + ".*")
+	var out []string
+	for _, m := range matches {
+		suffix := strings.TrimSuffix(strings.TrimPrefix(m, rf.path+"."), ".gz")
+		if _, err := time.Parse(backupTime, suffix); err == nil {
+			out = append(out, m)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+func (rf *RotatingFile) prune() {
+	if rf.opts.MaxBackups <= 0 {
+		return
+	}
+	b := rf.backups()
+	for len(b) > rf.opts.MaxBackups {
+		_ = os.Remove(b[0])
+		b = b[1:]
+	}
+}
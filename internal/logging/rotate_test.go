@@ -0,0 +1,85 @@
+package logging
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "proxy.log")
+	rf, err := OpenRotating(path, RotateOptions{MaxSize: 10, MaxBackups: 2, Compress: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)
+	rf.now = func() time.Time { clock = clock.Add(time.Second); return clock }
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := rf.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rf.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, _ := os.ReadFile(path)
+	if string(b) != "fourth\n" {
+		t.Errorf("current file %q, want the last line", b)
+	}
+	backups := rf.backups()
+	if len(backups) != 2 {
+		t.Fatalf("backups %v, want 2", backups)
+	}
+	for i, want := range []string{"second\n", "third\n"} {
+		if !strings.HasSuffix(backups[i], ".gz") {
+			t.Errorf("backup %s not compressed", backups[i])
+			continue
+		}
+		f, err := os.Open(backups[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := io.ReadAll(zr)
+		f.Close()
+		if string(got) != want {
+			t.Errorf("backup %d = %q, want %q", i, got, want)
+		}
+	}
+}
+
+func TestRotatingFileMaxAge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	rf, err := OpenRotating(path, RotateOptions{MaxAge: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rf.Close()
+	clock := time.Now()
+	rf.now = func() time.Time { return clock }
+
+	_, _ = rf.Write([]byte("a\n"))
+	clock = clock.Add(30 * time.Minute)
+	_, _ = rf.Write([]byte("b\n"))
+	if n := len(rf.backups()); n != 0 {
+		t.Fatalf("rotated after 30m: %d backups", n)
+	}
+	clock = clock.Add(time.Hour)
+	_, _ = rf.Write([]byte("c\n"))
+	if n := len(rf.backups()); n != 1 {
+		t.Fatalf("%d backups after an hour, want 1", n)
+	}
+	b, _ := os.ReadFile(path)
+	if string(b) != "c\n" {
+		t.Errorf("current file %q", b)
+	}
+}
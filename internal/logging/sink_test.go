@@ -0,0 +1,154 @@
+package logging
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"log/slog"
+	"net"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyslogUDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	s, err := DialSyslog("udp://"+pc.LocalAddr().String(), "local0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	logger, err := NewSyslog(s, "text", slog.LevelInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.WithValue(context.Background(), ctxKey{}, "req-1")
+	logger.WarnContext(ctx, "upstream response", "status", 502)
+
+	buf := make([]byte, 4096)
+	_ = pc.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// local0 (16) * 8 + warning (4)
+	re := regexp.MustCompile(`^<132>1 \d{4}-\d\d-\d\dT[\d:.]+Z \S+ \S+ \d+ - - level=WARN msg="upstream response" status=502 request_id=req-1$`)
+	if got := string(buf[:n]); !re.MatchString(got) {
+		t.Errorf("message %q", got)
+	}
+}
+
+func TestSyslogTCPFraming(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	s, err := DialSyslog("tcp://"+ln.Addr().String(), "daemon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	c, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if err := s.Send(slog.LevelError, time.Now(), []byte("msg=boom\n")); err != nil {
+		t.Fatal(err)
+	}
+	_ = c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	r := bufio.NewReader(c)
+	length, err := r.ReadString(' ')
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	for _, d := range strings.TrimSpace(length) {
+		n = n*10 + int(d-'0')
+	}
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(msg), "<27>1 ") || !strings.HasSuffix(string(msg), " - - msg=boom") {
+		t.Errorf("message %q", msg)
+	}
+}
+
+func TestSyslogUnknownFacility(t *testing.T) {
+	if _, err := DialSyslog("udp://127.0.0.1:514", "nope"); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestJournald(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "journal.sock")
+	ln, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: sock, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: sock, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	j := &Journald{conn: conn, ident: "ollama-proxy"}
+	defer j.Close()
+
+	logger := NewJournald(j, slog.LevelInfo).With("upstream", "ollama.com")
+	logger.Debug("not sent")
+	ctx := context.WithValue(context.Background(), ctxKey{}, "req-1")
+	logger.WithGroup("http").ErrorContext(ctx, "proxy error", "err", "line one\nline two", "status-code", 502)
+
+	buf := make([]byte, 4096)
+	_ = ln.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, err := ln.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := buf[:n]
+	for _, want := range []string{
+		"MESSAGE=proxy error\n",
+		"PRIORITY=3\n",
+		"SYSLOG_IDENTIFIER=ollama-proxy\n",
+		"UPSTREAM=ollama.com\n",
+		"HTTP_STATUS_CODE=502\n",
+		"REQUEST_ID=req-1\n",
+	} {
+		if !bytes.Contains(got, []byte(want)) {
+			t.Errorf("missing %q in %q", want, got)
+		}
+	}
+	var multi bytes.Buffer
+	multi.WriteString("HTTP_ERR\n")
+	_ = binary.Write(&multi, binary.LittleEndian, uint64(len("line one\nline two")))
+	multi.WriteString("line one\nline two\n")
+	if !bytes.Contains(got, multi.Bytes()) {
+		t.Errorf("multi-line field not binary-encoded: %q", got)
+	}
+}
+
+func TestFieldName(t *testing.T) {
+	for in, want := range map[string]string{
+		"request_id": "REQUEST_ID",
+		"_source":    "SOURCE",
+		"2fa":        "F_2FA",
+		"a.b-c":      "A_B_C",
+	} {
+		if got := fieldName(in); got != want {
+			t.Errorf("fieldName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var facilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19, "local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// severity maps a slog level to a syslog severity, which journald uses
+// as PRIORITY too.
+func severity(l slog.Level) int {
+	switch {
+	case l >= slog.LevelError:
+		return 3
+	case l >= slog.LevelWarn:
+		return 4
+	case l >= slog.LevelInfo:
+		return 6
+	default:
+		return 7
+	}
+}
+
+// Syslog sends messages to a syslog daemon or collector in RFC 5424
+// format. Stream connections use octet-counting framing (RFC 6587) and are
+// redialled after a write error.
+type Syslog struct {
+	network, addr string
+	facility      int
+	hostname      string
+	app           string
+	pid           int
+
+	mu      sync.Mutex
+	conn    net.Conn
+	stream  bool // octet-counting framing
+	newline bool // newline-terminated framing
+}
+
+// DialSyslog connects to addr, which is udp://host:port, tcp://host:port,
+// unix:///path or host:port (UDP). An empty addr uses the local syslog
+// socket. facility is a name such as daemon or local0.
+func DialSyslog(addr, facility string) (*Syslog, error) {
+	fac, ok := facilities[strings.ToLower(facility)]
+	if !ok {
+		return nil, fmt.Errorf("unknown syslog facility %q", facility)
+	}
+	host, _ := os.Hostname()
+	if host == "" {
+		host = "-"
+	}
+	s := &Syslog{facility: fac, hostname: host, app: filepath.Base(os.Args[0]), pid: os.Getpid()}
+	switch {
+	case addr == "":
+	case strings.HasPrefix(addr, "udp://"), strings.HasPrefix(addr, "tcp://"):
+		s.network, s.addr, _ = strings.Cut(addr, "://")
+	case strings.HasPrefix(addr, "unix://"):
+		s.network, s.addr = "unix", strings.TrimPrefix(addr, "unix://")
+	default:
+		s.network, s.addr = "udp", addr
+	}
+	if err := s.dial(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Syslog) dial() error {
+	if s.network == "udp" || s.network == "tcp" {
+		c, err := net.DialTimeout(s.network, s.addr, 5*time.Second)
+		if err != nil {
+			return err
+		}
+		s.conn, s.stream = c, s.network == "tcp"
+		return nil
+	}
+	paths := []string{s.addr}
+	if s.addr == "" {
+		paths = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+	}
+	for _, p := range paths {
+		for _, network := range []string{"unixgram", "unix"} {
+			if c, err := net.Dial(network, p); err == nil {
+				// local daemons read newline-terminated messages from
+				// stream sockets
+				s.conn, s.network, s.addr, s.stream = c, network, p, false
+				s.newline = network == "unix"
+				return nil
+			}
+		}
+	}
+	return errors.New("no local syslog socket found")
+}
+
+// Send writes one message with the given level and time.
+func (s *Syslog) Send(level slog.Level, t time.Time, msg []byte) error {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "<%d>1 %s %s %s %d - - ", s.facility*8+severity(level),
+		t.UTC().Format("2006-01-02T15:04:05.000000Z"), s.hostname, s.app, s.pid)
+	b.Write(bytes.TrimRight(msg, "\n"))
+	frame := b.Bytes()
+	switch {
+	case s.stream:
+		frame = append([]byte(strconv.Itoa(b.Len())+" "), frame...)
+	case s.newline:
+		frame = append(frame, '\n')
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.conn.Write(frame)
+	if err != nil {
+		// the daemon may have restarted or the collector dropped the
+		// connection
+		s.conn.Close()
+		if err = s.dial(); err == nil {
+			_, err = s.conn.Write(frame)
+		}
+	}
+	return err
+}
+
+// Close closes the connection.
+func (s *Syslog) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conn.Close()
+}
+
+// NewSyslog returns a logger sending to s. Records are formatted as text
+// or JSON, without the time, which the syslog header carries.
+func NewSyslog(s *Syslog, format string, level slog.Leveler) (*slog.Logger, error) {
+	h := &sinkHandler{
+		mu:   new(sync.Mutex),
+		buf:  new(bytes.Buffer),
+		send: s.Send,
+	}
+	var err error
+	h.Handler, err = newHandler(h.buf, format, &slog.HandlerOptions{
+		Level: level,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) == 0 && a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return slog.New(contextHandler{h}), nil
+}
+
+// sinkHandler formats records with a text or JSON handler into a buffer
+// and passes each one on to send, which frames it for the sink.
+type sinkHandler struct {
+	slog.Handler
+	mu   *sync.Mutex
+	buf  *bytes.Buffer
+	send func(level slog.Level, t time.Time, msg []byte) error
+}
+
+func (h *sinkHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.buf.Reset()
+	if err := h.Handler.Handle(ctx, r); err != nil {
+		return err
+	}
+	return h.send(r.Level, r.Time, h.buf.Bytes())
+}
+
+func (h *sinkHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := *h
+	c.Handler = h.Handler.WithAttrs(attrs)
+	return &c
+}
+
+func (h *sinkHandler) WithGroup(name string) slog.Handler {
+	c := *h
+	c.Handler = h.Handler.WithGroup(name)
+	return &c
+}
@@ -0,0 +1,329 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Registry holds a set of metrics and renders them in the Prometheus text
+// exposition format. The proxy only needs counters, gauges and histograms,
+// so this is a small stand-in for the full client library.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+type metric interface {
+	name() string
+	write(w io.Writer)
+}
+
+// Default is the registry used by the package-level constructors and
+// served by Handler.
+var Default = &Registry{}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, existing := range r.metrics {
+		if existing.name() == m.name() {
+			panic("metrics: duplicate metric " + m.name())
+		}
+	}
+	r.metrics = append(r.metrics, m)
+}
+
+// Write renders all metrics in the registry.
+func (r *Registry) Write(w io.Writer) {
+	r.mu.Lock()
+	ms := append([]metric(nil), r.metrics...)
+	r.mu.Unlock()
+	sort.Slice(ms, func(i, j int) bool { return ms[i].name() < ms[j].name() })
+	for _, m := range ms {
+		m.write(w)
+	}
+}
+
+// Handler serves the Default registry.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		Default.Write(w)
+	})
+}
+
+// vec is the shared label bookkeeping for all metric kinds.
+type vec[T any] struct {
+	metricName string
+	help       string
+	kind       string
+	labels     []string
+	newChild   func() *T
+
+	mu       sync.Mutex
+	children map[string]*T
+	values   map[string][]string
+}
+
+func newVec[T any](name, help, kind string, labels []string, newChild func() *T) *vec[T] {
+	return &vec[T]{
+		metricName: name,
+		help:       help,
+		kind:       kind,
+		labels:     labels,
+		newChild:   newChild,
+		children:   make(map[string]*T),
+		values:     make(map[string][]string),
+	}
+}
+
+func (v *vec[T]) name() string { return v.metricName }
+
+func (v *vec[T]) with(values []string) *T {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.metricName, len(v.labels), len(values)))
+	}
+	k := strings.Join(values, "\xff")
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	c, ok := v.children[k]
+	if !ok {
+		c = v.newChild()
+		v.children[k] = c
+		v.values[k] = append([]string(nil), values...)
+		if b, ok := any(c).(binder); ok {
+			b.bind(&Series{Name: v.metricName, Labels: v.labels, Values: v.values[k]})
+		}
+	}
+	return c
+}
+
+// each calls fn for every child in a stable order.
+func (v *vec[T]) each(fn func(labels string, c *T)) {
+	v.mu.Lock()
+	keys := make([]string, 0, len(v.children))
+	for k := range v.children {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	type entry struct {
+		labels string
+		c      *T
+	}
+	entries := make([]entry, len(keys))
+	for i, k := range keys {
+		entries[i] = entry{formatLabels(v.labels, v.values[k]), v.children[k]}
+	}
+	v.mu.Unlock()
+	for _, e := range entries {
+		fn(e.labels, e.c)
+	}
+}
+
+func (v *vec[T]) header(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", v.metricName, v.help, v.metricName, v.kind)
+}
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	parts := make([]string, len(names))
+	for i, n := range names {
+		parts[i] = n + "=" + strconv.Quote(values[i])
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// Counter is a monotonically increasing value.
+type Counter struct {
+	mu     sync.Mutex
+	v      float64
+	series *Series
+}
+
+// Inc adds one to the counter.
+func (c *Counter) Inc() { c.Add(1) }
+
+// Add adds d, which must not be negative, to the counter.
+func (c *Counter) Add(d float64) {
+	c.mu.Lock()
+	c.v += d
+	c.mu.Unlock()
+	if s := currentSink(c.series); s != nil {
+		s.Count(c.series, d)
+	}
+}
+
+// Value returns the current count.
+func (c *Counter) Value() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.v
+}
+
+// CounterVec is a set of counters partitioned by label values.
+type CounterVec struct{ *vec[Counter] }
+
+// NewCounter registers a counter with the given label names in Default.
+func NewCounter(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{newVec(name, help, "counter", labels, func() *Counter { return &Counter{} })}
+	Default.register(c)
+	return c
+}
+
+// With returns the counter for the given label values.
+func (c *CounterVec) With(values ...string) *Counter { return c.with(values) }
+
+func (c *CounterVec) write(w io.Writer) {
+	c.header(w)
+	c.each(func(labels string, ch *Counter) {
+		fmt.Fprintf(w, "%s%s %s\n", c.metricName, labels, formatFloat(ch.Value()))
+	})
+}
+
+// Gauge is a value that can go up and down.
+type Gauge struct {
+	mu     sync.Mutex
+	v      float64
+	series *Series
+}
+
+// Set sets the gauge to v.
+func (g *Gauge) Set(v float64) {
+	g.mu.Lock()
+	g.v = v
+	g.mu.Unlock()
+	if s := currentSink(g.series); s != nil {
+		s.Gauge(g.series, v)
+	}
+}
+
+// Add adds d to the gauge.
+func (g *Gauge) Add(d float64) {
+	g.mu.Lock()
+	g.v += d
+	v := g.v
+	g.mu.Unlock()
+	if s := currentSink(g.series); s != nil {
+		s.Gauge(g.series, v)
+	}
+}
+
+// Inc adds one to the gauge.
+func (g *Gauge) Inc() { g.Add(1) }
+
+// Dec subtracts one from the gauge.
+func (g *Gauge) Dec() { g.Add(-1) }
+
+// Value returns the current value.
+func (g *Gauge) Value() float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.v
+}
+
+// GaugeVec is a set of gauges partitioned by label values.
+type GaugeVec struct{ *vec[Gauge] }
+
+// NewGauge registers a gauge with the given label names in Default.
+func NewGauge(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{newVec(name, help, "gauge", labels, func() *Gauge { return &Gauge{} })}
+	Default.register(g)
+	return g
+}
+
+// With returns the gauge for the given label values.
+func (g *GaugeVec) With(values ...string) *Gauge { return g.with(values) }
+
+func (g *GaugeVec) write(w io.Writer) {
+	g.header(w)
+	g.each(func(labels string, ch *Gauge) {
+		fmt.Fprintf(w, "%s%s %s\n", g.metricName, labels, formatFloat(ch.Value()))
+	})
+}
+
+// DefaultBuckets are latency buckets in seconds suited to LLM requests,
+// which range from milliseconds for metadata calls to minutes for long
+// generations.
+var DefaultBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+// Histogram samples observations into cumulative buckets.
+type Histogram struct {
+	mu      sync.Mutex
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+	series  *Series
+}
+
+// Observe records v.
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	for i, b := range h.buckets {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+	h.mu.Unlock()
+	if s := currentSink(h.series); s != nil {
+		s.Observe(h.series, v)
+	}
+}
+
+// HistogramVec is a set of histograms partitioned by label values.
+type HistogramVec struct{ *vec[Histogram] }
+
+// NewHistogram registers a histogram with the given buckets and label names
+// in Default. A nil buckets slice uses DefaultBuckets.
+func NewHistogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	h := &HistogramVec{newVec(name, help, "histogram", labels, func() *Histogram {
+		return &Histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+	})}
+	Default.register(h)
+	return h
+}
+
+// With returns the histogram for the given label values.
+func (h *HistogramVec) With(values ...string) *Histogram { return h.with(values) }
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.header(w)
+	h.each(func(labels string, ch *Histogram) {
+		ch.mu.Lock()
+		defer ch.mu.Unlock()
+		for i, b := range ch.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, withLE(labels, formatFloat(b)), ch.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, withLE(labels, "+Inf"), ch.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.metricName, labels, formatFloat(ch.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.metricName, labels, ch.count)
+	})
+}
+
+// withLE adds the le label to an already formatted label set.
+func withLE(labels, le string) string {
+	if labels == "" {
+		return `{le="` + le + `"}`
+	}
+	return labels[:len(labels)-1] + `,le="` + le + `"}`
+}
@@ -0,0 +1,42 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestExposition(t *testing.T) {
+	r := &Registry{}
+	c := &CounterVec{newVec("test_requests_total", "Requests.", "counter", []string{"code"}, func() *Counter { return &Counter{} })}
+	h := &HistogramVec{newVec("test_duration_seconds", "Duration.", "histogram", nil, func() *Histogram {
+		return &Histogram{buckets: []float64{0.1, 1}, counts: make([]uint64, 2)}
+	})}
+	r.register(c)
+	r.register(h)
+
+	c.With("200").Inc()
+	c.With("200").Inc()
+	c.With("502").Add(3)
+	h.With().Observe(0.5)
+	h.With().Observe(2)
+
+	var buf bytes.Buffer
+	r.Write(&buf)
+	out := buf.String()
+
+	for _, want := range []string{
+		"# TYPE test_requests_total counter\n",
+		`test_requests_total{code="200"} 2` + "\n",
+		`test_requests_total{code="502"} 3` + "\n",
+		`test_duration_seconds_bucket{le="0.1"} 0` + "\n",
+		`test_duration_seconds_bucket{le="1"} 1` + "\n",
+		`test_duration_seconds_bucket{le="+Inf"} 2` + "\n",
+		"test_duration_seconds_sum 2.5\n",
+		"test_duration_seconds_count 2\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("missing %q in output:\n%s", want, out)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package metrics
+
+import "sync/atomic"
+
+// Series identifies one child of a metric: the metric name and its label
+// names and values.
+type Series struct {
+	Name   string
+	Labels []string
+	Values []string
+}
+
+// Sink receives every update to the Default registry's metrics as it
+// happens, for push-based exporters such as StatsD. Implementations must
+// not block.
+type Sink interface {
+	// Count is called with each increment of a counter.
+	Count(s *Series, delta float64)
+	// Gauge is called with a gauge's new value.
+	Gauge(s *Series, v float64)
+	// Observe is called with each histogram observation.
+	Observe(s *Series, v float64)
+}
+
+type sinkHolder struct{ Sink }
+
+var sink atomic.Pointer[sinkHolder]
+
+// SetSink makes s receive all metric updates from now on, in addition to
+// them being served on /metrics. A nil s stops pushing.
+func SetSink(s Sink) {
+	if s == nil {
+		sink.Store(nil)
+		return
+	}
+	sink.Store(&sinkHolder{s})
+}
+
+func currentSink(s *Series) Sink {
+	if s == nil {
+		return nil
+	}
+	if h := sink.Load(); h != nil {
+		return h.Sink
+	}
+	return nil
+}
+
+// binder is implemented by the child types so vec can tell them which
+// series they are.
+type binder interface{ bind(*Series) }
+
+func (c *Counter) bind(s *Series)   { c.series = s }
+func (g *Gauge) bind(s *Series)     { g.series = s }
+func (h *Histogram) bind(s *Series) { h.series = s }
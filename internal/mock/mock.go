@@ -0,0 +1,315 @@
+// Package mock answers the Ollama API with canned responses instead of
+// an upstream, for client development and CI runs that shouldn't need a
+// model or network access. Generations are streamed word by word with a
+// configurable delay, so clients see realistic chunking.
+package mock
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/yeti47/ollama-proxy/internal/apierror"
+	"github.com/yeti47/ollama-proxy/internal/ollama"
+)
+
+// DefaultResponse is the answer when no fixture matches.
+const DefaultResponse = "This is a mock response from ollama-proxy."
+
+// Version is what /api/version reports.
+const Version = "0.0.0-mock"
+
+// Fixture is one canned answer. The first fixture whose Model and Match
+// fit a request answers it.
+type Fixture struct {
+	// Model restricts the fixture to a model; empty matches any.
+	Model string `json:"model,omitempty"`
+	// Match restricts the fixture to requests whose prompt or last user
+	// message contains it, ignoring case; empty matches any.
+	Match     string          `json:"match,omitempty"`
+	Response  string          `json:"response,omitempty"`
+	Thinking  string          `json:"thinking,omitempty"`
+	ToolCalls json.RawMessage `json:"tool_calls,omitempty"`
+	// Status and Error answer with an Ollama error instead.
+	Status int    `json:"status,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Fixtures is the format of the fixtures file.
+type Fixtures struct {
+	// Models are listed by /api/tags; by default the models the
+	// responses name, or just "mock".
+	Models    []string  `json:"models,omitempty"`
+	Responses []Fixture `json:"responses,omitempty"`
+}
+
+// Load reads a fixtures file.
+func Load(path string) (*Fixtures, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f Fixtures
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for i, r := range f.Responses {
+		if r.Error != "" && r.Status == 0 {
+			f.Responses[i].Status = http.StatusInternalServerError
+		}
+	}
+	return &f, nil
+}
+
+// Server is the mock upstream.
+type Server struct {
+	Fixtures Fixtures
+	// ChunkDelay is the pause before each streamed chunk.
+	ChunkDelay time.Duration
+	// OnUsage is called with the made-up token counts of each generation,
+	// as the proxy does for real ones.
+	OnUsage func(*http.Request, ollama.Usage)
+}
+
+// request holds the fields of /api/chat and /api/generate requests the
+// mock looks at.
+type request struct {
+	Model    string `json:"model"`
+	Prompt   string `json:"prompt"`
+	System   string `json:"system"`
+	Messages []struct {
+		Role    string `json:"role"`
+		Content string `json:"content"`
+	} `json:"messages"`
+	Stream *bool `json:"stream"`
+	Think  *bool `json:"think"`
+}
+
+// input is the text a fixture's Match is looked for in.
+func (q *request) input(chat bool) string {
+	if !chat {
+		return q.Prompt
+	}
+	for i := len(q.Messages) - 1; i >= 0; i-- {
+		if q.Messages[i].Role == "user" {
+			return q.Messages[i].Content
+		}
+	}
+	return ""
+}
+
+// promptTokens approximates the prompt's token count by its words.
+func (q *request) promptTokens() int {
+	n := len(strings.Fields(q.Prompt)) + len(strings.Fields(q.System))
+	for _, m := range q.Messages {
+		n += len(strings.Fields(m.Content))
+	}
+	return n
+}
+
+// ServeHTTP answers the Ollama API.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/", "":
+		_, _ = io.WriteString(w, "Ollama is running")
+	case "/api/version":
+		writeJSON(w, map[string]string{"version": Version})
+	case "/api/tags":
+		s.tags(w)
+	case "/api/ps":
+		writeJSON(w, map[string]any{"models": []any{}})
+	case "/api/chat":
+		s.generate(w, r, true)
+	case "/api/generate":
+		s.generate(w, r, false)
+	default:
+		apierror.Write(w, http.StatusNotImplemented, r.URL.Path+" is not supported in mock mode")
+	}
+}
+
+func (s *Server) tags(w http.ResponseWriter) {
+	names := s.Fixtures.Models
+	if len(names) == 0 {
+		seen := map[string]bool{}
+		for _, f := range s.Fixtures.Responses {
+			if f.Model != "" && !seen[f.Model] {
+				seen[f.Model] = true
+				names = append(names, f.Model)
+			}
+		}
+	}
+	if len(names) == 0 {
+		names = []string{"mock"}
+	}
+	models := []map[string]any{}
+	for _, m := range names {
+		name := ollama.NormalizeModel(m)
+		models = append(models, map[string]any{
+			"name":        name,
+			"model":       name,
+			"modified_at": time.Time{}.Format(time.RFC3339),
+			"size":        0,
+			"digest":      "",
+			"details":     map[string]any{"format": "mock"},
+		})
+	}
+	writeJSON(w, map[string]any{"models": models})
+}
+
+// match returns the first fixture for q.
+func (s *Server) match(q *request, chat bool) Fixture {
+	in := strings.ToLower(q.input(chat))
+	model := ollama.NormalizeModel(q.Model)
+	for _, f := range s.Fixtures.Responses {
+		if f.Model != "" && ollama.NormalizeModel(f.Model) != model {
+			continue
+		}
+		if f.Match != "" && !strings.Contains(in, strings.ToLower(f.Match)) {
+			continue
+		}
+		return f
+	}
+	return Fixture{Response: DefaultResponse}
+}
+
+// words splits text into streamed chunks, each word with the space
+// after it.
+var words = regexp.MustCompile(`\s*\S+\s*`)
+
+func (s *Server) generate(w http.ResponseWriter, r *http.Request, chat bool) {
+	if r.Method != http.MethodPost {
+		apierror.Write(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	var q request
+	if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+		apierror.Write(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	if q.Model == "" {
+		apierror.Write(w, http.StatusBadRequest, "model is required")
+		return
+	}
+	f := s.match(&q, chat)
+	if f.Error != "" {
+		apierror.Write(w, f.Status, f.Error)
+		return
+	}
+	start := time.Now()
+	thinking := f.Thinking
+	if q.Think != nil && !*q.Think {
+		thinking = ""
+	}
+	var thinkParts, parts []string
+	if thinking != "" {
+		thinkParts = words.FindAllString(thinking, -1)
+	}
+	parts = words.FindAllString(f.Response, -1)
+	usage := ollama.Usage{
+		Model:            q.Model,
+		PromptTokens:     q.promptTokens(),
+		CompletionTokens: len(thinkParts) + len(parts),
+	}
+
+	chunk := func(text, thought string, toolCalls json.RawMessage) map[string]any {
+		c := map[string]any{"model": q.Model, "created_at": now(), "done": false}
+		if chat {
+			msg := map[string]any{"role": "assistant", "content": text}
+			if thought != "" {
+				msg["thinking"] = thought
+			}
+			if len(toolCalls) > 0 {
+				msg["tool_calls"] = toolCalls
+			}
+			c["message"] = msg
+		} else {
+			c["response"] = text
+			if thought != "" {
+				c["thinking"] = thought
+			}
+		}
+		return c
+	}
+	done := func(c map[string]any) map[string]any {
+		elapsed := time.Since(start)
+		usage.TotalDuration, usage.EvalDuration = elapsed, elapsed
+		c["done"] = true
+		c["done_reason"] = "stop"
+		c["total_duration"] = elapsed.Nanoseconds()
+		c["load_duration"] = 0
+		c["prompt_eval_count"] = usage.PromptTokens
+		c["prompt_eval_duration"] = 0
+		c["eval_count"] = usage.CompletionTokens
+		c["eval_duration"] = elapsed.Nanoseconds()
+		if s.OnUsage != nil {
+			s.OnUsage(r, usage)
+		}
+		return c
+	}
+
+	if q.Stream != nil && !*q.Stream {
+		if s.ChunkDelay > 0 {
+			if !s.sleep(r, time.Duration(len(thinkParts)+len(parts))*s.ChunkDelay) {
+				return
+			}
+		}
+		writeJSON(w, done(chunk(f.Response, thinking, f.ToolCalls)))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	send := func(c map[string]any) bool {
+		if s.ChunkDelay > 0 && !s.sleep(r, s.ChunkDelay) {
+			return false
+		}
+		if enc.Encode(c) != nil {
+			return false
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return true
+	}
+	for _, t := range thinkParts {
+		if !send(chunk("", t, nil)) {
+			return
+		}
+	}
+	for _, t := range parts {
+		if !send(chunk(t, "", nil)) {
+			return
+		}
+	}
+	// like Ollama, tool calls arrive in a chunk of their own
+	if len(f.ToolCalls) > 0 && !send(chunk("", "", f.ToolCalls)) {
+		return
+	}
+	send(done(chunk("", "", nil)))
+}
+
+// sleep waits d, reporting false if the client went away first.
+func (s *Server) sleep(r *http.Request, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-r.Context().Done():
+		return false
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	b, _ := json.Marshal(v)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_, _ = w.Write(b)
+}
+
+func now() string { return time.Now().UTC().Format(time.RFC3339Nano) }
@@ -0,0 +1,126 @@
+package mock
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yeti47/ollama-proxy/internal/ollama"
+)
+
+func post(s *Server, path, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+	return w
+}
+
+func TestChatStream(t *testing.T) {
+	var usage ollama.Usage
+	s := &Server{
+		Fixtures: Fixtures{Responses: []Fixture{
+			{Match: "weather", Response: "It is sunny.", ToolCalls: json.RawMessage(`[{"function":{"name":"get_weather","arguments":{"city":"Oslo"}}}]`)},
+			{Response: "Hello there friend"},
+		}},
+		OnUsage: func(_ *http.Request, u ollama.Usage) { usage = u },
+	}
+	w := post(s, "/api/chat", `{"model":"m","messages":[{"role":"user","content":"What's the WEATHER like?"}]}`)
+	if ct := w.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Fatalf("content type %q", ct)
+	}
+	var text string
+	var chunks []map[string]any
+	sc := bufio.NewScanner(w.Body)
+	for sc.Scan() {
+		var c map[string]any
+		if err := json.Unmarshal(sc.Bytes(), &c); err != nil {
+			t.Fatal(err)
+		}
+		chunks = append(chunks, c)
+		text += c["message"].(map[string]any)["content"].(string)
+	}
+	if text != "It is sunny." {
+		t.Errorf("text = %q", text)
+	}
+	// three words, the tool calls and the done chunk
+	if len(chunks) != 5 {
+		t.Fatalf("got %d chunks", len(chunks))
+	}
+	if _, ok := chunks[3]["message"].(map[string]any)["tool_calls"]; !ok {
+		t.Errorf("tool calls missing: %v", chunks[3])
+	}
+	last := chunks[4]
+	if last["done"] != true || last["eval_count"] != float64(3) || last["prompt_eval_count"] != float64(4) {
+		t.Errorf("done chunk = %v", last)
+	}
+	if usage.PromptTokens != 4 || usage.CompletionTokens != 3 || usage.Model != "m" {
+		t.Errorf("usage = %+v", usage)
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	s := &Server{Fixtures: Fixtures{Responses: []Fixture{
+		{Model: "other", Response: "wrong"},
+		{Model: "llama3", Thinking: "Hmm.", Response: "42"},
+	}}}
+	w := post(s, "/api/generate", `{"model":"llama3:latest","prompt":"answer?","stream":false}`)
+	var got map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["response"] != "42" || got["thinking"] != "Hmm." || got["done"] != true {
+		t.Errorf("response = %v", got)
+	}
+
+	w = post(s, "/api/generate", `{"model":"llama3","prompt":"x","stream":false,"think":false}`)
+	if strings.Contains(w.Body.String(), "thinking") {
+		t.Errorf("thinking kept with think false: %s", w.Body)
+	}
+	w = post(s, "/api/generate", `{"model":"unknown","prompt":"x","stream":false}`)
+	if !strings.Contains(w.Body.String(), DefaultResponse) {
+		t.Errorf("default response missing: %s", w.Body)
+	}
+}
+
+func TestErrorFixture(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fixtures.json")
+	os.WriteFile(path, []byte(`{"responses":[{"model":"gone","error":"model \"gone\" not found"}]}`), 0o600)
+	f, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{Fixtures: *f}
+	w := post(s, "/api/chat", `{"model":"gone","messages":[]}`)
+	if w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), `not found`) {
+		t.Errorf("got %d %s", w.Code, w.Body)
+	}
+}
+
+func TestTags(t *testing.T) {
+	s := &Server{Fixtures: Fixtures{Responses: []Fixture{{Model: "a"}, {Model: "b:7b"}, {Model: "a"}}}}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/tags", nil))
+	var got struct{ Models []struct{ Name string } }
+	json.Unmarshal(w.Body.Bytes(), &got)
+	if len(got.Models) != 2 || got.Models[0].Name != "a:latest" || got.Models[1].Name != "b:7b" {
+		t.Errorf("models = %+v", got.Models)
+	}
+}
+
+func TestChunkDelayCancel(t *testing.T) {
+	s := &Server{ChunkDelay: time.Hour}
+	r := httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(`{"model":"m","prompt":"x"}`))
+	ctx, cancel := context.WithCancel(r.Context())
+	cancel()
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r.WithContext(ctx))
+	if w.Body.Len() != 0 {
+		t.Errorf("wrote %q after the client left", w.Body)
+	}
+}
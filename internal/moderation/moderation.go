@@ -0,0 +1,270 @@
+// Package moderation checks prompts against a moderation model before they
+// are forwarded to the upstream.
+package moderation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yeti47/ollama-proxy/internal/apierror"
+	"github.com/yeti47/ollama-proxy/internal/audit"
+	"github.com/yeti47/ollama-proxy/internal/auth"
+	"github.com/yeti47/ollama-proxy/internal/metrics"
+	"github.com/yeti47/ollama-proxy/internal/ollama"
+)
+
+// ResultHeader is set on forwarded requests' responses when a flagged
+// prompt was let through in flag mode.
+const ResultHeader = "X-Proxy-Moderation"
+
+var checks = metrics.NewCounter("ollama_proxy_moderation_total",
+	"Moderation checks by result (allowed, blocked, flagged, error).", "result")
+
+// Verdict is a moderation result.
+type Verdict struct {
+	Flagged    bool
+	Categories []string
+}
+
+// Checker classifies a prompt.
+type Checker interface {
+	Check(ctx context.Context, text string) (Verdict, error)
+}
+
+// OllamaChecker uses a safety classifier served by Ollama, such as
+// llama-guard3, which answers "safe" or "unsafe" followed by the violated
+// category codes.
+type OllamaChecker struct {
+	URL    string
+	Model  string
+	Client *http.Client
+}
+
+// Check implements Checker.
+func (o *OllamaChecker) Check(ctx context.Context, text string) (Verdict, error) {
+	body, _ := json.Marshal(map[string]any{
+		"model":    o.Model,
+		"stream":   false,
+		"messages": []map[string]string{{"role": "user", "content": text}},
+	})
+	var out struct {
+		Message struct {
+			Content string `json:"content"`
+		} `json:"message"`
+	}
+	if err := postJSON(ctx, o.Client, strings.TrimRight(o.URL, "/")+"/api/chat", "", body, &out); err != nil {
+		return Verdict{}, err
+	}
+	answer := strings.TrimSpace(out.Message.Content)
+	first, rest, _ := strings.Cut(answer, "\n")
+	switch strings.ToLower(strings.TrimSpace(first)) {
+	case "safe":
+		return Verdict{}, nil
+	case "unsafe":
+		v := Verdict{Flagged: true}
+		for _, c := range strings.FieldsFunc(rest, func(r rune) bool { return r == ',' || r == '\n' || r == ' ' }) {
+			v.Categories = append(v.Categories, c)
+		}
+		return v, nil
+	}
+	return Verdict{}, fmt.Errorf("unexpected moderation answer %q", answer)
+}
+
+// APIChecker uses an OpenAI-compatible /v1/moderations endpoint.
+type APIChecker struct {
+	URL    string
+	APIKey string
+	Model  string
+	Client *http.Client
+}
+
+// Check implements Checker.
+func (a *APIChecker) Check(ctx context.Context, text string) (Verdict, error) {
+	req := map[string]any{"input": text}
+	if a.Model != "" {
+		req["model"] = a.Model
+	}
+	body, _ := json.Marshal(req)
+	var out struct {
+		Results []struct {
+			Flagged    bool            `json:"flagged"`
+			Categories map[string]bool `json:"categories"`
+		} `json:"results"`
+	}
+	if err := postJSON(ctx, a.Client, a.URL, a.APIKey, body, &out); err != nil {
+		return Verdict{}, err
+	}
+	if len(out.Results) == 0 {
+		return Verdict{}, errors.New("moderation response has no results")
+	}
+	v := Verdict{Flagged: out.Results[0].Flagged}
+	for c, hit := range out.Results[0].Categories {
+		if hit {
+			v.Categories = append(v.Categories, c)
+		}
+	}
+	sort.Strings(v.Categories)
+	return v, nil
+}
+
+func postJSON(ctx context.Context, c *http.Client, url, key string, body []byte, out any) error {
+	if c == nil {
+		c = http.DefaultClient
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("moderation endpoint: status %d: %s", resp.StatusCode, bytes.TrimSpace(b))
+	}
+	return json.Unmarshal(b, out)
+}
+
+// PromptText collects the user-supplied text from an Ollama or OpenAI
+// style request body: prompt, system, and message contents.
+func PromptText(body []byte) string {
+	var req struct {
+		Prompt   json.RawMessage `json:"prompt"`
+		System   string          `json:"system"`
+		Input    json.RawMessage `json:"input"`
+		Messages []struct {
+			Content json.RawMessage `json:"content"`
+		} `json:"messages"`
+	}
+	if json.Unmarshal(body, &req) != nil {
+		return ""
+	}
+	var parts []string
+	add := func(raw json.RawMessage) {
+		var s string
+		if json.Unmarshal(raw, &s) == nil {
+			parts = append(parts, s)
+			return
+		}
+		// OpenAI content parts or prompt arrays
+		var list []json.RawMessage
+		if json.Unmarshal(raw, &list) != nil {
+			return
+		}
+		for _, item := range list {
+			var p struct {
+				Type string `json:"type"`
+				Text string `json:"text"`
+			}
+			if json.Unmarshal(item, &s) == nil {
+				parts = append(parts, s)
+			} else if json.Unmarshal(item, &p) == nil && p.Text != "" {
+				parts = append(parts, p.Text)
+			}
+		}
+	}
+	if req.System != "" {
+		parts = append(parts, req.System)
+	}
+	add(req.Prompt)
+	add(req.Input)
+	for _, m := range req.Messages {
+		add(m.Content)
+	}
+	return strings.TrimSpace(strings.Join(parts, "\n\n"))
+}
+
+// Moderator runs prompts through a Checker before forwarding.
+type Moderator struct {
+	Checker Checker
+	// Flag lets flagged prompts through with a ResultHeader on the
+	// response instead of refusing them.
+	Flag bool
+	// FailOpen forwards requests when the checker is unavailable. By
+	// default they are refused.
+	FailOpen bool
+	Timeout  time.Duration
+}
+
+// refusal is the body sent for blocked prompts.
+type refusal struct {
+	Error      string   `json:"error"`
+	Reason     string   `json:"reason"`
+	Categories []string `json:"categories,omitempty"`
+}
+
+// Middleware checks the prompt in each request body.
+func (m *Moderator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			next.ServeHTTP(w, r)
+			return
+		}
+		body, err := ollama.PeekBody(r)
+		if err != nil {
+			apierror.BodyError(w, err)
+			return
+		}
+		text := PromptText(body)
+		if text == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), m.Timeout)
+		v, err := m.Checker.Check(ctx, text)
+		cancel()
+		if err != nil {
+			checks.With("error").Inc()
+			slog.ErrorContext(r.Context(), "moderation check failed", "method", r.Method, "path", r.URL.Path, "err", err)
+			if m.FailOpen {
+				next.ServeHTTP(w, r)
+				return
+			}
+			apierror.Write(w, http.StatusServiceUnavailable, "content moderation unavailable")
+			return
+		}
+		if !v.Flagged {
+			checks.With("allowed").Inc()
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cats := strings.Join(v.Categories, ",")
+		if m.Flag {
+			checks.With("flagged").Inc()
+			slog.InfoContext(r.Context(), "moderation flagged", "method", r.Method, "path", r.URL.Path, "categories", cats)
+			w.Header().Set(ResultHeader, "flagged; categories="+cats)
+			next.ServeHTTP(w, r)
+			return
+		}
+		checks.With("blocked").Inc()
+		slog.WarnContext(r.Context(), "moderation blocked", "method", r.Method, "path", r.URL.Path, "categories", cats)
+		audit.Event(audit.Blocked, r, auth.ClientName(r.Context()), "content policy: "+cats)
+		b, _ := json.Marshal(refusal{
+			Error:      "request blocked by content policy",
+			Reason:     "content_policy",
+			Categories: v.Categories,
+		})
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+		w.WriteHeader(http.StatusForbidden)
+		w.Write(b)
+	})
+}
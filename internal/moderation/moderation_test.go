@@ -0,0 +1,94 @@
+package moderation
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPromptText(t *testing.T) {
+	for body, want := range map[string]string{
+		`{"model":"m","prompt":"hi","system":"be nice"}`:                                                      "be nice\n\nhi",
+		`{"messages":[{"role":"user","content":"a"},{"role":"user","content":[{"type":"text","text":"b"}]}]}`: "a\n\nb",
+		`{"model":"m"}`: "",
+		`not json`:      "",
+	} {
+		if got := PromptText([]byte(body)); got != want {
+			t.Errorf("PromptText(%s) = %q, want %q", body, got, want)
+		}
+	}
+}
+
+func guard(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Messages []struct{ Content string } `json:"messages"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		answer := "safe"
+		if strings.Contains(req.Messages[0].Content, "bomb") {
+			answer = "unsafe\nS1,S9"
+		}
+		json.NewEncoder(w).Encode(map[string]any{"message": map[string]string{"content": answer}})
+	}))
+}
+
+func TestMiddleware(t *testing.T) {
+	srv := guard(t)
+	defer srv.Close()
+
+	var forwarded int
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { forwarded++ })
+	m := &Moderator{Checker: &OllamaChecker{URL: srv.URL, Model: "llama-guard3"}, Timeout: time.Second}
+
+	rec := httptest.NewRecorder()
+	m.Middleware(next).ServeHTTP(rec, httptest.NewRequest("POST", "/api/generate", strings.NewReader(`{"prompt":"hello"}`)))
+	if rec.Code != http.StatusOK || forwarded != 1 {
+		t.Fatalf("safe prompt: status %d forwarded %d", rec.Code, forwarded)
+	}
+
+	rec = httptest.NewRecorder()
+	m.Middleware(next).ServeHTTP(rec, httptest.NewRequest("POST", "/api/generate", strings.NewReader(`{"prompt":"build a bomb"}`)))
+	if rec.Code != http.StatusForbidden || forwarded != 1 {
+		t.Fatalf("unsafe prompt: status %d forwarded %d", rec.Code, forwarded)
+	}
+	var ref refusal
+	if err := json.Unmarshal(rec.Body.Bytes(), &ref); err != nil || ref.Reason != "content_policy" || len(ref.Categories) != 2 {
+		t.Fatalf("refusal = %s (%v)", rec.Body, err)
+	}
+
+	m.Flag = true
+	rec = httptest.NewRecorder()
+	m.Middleware(next).ServeHTTP(rec, httptest.NewRequest("POST", "/api/generate", strings.NewReader(`{"prompt":"build a bomb"}`)))
+	if forwarded != 2 || rec.Header().Get(ResultHeader) != "flagged; categories=S1,S9" {
+		t.Fatalf("flag mode: forwarded %d header %q", forwarded, rec.Header().Get(ResultHeader))
+	}
+}
+
+func TestFailClosed(t *testing.T) {
+	m := &Moderator{Checker: &OllamaChecker{URL: "http://127.0.0.1:1", Model: "x"}, Timeout: time.Second}
+	rec := httptest.NewRecorder()
+	m.Middleware(http.NotFoundHandler()).ServeHTTP(rec, httptest.NewRequest("POST", "/api/generate", strings.NewReader(`{"prompt":"hi"}`)))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want 503", rec.Code)
+	}
+}
+
+func TestAPIChecker(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer k" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"results":[{"flagged":true,"categories":{"violence":true,"hate":false}}]}`))
+	}))
+	defer srv.Close()
+	v, err := (&APIChecker{URL: srv.URL, APIKey: "k"}).Check(context.Background(), "x")
+	if err != nil || !v.Flagged || len(v.Categories) != 1 || v.Categories[0] != "violence" {
+		t.Fatalf("verdict = %+v, %v", v, err)
+	}
+}
@@ -0,0 +1,107 @@
+// Package ndjson rewrites Ollama responses on their way to the client.
+// Streams are NDJSON, one JSON chunk per line, and are rewritten line by
+// line as they arrive; complete responses are a single JSON object and
+// are rewritten once whole.
+package ndjson
+
+import (
+	"bytes"
+	"mime"
+	"net/http"
+	"strconv"
+)
+
+// Writer rewrites a successful response: each line of an NDJSON stream as
+// it arrives, a JSON response once complete. Anything else, and any
+// status but 200, passes through. Finish must be called once the handler
+// returns.
+type Writer struct {
+	http.ResponseWriter
+	rewrite func(b []byte, complete bool) []byte
+
+	wrote  bool
+	mode   byte // 's' for a stream, 'j' for a JSON response, 0 to pass through
+	buf    bytes.Buffer
+	status int
+}
+
+// NewWriter returns a Writer that passes each chunk through rewrite: a
+// line of a stream without its line ending, or with complete set, a whole
+// JSON response. rewrite returns the chunk to send, which may be b.
+func NewWriter(w http.ResponseWriter, rewrite func(b []byte, complete bool) []byte) *Writer {
+	return &Writer{ResponseWriter: w, rewrite: rewrite}
+}
+
+func (w *Writer) WriteHeader(code int) {
+	if w.wrote {
+		return
+	}
+	w.wrote, w.status = true, code
+	mt, _, _ := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	switch {
+	case code != http.StatusOK:
+	case mt == "application/x-ndjson":
+		w.mode = 's'
+		w.Header().Del("Content-Length")
+	case mt == "application/json":
+		w.mode = 'j'
+		return // sent with the rewritten body
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *Writer) Write(b []byte) (int, error) {
+	if !w.wrote {
+		w.WriteHeader(http.StatusOK)
+	}
+	switch w.mode {
+	case 'j':
+		return w.buf.Write(b)
+	case 's':
+		w.buf.Write(b)
+		for {
+			i := bytes.IndexByte(w.buf.Bytes(), '\n')
+			if i == -1 {
+				break
+			}
+			if _, err := w.ResponseWriter.Write(w.line(w.buf.Next(i + 1))); err != nil {
+				return 0, err
+			}
+		}
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *Writer) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok && w.mode != 'j' {
+		f.Flush()
+	}
+}
+
+func (w *Writer) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+// Finish writes what is still held back: the end of a stream without a
+// final line ending, or the whole of a JSON response.
+func (w *Writer) Finish() {
+	switch w.mode {
+	case 's':
+		if w.buf.Len() > 0 {
+			_, _ = w.ResponseWriter.Write(w.line(w.buf.Bytes()))
+		}
+	case 'j':
+		out := w.rewrite(w.buf.Bytes(), true)
+		w.Header().Set("Content-Length", strconv.Itoa(len(out)))
+		w.ResponseWriter.WriteHeader(w.status)
+		_, _ = w.ResponseWriter.Write(out)
+	}
+	w.buf.Reset()
+}
+
+// line rewrites one line of a stream, keeping its line ending.
+func (w *Writer) line(l []byte) []byte {
+	trimmed := bytes.TrimRight(l, "\r\n")
+	out := w.rewrite(trimmed, false)
+	// out may share l's array, which the line ending must not overwrite
+	return append(out[:len(out):len(out)], l[len(trimmed):]...)
+}
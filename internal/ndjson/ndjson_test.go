@@ -0,0 +1,77 @@
+package ndjson
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func upper(b []byte, complete bool) []byte {
+	if complete {
+		return append(bytes.ToUpper(b), '!')
+	}
+	return bytes.ToUpper(b)
+}
+
+func TestWriter(t *testing.T) {
+	cases := []struct {
+		name, contentType string
+		status            int
+		writes            []string
+		want              string
+	}{
+		{"stream", "application/x-ndjson", 200, []string{`{"a":1}` + "\n" + `{"b"`, `:2}` + "\r\n", `{"c":3}`}, `{"A":1}` + "\n" + `{"B":2}` + "\r\n" + `{"C":3}`},
+		{"json", "application/json; charset=utf-8", 200, []string{`{"a":`, `1}`}, `{"A":1}!`},
+		{"error", "application/json", 500, []string{`{"error":"x"}`}, `{"error":"x"}`},
+		{"other content", "text/plain", 200, []string{"a\nb"}, "a\nb"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			w := NewWriter(rec, upper)
+			w.Header().Set("Content-Type", tc.contentType)
+			w.Header().Set("Content-Length", "999")
+			w.WriteHeader(tc.status)
+			for _, s := range tc.writes {
+				if _, err := io.WriteString(w, s); err != nil {
+					t.Fatal(err)
+				}
+				w.Flush()
+			}
+			w.Finish()
+			if got := rec.Body.String(); got != tc.want {
+				t.Errorf("body %q, want %q", got, tc.want)
+			}
+			if rec.Code != tc.status {
+				t.Errorf("status %d, want %d", rec.Code, tc.status)
+			}
+			switch cl := rec.Header().Get("Content-Length"); tc.name {
+			case "stream":
+				if cl != "" {
+					t.Errorf("stream kept Content-Length %s", cl)
+				}
+			case "json":
+				if cl != "8" {
+					t.Errorf("Content-Length %s, want 8", cl)
+				}
+			}
+		})
+	}
+}
+
+func TestWriterKeepsLine(t *testing.T) {
+	// a rewrite returning part of its input must not see it clobbered
+	rec := httptest.NewRecorder()
+	w := NewWriter(rec, func(b []byte, _ bool) []byte { return b[:1] })
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	_, _ = io.WriteString(w, "ab\ncd\n")
+	w.Finish()
+	if got := rec.Body.String(); got != "a\nc\n" {
+		t.Errorf("body %q", got)
+	}
+	if rec.Code != http.StatusOK {
+		t.Errorf("status %d", rec.Code)
+	}
+}
@@ -0,0 +1,95 @@
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// severity groups event types for colours and icons in chat messages.
+func severity(typ string) string {
+	switch typ {
+	case UpstreamDown, CircuitOpen, ServerErrors, Panic:
+		return "critical"
+	case UpstreamRecovered:
+		return "resolved"
+	default:
+		return "warning"
+	}
+}
+
+var (
+	slackIcons    = map[string]string{"critical": ":red_circle:", "warning": ":warning:", "resolved": ":white_check_mark:"}
+	discordColors = map[string]int{"critical": 0xd93025, "warning": 0xf9ab00, "resolved": 0x188038}
+)
+
+// title turns an event type into a heading, e.g. "Upstream down".
+func title(typ string) string {
+	s := strings.ReplaceAll(typ, "_", " ")
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+// sortedFields returns the event fields as name/value pairs in a stable
+// order.
+func sortedFields(e Event) [][2]string {
+	names := make([]string, 0, len(e.Fields))
+	for k := range e.Fields {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	out := make([][2]string, 0, len(names))
+	for _, k := range names {
+		v := e.Fields[k]
+		if t, ok := v.(time.Time); ok {
+			v = t.UTC().Format(time.RFC3339)
+		}
+		out = append(out, [2]string{k, fmt.Sprint(v)})
+	}
+	return out
+}
+
+// encode renders e as the request body for a target format.
+func encode(format string, e Event) ([]byte, error) {
+	switch format {
+	case "", JSON:
+		return json.Marshal(e)
+	case Slack:
+		var b strings.Builder
+		fmt.Fprintf(&b, "%s *%s*: %s", slackIcons[severity(e.Type)], title(e.Type), e.Message)
+		for _, f := range sortedFields(e) {
+			fmt.Fprintf(&b, "\n• %s: `%s`", f[0], f[1])
+		}
+		return json.Marshal(map[string]string{"text": b.String()})
+	case Discord:
+		type field struct {
+			Name   string `json:"name"`
+			Value  string `json:"value"`
+			Inline bool   `json:"inline"`
+		}
+		embed := struct {
+			Title       string  `json:"title"`
+			Description string  `json:"description"`
+			Color       int     `json:"color"`
+			Timestamp   string  `json:"timestamp"`
+			Fields      []field `json:"fields,omitempty"`
+		}{
+			Title:       title(e.Type),
+			Description: e.Message,
+			Color:       discordColors[severity(e.Type)],
+			Timestamp:   e.Time.UTC().Format(time.RFC3339),
+		}
+		for _, f := range sortedFields(e) {
+			embed.Fields = append(embed.Fields, field{Name: f[0], Value: f[1], Inline: true})
+		}
+		return json.Marshal(map[string]any{"username": "ollama-proxy", "embeds": []any{embed}})
+	case Sentry:
+		return json.Marshal(sentryEvent(e))
+	default:
+		return nil, fmt.Errorf("unknown webhook format %q", format)
+	}
+}
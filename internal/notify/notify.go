@@ -0,0 +1,236 @@
+// Package notify posts operational events, such as the upstream going
+// down or a client running out of quota, to webhooks so incident tooling
+// hears about problems without scraping logs. Besides plain JSON webhooks
+// it can post formatted messages to Slack and Discord, and report errors
+// to Sentry.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/yeti47/ollama-proxy/internal/metrics"
+)
+
+// Event types.
+const (
+	UpstreamDown      = "upstream_down"
+	UpstreamRecovered = "upstream_recovered"
+	CircuitOpen       = "circuit_open"
+	ServerErrors      = "server_errors"
+	QuotaExceeded     = "quota_exceeded"
+	BudgetExceeded    = "budget_exceeded"
+	Panic             = "panic"
+)
+
+var notifications = metrics.NewCounter("ollama_proxy_notifications_total",
+	"Webhook notifications, by event type and result (sent, failed, dropped).", "type", "result")
+
+// Event is the JSON body posted to webhooks.
+type Event struct {
+	Type    string    `json:"type"`
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+	// Subject is what the event is about, e.g. a client name. Events are
+	// throttled per type and subject.
+	Subject string         `json:"subject,omitempty"`
+	Fields  map[string]any `json:"fields,omitempty"`
+}
+
+// queueSize bounds the events waiting for delivery; more are dropped so a
+// slow webhook can't hold up requests.
+const queueSize = 100
+
+// attempts is how often delivery to a webhook is tried.
+const attempts = 3
+
+// Target formats.
+const (
+	JSON    = "json"
+	Slack   = "slack"
+	Discord = "discord"
+	// Sentry targets take a DSN as URL and only receive critical events:
+	// panics, the upstream going down, 5xx bursts and exhausted keys.
+	Sentry = "sentry"
+)
+
+// Target is a webhook and the format it expects.
+type Target struct {
+	URL    string
+	Format string // JSON, Slack, Discord or Sentry; empty means JSON
+}
+
+// Notifier delivers events to webhooks in the background.
+type Notifier struct {
+	targets  []Target
+	throttle time.Duration
+	client   *http.Client
+	queue    chan Event
+	done     chan struct{}
+	backoff  time.Duration
+
+	mu     sync.Mutex
+	last   map[string]time.Time
+	closed bool
+	now    func() time.Time
+}
+
+// New returns a Notifier posting to targets. An event of the same type
+// and subject is sent at most once per throttle interval.
+func New(targets []Target, throttle time.Duration) *Notifier {
+	n := &Notifier{
+		targets:  targets,
+		throttle: throttle,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		queue:    make(chan Event, queueSize),
+		done:     make(chan struct{}),
+		backoff:  time.Second,
+		last:     make(map[string]time.Time),
+		now:      time.Now,
+	}
+	go n.run()
+	return n
+}
+
+// Notify queues e for delivery unless an event with the same type and
+// subject was queued within the throttle interval. It never blocks.
+func (n *Notifier) Notify(e Event) {
+	if e.Time.IsZero() {
+		e.Time = n.now().UTC()
+	}
+	id := e.Type + "\x00" + e.Subject
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed {
+		return
+	}
+	if last, ok := n.last[id]; ok && e.Time.Sub(last) < n.throttle {
+		return
+	}
+	n.last[id] = e.Time
+	select {
+	case n.queue <- e:
+	default:
+		notifications.With(e.Type, "dropped").Inc()
+		slog.Warn("notification queue full; dropping event", "type", e.Type)
+	}
+}
+
+func (n *Notifier) run() {
+	defer close(n.done)
+	for e := range n.queue {
+		for i, t := range n.targets {
+			if t.Format == Sentry && severity(e.Type) != "critical" {
+				continue
+			}
+			body, err := encode(t.Format, e)
+			if err != nil {
+				slog.Error("encoding notification", "type", e.Type, "format", t.Format, "err", err)
+				continue
+			}
+			if err := n.deliver(t, body); err != nil {
+				notifications.With(e.Type, "failed").Inc()
+				// webhook URLs often embed a token, so only log the index
+				slog.Warn("webhook delivery failed", "type", e.Type, "webhook", i, "err", err)
+				continue
+			}
+			notifications.With(e.Type, "sent").Inc()
+		}
+	}
+}
+
+func (n *Notifier) deliver(t Target, body []byte) error {
+	var err error
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			time.Sleep(n.backoff << (i - 1))
+		}
+		if err = n.post(t, body); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func (n *Notifier) post(t Target, body []byte) error {
+	url := t.URL
+	var sentryAuth string
+	if t.Format == Sentry {
+		d, err := ParseDSN(t.URL)
+		if err != nil {
+			return err
+		}
+		url, sentryAuth = d.storeURL(), d.authHeader()
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "ollama-proxy")
+	if sentryAuth != "" {
+		req.Header.Set("X-Sentry-Auth", sentryAuth)
+	}
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// Shutdown stops accepting events and waits until the queued ones are
+// delivered or ctx is done. It is safe to call on a nil Notifier.
+func (n *Notifier) Shutdown(ctx context.Context) {
+	if n == nil {
+		return
+	}
+	std.CompareAndSwap(n, nil)
+	n.mu.Lock()
+	if !n.closed {
+		n.closed = true
+		close(n.queue)
+	}
+	n.mu.Unlock()
+	select {
+	case <-n.done:
+	case <-ctx.Done():
+	}
+}
+
+var std atomic.Pointer[Notifier]
+
+// SetDefault makes n the notifier used by Send. Passing nil disables
+// notifications.
+func SetDefault(n *Notifier) {
+	std.Store(n)
+}
+
+// Send queues an event with the default notifier. fields are alternating
+// keys and values, as with slog. It does nothing when no default notifier
+// is set.
+func Send(typ, subject, message string, fields ...any) {
+	n := std.Load()
+	if n == nil {
+		return
+	}
+	e := Event{Type: typ, Subject: subject, Message: message}
+	if len(fields) > 0 {
+		e.Fields = make(map[string]any, len(fields)/2)
+		for i := 0; i+1 < len(fields); i += 2 {
+			e.Fields[fmt.Sprint(fields[i])] = fields[i+1]
+		}
+	}
+	n.Notify(e)
+}
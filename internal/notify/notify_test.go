@@ -0,0 +1,147 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNotifier(t *testing.T) {
+	var mu sync.Mutex
+	var got []Event
+	fail := 1
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if fail > 0 {
+			// the first delivery fails and must be retried
+			fail--
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		var e Event
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+			t.Errorf("decoding event: %v", err)
+		}
+		got = append(got, e)
+	}))
+	defer srv.Close()
+
+	n := New([]Target{{URL: srv.URL}}, time.Minute)
+	n.backoff = time.Millisecond
+	SetDefault(n)
+	Send(QuotaExceeded, "alice", "daily token quota exceeded for alice", "period", "daily")
+	Send(QuotaExceeded, "alice", "throttled")
+	Send(QuotaExceeded, "bob", "daily token quota exceeded for bob")
+	Send(UpstreamDown, "", "upstream is down")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	n.Shutdown(ctx)
+	Send(UpstreamDown, "", "after shutdown")
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(got) != 3 {
+		t.Fatalf("got %d events, want 3: %+v", len(got), got)
+	}
+	if e := got[0]; e.Type != QuotaExceeded || e.Subject != "alice" || e.Fields["period"] != "daily" || e.Time.IsZero() {
+		t.Errorf("first event %+v", e)
+	}
+	if got[1].Subject != "bob" || got[2].Type != UpstreamDown {
+		t.Errorf("events %+v", got)
+	}
+}
+
+func TestEncode(t *testing.T) {
+	e := Event{
+		Type:    UpstreamDown,
+		Time:    time.Date(2026, 10, 16, 9, 15, 0, 0, time.UTC),
+		Message: "upstream is down: 502 Bad Gateway",
+		Fields:  map[string]any{"upstream": "ollama.com", "consecutive_failures": 3},
+	}
+
+	b, err := encode(Slack, e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var slack struct{ Text string }
+	_ = json.Unmarshal(b, &slack)
+	want := ":red_circle: *Upstream down*: upstream is down: 502 Bad Gateway\n• consecutive_failures: `3`\n• upstream: `ollama.com`"
+	if slack.Text != want {
+		t.Errorf("slack text %q", slack.Text)
+	}
+
+	e.Type = UpstreamRecovered
+	b, err = encode(Discord, e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var discord struct {
+		Embeds []struct {
+			Title     string
+			Color     int
+			Timestamp string
+			Fields    []struct{ Name, Value string }
+		}
+	}
+	_ = json.Unmarshal(b, &discord)
+	if len(discord.Embeds) != 1 {
+		t.Fatalf("discord body %s", b)
+	}
+	if em := discord.Embeds[0]; em.Title != "Upstream recovered" || em.Color != 0x188038 || em.Timestamp != "2026-10-16T09:15:00Z" || len(em.Fields) != 2 {
+		t.Errorf("discord embed %+v", em)
+	}
+
+	if _, err := encode("teams", e); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+func TestSentry(t *testing.T) {
+	var mu sync.Mutex
+	var got []map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/sentry/api/42/store/" || !strings.Contains(r.Header.Get("X-Sentry-Auth"), "sentry_key=pubkey") {
+			t.Errorf("posted to %s with auth %q", r.URL.Path, r.Header.Get("X-Sentry-Auth"))
+		}
+		var e map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&e)
+		mu.Lock()
+		got = append(got, e)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	dsn := strings.Replace(srv.URL, "://", "://pubkey@", 1) + "/sentry/42"
+	if _, err := ParseDSN(dsn); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseDSN("https://sentry.example.com/42"); err == nil {
+		t.Error("DSN without a key accepted")
+	}
+	n := New([]Target{{URL: dsn, Format: Sentry}}, time.Minute)
+	n.Notify(Event{Type: QuotaExceeded, Subject: "alice", Message: "not an error"})
+	n.Notify(Event{Type: Panic, Subject: "/api/chat", Message: "panic: boom",
+		Fields: map[string]any{"request_id": "req-1", "stack": "goroutine 1 [running]:"}})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	n.Shutdown(ctx)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(got) != 1 {
+		t.Fatalf("got %d events, want only the panic: %+v", len(got), got)
+	}
+	e := got[0]
+	tags, _ := e["tags"].(map[string]any)
+	extra, _ := e["extra"].(map[string]any)
+	if e["level"] != "fatal" || e["message"] != "panic: boom" || tags["request_id"] != "req-1" || extra["stack"] == nil || len(e["event_id"].(string)) != 32 {
+		t.Errorf("event %+v", e)
+	}
+}
@@ -0,0 +1,91 @@
+package notify
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/yeti47/ollama-proxy/internal/buildinfo"
+)
+
+// DSN is a parsed Sentry DSN, https://<key>@<host>/<project>.
+type DSN struct {
+	scheme, host, path string
+	key, project       string
+}
+
+// ParseDSN parses a Sentry DSN. Self-hosted Sentry and compatible
+// services such as GlitchTip use the same form.
+func ParseDSN(s string) (*DSN, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("sentry dsn: %w", err)
+	}
+	if u.Scheme != "https" && u.Scheme != "http" || u.Host == "" || u.User == nil || u.User.Username() == "" {
+		return nil, fmt.Errorf("sentry dsn must look like https://<key>@<host>/<project>")
+	}
+	// anything before the project id is a path prefix of the Sentry host
+	p := strings.TrimSuffix(u.Path, "/")
+	i := strings.LastIndex(p, "/")
+	path, project := p[:max(i, 0)], p[i+1:]
+	if project == "" {
+		return nil, fmt.Errorf("sentry dsn has no project id")
+	}
+	return &DSN{scheme: u.Scheme, host: u.Host, path: path, key: u.User.Username(), project: project}, nil
+}
+
+func (d *DSN) storeURL() string {
+	return fmt.Sprintf("%s://%s%s/api/%s/store/", d.scheme, d.host, d.path, d.project)
+}
+
+func (d *DSN) authHeader() string {
+	return "Sentry sentry_version=7, sentry_client=ollama-proxy/" + buildinfo.Get().Version + ", sentry_key=" + d.key
+}
+
+// sentryEvent renders e for Sentry's store endpoint. Fields become tags,
+// except a stack trace, which goes into extra.
+func sentryEvent(e Event) map[string]any {
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	level := "error"
+	if e.Type == Panic {
+		level = "fatal"
+	}
+	tags := map[string]string{"event_type": e.Type}
+	extra := map[string]any{}
+	for _, f := range sortedFields(e) {
+		if f[0] == "stack" {
+			extra["stack"] = f[1]
+			continue
+		}
+		if len(f[1]) > 200 { // Sentry's limit for tag values
+			extra[f[0]] = f[1]
+			continue
+		}
+		tags[f[0]] = f[1]
+	}
+	ev := map[string]any{
+		"event_id":    hex.EncodeToString(id),
+		"timestamp":   e.Time.UTC().Format("2006-01-02T15:04:05Z"),
+		"level":       level,
+		"platform":    "go",
+		"logger":      "ollama-proxy",
+		"release":     "ollama-proxy@" + buildinfo.Get().Version,
+		"message":     e.Message,
+		"tags":        tags,
+		"fingerprint": []string{e.Type, e.Subject},
+	}
+	if host, err := os.Hostname(); err == nil {
+		ev["server_name"] = host
+	}
+	if len(extra) > 0 {
+		ev["extra"] = extra
+	}
+	if e.Type == Panic {
+		ev["exception"] = map[string]any{"values": []map[string]string{{"type": "panic", "value": e.Message}}}
+	}
+	return ev
+}
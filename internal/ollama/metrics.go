@@ -0,0 +1,55 @@
+package ollama
+
+import (
+	"time"
+
+	"github.com/yeti47/ollama-proxy/internal/metrics"
+)
+
+var (
+	tokensTotal = metrics.NewCounter("ollama_proxy_tokens_total",
+		"Tokens reported by the upstream at the end of a generation, by model, client, tenant and kind (prompt or completion).",
+		"model", "client", "tenant", "kind")
+	generationSeconds = metrics.NewHistogram("ollama_proxy_generation_duration_seconds",
+		"Durations reported by the upstream at the end of a generation, by model and phase (total, load, prompt_eval, eval).",
+		nil, "model", "phase")
+)
+
+// RecordUsage adds u to the token and generation duration metrics. client
+// is the authenticated client name, or "" for anonymous requests, and
+// tenant its tenant, if any.
+func RecordUsage(client, tenant string, u Usage) {
+	if client == "" {
+		client = "anonymous"
+	}
+	model := u.Model
+	if model == "" {
+		model = "unknown"
+	}
+	tokensTotal.With(model, client, tenant, "prompt").Add(float64(u.PromptTokens))
+	tokensTotal.With(model, client, tenant, "completion").Add(float64(u.CompletionTokens))
+	for _, p := range []struct {
+		phase string
+		d     time.Duration
+	}{
+		{"total", u.TotalDuration},
+		{"load", u.LoadDuration},
+		{"prompt_eval", u.PromptEvalDuration},
+		{"eval", u.EvalDuration},
+	} {
+		// OpenAI-compatible endpoints report no durations
+		if p.d > 0 {
+			generationSeconds.With(model, p.phase).Observe(p.d.Seconds())
+		}
+	}
+}
+
+// LogFields returns u as key/value pairs for a log record.
+func (u Usage) LogFields() []any {
+	f := []any{"model", u.Model, "prompt_tokens", u.PromptTokens, "completion_tokens", u.CompletionTokens}
+	if u.TotalDuration > 0 {
+		f = append(f, "total_duration", u.TotalDuration, "load_duration", u.LoadDuration,
+			"prompt_eval_duration", u.PromptEvalDuration, "eval_duration", u.EvalDuration)
+	}
+	return f
+}
@@ -0,0 +1,221 @@
+package ollama
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PeekBody reads the request body and replaces it with an in-memory copy
+// so the request can still be forwarded. It returns nil for requests
+// without a body.
+func PeekBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+	b, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// ReplaceBody swaps the request body for b and fixes up Content-Length.
+func ReplaceBody(r *http.Request, b []byte) {
+	r.Body = io.NopCloser(bytes.NewReader(b))
+	r.ContentLength = int64(len(b))
+	r.Header.Set("Content-Length", strconv.Itoa(len(b)))
+}
+
+// Model returns the model named in an Ollama request body. Most endpoints
+// use "model"; some older ones (show, pull, delete) still accept "name".
+// It returns an empty string if the body isn't JSON or names no model.
+func Model(body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+	var m struct {
+		Model string `json:"model"`
+		Name  string `json:"name"`
+	}
+	if json.Unmarshal(body, &m) != nil {
+		return ""
+	}
+	if m.Model != "" {
+		return m.Model
+	}
+	return m.Name
+}
+
+// PeekModel combines PeekBody and Model.
+func PeekModel(r *http.Request) (string, error) {
+	b, err := PeekBody(r)
+	if err != nil {
+		return "", err
+	}
+	return Model(b), nil
+}
+
+// NormalizeModel adds the implicit ":latest" tag so "llama3" and
+// "llama3:latest" compare equal.
+func NormalizeModel(name string) string {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return name
+	}
+	// a colon after the last slash separates the tag; one before it is a
+	// registry port
+	if i := strings.LastIndex(name, ":"); i == -1 || i < strings.LastIndex(name, "/") {
+		return name + ":latest"
+	}
+	return name
+}
+
+// Usage is the token accounting reported at the end of a generation.
+// Ollama sends it on the final ("done": true) chunk; OpenAI-compatible
+// endpoints send a "usage" object.
+type Usage struct {
+	Model              string
+	PromptTokens       int
+	CompletionTokens   int
+	TotalDuration      time.Duration
+	LoadDuration       time.Duration
+	PromptEvalDuration time.Duration
+	EvalDuration       time.Duration
+}
+
+// TotalTokens returns prompt plus completion tokens.
+func (u Usage) TotalTokens() int { return u.PromptTokens + u.CompletionTokens }
+
+// ParseUsage extracts usage from a single response line, which may be an
+// NDJSON chunk or an SSE "data:" frame. ok is false for lines that carry no
+// usage information.
+func ParseUsage(line []byte) (u Usage, ok bool) {
+	line = bytes.TrimSpace(line)
+	line = bytes.TrimPrefix(line, []byte("data:"))
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 || line[0] != '{' {
+		return u, false
+	}
+	// cheap pre-check so we only fully decode the interesting chunks
+	if !bytes.Contains(line, []byte(`"done"`)) && !bytes.Contains(line, []byte(`"usage"`)) {
+		return u, false
+	}
+	var m struct {
+		Model              string `json:"model"`
+		Done               bool   `json:"done"`
+		PromptEvalCount    int    `json:"prompt_eval_count"`
+		EvalCount          int    `json:"eval_count"`
+		TotalDuration      int64  `json:"total_duration"`
+		LoadDuration       int64  `json:"load_duration"`
+		PromptEvalDuration int64  `json:"prompt_eval_duration"`
+		EvalDuration       int64  `json:"eval_duration"`
+		Usage              *struct {
+			PromptTokens     int `json:"prompt_tokens"`
+			CompletionTokens int `json:"completion_tokens"`
+		} `json:"usage"`
+	}
+	if json.Unmarshal(line, &m) != nil {
+		return u, false
+	}
+	switch {
+	case m.Usage != nil:
+		return Usage{Model: m.Model, PromptTokens: m.Usage.PromptTokens, CompletionTokens: m.Usage.CompletionTokens}, true
+	case m.Done:
+		return Usage{
+			Model:              m.Model,
+			PromptTokens:       m.PromptEvalCount,
+			CompletionTokens:   m.EvalCount,
+			TotalDuration:      time.Duration(m.TotalDuration),
+			LoadDuration:       time.Duration(m.LoadDuration),
+			PromptEvalDuration: time.Duration(m.PromptEvalDuration),
+			EvalDuration:       time.Duration(m.EvalDuration),
+		}, true
+	}
+	return u, false
+}
+
+// UsageReader wraps a response body and watches the lines passing through
+// for usage information. OnUsage is called once, when the body hits EOF or
+// is closed, if any usage was seen. Only the current partial line is
+// buffered, so streaming is not affected.
+type UsageReader struct {
+	rc      io.ReadCloser
+	onUsage func(Usage)
+	line    []byte
+	skip    bool
+	usage   Usage
+	seen    bool
+	fired   bool
+}
+
+// maxUsageLine caps how much of a single line is buffered. Final chunks
+// are small; huge lines (e.g. embeddings) are skipped.
+const maxUsageLine = 64 << 10
+
+// NewUsageReader returns a reader that reports usage to onUsage.
+func NewUsageReader(rc io.ReadCloser, onUsage func(Usage)) *UsageReader {
+	return &UsageReader{rc: rc, onUsage: onUsage}
+}
+
+func (u *UsageReader) Read(p []byte) (int, error) {
+	n, err := u.rc.Read(p)
+	u.scan(p[:n])
+	if err == io.EOF {
+		u.scan([]byte("\n"))
+		u.fire()
+	}
+	return n, err
+}
+
+// Close closes the underlying body and reports usage if not done already.
+func (u *UsageReader) Close() error {
+	err := u.rc.Close()
+	u.fire()
+	return err
+}
+
+func (u *UsageReader) scan(b []byte) {
+	for len(b) > 0 {
+		i := bytes.IndexByte(b, '\n')
+		if i == -1 {
+			u.appendLine(b)
+			return
+		}
+		u.appendLine(b[:i])
+		if !u.skip {
+			if usage, ok := ParseUsage(u.line); ok {
+				u.usage, u.seen = usage, true
+			}
+		}
+		u.line, u.skip = u.line[:0], false
+		b = b[i+1:]
+	}
+}
+
+// appendLine buffers part of the current line, giving up on lines longer
+// than maxUsageLine.
+func (u *UsageReader) appendLine(b []byte) {
+	if u.skip {
+		return
+	}
+	if len(u.line)+len(b) > maxUsageLine {
+		u.line, u.skip = u.line[:0], true
+		return
+	}
+	u.line = append(u.line, b...)
+}
+
+func (u *UsageReader) fire() {
+	if u.fired || !u.seen {
+		return
+	}
+	u.fired = true
+	u.onUsage(u.usage)
+}
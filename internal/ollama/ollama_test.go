@@ -0,0 +1,89 @@
+package ollama
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+func TestUsageReader(t *testing.T) {
+	stream := `{"model":"llama3.2","message":{"content":"Hel"},"done":false}
+{"model":"llama3.2","message":{"content":"lo"},"done":false}
+{"model":"llama3.2","done":true,"prompt_eval_count":12,"eval_count":34,"eval_duration":1000000}
+`
+	var got []Usage
+	r := NewUsageReader(io.NopCloser(&chunkedReader{s: stream, n: 7}), func(u Usage) { got = append(got, u) })
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	r.Close()
+	if string(b) != stream {
+		t.Fatalf("body altered: %q", b)
+	}
+	if len(got) != 1 {
+		t.Fatalf("expected one usage callback, got %d", len(got))
+	}
+	if u := got[0]; u.Model != "llama3.2" || u.PromptTokens != 12 || u.CompletionTokens != 34 || u.EvalDuration.Milliseconds() != 1 {
+		t.Fatalf("unexpected usage %+v", u)
+	}
+}
+
+func TestParseUsageOpenAI(t *testing.T) {
+	u, ok := ParseUsage([]byte(`data: {"model":"m","choices":[],"usage":{"prompt_tokens":3,"completion_tokens":4}}`))
+	if !ok || u.TotalTokens() != 7 {
+		t.Fatalf("unexpected usage %+v ok=%t", u, ok)
+	}
+	if _, ok := ParseUsage([]byte(`data: [DONE]`)); ok {
+		t.Fatal("expected no usage for [DONE]")
+	}
+}
+
+func TestNormalizeModel(t *testing.T) {
+	cases := map[string]string{
+		"llama3":                    "llama3:latest",
+		"llama3:8b":                 "llama3:8b",
+		"registry:5000/team/model":  "registry:5000/team/model:latest",
+		"registry:5000/team/m:q4_0": "registry:5000/team/m:q4_0",
+	}
+	for in, want := range cases {
+		if got := NormalizeModel(in); got != want {
+			t.Errorf("%s: expected %s got %s", in, want, got)
+		}
+	}
+}
+
+// chunkedReader returns s in pieces of n bytes to exercise line splitting.
+type chunkedReader struct {
+	s string
+	n int
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	if c.s == "" {
+		return 0, io.EOF
+	}
+	n := c.n
+	if n > len(c.s) {
+		n = len(c.s)
+	}
+	n = copy(p, c.s[:n])
+	c.s = c.s[n:]
+	return n, nil
+}
+
+func TestRecordUsage(t *testing.T) {
+	u := Usage{Model: "llama3:latest", PromptTokens: 12, CompletionTokens: 30, TotalDuration: 2 * time.Second, EvalDuration: time.Second}
+	RecordUsage("alice", "acme", u)
+	RecordUsage("alice", "acme", u)
+	if got := tokensTotal.With("llama3:latest", "alice", "acme", "completion").Value(); got != 60 {
+		t.Errorf("completion tokens %v, want 60", got)
+	}
+	if got := tokensTotal.With("llama3:latest", "alice", "acme", "prompt").Value(); got != 24 {
+		t.Errorf("prompt tokens %v, want 24", got)
+	}
+	RecordUsage("", "", Usage{PromptTokens: 1})
+	if got := tokensTotal.With("unknown", "anonymous", "", "prompt").Value(); got != 1 {
+		t.Errorf("anonymous prompt tokens %v, want 1", got)
+	}
+}
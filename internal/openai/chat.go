@@ -0,0 +1,442 @@
+package openai
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/yeti47/ollama-proxy/internal/ollama"
+	"github.com/yeti47/ollama-proxy/internal/translate"
+)
+
+// chatRequest is the subset of OpenAI's chat completion request that has
+// an Ollama equivalent.
+type chatRequest struct {
+	Model         string        `json:"model"`
+	Messages      []chatMessage `json:"messages"`
+	Stream        bool          `json:"stream"`
+	StreamOptions *struct {
+		IncludeUsage bool `json:"include_usage"`
+	} `json:"stream_options"`
+	N                   *int            `json:"n"`
+	MaxTokens           *int            `json:"max_tokens"`
+	MaxCompletionTokens *int            `json:"max_completion_tokens"`
+	Temperature         *float64        `json:"temperature"`
+	TopP                *float64        `json:"top_p"`
+	FrequencyPenalty    *float64        `json:"frequency_penalty"`
+	PresencePenalty     *float64        `json:"presence_penalty"`
+	Seed                *int            `json:"seed"`
+	Stop                json.RawMessage `json:"stop"`
+	ResponseFormat      *struct {
+		Type       string `json:"type"`
+		JSONSchema *struct {
+			Schema json.RawMessage `json:"schema"`
+		} `json:"json_schema"`
+	} `json:"response_format"`
+	Tools json.RawMessage `json:"tools"`
+}
+
+type chatMessage struct {
+	Role       string          `json:"role"`
+	Content    json.RawMessage `json:"content"` // a string, an array of parts or null
+	ToolCalls  []toolCall      `json:"tool_calls"`
+	ToolCallID string          `json:"tool_call_id"`
+}
+
+type toolCall struct {
+	Index    *int   `json:"index,omitempty"`
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"` // JSON encoded
+	} `json:"function"`
+}
+
+// ollamaMessage is a message of Ollama's /api/chat, in both directions.
+type ollamaMessage struct {
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	Images    []string         `json:"images,omitempty"`
+	ToolCalls []ollamaToolCall `json:"tool_calls,omitempty"`
+	ToolName  string           `json:"tool_name,omitempty"`
+}
+
+type ollamaToolCall struct {
+	Function struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	} `json:"function"`
+}
+
+type ollamaChatResponse struct {
+	Model           string        `json:"model"`
+	Message         ollamaMessage `json:"message"`
+	Done            bool          `json:"done"`
+	DoneReason      string        `json:"done_reason"`
+	PromptEvalCount int           `json:"prompt_eval_count"`
+	EvalCount       int           `json:"eval_count"`
+	Error           string        `json:"error"`
+}
+
+// toOllamaChat converts an OpenAI chat completion request to the body of
+// an /api/chat request.
+func toOllamaChat(req *chatRequest) ([]byte, error) {
+	if req.Model == "" {
+		return nil, fmt.Errorf("model is required")
+	}
+	if len(req.Messages) == 0 {
+		return nil, fmt.Errorf("messages must not be empty")
+	}
+	if req.N != nil && *req.N != 1 {
+		return nil, fmt.Errorf("n must be 1: Ollama generates a single choice")
+	}
+	out := map[string]any{"model": req.Model, "stream": req.Stream}
+	msgs := make([]ollamaMessage, 0, len(req.Messages))
+	toolNames := map[string]string{} // tool call ID -> function name
+	for i, m := range req.Messages {
+		om := ollamaMessage{Role: m.Role}
+		if err := messageContent(m.Content, &om); err != nil {
+			return nil, fmt.Errorf("messages[%d]: %v", i, err)
+		}
+		for _, tc := range m.ToolCalls {
+			var call ollamaToolCall
+			call.Function.Name = tc.Function.Name
+			call.Function.Arguments = json.RawMessage("{}")
+			if tc.Function.Arguments != "" {
+				if !json.Valid([]byte(tc.Function.Arguments)) {
+					return nil, fmt.Errorf("messages[%d]: tool call arguments are not valid JSON", i)
+				}
+				call.Function.Arguments = json.RawMessage(tc.Function.Arguments)
+			}
+			om.ToolCalls = append(om.ToolCalls, call)
+			toolNames[tc.ID] = tc.Function.Name
+		}
+		if m.Role == "tool" {
+			om.ToolName = toolNames[m.ToolCallID]
+		}
+		msgs = append(msgs, om)
+	}
+	out["messages"] = msgs
+
+	opts := map[string]any{}
+	setOpt := func(name string, v any, ok bool) {
+		if ok {
+			opts[name] = v
+		}
+	}
+	if req.MaxCompletionTokens != nil {
+		req.MaxTokens = req.MaxCompletionTokens
+	}
+	setOpt("num_predict", deref(req.MaxTokens), req.MaxTokens != nil)
+	setOpt("temperature", deref(req.Temperature), req.Temperature != nil)
+	setOpt("top_p", deref(req.TopP), req.TopP != nil)
+	setOpt("frequency_penalty", deref(req.FrequencyPenalty), req.FrequencyPenalty != nil)
+	setOpt("presence_penalty", deref(req.PresencePenalty), req.PresencePenalty != nil)
+	setOpt("seed", deref(req.Seed), req.Seed != nil)
+	if stop, err := stopSequences(req.Stop); err != nil {
+		return nil, err
+	} else if len(stop) > 0 {
+		opts["stop"] = stop
+	}
+	if len(opts) > 0 {
+		out["options"] = opts
+	}
+	if rf := req.ResponseFormat; rf != nil {
+		switch rf.Type {
+		case "json_object":
+			out["format"] = "json"
+		case "json_schema":
+			if rf.JSONSchema == nil || len(rf.JSONSchema.Schema) == 0 {
+				return nil, fmt.Errorf("response_format json_schema needs a schema")
+			}
+			out["format"] = rf.JSONSchema.Schema
+		case "", "text":
+		default:
+			return nil, fmt.Errorf("unsupported response_format %q", rf.Type)
+		}
+	}
+	// OpenAI's function tool definitions are what Ollama expects as well
+	if len(req.Tools) > 0 && string(req.Tools) != "null" {
+		out["tools"] = req.Tools
+	}
+	return json.Marshal(out)
+}
+
+func deref[T any](p *T) T {
+	if p == nil {
+		var zero T
+		return zero
+	}
+	return *p
+}
+
+// messageContent fills in the text and images of m from an OpenAI message
+// content: a string, or an array of text and image_url parts.
+func messageContent(raw json.RawMessage, m *ollamaMessage) error {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil
+	}
+	if raw[0] == '"' {
+		return json.Unmarshal(raw, &m.Content)
+	}
+	var parts []struct {
+		Type     string          `json:"type"`
+		Text     string          `json:"text"`
+		ImageURL json.RawMessage `json:"image_url"`
+	}
+	if err := json.Unmarshal(raw, &parts); err != nil {
+		return fmt.Errorf("content must be a string or an array of parts")
+	}
+	var text []string
+	for _, p := range parts {
+		switch p.Type {
+		case "text":
+			text = append(text, p.Text)
+		case "image_url":
+			img, err := imageData(p.ImageURL)
+			if err != nil {
+				return err
+			}
+			m.Images = append(m.Images, img)
+		default:
+			return fmt.Errorf("unsupported content part %q", p.Type)
+		}
+	}
+	m.Content = strings.Join(text, "\n")
+	return nil
+}
+
+// imageData returns the base64 data of an image_url part. Only data URLs
+// are accepted: fetching remote images would let clients make the proxy
+// request arbitrary URLs.
+func imageData(raw json.RawMessage) (string, error) {
+	var u struct {
+		URL string `json:"url"`
+	}
+	if len(raw) > 0 && raw[0] == '"' {
+		_ = json.Unmarshal(raw, &u.URL)
+	} else {
+		_ = json.Unmarshal(raw, &u)
+	}
+	meta, data, ok := strings.Cut(u.URL, ",")
+	if !ok || !strings.HasPrefix(meta, "data:") || !strings.HasSuffix(meta, ";base64") {
+		return "", fmt.Errorf("image_url must be a base64 data URL")
+	}
+	return data, nil
+}
+
+// stopSequences accepts OpenAI's stop, a string or an array of strings.
+func stopSequences(raw json.RawMessage) ([]string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	var one string
+	if json.Unmarshal(raw, &one) == nil {
+		return []string{one}, nil
+	}
+	var many []string
+	if err := json.Unmarshal(raw, &many); err != nil {
+		return nil, fmt.Errorf("stop must be a string or an array of strings")
+	}
+	return many, nil
+}
+
+type chatCompletion struct {
+	ID                string       `json:"id"`
+	Object            string       `json:"object"`
+	Created           int64        `json:"created"`
+	Model             string       `json:"model"`
+	SystemFingerprint string       `json:"system_fingerprint"`
+	Choices           []chatChoice `json:"choices"`
+	Usage             *chatUsage   `json:"usage,omitempty"`
+}
+
+type chatChoice struct {
+	Index        int         `json:"index"`
+	Message      *chatOutput `json:"message,omitempty"`
+	Delta        *chatOutput `json:"delta,omitempty"`
+	FinishReason *string     `json:"finish_reason"`
+}
+
+// chatOutput is a choice's message, or the delta of a streamed chunk.
+type chatOutput struct {
+	Role      string     `json:"role,omitempty"`
+	Content   *string    `json:"content,omitempty"`
+	ToolCalls []toolCall `json:"tool_calls,omitempty"`
+}
+
+type chatUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// chatResponse tracks one translated chat response.
+type chatResponse struct {
+	id           string
+	model        string
+	created      int64
+	stream       bool
+	includeUsage bool
+	started      bool // the first chunk, carrying the role, was sent
+	toolCalls    bool
+}
+
+func (c *chatResponse) completion(choices ...chatChoice) chatCompletion {
+	obj := "chat.completion"
+	if c.stream {
+		obj = "chat.completion.chunk"
+	}
+	if choices == nil {
+		choices = []chatChoice{} // the usage chunk has an empty list
+	}
+	return chatCompletion{ID: c.id, Object: obj, Created: c.created, Model: c.model, SystemFingerprint: "fp_ollama", Choices: choices}
+}
+
+// output converts an Ollama message; tool calls get IDs and JSON encoded
+// arguments.
+func (c *chatResponse) output(m ollamaMessage) *chatOutput {
+	out := &chatOutput{}
+	if m.Content != "" {
+		out.Content = &m.Content
+	}
+	for i, tc := range m.ToolCalls {
+		var call toolCall
+		if c.stream {
+			idx := i
+			call.Index = &idx
+		}
+		call.ID = translate.NewID("call_")
+		call.Type = "function"
+		call.Function.Name = tc.Function.Name
+		call.Function.Arguments = string(tc.Function.Arguments)
+		if len(tc.Function.Arguments) == 0 {
+			call.Function.Arguments = "{}"
+		}
+		out.ToolCalls = append(out.ToolCalls, call)
+		c.toolCalls = true
+	}
+	return out
+}
+
+func (c *chatResponse) finishReason(doneReason string) *string {
+	reason := "stop"
+	switch {
+	case c.toolCalls:
+		reason = "tool_calls"
+	case doneReason == "length":
+		reason = "length"
+	}
+	return &reason
+}
+
+func usageOf(r ollamaChatResponse) *chatUsage {
+	return &chatUsage{PromptTokens: r.PromptEvalCount, CompletionTokens: r.EvalCount, TotalTokens: r.PromptEvalCount + r.EvalCount}
+}
+
+// result converts a non-streamed /api/chat response.
+func (c *chatResponse) result(w http.ResponseWriter, body []byte) {
+	var r ollamaChatResponse
+	if err := json.Unmarshal(body, &r); err != nil {
+		writeError(w, http.StatusBadGateway, "upstream sent an invalid chat response")
+		return
+	}
+	if r.Error != "" {
+		writeError(w, http.StatusBadGateway, r.Error)
+		return
+	}
+	msg := c.output(r.Message)
+	msg.Role = "assistant"
+	if msg.Content == nil {
+		empty := ""
+		msg.Content = &empty
+	}
+	out := c.completion(chatChoice{Message: msg, FinishReason: c.finishReason(r.DoneReason)})
+	out.Usage = usageOf(r)
+	b, _ := json.Marshal(out)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(b)
+}
+
+// chunk converts one line of a streamed /api/chat response into SSE
+// frames.
+func (c *chatResponse) chunk(w http.ResponseWriter, line []byte) {
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 {
+		return
+	}
+	var r ollamaChatResponse
+	if err := json.Unmarshal(line, &r); err != nil || r.Error != "" {
+		msg := r.Error
+		if msg == "" {
+			msg = "upstream sent an invalid chat chunk"
+		}
+		b, _ := json.Marshal(map[string]any{"error": map[string]any{"message": msg, "type": "server_error"}})
+		sse(w, b)
+		return
+	}
+	delta := c.output(r.Message)
+	if !c.started {
+		delta.Role, c.started = "assistant", true
+	}
+	if delta.Content != nil || delta.ToolCalls != nil || delta.Role != "" {
+		b, _ := json.Marshal(c.completion(chatChoice{Delta: delta}))
+		sse(w, b)
+	}
+	if !r.Done {
+		return
+	}
+	b, _ := json.Marshal(c.completion(chatChoice{Delta: &chatOutput{}, FinishReason: c.finishReason(r.DoneReason)}))
+	sse(w, b)
+	if c.includeUsage {
+		u := c.completion()
+		u.Usage = usageOf(r)
+		b, _ = json.Marshal(u)
+		sse(w, b)
+	}
+	sse(w, []byte("[DONE]"))
+}
+
+func sse(w http.ResponseWriter, data []byte) {
+	_, _ = w.Write([]byte("data: "))
+	_, _ = w.Write(data)
+	_, _ = w.Write([]byte("\n\n"))
+}
+
+// chat serves /v1/chat/completions through next's /api/chat.
+func chat(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	body, err := ollama.PeekBody(r)
+	if err != nil {
+		bodyError(w, err)
+		return
+	}
+	var req chatRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return
+	}
+	b, err := toOllamaChat(&req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	c := &chatResponse{
+		id:           translate.NewID("chatcmpl-"),
+		model:        req.Model,
+		stream:       req.Stream,
+		created:      time.Now().Unix(),
+		includeUsage: req.Stream && req.StreamOptions != nil && req.StreamOptions.IncludeUsage,
+	}
+	var line func([]byte)
+	if req.Stream {
+		line = func(b []byte) { c.chunk(w, b) }
+	}
+	t := translate.NewWriter(w, line, writeError)
+	forward(t, r, next, "/api/chat", b)
+	t.Finish(func(body []byte) { c.result(w, body) })
+}
@@ -0,0 +1,164 @@
+package openai
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// upstream returns a handler standing in for the proxy, recording the
+// translated request and answering with status and body.
+func upstream(t *testing.T, path string, got *map[string]any, status int, body string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != path || r.Header.Get("Accept-Encoding") != "" {
+			t.Errorf("forwarded to %s with Accept-Encoding %q", r.URL.Path, r.Header.Get("Accept-Encoding"))
+		}
+		b, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(b, got); err != nil {
+			t.Errorf("forwarded body %s: %v", b, err)
+		}
+		w.Header().Set("Content-Length", "12345")
+		w.WriteHeader(status)
+		_, _ = io.WriteString(w, body)
+	})
+}
+
+func TestChat(t *testing.T) {
+	var got map[string]any
+	h := Middleware(upstream(t, "/api/chat", &got, http.StatusOK,
+		`{"model":"llama3","message":{"role":"assistant","content":"Hi there"},"done":true,"done_reason":"stop","prompt_eval_count":9,"eval_count":3}`))
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{
+		"model": "llama3",
+		"messages": [
+			{"role": "system", "content": "be brief"},
+			{"role": "user", "content": [{"type": "text", "text": "what is this?"},
+				{"type": "image_url", "image_url": {"url": "data:image/png;base64,aGVsbG8="}}]}
+		],
+		"max_tokens": 50, "temperature": 0.2, "stop": "END",
+		"response_format": {"type": "json_object"}}`))
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	msgs := got["messages"].([]any)
+	user := msgs[1].(map[string]any)
+	opts := got["options"].(map[string]any)
+	if got["stream"] != false || got["format"] != "json" || user["content"] != "what is this?" ||
+		user["images"].([]any)[0] != "aGVsbG8=" || opts["num_predict"] != 50.0 || opts["stop"].([]any)[0] != "END" {
+		t.Errorf("translated request %v", got)
+	}
+
+	var resp chatCompletion
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("%v: %s", err, rec.Body)
+	}
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Length") != "" || resp.Object != "chat.completion" ||
+		!strings.HasPrefix(resp.ID, "chatcmpl-") || resp.Model != "llama3" {
+		t.Errorf("response %d %v %s", rec.Code, rec.Header(), rec.Body)
+	}
+	c := resp.Choices[0]
+	if *c.Message.Content != "Hi there" || c.Message.Role != "assistant" || *c.FinishReason != "stop" || resp.Usage.TotalTokens != 12 {
+		t.Errorf("response %s", rec.Body)
+	}
+}
+
+func TestChatStream(t *testing.T) {
+	var got map[string]any
+	h := Middleware(upstream(t, "/api/chat", &got, http.StatusOK, strings.Join([]string{
+		`{"model":"llama3","message":{"role":"assistant","content":"Hel"},"done":false}`,
+		`{"model":"llama3","message":{"role":"assistant","content":"lo"},"done":false}`,
+		`{"model":"llama3","message":{"role":"assistant","content":"","tool_calls":[{"function":{"name":"get_weather","arguments":{"city":"Oslo"}}}]},"done":false}`,
+		`{"model":"llama3","message":{"role":"assistant","content":""},"done":true,"done_reason":"stop","prompt_eval_count":5,"eval_count":7}`,
+	}, "\n")+"\n"))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(
+		`{"model":"llama3","stream":true,"stream_options":{"include_usage":true},"messages":[{"role":"user","content":"hi"}]}`)))
+
+	if got["stream"] != true {
+		t.Errorf("translated request %v", got)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type %q", ct)
+	}
+	frames := strings.Split(strings.TrimSuffix(rec.Body.String(), "\n\n"), "\n\n")
+	if len(frames) != 6 || frames[5] != "data: [DONE]" {
+		t.Fatalf("frames %q", frames)
+	}
+	var chunks []chatCompletion
+	for _, f := range frames[:5] {
+		var c chatCompletion
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(f, "data: ")), &c); err != nil {
+			t.Fatalf("%s: %v", f, err)
+		}
+		chunks = append(chunks, c)
+	}
+	if d := chunks[0].Choices[0].Delta; d.Role != "assistant" || *d.Content != "Hel" || chunks[0].Object != "chat.completion.chunk" {
+		t.Errorf("first chunk %s", frames[0])
+	}
+	if tc := chunks[2].Choices[0].Delta.ToolCalls; len(tc) != 1 || tc[0].Function.Arguments != `{"city":"Oslo"}` || *tc[0].Index != 0 {
+		t.Errorf("tool call chunk %s", frames[2])
+	}
+	if fr := chunks[3].Choices[0].FinishReason; fr == nil || *fr != "tool_calls" {
+		t.Errorf("final chunk %s", frames[3])
+	}
+	if u := chunks[4]; len(u.Choices) != 0 || u.Usage == nil || u.Usage.TotalTokens != 12 {
+		t.Errorf("usage chunk %s", frames[4])
+	}
+}
+
+func TestChatToolResults(t *testing.T) {
+	var got map[string]any
+	h := Middleware(upstream(t, "/api/chat", &got, http.StatusOK, `{"message":{"role":"assistant","content":"12 degrees"},"done":true}`))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{
+		"model": "llama3",
+		"tools": [{"type": "function", "function": {"name": "get_weather", "parameters": {"type": "object"}}}],
+		"messages": [
+			{"role": "user", "content": "weather in Oslo?"},
+			{"role": "assistant", "content": null, "tool_calls": [{"id": "call_1", "type": "function",
+				"function": {"name": "get_weather", "arguments": "{\"city\":\"Oslo\"}"}}]},
+			{"role": "tool", "tool_call_id": "call_1", "content": "12"}
+		]}`)))
+
+	msgs := got["messages"].([]any)
+	call := msgs[1].(map[string]any)["tool_calls"].([]any)[0].(map[string]any)["function"].(map[string]any)
+	if call["arguments"].(map[string]any)["city"] != "Oslo" || msgs[2].(map[string]any)["tool_name"] != "get_weather" || got["tools"] == nil {
+		t.Errorf("translated request %v", got)
+	}
+}
+
+func TestChatErrors(t *testing.T) {
+	var got map[string]any
+	h := Middleware(upstream(t, "/api/chat", &got, http.StatusNotFound, `{"error":"model \"nope\" not found, try pulling it first"}`))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(
+		`{"model":"nope","messages":[{"role":"user","content":"hi"}]}`)))
+	if rec.Code != http.StatusNotFound || !strings.Contains(rec.Body.String(), `"message":"model \"nope\" not found`) ||
+		!strings.Contains(rec.Body.String(), `"type":"invalid_request_error"`) {
+		t.Errorf("upstream error: %d %s", rec.Code, rec.Body)
+	}
+
+	for _, body := range []string{
+		`{"model":"llama3","messages":[]}`,
+		`{"model":"llama3","n":2,"messages":[{"role":"user","content":"hi"}]}`,
+		`{"model":"llama3","messages":[{"role":"user","content":[{"type":"image_url","image_url":{"url":"http://169.254.169.254/x.png"}}]}]}`,
+		`not json`,
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body)))
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), `"invalid_request_error"`) {
+			t.Errorf("%s: %d %s", body, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestPassThrough(t *testing.T) {
+	var path string
+	h := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { path = r.URL.Path }))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/v1/completions", strings.NewReader(`{}`)))
+	if path != "/v1/completions" {
+		t.Errorf("forwarded to %s", path)
+	}
+}
@@ -0,0 +1,127 @@
+package openai
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+
+	"github.com/yeti47/ollama-proxy/internal/ollama"
+	"github.com/yeti47/ollama-proxy/internal/translate"
+)
+
+type embeddingsRequest struct {
+	Model          string          `json:"model"`
+	Input          json.RawMessage `json:"input"` // a string or an array of strings
+	EncodingFormat string          `json:"encoding_format"`
+	Dimensions     *int            `json:"dimensions"`
+}
+
+type ollamaEmbedResponse struct {
+	Embeddings      [][]float64 `json:"embeddings"`
+	PromptEvalCount int         `json:"prompt_eval_count"`
+}
+
+type embedding struct {
+	Object    string `json:"object"`
+	Embedding any    `json:"embedding"` // []float64, or a base64 string
+	Index     int    `json:"index"`
+}
+
+// embeddingInputs accepts OpenAI's input: one string or a batch of them.
+// Pre-tokenized input (arrays of token IDs) can't be translated, since
+// the IDs are tokens of OpenAI's tokenizer, not the model's.
+func embeddingInputs(raw json.RawMessage) ([]string, error) {
+	var one string
+	if json.Unmarshal(raw, &one) == nil {
+		return []string{one}, nil
+	}
+	var many []string
+	if err := json.Unmarshal(raw, &many); err != nil {
+		return nil, fmt.Errorf("input must be a string or an array of strings; token arrays are not supported")
+	}
+	if len(many) == 0 {
+		return nil, fmt.Errorf("input must not be empty")
+	}
+	return many, nil
+}
+
+// toOllamaEmbed converts an OpenAI embeddings request to the body of an
+// /api/embed request. A batch stays one request: /api/embed takes an
+// array of inputs and returns the embeddings in the same order.
+func toOllamaEmbed(req *embeddingsRequest) ([]byte, error) {
+	if req.Model == "" {
+		return nil, fmt.Errorf("model is required")
+	}
+	inputs, err := embeddingInputs(req.Input)
+	if err != nil {
+		return nil, err
+	}
+	if req.EncodingFormat != "" && req.EncodingFormat != "float" && req.EncodingFormat != "base64" {
+		return nil, fmt.Errorf("unsupported encoding_format %q", req.EncodingFormat)
+	}
+	out := map[string]any{"model": req.Model, "input": inputs}
+	if req.Dimensions != nil {
+		out["dimensions"] = *req.Dimensions
+	}
+	return json.Marshal(out)
+}
+
+// encodeBase64 packs v as little-endian float32s, the format OpenAI uses
+// for encoding_format base64.
+func encodeBase64(v []float64) string {
+	b := make([]byte, 4*len(v))
+	for i, f := range v {
+		binary.LittleEndian.PutUint32(b[4*i:], math.Float32bits(float32(f)))
+	}
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// embeddingsResult converts an /api/embed response.
+func embeddingsResult(w http.ResponseWriter, req *embeddingsRequest, body []byte) {
+	var r ollamaEmbedResponse
+	if err := json.Unmarshal(body, &r); err != nil {
+		writeError(w, http.StatusBadGateway, "upstream sent an invalid embed response")
+		return
+	}
+	data := make([]embedding, len(r.Embeddings))
+	for i, e := range r.Embeddings {
+		data[i] = embedding{Object: "embedding", Embedding: e, Index: i}
+		if req.EncodingFormat == "base64" {
+			data[i].Embedding = encodeBase64(e)
+		}
+	}
+	b, _ := json.Marshal(map[string]any{
+		"object": "list",
+		"data":   data,
+		"model":  req.Model,
+		"usage":  map[string]int{"prompt_tokens": r.PromptEvalCount, "total_tokens": r.PromptEvalCount},
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(b)
+}
+
+// embeddings serves /v1/embeddings through next's /api/embed.
+func embeddings(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	body, err := ollama.PeekBody(r)
+	if err != nil {
+		bodyError(w, err)
+		return
+	}
+	var req embeddingsRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return
+	}
+	b, err := toOllamaEmbed(&req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	t := translate.NewWriter(w, nil, writeError)
+	forward(t, r, next, "/api/embed", b)
+	t.Finish(func(body []byte) { embeddingsResult(w, &req, body) })
+}
@@ -0,0 +1,88 @@
+package openai
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestEmbeddings(t *testing.T) {
+	var got map[string]any
+	h := Middleware(upstream(t, "/api/embed", &got, http.StatusOK,
+		`{"model":"nomic-embed-text","embeddings":[[0.5,-1],[0.25,2]],"prompt_eval_count":7}`))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/embeddings", strings.NewReader(
+		`{"model":"nomic-embed-text","input":["first","second"],"dimensions":2}`)))
+
+	if in := got["input"].([]any); len(in) != 2 || in[1] != "second" || got["dimensions"] != 2.0 {
+		t.Errorf("translated request %v", got)
+	}
+	var resp struct {
+		Object string `json:"object"`
+		Data   []struct {
+			Object    string    `json:"object"`
+			Embedding []float64 `json:"embedding"`
+			Index     int       `json:"index"`
+		} `json:"data"`
+		Usage struct {
+			PromptTokens int `json:"prompt_tokens"`
+			TotalTokens  int `json:"total_tokens"`
+		} `json:"usage"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("%v: %s", err, rec.Body)
+	}
+	if resp.Object != "list" || len(resp.Data) != 2 || resp.Data[1].Index != 1 || resp.Data[1].Embedding[1] != 2 ||
+		resp.Data[0].Object != "embedding" || resp.Usage.PromptTokens != 7 || resp.Usage.TotalTokens != 7 {
+		t.Errorf("response %s", rec.Body)
+	}
+}
+
+func TestEmbeddingsBase64(t *testing.T) {
+	var got map[string]any
+	h := Middleware(upstream(t, "/api/embed", &got, http.StatusOK, `{"embeddings":[[0.5,-1]],"prompt_eval_count":2}`))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/embeddings", strings.NewReader(
+		`{"model":"nomic-embed-text","input":"hello","encoding_format":"base64"}`)))
+
+	if in := got["input"].([]any); len(in) != 1 || in[0] != "hello" {
+		t.Errorf("translated request %v", got)
+	}
+	var resp struct {
+		Data []struct {
+			Embedding string `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("%v: %s", err, rec.Body)
+	}
+	b, err := base64.StdEncoding.DecodeString(resp.Data[0].Embedding)
+	if err != nil || len(b) != 8 {
+		t.Fatalf("embedding %q: %v", resp.Data[0].Embedding, err)
+	}
+	if f := math.Float32frombits(binary.LittleEndian.Uint32(b[4:])); f != -1 {
+		t.Errorf("second value %v", f)
+	}
+}
+
+func TestEmbeddingsInvalid(t *testing.T) {
+	var got map[string]any
+	h := Middleware(upstream(t, "/api/embed", &got, http.StatusOK, `{}`))
+	for _, body := range []string{
+		`{"model":"nomic-embed-text","input":[]}`,
+		`{"model":"nomic-embed-text","input":[[101,2023]]}`,
+		`{"model":"nomic-embed-text","input":"x","encoding_format":"int8"}`,
+		`{"input":"x"}`,
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/embeddings", strings.NewReader(body)))
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), `"invalid_request_error"`) {
+			t.Errorf("%s: %d %s", body, rec.Code, rec.Body)
+		}
+	}
+}
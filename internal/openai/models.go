@@ -0,0 +1,71 @@
+package openai
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/yeti47/ollama-proxy/internal/ollama"
+	"github.com/yeti47/ollama-proxy/internal/translate"
+)
+
+type model struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	OwnedBy string `json:"owned_by"`
+}
+
+// toModel converts an /api/tags entry. Like Ollama's own OpenAI endpoint,
+// it names the namespace of a model as its owner, "library" for official
+// models.
+func toModel(name string, modified time.Time) model {
+	owner := "library"
+	if i := strings.LastIndex(name, "/"); i != -1 {
+		owner = name[:i]
+		if j := strings.LastIndex(owner, "/"); j != -1 {
+			owner = owner[j+1:] // drop a registry host
+		}
+	}
+	return model{ID: name, Object: "model", Created: modified.Unix(), OwnedBy: owner}
+}
+
+// models serves GET /v1/models and /v1/models/{id} from the upstream's
+// /api/tags listing.
+func models(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	id := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/v1/models"), "/")
+	t := translate.NewWriter(w, nil, writeError)
+	forward(t, r, next, "/api/tags", nil)
+	t.Finish(func(body []byte) {
+		var tags struct {
+			Models []struct {
+				Name       string    `json:"name"`
+				ModifiedAt time.Time `json:"modified_at"`
+			} `json:"models"`
+		}
+		if err := json.Unmarshal(body, &tags); err != nil {
+			writeError(w, http.StatusBadGateway, "upstream sent an invalid model list")
+			return
+		}
+		list := make([]model, 0, len(tags.Models))
+		for _, m := range tags.Models {
+			if id != "" && ollama.NormalizeModel(m.Name) != ollama.NormalizeModel(id) {
+				continue
+			}
+			list = append(list, toModel(m.Name, m.ModifiedAt))
+		}
+		var out any = map[string]any{"object": "list", "data": list}
+		if id != "" {
+			if len(list) == 0 {
+				writeError(w, http.StatusNotFound, "model '"+id+"' not found")
+				return
+			}
+			out = list[0]
+		}
+		b, _ := json.Marshal(out)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(b)
+	})
+}
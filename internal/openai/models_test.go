@@ -0,0 +1,50 @@
+package openai
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestModels(t *testing.T) {
+	h := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/api/tags" {
+			t.Errorf("forwarded %s %s", r.Method, r.URL.Path)
+		}
+		_, _ = io.WriteString(w, `{"models":[
+			{"name":"llama3:latest","modified_at":"2026-05-01T10:00:00Z","size":4661224676},
+			{"name":"registry.example.com/team/coder:7b","modified_at":"2026-06-01T10:00:00Z"}]}`)
+	}))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/models", nil))
+	var list struct {
+		Object string  `json:"object"`
+		Data   []model `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+		t.Fatalf("%v: %s", err, rec.Body)
+	}
+	want := []model{
+		{ID: "llama3:latest", Object: "model", Created: 1777629600, OwnedBy: "library"},
+		{ID: "registry.example.com/team/coder:7b", Object: "model", Created: 1780308000, OwnedBy: "team"},
+	}
+	if list.Object != "list" || len(list.Data) != 2 || list.Data[0] != want[0] || list.Data[1] != want[1] {
+		t.Errorf("models %s", rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/models/llama3", nil))
+	var m model
+	if err := json.Unmarshal(rec.Body.Bytes(), &m); err != nil || m != want[0] {
+		t.Errorf("model %s: %v", rec.Body, err)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/models/mistral", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("unknown model: %d %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,65 @@
+// Package openai translates requests in the OpenAI API dialect to Ollama's
+// native API and the responses back, so tools that only speak OpenAI can
+// use upstreams that serve /api/* only.
+package openai
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/yeti47/ollama-proxy/internal/translate"
+)
+
+// Middleware translates OpenAI requests to their Ollama counterparts
+// before they reach next, and next's responses back to OpenAI's format.
+// Other requests pass through untouched.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/v1/chat/completions":
+			chat(w, r, next)
+		case r.Method == http.MethodPost && r.URL.Path == "/v1/embeddings":
+			embeddings(w, r, next)
+		case r.Method == http.MethodGet && (r.URL.Path == "/v1/models" || strings.HasPrefix(r.URL.Path, "/v1/models/")):
+			models(w, r, next)
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// writeError sends an error in OpenAI's format.
+func writeError(w http.ResponseWriter, status int, msg string) {
+	typ := "invalid_request_error"
+	switch {
+	case status == http.StatusUnauthorized:
+		typ = "authentication_error"
+	case status == http.StatusForbidden:
+		typ = "permission_error"
+	case status == http.StatusTooManyRequests:
+		typ = "rate_limit_error"
+	case status >= 500:
+		typ = "server_error"
+	}
+	b, _ := json.Marshal(map[string]any{"error": map[string]any{"message": msg, "type": typ, "param": nil, "code": nil}})
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+	w.WriteHeader(status)
+	_, _ = w.Write(b)
+}
+
+// bodyError is apierror.BodyError in OpenAI's format.
+func bodyError(w http.ResponseWriter, err error) {
+	translate.BodyError(w, err, writeError)
+}
+
+// forward sends body to path on next in place of r.
+func forward(w http.ResponseWriter, r *http.Request, next http.Handler, path string, body []byte) {
+	endpoint := r.URL.Path
+	if strings.HasPrefix(endpoint, "/v1/models/") {
+		endpoint = "/v1/models"
+	}
+	translate.Forward(w, r, next, endpoint, path, body)
+}
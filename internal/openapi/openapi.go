@@ -0,0 +1,277 @@
+// Package openapi describes the HTTP surface of the proxy as an OpenAPI
+// 3.1 document, for client generators and API gateways. The document is
+// built from the features a Surface enables, so it lists what this
+// instance actually serves.
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Path is where the document is served.
+const Path = "/openapi.json"
+
+// Surface is what the running proxy serves beyond the Ollama API.
+type Surface struct {
+	Version string
+	// ClientAuth is set when the API needs a client key.
+	ClientAuth bool
+	OpenAI     bool
+	Anthropic  bool
+	GRPC       bool
+	PullCache  bool
+	// Admin is set when the admin API is enabled, on this listener or on
+	// -admin-listen.
+	Admin bool
+	// KeyStore, Pprof, HAR, RequestCapture and ResponseCache add their
+	// admin endpoints.
+	KeyStore       bool
+	Pprof          bool
+	HAR            bool
+	RequestCapture bool
+	ResponseCache  bool
+}
+
+// operation is one method on a path.
+type operation struct {
+	tag, summary string
+	// request and response name schemas of JSON bodies.
+	request, response string
+	// stream marks responses that may come as NDJSON instead.
+	stream bool
+	// sse marks responses that may come as server-sent events.
+	sse bool
+	// status is the success status; 200 by default.
+	status int
+	// raw is the content type of non-JSON responses.
+	raw    string
+	params []param
+	// security is "client", "admin" or empty for public endpoints.
+	security string
+}
+
+type param struct{ name, in, description string }
+
+// route places an operation.
+type route struct {
+	method, path string
+	o            operation
+}
+
+func (o operation) render() map[string]any {
+	op := map[string]any{"tags": []string{o.tag}, "summary": o.summary}
+	if o.request != "" {
+		op["requestBody"] = map[string]any{
+			"required": true,
+			"content":  map[string]any{"application/json": map[string]any{"schema": ref(o.request)}},
+		}
+	}
+	if len(o.params) > 0 {
+		var ps []any
+		for _, p := range o.params {
+			ps = append(ps, map[string]any{
+				"name":        p.name,
+				"in":          p.in,
+				"required":    p.in == "path",
+				"description": p.description,
+				"schema":      map[string]any{"type": "string"},
+			})
+		}
+		op["parameters"] = ps
+	}
+	ok := map[string]any{"description": "OK"}
+	content := map[string]any{}
+	switch {
+	case o.raw != "":
+		content[o.raw] = map[string]any{"schema": map[string]any{"type": "string"}}
+	case o.response != "":
+		content["application/json"] = map[string]any{"schema": ref(o.response)}
+	}
+	if o.stream {
+		content["application/x-ndjson"] = map[string]any{"schema": ref(o.response)}
+	}
+	if o.sse {
+		content["text/event-stream"] = map[string]any{"schema": map[string]any{"type": "string"}}
+	}
+	if len(content) > 0 {
+		ok["content"] = content
+	}
+	status := o.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	op["responses"] = map[string]any{
+		strconv.Itoa(status): ok,
+		"default": map[string]any{
+			"description": "Error",
+			"content":     map[string]any{"application/json": map[string]any{"schema": ref("Error")}},
+		},
+	}
+	switch o.security {
+	case "client":
+		op["security"] = []any{map[string]any{"clientKey": []string{}}}
+	case "admin":
+		op["security"] = []any{map[string]any{"adminToken": []string{}}}
+	default:
+		op["security"] = []any{}
+	}
+	return op
+}
+
+func ref(schema string) map[string]any {
+	return map[string]any{"$ref": "#/components/schemas/" + schema}
+}
+
+// Build returns the document for s.
+func Build(s Surface) map[string]any {
+	paths := map[string]map[string]any{}
+	add := func(method, path string, o operation) {
+		if paths[path] == nil {
+			paths[path] = map[string]any{}
+		}
+		paths[path][strings.ToLower(method)] = o.render()
+	}
+	client := ""
+	if s.ClientAuth {
+		client = "client"
+	}
+
+	for _, e := range []route{
+		{"POST", "/api/chat", operation{summary: "Chat with a model", request: "ChatRequest", response: "ChatResponse", stream: true}},
+		{"POST", "/api/generate", operation{summary: "Generate a completion", request: "GenerateRequest", response: "GenerateResponse", stream: true}},
+		{"POST", "/api/embed", operation{summary: "Embed inputs", request: "EmbedRequest", response: "EmbedResponse"}},
+		{"POST", "/api/embeddings", operation{summary: "Embed a prompt (legacy)", request: "EmbeddingsRequest", response: "EmbeddingsResponse"}},
+		{"GET", "/api/tags", operation{summary: "List local models", response: "ModelList"}},
+		{"GET", "/api/ps", operation{summary: "List running models", response: "ModelList"}},
+		{"GET", "/api/version", operation{summary: "Upstream version", response: "Version"}},
+		{"POST", "/api/show", operation{summary: "Show model information", request: "ModelRequest", response: "Object"}},
+		{"POST", "/api/pull", operation{summary: "Pull a model", request: "PullRequest", response: "Progress", stream: true}},
+		{"POST", "/api/push", operation{summary: "Push a model", request: "PullRequest", response: "Progress", stream: true}},
+		{"POST", "/api/create", operation{summary: "Create a model", request: "Object", response: "Progress", stream: true}},
+		{"POST", "/api/copy", operation{summary: "Copy a model", request: "CopyRequest"}},
+		{"DELETE", "/api/delete", operation{summary: "Delete a model", request: "ModelRequest"}},
+	} {
+		e.o.tag, e.o.security = "Ollama", client
+		add(e.method, e.path, e.o)
+	}
+	add("GET", "/proxy/quota", operation{tag: "Proxy", summary: "Token quota of the calling key", response: "Object", security: client})
+
+	if s.OpenAI {
+		for _, e := range []route{
+			{"POST", "/v1/chat/completions", operation{summary: "OpenAI chat completions", request: "OpenAIChatRequest", response: "Object", sse: true}},
+			{"POST", "/v1/embeddings", operation{summary: "OpenAI embeddings", request: "OpenAIEmbeddingsRequest", response: "Object"}},
+			{"GET", "/v1/models", operation{summary: "OpenAI model list", response: "Object"}},
+			{"GET", "/v1/models/{model}", operation{summary: "OpenAI model", response: "Object", params: []param{{"model", "path", "Model name"}}}},
+		} {
+			e.o.tag, e.o.security = "OpenAI", client
+			add(e.method, e.path, e.o)
+		}
+	}
+	if s.Anthropic {
+		add("POST", "/v1/messages", operation{tag: "Anthropic", summary: "Anthropic messages", request: "AnthropicMessagesRequest", response: "Object", sse: true, security: client})
+	}
+
+	add("GET", "/healthz", operation{tag: "Health", summary: "Liveness", raw: "text/plain"})
+	add("GET", "/readyz", operation{tag: "Health", summary: "Readiness: the upstream answers", raw: "text/plain"})
+	add("GET", "/metrics", operation{tag: "Proxy", summary: "Prometheus metrics", raw: "text/plain"})
+	add("GET", "/stats", operation{tag: "Proxy", summary: "Traffic statistics", response: "Object"})
+	add("GET", "/proxy/info", operation{tag: "Proxy", summary: "Build information", response: "Object"})
+	add("GET", Path, operation{tag: "Proxy", summary: "This document", response: "Object"})
+
+	if s.PullCache {
+		name := param{"name", "path", "Repository, e.g. library/llama3"}
+		add("GET", "/v2/{name}/manifests/{reference}", operation{tag: "Registry", summary: "Model manifest from the registry", response: "Object",
+			params: []param{name, {"reference", "path", "Tag or digest"}}})
+		add("GET", "/v2/{name}/blobs/{digest}", operation{tag: "Registry", summary: "Model layer, from the pull cache when held", raw: "application/octet-stream",
+			params: []param{name, {"digest", "path", "sha256:..."}, {"Range", "header", "Byte range"}}})
+	}
+
+	if s.Admin {
+		id := []param{{"id", "path", ""}}
+		admin := []route{
+			{"GET", "/admin/log-level", operation{summary: "Show the log level", response: "Object"}},
+			{"PUT", "/admin/log-level", operation{summary: "Change the log level", request: "Object", response: "Object"}},
+			{"GET", "/admin/config", operation{summary: "Effective configuration", response: "Object"}},
+			{"GET", "/admin/requests", operation{summary: "List requests in flight", response: "Object"}},
+			{"DELETE", "/admin/requests/{id}", operation{summary: "Cancel a request", response: "Object", params: id}},
+			{"GET", "/admin/usage", operation{summary: "Usage per key", response: "Object",
+				params: []param{{"key", "query", "Key name"}, {"window", "query", "Window such as 24h or 7d"}}}},
+			{"GET", "/admin/usage/export", operation{summary: "Usage per key and model", raw: "text/csv",
+				params: []param{{"key", "query", "Key name"}, {"from", "query", "Start date"}, {"to", "query", "End date"}, {"format", "query", "csv or json"}}}},
+		}
+		if s.KeyStore {
+			admin = append(admin, []route{
+				{"GET", "/admin/keys", operation{summary: "List client keys", response: "Object"}},
+				{"POST", "/admin/keys", operation{summary: "Create a client key", request: "Object", response: "Object", status: http.StatusCreated}},
+				{"GET", "/admin/keys/{id}", operation{summary: "Show a client key", response: "Object", params: id}},
+				{"PATCH", "/admin/keys/{id}", operation{summary: "Annotate a client key", request: "Object", response: "Object", params: id}},
+				{"DELETE", "/admin/keys/{id}", operation{summary: "Revoke a client key", response: "Object", params: id}},
+			}...)
+		}
+		if s.HAR {
+			admin = append(admin, []route{
+				{"GET", "/admin/capture/har", operation{summary: "HAR capture status", response: "Object"}},
+				{"POST", "/admin/capture/har", operation{summary: "Start a HAR capture", response: "Object", params: []param{{"duration", "query", "Such as 5m"}}}},
+				{"DELETE", "/admin/capture/har", operation{summary: "Stop the HAR capture", response: "Object"}},
+			}...)
+		}
+		if s.RequestCapture {
+			admin = append(admin, []route{
+				{"GET", "/admin/capture/requests", operation{summary: "Per-request capture status", response: "Object"}},
+				{"PUT", "/admin/capture/requests", operation{summary: "Turn per-request capture on or off", request: "Object", response: "Object"}},
+			}...)
+		}
+		if s.ResponseCache {
+			admin = append(admin, route{"DELETE", "/admin/cache", operation{summary: "Empty the response cache", status: http.StatusNoContent}})
+		}
+		if s.Pprof {
+			admin = append(admin, route{"GET", "/admin/debug/pprof/{profile}", operation{summary: "Runtime profiles", raw: "application/octet-stream", params: []param{{"profile", "path", "heap, goroutine, profile, trace, ..."}}}})
+		}
+		for _, e := range admin {
+			e.o.tag, e.o.security = "Admin", "admin"
+			add(e.method, e.path, e.o)
+		}
+	}
+
+	var components map[string]any
+	if err := json.Unmarshal([]byte(schemas), &components); err != nil {
+		panic("openapi: invalid schemas: " + err.Error())
+	}
+	description := "Ollama API behind ollama-proxy."
+	if s.GRPC {
+		description += " A gRPC service, ollama.proxy.v1.Ollama, is served on the same listener; see proto/ollama/v1/ollama.proto."
+	}
+	doc := map[string]any{
+		"openapi": "3.1.0",
+		"info":    map[string]any{"title": "ollama-proxy", "version": s.Version, "description": description},
+		"paths":   paths,
+		"components": map[string]any{
+			"schemas": components,
+			"securitySchemes": map[string]any{
+				"clientKey":  map[string]any{"type": "http", "scheme": "bearer", "description": "A client key"},
+				"adminToken": map[string]any{"type": "http", "scheme": "bearer", "description": "The admin token"},
+			},
+		},
+	}
+	return doc
+}
+
+// Handler serves the document for s.
+func Handler(s Surface) http.Handler {
+	b, err := json.MarshalIndent(Build(s), "", "  ")
+	if err != nil {
+		panic("openapi: " + err.Error())
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(b)
+	})
+}
@@ -0,0 +1,89 @@
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// refs collects every $ref in v.
+func refs(v any, out map[string]bool) {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, e := range v {
+			if s, ok := e.(string); ok && k == "$ref" {
+				out[s] = true
+			}
+			refs(e, out)
+		}
+	case []any:
+		for _, e := range v {
+			refs(e, out)
+		}
+	}
+}
+
+func document(t *testing.T, s Surface) map[string]any {
+	t.Helper()
+	w := httptest.NewRecorder()
+	Handler(s).ServeHTTP(w, httptest.NewRequest(http.MethodGet, Path, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d", w.Code)
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func TestRefsResolve(t *testing.T) {
+	doc := document(t, Surface{Version: "v1", ClientAuth: true, OpenAI: true, Anthropic: true, PullCache: true, Admin: true, KeyStore: true, Pprof: true, HAR: true, RequestCapture: true})
+	schemas := doc["components"].(map[string]any)["schemas"].(map[string]any)
+	found := map[string]bool{}
+	refs(doc, found)
+	for r := range found {
+		name := strings.TrimPrefix(r, "#/components/schemas/")
+		if _, ok := schemas[name]; !ok {
+			t.Errorf("unresolved %s", r)
+		}
+	}
+	if doc["openapi"] != "3.1.0" || doc["info"].(map[string]any)["version"] != "v1" {
+		t.Errorf("header = %v %v", doc["openapi"], doc["info"])
+	}
+}
+
+func TestSurface(t *testing.T) {
+	paths := func(s Surface) map[string]any { return document(t, s)["paths"].(map[string]any) }
+
+	p := paths(Surface{})
+	for _, want := range []string{"/api/chat", "/api/tags", "/healthz", Path} {
+		if p[want] == nil {
+			t.Errorf("%s missing", want)
+		}
+	}
+	for _, absent := range []string{"/v1/chat/completions", "/v1/messages", "/admin/keys", "/v2/{name}/blobs/{digest}"} {
+		if p[absent] != nil {
+			t.Errorf("%s listed though disabled", absent)
+		}
+	}
+	chat := p["/api/chat"].(map[string]any)["post"].(map[string]any)
+	if sec := chat["security"].([]any); len(sec) != 0 {
+		t.Errorf("open API requires %v", sec)
+	}
+
+	p = paths(Surface{ClientAuth: true, OpenAI: true, Admin: true, KeyStore: true})
+	if p["/v1/chat/completions"] == nil || p["/admin/keys/{id}"] == nil {
+		t.Error("enabled endpoints missing")
+	}
+	chat = p["/api/chat"].(map[string]any)["post"].(map[string]any)
+	if sec := chat["security"].([]any); len(sec) != 1 {
+		t.Errorf("client auth not required: %v", sec)
+	}
+	keys := p["/admin/keys"].(map[string]any)["post"].(map[string]any)
+	if _, ok := keys["responses"].(map[string]any)["201"]; !ok {
+		t.Errorf("key creation responses = %v", keys["responses"])
+	}
+}
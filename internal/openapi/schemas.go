@@ -0,0 +1,235 @@
+package openapi
+
+// schemas are the components shared by the operations. Request schemas
+// list the fields the proxy itself looks at or translates; upstreams
+// accept more, so objects stay open to other properties.
+const schemas = `{
+  "Error": {
+    "type": "object",
+    "properties": {"error": {"type": "string"}},
+    "required": ["error"]
+  },
+  "Message": {
+    "type": "object",
+    "properties": {
+      "role": {"type": "string", "enum": ["system", "user", "assistant", "tool"]},
+      "content": {"type": "string"},
+      "thinking": {"type": "string"},
+      "images": {"type": "array", "items": {"type": "string", "format": "byte"}},
+      "tool_calls": {"type": "array", "items": {"$ref": "#/components/schemas/ToolCall"}},
+      "tool_name": {"type": "string"}
+    },
+    "required": ["role"]
+  },
+  "ToolCall": {
+    "type": "object",
+    "properties": {
+      "function": {
+        "type": "object",
+        "properties": {
+          "name": {"type": "string"},
+          "arguments": {"type": "object"}
+        }
+      }
+    }
+  },
+  "Options": {
+    "type": "object",
+    "description": "Model parameters such as temperature, top_p, num_ctx, num_predict, seed and stop.",
+    "additionalProperties": true
+  },
+  "Format": {
+    "description": "\"json\", or a JSON schema the response must follow.",
+    "oneOf": [{"type": "string", "enum": ["json"]}, {"type": "object"}]
+  },
+  "ChatRequest": {
+    "type": "object",
+    "properties": {
+      "model": {"type": "string"},
+      "messages": {"type": "array", "items": {"$ref": "#/components/schemas/Message"}},
+      "tools": {"type": "array", "items": {"type": "object"}},
+      "format": {"$ref": "#/components/schemas/Format"},
+      "options": {"$ref": "#/components/schemas/Options"},
+      "stream": {"type": "boolean", "default": true},
+      "think": {"type": "boolean"},
+      "keep_alive": {"oneOf": [{"type": "string"}, {"type": "number"}]}
+    },
+    "required": ["model"]
+  },
+  "Usage": {
+    "type": "object",
+    "properties": {
+      "done": {"type": "boolean"},
+      "done_reason": {"type": "string"},
+      "total_duration": {"type": "integer"},
+      "load_duration": {"type": "integer"},
+      "prompt_eval_count": {"type": "integer"},
+      "prompt_eval_duration": {"type": "integer"},
+      "eval_count": {"type": "integer"},
+      "eval_duration": {"type": "integer"}
+    }
+  },
+  "ChatResponse": {
+    "description": "One response, or one line of an NDJSON stream; the last has done set and the token counts.",
+    "allOf": [
+      {"$ref": "#/components/schemas/Usage"},
+      {
+        "type": "object",
+        "properties": {
+          "model": {"type": "string"},
+          "created_at": {"type": "string", "format": "date-time"},
+          "message": {"$ref": "#/components/schemas/Message"}
+        }
+      }
+    ]
+  },
+  "GenerateRequest": {
+    "type": "object",
+    "properties": {
+      "model": {"type": "string"},
+      "prompt": {"type": "string"},
+      "suffix": {"type": "string"},
+      "system": {"type": "string"},
+      "images": {"type": "array", "items": {"type": "string", "format": "byte"}},
+      "format": {"$ref": "#/components/schemas/Format"},
+      "options": {"$ref": "#/components/schemas/Options"},
+      "stream": {"type": "boolean", "default": true},
+      "raw": {"type": "boolean"},
+      "think": {"type": "boolean"},
+      "keep_alive": {"oneOf": [{"type": "string"}, {"type": "number"}]}
+    },
+    "required": ["model"]
+  },
+  "GenerateResponse": {
+    "description": "One response, or one line of an NDJSON stream; the last has done set and the token counts.",
+    "allOf": [
+      {"$ref": "#/components/schemas/Usage"},
+      {
+        "type": "object",
+        "properties": {
+          "model": {"type": "string"},
+          "created_at": {"type": "string", "format": "date-time"},
+          "response": {"type": "string"},
+          "thinking": {"type": "string"},
+          "context": {"type": "array", "items": {"type": "integer"}}
+        }
+      }
+    ]
+  },
+  "EmbedRequest": {
+    "type": "object",
+    "properties": {
+      "model": {"type": "string"},
+      "input": {"oneOf": [{"type": "string"}, {"type": "array", "items": {"type": "string"}}]},
+      "truncate": {"type": "boolean"},
+      "options": {"$ref": "#/components/schemas/Options"}
+    },
+    "required": ["model", "input"]
+  },
+  "EmbedResponse": {
+    "type": "object",
+    "properties": {
+      "model": {"type": "string"},
+      "embeddings": {"type": "array", "items": {"type": "array", "items": {"type": "number"}}},
+      "prompt_eval_count": {"type": "integer"}
+    }
+  },
+  "EmbeddingsRequest": {
+    "type": "object",
+    "properties": {"model": {"type": "string"}, "prompt": {"type": "string"}},
+    "required": ["model", "prompt"]
+  },
+  "EmbeddingsResponse": {
+    "type": "object",
+    "properties": {"embedding": {"type": "array", "items": {"type": "number"}}}
+  },
+  "ModelRequest": {
+    "type": "object",
+    "properties": {"model": {"type": "string"}},
+    "required": ["model"]
+  },
+  "CopyRequest": {
+    "type": "object",
+    "properties": {"source": {"type": "string"}, "destination": {"type": "string"}},
+    "required": ["source", "destination"]
+  },
+  "PullRequest": {
+    "type": "object",
+    "properties": {
+      "model": {"type": "string"},
+      "insecure": {"type": "boolean"},
+      "stream": {"type": "boolean", "default": true}
+    },
+    "required": ["model"]
+  },
+  "Progress": {
+    "type": "object",
+    "properties": {
+      "status": {"type": "string"},
+      "digest": {"type": "string"},
+      "total": {"type": "integer"},
+      "completed": {"type": "integer"}
+    }
+  },
+  "Model": {
+    "type": "object",
+    "properties": {
+      "name": {"type": "string"},
+      "model": {"type": "string"},
+      "modified_at": {"type": "string", "format": "date-time"},
+      "size": {"type": "integer"},
+      "digest": {"type": "string"},
+      "details": {"type": "object"}
+    }
+  },
+  "ModelList": {
+    "type": "object",
+    "properties": {"models": {"type": "array", "items": {"$ref": "#/components/schemas/Model"}}}
+  },
+  "Version": {
+    "type": "object",
+    "properties": {"version": {"type": "string"}}
+  },
+  "Object": {
+    "type": "object",
+    "additionalProperties": true
+  },
+  "OpenAIChatRequest": {
+    "type": "object",
+    "properties": {
+      "model": {"type": "string"},
+      "messages": {"type": "array", "items": {"type": "object"}},
+      "tools": {"type": "array", "items": {"type": "object"}},
+      "response_format": {"type": "object"},
+      "stream": {"type": "boolean"},
+      "temperature": {"type": "number"},
+      "top_p": {"type": "number"},
+      "max_tokens": {"type": "integer"},
+      "seed": {"type": "integer"},
+      "stop": {"oneOf": [{"type": "string"}, {"type": "array", "items": {"type": "string"}}]}
+    },
+    "required": ["model", "messages"]
+  },
+  "OpenAIEmbeddingsRequest": {
+    "type": "object",
+    "properties": {
+      "model": {"type": "string"},
+      "input": {"oneOf": [{"type": "string"}, {"type": "array", "items": {"type": "string"}}]}
+    },
+    "required": ["model", "input"]
+  },
+  "AnthropicMessagesRequest": {
+    "type": "object",
+    "properties": {
+      "model": {"type": "string"},
+      "system": {"oneOf": [{"type": "string"}, {"type": "array", "items": {"type": "object"}}]},
+      "messages": {"type": "array", "items": {"type": "object"}},
+      "tools": {"type": "array", "items": {"type": "object"}},
+      "max_tokens": {"type": "integer"},
+      "stream": {"type": "boolean"},
+      "temperature": {"type": "number"},
+      "stop_sequences": {"type": "array", "items": {"type": "string"}}
+    },
+    "required": ["model", "messages", "max_tokens"]
+  }
+}`
@@ -0,0 +1,274 @@
+// Package params enforces limits on the options of requests, such as a
+// ceiling on num_predict or a temperature range, so one client's settings
+// can't tie up a shared backend. Out-of-range values are clamped or the
+// request is rejected, per policy.
+package params
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/yeti47/ollama-proxy/internal/access"
+	"github.com/yeti47/ollama-proxy/internal/apierror"
+	"github.com/yeti47/ollama-proxy/internal/auth"
+	"github.com/yeti47/ollama-proxy/internal/metrics"
+	"github.com/yeti47/ollama-proxy/internal/ollama"
+)
+
+// ReportHeader is set on responses to requests whose options were
+// changed. It lists the changes, e.g. "num_predict=4096, mirostat removed".
+const ReportHeader = "X-Proxy-Clamped"
+
+var enforced = metrics.NewCounter("ollama_proxy_parameter_policy_total",
+	"Request options clamped, defaulted, removed or rejected by a parameter policy, by policy, option and action.",
+	"policy", "option", "action")
+
+// paths are the endpoints whose requests take options.
+var paths = map[string]bool{
+	"/api/chat": true, "/api/generate": true, "/api/embed": true, "/api/embeddings": true,
+}
+
+// Policy limits request options, read from the "parameter_policies"
+// section of the config file.
+type Policy struct {
+	// Name labels the policy in errors, logs and metrics; it defaults to
+	// its index.
+	Name string `json:"name,omitempty"`
+	// Models and Clients restrict the policy as in request rewrites.
+	Models  []string `json:"models,omitempty"`
+	Clients []string `json:"clients,omitempty"`
+	// Options limits numeric options by name, e.g. "num_predict".
+	Options map[string]Range `json:"options,omitempty"`
+	// Banned options are removed, or rejected.
+	Banned []string `json:"banned,omitempty"`
+	// Action is "clamp" (the default) or "reject".
+	Action string `json:"action,omitempty"`
+}
+
+// Range bounds a numeric option. Default is set when a request doesn't
+// give the option; it is not checked against Min and Max.
+type Range struct {
+	Min     *float64 `json:"min,omitempty"`
+	Max     *float64 `json:"max,omitempty"`
+	Default *float64 `json:"default,omitempty"`
+}
+
+// Policies is a checked, ordered set of policies.
+type Policies struct {
+	policies []Policy
+}
+
+// New checks policies.
+func New(policies []Policy) (*Policies, error) {
+	ps := &Policies{}
+	for i, p := range policies {
+		if p.Name == "" {
+			p.Name = "#" + strconv.Itoa(i)
+		}
+		switch p.Action {
+		case "":
+			p.Action = "clamp"
+		case "clamp", "reject":
+		default:
+			return nil, fmt.Errorf("parameter policy %s: action must be clamp or reject, got %q", p.Name, p.Action)
+		}
+		if len(p.Options) == 0 && len(p.Banned) == 0 {
+			return nil, fmt.Errorf("parameter policy %s: no options or banned options", p.Name)
+		}
+		for name, r := range p.Options {
+			if r.Min != nil && r.Max != nil && *r.Min > *r.Max {
+				return nil, fmt.Errorf("parameter policy %s: %s: min is above max", p.Name, name)
+			}
+		}
+		ps.policies = append(ps.policies, p)
+	}
+	return ps, nil
+}
+
+// Len reports the number of policies.
+func (ps *Policies) Len() int {
+	if ps == nil {
+		return 0
+	}
+	return len(ps.policies)
+}
+
+// matches reports whether p applies to a request from id for model.
+func (p *Policy) matches(id *auth.Identity, model string) bool {
+	if len(p.Clients) > 0 {
+		found := false
+		for _, c := range p.Clients {
+			found = found || (id != nil && (c == id.Name || (id.Tenant != "" && c == id.Tenant)))
+		}
+		if !found {
+			return false
+		}
+	}
+	return len(p.Models) == 0 || access.ModelAllowed(p.Models, model)
+}
+
+// Middleware enforces the matching policies on requests to next.
+func (ps *Policies) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ps.Len() == 0 || r.Method != http.MethodPost || !paths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		body, err := ollama.PeekBody(r)
+		if err != nil {
+			apierror.BodyError(w, err)
+			return
+		}
+		var req map[string]json.RawMessage
+		if json.Unmarshal(body, &req) != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		var model string
+		_ = json.Unmarshal(req["model"], &model)
+		opts := map[string]json.RawMessage{}
+		if raw, ok := req["options"]; ok && string(raw) != "null" {
+			if json.Unmarshal(raw, &opts) != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		id := auth.FromContext(r.Context())
+		var changes []string
+		for i := range ps.policies {
+			p := &ps.policies[i]
+			if !p.matches(id, model) {
+				continue
+			}
+			c, msg := p.enforce(opts)
+			if msg != "" {
+				slog.InfoContext(r.Context(), "request rejected by parameter policy", "policy", p.Name, "reason", msg)
+				apierror.Write(w, http.StatusBadRequest, msg)
+				return
+			}
+			changes = append(changes, c...)
+		}
+		if len(changes) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(opts) > 0 {
+			req["options"], _ = marshal(opts)
+		} else {
+			delete(req, "options")
+		}
+		b, err := marshal(req)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ollama.ReplaceBody(r, b)
+		w.Header().Set(ReportHeader, strings.Join(changes, ", "))
+		slog.DebugContext(r.Context(), "request options clamped", "changes", changes)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// enforce applies p to opts, returning the changes made, or the reason
+// the request is rejected.
+func (p *Policy) enforce(opts map[string]json.RawMessage) (changes []string, reject string) {
+	for _, name := range p.Banned {
+		if _, ok := opts[name]; !ok {
+			continue
+		}
+		if p.Action == "reject" {
+			enforced.With(p.Name, name, "rejected").Inc()
+			return nil, fmt.Sprintf("option %s is not allowed", name)
+		}
+		delete(opts, name)
+		enforced.With(p.Name, name, "removed").Inc()
+		changes = append(changes, name+" removed")
+	}
+	names := make([]string, 0, len(p.Options))
+	for name := range p.Options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		rg := p.Options[name]
+		raw, ok := opts[name]
+		if !ok {
+			if rg.Default != nil {
+				opts[name] = number(*rg.Default)
+				enforced.With(p.Name, name, "defaulted").Inc()
+				changes = append(changes, name+"="+string(opts[name]))
+			}
+			continue
+		}
+		var v float64
+		if json.Unmarshal(raw, &v) != nil {
+			continue // not a number; the upstream will reject it
+		}
+		want, out := rg.clamp(name, v)
+		if !out {
+			continue
+		}
+		if p.Action == "reject" {
+			enforced.With(p.Name, name, "rejected").Inc()
+			return nil, fmt.Sprintf("option %s=%s is out of range%s", name, string(raw), rg.describe())
+		}
+		opts[name] = number(want)
+		enforced.With(p.Name, name, "clamped").Inc()
+		changes = append(changes, name+"="+string(opts[name]))
+	}
+	return changes, ""
+}
+
+// clamp returns v bounded by r and whether it was out of range. A negative
+// num_predict means no limit to Ollama, so it is above any Max.
+func (r Range) clamp(name string, v float64) (float64, bool) {
+	if name == "num_predict" && v < 0 && r.Max != nil {
+		return *r.Max, true
+	}
+	switch {
+	case r.Max != nil && v > *r.Max:
+		return *r.Max, true
+	case r.Min != nil && v < *r.Min:
+		return *r.Min, true
+	}
+	return v, false
+}
+
+func (r Range) describe() string {
+	f := func(v float64) string { return strconv.FormatFloat(v, 'g', -1, 64) }
+	switch {
+	case r.Min != nil && r.Max != nil:
+		return fmt.Sprintf(" (allowed: %s to %s)", f(*r.Min), f(*r.Max))
+	case r.Max != nil:
+		return fmt.Sprintf(" (max %s)", f(*r.Max))
+	case r.Min != nil:
+		return fmt.Sprintf(" (min %s)", f(*r.Min))
+	}
+	return ""
+}
+
+// number encodes v, as an integer when it is one.
+func number(v float64) json.RawMessage {
+	if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+		return json.RawMessage(strconv.FormatInt(int64(v), 10))
+	}
+	return json.RawMessage(strconv.FormatFloat(v, 'g', -1, 64))
+}
+
+// marshal encodes v without escaping <, > and &.
+func marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
@@ -0,0 +1,68 @@
+package params
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/yeti47/ollama-proxy/internal/auth"
+)
+
+func f(v float64) *float64 { return &v }
+
+func TestNew(t *testing.T) {
+	for _, p := range []Policy{
+		{Action: "drop", Banned: []string{"mirostat"}},
+		{},
+		{Options: map[string]Range{"temperature": {Min: f(2), Max: f(1)}}},
+	} {
+		if _, err := New([]Policy{p}); err == nil {
+			t.Errorf("%+v accepted", p)
+		}
+	}
+}
+
+func TestClamp(t *testing.T) {
+	ps, err := New([]Policy{
+		{Name: "shared", Options: map[string]Range{
+			"num_predict": {Max: f(4096), Default: f(1024)},
+			"temperature": {Min: f(0), Max: f(1.5)},
+		}, Banned: []string{"num_gpu"}},
+		{Name: "batch", Clients: []string{"batch"}, Action: "reject", Options: map[string]Range{"num_ctx": {Max: f(8192)}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got string
+	h := ps.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		got = string(b)
+	}))
+	for _, c := range []struct {
+		client, body, want, report string
+		status                     int
+	}{
+		{"", `{"model":"llama3","prompt":"hi","options":{"num_predict":-1,"temperature":3,"num_gpu":99}}`,
+			`{"model":"llama3","options":{"num_predict":4096,"temperature":1.5},"prompt":"hi"}`,
+			"num_gpu removed, num_predict=4096, temperature=1.5", http.StatusOK},
+		{"", `{"model":"llama3","prompt":"hi"}`,
+			`{"model":"llama3","options":{"num_predict":1024},"prompt":"hi"}`, "num_predict=1024", http.StatusOK},
+		{"", `{"model":"llama3","prompt":"hi","options":{"num_predict":100,"temperature":0.7}}`,
+			`{"model":"llama3","prompt":"hi","options":{"num_predict":100,"temperature":0.7}}`, "", http.StatusOK},
+		{"batch", `{"model":"llama3","prompt":"hi","options":{"num_predict":100,"num_ctx":32768}}`, "", "", http.StatusBadRequest},
+	} {
+		got = ""
+		r := httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(c.body))
+		if c.client != "" {
+			r = r.WithContext(auth.WithIdentity(context.Background(), &auth.Identity{Name: c.client}))
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		if rec.Code != c.status || got != c.want || rec.Header().Get(ReportHeader) != c.report {
+			t.Errorf("%s: status %d, forwarded %s, report %q", c.body, rec.Code, got, rec.Header().Get(ReportHeader))
+		}
+	}
+}
@@ -0,0 +1,215 @@
+// Package prompttemplate wraps the user prompt of chat and generate
+// requests in configured Go templates, such as a retrieval preamble or
+// output-format instructions, just before they are forwarded.
+package prompttemplate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"path"
+	"strconv"
+	"text/template"
+
+	"github.com/yeti47/ollama-proxy/internal/access"
+	"github.com/yeti47/ollama-proxy/internal/auth"
+	"github.com/yeti47/ollama-proxy/internal/metrics"
+	"github.com/yeti47/ollama-proxy/internal/ollama"
+)
+
+var wrapped = metrics.NewCounter("ollama_proxy_prompt_templates_total",
+	"Prompts wrapped by a template before forwarding, by template.", "template")
+
+// Rule is one template, read from the "prompt_templates" section of the
+// config file.
+type Rule struct {
+	// Name labels the template in logs and metrics; it defaults to its
+	// index.
+	Name string `json:"name,omitempty"`
+	// Path matches /api/chat or /api/generate, with path.Match wildcards;
+	// by default both.
+	Path string `json:"path,omitempty"`
+	// Models and Clients restrict the template as in request rewrites.
+	Models  []string `json:"models,omitempty"`
+	Clients []string `json:"clients,omitempty"`
+	// Template is a text/template executed with Data; its output replaces
+	// the prompt.
+	Template string `json:"template"`
+}
+
+// Data is what a template sees.
+type Data struct {
+	// Prompt is the user prompt: the prompt of a generate request, or the
+	// content of the last user message of a chat.
+	Prompt string
+	Model  string
+	// Client and Tenant name the caller, if authenticated.
+	Client, Tenant string
+	// Path is the request path.
+	Path string
+}
+
+// Templates is a compiled, ordered set of templates.
+type Templates struct {
+	rules []*rule
+}
+
+type rule struct {
+	Rule
+	tmpl *template.Template
+}
+
+// New compiles rules.
+func New(rules []Rule) (*Templates, error) {
+	ts := &Templates{}
+	for i, r := range rules {
+		if r.Name == "" {
+			r.Name = "#" + strconv.Itoa(i)
+		}
+		if r.Path != "" {
+			if _, err := path.Match(r.Path, "/"); err != nil {
+				return nil, fmt.Errorf("prompt template %s: path %q: %w", r.Name, r.Path, err)
+			}
+		}
+		if r.Template == "" {
+			return nil, fmt.Errorf("prompt template %s: template is required", r.Name)
+		}
+		t, err := template.New(r.Name).Option("missingkey=error").Parse(r.Template)
+		if err == nil {
+			// catches misspelt fields, which only fail when executed
+			err = t.Execute(io.Discard, Data{})
+		}
+		if err != nil {
+			return nil, fmt.Errorf("prompt template %s: %w", r.Name, err)
+		}
+		ts.rules = append(ts.rules, &rule{Rule: r, tmpl: t})
+	}
+	return ts, nil
+}
+
+// Len reports the number of templates.
+func (ts *Templates) Len() int {
+	if ts == nil {
+		return 0
+	}
+	return len(ts.rules)
+}
+
+func (r *rule) matches(reqPath string, id *auth.Identity, model string) bool {
+	if r.Path != "" {
+		if ok, _ := path.Match(r.Path, reqPath); !ok {
+			return false
+		}
+	}
+	if len(r.Clients) > 0 {
+		found := false
+		for _, c := range r.Clients {
+			found = found || (id != nil && (c == id.Name || (id.Tenant != "" && c == id.Tenant)))
+		}
+		if !found {
+			return false
+		}
+	}
+	return len(r.Models) == 0 || access.ModelAllowed(r.Models, model)
+}
+
+// Apply wraps the prompt of r in the matching templates, in order; each
+// wraps the result of the one before. A template that fails leaves the
+// prompt as it was.
+func (ts *Templates) Apply(r *http.Request) {
+	if ts.Len() == 0 || r.Method != http.MethodPost || (r.URL.Path != "/api/chat" && r.URL.Path != "/api/generate") {
+		return
+	}
+	id := auth.FromContext(r.Context())
+	body, err := ollama.PeekBody(r)
+	if err != nil {
+		return
+	}
+	var req map[string]json.RawMessage
+	if json.Unmarshal(body, &req) != nil {
+		return
+	}
+	d := Data{Path: r.URL.Path}
+	_ = json.Unmarshal(req["model"], &d.Model)
+	if id != nil {
+		d.Client, d.Tenant = id.Name, id.Tenant
+	}
+	var rules []*rule
+	for _, t := range ts.rules {
+		if t.matches(r.URL.Path, id, d.Model) {
+			rules = append(rules, t)
+		}
+	}
+	if len(rules) == 0 {
+		return
+	}
+
+	// the prompt lives in "prompt", or in the content of the last user
+	// message
+	var messages []map[string]json.RawMessage
+	var target map[string]json.RawMessage
+	field := "prompt"
+	if r.URL.Path == "/api/chat" {
+		if json.Unmarshal(req["messages"], &messages) != nil {
+			return
+		}
+		for i := len(messages) - 1; i >= 0; i-- {
+			var role string
+			_ = json.Unmarshal(messages[i]["role"], &role)
+			if role == "user" {
+				target = messages[i]
+				break
+			}
+		}
+		if target == nil {
+			return
+		}
+		field = "content"
+	} else {
+		target = req
+	}
+	if json.Unmarshal(target[field], &d.Prompt) != nil {
+		return
+	}
+
+	var names []string
+	for _, t := range rules {
+		var buf bytes.Buffer
+		if err := t.tmpl.Execute(&buf, d); err != nil {
+			slog.WarnContext(r.Context(), "prompt template failed", "template", t.Name, "err", err)
+			continue
+		}
+		d.Prompt = buf.String()
+		names = append(names, t.Name)
+	}
+	if len(names) == 0 {
+		return
+	}
+	target[field], _ = marshal(d.Prompt)
+	if messages != nil {
+		req["messages"], _ = marshal(messages)
+	}
+	b, err := marshal(req)
+	if err != nil {
+		return
+	}
+	ollama.ReplaceBody(r, b)
+	for _, name := range names {
+		wrapped.With(name).Inc()
+	}
+	slog.DebugContext(r.Context(), "prompt wrapped", "templates", names)
+}
+
+// marshal encodes v without escaping <, > and &.
+func marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
@@ -0,0 +1,56 @@
+package prompttemplate
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/yeti47/ollama-proxy/internal/auth"
+)
+
+func TestNew(t *testing.T) {
+	for _, r := range []Rule{
+		{Name: "empty"},
+		{Template: "{{.Prompt"},
+		{Path: "[", Template: "{{.Prompt}}"},
+		{Template: "{{.Nope}}"},
+	} {
+		if _, err := New([]Rule{r}); err == nil {
+			t.Errorf("%+v accepted", r)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	ts, err := New([]Rule{
+		{Name: "rag", Clients: []string{"kb"}, Template: "Use the knowledge base.\n\n{{.Prompt}}"},
+		{Name: "json", Path: "/api/generate", Template: "{{.Prompt}}\n\nAnswer in JSON for {{.Model}}."},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		client, path, body, want string
+	}{
+		{"kb", "/api/chat", `{"model":"llama3","messages":[{"role":"user","content":"old"},{"role":"assistant","content":"a"},{"role":"user","content":"<q>"}]}`,
+			`{"messages":[{"content":"old","role":"user"},{"content":"a","role":"assistant"},{"content":"Use the knowledge base.\n\n<q>","role":"user"}],"model":"llama3"}`},
+		{"kb", "/api/generate", `{"model":"llama3","prompt":"q"}`,
+			`{"model":"llama3","prompt":"Use the knowledge base.\n\nq\n\nAnswer in JSON for llama3."}`},
+		{"", "/api/chat", `{"model":"llama3","messages":[{"role":"user","content":"q"}]}`,
+			`{"model":"llama3","messages":[{"role":"user","content":"q"}]}`},
+		{"", "/api/embed", `{"model":"llama3","input":"q"}`, `{"model":"llama3","input":"q"}`},
+	} {
+		r := httptest.NewRequest(http.MethodPost, c.path, strings.NewReader(c.body))
+		if c.client != "" {
+			r = r.WithContext(auth.WithIdentity(context.Background(), &auth.Identity{Name: c.client}))
+		}
+		ts.Apply(r)
+		b, _ := io.ReadAll(r.Body)
+		if string(b) != c.want {
+			t.Errorf("%s %s:\ngot  %s\nwant %s", c.client, c.path, b, c.want)
+		}
+	}
+}
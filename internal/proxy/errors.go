@@ -0,0 +1,82 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"syscall"
+
+	"github.com/yeti47/ollama-proxy/internal/metrics"
+)
+
+var errorsTotal = metrics.NewCounter("ollama_proxy_errors_total",
+	"Failed proxied requests by class: dns, dial_timeout, connect, tls, upstream_timeout, upstream_closed, "+
+		"upstream_5xx, client_cancel, stream_aborted, request_too_large or other.", "class")
+
+// classify returns the class of an error returned by the upstream
+// transport for r, for the class label of ollama_proxy_errors_total and
+// the error_class log field.
+func classify(r *http.Request, err error) string {
+	if r.Context().Err() == context.Canceled || errors.Is(err, context.Canceled) {
+		return "client_cancel"
+	}
+	var (
+		dnsErr      *net.DNSError
+		opErr       *net.OpError
+		tooLarge    *http.MaxBytesError
+		recordErr   tls.RecordHeaderError
+		alertErr    tls.AlertError
+		verifyErr   *tls.CertificateVerificationError
+		unknownAuth x509.UnknownAuthorityError
+		hostErr     x509.HostnameError
+		invalidErr  x509.CertificateInvalidError
+		netErr      net.Error
+	)
+	switch {
+	case errors.As(err, &tooLarge):
+		return "request_too_large"
+	case errors.As(err, &dnsErr):
+		return "dns"
+	case errors.As(err, &recordErr), errors.As(err, &alertErr), errors.As(err, &verifyErr),
+		errors.As(err, &unknownAuth), errors.As(err, &hostErr), errors.As(err, &invalidErr):
+		return "tls"
+	case errors.As(err, &opErr) && opErr.Op == "dial":
+		if opErr.Timeout() {
+			return "dial_timeout"
+		}
+		return "connect"
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return "upstream_timeout"
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, syscall.ECONNRESET):
+		return "upstream_closed"
+	}
+	return "other"
+}
+
+// abortReader watches a response body being relayed and classifies a
+// stream that ends with an error instead of EOF.
+type abortReader struct {
+	io.ReadCloser
+	req    *http.Request
+	failed bool
+}
+
+func (b *abortReader) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF && !b.failed {
+		b.failed = true
+		class := "stream_aborted"
+		if b.req.Context().Err() == context.Canceled {
+			class = "client_cancel"
+		}
+		errorsTotal.With(class).Inc()
+		slog.WarnContext(b.req.Context(), "response aborted mid-body", "method", b.req.Method, "path", b.req.URL.Path,
+			"error_class", class, "err", err)
+	}
+	return n, err
+}
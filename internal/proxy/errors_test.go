@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+type timeoutErr struct{}
+
+func (timeoutErr) Error() string   { return "i/o timeout" }
+func (timeoutErr) Timeout() bool   { return true }
+func (timeoutErr) Temporary() bool { return true }
+
+func TestClassify(t *testing.T) {
+	req := httptest.NewRequest("POST", "/api/chat", nil)
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, tc := range []struct {
+		err  error
+		r    *http.Request
+		want string
+	}{
+		{&net.OpError{Op: "dial", Err: &net.DNSError{Err: "no such host", Name: "ollama.invalid"}}, req, "dns"},
+		{&net.OpError{Op: "dial", Err: timeoutErr{}}, req, "dial_timeout"},
+		{&net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, req, "connect"},
+		{fmt.Errorf("tls: failed to verify certificate: %w", x509.UnknownAuthorityError{}), req, "tls"},
+		{&net.OpError{Op: "read", Err: timeoutErr{}}, req, "upstream_timeout"},
+		{context.DeadlineExceeded, req, "upstream_timeout"},
+		{io.ErrUnexpectedEOF, req, "upstream_closed"},
+		{&net.OpError{Op: "read", Err: os.NewSyscallError("read", syscall.ECONNRESET)}, req, "upstream_closed"},
+		{&http.MaxBytesError{Limit: 10}, req, "request_too_large"},
+		{errors.New("anything"), req.WithContext(cancelled), "client_cancel"},
+		{errors.New("anything"), req, "other"},
+	} {
+		if got := classify(tc.r, tc.err); got != tc.want {
+			t.Errorf("%v: got %s, want %s", tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestStreamAborted(t *testing.T) {
+	var logs bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	defer slog.SetDefault(prev)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "100")
+		_, _ = w.Write([]byte(`{"response":"Hel`))
+		// hang up halfway through the body
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		conn.Close()
+	}))
+	defer upstream.Close()
+	u, _ := url.Parse(upstream.URL)
+	p := New(u, Options{})
+	func() {
+		defer func() { _ = recover() }() // the proxy aborts the handler
+		p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/generate", strings.NewReader(`{}`)))
+	}()
+	if out := logs.String(); !strings.Contains(out, `msg="response aborted mid-body"`) || !strings.Contains(out, "error_class=stream_aborted") {
+		t.Errorf("abort not classified: %s", out)
+	}
+}
@@ -2,22 +2,33 @@ package proxy
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
-	"encoding/json"
+	"errors"
 	"io"
-	"log"
+	"log/slog"
 	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
-	"strconv"
 	"strings"
 	"time"
+
+	"github.com/yeti47/ollama-proxy/internal/apierror"
+	"github.com/yeti47/ollama-proxy/internal/attest"
+	"github.com/yeti47/ollama-proxy/internal/auth"
+	"github.com/yeti47/ollama-proxy/internal/logging"
+	"github.com/yeti47/ollama-proxy/internal/ollama"
+	"github.com/yeti47/ollama-proxy/internal/prompttemplate"
+	"github.com/yeti47/ollama-proxy/internal/redact"
+	"github.com/yeti47/ollama-proxy/internal/rewrite"
+	"github.com/yeti47/ollama-proxy/internal/tracing"
 )
 
 // maskSensitive replaces occurrences of the apiKey and bearer tokens in s
 // with a redacted placeholder. If apiKey is empty it still masks any
-// 'Bearer <token>' occurrences when logging headers.
+// 'Bearer <token>' occurrences when logging headers. Patterns configured
+// with Options.LogRedactor are applied on top.
 func maskSensitive(apiKey, s string) string {
 	if apiKey != "" {
 		s = strings.ReplaceAll(s, apiKey, "[REDACTED]")
@@ -46,17 +57,91 @@ func maskSensitive(apiKey, s string) string {
 	return s
 }
 
+// Options configures the reverse proxy returned by New.
+type Options struct {
+	// APIKey is injected as Authorization: Bearer <key> on upstream requests.
+	APIKey string
+	// APIKeyFunc, if set, is called for every request instead of using
+	// APIKey, so the key can be rotated while the proxy is running.
+	APIKeyFunc func() string
+	// KeyFeedback, if set, is called with the global upstream key and the
+	// upstream response for every request that used it, so a key pool can
+	// move away from rejected or rate-limited keys. Requests that used a
+	// tenant key are not reported.
+	KeyFeedback func(key string, resp *http.Response)
+	// PreserveAuth keeps a client-supplied Authorization header instead of
+	// overwriting it with APIKey.
+	PreserveAuth bool
+	// APIKeyHeader, if set, is the header the key is sent in as-is,
+	// instead of Authorization: Bearer <key>; Azure OpenAI uses api-key.
+	APIKeyHeader string
+	// VersionFallback replaces an invalid upstream /api/version value;
+	// see rewrite.VersionFix.
+	VersionFallback string
+	// Rewrites, if set, edit upstream JSON responses after the built-in
+	// version fixup.
+	Rewrites *rewrite.Rules
+	// RequestRewrites, if set, edit request bodies before forwarding.
+	RequestRewrites *rewrite.RequestRules
+	// PromptTemplates, if set, wrap the user prompt before forwarding,
+	// ahead of RequestRewrites.
+	PromptTemplates *prompttemplate.Templates
+	// TLSConfig is used for upstream connections. If nil a default config
+	// with TLS 1.2 as the minimum version is used.
+	TLSConfig *tls.Config
+	// OnUsage, if set, is called with the token usage reported at the end
+	// of a successful response, once the body has been fully relayed. r is
+	// the outgoing upstream request; its context carries the inbound
+	// request's values.
+	OnUsage func(r *http.Request, u ollama.Usage)
+	// AttestationSecret, if set, signs every forwarded request so the
+	// upstream can verify it came through the proxy; see package attest.
+	AttestationSecret []byte
+	// Tracer, if set, records a client span for every upstream request
+	// and propagates the trace to the upstream.
+	Tracer *tracing.Tracer
+	// Privacy, if set, keeps request and response bodies out of the logs
+	// at every level: upstream error responses are logged with status
+	// and headers only.
+	Privacy bool
+	// LogRedactor, if set, masks its patterns in the headers, bodies and
+	// URLs the proxy logs, in addition to API keys and bearer tokens.
+	LogRedactor *redact.Redactor
+	// WrapTransport, if set, wraps the upstream transport, e.g. to observe
+	// upstream health.
+	WrapTransport func(http.RoundTripper) http.RoundTripper
+}
+
 // NewReverseProxy returns a reverse proxy that forwards to target while
 // preserving path, headers and body. It sets Host and X-Forwarded-* headers
 // and uses a reasonable Transport with TLS verification enabled. It can also
 // inject an Authorization: Bearer <key> header if apiKey is provided.
 func NewReverseProxy(target *url.URL, apiKey string, preserveAuth bool, versionFallback string) *httputil.ReverseProxy {
+	return New(target, Options{APIKey: apiKey, PreserveAuth: preserveAuth, VersionFallback: versionFallback})
+}
+
+// New is like NewReverseProxy but takes the full set of Options.
+func New(target *url.URL, opts Options) *httputil.ReverseProxy {
+	preserveAuth, versionFallback := opts.PreserveAuth, opts.VersionFallback
+	apiKey := func() string { return opts.APIKey }
+	if opts.APIKeyFunc != nil {
+		apiKey = opts.APIKeyFunc
+	}
 	proxy := httputil.NewSingleHostReverseProxy(target)
+	if versionFallback == "" {
+		versionFallback = "0.15.2"
+	}
+	rewrites := rewrite.Join(rewrite.MustNew(rewrite.VersionFix(versionFallback)), opts.Rewrites)
+	basePath := strings.TrimSuffix(target.Path, "/")
 
 	const maxLogBody = 1 << 20 // 1MB
+	mask := func(s string) string { return opts.LogRedactor.Mask(maskSensitive(apiKey(), s)) }
 
 	orig := proxy.Director
 	proxy.Director = func(r *http.Request) {
+		// rules match the path as the client sent it
+		opts.PromptTemplates.Apply(r)
+		opts.RequestRewrites.Apply(r)
 		orig(r) // sets scheme/host/path
 		// Ensure Host header matches target host
 		r.Host = target.Host
@@ -72,9 +157,17 @@ func NewReverseProxy(target *url.URL, apiKey string, preserveAuth bool, versionF
 
 		// Authorization injection: inject apiKey as Bearer token by default,
 		// unless preserveAuth is true and client provided an Authorization header.
-		if apiKey != "" {
+		// A tenant-specific upstream key from inbound authentication takes
+		// precedence over the global key.
+		key := apiKey()
+		if id := auth.FromContext(r.Context()); id != nil && id.UpstreamKey != "" {
+			key = id.UpstreamKey
+		}
+		if key != "" && opts.APIKeyHeader != "" {
+			r.Header.Set(opts.APIKeyHeader, key)
+		} else if key != "" {
 			if !(preserveAuth && r.Header.Get("Authorization") != "") {
-				token := apiKey
+				token := key
 				if len(token) >= 7 && token[:7] == "Bearer " {
 					r.Header.Set("Authorization", token)
 				} else {
@@ -82,10 +175,35 @@ func NewReverseProxy(target *url.URL, apiKey string, preserveAuth bool, versionF
 				}
 			}
 		}
-
+		// pass the request id on so upstream logs can be correlated
+		if id := logging.RequestID(r.Context()); id != "" {
+			r.Header.Set(logging.RequestIDHeader, id)
+		}
+		slog.DebugContext(r.Context(), "upstream request", "method", r.Method, "url", mask(r.URL.String()))
+		tracing.FromContext(r.Context()).AddEvent("proxy.director")
 	}
 
 	proxy.ModifyResponse = func(resp *http.Response) error {
+		if resp.Request != nil {
+			tracing.FromContext(resp.Request.Context()).AddEvent("proxy.modify_response")
+			// the proxy already echoes the request id; don't send a second
+			// copy if the upstream echoes it too
+			if logging.RequestID(resp.Request.Context()) != "" {
+				resp.Header.Del(logging.RequestIDHeader)
+			}
+		}
+		if opts.KeyFeedback != nil && resp.Request != nil {
+			if id := auth.FromContext(resp.Request.Context()); id == nil || id.UpstreamKey == "" {
+				if opts.APIKeyHeader != "" {
+					if key := resp.Request.Header.Get(opts.APIKeyHeader); key != "" {
+						opts.KeyFeedback(key, resp)
+					}
+				} else if key, ok := strings.CutPrefix(resp.Request.Header.Get("Authorization"), "Bearer "); ok {
+					opts.KeyFeedback(key, resp)
+				}
+			}
+		}
+
 		// If upstream is using chunked transfer encoding, ensure we do not
 		// forward a Content-Length header which can confuse clients and lead
 		// to ERR_INCOMPLETE_CHUNKED_ENCODING when the lengths don't match.
@@ -109,88 +227,114 @@ func NewReverseProxy(target *url.URL, apiKey string, preserveAuth bool, versionF
 				break
 			}
 		}
-		if isChunked || resp.StatusCode >= 400 {
+		// successful streams are only sampled at debug level: reading the
+		// snippet holds back the first megabyte of the stream
+		if resp.StatusCode >= 400 || (isChunked && resp.Request != nil && slog.Default().Enabled(resp.Request.Context(), slog.LevelDebug)) {
 			// read up to maxLogBody bytes for logging and then restore the body
 			if resp.Body != nil {
-				snippetLimit := int64(maxLogBody)
-				b, _ := io.ReadAll(io.LimitReader(resp.Body, snippetLimit))
-				// mask sensitive content
-				bodySnippet := maskSensitive(apiKey, string(b))
+				var b []byte
+				if !opts.Privacy {
+					b, _ = io.ReadAll(io.LimitReader(resp.Body, int64(maxLogBody)))
+				}
 
 				// headers
 				var hdrs []string
 				for k, vv := range resp.Header {
 					hdrs = append(hdrs, k+": "+strings.Join(vv, ","))
 				}
-				headerStr := maskSensitive(apiKey, strings.Join(hdrs, "; "))
+				headerStr := mask(strings.Join(hdrs, "; "))
 
+				level := slog.LevelDebug
+				if resp.StatusCode >= 400 {
+					level = slog.LevelWarn
+				}
+				attrs := []any{"status", resp.StatusCode, "headers", headerStr}
+				if !opts.Privacy {
+					attrs = append(attrs, "body_snippet", mask(string(b)))
+				}
+				ctx := context.Background()
 				if resp.Request != nil {
-					log.Printf("upstream %s %s -> %d; headers=%s; body_snippet=%s",
-						resp.Request.Method, resp.Request.URL.String(), resp.StatusCode, headerStr, bodySnippet)
-				} else {
-					log.Printf("upstream -> %d; headers=%s; body_snippet=%s",
-						resp.StatusCode, headerStr, bodySnippet)
+					ctx = resp.Request.Context()
+					attrs = append(attrs, "method", resp.Request.Method, "url", mask(resp.Request.URL.String()))
 				}
+				slog.Log(ctx, level, "upstream response", attrs...)
 
 				// restore body so normal proxy behavior continues
-				resp.Body = io.NopCloser(io.MultiReader(bytes.NewReader(b), resp.Body))
+				if !opts.Privacy {
+					resp.Body = io.NopCloser(io.MultiReader(bytes.NewReader(b), resp.Body))
+				}
 			} else {
-				log.Printf("upstream: status=%d (no body)", resp.StatusCode)
+				slog.Warn("upstream response without body", "status", resp.StatusCode)
 			}
 		}
-		// Quick fix: if upstream /api/version returns an invalid version like
-		// "0.0.0" or "0.0.0.0", replace it with a compatible version
-		// (0.15.2) so clients that validate the version can continue.
-		if resp.Request != nil && strings.HasSuffix(resp.Request.URL.Path, "/api/version") {
-			if ct := resp.Header.Get("Content-Type"); strings.Contains(ct, "application/json") {
-				if resp.Body != nil {
-					b, err := io.ReadAll(resp.Body)
-					if err == nil {
-						var m map[string]any
-						if json.Unmarshal(b, &m) == nil {
-							fallback := versionFallback
-							if fallback == "" {
-								fallback = "0.15.2"
-							}
-							if v, ok := m["version"].(string); ok && (v == "0.0.0" || v == "0.0.0.0") {
-								m["version"] = fallback
-								nb, _ := json.Marshal(m)
-								resp.Body = io.NopCloser(bytes.NewReader(nb))
-								resp.ContentLength = int64(len(nb))
-								resp.Header.Set("Content-Length", strconv.Itoa(len(nb)))
-								// If upstream used chunked encoding, remove it to avoid
-								// conflicting headers when we set Content-Length.
-								resp.Header.Del("Transfer-Encoding")
-								resp.TransferEncoding = nil
-								log.Printf("fixed /api/version value to %s", fallback)
-							} else {
-								// restore original body
-								resp.Body = io.NopCloser(bytes.NewReader(b))
-							}
-						} else {
-							resp.Body = io.NopCloser(bytes.NewReader(b))
-						}
-					}
-				}
-			}
+		// the built-in /api/version fixup and any configured rules; paths
+		// are matched as the client sent them, without the target's prefix
+		if resp.Request != nil {
+			rewrites.Apply(resp, strings.TrimPrefix(resp.Request.URL.Path, basePath))
+		}
+
+		if resp.StatusCode >= 500 {
+			errorsTotal.With("upstream_5xx").Inc()
+		}
+		if resp.Body != nil && resp.Request != nil {
+			resp.Body = &abortReader{ReadCloser: resp.Body, req: resp.Request}
+		}
+
+		if opts.OnUsage != nil && resp.Body != nil && resp.Request != nil && resp.StatusCode < 300 {
+			req := resp.Request
+			resp.Body = ollama.NewUsageReader(resp.Body, func(u ollama.Usage) {
+				opts.OnUsage(req, u)
+			})
 		}
 
 		return nil
 	}
 
 	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
-		log.Printf("proxy error: %v", err)
+		// a request cancelled on purpose (e.g. from the admin API) is not
+		// an upstream failure
+		if r.Context().Err() != nil {
+			if cause := context.Cause(r.Context()); cause != context.Canceled {
+				slog.InfoContext(r.Context(), "request cancelled", "method", r.Method, "path", r.URL.Path, "cause", cause)
+				apierror.Write(w, http.StatusServiceUnavailable, "request cancelled: "+cause.Error())
+				return
+			}
+		}
+		class := classify(r, err)
+		errorsTotal.With(class).Inc()
+		level := slog.LevelError
+		if class == "client_cancel" {
+			// the client went away; not a proxy or upstream failure
+			level = slog.LevelInfo
+		}
+		slog.Log(r.Context(), level, "proxy error", "method", r.Method, "path", r.URL.Path, "error_class", class, "err", err)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			apierror.BodyError(w, err)
+			return
+		}
 		http.Error(w, "Bad Gateway", http.StatusBadGateway)
 	}
 
+	tlsConfig := opts.TLSConfig
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
 	proxy.Transport = &http.Transport{
 		Proxy:               http.ProxyFromEnvironment,
 		DialContext:         (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}).DialContext,
 		TLSHandshakeTimeout: 10 * time.Second,
 		IdleConnTimeout:     90 * time.Second,
 		MaxIdleConns:        100,
-		TLSClientConfig:     &tls.Config{MinVersion: tls.VersionTLS12},
+		TLSClientConfig:     tlsConfig,
+	}
+	if opts.WrapTransport != nil {
+		proxy.Transport = opts.WrapTransport(proxy.Transport)
+	}
+	if len(opts.AttestationSecret) > 0 {
+		proxy.Transport = &attest.Transport{Secret: opts.AttestationSecret, Next: proxy.Transport}
 	}
+	proxy.Transport = opts.Tracer.Transport(proxy.Transport)
 
 	return proxy
 }
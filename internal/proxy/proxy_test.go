@@ -1,13 +1,21 @@
 package proxy
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/yeti47/ollama-proxy/internal/auth"
+	"github.com/yeti47/ollama-proxy/internal/logging"
+	"github.com/yeti47/ollama-proxy/internal/redact"
 )
 
 func TestAuthorizationInjectionAndPreserve(t *testing.T) {
@@ -75,6 +83,68 @@ func TestAuthorizationInjectionAndPreserve(t *testing.T) {
 	})
 }
 
+func TestTenantUpstreamKey(t *testing.T) {
+	ch := make(chan string, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ch <- r.Header.Get("Authorization")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	u, _ := url.Parse(upstream.URL)
+	ks := auth.NewKeySet(nil)
+	ks.Add("client-a", auth.Identity{Tenant: "team-a", UpstreamKey: "sk-team-a"})
+	p := auth.Middleware(ks, NewReverseProxy(u, "sk-global", false, ""))
+	proxySrv := httptest.NewServer(p)
+	defer proxySrv.Close()
+
+	req, _ := http.NewRequest("GET", proxySrv.URL+"/api/tags", nil)
+	req.Header.Set("Authorization", "Bearer client-a")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("do error: %v", err)
+	}
+	resp.Body.Close()
+
+	select {
+	case got := <-ch:
+		want := "Bearer sk-team-a"
+		if got != want {
+			t.Fatalf("expected %q got %q", want, got)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("timeout waiting for upstream request")
+	}
+}
+
+func TestAPIKeyHeader(t *testing.T) {
+	ch := make(chan http.Header, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ch <- r.Header.Clone()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	u, _ := url.Parse(upstream.URL)
+	var reported string
+	p := New(u, Options{APIKey: "azure-key", APIKeyHeader: "api-key", KeyFeedback: func(key string, _ *http.Response) { reported = key }})
+	proxySrv := httptest.NewServer(p)
+	defer proxySrv.Close()
+
+	resp, err := http.Get(proxySrv.URL + "/openai/deployments/gpt/chat/completions")
+	if err != nil {
+		t.Fatalf("do error: %v", err)
+	}
+	resp.Body.Close()
+	h := <-ch
+	if h.Get("api-key") != "azure-key" || h.Get("Authorization") != "" {
+		t.Fatalf("upstream headers %v", h)
+	}
+	if reported != "azure-key" {
+		t.Fatalf("key feedback got %q", reported)
+	}
+}
+
 func TestVersionFixup(t *testing.T) {
 	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path == "/api/version" {
@@ -174,3 +244,162 @@ func TestStreamingResponsePreserved(t *testing.T) {
 		t.Fatalf("unexpected body: %q", string(b))
 	}
 }
+
+func TestKeyFeedback(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "Bearer sk-limited" {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	u, _ := url.Parse(upstream.URL)
+	keys := []string{"sk-limited", "sk-ok"}
+	var reported []string
+	p := New(u, Options{
+		APIKeyFunc: func() string { return keys[0] },
+		KeyFeedback: func(key string, resp *http.Response) {
+			reported = append(reported, fmt.Sprintf("%s=%d", key, resp.StatusCode))
+			if resp.StatusCode == http.StatusTooManyRequests {
+				keys = keys[1:]
+			}
+		},
+	})
+	for _, want := range []int{http.StatusTooManyRequests, http.StatusOK} {
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, httptest.NewRequest("GET", "/api/tags", nil))
+		if rec.Code != want {
+			t.Fatalf("status = %d, want %d", rec.Code, want)
+		}
+	}
+	if strings.Join(reported, ",") != "sk-limited=429,sk-ok=200" {
+		t.Fatalf("reported %v", reported)
+	}
+}
+
+func TestRequestIDForwarded(t *testing.T) {
+	ch := make(chan string, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ch <- r.Header.Get(logging.RequestIDHeader)
+		w.Header().Set(logging.RequestIDHeader, r.Header.Get(logging.RequestIDHeader))
+	}))
+	defer upstream.Close()
+
+	u, _ := url.Parse(upstream.URL)
+	proxySrv := httptest.NewServer(logging.RequestIDMiddleware(NewReverseProxy(u, "", false, "")))
+	defer proxySrv.Close()
+
+	req, _ := http.NewRequest("GET", proxySrv.URL+"/api/tags", nil)
+	req.Header.Set(logging.RequestIDHeader, "bad id")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if n := len(resp.Header.Values(logging.RequestIDHeader)); n != 1 {
+		t.Errorf("%d request id headers in the response, want 1", n)
+	}
+	echoed := resp.Header.Get(logging.RequestIDHeader)
+	if echoed == "" || echoed == "bad id" {
+		t.Fatalf("echoed request id %q", echoed)
+	}
+	select {
+	case got := <-ch:
+		if got != echoed {
+			t.Errorf("upstream got request id %q, client got %q", got, echoed)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for upstream request")
+	}
+}
+
+func TestLogRedaction(t *testing.T) {
+	var logs bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	defer slog.SetDefault(prev)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Session", "sess-4f9a1c")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"customer CUST-123456 unknown at db1.corp.example.com, key sk-secret"}`))
+	}))
+	defer upstream.Close()
+	red, err := redact.New(redact.Config{Rules: []redact.Rule{
+		{Name: "customer", Pattern: `CUST-[0-9]{6}`},
+		{Name: "session", Pattern: `sess-[0-9a-f]+`},
+		{Name: "host", Pattern: `[a-z0-9-]+\.corp\.example\.com`, Replacement: "[HOST]"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(upstream.URL)
+	proxySrv := httptest.NewServer(New(u, Options{APIKey: "sk-secret", LogRedactor: red}))
+	defer proxySrv.Close()
+
+	resp, err := http.Get(proxySrv.URL + "/api/show")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), "CUST-123456") {
+		t.Errorf("client response was altered: %s", body)
+	}
+
+	out := logs.String()
+	for _, leak := range []string{"CUST-123456", "sess-4f9a1c", "db1.corp", "sk-secret"} {
+		if strings.Contains(out, leak) {
+			t.Errorf("log contains %q: %s", leak, out)
+		}
+	}
+	for _, want := range []string{"[REDACTED:CUSTOMER]", "[REDACTED:SESSION]", "[HOST]"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("log is missing %q: %s", want, out)
+		}
+	}
+}
+
+func TestPrivacyKeepsBodiesOutOfLogs(t *testing.T) {
+	var logs bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	defer slog.SetDefault(prev)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/show" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"confidential merger plan is too long"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		_, _ = w.Write([]byte(`{"response":"the confidential answer","done":true}` + "\n"))
+		w.(http.Flusher).Flush()
+	}))
+	defer upstream.Close()
+	u, _ := url.Parse(upstream.URL)
+	proxySrv := httptest.NewServer(New(u, Options{Privacy: true}))
+	defer proxySrv.Close()
+
+	for _, path := range []string{"/api/show", "/api/generate"} {
+		resp, err := http.Post(proxySrv.URL+path, "application/json", strings.NewReader(`{}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if !strings.Contains(string(body), "confidential") {
+			t.Errorf("%s: client response was altered: %s", path, body)
+		}
+	}
+	out := logs.String()
+	if strings.Contains(out, "confidential") || strings.Contains(out, "body_snippet") {
+		t.Errorf("body content was logged: %s", out)
+	}
+	if !strings.Contains(out, "status=400") {
+		t.Errorf("error response metadata was not logged: %s", out)
+	}
+}
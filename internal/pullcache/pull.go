@@ -0,0 +1,86 @@
+package pullcache
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/yeti47/ollama-proxy/internal/apierror"
+	"github.com/yeti47/ollama-proxy/internal/ollama"
+)
+
+// Rewrite points /api/pull requests for registry models at the mirror,
+// so that the upstream Ollama downloads through the cache too.
+type Rewrite struct {
+	// Host is the mirror as the upstream reaches it, host[:port].
+	Host string
+	// Insecure lets the upstream pull from the mirror over plain HTTP.
+	Insecure bool
+}
+
+// ParseRewrite reads the mirror's base URL, e.g. http://proxy:11434.
+func ParseRewrite(s string) (*Rewrite, error) {
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("mirror URL %q: want http(s)://host[:port]", s)
+	}
+	return &Rewrite{Host: u.Host, Insecure: u.Scheme == "http"}, nil
+}
+
+// Name maps a model name from the default registry onto the mirror:
+// llama3 becomes host/library/llama3. Names that already carry a
+// registry host are returned unchanged.
+func (rw *Rewrite) Name(model string) string {
+	first, rest, ok := strings.Cut(model, "/")
+	if !ok {
+		return rw.Host + "/library/" + model
+	}
+	if first == "registry.ollama.ai" {
+		return rw.Host + "/" + rest
+	}
+	if strings.ContainsAny(first, ".:") || first == "localhost" {
+		return model
+	}
+	return rw.Host + "/" + model
+}
+
+// Middleware rewrites the model of /api/pull requests to next.
+func (rw *Rewrite) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/api/pull" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		body, err := ollama.PeekBody(r)
+		if err != nil {
+			apierror.BodyError(w, err)
+			return
+		}
+		var req map[string]any
+		if json.Unmarshal(body, &req) != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		field := "model"
+		if _, ok := req[field].(string); !ok {
+			field = "name"
+		}
+		model, _ := req[field].(string)
+		if model == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if mirrored := rw.Name(model); mirrored != model {
+			req[field] = mirrored
+			if rw.Insecure {
+				req["insecure"] = true
+			}
+			if b, err := json.Marshal(req); err == nil {
+				ollama.ReplaceBody(r, b)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
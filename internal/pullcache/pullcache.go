@@ -0,0 +1,358 @@
+// Package pullcache is a pull-through mirror of the Ollama model
+// registry. Ollama instances pull from it as from any registry; model
+// layers, which run to tens of gigabytes, are kept on local disk so that
+// each is downloaded from the internet once however many machines pull
+// it. Manifests are always fetched fresh, since tags move.
+package pullcache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yeti47/ollama-proxy/internal/metrics"
+)
+
+// DefaultRegistry is where Ollama pulls models from.
+const DefaultRegistry = "https://registry.ollama.ai"
+
+var (
+	requests = metrics.NewCounter("ollama_proxy_pull_cache_requests_total",
+		"Registry blob requests by result: hit or miss.", "result")
+	evictions = metrics.NewCounter("ollama_proxy_pull_cache_evictions_total",
+		"Blobs removed from the pull cache to stay under its size limit.")
+	cached = metrics.NewGauge("ollama_proxy_pull_cache_bytes",
+		"Bytes of model layers held in the pull cache.")
+)
+
+var digestRe = regexp.MustCompile(`^sha256:[0-9a-f]{64}$`)
+
+// forwarded are the request headers passed to the registry.
+var forwarded = []string{"Accept", "Authorization", "Range", "If-Range", "If-None-Match", "User-Agent"}
+
+// Cache serves the registry API under /v2/ from Upstream, keeping blobs
+// in Dir.
+type Cache struct {
+	Upstream *url.URL
+	Dir      string
+	// MaxBytes bounds the blobs kept on disk; the least recently used are
+	// evicted to make room. Zero means no limit.
+	MaxBytes int64
+	// Client fetches from the registry, which redirects blob downloads
+	// to a CDN.
+	Client *http.Client
+
+	mu      sync.Mutex
+	blobs   map[string]*blob
+	total   int64
+	filling map[string]bool
+}
+
+// blob is one cached layer.
+type blob struct {
+	size int64
+	used time.Time
+}
+
+// New opens the cache in dir, picking up blobs left by an earlier run.
+func New(upstream *url.URL, dir string, maxBytes int64) (*Cache, error) {
+	c := &Cache{
+		Upstream: upstream,
+		Dir:      dir,
+		MaxBytes: maxBytes,
+		Client:   &http.Client{},
+		blobs:    map[string]*blob{},
+		filling:  map[string]bool{},
+	}
+	// partial downloads of an earlier run are of no use
+	if err := os.RemoveAll(filepath.Join(dir, "tmp")); err != nil {
+		return nil, err
+	}
+	for _, sub := range []string{"blobs", "tmp"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o700); err != nil {
+			return nil, err
+		}
+	}
+	entries, err := os.ReadDir(filepath.Join(dir, "blobs"))
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		c.blobs[e.Name()] = &blob{size: info.Size(), used: info.ModTime()}
+		c.total += info.Size()
+	}
+	c.mu.Lock()
+	c.evict("")
+	c.mu.Unlock()
+	return c, nil
+}
+
+// fileName is where the blob with digest is kept: the colon is not
+// allowed in file names on every system.
+func fileName(digest string) string { return strings.Replace(digest, ":", "-", 1) }
+
+// ServeHTTP implements the read side of the registry API:
+// /v2/, /v2/{name}/manifests/{ref} and /v2/{name}/blobs/{digest}.
+func (c *Cache) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	p := strings.TrimPrefix(r.URL.Path, "/v2/")
+	if p == "" || p == r.URL.Path {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, "{}")
+		return
+	}
+	i := strings.LastIndex(p, "/blobs/")
+	if i < 0 {
+		if !strings.Contains(p, "/manifests/") {
+			http.NotFound(w, r)
+			return
+		}
+		c.forward(w, r)
+		return
+	}
+	digest := p[i+len("/blobs/"):]
+	if !digestRe.MatchString(digest) {
+		http.Error(w, "invalid digest", http.StatusBadRequest)
+		return
+	}
+	// layers take far longer than the server's write timeout; best
+	// effort, as not every wrapping writer can reach the connection
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	if c.serve(w, r, digest) {
+		requests.With("hit").Inc()
+		return
+	}
+	requests.With("miss").Inc()
+	// Ollama fetches large layers as many concurrent ranges, and stalls
+	// if one of them is held back; so the client is served straight from
+	// the registry while the whole layer is fetched into the cache
+	// alongside.
+	if r.Method == http.MethodGet {
+		c.fill(r, digest)
+	}
+	c.forward(w, r)
+}
+
+// serve answers r from the cache if it holds digest.
+func (c *Cache) serve(w http.ResponseWriter, r *http.Request, digest string) bool {
+	name := fileName(digest)
+	c.mu.Lock()
+	b, ok := c.blobs[name]
+	if ok {
+		b.used = time.Now()
+	}
+	c.mu.Unlock()
+	if !ok {
+		return false
+	}
+	f, err := os.Open(filepath.Join(c.Dir, "blobs", name))
+	if err != nil {
+		// removed behind our back
+		c.mu.Lock()
+		c.drop(name)
+		c.mu.Unlock()
+		return false
+	}
+	defer f.Close()
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Docker-Content-Digest", digest)
+	// blobs are immutable, so the digest serves as validator
+	w.Header().Set("Etag", strconv.Quote(digest))
+	http.ServeContent(w, r, "", time.Time{}, f)
+	return true
+}
+
+// request builds the registry request for r.
+func (c *Cache) request(ctx context.Context, r *http.Request) (*http.Request, error) {
+	u := *c.Upstream
+	u.Path = strings.TrimSuffix(u.Path, "/") + r.URL.Path
+	u.RawPath = ""
+	u.RawQuery = r.URL.RawQuery
+	out, err := http.NewRequestWithContext(ctx, r.Method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range forwarded {
+		if v := r.Header.Values(h); len(v) > 0 {
+			out.Header[h] = v
+		}
+	}
+	return out, nil
+}
+
+// forward passes r through to the registry.
+func (c *Cache) forward(w http.ResponseWriter, r *http.Request) {
+	out, err := c.request(r.Context(), r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	resp, err := c.Client.Do(out)
+	if err != nil {
+		slog.Warn("registry request failed", "path", r.URL.Path, "err", err)
+		http.Error(w, "registry unreachable", http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	for k, vs := range resp.Header {
+		if k == "Connection" || k == "Transfer-Encoding" {
+			continue
+		}
+		w.Header()[k] = vs
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+// fill downloads the blob r asks for into the cache in the background,
+// unless that is already under way.
+func (c *Cache) fill(r *http.Request, digest string) {
+	name := fileName(digest)
+	c.mu.Lock()
+	if c.filling[name] {
+		c.mu.Unlock()
+		return
+	}
+	c.filling[name] = true
+	c.mu.Unlock()
+
+	// the client's registry token, if any, authorizes the download too
+	out, err := c.request(context.Background(), r)
+	if err != nil {
+		c.mu.Lock()
+		delete(c.filling, name)
+		c.mu.Unlock()
+		return
+	}
+	out.Header.Del("Range")
+	out.Header.Del("If-Range")
+	out.Header.Del("If-None-Match")
+	go func() {
+		err := c.download(out, digest)
+		c.mu.Lock()
+		delete(c.filling, name)
+		c.mu.Unlock()
+		if err != nil {
+			slog.Warn("pull cache download failed", "digest", digest, "err", err)
+		}
+	}()
+}
+
+// errTooLarge skips blobs that would not fit even in an empty cache.
+var errTooLarge = errors.New("larger than the cache")
+
+// download fetches a whole blob, checks it against its digest and adds
+// it to the cache.
+func (c *Cache) download(req *http.Request, digest string) error {
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("registry answered %s", resp.Status)
+	}
+	if c.MaxBytes > 0 && resp.ContentLength > c.MaxBytes {
+		return errTooLarge
+	}
+	f, err := os.CreateTemp(filepath.Join(c.Dir, "tmp"), "blob-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	var body io.Reader = resp.Body
+	if c.MaxBytes > 0 {
+		body = io.LimitReader(body, c.MaxBytes+1)
+	}
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(f, h), body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if c.MaxBytes > 0 && n > c.MaxBytes {
+		return errTooLarge
+	}
+	if got := "sha256:" + hex.EncodeToString(h.Sum(nil)); got != digest {
+		return fmt.Errorf("content has digest %s", got)
+	}
+	name := fileName(digest)
+	if err := os.Rename(f.Name(), filepath.Join(c.Dir, "blobs", name)); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.drop(name)
+	c.blobs[name] = &blob{size: n, used: time.Now()}
+	c.total += n
+	c.evict(name)
+	slog.Info("pull cache stored blob", "digest", digest, "bytes", n)
+	return nil
+}
+
+// drop forgets a blob. c.mu must be held.
+func (c *Cache) drop(name string) {
+	if b, ok := c.blobs[name]; ok {
+		c.total -= b.size
+		delete(c.blobs, name)
+	}
+	cached.With().Set(float64(c.total))
+}
+
+// evict removes the least recently used blobs other than keep until the
+// cache is within MaxBytes. c.mu must be held.
+func (c *Cache) evict(keep string) {
+	defer func() { cached.With().Set(float64(c.total)) }()
+	if c.MaxBytes <= 0 || c.total <= c.MaxBytes {
+		return
+	}
+	names := make([]string, 0, len(c.blobs))
+	for name := range c.blobs {
+		if name != keep {
+			names = append(names, name)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool { return c.blobs[names[i]].used.Before(c.blobs[names[j]].used) })
+	for _, name := range names {
+		if c.total <= c.MaxBytes {
+			break
+		}
+		if err := os.Remove(filepath.Join(c.Dir, "blobs", name)); err != nil && !os.IsNotExist(err) {
+			slog.Warn("pull cache eviction failed", "blob", name, "err", err)
+			continue
+		}
+		c.drop(name)
+		evictions.With().Inc()
+	}
+}
+
+// Size reports the number of cached blobs and their total size.
+func (c *Cache) Size() (blobs int, bytes int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.blobs), c.total
+}
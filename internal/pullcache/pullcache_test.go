@@ -0,0 +1,207 @@
+package pullcache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func digestOf(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// registry serves blobs by digest and counts the requests for each.
+func registry(t *testing.T, blobs ...string) (*httptest.Server, map[string]*atomic.Int32) {
+	t.Helper()
+	byDigest := map[string]string{}
+	hits := map[string]*atomic.Int32{}
+	for _, b := range blobs {
+		byDigest[digestOf(b)] = b
+		hits[digestOf(b)] = &atomic.Int32{}
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/manifests/") {
+			w.Header().Set("Content-Type", "application/vnd.docker.distribution.manifest.v2+json")
+			io.WriteString(w, `{"layers":[]}`)
+			return
+		}
+		d := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+		b, ok := byDigest[d]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		hits[d].Add(1)
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(b))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, hits
+}
+
+func newCache(t *testing.T, upstream string, max int64) *Cache {
+	t.Helper()
+	u, _ := url.Parse(upstream)
+	c, err := New(u, t.TempDir(), max)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func get(c *Cache, path, rng string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	if rng != "" {
+		r.Header.Set("Range", rng)
+	}
+	w := httptest.NewRecorder()
+	c.ServeHTTP(w, r)
+	return w
+}
+
+// waitCached waits for the background download of n blobs.
+func waitCached(t *testing.T, c *Cache, n int) {
+	t.Helper()
+	for i := 0; i < 200; i++ {
+		c.mu.Lock()
+		done := len(c.blobs) == n && len(c.filling) == 0
+		c.mu.Unlock()
+		if done {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("cache never reached %d blobs", n)
+}
+
+func TestBlobCached(t *testing.T) {
+	layer := strings.Repeat("weights", 100)
+	d := digestOf(layer)
+	srv, hits := registry(t, layer)
+	c := newCache(t, srv.URL, 0)
+	path := "/v2/library/llama3/blobs/" + d
+
+	// a miss is served from the registry while the cache fills
+	w := get(c, path, "bytes=0-6")
+	if w.Code != http.StatusPartialContent || w.Body.String() != "weights" {
+		t.Fatalf("miss: %d %q", w.Code, w.Body)
+	}
+	waitCached(t, c, 1)
+	n := hits[d].Load()
+
+	w = get(c, path, "bytes=7-13")
+	if w.Code != http.StatusPartialContent || w.Body.String() != "weights" {
+		t.Fatalf("hit: %d %q", w.Code, w.Body)
+	}
+	if w.Header().Get("Docker-Content-Digest") != d {
+		t.Errorf("digest header = %q", w.Header().Get("Docker-Content-Digest"))
+	}
+	if w = get(c, path, ""); w.Body.String() != layer {
+		t.Errorf("full body mismatch")
+	}
+	if hits[d].Load() != n {
+		t.Errorf("registry hit again after caching")
+	}
+
+	// a restart finds the blob on disk
+	c2, err := New(c.Upstream, c.Dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blobs, size := c2.Size(); blobs != 1 || size != int64(len(layer)) {
+		t.Errorf("reopened cache holds %d blobs, %d bytes", blobs, size)
+	}
+}
+
+func TestManifestForwarded(t *testing.T) {
+	srv, _ := registry(t)
+	c := newCache(t, srv.URL, 0)
+	w := get(c, "/v2/library/llama3/manifests/latest", "")
+	if w.Code != http.StatusOK || w.Body.String() != `{"layers":[]}` {
+		t.Fatalf("manifest: %d %q", w.Code, w.Body)
+	}
+	if w = get(c, "/v2/", ""); w.Code != http.StatusOK {
+		t.Errorf("/v2/ answered %d", w.Code)
+	}
+	if w = get(c, "/v2/library/llama3/blobs/sha256:xyz", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("bad digest answered %d", w.Code)
+	}
+}
+
+func TestEviction(t *testing.T) {
+	a, b, big := strings.Repeat("a", 60), strings.Repeat("b", 60), strings.Repeat("c", 200)
+	srv, _ := registry(t, a, b, big)
+	c := newCache(t, srv.URL, 100)
+
+	get(c, "/v2/m/blobs/"+digestOf(a), "")
+	waitCached(t, c, 1)
+	get(c, "/v2/m/blobs/"+digestOf(b), "")
+	waitCached(t, c, 1)
+	c.mu.Lock()
+	_, hasA := c.blobs[fileName(digestOf(a))]
+	_, hasB := c.blobs[fileName(digestOf(b))]
+	c.mu.Unlock()
+	if hasA || !hasB {
+		t.Errorf("after eviction a=%v b=%v, want only b", hasA, hasB)
+	}
+
+	// a blob larger than the whole cache is passed through but not kept
+	if w := get(c, "/v2/m/blobs/"+digestOf(big), ""); w.Body.String() != big {
+		t.Errorf("oversized blob not served")
+	}
+	waitCached(t, c, 1)
+	if _, size := c.Size(); size != 60 {
+		t.Errorf("cache holds %d bytes, want 60", size)
+	}
+}
+
+func TestDigestMismatch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "tampered")
+	}))
+	defer srv.Close()
+	c := newCache(t, srv.URL, 0)
+	get(c, "/v2/m/blobs/"+digestOf("original"), "")
+	waitCached(t, c, 0)
+}
+
+func TestRewriteName(t *testing.T) {
+	rw, err := ParseRewrite("http://proxy:11434")
+	if err != nil || !rw.Insecure || rw.Host != "proxy:11434" {
+		t.Fatalf("ParseRewrite = %+v, %v", rw, err)
+	}
+	for in, want := range map[string]string{
+		"llama3":                          "proxy:11434/library/llama3",
+		"llama3:8b":                       "proxy:11434/library/llama3:8b",
+		"someone/model":                   "proxy:11434/someone/model",
+		"registry.ollama.ai/library/qwen": "proxy:11434/library/qwen",
+		"hf.co/org/model":                 "hf.co/org/model",
+	} {
+		if got := rw.Name(in); got != want {
+			t.Errorf("Name(%q) = %q, want %q", in, got, want)
+		}
+	}
+	if _, err := ParseRewrite("proxy:11434"); err == nil {
+		t.Error("URL without scheme accepted")
+	}
+}
+
+func TestRewriteMiddleware(t *testing.T) {
+	rw := &Rewrite{Host: "proxy:11434", Insecure: true}
+	var got string
+	h := rw.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		got = string(b)
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/pull", strings.NewReader(`{"model":"llama3"}`)))
+	if got != `{"insecure":true,"model":"proxy:11434/library/llama3"}` {
+		t.Errorf("body = %s", got)
+	}
+}
@@ -0,0 +1,244 @@
+package quota
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/yeti47/ollama-proxy/internal/apierror"
+	"github.com/yeti47/ollama-proxy/internal/auth"
+	"github.com/yeti47/ollama-proxy/internal/metrics"
+	"github.com/yeti47/ollama-proxy/internal/notify"
+	"github.com/yeti47/ollama-proxy/internal/ollama"
+)
+
+var quotaExceeded = metrics.NewCounter("ollama_proxy_quota_exceeded_total",
+	"Requests rejected because a token quota was used up, by client, tenant and period.", "client", "tenant", "period")
+
+// anonymousKey tracks usage of unauthenticated clients.
+const anonymousKey = "anonymous"
+
+// Limits are token quotas per calendar day and month (UTC). Zero means
+// unlimited.
+type Limits struct {
+	DailyTokens   int64 `json:"daily_tokens,omitempty"`
+	MonthlyTokens int64 `json:"monthly_tokens,omitempty"`
+}
+
+// usage is the running count for one client. Periods are stored as
+// strings ("2006-01-02", "2006-01") so rollover is a simple comparison.
+type usage struct {
+	Day         string `json:"day"`
+	DayTokens   int64  `json:"day_tokens"`
+	Month       string `json:"month"`
+	MonthTokens int64  `json:"month_tokens"`
+}
+
+func (u *usage) roll(now time.Time) {
+	if d := now.Format("2006-01-02"); u.Day != d {
+		u.Day, u.DayTokens = d, 0
+	}
+	if m := now.Format("2006-01"); u.Month != m {
+		u.Month, u.MonthTokens = m, 0
+	}
+}
+
+// Tracker counts prompt+completion tokens per client and denies requests
+// once a quota is exhausted. Counts can be persisted to a JSON file so a
+// restart doesn't reset them.
+type Tracker struct {
+	Default   Limits
+	Overrides map[string]Limits
+	StateFile string
+
+	mu    sync.Mutex
+	usage map[string]*usage
+	dirty bool
+	now   func() time.Time
+}
+
+// NewTracker returns a Tracker. If stateFile exists, counts are loaded from
+// it.
+func NewTracker(def Limits, stateFile string) (*Tracker, error) {
+	t := &Tracker{
+		Default:   def,
+		Overrides: make(map[string]Limits),
+		StateFile: stateFile,
+		usage:     make(map[string]*usage),
+		now:       func() time.Time { return time.Now().UTC() },
+	}
+	if stateFile != "" {
+		b, err := os.ReadFile(stateFile)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if len(b) > 0 {
+			if err := json.Unmarshal(b, &t.usage); err != nil {
+				return nil, fmt.Errorf("parsing %s: %w", stateFile, err)
+			}
+		}
+	}
+	return t, nil
+}
+
+func (t *Tracker) keyAndLimits(id *auth.Identity) (string, Limits) {
+	if id == nil {
+		return anonymousKey, t.Default
+	}
+	if lim, ok := t.Overrides[id.Name]; ok {
+		return id.Name, lim
+	}
+	return id.Name, t.Default
+}
+
+// get returns the rolled-over usage for key. Callers must hold t.mu.
+func (t *Tracker) get(key string) *usage {
+	u, ok := t.usage[key]
+	if !ok {
+		u = &usage{}
+		t.usage[key] = u
+	}
+	u.roll(t.now())
+	return u
+}
+
+// Record adds the tokens of a completed response. It matches the proxy's
+// OnUsage hook.
+func (t *Tracker) Record(r *http.Request, u ollama.Usage) {
+	key, _ := t.keyAndLimits(auth.FromContext(r.Context()))
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	cu := t.get(key)
+	cu.DayTokens += int64(u.TotalTokens())
+	cu.MonthTokens += int64(u.TotalTokens())
+	t.dirty = true
+}
+
+// Status describes a client's quota position.
+type Status struct {
+	Client           string    `json:"client"`
+	DailyLimit       int64     `json:"daily_limit,omitempty"`
+	DailyUsed        int64     `json:"daily_used"`
+	DailyRemaining   *int64    `json:"daily_remaining,omitempty"`
+	DailyResetsAt    time.Time `json:"daily_resets_at"`
+	MonthlyLimit     int64     `json:"monthly_limit,omitempty"`
+	MonthlyUsed      int64     `json:"monthly_used"`
+	MonthlyRemaining *int64    `json:"monthly_remaining,omitempty"`
+	MonthlyResetsAt  time.Time `json:"monthly_resets_at"`
+}
+
+// Status returns the quota position of id.
+func (t *Tracker) Status(id *auth.Identity) Status {
+	key, lim := t.keyAndLimits(id)
+	t.mu.Lock()
+	u := *t.get(key)
+	now := t.now()
+	t.mu.Unlock()
+
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	s := Status{
+		Client:          key,
+		DailyLimit:      lim.DailyTokens,
+		DailyUsed:       u.DayTokens,
+		DailyResetsAt:   day.AddDate(0, 0, 1),
+		MonthlyLimit:    lim.MonthlyTokens,
+		MonthlyUsed:     u.MonthTokens,
+		MonthlyResetsAt: month.AddDate(0, 1, 0),
+	}
+	if lim.DailyTokens > 0 {
+		rem := max(0, lim.DailyTokens-u.DayTokens)
+		s.DailyRemaining = &rem
+	}
+	if lim.MonthlyTokens > 0 {
+		rem := max(0, lim.MonthlyTokens-u.MonthTokens)
+		s.MonthlyRemaining = &rem
+	}
+	return s
+}
+
+// Middleware rejects requests from clients whose daily or monthly quota
+// is used up with 429 and a Retry-After pointing at the next reset.
+func (t *Tracker) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := t.Status(auth.FromContext(r.Context()))
+		var period string
+		var reset time.Time
+		switch {
+		case s.MonthlyRemaining != nil && *s.MonthlyRemaining == 0:
+			period, reset = "monthly", s.MonthlyResetsAt
+		case s.DailyRemaining != nil && *s.DailyRemaining == 0:
+			period, reset = "daily", s.DailyResetsAt
+		}
+		if period != "" {
+			quotaExceeded.With(s.Client, auth.TenantName(r.Context()), period).Inc()
+			slog.WarnContext(r.Context(), "token quota exceeded", "period", period, "client", s.Client)
+			notify.Send(notify.QuotaExceeded, s.Client, period+" token quota exceeded for "+s.Client,
+				"client", s.Client, "period", period, "resets_at", reset)
+			w.Header().Set("Retry-After", strconv.Itoa(int(reset.Sub(t.now()).Seconds())+1))
+			apierror.Write(w, http.StatusTooManyRequests, period+" token quota exceeded; resets at "+reset.Format(time.RFC3339))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Handler serves the caller's own quota status as JSON.
+func (t *Tracker) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			apierror.Write(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(t.Status(auth.FromContext(r.Context())))
+	})
+}
+
+// Save writes the counts to StateFile if they changed since the last save.
+func (t *Tracker) Save() error {
+	if t.StateFile == "" {
+		return nil
+	}
+	t.mu.Lock()
+	if !t.dirty {
+		t.mu.Unlock()
+		return nil
+	}
+	b, err := json.Marshal(t.usage)
+	t.dirty = false
+	t.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp := t.StateFile + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, t.StateFile)
+}
+
+// SaveEvery persists the counts every interval until stop is closed, and
+// once more on the way out.
+func (t *Tracker) SaveEvery(interval time.Duration, stop <-chan struct{}) {
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+			if err := t.Save(); err != nil {
+				slog.Error("saving quota state", "err", err)
+			}
+		case <-stop:
+			if err := t.Save(); err != nil {
+				slog.Error("saving quota state", "err", err)
+			}
+			return
+		}
+	}
+}
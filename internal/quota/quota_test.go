@@ -0,0 +1,77 @@
+package quota
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/yeti47/ollama-proxy/internal/auth"
+	"github.com/yeti47/ollama-proxy/internal/ollama"
+)
+
+func TestTracker(t *testing.T) {
+	state := filepath.Join(t.TempDir(), "quota.json")
+	tr, err := NewTracker(Limits{DailyTokens: 100, MonthlyTokens: 150}, state)
+	if err != nil {
+		t.Fatalf("new tracker: %v", err)
+	}
+	now := time.Date(2026, 3, 31, 23, 0, 0, 0, time.UTC)
+	tr.now = func() time.Time { return now }
+
+	alice := &auth.Identity{Name: "alice"}
+	newReq := func() *http.Request {
+		req := httptest.NewRequest("POST", "/api/chat", nil)
+		return req.WithContext(auth.WithIdentity(req.Context(), alice))
+	}
+	h := tr.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	do := func() int {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, newReq())
+		return rec.Code
+	}
+
+	tr.Record(newReq(), ollama.Usage{PromptTokens: 40, CompletionTokens: 40})
+	if code := do(); code != http.StatusOK {
+		t.Fatalf("expected 200 got %d", code)
+	}
+	tr.Record(newReq(), ollama.Usage{PromptTokens: 10, CompletionTokens: 10})
+	if code := do(); code != http.StatusTooManyRequests {
+		t.Fatalf("expected daily quota 429 got %d", code)
+	}
+
+	// status endpoint reflects usage
+	rec := httptest.NewRecorder()
+	statusReq := httptest.NewRequest("GET", "/proxy/quota", nil)
+	tr.Handler().ServeHTTP(rec, statusReq.WithContext(auth.WithIdentity(statusReq.Context(), alice)))
+	var s Status
+	if err := json.Unmarshal(rec.Body.Bytes(), &s); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if s.DailyUsed != 100 || *s.DailyRemaining != 0 || *s.MonthlyRemaining != 50 {
+		t.Fatalf("unexpected status %+v", s)
+	}
+
+	// persisted counts survive a restart
+	if err := tr.Save(); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	tr2, err := NewTracker(tr.Default, state)
+	if err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	tr2.now = tr.now
+	if s := tr2.Status(alice); s.DailyUsed != 100 {
+		t.Fatalf("expected reloaded usage 100 got %d", s.DailyUsed)
+	}
+
+	// a new day (and month) resets the counters
+	now = now.Add(2 * time.Hour)
+	if code := do(); code != http.StatusOK {
+		t.Fatalf("expected 200 after rollover got %d", code)
+	}
+}
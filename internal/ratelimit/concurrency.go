@@ -0,0 +1,140 @@
+package ratelimit
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/yeti47/ollama-proxy/internal/apierror"
+	"github.com/yeti47/ollama-proxy/internal/auth"
+	"github.com/yeti47/ollama-proxy/internal/metrics"
+)
+
+var (
+	inFlight = metrics.NewGauge("ollama_proxy_inflight_requests",
+		"Requests currently holding a concurrency slot.")
+	concurrencyRejected = metrics.NewCounter("ollama_proxy_concurrency_rejected_total",
+		"Requests rejected because no concurrency slot became free, by client, tenant and scope.", "client", "tenant", "scope")
+)
+
+// ConcurrencyLimiter caps the number of simultaneous upstream requests,
+// globally and per client key. Requests over the limit wait up to
+// QueueTimeout for a slot; with a zero timeout they are rejected at once.
+type ConcurrencyLimiter struct {
+	Global    int
+	PerClient int
+	// Overrides holds per-client limits keyed by identity name.
+	Overrides    map[string]int
+	QueueTimeout time.Duration
+
+	global chan struct{}
+
+	mu      sync.Mutex
+	clients map[string]chan struct{}
+}
+
+// NewConcurrencyLimiter returns a limiter. Zero limits mean unlimited.
+func NewConcurrencyLimiter(global, perClient int, queueTimeout time.Duration) *ConcurrencyLimiter {
+	c := &ConcurrencyLimiter{
+		Global:       global,
+		PerClient:    perClient,
+		Overrides:    make(map[string]int),
+		QueueTimeout: queueTimeout,
+		clients:      make(map[string]chan struct{}),
+	}
+	if global > 0 {
+		c.global = make(chan struct{}, global)
+	}
+	return c
+}
+
+// clientSem returns the semaphore for the caller, or nil if unlimited.
+func (c *ConcurrencyLimiter) clientSem(id *auth.Identity) (string, chan struct{}) {
+	key, limit := AnonymousKey, c.PerClient
+	if id != nil {
+		key = id.Name
+		if n, ok := c.Overrides[id.Name]; ok {
+			limit = n
+		}
+	}
+	if limit <= 0 {
+		return key, nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	sem, ok := c.clients[key]
+	if !ok {
+		sem = make(chan struct{}, limit)
+		c.clients[key] = sem
+	}
+	return key, sem
+}
+
+// acquire takes a slot from sem, waiting until ctx is done.
+func acquire(ctx context.Context, sem chan struct{}) bool {
+	select {
+	case sem <- struct{}{}:
+		return true
+	default:
+	}
+	select {
+	case sem <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Middleware holds a client slot and a global slot for the duration of
+// each request, including the whole of a streamed response.
+func (c *ConcurrencyLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, sem := c.clientSem(auth.FromContext(r.Context()))
+
+		ctx := r.Context()
+		cancel := func() {}
+		if c.QueueTimeout > 0 {
+			ctx, cancel = context.WithTimeout(ctx, c.QueueTimeout)
+		} else {
+			// an already-cancelled context makes acquire fail immediately
+			// when no slot is free
+			var cctx context.Context
+			cctx, cancel = context.WithCancel(ctx)
+			cancel()
+			ctx = cctx
+		}
+		defer cancel()
+
+		if sem != nil {
+			if !acquire(ctx, sem) {
+				c.reject(w, r, key, "client")
+				return
+			}
+			defer func() { <-sem }()
+		}
+		if c.global != nil {
+			if !acquire(ctx, c.global) {
+				c.reject(w, r, key, "global")
+				return
+			}
+			defer func() { <-c.global }()
+		}
+
+		inFlight.With().Inc()
+		defer inFlight.With().Dec()
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (c *ConcurrencyLimiter) reject(w http.ResponseWriter, r *http.Request, key, scope string) {
+	if r.Context().Err() != nil {
+		// the client went away while queued; nobody is listening
+		return
+	}
+	concurrencyRejected.With(key, auth.TenantName(r.Context()), scope).Inc()
+	slog.WarnContext(r.Context(), "concurrency limit reached", "scope", scope, "client", key, "method", r.Method, "path", r.URL.Path)
+	w.Header().Set("Retry-After", "1")
+	apierror.Write(w, http.StatusTooManyRequests, "too many concurrent requests ("+scope+" limit)")
+}
@@ -0,0 +1,77 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yeti47/ollama-proxy/internal/auth"
+)
+
+func TestConcurrencyLimiter(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{}, 10)
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		w.WriteHeader(http.StatusOK)
+	})
+
+	do := func(h http.Handler, name string) int {
+		req := httptest.NewRequest("POST", "/api/generate", nil)
+		req = req.WithContext(auth.WithIdentity(req.Context(), &auth.Identity{Name: name}))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	t.Run("immediate rejection", func(t *testing.T) {
+		h := NewConcurrencyLimiter(2, 1, 0).Middleware(next)
+		var wg sync.WaitGroup
+		codes := make(chan int, 2)
+		for _, name := range []string{"a", "b"} {
+			wg.Add(1)
+			go func(name string) {
+				defer wg.Done()
+				codes <- do(h, name)
+			}(name)
+		}
+		<-started
+		<-started
+		// per-client limit hit for a, global limit hit for c
+		if code := do(h, "a"); code != http.StatusTooManyRequests {
+			t.Fatalf("expected per-client 429 got %d", code)
+		}
+		if code := do(h, "c"); code != http.StatusTooManyRequests {
+			t.Fatalf("expected global 429 got %d", code)
+		}
+		release <- struct{}{}
+		release <- struct{}{}
+		wg.Wait()
+		close(codes)
+		for code := range codes {
+			if code != http.StatusOK {
+				t.Fatalf("expected 200 got %d", code)
+			}
+		}
+	})
+
+	t.Run("queueing", func(t *testing.T) {
+		h := NewConcurrencyLimiter(0, 1, time.Second).Middleware(next)
+		done := make(chan int, 2)
+		go func() { done <- do(h, "a") }()
+		<-started
+		go func() { done <- do(h, "a") }()
+		time.Sleep(50 * time.Millisecond)
+		release <- struct{}{}
+		<-started
+		release <- struct{}{}
+		for i := 0; i < 2; i++ {
+			if code := <-done; code != http.StatusOK {
+				t.Fatalf("expected queued request to succeed, got %d", code)
+			}
+		}
+	})
+}
@@ -0,0 +1,203 @@
+package ratelimit
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/yeti47/ollama-proxy/internal/apierror"
+	"github.com/yeti47/ollama-proxy/internal/auth"
+	"github.com/yeti47/ollama-proxy/internal/metrics"
+	"github.com/yeti47/ollama-proxy/internal/ollama"
+)
+
+var rateLimited = metrics.NewCounter("ollama_proxy_rate_limited_total",
+	"Requests rejected by the rate limiter, by client, tenant and limit.", "client", "tenant", "limit")
+
+// AnonymousKey is the bucket shared by unauthenticated clients.
+const AnonymousKey = "anonymous"
+
+// Limits are per-minute allowances plus a cap on simultaneous requests.
+// Zero means unlimited.
+type Limits struct {
+	RPM        int `json:"rpm,omitempty"`
+	TPM        int `json:"tpm,omitempty"`
+	Concurrent int `json:"concurrent,omitempty"`
+}
+
+// bucket is a token bucket refilled continuously at capacity per minute.
+// Token buckets may go negative: usage is only known after a response
+// completes, so a large generation is debited in full and the client waits
+// until the bucket recovers.
+type bucket struct {
+	capacity float64
+	level    float64
+	last     time.Time
+}
+
+func newBucket(perMinute int, now time.Time) *bucket {
+	return &bucket{capacity: float64(perMinute), level: float64(perMinute), last: now}
+}
+
+func (b *bucket) refill(now time.Time) {
+	elapsed := now.Sub(b.last).Minutes()
+	b.level = math.Min(b.capacity, b.level+elapsed*b.capacity)
+	b.last = now
+}
+
+// wait returns how long until the bucket holds at least n.
+func (b *bucket) wait(n float64) time.Duration {
+	if b.level >= n {
+		return 0
+	}
+	return time.Duration((n - b.level) / b.capacity * float64(time.Minute))
+}
+
+// untilFull returns how long until the bucket is full again.
+func (b *bucket) untilFull() time.Duration {
+	return time.Duration((b.capacity - b.level) / b.capacity * float64(time.Minute))
+}
+
+type clientBuckets struct {
+	requests *bucket
+	tokens   *bucket
+}
+
+// Limiter enforces request and token rate limits per client key.
+type Limiter struct {
+	// Default applies to authenticated clients without an override.
+	Default Limits
+	// Anonymous applies to the single bucket shared by unauthenticated
+	// clients.
+	Anonymous Limits
+	// Overrides holds per-client limits keyed by identity name.
+	Overrides map[string]Limits
+
+	mu      sync.Mutex
+	buckets map[string]*clientBuckets
+	now     func() time.Time
+}
+
+// New returns a Limiter with the given defaults.
+func New(def, anonymous Limits) *Limiter {
+	return &Limiter{
+		Default:   def,
+		Anonymous: anonymous,
+		Overrides: make(map[string]Limits),
+		buckets:   make(map[string]*clientBuckets),
+		now:       time.Now,
+	}
+}
+
+func (l *Limiter) keyAndLimits(id *auth.Identity) (string, Limits) {
+	if id == nil {
+		return AnonymousKey, l.Anonymous
+	}
+	if lim, ok := l.Overrides[id.Name]; ok {
+		return id.Name, lim
+	}
+	return id.Name, l.Default
+}
+
+// get returns the refilled buckets for key. Callers must hold l.mu.
+func (l *Limiter) get(key string, lim Limits) *clientBuckets {
+	now := l.now()
+	cb, ok := l.buckets[key]
+	if !ok {
+		cb = &clientBuckets{}
+		if lim.RPM > 0 {
+			cb.requests = newBucket(lim.RPM, now)
+		}
+		if lim.TPM > 0 {
+			cb.tokens = newBucket(lim.TPM, now)
+		}
+		l.buckets[key] = cb
+	}
+	if cb.requests != nil {
+		cb.requests.refill(now)
+	}
+	if cb.tokens != nil {
+		cb.tokens.refill(now)
+	}
+	return cb
+}
+
+// Middleware rejects requests that exceed the caller's limits with 429 and
+// sets x-ratelimit-* headers on every response.
+func (l *Limiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, lim := l.keyAndLimits(auth.FromContext(r.Context()))
+		if lim.RPM <= 0 && lim.TPM <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		l.mu.Lock()
+		cb := l.get(key, lim)
+		var retry time.Duration
+		var exceeded string
+		if cb.requests != nil {
+			if d := cb.requests.wait(1); d > 0 {
+				retry, exceeded = d, "requests"
+			}
+		}
+		if cb.tokens != nil {
+			// any positive balance admits a request; its cost is unknown
+			// until the response completes
+			if d := cb.tokens.wait(math.SmallestNonzeroFloat64); d > retry {
+				retry, exceeded = d, "tokens"
+			}
+		}
+		if exceeded == "" && cb.requests != nil {
+			cb.requests.level--
+		}
+		setHeaders(w.Header(), cb)
+		l.mu.Unlock()
+
+		if exceeded != "" {
+			rateLimited.With(key, auth.TenantName(r.Context()), exceeded).Inc()
+			slog.WarnContext(r.Context(), "rate limit exceeded", "limit", exceeded, "client", key)
+			secs := int(math.Ceil(retry.Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(secs))
+			apierror.Write(w, http.StatusTooManyRequests, fmt.Sprintf("rate limit exceeded (%s per minute); retry in %ds", exceeded, secs))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RecordUsage debits the tokens used by a completed response from the
+// caller's token bucket. It matches the proxy's OnUsage hook.
+func (l *Limiter) RecordUsage(r *http.Request, u ollama.Usage) {
+	key, lim := l.keyAndLimits(auth.FromContext(r.Context()))
+	if lim.TPM <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	cb := l.get(key, lim)
+	cb.tokens.level -= float64(u.TotalTokens())
+}
+
+func setHeaders(h http.Header, cb *clientBuckets) {
+	if b := cb.requests; b != nil {
+		h.Set("X-Ratelimit-Limit-Requests", strconv.Itoa(int(b.capacity)))
+		h.Set("X-Ratelimit-Remaining-Requests", strconv.Itoa(int(math.Max(0, math.Floor(b.level)))))
+		h.Set("X-Ratelimit-Reset-Requests", formatReset(b.untilFull()))
+	}
+	if b := cb.tokens; b != nil {
+		h.Set("X-Ratelimit-Limit-Tokens", strconv.Itoa(int(b.capacity)))
+		h.Set("X-Ratelimit-Remaining-Tokens", strconv.Itoa(int(math.Max(0, math.Floor(b.level)))))
+		h.Set("X-Ratelimit-Reset-Tokens", formatReset(b.untilFull()))
+	}
+}
+
+// formatReset renders a duration like "1.5s", matching the format used by
+// OpenAI-compatible APIs.
+func formatReset(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 1, 64) + "s"
+}
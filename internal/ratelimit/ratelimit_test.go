@@ -0,0 +1,71 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/yeti47/ollama-proxy/internal/auth"
+	"github.com/yeti47/ollama-proxy/internal/ollama"
+)
+
+func TestLimiter(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	l := New(Limits{RPM: 2, TPM: 100}, Limits{RPM: 1})
+	l.now = func() time.Time { return now }
+	l.Overrides["vip"] = Limits{RPM: 10}
+
+	h := l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	do := func(id *auth.Identity) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/chat", nil)
+		if id != nil {
+			req = req.WithContext(auth.WithIdentity(req.Context(), id))
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	alice := &auth.Identity{Name: "alice"}
+	if rec := do(alice); rec.Code != http.StatusOK || rec.Header().Get("X-Ratelimit-Remaining-Requests") != "1" {
+		t.Fatalf("first request: code=%d remaining=%q", rec.Code, rec.Header().Get("X-Ratelimit-Remaining-Requests"))
+	}
+	if rec := do(alice); rec.Code != http.StatusOK {
+		t.Fatalf("second request: expected 200 got %d", rec.Code)
+	}
+	rec := do(alice)
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("third request: expected 429 got %d", rec.Code)
+	}
+	if rec.Header().Get("Retry-After") != "30" {
+		t.Fatalf("expected Retry-After 30 got %q", rec.Header().Get("Retry-After"))
+	}
+
+	// buckets are per key
+	if rec := do(&auth.Identity{Name: "vip"}); rec.Code != http.StatusOK {
+		t.Fatalf("vip: expected 200 got %d", rec.Code)
+	}
+	if rec := do(nil); rec.Code != http.StatusOK {
+		t.Fatalf("anonymous: expected 200 got %d", rec.Code)
+	}
+	if rec := do(nil); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("anonymous: expected 429 got %d", rec.Code)
+	}
+
+	// a minute later requests are available again, but a big generation
+	// exhausts the token budget
+	now = now.Add(time.Minute)
+	req := httptest.NewRequest("POST", "/api/chat", nil)
+	req = req.WithContext(auth.WithIdentity(req.Context(), alice))
+	l.RecordUsage(req, ollama.Usage{PromptTokens: 50, CompletionTokens: 100})
+	if rec := do(alice); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("token limit: expected 429 got %d", rec.Code)
+	}
+	now = now.Add(31 * time.Second)
+	if rec := do(alice); rec.Code != http.StatusOK {
+		t.Fatalf("after token refill: expected 200 got %d", rec.Code)
+	}
+}
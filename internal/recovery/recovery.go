@@ -0,0 +1,43 @@
+// Package recovery reports handler panics. net/http already keeps the
+// server alive after a panic, but only writes the stack to stderr, which
+// nobody reads on an unattended box.
+package recovery
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/yeti47/ollama-proxy/internal/auth"
+	"github.com/yeti47/ollama-proxy/internal/logging"
+	"github.com/yeti47/ollama-proxy/internal/notify"
+	"github.com/yeti47/ollama-proxy/internal/stats"
+)
+
+// Middleware logs a panic in next with its stack and sends a
+// notify.Panic event carrying the request's IDs, never its content. The
+// connection is then aborted as net/http would.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				// a deliberate abort, e.g. a stream broken off by the upstream
+				panic(v)
+			}
+			stack := string(debug.Stack())
+			client := auth.ClientName(r.Context())
+			slog.ErrorContext(r.Context(), "panic serving request", "panic", v, "method", r.Method, "path", r.URL.Path, "stack", stack)
+			notify.Send(notify.Panic, stats.Endpoint(r.URL.Path), fmt.Sprintf("panic: %v", v),
+				"request_id", logging.RequestID(r.Context()), "client", client, "method", r.Method,
+				"endpoint", stats.Endpoint(r.URL.Path), "stack", stack)
+			// already logged; stop net/http from logging it again
+			panic(http.ErrAbortHandler)
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,36 @@
+package recovery
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMiddleware(t *testing.T) {
+	var logs bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	defer slog.SetDefault(prev)
+
+	h := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+	var got any
+	func() {
+		defer func() { got = recover() }()
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/chat", strings.NewReader(`{"prompt":"secret"}`)))
+	}()
+	if got != http.ErrAbortHandler {
+		t.Errorf("re-panicked with %v, want http.ErrAbortHandler", got)
+	}
+	out := logs.String()
+	if !strings.Contains(out, `msg="panic serving request" panic=boom`) || !strings.Contains(out, "stack=") {
+		t.Errorf("panic not logged: %s", out)
+	}
+	if strings.Contains(out, "secret") {
+		t.Error("request body leaked into the log")
+	}
+}
@@ -0,0 +1,267 @@
+// Package redact masks personal data in request bodies before they are
+// forwarded to the upstream.
+package redact
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/yeti47/ollama-proxy/internal/apierror"
+	"github.com/yeti47/ollama-proxy/internal/metrics"
+	"github.com/yeti47/ollama-proxy/internal/ollama"
+)
+
+// ReportHeader is set on responses to requests that had data masked. It
+// lists what was masked and how often, e.g. "email=2, ssn=1".
+const ReportHeader = "X-Proxy-Redacted"
+
+var redactions = metrics.NewCounter("ollama_proxy_redactions_total",
+	"Values masked in request bodies before forwarding, by detector.", "type")
+
+// Config selects built-in detectors and adds custom rules. It is read from
+// the "redaction" section of the config file.
+type Config struct {
+	// Detectors lists built-in detectors: email, credit_card, ssn, or all.
+	Detectors []string `json:"detectors,omitempty"`
+	Rules     []Rule   `json:"rules,omitempty"`
+}
+
+// Rule masks everything matching Pattern (RE2 syntax). Replacement
+// defaults to "[REDACTED:<NAME>]".
+type Rule struct {
+	Name        string `json:"name"`
+	Pattern     string `json:"pattern"`
+	Replacement string `json:"replacement,omitempty"`
+}
+
+type detector struct {
+	name        string
+	re          *regexp.Regexp
+	replacement string
+	// valid, if set, filters regexp matches (e.g. the Luhn check).
+	valid func(string) bool
+}
+
+var builtin = map[string]func() detector{
+	"email": func() detector {
+		return detector{re: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)}
+	},
+	"credit_card": func() detector {
+		return detector{re: regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`), valid: luhn}
+	},
+	"ssn": func() detector {
+		return detector{re: regexp.MustCompile(`\b\d{3}-\d{2}-\d{4}\b`), valid: validSSN}
+	},
+}
+
+// Detectors returns the names of the built-in detectors.
+func Detectors() []string {
+	names := make([]string, 0, len(builtin))
+	for n := range builtin {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Redactor applies a set of detectors to request bodies.
+type Redactor struct {
+	detectors []detector
+}
+
+// New builds a Redactor from c.
+func New(c Config) (*Redactor, error) {
+	r := &Redactor{}
+	seen := map[string]bool{}
+	for _, name := range c.Detectors {
+		name = strings.TrimSpace(name)
+		names := []string{name}
+		if name == "all" {
+			names = Detectors()
+		}
+		for _, n := range names {
+			mk, ok := builtin[n]
+			if !ok {
+				return nil, fmt.Errorf("unknown redaction detector %q (want one of %s or all)", n, strings.Join(Detectors(), ", "))
+			}
+			if seen[n] {
+				continue
+			}
+			seen[n] = true
+			d := mk()
+			d.name = n
+			r.detectors = append(r.detectors, d)
+		}
+	}
+	for i, rule := range c.Rules {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("redaction rules[%d]: name is required", i)
+		}
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("redaction rule %q: %w", rule.Name, err)
+		}
+		r.detectors = append(r.detectors, detector{name: rule.Name, re: re, replacement: rule.Replacement})
+	}
+	for i := range r.detectors {
+		if r.detectors[i].replacement == "" {
+			r.detectors[i].replacement = "[REDACTED:" + strings.ToUpper(r.detectors[i].name) + "]"
+		}
+	}
+	return r, nil
+}
+
+// Len returns the number of active detectors.
+func (r *Redactor) Len() int { return len(r.detectors) }
+
+// blob matches long base64 runs, such as the images of multimodal
+// requests, which only bloat logs.
+var blob = regexp.MustCompile(`[A-Za-z0-9+/]{512,}={0,2}`)
+
+// StripBlobs replaces long base64 runs in s with their decoded size.
+func StripBlobs(s string) string {
+	return blob.ReplaceAllStringFunc(s, func(m string) string {
+		return "[base64: " + strconv.Itoa(base64.StdEncoding.DecodedLen(len(m))) + " bytes]"
+	})
+}
+
+// Mask masks s without counting matches, for text headed to logs or
+// captures. Base64 blobs are stripped even by a nil Redactor, which
+// otherwise returns s unchanged.
+func (r *Redactor) Mask(s string) string {
+	s = StripBlobs(s)
+	if r == nil {
+		return s
+	}
+	return r.String(s, map[string]int{})
+}
+
+// String masks s and adds the number of matches per detector to counts.
+func (r *Redactor) String(s string, counts map[string]int) string {
+	for _, d := range r.detectors {
+		s = d.re.ReplaceAllStringFunc(s, func(m string) string {
+			if d.valid != nil && !d.valid(m) {
+				return m
+			}
+			counts[d.name]++
+			return d.replacement
+		})
+	}
+	return s
+}
+
+// skipFields hold values that are never free text.
+var skipFields = map[string]bool{"model": true, "images": true, "format": true, "keep_alive": true}
+
+// Body masks every string value in a JSON request body. It returns the
+// body unchanged (and no counts) if it isn't JSON or nothing matched.
+func (r *Redactor) Body(body []byte) ([]byte, map[string]int) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var v any
+	if dec.Decode(&v) != nil {
+		return body, nil
+	}
+	counts := map[string]int{}
+	v = r.walk(v, counts)
+	if len(counts) == 0 {
+		return body, nil
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return body, nil
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), counts
+}
+
+func (r *Redactor) walk(v any, counts map[string]int) any {
+	switch t := v.(type) {
+	case string:
+		return r.String(t, counts)
+	case []any:
+		for i := range t {
+			t[i] = r.walk(t[i], counts)
+		}
+	case map[string]any:
+		for k, x := range t {
+			if !skipFields[k] {
+				t[k] = r.walk(x, counts)
+			}
+		}
+	}
+	return v
+}
+
+// Report formats counts for the ReportHeader.
+func Report(counts map[string]int) string {
+	parts := make([]string, 0, len(counts))
+	for name, n := range counts {
+		parts = append(parts, name+"="+strconv.Itoa(n))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ", ")
+}
+
+// Middleware masks request bodies before passing them on. What was masked
+// is reported to the client in the ReportHeader and counted in metrics;
+// the masked values themselves are never logged.
+func (r *Redactor) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, err := ollama.PeekBody(req)
+		if err != nil {
+			apierror.BodyError(w, err)
+			return
+		}
+		if len(body) > 0 {
+			masked, counts := r.Body(body)
+			if len(counts) > 0 {
+				ollama.ReplaceBody(req, masked)
+				for name, n := range counts {
+					redactions.With(name).Add(float64(n))
+				}
+				report := Report(counts)
+				w.Header().Set(ReportHeader, report)
+				slog.InfoContext(req.Context(), "redacted", "method", req.Method, "path", req.URL.Path, "counts", report)
+			}
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// luhn reports whether the digits in s pass the Luhn checksum.
+func luhn(s string) bool {
+	sum, n := 0, 0
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if n%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+	return n >= 13 && sum%10 == 0
+}
+
+// validSSN rejects numbers the SSA never issues (area 000, 666 or 9xx,
+// group 00, serial 0000), which cuts false positives on similar formats.
+func validSSN(s string) bool {
+	area, group, serial := s[0:3], s[4:6], s[7:11]
+	return area != "000" && area != "666" && area[0] != '9' && group != "00" && serial != "0000"
+}
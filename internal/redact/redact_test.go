@@ -0,0 +1,97 @@
+package redact
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBody(t *testing.T) {
+	r, err := New(Config{
+		Detectors: []string{"all"},
+		Rules:     []Rule{{Name: "ticket", Pattern: `JIRA-\d+`, Replacement: "[TICKET]"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := `{"model":"bob@example.com","options":{"num_ctx":8192},"messages":[{"role":"user","content":` +
+		`"mail alice@example.com, card 4111 1111 1111 1111, not a card 1234 5678 9012 3456, ssn 123-45-6789, bad ssn 000-12-3456, see JIRA-42 <ok>"}]}`
+	out, counts := r.Body([]byte(in))
+
+	var m struct {
+		Model    string         `json:"model"`
+		Options  map[string]any `json:"options"`
+		Messages []struct {
+			Content string `json:"content"`
+		} `json:"messages"`
+	}
+	if err := json.Unmarshal(out, &m); err != nil {
+		t.Fatalf("output is not JSON: %v\n%s", err, out)
+	}
+	want := "mail [REDACTED:EMAIL], card [REDACTED:CREDIT_CARD], not a card 1234 5678 9012 3456, ssn [REDACTED:SSN], bad ssn 000-12-3456, see [TICKET] <ok>"
+	if got := m.Messages[0].Content; got != want {
+		t.Fatalf("content =\n%s\nwant\n%s", got, want)
+	}
+	if m.Model != "bob@example.com" {
+		t.Fatalf("model field was redacted: %q", m.Model)
+	}
+	if !strings.Contains(string(out), `"num_ctx":8192`) {
+		t.Fatalf("number not preserved: %s", out)
+	}
+	if got := Report(counts); got != "credit_card=1, email=1, ssn=1, ticket=1" {
+		t.Fatalf("report = %q", got)
+	}
+
+	clean := []byte(`{"model":"m","prompt":"hello"}`)
+	if out, counts := r.Body(clean); string(out) != string(clean) || counts != nil {
+		t.Fatalf("clean body changed: %s %v", out, counts)
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	if _, err := New(Config{Detectors: []string{"phone"}}); err == nil {
+		t.Fatal("unknown detector accepted")
+	}
+	if _, err := New(Config{Rules: []Rule{{Name: "x", Pattern: "("}}}); err == nil {
+		t.Fatal("bad pattern accepted")
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	r, _ := New(Config{Detectors: []string{"email"}})
+	var got string
+	h := r.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		b, _ := io.ReadAll(req.Body)
+		got = string(b)
+		if req.ContentLength != int64(len(b)) {
+			t.Errorf("ContentLength %d != %d", req.ContentLength, len(b))
+		}
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("POST", "/api/generate", strings.NewReader(`{"prompt":"write to a@b.io"}`)))
+	if got != `{"prompt":"write to [REDACTED:EMAIL]"}` {
+		t.Fatalf("forwarded body = %s", got)
+	}
+	if rec.Header().Get(ReportHeader) != "email=1" {
+		t.Fatalf("report header = %q", rec.Header().Get(ReportHeader))
+	}
+}
+
+func TestMaskNil(t *testing.T) {
+	var r *Redactor
+	if got := r.Mask("alice@example.com"); got != "alice@example.com" {
+		t.Errorf("nil redactor changed the text: %q", got)
+	}
+}
+
+func TestMaskBlobs(t *testing.T) {
+	var r *Redactor
+	img := strings.Repeat("iVBORw0K", 100)
+	got := r.Mask(`{"prompt":"hi","images":["` + img + `"]}`)
+	if got != `{"prompt":"hi","images":["[base64: 600 bytes]"]}` {
+		t.Errorf("got %q", got)
+	}
+}
@@ -0,0 +1,54 @@
+// Package reload watches files for changes so keys and secrets can be
+// rotated without restarting the proxy.
+package reload
+
+import (
+	"os"
+	"time"
+)
+
+type stamp struct {
+	mod  time.Time
+	size int64
+	ok   bool
+}
+
+func stat(path string) stamp {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return stamp{}
+	}
+	return stamp{mod: fi.ModTime(), size: fi.Size(), ok: true}
+}
+
+// Watch polls paths every interval and calls fn once per round in which
+// any of them changed (modification time, size, or appearing/vanishing).
+// Polling is used instead of filesystem notifications because secrets
+// mounted by Docker and Kubernetes are replaced via symlink swaps that
+// inotify watches on the file itself don't see. Watch returns when stop
+// is closed.
+func Watch(paths []string, interval time.Duration, stop <-chan struct{}, fn func()) {
+	last := make([]stamp, len(paths))
+	for i, p := range paths {
+		last[i] = stat(p)
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+		}
+		changed := false
+		for i, p := range paths {
+			if s := stat(p); s != last[i] {
+				last[i] = s
+				changed = true
+			}
+		}
+		if changed {
+			fn()
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package reload
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.txt")
+	if err := os.WriteFile(path, []byte("a\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	changed := make(chan struct{}, 10)
+	stop := make(chan struct{})
+	defer close(stop)
+	go Watch([]string{path}, 10*time.Millisecond, stop, func() { changed <- struct{}{} })
+
+	select {
+	case <-changed:
+		t.Fatal("reload without a change")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if err := os.WriteFile(path, []byte("a\nb\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-changed:
+	case <-time.After(time.Second):
+		t.Fatal("change not detected")
+	}
+
+	os.Remove(path)
+	select {
+	case <-changed:
+	case <-time.After(time.Second):
+		t.Fatal("removal not detected")
+	}
+}
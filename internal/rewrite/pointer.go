@@ -0,0 +1,111 @@
+package rewrite
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parsePointer splits a JSON pointer (RFC 6901) into its tokens; "" is
+// the whole document.
+func parsePointer(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(s, "/") {
+		return nil, fmt.Errorf("path %q is not a JSON pointer", s)
+	}
+	toks := strings.Split(s[1:], "/")
+	for i, t := range toks {
+		toks[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(t)
+	}
+	return toks, nil
+}
+
+// get returns the value at ptr.
+func get(doc any, ptr []string) (any, bool) {
+	for _, t := range ptr {
+		switch node := doc.(type) {
+		case map[string]any:
+			v, ok := node[t]
+			if !ok {
+				return nil, false
+			}
+			doc = v
+		case []any:
+			i, err := strconv.Atoi(t)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, false
+			}
+			doc = node[i]
+		default:
+			return nil, false
+		}
+	}
+	return doc, true
+}
+
+// edit sets, replaces or removes the value at ptr, returning the updated
+// node and whether anything changed. set creates missing objects on the
+// way. Arrays are returned anew, since appending and removing move them.
+func edit(node any, ptr []string, kind string, v any) (any, bool) {
+	if len(ptr) == 0 {
+		return v, true
+	}
+	t, last := ptr[0], len(ptr) == 1
+	switch n := node.(type) {
+	case map[string]any:
+		child, exists := n[t]
+		if last {
+			switch {
+			case kind == "remove" && exists:
+				delete(n, t)
+			case kind == "set" || (kind == "replace" && exists):
+				n[t] = v
+			default:
+				return n, false
+			}
+			return n, true
+		}
+		if !exists {
+			if kind != "set" {
+				return n, false
+			}
+			child = map[string]any{}
+		}
+		nc, ok := edit(child, ptr[1:], kind, v)
+		if !ok && !exists {
+			return n, false
+		}
+		n[t] = nc
+		return n, ok
+	case []any:
+		if last && t == "-" && kind == "set" {
+			return append(n, v), true
+		}
+		i, err := strconv.Atoi(t)
+		if err != nil || i < 0 || i > len(n) {
+			return n, false
+		}
+		if last {
+			switch {
+			case i == len(n) && kind == "set":
+				return append(n, v), true
+			case i == len(n):
+				return n, false
+			case kind == "remove":
+				return append(n[:i:i], n[i+1:]...), true
+			default:
+				n[i] = v
+				return n, true
+			}
+		}
+		if i == len(n) {
+			return n, false
+		}
+		nc, ok := edit(n[i], ptr[1:], kind, v)
+		n[i] = nc
+		return n, ok
+	}
+	return node, false
+}
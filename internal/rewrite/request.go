@@ -0,0 +1,138 @@
+package rewrite
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path"
+	"strconv"
+
+	"github.com/yeti47/ollama-proxy/internal/access"
+	"github.com/yeti47/ollama-proxy/internal/auth"
+	"github.com/yeti47/ollama-proxy/internal/metrics"
+	"github.com/yeti47/ollama-proxy/internal/ollama"
+)
+
+var mutated = metrics.NewCounter("ollama_proxy_request_rewrites_total",
+	"Request bodies changed by a request rewrite rule before forwarding, by rule.", "rule")
+
+// RequestRule changes request bodies before they are forwarded, read
+// from the "request_rewrites" section of the config file. It uses the
+// same ops as Rule.
+type RequestRule struct {
+	// Name labels the rule in logs and metrics; it defaults to Path.
+	Name string `json:"name,omitempty"`
+	// Path matches the request path, with path.Match wildcards.
+	Path string `json:"path"`
+	// Models restricts the rule to requests for these models; patterns
+	// such as "llama3*" work as in model allowlists.
+	Models []string `json:"models,omitempty"`
+	// Clients restricts the rule to these client or tenant names.
+	Clients []string `json:"clients,omitempty"`
+	Ops     []Op     `json:"ops"`
+}
+
+// RequestRules is a compiled, ordered set of request rules.
+type RequestRules struct {
+	rules []*requestRule
+}
+
+type requestRule struct {
+	*rule
+	models  []string
+	clients map[string]bool
+}
+
+// NewRequest compiles rules.
+func NewRequest(rules []RequestRule) (*RequestRules, error) {
+	rs := &RequestRules{}
+	for i, r := range rules {
+		c, err := compile(Rule{Name: r.Name, Path: r.Path, Ops: r.Ops})
+		if err != nil {
+			name := r.Name
+			if name == "" {
+				name = "#" + strconv.Itoa(i)
+			}
+			return nil, fmt.Errorf("request rewrite %s: %w", name, err)
+		}
+		rr := &requestRule{rule: c, models: r.Models}
+		if len(r.Clients) > 0 {
+			rr.clients = map[string]bool{}
+			for _, name := range r.Clients {
+				rr.clients[name] = true
+			}
+		}
+		rs.rules = append(rs.rules, rr)
+	}
+	return rs, nil
+}
+
+// Len reports the number of rules.
+func (rs *RequestRules) Len() int {
+	if rs == nil {
+		return 0
+	}
+	return len(rs.rules)
+}
+
+// matches reports whether rr applies to a request from id for model.
+func (rr *requestRule) matches(id *auth.Identity, model string) bool {
+	if rr.clients != nil && (id == nil || !(rr.clients[id.Name] || (id.Tenant != "" && rr.clients[id.Tenant]))) {
+		return false
+	}
+	return len(rr.models) == 0 || access.ModelAllowed(rr.models, model)
+}
+
+// Apply changes the JSON body of r by the matching rules.
+func (rs *RequestRules) Apply(r *http.Request) {
+	if rs.Len() == 0 || r.Body == nil || r.Body == http.NoBody {
+		return
+	}
+	var rules []*requestRule
+	for _, rr := range rs.rules {
+		if ok, _ := path.Match(rr.Path, r.URL.Path); ok {
+			rules = append(rules, rr)
+		}
+	}
+	if len(rules) == 0 {
+		return
+	}
+	b, err := ollama.PeekBody(r)
+	if err != nil {
+		return
+	}
+	doc, err := decode(b)
+	if err != nil {
+		return
+	}
+	id := auth.FromContext(r.Context())
+	changed := false
+	for _, rr := range rules {
+		// an earlier rule may have changed the model
+		var model string
+		if m, ok := doc.(map[string]any); ok {
+			model, _ = m["model"].(string)
+		}
+		if !rr.matches(id, model) {
+			continue
+		}
+		var ok bool
+		if doc, ok = rr.apply(doc); ok {
+			changed = true
+			mutated.With(rr.Name).Inc()
+			slog.DebugContext(r.Context(), "request rewritten", "rule", rr.Name)
+		}
+	}
+	if !changed {
+		return
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(doc); err != nil {
+		return
+	}
+	ollama.ReplaceBody(r, bytes.TrimSuffix(buf.Bytes(), []byte("\n")))
+}
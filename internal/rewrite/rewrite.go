@@ -0,0 +1,386 @@
+// Package rewrite edits upstream JSON responses according to configured
+// rules. A rule matches on path, status and content type, and applies
+// JSON-pointer operations to the body: whole JSON documents, or each line
+// of an NDJSON stream. The /api/version fixup for upstreams that report
+// 0.0.0 is one such rule, built in.
+package rewrite
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+
+	"github.com/yeti47/ollama-proxy/internal/metrics"
+)
+
+// MaxBody is the largest JSON document that is rewritten; bigger ones
+// pass unchanged.
+const MaxBody = 8 << 20
+
+var applied = metrics.NewCounter("ollama_proxy_response_rewrites_total",
+	"Responses (or NDJSON lines) changed by a rewrite rule, by rule.", "rule")
+
+// Rule is one rewrite, read from the "response_rewrites" section of the
+// config file.
+type Rule struct {
+	// Name labels the rule in logs and metrics; it defaults to Path.
+	Name string `json:"name,omitempty"`
+	// Path matches the request path, with path.Match wildcards such as
+	// /api/*.
+	Path string `json:"path"`
+	// Status lists the response statuses to rewrite; by default any 2xx.
+	Status []int `json:"status,omitempty"`
+	// ContentType restricts the rule to application/json or
+	// application/x-ndjson; by default it applies to both.
+	ContentType string `json:"content_type,omitempty"`
+	Ops         []Op   `json:"ops"`
+}
+
+// Op is one operation on the JSON document.
+type Op struct {
+	// Op is one of:
+	//   test     go on with the rule only if Path exists and, if given,
+	//            equals Value or, as a string, matches the Matches regexp
+	//   set      set Path to Value, adding it and any missing objects
+	//            on the way; "-" appends to an array
+	//   replace  set Path to Value if it exists
+	//   remove   remove Path if it exists
+	Op   string `json:"op"`
+	Path string `json:"path"`
+	// Value is the JSON value to set, or to test against.
+	Value   json.RawMessage `json:"value,omitempty"`
+	Matches string          `json:"matches,omitempty"`
+}
+
+// Rules is a compiled, ordered set of rules.
+type Rules struct {
+	rules []*rule
+}
+
+type rule struct {
+	Rule
+	ops []op
+}
+
+type op struct {
+	kind    string
+	pointer []string
+	value   any
+	hasVal  bool
+	matches *regexp.Regexp
+}
+
+// New compiles rules.
+func New(rules []Rule) (*Rules, error) {
+	rs := &Rules{}
+	for i, r := range rules {
+		c, err := compile(r)
+		if err != nil {
+			name := r.Name
+			if name == "" {
+				name = "#" + strconv.Itoa(i)
+			}
+			return nil, fmt.Errorf("response rewrite %s: %w", name, err)
+		}
+		rs.rules = append(rs.rules, c)
+	}
+	return rs, nil
+}
+
+func compile(r Rule) (*rule, error) {
+	if r.Path == "" {
+		return nil, fmt.Errorf("path is required")
+	}
+	if _, err := path.Match(r.Path, "/"); err != nil {
+		return nil, fmt.Errorf("path %q: %w", r.Path, err)
+	}
+	switch r.ContentType {
+	case "", "application/json", "application/x-ndjson":
+	default:
+		return nil, fmt.Errorf("content_type must be application/json or application/x-ndjson")
+	}
+	if len(r.Ops) == 0 {
+		return nil, fmt.Errorf("no ops")
+	}
+	if r.Name == "" {
+		r.Name = r.Path
+	}
+	c := &rule{Rule: r}
+	for i, o := range r.Ops {
+		ptr, err := parsePointer(o.Path)
+		if err != nil {
+			return nil, fmt.Errorf("ops[%d]: %w", i, err)
+		}
+		cop := op{kind: o.Op, pointer: ptr}
+		if len(o.Value) > 0 {
+			if cop.value, err = decode(o.Value); err != nil {
+				return nil, fmt.Errorf("ops[%d]: value: %w", i, err)
+			}
+			cop.hasVal = true
+		}
+		switch o.Op {
+		case "test":
+			if o.Matches != "" {
+				if cop.matches, err = regexp.Compile(o.Matches); err != nil {
+					return nil, fmt.Errorf("ops[%d]: matches: %w", i, err)
+				}
+			}
+		case "set", "replace":
+			if !cop.hasVal {
+				return nil, fmt.Errorf("ops[%d]: %s needs a value", i, o.Op)
+			}
+		case "remove":
+			if len(ptr) == 0 {
+				return nil, fmt.Errorf("ops[%d]: can't remove the whole document", i)
+			}
+		default:
+			return nil, fmt.Errorf("ops[%d]: unknown op %q, want test, set, replace or remove", i, o.Op)
+		}
+		c.ops = append(c.ops, cop)
+	}
+	return c, nil
+}
+
+// VersionFix is the built-in rule that replaces the 0.0.0 some upstreams
+// report from /api/version, which clients that check the version reject.
+func VersionFix(fallback string) Rule {
+	v, _ := json.Marshal(fallback)
+	return Rule{
+		Name:   "version-fallback",
+		Path:   "/api/version",
+		Status: []int{http.StatusOK},
+		Ops: []Op{
+			{Op: "test", Path: "/version", Matches: `^0\.0\.0(\.0)?$`},
+			{Op: "replace", Path: "/version", Value: v},
+		},
+	}
+}
+
+// MustNew is New for rules known to be valid, such as the built-in ones.
+func MustNew(rules ...Rule) *Rules {
+	rs, err := New(rules)
+	if err != nil {
+		panic(err)
+	}
+	return rs
+}
+
+// Join returns the rules of a followed by those of b; either may be nil.
+func Join(a, b *Rules) *Rules {
+	out := &Rules{}
+	for _, rs := range []*Rules{a, b} {
+		if rs != nil {
+			out.rules = append(out.rules, rs.rules...)
+		}
+	}
+	return out
+}
+
+// Len reports the number of rules.
+func (rs *Rules) Len() int {
+	if rs == nil {
+		return 0
+	}
+	return len(rs.rules)
+}
+
+// matching returns the rules for a response to path.
+func (rs *Rules) matching(reqPath string, status int, mediaType string) []*rule {
+	var out []*rule
+	for _, r := range rs.rules {
+		if ok, _ := path.Match(r.Path, reqPath); !ok {
+			continue
+		}
+		if len(r.Status) > 0 {
+			found := false
+			for _, s := range r.Status {
+				found = found || s == status
+			}
+			if !found {
+				continue
+			}
+		} else if status < 200 || status > 299 {
+			continue
+		}
+		if r.ContentType != "" && r.ContentType != mediaType {
+			continue
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+// Apply rewrites resp, the response to a request for reqPath, fixing up
+// its length headers.
+func (rs *Rules) Apply(resp *http.Response, reqPath string) {
+	if rs.Len() == 0 || resp.Body == nil {
+		return
+	}
+	if ce := resp.Header.Get("Content-Encoding"); ce != "" && ce != "identity" {
+		return
+	}
+	mt, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mt != "application/json" && mt != "application/x-ndjson" {
+		return
+	}
+	rules := rs.matching(reqPath, resp.StatusCode, mt)
+	if len(rules) == 0 {
+		return
+	}
+	if mt == "application/x-ndjson" {
+		resp.Body = &lineReader{src: bufio.NewReader(resp.Body), body: resp.Body, rules: rules}
+		resp.ContentLength = -1
+		resp.Header.Del("Content-Length")
+		return
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, MaxBody+1))
+	rest := resp.Body
+	if err != nil || len(b) > MaxBody {
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(b), rest), rest}
+		return
+	}
+	rest.Close()
+	if nb, ok := rewrite(b, rules); ok {
+		b = nb
+		resp.ContentLength = int64(len(b))
+		resp.Header.Set("Content-Length", strconv.Itoa(len(b)))
+		// the length is known now; a chunked upstream encoding would
+		// conflict with it
+		resp.Header.Del("Transfer-Encoding")
+		resp.TransferEncoding = nil
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(b))
+}
+
+// rewrite applies rules to one JSON document, reporting whether any of
+// them changed it.
+func rewrite(b []byte, rules []*rule) ([]byte, bool) {
+	doc, err := decode(b)
+	if err != nil {
+		return b, false
+	}
+	changed := false
+	for _, r := range rules {
+		var ok bool
+		if doc, ok = r.apply(doc); ok {
+			changed = true
+			applied.With(r.Name).Inc()
+			slog.Debug("response rewritten", "rule", r.Name)
+		}
+	}
+	if !changed {
+		return b, false
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	// keep <, > and & as the upstream sent them
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(doc); err != nil {
+		return b, false
+	}
+	out := buf.Bytes()
+	if !bytes.HasSuffix(b, []byte("\n")) {
+		out = bytes.TrimSuffix(out, []byte("\n"))
+	}
+	return out, true
+}
+
+// apply runs the rule's ops on doc until a test fails, reporting whether
+// any op changed it.
+func (r *rule) apply(doc any) (any, bool) {
+	changed := false
+	for _, o := range r.ops {
+		if o.kind == "test" {
+			if !o.test(doc) {
+				return doc, changed
+			}
+			continue
+		}
+		var ok bool
+		if doc, ok = edit(doc, o.pointer, o.kind, o.value); ok {
+			changed = true
+		}
+	}
+	return doc, changed
+}
+
+func (o op) test(doc any) bool {
+	v, ok := get(doc, o.pointer)
+	if !ok {
+		return false
+	}
+	if o.matches != nil {
+		var s string
+		switch v := v.(type) {
+		case string:
+			s = v
+		case json.Number:
+			s = v.String()
+		case bool:
+			s = strconv.FormatBool(v)
+		default:
+			return false
+		}
+		if !o.matches.MatchString(s) {
+			return false
+		}
+	}
+	if o.hasVal {
+		a, _ := json.Marshal(v)
+		b, _ := json.Marshal(o.value)
+		return bytes.Equal(a, b)
+	}
+	return true
+}
+
+// lineReader rewrites an NDJSON stream line by line as it is read.
+type lineReader struct {
+	src   *bufio.Reader
+	body  io.Closer
+	rules []*rule
+	buf   []byte
+	err   error
+}
+
+func (l *lineReader) Read(p []byte) (int, error) {
+	for len(l.buf) == 0 {
+		if l.err != nil {
+			return 0, l.err
+		}
+		line, err := l.src.ReadBytes('\n')
+		l.err = err
+		if len(bytes.TrimSpace(line)) > 0 {
+			if nl, ok := rewrite(line, l.rules); ok {
+				line = nl
+			}
+		}
+		l.buf = line
+	}
+	n := copy(p, l.buf)
+	l.buf = l.buf[n:]
+	return n, nil
+}
+
+func (l *lineReader) Close() error { return l.body.Close() }
+
+// decode parses JSON keeping numbers as written.
+func decode(b []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
@@ -0,0 +1,172 @@
+package rewrite
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/yeti47/ollama-proxy/internal/auth"
+)
+
+func response(status int, contentType, body string) *http.Response {
+	return &http.Response{
+		StatusCode:    status,
+		Header:        http.Header{"Content-Type": {contentType}, "Content-Length": {"1"}},
+		Body:          io.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+	}
+}
+
+func body(t *testing.T, resp *http.Response) string {
+	t.Helper()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestVersionFix(t *testing.T) {
+	rs := MustNew(VersionFix("0.15.2"))
+	for in, want := range map[string]string{
+		`{"version":"0.0.0"}`:   `{"version":"0.15.2"}`,
+		`{"version":"0.0.0.0"}`: `{"version":"0.15.2"}`,
+		`{"version":"0.12.1"}`:  `{"version":"0.12.1"}`,
+	} {
+		resp := response(200, "application/json; charset=utf-8", in)
+		rs.Apply(resp, "/api/version")
+		if got := body(t, resp); got != want {
+			t.Errorf("%s: got %s, want %s", in, got, want)
+		}
+		if resp.ContentLength != int64(len(want)) {
+			t.Errorf("%s: content length %d", in, resp.ContentLength)
+		}
+	}
+}
+
+func TestOps(t *testing.T) {
+	rs, err := New([]Rule{{
+		Path: "/api/*",
+		Ops: []Op{
+			{Op: "set", Path: "/details/family", Value: json.RawMessage(`"llama"`)},
+			{Op: "set", Path: "/tags/-", Value: json.RawMessage(`"new"`)},
+			{Op: "replace", Path: "/missing", Value: json.RawMessage(`1`)},
+			{Op: "replace", Path: "/tags/0", Value: json.RawMessage(`"first"`)},
+			{Op: "remove", Path: "/license"},
+			{Op: "remove", Path: "/a~1b"},
+		},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := response(200, "application/json", `{"details":{},"tags":["x"],"license":"long text","a/b":1,"n":1.50,"html":"<b>"}`)
+	rs.Apply(resp, "/api/show")
+	want := `{"details":{"family":"llama"},"html":"<b>","n":1.50,"tags":["first","new"]}`
+	if got := body(t, resp); got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
+
+func TestMatching(t *testing.T) {
+	rs := MustNew(Rule{Path: "/api/tags", Ops: []Op{{Op: "set", Path: "/x", Value: json.RawMessage(`1`)}}})
+	for _, c := range []struct {
+		path, ct string
+		status   int
+	}{
+		{"/api/ps", "application/json", 200},
+		{"/api/tags", "text/plain", 200},
+		{"/api/tags", "application/json", 500},
+	} {
+		resp := response(c.status, c.ct, `{}`)
+		rs.Apply(resp, c.path)
+		if got := body(t, resp); got != `{}` {
+			t.Errorf("%+v rewritten: %s", c, got)
+		}
+	}
+	// the rest of a rule is skipped after a failed test
+	rs = MustNew(Rule{Path: "/p", Status: []int{404}, Ops: []Op{
+		{Op: "test", Path: "/error", Value: json.RawMessage(`"gone"`)},
+		{Op: "set", Path: "/error", Value: json.RawMessage(`"model not found"`)},
+	}})
+	resp := response(404, "application/json", `{"error":"other"}`)
+	rs.Apply(resp, "/p")
+	if got := body(t, resp); got != `{"error":"other"}` {
+		t.Errorf("failed test still rewrote: %s", got)
+	}
+	resp = response(404, "application/json", `{"error":"gone"}`)
+	rs.Apply(resp, "/p")
+	if got := body(t, resp); got != `{"error":"model not found"}` {
+		t.Errorf("got %s", got)
+	}
+}
+
+func TestNDJSON(t *testing.T) {
+	rs := MustNew(Rule{Path: "/api/generate", ContentType: "application/x-ndjson", Ops: []Op{
+		{Op: "test", Path: "/done", Value: json.RawMessage(`true`)},
+		{Op: "remove", Path: "/context"},
+	}})
+	resp := response(200, "application/x-ndjson", "{\"response\":\"a\",\"done\":false}\n{\"done\":true,\"context\":[1,2]}\n")
+	rs.Apply(resp, "/api/generate")
+	want := "{\"response\":\"a\",\"done\":false}\n{\"done\":true}\n"
+	if got := body(t, resp); got != want {
+		t.Errorf("got %q", got)
+	}
+	if resp.Header.Get("Content-Length") != "" || resp.ContentLength != -1 {
+		t.Errorf("length kept for a rewritten stream")
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	for _, r := range []Rule{
+		{Ops: []Op{{Op: "remove", Path: "/x"}}},
+		{Path: "/x"},
+		{Path: "/x", Ops: []Op{{Op: "move", Path: "/x"}}},
+		{Path: "/x", Ops: []Op{{Op: "set", Path: "x", Value: json.RawMessage(`1`)}}},
+		{Path: "/x", Ops: []Op{{Op: "set", Path: "/x"}}},
+		{Path: "/x", Ops: []Op{{Op: "test", Path: "/x", Matches: "("}}},
+		{Path: "/x", ContentType: "text/plain", Ops: []Op{{Op: "remove", Path: "/x"}}},
+	} {
+		if _, err := New([]Rule{r}); err == nil {
+			t.Errorf("%+v accepted", r)
+		}
+	}
+}
+
+func TestRequestRules(t *testing.T) {
+	rs, err := NewRequest([]RequestRule{
+		{Name: "ctx", Path: "/api/*", Models: []string{"llama3*"}, Ops: []Op{{Op: "set", Path: "/options/num_ctx", Value: json.RawMessage(`8192`)}}},
+		{Name: "keep", Path: "/api/chat", Clients: []string{"team-a"}, Ops: []Op{{Op: "set", Path: "/keep_alive", Value: json.RawMessage(`"1h"`)}}},
+		{Name: "strip", Path: "/api/chat", Ops: []Op{{Op: "remove", Path: "/unsupported"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	send := func(path, body string, id *auth.Identity) string {
+		r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		if id != nil {
+			r = r.WithContext(auth.WithIdentity(r.Context(), id))
+		}
+		rs.Apply(r)
+		b, _ := io.ReadAll(r.Body)
+		if r.ContentLength != int64(len(b)) {
+			t.Errorf("content length %d for %d bytes", r.ContentLength, len(b))
+		}
+		return string(b)
+	}
+	if got := send("/api/chat", `{"model":"llama3.1:8b","options":{"seed":1},"unsupported":1}`, nil); got != `{"model":"llama3.1:8b","options":{"num_ctx":8192,"seed":1}}` {
+		t.Errorf("got %s", got)
+	}
+	if got := send("/api/generate", `{"model":"llama3"}`, nil); got != `{"model":"llama3","options":{"num_ctx":8192}}` {
+		t.Errorf("missing options: got %s", got)
+	}
+	if got := send("/api/chat", `{"model":"qwen3"}`, &auth.Identity{Name: "k1", Tenant: "team-a"}); got != `{"keep_alive":"1h","model":"qwen3"}` {
+		t.Errorf("tenant rule: got %s", got)
+	}
+	// untouched bodies are forwarded byte for byte
+	if got := send("/api/generate", `{ "model": "qwen3" }`, &auth.Identity{Name: "team-a"}); got != `{ "model": "qwen3" }` {
+		t.Errorf("unmatched request changed: %s", got)
+	}
+}
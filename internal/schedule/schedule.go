@@ -0,0 +1,208 @@
+// Package schedule describes weekly time windows in which a key may be
+// used, such as "mon-fri 08:00-20:00".
+package schedule
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var dayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// window is one weekly window. It starts at start minutes past midnight
+// on each day in days and ends at end; if end <= start it runs past
+// midnight into the next day.
+type window struct {
+	days       [7]bool
+	start, end int
+}
+
+// Schedule is a set of weekly windows in a time zone. The zero value has no
+// windows and allows nothing; a nil *Schedule allows everything.
+type Schedule struct {
+	// Timezone is an IANA zone name such as "Europe/Berlin"; empty means
+	// the proxy's local time.
+	Timezone string `json:"timezone,omitempty"`
+	// Windows are of the form "[days] HH:MM-HH:MM", where days is a
+	// comma-separated list of days or day ranges ("mon-fri,sun"); without
+	// days the window applies every day.
+	Windows []string `json:"windows"`
+
+	loc     *time.Location
+	windows []window
+}
+
+// New parses windows in the named time zone.
+func New(timezone string, windows []string) (*Schedule, error) {
+	s := &Schedule{Timezone: timezone, Windows: windows}
+	if err := s.compile(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Schedule) compile() error {
+	loc := time.Local
+	if s.Timezone != "" {
+		var err error
+		if loc, err = time.LoadLocation(s.Timezone); err != nil {
+			return fmt.Errorf("schedule: %w", err)
+		}
+	}
+	if len(s.Windows) == 0 {
+		return fmt.Errorf("schedule: at least one window is required")
+	}
+	ws := make([]window, 0, len(s.Windows))
+	for _, spec := range s.Windows {
+		w, err := parseWindow(spec)
+		if err != nil {
+			return fmt.Errorf("schedule: window %q: %w", spec, err)
+		}
+		ws = append(ws, w)
+	}
+	s.loc, s.windows = loc, ws
+	return nil
+}
+
+// UnmarshalJSON parses and validates a schedule.
+func (s *Schedule) UnmarshalJSON(b []byte) error {
+	type plain Schedule
+	var p plain
+	if err := json.Unmarshal(b, &p); err != nil {
+		return err
+	}
+	*s = Schedule(p)
+	return s.compile()
+}
+
+func parseWindow(spec string) (window, error) {
+	var w window
+	fields := strings.Fields(spec)
+	var days, times string
+	switch len(fields) {
+	case 1:
+		days, times = "*", fields[0]
+	case 2:
+		days, times = fields[0], fields[1]
+	default:
+		return w, fmt.Errorf(`want "[days] HH:MM-HH:MM"`)
+	}
+
+	for _, part := range strings.Split(strings.ToLower(days), ",") {
+		if part == "*" || part == "daily" {
+			w.days = [7]bool{true, true, true, true, true, true, true}
+			continue
+		}
+		from, to, isRange := strings.Cut(part, "-")
+		a, ok := dayNames[from]
+		if !ok {
+			return w, fmt.Errorf("unknown day %q", from)
+		}
+		b := a
+		if isRange {
+			if b, ok = dayNames[to]; !ok {
+				return w, fmt.Errorf("unknown day %q", to)
+			}
+		}
+		for d := a; ; d = (d + 1) % 7 {
+			w.days[d] = true
+			if d == b {
+				break
+			}
+		}
+	}
+
+	start, end, ok := strings.Cut(times, "-")
+	if !ok {
+		return w, fmt.Errorf("time range must be HH:MM-HH:MM")
+	}
+	var err error
+	if w.start, err = parseClock(start); err != nil {
+		return w, err
+	}
+	if w.end, err = parseClock(end); err != nil {
+		return w, err
+	}
+	if w.start == w.end {
+		return w, fmt.Errorf("window is empty")
+	}
+	return w, nil
+}
+
+// parseClock parses HH:MM into minutes past midnight. 24:00 is accepted
+// as the end of the day.
+func parseClock(s string) (int, error) {
+	h, m, ok := strings.Cut(s, ":")
+	hh, err1 := strconv.Atoi(h)
+	mm, err2 := strconv.Atoi(m)
+	if !ok || err1 != nil || err2 != nil || hh < 0 || mm < 0 || mm > 59 || hh > 24 || (hh == 24 && mm != 0) {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	return hh*60 + mm, nil
+}
+
+// Allowed reports whether t falls into one of the windows.
+func (s *Schedule) Allowed(t time.Time) bool {
+	if s == nil {
+		return true
+	}
+	lt := t.In(s.loc)
+	d := lt.Weekday()
+	m := lt.Hour()*60 + lt.Minute()
+	for _, w := range s.windows {
+		if w.start < w.end {
+			if w.days[d] && m >= w.start && m < w.end {
+				return true
+			}
+			continue
+		}
+		if (w.days[d] && m >= w.start) || (w.days[(d+6)%7] && m < w.end) {
+			return true
+		}
+	}
+	return false
+}
+
+// Next returns the start of the first window that opens after t, and
+// false if the schedule has no windows.
+func (s *Schedule) Next(t time.Time) (time.Time, bool) {
+	if s == nil || len(s.windows) == 0 {
+		return time.Time{}, false
+	}
+	lt := t.In(s.loc)
+	var best time.Time
+	for i := 0; i <= 7; i++ {
+		day := lt.AddDate(0, 0, i)
+		for _, w := range s.windows {
+			if !w.days[day.Weekday()] {
+				continue
+			}
+			start := time.Date(day.Year(), day.Month(), day.Day(), 0, w.start, 0, 0, s.loc)
+			if start.After(t) && (best.IsZero() || start.Before(best)) {
+				best = start
+			}
+		}
+		if !best.IsZero() {
+			return best, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// String returns the windows and zone in their config form.
+func (s *Schedule) String() string {
+	if s == nil {
+		return "always"
+	}
+	out := strings.Join(s.Windows, ", ")
+	if s.Timezone != "" {
+		out += " (" + s.Timezone + ")"
+	}
+	return out
+}
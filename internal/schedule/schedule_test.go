@@ -0,0 +1,60 @@
+package schedule
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestAllowedAndNext(t *testing.T) {
+	s, err := New("Europe/Berlin", []string{"mon-fri 08:00-20:00", "sat 22:00-02:00"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+	at := func(day, hh, mm int) time.Time { return time.Date(2026, 10, day, hh, mm, 0, 0, berlin) }
+	// 2026-10-16 is a Friday.
+	for _, tc := range []struct {
+		t    time.Time
+		want bool
+		next time.Time
+	}{
+		{at(16, 8, 0), true, at(17, 22, 0)},
+		{at(16, 19, 59), true, at(17, 22, 0)},
+		{at(16, 20, 0), false, at(17, 22, 0)},
+		{at(17, 23, 30), true, at(19, 8, 0)},
+		{at(18, 1, 59), true, at(19, 8, 0)},
+		{at(18, 2, 0), false, at(19, 8, 0)},
+		{at(19, 7, 0), false, at(19, 8, 0)},
+	} {
+		if got := s.Allowed(tc.t.UTC()); got != tc.want {
+			t.Errorf("Allowed(%s) = %t, want %t", tc.t, got, tc.want)
+		}
+		if got, ok := s.Next(tc.t); !ok || !got.Equal(tc.next) {
+			t.Errorf("Next(%s) = %s, want %s", tc.t, got, tc.next)
+		}
+	}
+
+	var nilSchedule *Schedule
+	if !nilSchedule.Allowed(time.Now()) {
+		t.Error("nil schedule should allow everything")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, w := range []string{"", "mon", "funday 08:00-10:00", "mon 08:00", "mon 25:00-26:00", "mon 08:00-08:00", "a b c"} {
+		if _, err := New("", []string{w}); err == nil {
+			t.Errorf("New(%q) succeeded", w)
+		}
+	}
+	if _, err := New("Mars/Olympus", []string{"09:00-17:00"}); err == nil {
+		t.Error("unknown time zone accepted")
+	}
+	var s Schedule
+	if err := json.Unmarshal([]byte(`{"windows":["sat,sun 10:00-24:00"]}`), &s); err != nil {
+		t.Fatal(err)
+	}
+	if !s.Allowed(time.Date(2026, 10, 18, 23, 59, 0, 0, time.Local)) {
+		t.Error("Sunday 23:59 should be allowed")
+	}
+}
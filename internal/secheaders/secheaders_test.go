@@ -0,0 +1,41 @@
+package secheaders
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMiddleware(t *testing.T) {
+	c := New(map[string]string{"x-frame-options": "", "Permissions-Policy": "interest-cohort=()"}, 3600)
+	h := Middleware(c, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// an upstream value must not survive
+		w.Header().Set("Referrer-Policy", "unsafe-url")
+		w.Write([]byte("ok"))
+	}))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/api/tags", nil))
+	hdr := rec.Header()
+	if hdr.Get("X-Content-Type-Options") != "nosniff" || hdr.Get("Referrer-Policy") != "no-referrer" {
+		t.Fatalf("defaults missing: %v", hdr)
+	}
+	if _, ok := hdr["X-Frame-Options"]; ok {
+		t.Fatal("removed default still sent")
+	}
+	if hdr.Get("Permissions-Policy") != "interest-cohort=()" {
+		t.Fatal("custom header missing")
+	}
+	if hdr.Get("Strict-Transport-Security") != "" {
+		t.Fatal("HSTS sent over plain HTTP")
+	}
+
+	req := httptest.NewRequest("GET", "/api/tags", nil)
+	req.TLS = &tls.ConnectionState{}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Header().Get("Strict-Transport-Security") != "max-age=3600; includeSubDomains" {
+		t.Fatalf("HSTS = %q", rec.Header().Get("Strict-Transport-Security"))
+	}
+}
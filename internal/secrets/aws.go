@@ -0,0 +1,261 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AWSSecretsManager reads a secret from AWS Secrets Manager. Credentials
+// come from AWS_ACCESS_KEY_ID/AWS_SECRET_ACCESS_KEY/AWS_SESSION_TOKEN or,
+// when those aren't set, from the EC2 instance role via IMDSv2. The
+// region defaults to AWS_REGION, AWS_DEFAULT_REGION or the instance's
+// region.
+type AWSSecretsManager struct {
+	SecretID string
+	Region   string
+	// Endpoint overrides https://secretsmanager.<region>.amazonaws.com.
+	Endpoint string
+	// MetadataURL overrides the EC2 instance metadata service address.
+	MetadataURL string
+	Client      *http.Client
+
+	mu    sync.Mutex
+	creds awsCredentials
+}
+
+type awsCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Expires         time.Time
+}
+
+func (a *AWSSecretsManager) client() *http.Client {
+	if a.Client != nil {
+		return a.Client
+	}
+	return &http.Client{Timeout: 10 * time.Second}
+}
+
+func (a *AWSSecretsManager) metadataURL() string {
+	if a.MetadataURL != "" {
+		return strings.TrimRight(a.MetadataURL, "/")
+	}
+	return "http://169.254.169.254"
+}
+
+// Fetch implements Source.
+func (a *AWSSecretsManager) Fetch(ctx context.Context) (string, error) {
+	creds, err := a.credentials(ctx)
+	if err != nil {
+		return "", fmt.Errorf("aws credentials: %w", err)
+	}
+	region := a.Region
+	for _, env := range []string{"AWS_REGION", "AWS_DEFAULT_REGION"} {
+		if region == "" {
+			region = os.Getenv(env)
+		}
+	}
+	if region == "" {
+		if region, err = a.imds(ctx, "/latest/meta-data/placement/region"); err != nil {
+			return "", errors.New("no AWS region configured (set ?region=, AWS_REGION or run on EC2)")
+		}
+	}
+	endpoint := a.Endpoint
+	if endpoint == "" {
+		endpoint = "https://secretsmanager." + region + ".amazonaws.com"
+	}
+
+	body, _ := json.Marshal(map[string]string{"SecretId": a.SecretID})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	signV4(req, body, creds, region, "secretsmanager", time.Now())
+
+	resp, err := a.client().Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("secrets manager: status %d: %s", resp.StatusCode, bytes.TrimSpace(b))
+	}
+	var out struct {
+		SecretString string `json:"SecretString"`
+	}
+	if err := json.Unmarshal(b, &out); err != nil {
+		return "", fmt.Errorf("secrets manager: %w", err)
+	}
+	if out.SecretString == "" {
+		return "", fmt.Errorf("secret %s has no string value", a.SecretID)
+	}
+	return strings.TrimSpace(out.SecretString), nil
+}
+
+func (a *AWSSecretsManager) credentials(ctx context.Context) (awsCredentials, error) {
+	if id := os.Getenv("AWS_ACCESS_KEY_ID"); id != "" {
+		return awsCredentials{
+			AccessKeyID:     id,
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}, nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.creds.AccessKeyID != "" && time.Until(a.creds.Expires) > 5*time.Minute {
+		return a.creds, nil
+	}
+	role, err := a.imds(ctx, "/latest/meta-data/iam/security-credentials/")
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("no AWS_ACCESS_KEY_ID and no instance role: %w", err)
+	}
+	role, _, _ = strings.Cut(role, "\n")
+	raw, err := a.imds(ctx, "/latest/meta-data/iam/security-credentials/"+role)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	var c struct {
+		AccessKeyID     string    `json:"AccessKeyId"`
+		SecretAccessKey string    `json:"SecretAccessKey"`
+		Token           string    `json:"Token"`
+		Expiration      time.Time `json:"Expiration"`
+	}
+	if err := json.Unmarshal([]byte(raw), &c); err != nil {
+		return awsCredentials{}, fmt.Errorf("instance credentials: %w", err)
+	}
+	a.creds = awsCredentials{AccessKeyID: c.AccessKeyID, SecretAccessKey: c.SecretAccessKey, SessionToken: c.Token, Expires: c.Expiration}
+	return a.creds, nil
+}
+
+// imds performs an IMDSv2 request for path.
+func (a *AWSSecretsManager) imds(ctx context.Context, path string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPut, a.metadataURL()+"/latest/api/token", nil)
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "60")
+	resp, err := a.client().Do(req)
+	if err != nil {
+		return "", err
+	}
+	token, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("imds token: status %d", resp.StatusCode)
+	}
+	req, _ = http.NewRequestWithContext(ctx, http.MethodGet, a.metadataURL()+path, nil)
+	req.Header.Set("X-aws-ec2-metadata-token", string(token))
+	resp, err = a.client().Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("imds %s: status %d", path, resp.StatusCode)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+func hmacSHA256(key []byte, s string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(s))
+	return m.Sum(nil)
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// signV4 adds AWS Signature Version 4 headers to req. All headers already
+// set on req, plus Host, are signed.
+func signV4(req *http.Request, body []byte, c awsCredentials, region, service string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	if c.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", c.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonHeaders strings.Builder
+	for _, k := range names {
+		canonHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	q := req.URL.Query()
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var query []string
+	for _, k := range keys {
+		vs := q[k]
+		sort.Strings(vs)
+		for _, v := range vs {
+			query = append(query, awsEscape(k)+"="+awsEscape(v))
+		}
+	}
+
+	canonical := strings.Join([]string{
+		req.Method, path, strings.Join(query, "&"), canonHeaders.String(), signed, sha256Hex(body),
+	}, "\n")
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonical))
+
+	key := hmacSHA256([]byte("AWS4"+c.SecretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	sig := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+c.AccessKeyID+"/"+scope+
+		", SignedHeaders="+signed+", Signature="+sig)
+}
+
+// awsEscape percent-encodes s as SigV4 requires: everything except
+// unreserved characters.
+func awsEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,114 @@
+package secrets
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GCPSecretManager reads a secret version from Google Cloud Secret
+// Manager, authenticating with the service account of the GCE instance or
+// GKE workload through the metadata server.
+type GCPSecretManager struct {
+	Project string
+	Secret  string
+	Version string
+	// Endpoint overrides https://secretmanager.googleapis.com.
+	Endpoint string
+	// MetadataURL overrides http://metadata.google.internal.
+	MetadataURL string
+	Client      *http.Client
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func (g *GCPSecretManager) client() *http.Client {
+	if g.Client != nil {
+		return g.Client
+	}
+	return &http.Client{Timeout: 10 * time.Second}
+}
+
+// Fetch implements Source.
+func (g *GCPSecretManager) Fetch(ctx context.Context) (string, error) {
+	token, err := g.accessToken(ctx)
+	if err != nil {
+		return "", fmt.Errorf("gcp access token: %w", err)
+	}
+	endpoint := g.Endpoint
+	if endpoint == "" {
+		endpoint = "https://secretmanager.googleapis.com"
+	}
+	u := fmt.Sprintf("%s/v1/projects/%s/secrets/%s/versions/%s:access", strings.TrimRight(endpoint, "/"), g.Project, g.Secret, g.Version)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := g.client().Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("secret manager: status %d: %s", resp.StatusCode, strings.TrimSpace(string(b)))
+	}
+	var out struct {
+		Payload struct {
+			Data string `json:"data"`
+		} `json:"payload"`
+	}
+	if err := json.Unmarshal(b, &out); err != nil {
+		return "", fmt.Errorf("secret manager: %w", err)
+	}
+	v, err := base64.StdEncoding.DecodeString(out.Payload.Data)
+	if err != nil {
+		return "", fmt.Errorf("secret manager: payload: %w", err)
+	}
+	return strings.TrimSpace(string(v)), nil
+}
+
+func (g *GCPSecretManager) accessToken(ctx context.Context) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.token != "" && time.Until(g.expires) > time.Minute {
+		return g.token, nil
+	}
+	base := g.MetadataURL
+	if base == "" {
+		base = "http://metadata.google.internal"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		strings.TrimRight(base, "/")+"/computeMetadata/v1/instance/service-accounts/default/token", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := g.client().Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("metadata server: status %d", resp.StatusCode)
+	}
+	var tok struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil {
+		return "", fmt.Errorf("metadata server: %w", err)
+	}
+	g.token = tok.AccessToken
+	g.expires = time.Now().Add(time.Duration(tok.ExpiresIn) * time.Second)
+	return g.token, nil
+}
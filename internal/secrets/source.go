@@ -0,0 +1,134 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/yeti47/ollama-proxy/internal/keyring"
+)
+
+// Source fetches a single secret value, such as the upstream API key.
+type Source interface {
+	Fetch(ctx context.Context) (string, error)
+}
+
+// ParseSource parses a secret URI:
+//
+//	awssm://<secret-id>[?region=<region>][#<json-field>]
+//	gcpsm://<project>/<secret>[/<version>][#<json-field>]
+//	keyring://[[<service>/]<account>]
+//
+// If a field is given, the secret value is parsed as a JSON object and
+// that field is returned.
+func ParseSource(uri string) (Source, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	var src Source
+	switch u.Scheme {
+	case "awssm":
+		id := strings.TrimPrefix(u.Host+u.Path, "/")
+		if id == "" {
+			return nil, fmt.Errorf("%s: missing secret id", uri)
+		}
+		src = &AWSSecretsManager{SecretID: id, Region: u.Query().Get("region")}
+	case "gcpsm":
+		parts := strings.Split(strings.Trim(u.Host+u.Path, "/"), "/")
+		if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("%s: want gcpsm://<project>/<secret>[/<version>]", uri)
+		}
+		g := &GCPSecretManager{Project: parts[0], Secret: parts[1], Version: "latest"}
+		if len(parts) == 3 {
+			g.Version = parts[2]
+		}
+		src = g
+	case "keyring":
+		k := Keyring{Account: keyring.DefaultAccount}
+		switch parts := strings.Split(strings.Trim(u.Host+u.Path, "/"), "/"); {
+		case len(parts) == 2:
+			k.Service, k.Account = parts[0], parts[1]
+		case len(parts) > 2:
+			return nil, fmt.Errorf("%s: want keyring://[[<service>/]<account>]", uri)
+		case parts[0] != "":
+			k.Account = parts[0]
+		}
+		src = k
+	default:
+		return nil, fmt.Errorf("%s: unsupported secret source (want awssm://, gcpsm:// or keyring://)", uri)
+	}
+	if u.Fragment != "" {
+		src = jsonField{src: src, field: u.Fragment}
+	}
+	return src, nil
+}
+
+// Keyring reads a secret stored in the OS keyring with `ollama-proxy keys
+// set`, or by another tool under Service.
+type Keyring struct {
+	// Service defaults to keyring.Service.
+	Service string
+	Account string
+}
+
+// Fetch implements Source.
+func (k Keyring) Fetch(context.Context) (string, error) {
+	if k.Service != "" {
+		return keyring.Lookup(k.Service, k.Account)
+	}
+	return keyring.Get(k.Account)
+}
+
+// jsonField extracts one field from a secret stored as a JSON object, the
+// usual layout for AWS Secrets Manager key/value secrets.
+type jsonField struct {
+	src   Source
+	field string
+}
+
+func (j jsonField) Fetch(ctx context.Context) (string, error) {
+	v, err := j.src.Fetch(ctx)
+	if err != nil {
+		return "", err
+	}
+	var m map[string]any
+	if err := json.Unmarshal([]byte(v), &m); err != nil {
+		return "", fmt.Errorf("secret is not a JSON object: %w", err)
+	}
+	s, ok := m[j.field].(string)
+	if !ok || s == "" {
+		return "", fmt.Errorf("field %q missing or not a string", j.field)
+	}
+	return s, nil
+}
+
+// Poll fetches src every interval and calls fn when the value changed
+// from last. Errors are logged and the previous value stays in use. Poll
+// returns when stop is closed.
+func Poll(src Source, last string, interval time.Duration, stop <-chan struct{}, fn func(string)) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		v, err := src.Fetch(ctx)
+		cancel()
+		if err != nil {
+			slog.Warn("secrets: refreshing api key", "err", err)
+			continue
+		}
+		if v != last {
+			last = v
+			fn(v)
+		}
+	}
+}
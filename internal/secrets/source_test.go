@@ -0,0 +1,117 @@
+package secrets
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The example request from the AWS Signature Version 4 documentation.
+func TestSignV4(t *testing.T) {
+	req, _ := http.NewRequest("GET", "https://iam.amazonaws.com/?Action=ListUsers&Version=2010-05-08", nil)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	creds := awsCredentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	signV4(req, nil, creds, "us-east-1", "iam", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request, " +
+		"SignedHeaders=content-type;host;x-amz-date, " +
+		"Signature=5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Fatalf("Authorization =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestParseSource(t *testing.T) {
+	for _, uri := range []string{"awssm://", "gcpsm://proj", "gcpsm://a/b/c/d", "keyring://a/b/c", "file:///etc/key", "plain"} {
+		if _, err := ParseSource(uri); err == nil {
+			t.Errorf("%s: expected error", uri)
+		}
+	}
+	src, err := ParseSource("awssm://prod/ollama?region=eu-west-1#api_key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, ok := src.(jsonField)
+	if !ok || f.field != "api_key" {
+		t.Fatalf("source = %#v", src)
+	}
+	if a := f.src.(*AWSSecretsManager); a.SecretID != "prod/ollama" || a.Region != "eu-west-1" {
+		t.Fatalf("aws source = %+v", a)
+	}
+	for uri, want := range map[string]Keyring{
+		"keyring://":                {Account: "default"},
+		"keyring://work":            {Account: "work"},
+		"keyring:///work/":          {Account: "work"},
+		"keyring://other-app/work":  {Service: "other-app", Account: "work"},
+		"keyring:///other-app/work": {Service: "other-app", Account: "work"},
+	} {
+		src, err := ParseSource(uri)
+		if err != nil || src != want {
+			t.Errorf("%s: source = %#v, %v", uri, src, err)
+		}
+	}
+	src, err = ParseSource("gcpsm://my-proj/ollama-key/3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g := src.(*GCPSecretManager); g.Project != "my-proj" || g.Secret != "ollama-key" || g.Version != "3" {
+		t.Fatalf("gcp source = %+v", g)
+	}
+}
+
+func TestAWSSecretsManagerFetch(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_SESSION_TOKEN", "")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Amz-Target") != "secretsmanager.GetSecretValue" ||
+			!strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		var in map[string]string
+		json.NewDecoder(r.Body).Decode(&in)
+		json.NewEncoder(w).Encode(map[string]string{"SecretString": `{"api_key":"sk-aws","other":"x"}`, "Name": in["SecretId"]})
+	}))
+	defer srv.Close()
+
+	src := jsonField{src: &AWSSecretsManager{SecretID: "ollama", Region: "us-east-1", Endpoint: srv.URL}, field: "api_key"}
+	got, err := src.Fetch(context.Background())
+	if err != nil || got != "sk-aws" {
+		t.Fatalf("Fetch = %q, %v", got, err)
+	}
+}
+
+func TestGCPSecretManagerFetch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/service-accounts/default/token"):
+			if r.Header.Get("Metadata-Flavor") != "Google" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			w.Write([]byte(`{"access_token":"ya29.test","expires_in":3600}`))
+		case r.URL.Path == "/v1/projects/p/secrets/s/versions/latest:access":
+			if r.Header.Get("Authorization") != "Bearer ya29.test" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]any{"payload": map[string]string{
+				"data": base64.StdEncoding.EncodeToString([]byte("sk-gcp\n")),
+			}})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	g := &GCPSecretManager{Project: "p", Secret: "s", Version: "latest", Endpoint: srv.URL, MetadataURL: srv.URL}
+	got, err := g.Fetch(context.Background())
+	if err != nil || got != "sk-gcp" {
+		t.Fatalf("Fetch = %q, %v", got, err)
+	}
+}
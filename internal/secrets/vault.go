@@ -0,0 +1,214 @@
+// Package secrets fetches the upstream API key and TLS material from
+// external secret stores so they never have to touch disk or the
+// environment.
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Vault is a minimal client for the HashiCorp Vault HTTP API. It covers
+// what the proxy needs: reading KV (v1 and v2) and dynamic secrets,
+// renewing leases and keeping its own token alive.
+type Vault struct {
+	Addr      string
+	Token     string
+	Namespace string
+	Client    *http.Client
+}
+
+// NewVault returns a client for the Vault server at addr.
+func NewVault(addr, token string) *Vault {
+	return &Vault{
+		Addr:   strings.TrimRight(addr, "/"),
+		Token:  token,
+		Client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Secret is the result of a Vault read.
+type Secret struct {
+	Data          map[string]any
+	LeaseID       string
+	LeaseDuration time.Duration
+	Renewable     bool
+}
+
+// String returns the string value of field.
+func (s *Secret) String(field string) (string, error) {
+	v, ok := s.Data[field].(string)
+	if !ok || v == "" {
+		return "", fmt.Errorf("field %q missing or not a string", field)
+	}
+	return v, nil
+}
+
+type vaultResponse struct {
+	Data          map[string]any `json:"data"`
+	LeaseID       string         `json:"lease_id"`
+	LeaseDuration int            `json:"lease_duration"`
+	Renewable     bool           `json:"renewable"`
+	Errors        []string       `json:"errors"`
+	Auth          *struct {
+		LeaseDuration int  `json:"lease_duration"`
+		Renewable     bool `json:"renewable"`
+	} `json:"auth"`
+}
+
+func (v *Vault) do(ctx context.Context, method, path string, body any) (*vaultResponse, error) {
+	var rd io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		rd = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, v.Addr+"/v1/"+strings.TrimLeft(path, "/"), rd)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", v.Token)
+	if v.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.Namespace)
+	}
+	resp, err := v.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var vr vaultResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&vr); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("vault %s %s: status %d: %w", method, path, resp.StatusCode, err)
+	}
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("vault %s %s: status %d: %s", method, path, resp.StatusCode, strings.Join(vr.Errors, "; "))
+	}
+	return &vr, nil
+}
+
+// Read reads the secret at path, e.g. "secret/data/ollama". For KV v2
+// mounts the inner data map is returned.
+func (v *Vault) Read(ctx context.Context, path string) (*Secret, error) {
+	vr, err := v.do(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	data := vr.Data
+	if inner, ok := data["data"].(map[string]any); ok {
+		if _, kv2 := data["metadata"]; kv2 {
+			data = inner
+		}
+	}
+	return &Secret{
+		Data:          data,
+		LeaseID:       vr.LeaseID,
+		LeaseDuration: time.Duration(vr.LeaseDuration) * time.Second,
+		Renewable:     vr.Renewable,
+	}, nil
+}
+
+// renewLease extends a lease and returns its new duration.
+func (v *Vault) renewLease(ctx context.Context, leaseID string) (time.Duration, error) {
+	vr, err := v.do(ctx, http.MethodPut, "sys/leases/renew", map[string]string{"lease_id": leaseID})
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(vr.LeaseDuration) * time.Second, nil
+}
+
+// retryInterval is how soon a failed read or renewal is retried.
+const retryInterval = 30 * time.Second
+
+// renewAt returns when to act on something that expires after d.
+func renewAt(d time.Duration) time.Duration { return d * 2 / 3 }
+
+// Watch re-reads the secret at path and calls fn whenever its data
+// changes. Renewable leases are renewed at two thirds of their duration;
+// other secrets are re-read every refresh. first is the secret the caller
+// already read at startup. Watch returns when stop is closed.
+func (v *Vault) Watch(path string, first *Secret, refresh time.Duration, stop <-chan struct{}, fn func(*Secret)) {
+	cur := first
+	wait := refresh
+	for {
+		if cur.LeaseDuration > 0 {
+			wait = min(wait, renewAt(cur.LeaseDuration))
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(wait):
+		}
+		wait = refresh
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		if cur.Renewable && cur.LeaseID != "" {
+			d, err := v.renewLease(ctx, cur.LeaseID)
+			if err == nil && d > 0 {
+				cur.LeaseDuration = d
+				cancel()
+				continue
+			}
+			slog.Warn("vault: lease renewal failed, reading secret again", "path", path, "err", err)
+		}
+		s, err := v.Read(ctx, path)
+		cancel()
+		if err != nil {
+			// keep the current value and try again soon
+			slog.Warn("vault: reading secret", "path", path, "err", err)
+			wait = retryInterval
+			continue
+		}
+		if !reflect.DeepEqual(s.Data, cur.Data) {
+			fn(s)
+		}
+		cur = s
+	}
+}
+
+// KeepTokenAlive renews the client's own token at two thirds of its TTL
+// until stop is closed. Tokens that don't expire or can't be renewed are
+// left alone.
+func (v *Vault) KeepTokenAlive(stop <-chan struct{}) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	vr, err := v.do(ctx, http.MethodGet, "auth/token/lookup-self", nil)
+	cancel()
+	if err != nil {
+		slog.Warn("vault: token lookup", "err", err)
+		return
+	}
+	ttl, _ := vr.Data["ttl"].(float64)
+	renewable, _ := vr.Data["renewable"].(bool)
+	if ttl <= 0 || !renewable {
+		return
+	}
+	wait := renewAt(time.Duration(ttl) * time.Second)
+	for {
+		select {
+		case <-stop:
+			return
+		case <-time.After(wait):
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		vr, err := v.do(ctx, http.MethodPost, "auth/token/renew-self", map[string]any{})
+		cancel()
+		if err != nil || vr.Auth == nil {
+			slog.Warn("vault: token renewal failed", "err", err)
+			wait = retryInterval
+			continue
+		}
+		if !vr.Auth.Renewable || vr.Auth.LeaseDuration <= 0 {
+			return
+		}
+		wait = renewAt(time.Duration(vr.Auth.LeaseDuration) * time.Second)
+	}
+}
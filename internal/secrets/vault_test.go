@@ -0,0 +1,68 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestVaultReadAndWatch(t *testing.T) {
+	var key atomic.Value
+	key.Store("sk-1")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "tok" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+		if r.URL.Path != "/v1/secret/data/ollama" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors":[]}`))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"data": map[string]any{
+				"data":     map[string]any{"api_key": key.Load()},
+				"metadata": map[string]any{"version": 1},
+			},
+		})
+	}))
+	defer srv.Close()
+
+	if _, err := NewVault(srv.URL, "wrong").Read(context.Background(), "secret/data/ollama"); err == nil {
+		t.Fatal("read with a bad token succeeded")
+	}
+
+	v := NewVault(srv.URL, "tok")
+	s, err := v.Read(context.Background(), "secret/data/ollama")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := s.String("api_key"); err != nil || got != "sk-1" {
+		t.Fatalf("api_key = %q, %v", got, err)
+	}
+	if _, err := s.String("missing"); err == nil {
+		t.Fatal("missing field returned no error")
+	}
+
+	changed := make(chan string, 1)
+	stop := make(chan struct{})
+	defer close(stop)
+	go v.Watch("secret/data/ollama", s, 10*time.Millisecond, stop, func(s *Secret) {
+		k, _ := s.String("api_key")
+		changed <- k
+	})
+	key.Store("sk-2")
+	select {
+	case got := <-changed:
+		if got != "sk-2" {
+			t.Fatalf("rotated key = %q", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("rotation not picked up")
+	}
+}
@@ -0,0 +1,108 @@
+package stats
+
+import (
+	"crypto/tls"
+	"log/slog"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"github.com/yeti47/ollama-proxy/internal/metrics"
+)
+
+// phaseBuckets reach down to a millisecond for the network phases and up
+// to minutes for a model loading before its first token.
+var phaseBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+var upstreamPhase = metrics.NewHistogram("ollama_proxy_upstream_phase_seconds",
+	"Duration of each phase of upstream requests: dns, connect, tls (only for new connections), "+
+		"wait (request sent until the first response byte, i.e. upstream processing) and "+
+		"ttfb (start of the request until the first response byte).",
+	phaseBuckets, "phase")
+
+// phases collects the timestamps of one upstream request. The dial hooks
+// can run on another goroutine, hence the lock.
+type phases struct {
+	mu                       sync.Mutex
+	start                    time.Time
+	dnsStart, dnsDone        time.Time
+	connectStart, connectEnd time.Time
+	tlsStart, tlsDone        time.Time
+	wrote, firstByte         time.Time
+	reused                   bool
+}
+
+func (p *phases) set(t *time.Time) {
+	p.mu.Lock()
+	*t = time.Now()
+	p.mu.Unlock()
+}
+
+func (p *phases) trace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { p.set(&p.dnsStart) },
+		DNSDone:  func(httptrace.DNSDoneInfo) { p.set(&p.dnsDone) },
+		ConnectStart: func(string, string) {
+			p.mu.Lock()
+			// with several addresses only the first attempt's start counts
+			if p.connectStart.IsZero() {
+				p.connectStart = time.Now()
+			}
+			p.mu.Unlock()
+		},
+		ConnectDone:       func(string, string, error) { p.set(&p.connectEnd) },
+		TLSHandshakeStart: func() { p.set(&p.tlsStart) },
+		TLSHandshakeDone:  func(tls.ConnectionState, error) { p.set(&p.tlsDone) },
+		GotConn: func(info httptrace.GotConnInfo) {
+			p.mu.Lock()
+			p.reused = info.Reused
+			p.mu.Unlock()
+		},
+		WroteRequest:         func(httptrace.WroteRequestInfo) { p.set(&p.wrote) },
+		GotFirstResponseByte: func() { p.set(&p.firstByte) },
+	}
+}
+
+type phase struct {
+	name string
+	d    time.Duration
+}
+
+// durations returns the phases that happened, in order, and whether the
+// request reused a connection.
+func (p *phases) durations() (out []phase, reused bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	add := func(name string, from, to time.Time) {
+		if !from.IsZero() && !to.IsZero() && !to.Before(from) {
+			out = append(out, phase{name, to.Sub(from)})
+		}
+	}
+	add("dns", p.dnsStart, p.dnsDone)
+	add("connect", p.connectStart, p.connectEnd)
+	add("tls", p.tlsStart, p.tlsDone)
+	add("wait", p.wrote, p.firstByte)
+	add("ttfb", p.start, p.firstByte)
+	return out, p.reused
+}
+
+// PhaseTransport returns a RoundTripper that times the phases of each
+// upstream request with an httptrace.ClientTrace. It exports them as
+// ollama_proxy_upstream_phase_seconds and logs them at debug level, so
+// slow requests can be split into network time and model time.
+func PhaseTransport(next http.RoundTripper) http.RoundTripper {
+	return roundTripper(func(r *http.Request) (*http.Response, error) {
+		p := &phases{start: time.Now()}
+		r = r.WithContext(httptrace.WithClientTrace(r.Context(), p.trace()))
+		resp, err := next.RoundTrip(r)
+		timings, reused := p.durations()
+		attrs := []any{"path", r.URL.Path, "reused_conn", reused}
+		for _, ph := range timings {
+			upstreamPhase.With(ph.name).Observe(ph.d.Seconds())
+			attrs = append(attrs, ph.name, ph.d)
+		}
+		slog.DebugContext(r.Context(), "upstream timing", attrs...)
+		return resp, err
+	})
+}
@@ -0,0 +1,55 @@
+package stats
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yeti47/ollama-proxy/internal/metrics"
+)
+
+func TestPhaseTransport(t *testing.T) {
+	var logs bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	defer slog.SetDefault(prev)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond) // the model thinking
+		_, _ = w.Write([]byte(`{"done":true}`))
+	}))
+	defer upstream.Close()
+	client := &http.Client{Transport: PhaseTransport(&http.Transport{})}
+	get := func() string {
+		logs.Reset()
+		resp, err := client.Get(upstream.URL + "/api/generate")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return logs.String()
+	}
+
+	first := get()
+	for _, want := range []string{`msg="upstream timing"`, "path=/api/generate", "reused_conn=false", " connect=", " wait=", " ttfb="} {
+		if !strings.Contains(first, want) {
+			t.Errorf("first request log is missing %q: %s", want, first)
+		}
+	}
+	second := get()
+	if !strings.Contains(second, "reused_conn=true") || strings.Contains(second, " connect=") {
+		t.Errorf("second request should reuse the connection: %s", second)
+	}
+
+	rec := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(rec.Body.String(), `ollama_proxy_upstream_phase_seconds_count{phase="wait"} 2`) {
+		t.Errorf("wait phase not exported:\n%s", rec.Body.String())
+	}
+}
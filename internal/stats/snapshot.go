@@ -0,0 +1,184 @@
+package stats
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/yeti47/ollama-proxy/internal/notify"
+)
+
+// Stats keeps running totals since the proxy started, for scripts and
+// dashboards that don't scrape Prometheus.
+type Stats struct {
+	// ServerErrorThreshold is the number of 5xx responses within a minute
+	// that triggers a notify.ServerErrors event. Zero disables the event.
+	ServerErrorThreshold int
+
+	started   time.Time
+	inFlight  atomic.Int64
+	streaming atomic.Int64
+
+	mu       sync.Mutex
+	byStatus map[int]uint64
+	requests uint64
+	bytesIn  int64
+	bytesOut int64
+	upstream upstreamState
+	// recent5xx holds the times of 5xx responses in the last minute
+	recent5xx []time.Time
+}
+
+type upstreamState struct {
+	lastSuccess time.Time
+	lastFailure time.Time
+	lastError   string
+	failures    int // consecutive
+}
+
+// New returns empty stats starting now.
+func New() *Stats {
+	return &Stats{started: time.Now(), byStatus: make(map[int]uint64)}
+}
+
+func (s *Stats) record(status int, in, out int64) {
+	s.mu.Lock()
+	s.byStatus[status]++
+	s.requests++
+	s.bytesIn += in
+	s.bytesOut += out
+	var burst int
+	if status >= 500 && s.ServerErrorThreshold > 0 {
+		now := time.Now()
+		i := 0
+		for i < len(s.recent5xx) && now.Sub(s.recent5xx[i]) > time.Minute {
+			i++
+		}
+		s.recent5xx = append(s.recent5xx[i:], now)
+		if len(s.recent5xx) >= s.ServerErrorThreshold {
+			burst = len(s.recent5xx)
+		}
+	}
+	s.mu.Unlock()
+	if burst > 0 {
+		notify.Send(notify.ServerErrors, "", fmt.Sprintf("%d server errors in the last minute", burst),
+			"count", burst, "last_status", status)
+	}
+}
+
+// downAfter is the number of consecutive upstream failures after which
+// the upstream is reported as down rather than degraded.
+const downAfter = 3
+
+// Transport returns a RoundTripper that tracks whether upstream requests
+// succeed. Connection errors and 5xx responses count as failures.
+func (s *Stats) Transport(next http.RoundTripper) http.RoundTripper {
+	return roundTripper(func(r *http.Request) (*http.Response, error) {
+		resp, err := next.RoundTrip(r)
+		s.mu.Lock()
+		prev := s.upstream.failures
+		switch {
+		case err != nil:
+			s.upstream.lastFailure, s.upstream.lastError = time.Now(), err.Error()
+			s.upstream.failures++
+		case resp.StatusCode >= 500:
+			s.upstream.lastFailure, s.upstream.lastError = time.Now(), resp.Status
+			s.upstream.failures++
+		default:
+			s.upstream.lastSuccess, s.upstream.failures = time.Now(), 0
+		}
+		u := s.upstream
+		s.mu.Unlock()
+		switch {
+		case prev < downAfter && u.failures == downAfter:
+			notify.Send(notify.UpstreamDown, r.URL.Host, "upstream is down: "+u.lastError,
+				"upstream", r.URL.Host, "consecutive_failures", u.failures, "last_error", u.lastError)
+		case prev >= downAfter && u.failures == 0:
+			notify.Send(notify.UpstreamRecovered, r.URL.Host, "upstream is up again",
+				"upstream", r.URL.Host, "last_failure", u.lastFailure.UTC())
+		}
+		return resp, err
+	})
+}
+
+type roundTripper func(*http.Request) (*http.Response, error)
+
+func (f roundTripper) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+// Snapshot is the /stats response.
+type Snapshot struct {
+	StartedAt     time.Time         `json:"started_at"`
+	UptimeSeconds float64           `json:"uptime_seconds"`
+	Requests      uint64            `json:"requests"`
+	ByStatus      map[string]uint64 `json:"requests_by_status"`
+	InFlight      int64             `json:"in_flight"`
+	ActiveStreams int64             `json:"active_streams"`
+	BytesIn       int64             `json:"bytes_in"`
+	BytesOut      int64             `json:"bytes_out"`
+	Upstream      UpstreamHealth    `json:"upstream"`
+}
+
+// UpstreamHealth summarises recent upstream results. Status is "unknown"
+// before the first request, "up", "degraded" after a failure, or "down"
+// after several failures in a row.
+type UpstreamHealth struct {
+	Status              string     `json:"status"`
+	LastSuccess         *time.Time `json:"last_success,omitempty"`
+	LastFailure         *time.Time `json:"last_failure,omitempty"`
+	LastError           string     `json:"last_error,omitempty"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+}
+
+// Snapshot returns the current totals.
+func (s *Stats) Snapshot() Snapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snap := Snapshot{
+		UptimeSeconds: time.Since(s.started).Seconds(),
+		Requests:      s.requests,
+		ByStatus:      make(map[string]uint64, len(s.byStatus)),
+		InFlight:      s.inFlight.Load(),
+		ActiveStreams: s.streaming.Load(),
+		BytesIn:       s.bytesIn,
+		BytesOut:      s.bytesOut,
+		StartedAt:     s.started.UTC(),
+	}
+	for code, n := range s.byStatus {
+		snap.ByStatus[strconv.Itoa(code)] = n
+	}
+	u := s.upstream
+	h := UpstreamHealth{Status: "unknown", LastError: u.lastError, ConsecutiveFailures: u.failures}
+	switch {
+	case u.failures >= downAfter:
+		h.Status = "down"
+	case u.failures > 0:
+		h.Status = "degraded"
+	case !u.lastSuccess.IsZero():
+		h.Status = "up"
+	}
+	if !u.lastSuccess.IsZero() {
+		t := u.lastSuccess.UTC()
+		h.LastSuccess = &t
+	}
+	if !u.lastFailure.IsZero() {
+		t := u.lastFailure.UTC()
+		h.LastFailure = &t
+	}
+	snap.Upstream = h
+	return snap
+}
+
+// Handler serves the snapshot as JSON.
+func (s *Stats) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(s.Snapshot())
+	})
+}
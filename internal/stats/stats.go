@@ -0,0 +1,155 @@
+// Package stats records per-request metrics labelled with the Ollama
+// endpoint and the requested model, so dashboards can tell a slow model
+// from a slow proxy, and keeps a few running totals for the /stats JSON
+// endpoint.
+package stats
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yeti47/ollama-proxy/internal/apierror"
+	"github.com/yeti47/ollama-proxy/internal/metrics"
+	"github.com/yeti47/ollama-proxy/internal/ollama"
+)
+
+// sizeBuckets are in bytes, from a short prompt up to a long streamed
+// generation.
+var sizeBuckets = []float64{256, 1 << 10, 4 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20, 4 << 20, 16 << 20}
+
+var (
+	requestsTotal = metrics.NewCounter("ollama_proxy_requests_total",
+		"Requests handled, by endpoint, model and status code.", "endpoint", "model", "code")
+	requestDuration = metrics.NewHistogram("ollama_proxy_request_duration_seconds",
+		"Time until the response was complete, including streaming, by endpoint and model.",
+		nil, "endpoint", "model")
+	firstByte = metrics.NewHistogram("ollama_proxy_time_to_first_byte_seconds",
+		"Time until the response headers were sent, by endpoint and model.",
+		nil, "endpoint", "model")
+	requestSize = metrics.NewHistogram("ollama_proxy_request_size_bytes",
+		"Request body size, by endpoint and model.", sizeBuckets, "endpoint", "model")
+	responseSize = metrics.NewHistogram("ollama_proxy_response_size_bytes",
+		"Response body size, by endpoint and model.", sizeBuckets, "endpoint", "model")
+)
+
+// endpoints are the paths used as the endpoint label; anything else is
+// "other" so unknown paths can't blow up the number of series.
+var endpoints = map[string]bool{
+	"/api/chat": true, "/api/generate": true, "/api/embed": true, "/api/embeddings": true,
+	"/api/tags": true, "/api/show": true, "/api/ps": true, "/api/version": true,
+	"/api/pull": true, "/api/push": true, "/api/create": true, "/api/copy": true, "/api/delete": true,
+	"/v1/chat/completions": true, "/v1/completions": true, "/v1/embeddings": true, "/v1/models": true,
+}
+
+// Endpoint returns the metrics label for path.
+func Endpoint(path string) string {
+	if endpoints[path] {
+		return path
+	}
+	if strings.HasPrefix(path, "/v1/models/") {
+		return "/v1/models"
+	}
+	return "other"
+}
+
+// maxModels caps the distinct model labels; models named by clients past
+// that are counted as "other".
+const maxModels = 200
+
+var models = struct {
+	sync.Mutex
+	seen map[string]bool
+}{seen: make(map[string]bool)}
+
+func modelLabel(m string) string {
+	if m == "" {
+		return "none"
+	}
+	m = ollama.NormalizeModel(m)
+	models.Lock()
+	defer models.Unlock()
+	if !models.seen[m] {
+		if len(models.seen) >= maxModels {
+			return "other"
+		}
+		models.seen[m] = true
+	}
+	return m
+}
+
+// Middleware records request count, latency, time to first byte and sizes
+// for every request passing through next.
+func (s *Stats) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		s.inFlight.Add(1)
+		defer s.inFlight.Add(-1)
+		var model string
+		var reqBytes int
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			body, err := ollama.PeekBody(r)
+			if err != nil {
+				apierror.BodyError(w, err)
+				return
+			}
+			model, reqBytes = ollama.Model(body), len(body)
+		}
+		endpoint, label := Endpoint(r.URL.Path), modelLabel(model)
+
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK, start: start, stats: s}
+		next.ServeHTTP(sw, r)
+		if sw.wrote {
+			s.streaming.Add(-1)
+		} else {
+			sw.firstByte = time.Since(start)
+		}
+		s.record(sw.status, int64(reqBytes), sw.bytes)
+
+		requestsTotal.With(endpoint, label, strconv.Itoa(sw.status)).Inc()
+		requestDuration.With(endpoint, label).Observe(time.Since(start).Seconds())
+		firstByte.With(endpoint, label).Observe(sw.firstByte.Seconds())
+		requestSize.With(endpoint, label).Observe(float64(reqBytes))
+		responseSize.With(endpoint, label).Observe(float64(sw.bytes))
+	})
+}
+
+// statusWriter records the status, size and time to the first byte of the
+// response. It passes Flush through so streamed responses keep streaming.
+type statusWriter struct {
+	http.ResponseWriter
+	stats     *Stats
+	start     time.Time
+	status    int
+	wrote     bool
+	firstByte time.Duration
+	bytes     int64
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if !w.wrote {
+		w.status, w.wrote, w.firstByte = code, true, time.Since(w.start)
+		w.stats.streaming.Add(1)
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if !w.wrote {
+		w.wrote, w.firstByte = true, time.Since(w.start)
+		w.stats.streaming.Add(1)
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
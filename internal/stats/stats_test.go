@@ -0,0 +1,111 @@
+package stats
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMiddleware(t *testing.T) {
+	var forwarded string
+	st := New()
+	h := st.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		forwarded = string(b)
+		if r.URL.Path == "/api/generate" {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		_, _ = w.Write([]byte(`{"done":true}`))
+	}))
+
+	body := `{"model":"llama3","messages":[]}`
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/chat", strings.NewReader(body)))
+	if forwarded != body {
+		t.Errorf("forwarded body %q", forwarded)
+	}
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/generate", strings.NewReader(`{"model":"qwen2:7b"}`)))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/some/scan", nil))
+
+	if got := requestsTotal.With("/api/chat", "llama3:latest", "200").Value(); got != 1 {
+		t.Errorf("chat requests %v, want 1", got)
+	}
+	if got := requestsTotal.With("/api/generate", "qwen2:7b", "502").Value(); got != 1 {
+		t.Errorf("generate errors %v, want 1", got)
+	}
+	if got := requestsTotal.With("other", "none", "200").Value(); got != 1 {
+		t.Errorf("other requests %v, want 1", got)
+	}
+
+	snap := st.Snapshot()
+	if snap.Requests != 3 || snap.ByStatus["200"] != 2 || snap.ByStatus["502"] != 1 {
+		t.Errorf("snapshot requests %d by status %v", snap.Requests, snap.ByStatus)
+	}
+	if snap.BytesIn != int64(len(body)+len(`{"model":"qwen2:7b"}`)) || snap.BytesOut != 2*int64(len(`{"done":true}`)) {
+		t.Errorf("bytes in %d out %d", snap.BytesIn, snap.BytesOut)
+	}
+	if snap.InFlight != 0 || snap.ActiveStreams != 0 {
+		t.Errorf("in flight %d, active streams %d after all requests finished", snap.InFlight, snap.ActiveStreams)
+	}
+}
+
+func TestUpstreamHealth(t *testing.T) {
+	st := New()
+	status := http.StatusOK
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer upstream.Close()
+	client := &http.Client{Transport: st.Transport(http.DefaultTransport)}
+	get := func() {
+		resp, err := client.Get(upstream.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+	}
+
+	if got := st.Snapshot().Upstream.Status; got != "unknown" {
+		t.Errorf("status before any request %q", got)
+	}
+	get()
+	if got := st.Snapshot().Upstream.Status; got != "up" {
+		t.Errorf("status %q, want up", got)
+	}
+	status = http.StatusServiceUnavailable
+	get()
+	if got := st.Snapshot().Upstream.Status; got != "degraded" {
+		t.Errorf("status %q, want degraded", got)
+	}
+	get()
+	get()
+	h := st.Snapshot().Upstream
+	if h.Status != "down" || h.ConsecutiveFailures != 3 || h.LastError != "503 Service Unavailable" {
+		t.Errorf("upstream %+v", h)
+	}
+
+	rec := httptest.NewRecorder()
+	st.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/stats", nil))
+	var snap map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &snap); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := snap["uptime_seconds"]; !ok {
+		t.Errorf("no uptime in %s", rec.Body)
+	}
+}
+
+func TestEndpoint(t *testing.T) {
+	for path, want := range map[string]string{
+		"/api/chat":           "/api/chat",
+		"/v1/models/llama3":   "/v1/models",
+		"/api/chat/../../x":   "other",
+		"/wp-admin/login.php": "other",
+	} {
+		if got := Endpoint(path); got != want {
+			t.Errorf("Endpoint(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
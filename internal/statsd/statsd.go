@@ -0,0 +1,205 @@
+// Package statsd pushes the proxy's metrics to a StatsD or DogStatsD
+// agent over UDP, for setups built around Datadog or Telegraf rather than
+// Prometheus scraping. It receives every metric update through
+// metrics.SetSink and batches them into datagrams.
+package statsd
+
+import (
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/yeti47/ollama-proxy/internal/metrics"
+)
+
+// maxPacket keeps datagrams under a typical Ethernet MTU.
+const maxPacket = 1432
+
+// flushInterval bounds how long an update waits for its datagram to
+// fill up.
+const flushInterval = time.Second
+
+// Formats of the emitted lines.
+const (
+	// DogStatsD adds labels as tags: name:1|c|#endpoint:/api/chat
+	DogStatsD = "dogstatsd"
+	// StatsD has no tags, so label values are appended to the name:
+	// name._api_chat:1|c
+	StatsD = "statsd"
+)
+
+type update struct {
+	kind   byte // 'c', 'g' or 'o'
+	series *metrics.Series
+	v      float64
+}
+
+// Client is a metrics.Sink writing to a StatsD agent.
+type Client struct {
+	conn    net.Conn
+	prefix  string
+	tagged  bool
+	updates chan update
+	dropped atomic.Int64
+	done    chan struct{}
+	closeMu sync.RWMutex // closing waits for senders to finish
+	closed  bool
+}
+
+// New returns a client sending to addr (host:port). prefix replaces the
+// ollama_proxy_ prefix of metric names, e.g. "ollama_proxy." for
+// Datadog-style dotted names. format is DogStatsD or StatsD.
+func New(addr, prefix, format string) (*Client, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{
+		conn:    conn,
+		prefix:  prefix,
+		tagged:  format != StatsD,
+		updates: make(chan update, 4096),
+		done:    make(chan struct{}),
+	}
+	go c.run()
+	return c, nil
+}
+
+// Count implements metrics.Sink.
+func (c *Client) Count(s *metrics.Series, d float64) { c.send(update{'c', s, d}) }
+
+// Gauge implements metrics.Sink.
+func (c *Client) Gauge(s *metrics.Series, v float64) { c.send(update{'g', s, v}) }
+
+// Observe implements metrics.Sink.
+func (c *Client) Observe(s *metrics.Series, v float64) { c.send(update{'o', s, v}) }
+
+// send queues u, dropping it rather than slowing down a request when the
+// agent can't keep up.
+func (c *Client) send(u update) {
+	c.closeMu.RLock()
+	defer c.closeMu.RUnlock()
+	if c.closed {
+		return
+	}
+	select {
+	case c.updates <- u:
+	default:
+		c.dropped.Add(1)
+	}
+}
+
+func (c *Client) run() {
+	defer close(c.done)
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	var buf []byte
+	flush := func() {
+		if len(buf) == 0 {
+			return
+		}
+		// UDP: a missing agent only shows up as errors we can't act on
+		_, _ = c.conn.Write(buf)
+		buf = buf[:0]
+	}
+	for {
+		select {
+		case u, ok := <-c.updates:
+			if !ok {
+				flush()
+				return
+			}
+			line := c.line(u)
+			if len(buf)+len(line)+1 > maxPacket {
+				flush()
+			}
+			if len(buf) > 0 {
+				buf = append(buf, '\n')
+			}
+			buf = append(buf, line...)
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// line formats one update in the StatsD line protocol.
+func (c *Client) line(u update) string {
+	name := u.series.Name
+	if c.prefix != "" {
+		name = c.prefix + strings.TrimPrefix(name, "ollama_proxy_")
+	}
+	if !c.tagged {
+		for _, v := range u.series.Values {
+			if v != "" {
+				name += "." + sanitize(v)
+			}
+		}
+	}
+	v, typ := u.v, "c"
+	switch u.kind {
+	case 'g':
+		typ = "g"
+	case 'o':
+		typ = "ms"
+		if strings.HasSuffix(u.series.Name, "_seconds") {
+			v *= 1000
+		} else if c.tagged {
+			typ = "h"
+		}
+	}
+	var b strings.Builder
+	b.WriteString(name)
+	b.WriteByte(':')
+	b.WriteString(strconv.FormatFloat(v, 'f', -1, 64))
+	b.WriteByte('|')
+	b.WriteString(typ)
+	if c.tagged && len(u.series.Labels) > 0 {
+		b.WriteString("|#")
+		for i, l := range u.series.Labels {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(l)
+			b.WriteByte(':')
+			b.WriteString(tagValue.Replace(u.series.Values[i]))
+		}
+	}
+	return b.String()
+}
+
+// tagValue escapes the characters that delimit DogStatsD tags.
+var tagValue = strings.NewReplacer(",", "_", "|", "_", "\n", "_")
+
+// sanitize makes a label value usable as a name segment.
+func sanitize(v string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		}
+		return '_'
+	}, v)
+}
+
+// Close flushes pending updates and closes the connection.
+func (c *Client) Close() error {
+	if c == nil {
+		return nil
+	}
+	c.closeMu.Lock()
+	if !c.closed {
+		c.closed = true
+		close(c.updates)
+	}
+	c.closeMu.Unlock()
+	<-c.done
+	if n := c.dropped.Load(); n > 0 {
+		slog.Warn("statsd: updates dropped because the agent could not keep up", "dropped", n)
+	}
+	return c.conn.Close()
+}
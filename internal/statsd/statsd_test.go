@@ -0,0 +1,62 @@
+package statsd
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yeti47/ollama-proxy/internal/metrics"
+)
+
+var (
+	testRequests = metrics.NewCounter("ollama_proxy_test_requests_total", "Test.", "endpoint", "code")
+	testDuration = metrics.NewHistogram("ollama_proxy_test_duration_seconds", "Test.", nil, "endpoint")
+	testActive   = metrics.NewGauge("ollama_proxy_test_active", "Test.")
+)
+
+func receive(t *testing.T, format string) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	c, err := New(pc.LocalAddr().String(), "ollama_proxy.", format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics.SetSink(c)
+	testRequests.With("/api/chat", "200").Inc()
+	testDuration.With("/api/chat").Observe(0.25)
+	testActive.With().Set(3)
+	metrics.SetSink(nil)
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	_ = pc.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, maxPacket)
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf[:n])
+}
+
+func TestDogStatsD(t *testing.T) {
+	got := receive(t, DogStatsD)
+	want := "ollama_proxy.test_requests_total:1|c|#endpoint:/api/chat,code:200\n" +
+		"ollama_proxy.test_duration_seconds:250|ms|#endpoint:/api/chat\n" +
+		"ollama_proxy.test_active:3|g"
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestStatsD(t *testing.T) {
+	got := receive(t, StatsD)
+	if !strings.HasPrefix(got, "ollama_proxy.test_requests_total._api_chat.200:1|c\n") || strings.Contains(got, "|#") {
+		t.Errorf("got\n%s", got)
+	}
+}
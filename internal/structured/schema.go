@@ -0,0 +1,314 @@
+package structured
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxErrors bounds the problems reported for one document; a repair
+// prompt needs a few, not hundreds.
+const maxErrors = 10
+
+// Validate checks the JSON text doc against schema and returns its
+// problems, each prefixed with a JSON pointer to the offending value. It
+// covers the JSON Schema keywords structured outputs are built from:
+// type, enum, const, properties, required, additionalProperties, items,
+// prefixItems, the length, size and range limits, pattern, local $ref,
+// allOf, anyOf, oneOf and not. Other keywords, such as format, are
+// ignored.
+func Validate(schema json.RawMessage, doc string) []string {
+	var root any
+	if err := json.Unmarshal(schema, &root); err != nil {
+		return []string{"invalid schema: " + err.Error()}
+	}
+	var x any
+	if err := json.Unmarshal([]byte(doc), &x); err != nil {
+		return []string{"not valid JSON: " + err.Error()}
+	}
+	v := &validator{root: root}
+	v.check(root, x, "", 0)
+	return v.errs
+}
+
+type validator struct {
+	root any
+	errs []string
+}
+
+func (v *validator) fail(path, format string, args ...any) {
+	if len(v.errs) < maxErrors {
+		if path == "" {
+			path = "/"
+		}
+		v.errs = append(v.errs, path+": "+fmt.Sprintf(format, args...))
+	}
+}
+
+// valid reports whether x matches s, without recording problems.
+func (v *validator) valid(s, x any, depth int) bool {
+	sub := &validator{root: v.root}
+	sub.check(s, x, "", depth)
+	return len(sub.errs) == 0
+}
+
+func (v *validator) check(schema, x any, path string, depth int) {
+	if depth > 64 {
+		v.fail(path, "schema nests too deeply")
+		return
+	}
+	s, ok := schema.(map[string]any)
+	if !ok {
+		if b, ok := schema.(bool); ok && !b {
+			v.fail(path, "no value is allowed here")
+		}
+		return
+	}
+	if ref, ok := s["$ref"].(string); ok {
+		target, err := v.resolve(ref)
+		if err != nil {
+			v.fail(path, "%v", err)
+			return
+		}
+		v.check(target, x, path, depth+1)
+	}
+	if t, ok := s["type"]; ok && !hasType(t, x) {
+		v.fail(path, "expected %s, got %s", typeNames(t), typeOf(x))
+		return
+	}
+	if enum, ok := s["enum"].([]any); ok && !contains(enum, x) {
+		v.fail(path, "must be one of %s", compact(enum))
+	}
+	if c, ok := s["const"]; ok && !reflect.DeepEqual(c, x) {
+		v.fail(path, "must be %s", compact(c))
+	}
+	switch x := x.(type) {
+	case map[string]any:
+		v.object(s, x, path, depth)
+	case []any:
+		v.array(s, x, path, depth)
+	case string:
+		n := utf8.RuneCountInString(x)
+		if min, ok := number(s["minLength"]); ok && float64(n) < min {
+			v.fail(path, "must be at least %v characters long", min)
+		}
+		if max, ok := number(s["maxLength"]); ok && float64(n) > max {
+			v.fail(path, "must be at most %v characters long", max)
+		}
+		if p, ok := s["pattern"].(string); ok {
+			// patterns RE2 can't compile are skipped, not held against the
+			// model
+			if re, err := regexp.Compile(p); err == nil && !re.MatchString(x) {
+				v.fail(path, "must match %s", p)
+			}
+		}
+	case float64:
+		if min, ok := number(s["minimum"]); ok && x < min {
+			v.fail(path, "must be at least %v", min)
+		}
+		if max, ok := number(s["maximum"]); ok && x > max {
+			v.fail(path, "must be at most %v", max)
+		}
+		if min, ok := number(s["exclusiveMinimum"]); ok && x <= min {
+			v.fail(path, "must be greater than %v", min)
+		}
+		if max, ok := number(s["exclusiveMaximum"]); ok && x >= max {
+			v.fail(path, "must be less than %v", max)
+		}
+	}
+	if all, ok := s["allOf"].([]any); ok {
+		for _, sub := range all {
+			v.check(sub, x, path, depth+1)
+		}
+	}
+	if anyOf, ok := s["anyOf"].([]any); ok {
+		matched := false
+		for _, sub := range anyOf {
+			if matched = v.valid(sub, x, depth+1); matched {
+				break
+			}
+		}
+		if !matched {
+			v.fail(path, "matches none of the allowed alternatives")
+		}
+	}
+	if oneOf, ok := s["oneOf"].([]any); ok {
+		n := 0
+		for _, sub := range oneOf {
+			if v.valid(sub, x, depth+1) {
+				n++
+			}
+		}
+		if n != 1 {
+			v.fail(path, "must match exactly one alternative, matches %d", n)
+		}
+	}
+	if not, ok := s["not"]; ok && v.valid(not, x, depth+1) {
+		v.fail(path, "matches a schema it must not match")
+	}
+}
+
+func (v *validator) object(s map[string]any, x map[string]any, path string, depth int) {
+	props, _ := s["properties"].(map[string]any)
+	if req, ok := s["required"].([]any); ok {
+		for _, r := range req {
+			if name, ok := r.(string); ok {
+				if _, ok := x[name]; !ok {
+					v.fail(path, "missing required property %q", name)
+				}
+			}
+		}
+	}
+	if min, ok := number(s["minProperties"]); ok && float64(len(x)) < min {
+		v.fail(path, "must have at least %v properties", min)
+	}
+	if max, ok := number(s["maxProperties"]); ok && float64(len(x)) > max {
+		v.fail(path, "must have at most %v properties", max)
+	}
+	keys := make([]string, 0, len(x))
+	for k := range x {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		p := path + "/" + escape(k)
+		if sub, ok := props[k]; ok {
+			v.check(sub, x[k], p, depth+1)
+			continue
+		}
+		switch ap := s["additionalProperties"].(type) {
+		case bool:
+			if !ap {
+				v.fail(p, "property is not allowed")
+			}
+		case map[string]any:
+			v.check(ap, x[k], p, depth+1)
+		}
+	}
+}
+
+func (v *validator) array(s map[string]any, x []any, path string, depth int) {
+	if min, ok := number(s["minItems"]); ok && float64(len(x)) < min {
+		v.fail(path, "must have at least %v items", min)
+	}
+	if max, ok := number(s["maxItems"]); ok && float64(len(x)) > max {
+		v.fail(path, "must have at most %v items", max)
+	}
+	prefix, _ := s["prefixItems"].([]any)
+	if tuple, ok := s["items"].([]any); ok {
+		prefix = tuple // the draft 7 spelling of prefixItems
+	}
+	for i, item := range x {
+		p := path + "/" + strconv.Itoa(i)
+		if i < len(prefix) {
+			v.check(prefix[i], item, p, depth+1)
+		} else if items, ok := s["items"]; ok {
+			if _, tuple := items.([]any); !tuple {
+				v.check(items, item, p, depth+1)
+			}
+		}
+	}
+}
+
+// resolve follows a local reference such as #/$defs/address.
+func (v *validator) resolve(ref string) (any, error) {
+	if !strings.HasPrefix(ref, "#") {
+		return nil, fmt.Errorf("unsupported $ref %q: only local references are resolved", ref)
+	}
+	cur := v.root
+	for _, part := range strings.Split(strings.TrimPrefix(ref, "#"), "/")[1:] {
+		part = strings.ReplaceAll(strings.ReplaceAll(part, "~1", "/"), "~0", "~")
+		m, ok := cur.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("unresolvable $ref %q", ref)
+		}
+		if cur, ok = m[part]; !ok {
+			return nil, fmt.Errorf("unresolvable $ref %q", ref)
+		}
+	}
+	return cur, nil
+}
+
+func hasType(t, x any) bool {
+	switch t := t.(type) {
+	case string:
+		return isType(t, x)
+	case []any:
+		for _, n := range t {
+			if name, ok := n.(string); ok && isType(name, x) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func isType(name string, x any) bool {
+	switch name {
+	case "integer":
+		f, ok := x.(float64)
+		return ok && f == math.Trunc(f)
+	case "number":
+		_, ok := x.(float64)
+		return ok
+	}
+	return typeOf(x) == name
+}
+
+func typeOf(x any) string {
+	switch x.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	}
+	return "object"
+}
+
+func typeNames(t any) string {
+	if list, ok := t.([]any); ok {
+		names := make([]string, 0, len(list))
+		for _, n := range list {
+			names = append(names, fmt.Sprint(n))
+		}
+		return strings.Join(names, " or ")
+	}
+	return fmt.Sprint(t)
+}
+
+func number(x any) (float64, bool) {
+	f, ok := x.(float64)
+	return f, ok
+}
+
+func contains(list []any, x any) bool {
+	for _, e := range list {
+		if reflect.DeepEqual(e, x) {
+			return true
+		}
+	}
+	return false
+}
+
+func compact(x any) string {
+	b, _ := json.Marshal(x)
+	return string(b)
+}
+
+// escape encodes a property name as a JSON pointer segment.
+func escape(k string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(k, "~", "~0"), "/", "~1")
+}
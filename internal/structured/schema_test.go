@@ -0,0 +1,59 @@
+package structured
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+const person = `{
+	"type": "object",
+	"properties": {
+		"name": {"type": "string", "minLength": 1},
+		"age": {"type": "integer", "minimum": 0},
+		"email": {"type": ["string", "null"], "pattern": "^[^@]+@[^@]+$"},
+		"role": {"enum": ["admin", "user"]},
+		"tags": {"type": "array", "items": {"type": "string"}, "maxItems": 2},
+		"address": {"$ref": "#/$defs/address"}
+	},
+	"required": ["name", "age"],
+	"additionalProperties": false,
+	"$defs": {"address": {"type": "object", "properties": {"city": {"type": "string"}}, "required": ["city"]}}
+}`
+
+func TestValidate(t *testing.T) {
+	for _, c := range []struct {
+		doc  string
+		want []string
+	}{
+		{`{"name": "Ada", "age": 36, "email": null, "role": "admin", "tags": ["x"], "address": {"city": "London"}}`, nil},
+		{`{"name": "Ada"}`, []string{`/: missing required property "age"`}},
+		{`{"name": "", "age": 36.5}`, []string{"/age: expected integer, got number", "/name: must be at least 1 characters long"}},
+		{`{"name": "Ada", "age": -1, "nick": "A"}`, []string{"/age: must be at least 0", "/nick: property is not allowed"}},
+		{`{"name": "Ada", "age": 1, "email": "nope", "role": "root"}`, []string{"/email: must match ^[^@]+@[^@]+$", `/role: must be one of ["admin","user"]`}},
+		{`{"name": "Ada", "age": 1, "tags": ["a", 2, "c"], "address": {}}`, []string{`/address: missing required property "city"`, "/tags: must have at most 2 items", "/tags/1: expected string, got number"}},
+		{`["Ada"]`, []string{"/: expected object, got array"}},
+		{`{"name": "Ada", "age": 1`, []string{"not valid JSON: unexpected end of JSON input"}},
+	} {
+		got := Validate(json.RawMessage(person), c.doc)
+		if strings.Join(got, "\n") != strings.Join(c.want, "\n") {
+			t.Errorf("%s:\ngot  %q\nwant %q", c.doc, got, c.want)
+		}
+	}
+}
+
+func TestValidateCombinators(t *testing.T) {
+	schema := json.RawMessage(`{"anyOf": [{"type": "string"}, {"type": "number", "exclusiveMaximum": 10}], "not": {"const": "no"}}`)
+	for doc, valid := range map[string]bool{`"yes"`: true, `5`: true, `10`: false, `"no"`: false, `true`: false} {
+		if errs := Validate(schema, doc); (len(errs) == 0) != valid {
+			t.Errorf("%s: %q", doc, errs)
+		}
+	}
+	schema = json.RawMessage(`{"oneOf": [{"type": "integer"}, {"type": "number"}]}`)
+	if errs := Validate(schema, `1`); len(errs) != 1 {
+		t.Errorf("oneOf matching twice: %q", errs)
+	}
+	if errs := Validate(json.RawMessage(`{}`), `{"any": [1, "thing"]}`); len(errs) != 0 {
+		t.Errorf("empty schema: %q", errs)
+	}
+}
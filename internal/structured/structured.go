@@ -0,0 +1,330 @@
+// Package structured checks structured outputs: when an /api/chat or
+// /api/generate request asks for a JSON schema with "format", the
+// completed response is validated against it, and a response that
+// doesn't match is rejected, annotated, or generated again with a repair
+// prompt. Clients no longer each need their own validation.
+package structured
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/yeti47/ollama-proxy/internal/apierror"
+	"github.com/yeti47/ollama-proxy/internal/metrics"
+	"github.com/yeti47/ollama-proxy/internal/ollama"
+)
+
+var checked = metrics.NewCounter("ollama_proxy_structured_outputs_total",
+	"Responses checked against their requested format, by result (valid, repaired, invalid).", "result")
+
+// What happens to a response that doesn't match its schema.
+const (
+	// Reject answers with an error instead.
+	Reject = "reject"
+	// Annotate passes it on with a "schema_errors" list.
+	Annotate = "annotate"
+	// Retry asks the model again, telling it what was wrong, and rejects
+	// the response if it still doesn't match after the last attempt.
+	Retry = "retry"
+)
+
+// ParseAction validates an action name.
+func ParseAction(s string) (string, error) {
+	switch s {
+	case Reject, Annotate, Retry:
+		return s, nil
+	}
+	return "", fmt.Errorf("structured output action must be %s, %s or %s, not %q", Reject, Annotate, Retry, s)
+}
+
+// Validator checks the responses of requests with a format.
+type Validator struct {
+	Action string
+	// Retries is how often Retry asks again.
+	Retries int
+}
+
+// Middleware validates the responses of next. With Retry, streaming
+// requests are sent upstream without streaming, so a response can be
+// replaced before the client sees any of it; the client then gets it as
+// a stream of one chunk.
+func (v *Validator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || (r.URL.Path != "/api/chat" && r.URL.Path != "/api/generate") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		body, err := ollama.PeekBody(r)
+		if err != nil {
+			apierror.BodyError(w, err)
+			return
+		}
+		var req map[string]json.RawMessage
+		if json.Unmarshal(body, &req) != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		schema := requestedSchema(req["format"])
+		if schema == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// the response is read here, so it must arrive uncompressed
+		r.Header.Del("Accept-Encoding")
+		chat := r.URL.Path == "/api/chat"
+		stream := string(req["stream"]) != "false"
+		if stream && v.Action != Retry {
+			sw := &streamWriter{ResponseWriter: w, v: v, schema: schema, chat: chat}
+			next.ServeHTTP(sw, r)
+			sw.finish()
+			return
+		}
+		if stream {
+			req["stream"] = json.RawMessage("false")
+			b, _ := json.Marshal(req)
+			ollama.ReplaceBody(r, b)
+		}
+		v.complete(w, r, next, req, schema, chat, stream)
+	})
+}
+
+// requestedSchema returns the schema of a format: itself, or the empty
+// schema, which any JSON matches, for "json". It returns nil without a
+// format.
+func requestedSchema(format json.RawMessage) json.RawMessage {
+	f := bytes.TrimSpace(format)
+	switch {
+	case string(f) == `"json"`:
+		return json.RawMessage("{}")
+	case len(f) > 0 && f[0] == '{':
+		return f
+	}
+	return nil
+}
+
+// complete handles a request answered in one piece, asking again as often
+// as Retry allows.
+func (v *Validator) complete(w http.ResponseWriter, r *http.Request, next http.Handler, req map[string]json.RawMessage, schema json.RawMessage, chat, stream bool) {
+	for attempt := 0; ; attempt++ {
+		bw := &bufferWriter{header: http.Header{}}
+		next.ServeHTTP(bw, r)
+		mt, _, _ := mime.ParseMediaType(bw.header.Get("Content-Type"))
+		var resp map[string]json.RawMessage
+		if bw.status != http.StatusOK || mt != "application/json" || json.Unmarshal(bw.buf.Bytes(), &resp) != nil {
+			// an error, or something other than a complete response
+			bw.copyTo(w)
+			return
+		}
+		text := responseText(resp, chat)
+		errs := Validate(schema, text)
+		switch {
+		case len(errs) == 0:
+			if attempt > 0 {
+				checked.With("repaired").Inc()
+			} else {
+				checked.With("valid").Inc()
+			}
+		case v.Action == Retry && attempt < v.Retries:
+			b, _ := json.Marshal(repair(req, chat, text, errs))
+			ollama.ReplaceBody(r, b)
+			continue
+		case v.Action == Annotate:
+			checked.With("invalid").Inc()
+			resp["schema_errors"], _ = json.Marshal(errs)
+		default:
+			checked.With("invalid").Inc()
+			apierror.Write(w, http.StatusBadGateway, message(errs))
+			return
+		}
+		out, _ := json.Marshal(resp)
+		for k, vals := range bw.header {
+			w.Header()[k] = vals
+		}
+		if stream {
+			// a stream of one chunk: the complete response is also the
+			// final one
+			out = append(out, '\n')
+			w.Header().Set("Content-Type", "application/x-ndjson")
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(out)))
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(out)
+		return
+	}
+}
+
+// repair returns the request asking again: a chat continues with the
+// rejected answer and a request to fix it, a generate request gets both
+// appended to its prompt.
+func repair(req map[string]json.RawMessage, chat bool, text string, errs []string) map[string]json.RawMessage {
+	instruction := "That response does not match the required JSON schema:\n- " + strings.Join(errs, "\n- ") +
+		"\nRespond again with only JSON that matches the schema."
+	out := make(map[string]json.RawMessage, len(req))
+	for k, v := range req {
+		out[k] = v
+	}
+	out["stream"] = json.RawMessage("false")
+	if chat {
+		var msgs []json.RawMessage
+		_ = json.Unmarshal(req["messages"], &msgs)
+		for _, m := range []map[string]string{{"role": "assistant", "content": text}, {"role": "user", "content": instruction}} {
+			b, _ := json.Marshal(m)
+			msgs = append(msgs, b)
+		}
+		out["messages"], _ = json.Marshal(msgs)
+		return out
+	}
+	var prompt string
+	_ = json.Unmarshal(req["prompt"], &prompt)
+	out["prompt"], _ = json.Marshal(prompt + "\n\nA previous response was:\n" + text + "\n\n" + instruction)
+	delete(out, "context")
+	return out
+}
+
+// responseText returns the generated text of a response or stream chunk.
+func responseText(resp map[string]json.RawMessage, chat bool) string {
+	var s string
+	if chat {
+		var m struct {
+			Content string `json:"content"`
+		}
+		_ = json.Unmarshal(resp["message"], &m)
+		return m.Content
+	}
+	_ = json.Unmarshal(resp["response"], &s)
+	return s
+}
+
+func message(errs []string) string {
+	return "response does not match the requested format: " + strings.Join(errs, "; ")
+}
+
+// bufferWriter holds on to a whole response.
+type bufferWriter struct {
+	header http.Header
+	status int
+	buf    bytes.Buffer
+}
+
+func (w *bufferWriter) Header() http.Header { return w.header }
+
+func (w *bufferWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+}
+
+func (w *bufferWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.buf.Write(b)
+}
+
+// Flush is a no-op: nothing reaches the client before the response is
+// checked.
+func (w *bufferWriter) Flush() {}
+
+func (w *bufferWriter) copyTo(dst http.ResponseWriter) {
+	for k, v := range w.header {
+		dst.Header()[k] = v
+	}
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	dst.WriteHeader(w.status)
+	_, _ = dst.Write(w.buf.Bytes())
+}
+
+// streamWriter passes a stream on as it arrives, collecting its text, and
+// checks it when the final chunk comes: Reject replaces that chunk with an
+// error, Annotate adds the problems to it.
+type streamWriter struct {
+	http.ResponseWriter
+	v      *Validator
+	schema json.RawMessage
+	chat   bool
+
+	wrote  bool
+	stream bool
+	buf    bytes.Buffer
+	text   strings.Builder
+}
+
+func (w *streamWriter) WriteHeader(code int) {
+	if w.wrote {
+		return
+	}
+	w.wrote = true
+	mt, _, _ := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	w.stream = code == http.StatusOK && mt == "application/x-ndjson"
+	if w.stream {
+		w.Header().Del("Content-Length")
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *streamWriter) Write(b []byte) (int, error) {
+	if !w.wrote {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.stream {
+		return w.ResponseWriter.Write(b)
+	}
+	w.buf.Write(b)
+	for {
+		i := bytes.IndexByte(w.buf.Bytes(), '\n')
+		if i == -1 {
+			break
+		}
+		if _, err := w.ResponseWriter.Write(w.line(w.buf.Next(i + 1))); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+func (w *streamWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *streamWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+func (w *streamWriter) finish() {
+	if w.stream && w.buf.Len() > 0 {
+		_, _ = w.ResponseWriter.Write(w.line(w.buf.Bytes()))
+	}
+}
+
+// line collects the text of a chunk and checks the final one.
+func (w *streamWriter) line(l []byte) []byte {
+	var c map[string]json.RawMessage
+	if json.Unmarshal(l, &c) != nil {
+		return l
+	}
+	w.text.WriteString(responseText(c, w.chat))
+	if string(c["done"]) != "true" {
+		return l
+	}
+	errs := Validate(w.schema, w.text.String())
+	if len(errs) == 0 {
+		checked.With("valid").Inc()
+		return l
+	}
+	checked.With("invalid").Inc()
+	if w.v.Action == Annotate {
+		c["schema_errors"], _ = json.Marshal(errs)
+	} else {
+		c = map[string]json.RawMessage{}
+		c["error"], _ = json.Marshal(message(errs))
+	}
+	out, _ := json.Marshal(c)
+	return append(out, '\n')
+}
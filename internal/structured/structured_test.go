@@ -0,0 +1,122 @@
+package structured
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const format = `{"type":"object","properties":{"n":{"type":"integer"}},"required":["n"]}`
+
+// replies answers successive requests with the contents of replies,
+// recording their bodies.
+func replies(t *testing.T, got *[]map[string]any, contentType string, replies ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]any
+		b, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(b, &req); err != nil {
+			t.Errorf("forwarded %s: %v", b, err)
+		}
+		*got = append(*got, req)
+		if len(*got) > len(replies) {
+			t.Fatalf("%d requests", len(*got))
+		}
+		w.Header().Set("Content-Type", contentType)
+		_, _ = io.WriteString(w, replies[len(*got)-1])
+	})
+}
+
+func chatReply(content string, done bool) string {
+	b, _ := json.Marshal(map[string]any{"model": "llama3", "message": map[string]string{"role": "assistant", "content": content}, "done": done})
+	return string(b)
+}
+
+func post(h http.Handler, path, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+	return rec
+}
+
+func TestRetry(t *testing.T) {
+	var got []map[string]any
+	v := &Validator{Action: Retry, Retries: 2}
+	h := v.Middleware(replies(t, &got, "application/json; charset=utf-8",
+		chatReply(`{"n": "one"}`, true), chatReply(`{"n": 1}`, true)))
+	rec := post(h, "/api/chat", `{"model":"llama3","format":`+format+`,"messages":[{"role":"user","content":"Count"}]}`)
+
+	if len(got) != 2 || got[0]["stream"] != false {
+		t.Fatalf("requests %v", got)
+	}
+	msgs := got[1]["messages"].([]any)
+	if len(msgs) != 3 || msgs[1].(map[string]any)["content"] != `{"n": "one"}` ||
+		!strings.Contains(msgs[2].(map[string]any)["content"].(string), "/n: expected integer, got string") {
+		t.Errorf("repair request %v", msgs)
+	}
+	// the client asked for a stream and gets one chunk
+	if ct := rec.Header().Get("Content-Type"); ct != "application/x-ndjson" || rec.Body.String() != chatReply(`{"n": 1}`, true)+"\n" {
+		t.Errorf("%s: %s", ct, rec.Body)
+	}
+}
+
+func TestRetryExhausted(t *testing.T) {
+	var got []map[string]any
+	v := &Validator{Action: Retry, Retries: 1}
+	h := v.Middleware(replies(t, &got, "application/json",
+		`{"response":"nope","done":true}`, `{"response":"{}","done":true,"context":[1,2]}`))
+	rec := post(h, "/api/generate", `{"model":"llama3","format":`+format+`,"prompt":"Count","stream":false,"context":[1,2]}`)
+	if len(got) != 2 || !strings.Contains(got[1]["prompt"].(string), "A previous response was:\nnope") || got[1]["context"] != nil {
+		t.Errorf("requests %v", got)
+	}
+	if rec.Code != http.StatusBadGateway || !strings.Contains(rec.Body.String(), `missing required property \"n\"`) {
+		t.Errorf("%d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestAnnotate(t *testing.T) {
+	var got []map[string]any
+	v := &Validator{Action: Annotate}
+	h := v.Middleware(replies(t, &got, "application/json", chatReply(`{}`, true)))
+	rec := post(h, "/api/chat", `{"model":"llama3","format":`+format+`,"stream":false,"messages":[]}`)
+	var resp struct {
+		Errors []string `json:"schema_errors"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || len(resp.Errors) != 1 {
+		t.Errorf("%s (%v)", rec.Body, err)
+	}
+}
+
+func TestStream(t *testing.T) {
+	stream := strings.Join([]string{chatReply(`{"n":`, false), chatReply(` 1}`, false), chatReply("", true)}, "\n") + "\n"
+	var got []map[string]any
+	v := &Validator{Action: Reject}
+	h := v.Middleware(replies(t, &got, "application/x-ndjson", stream))
+	if rec := post(h, "/api/chat", `{"model":"llama3","format":`+format+`,"messages":[]}`); rec.Body.String() != stream {
+		t.Errorf("valid stream changed: %s", rec.Body)
+	}
+
+	got = nil
+	stream = strings.Join([]string{chatReply(`{"m": 1}`, false), chatReply("", true)}, "\n") + "\n"
+	h = v.Middleware(replies(t, &got, "application/x-ndjson", stream))
+	rec := post(h, "/api/chat", `{"model":"llama3","format":"json","messages":[]}`)
+	if rec.Body.String() != stream {
+		t.Errorf("format json: %s", rec.Body)
+	}
+	got = nil
+	rec = post(h, "/api/chat", `{"model":"llama3","format":`+format+`,"messages":[]}`)
+	lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[1], `{"error":"response does not match the requested format: /: missing required property`) {
+		t.Errorf("invalid stream: %s", rec.Body)
+	}
+}
+
+func TestPassThrough(t *testing.T) {
+	var got []map[string]any
+	v := &Validator{Action: Retry, Retries: 3}
+	h := v.Middleware(replies(t, &got, "application/json", chatReply("plain text", true)))
+	if rec := post(h, "/api/chat", `{"model":"llama3","stream":false,"messages":[]}`); rec.Body.String() != chatReply("plain text", true) || len(got) != 1 {
+		t.Errorf("request without format: %s", rec.Body)
+	}
+}
@@ -0,0 +1,222 @@
+// Package sysprompt adds configured system prompts to chat and generate
+// requests, such as an organization-wide instruction or a guardrail
+// preamble for some keys.
+package sysprompt
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/yeti47/ollama-proxy/internal/access"
+	"github.com/yeti47/ollama-proxy/internal/apierror"
+	"github.com/yeti47/ollama-proxy/internal/auth"
+	"github.com/yeti47/ollama-proxy/internal/metrics"
+	"github.com/yeti47/ollama-proxy/internal/ollama"
+)
+
+var injected = metrics.NewCounter("ollama_proxy_system_prompts_total",
+	"Requests given a configured system prompt, by rule.", "rule")
+
+// Rule is one system prompt, read from the "system_prompts" section of
+// the config file.
+type Rule struct {
+	// Name labels the rule in logs and metrics; it defaults to its index.
+	Name string `json:"name,omitempty"`
+	// Path matches /api/chat or /api/generate, with path.Match wildcards;
+	// by default both.
+	Path string `json:"path,omitempty"`
+	// Models and Clients restrict the rule as in request rewrites.
+	Models  []string `json:"models,omitempty"`
+	Clients []string `json:"clients,omitempty"`
+	Prompt  string   `json:"prompt"`
+	// Replace drops the client's own system prompt instead of keeping it
+	// after this one.
+	Replace bool `json:"replace,omitempty"`
+}
+
+// Rules is a compiled, ordered set of rules.
+type Rules struct {
+	rules []Rule
+}
+
+// New checks rules.
+func New(rules []Rule) (*Rules, error) {
+	rs := &Rules{}
+	for i, r := range rules {
+		if r.Name == "" {
+			r.Name = "#" + strconv.Itoa(i)
+		}
+		if r.Prompt == "" {
+			return nil, fmt.Errorf("system prompt %s: prompt is required", r.Name)
+		}
+		if r.Path != "" {
+			if _, err := path.Match(r.Path, "/"); err != nil {
+				return nil, fmt.Errorf("system prompt %s: path %q: %w", r.Name, r.Path, err)
+			}
+		}
+		rs.rules = append(rs.rules, r)
+	}
+	return rs, nil
+}
+
+// Len reports the number of rules.
+func (rs *Rules) Len() int {
+	if rs == nil {
+		return 0
+	}
+	return len(rs.rules)
+}
+
+// matches reports whether r applies to a request for reqPath from id for
+// model.
+func (r *Rule) matches(reqPath string, id *auth.Identity, model string) bool {
+	if r.Path != "" {
+		if ok, _ := path.Match(r.Path, reqPath); !ok {
+			return false
+		}
+	}
+	if len(r.Clients) > 0 {
+		found := false
+		for _, c := range r.Clients {
+			found = found || (id != nil && (c == id.Name || (id.Tenant != "" && c == id.Tenant)))
+		}
+		if !found {
+			return false
+		}
+	}
+	return len(r.Models) == 0 || access.ModelAllowed(r.Models, model)
+}
+
+// Middleware adds the prompts of the matching rules to requests to next.
+// The prompts of several rules are joined in order, ahead of the client's
+// own system prompt.
+func (rs *Rules) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rs.Len() == 0 || r.Method != http.MethodPost || (r.URL.Path != "/api/chat" && r.URL.Path != "/api/generate") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		body, err := ollama.PeekBody(r)
+		if err != nil {
+			apierror.BodyError(w, err)
+			return
+		}
+		var req map[string]json.RawMessage
+		if json.Unmarshal(body, &req) != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		var model string
+		_ = json.Unmarshal(req["model"], &model)
+		id := auth.FromContext(r.Context())
+		var prompts, names []string
+		replace := false
+		for i := range rs.rules {
+			rule := &rs.rules[i]
+			if rule.matches(r.URL.Path, id, model) {
+				prompts = append(prompts, rule.Prompt)
+				names = append(names, rule.Name)
+				replace = replace || rule.Replace
+			}
+		}
+		if len(prompts) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		prompt := strings.Join(prompts, "\n\n")
+		if r.URL.Path == "/api/chat" {
+			err = chat(req, prompt, replace)
+		} else {
+			err = generate(req, prompt, replace)
+		}
+		if err != nil {
+			// a body Ollama would reject anyway
+			next.ServeHTTP(w, r)
+			return
+		}
+		b, err := marshal(req)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ollama.ReplaceBody(r, b)
+		for _, name := range names {
+			injected.With(name).Inc()
+		}
+		slog.DebugContext(r.Context(), "system prompt added", "rules", names)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// chat puts prompt in a system message at the start of messages. A
+// leading system message of the client's is kept after it, in the same
+// message, unless replace is set; replace also drops any later ones.
+func chat(req map[string]json.RawMessage, prompt string, replace bool) error {
+	var messages []map[string]json.RawMessage
+	if raw, ok := req["messages"]; ok && string(raw) != "null" {
+		if err := json.Unmarshal(raw, &messages); err != nil {
+			return err
+		}
+	}
+	if replace {
+		kept := messages[:0]
+		for _, m := range messages {
+			var role string
+			_ = json.Unmarshal(m["role"], &role)
+			if role != "system" {
+				kept = append(kept, m)
+			}
+		}
+		messages = kept
+	}
+	var role, content string
+	if len(messages) > 0 {
+		_ = json.Unmarshal(messages[0]["role"], &role)
+		_ = json.Unmarshal(messages[0]["content"], &content)
+	}
+	if role == "system" {
+		if content != "" {
+			prompt += "\n\n" + content
+		}
+		messages[0]["content"], _ = marshal(prompt)
+	} else {
+		c, _ := marshal(prompt)
+		messages = append([]map[string]json.RawMessage{{"role": json.RawMessage(`"system"`), "content": c}}, messages...)
+	}
+	var err error
+	req["messages"], err = marshal(messages)
+	return err
+}
+
+// generate puts prompt ahead of the request's system field.
+func generate(req map[string]json.RawMessage, prompt string, replace bool) error {
+	var system string
+	if raw, ok := req["system"]; ok && string(raw) != "null" {
+		if err := json.Unmarshal(raw, &system); err != nil {
+			return err
+		}
+	}
+	if system != "" && !replace {
+		prompt += "\n\n" + system
+	}
+	var err error
+	req["system"], err = marshal(prompt)
+	return err
+}
+
+// marshal encodes v without escaping <, > and &, which prompts often hold.
+func marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
@@ -0,0 +1,59 @@
+package sysprompt
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/yeti47/ollama-proxy/internal/auth"
+)
+
+func TestNew(t *testing.T) {
+	if _, err := New([]Rule{{Name: "empty"}}); err == nil {
+		t.Error("rule without a prompt accepted")
+	}
+	if _, err := New([]Rule{{Path: "[", Prompt: "x"}}); err == nil {
+		t.Error("bad path accepted")
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	rs, err := New([]Rule{
+		{Name: "org", Prompt: "Be <brief>."},
+		{Name: "support", Clients: []string{"support"}, Path: "/api/chat", Prompt: "You answer support tickets."},
+		{Name: "coder", Models: []string{"qwen2.5-coder*"}, Prompt: "Only write Go.", Replace: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got string
+	h := rs.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		got = string(b)
+	}))
+	for _, c := range []struct {
+		client, path, body, want string
+	}{
+		{"", "/api/chat", `{"model":"llama3","messages":[{"role":"user","content":"hi"}]}`,
+			`{"messages":[{"content":"Be <brief>.","role":"system"},{"content":"hi","role":"user"}],"model":"llama3"}`},
+		{"support", "/api/chat", `{"model":"llama3","messages":[{"role":"system","content":"Mine."},{"role":"user","content":"hi"}]}`,
+			`{"messages":[{"content":"Be <brief>.\n\nYou answer support tickets.\n\nMine.","role":"system"},{"content":"hi","role":"user"}],"model":"llama3"}`},
+		{"support", "/api/generate", `{"model":"llama3","prompt":"hi","system":"Mine."}`,
+			`{"model":"llama3","prompt":"hi","system":"Be <brief>.\n\nMine."}`},
+		{"", "/api/generate", `{"model":"qwen2.5-coder:7b","prompt":"hi","system":"Mine."}`,
+			`{"model":"qwen2.5-coder:7b","prompt":"hi","system":"Be <brief>.\n\nOnly write Go."}`},
+		{"", "/api/embed", `{"model":"llama3","input":"hi"}`, `{"model":"llama3","input":"hi"}`},
+	} {
+		r := httptest.NewRequest(http.MethodPost, c.path, strings.NewReader(c.body))
+		if c.client != "" {
+			r = r.WithContext(auth.WithIdentity(context.Background(), &auth.Identity{Name: c.client}))
+		}
+		h.ServeHTTP(httptest.NewRecorder(), r)
+		if got != c.want {
+			t.Errorf("%s %s:\ngot  %s\nwant %s", c.client, c.path, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+// Package think removes reasoning from /api/chat and /api/generate
+// responses: the thinking field, and <think>…</think> blocks that
+// reasoning models write into the text when the upstream doesn't separate
+// them. Some clients show either as part of the answer.
+package think
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/yeti47/ollama-proxy/internal/auth"
+	"github.com/yeti47/ollama-proxy/internal/metrics"
+	"github.com/yeti47/ollama-proxy/internal/ndjson"
+)
+
+var stripped = metrics.NewCounter("ollama_proxy_thinking_stripped_total",
+	"Responses with reasoning removed, by endpoint.", "endpoint")
+
+// Header lets a request choose: "true" to strip reasoning from its
+// response, "false" to keep it. It is not forwarded.
+const Header = "X-Proxy-Strip-Thinking"
+
+// Stripper decides per request whether reasoning is removed.
+type Stripper struct {
+	// Default applies to requests without a client setting or header.
+	Default bool
+	// Clients overrides Default by client name.
+	Clients map[string]bool
+}
+
+// strip reports whether r's response loses its reasoning: the header
+// wins over the client's setting, which wins over Default.
+func (s *Stripper) strip(r *http.Request) bool {
+	if v := r.Header.Get(Header); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			return b
+		}
+	}
+	if id := auth.FromContext(r.Context()); id != nil {
+		if b, ok := s.Clients[id.Name]; ok {
+			return b
+		}
+	}
+	return s.Default
+}
+
+// Middleware strips reasoning from the responses of next where
+// requested.
+func (s *Stripper) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		strip := s.strip(r)
+		r.Header.Del(Header)
+		if !strip || r.Method != http.MethodPost || (r.URL.Path != "/api/chat" && r.URL.Path != "/api/generate") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// the response is rewritten here, so it must arrive uncompressed
+		r.Header.Del("Accept-Encoding")
+		resp := &response{chat: r.URL.Path == "/api/chat"}
+		sw := ndjson.NewWriter(w, resp.rewrite)
+		next.ServeHTTP(sw, r)
+		sw.Finish()
+		if resp.changed {
+			stripped.With(r.URL.Path).Inc()
+		}
+	})
+}
+
+// response is the state of a response having its reasoning stripped.
+type response struct {
+	chat    bool
+	text    filter
+	changed bool
+}
+
+// rewrite implements the ndjson.Writer rewrite func.
+func (rs *response) rewrite(b []byte, complete bool) []byte {
+	if out, ok := rs.chunk(b, complete); ok {
+		return out
+	}
+	return b
+}
+
+// chunk strips the reasoning from a response or stream chunk; ok is
+// false if there was none. Text held back at the end of one chunk, in
+// case it starts a <think> tag, goes out with the next one, and with the
+// final chunk at the latest.
+func (rs *response) chunk(b []byte, complete bool) ([]byte, bool) {
+	var c map[string]json.RawMessage
+	if json.Unmarshal(b, &c) != nil {
+		return nil, false
+	}
+	done := complete || string(c["done"]) == "true"
+	obj, key := c, "response"
+	if rs.chat {
+		obj = nil
+		if json.Unmarshal(c["message"], &obj) != nil || obj == nil {
+			return nil, false
+		}
+		key = "content"
+	}
+	changed := false
+	if _, ok := obj["thinking"]; ok {
+		delete(obj, "thinking")
+		changed = true
+	}
+	var text string
+	if json.Unmarshal(obj[key], &text) == nil {
+		out := rs.text.write(text)
+		if done {
+			out += rs.text.flush()
+		}
+		if out != text {
+			obj[key], _ = json.Marshal(out)
+			changed = true
+		}
+	}
+	if !changed {
+		return nil, false
+	}
+	rs.changed = true
+	if rs.chat {
+		c["message"], _ = json.Marshal(obj)
+	}
+	out, _ := json.Marshal(c)
+	return out, true
+}
+
+const (
+	openTag  = "<think>"
+	closeTag = "</think>"
+)
+
+// filter removes <think> blocks from text arriving in pieces. Tags may
+// be split across pieces, so a piece ending in what could be the start of
+// a tag is held back until the next one.
+type filter struct {
+	inside bool   // within a block
+	trim   bool   // a block just ended; drop the whitespace after it
+	held   string // the possible start of a tag
+}
+
+func (f *filter) write(s string) string {
+	s, f.held = f.held+s, ""
+	var out strings.Builder
+	for s != "" {
+		if f.inside {
+			i := strings.Index(s, closeTag)
+			if i == -1 {
+				f.held = partial(s, closeTag)
+				break
+			}
+			s, f.inside, f.trim = s[i+len(closeTag):], false, true
+			continue
+		}
+		i := strings.Index(s, openTag)
+		if i == -1 {
+			f.held = partial(s, openTag)
+			f.emit(&out, s[:len(s)-len(f.held)])
+			break
+		}
+		f.emit(&out, s[:i])
+		s, f.inside = s[i+len(openTag):], true
+	}
+	return out.String()
+}
+
+// flush returns the text held back at the end of the response. An
+// unclosed block is dropped.
+func (f *filter) flush() string {
+	var out strings.Builder
+	if !f.inside {
+		f.emit(&out, f.held)
+	}
+	f.held = ""
+	return out.String()
+}
+
+func (f *filter) emit(out *strings.Builder, s string) {
+	if f.trim {
+		s = strings.TrimLeft(s, " \t\r\n")
+		f.trim = s == ""
+	}
+	out.WriteString(s)
+}
+
+// partial returns the longest end of s that tag starts with.
+func partial(s, tag string) string {
+	for n := min(len(s), len(tag)-1); n > 0; n-- {
+		if strings.HasSuffix(s, tag[:n]) {
+			return s[len(s)-n:]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,107 @@
+package think
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/yeti47/ollama-proxy/internal/auth"
+)
+
+func TestFilter(t *testing.T) {
+	for _, c := range []struct {
+		pieces []string
+		want   string
+	}{
+		{[]string{"<think>hmm</think>\n\nHello"}, "Hello"},
+		{[]string{"<th", "ink>hm", "m</th", "ink>", "\n", "Hel", "lo"}, "Hello"},
+		{[]string{"a < b and <thinking"}, "a < b and <thinking"},
+		{[]string{"Hi <", "b>"}, "Hi <b>"},
+		{[]string{"Hi <thi"}, "Hi <thi"},
+		{[]string{"<think>never closed"}, ""},
+		{[]string{"One <think>x</think> two"}, "One two"},
+	} {
+		var f filter
+		var got string
+		for _, p := range c.pieces {
+			got += f.write(p)
+		}
+		got += f.flush()
+		if got != c.want {
+			t.Errorf("%q: got %q, want %q", c.pieces, got, c.want)
+		}
+	}
+}
+
+func upstream(contentType, body string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(Header) != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", contentType)
+		_, _ = io.WriteString(w, body)
+	})
+}
+
+func TestStream(t *testing.T) {
+	s := &Stripper{Default: true}
+	h := s.Middleware(upstream("application/x-ndjson", strings.Join([]string{
+		`{"model":"qwen3","message":{"role":"assistant","content":"","thinking":"Let me see."},"done":false}`,
+		`{"model":"qwen3","message":{"role":"assistant","content":"<think>more</think>\n\nIt is 4"},"done":false}`,
+		`{"model":"qwen3","message":{"role":"assistant","content":" <"},"done":false}`,
+		`{"model":"qwen3","message":{"role":"assistant","content":""},"done":true,"eval_count":9}`,
+	}, "\n")+"\n"))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/chat", strings.NewReader(`{}`)))
+	want := strings.Join([]string{
+		`{"done":false,"message":{"content":"","role":"assistant"},"model":"qwen3"}`,
+		`{"done":false,"message":{"content":"It is 4","role":"assistant"},"model":"qwen3"}`,
+		`{"done":false,"message":{"content":" ","role":"assistant"},"model":"qwen3"}`,
+		`{"done":true,"eval_count":9,"message":{"content":"\u003c","role":"assistant"},"model":"qwen3"}`,
+	}, "\n") + "\n"
+	if rec.Body.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", rec.Body, want)
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	s := &Stripper{Clients: map[string]bool{"app": true}}
+	h := s.Middleware(upstream("application/json; charset=utf-8",
+		`{"response":"<think>hmm</think>4","thinking":"x","done":true}`))
+	req := httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(`{}`))
+	req = req.WithContext(auth.WithIdentity(context.Background(), &auth.Identity{Name: "app"}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Body.String() != `{"done":true,"response":"4"}` || rec.Header().Get("Content-Length") != "28" {
+		t.Errorf("got %s (Content-Length %s)", rec.Body, rec.Header().Get("Content-Length"))
+	}
+}
+
+func TestChoice(t *testing.T) {
+	s := &Stripper{Default: true, Clients: map[string]bool{"raw": false}}
+	for _, c := range []struct {
+		client, header string
+		want           bool
+	}{
+		{"", "", true},
+		{"raw", "", false},
+		{"raw", "true", true},
+		{"other", "false", false},
+		{"other", "bogus", true},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/api/chat", nil)
+		if c.client != "" {
+			req = req.WithContext(auth.WithIdentity(context.Background(), &auth.Identity{Name: c.client}))
+		}
+		if c.header != "" {
+			req.Header.Set(Header, c.header)
+		}
+		if got := s.strip(req); got != c.want {
+			t.Errorf("client %q, header %q: strip %v", c.client, c.header, got)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+)
+
+// ServerConfig builds the listener TLS configuration from a certificate and
+// key. If clientCAFile is set, clients must present a certificate signed by
+// one of the CAs in that bundle.
+func ServerConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("both a certificate and a key are required for TLS")
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading server certificate: %w", err)
+	}
+	cfg := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}
+	return cfg, requireClientCerts(cfg, clientCAFile)
+}
+
+// DynamicServerConfig is like ServerConfig but serves whatever certificate
+// c currently holds.
+func DynamicServerConfig(c *Cert, clientCAFile string) (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: c.GetCertificate,
+	}
+	return cfg, requireClientCerts(cfg, clientCAFile)
+}
+
+func requireClientCerts(cfg *tls.Config, clientCAFile string) error {
+	if clientCAFile == "" {
+		return nil
+	}
+	pool, err := loadPool(clientCAFile)
+	if err != nil {
+		return fmt.Errorf("loading client CA: %w", err)
+	}
+	cfg.ClientCAs = pool
+	cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	return nil
+}
+
+// Cert holds a server certificate that can be replaced while the listener
+// is running, e.g. when it is fetched from a secrets store and rotated
+// there.
+type Cert struct {
+	cur atomic.Pointer[tls.Certificate]
+}
+
+// Set parses a PEM certificate chain and key and makes them the current
+// certificate. On error the previous certificate stays in place.
+func (c *Cert) Set(certPEM, keyPEM []byte) error {
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return err
+	}
+	c.cur.Store(&cert)
+	return nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+func (c *Cert) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cert := c.cur.Load()
+	if cert == nil {
+		return nil, errors.New("no server certificate loaded")
+	}
+	return cert, nil
+}
+
+// loadPool reads a PEM bundle into a new certificate pool.
+func loadPool(path string) (*x509.CertPool, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("%s: no PEM certificates found", path)
+	}
+	return pool, nil
+}
+
+// Client describes the TLS settings used for connections to the upstream.
+type Client struct {
+	// CertFile and KeyFile hold a client certificate presented to
+	// upstreams that sit behind an mTLS-terminating gateway.
+	CertFile string
+	KeyFile  string
+	// CAFile is a PEM bundle of additional root CAs trusted for the
+	// upstream, on top of the system roots.
+	CAFile string
+	// InsecureSkipVerify disables verification of the upstream
+	// certificate. Only meant for lab setups with self-signed certs.
+	InsecureSkipVerify bool
+}
+
+// Config builds the upstream tls.Config. Verification of the server
+// certificate stays enabled unless InsecureSkipVerify is set.
+func (c Client) Config() (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12, InsecureSkipVerify: c.InsecureSkipVerify}
+	if c.CertFile != "" || c.KeyFile != "" {
+		if c.CertFile == "" || c.KeyFile == "" {
+			return nil, errors.New("both an upstream client certificate and key are required")
+		}
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading upstream client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	if c.CAFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		b, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading upstream CA: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("loading upstream CA: %s: no PEM certificates found", c.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+	return cfg, nil
+}
@@ -0,0 +1,211 @@
+package tlsutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// pki is a CA and the files of certificates it issued.
+type pki struct {
+	dir  string
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	n    int64
+}
+
+func newPKI(t *testing.T) *pki {
+	t.Helper()
+	p := &pki{dir: t.TempDir()}
+	p.cert, p.key = p.issue(t, "ca", &x509.Certificate{IsCA: true, KeyUsage: x509.KeyUsageCertSign, BasicConstraintsValid: true})
+	return p
+}
+
+// issue signs tmpl with the CA, or itself for the CA, and writes
+// name.pem and name-key.pem.
+func (p *pki) issue(t *testing.T, name string, tmpl *x509.Certificate) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.n++
+	tmpl.SerialNumber = big.NewInt(p.n)
+	tmpl.Subject = pkix.Name{CommonName: name}
+	tmpl.NotBefore = time.Now().Add(-time.Hour)
+	tmpl.NotAfter = time.Now().Add(time.Hour)
+	parent, signer := tmpl, key
+	if p.cert != nil {
+		parent, signer = p.cert, p.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kb, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.write(t, name+".pem", &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	p.write(t, name+"-key.pem", &pem.Block{Type: "EC PRIVATE KEY", Bytes: kb})
+	cert, _ := x509.ParseCertificate(der)
+	return cert, key
+}
+
+func (p *pki) write(t *testing.T, name string, b *pem.Block) {
+	t.Helper()
+	if err := os.WriteFile(p.path(name), pem.EncodeToMemory(b), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (p *pki) path(name string) string { return filepath.Join(p.dir, name) }
+
+// mtlsServer starts a server with a certificate for 127.0.0.1 that
+// requires client certificates issued by p.
+func mtlsServer(t *testing.T, p *pki) *httptest.Server {
+	t.Helper()
+	p.issue(t, "server", &x509.Certificate{
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	cfg, err := ServerConfig(p.path("server.pem"), p.path("server-key.pem"), p.path("ca.pem"))
+	if err != nil {
+		t.Fatalf("server config: %v", err)
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	srv.TLS = cfg
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestServerConfigRequiresClientCert(t *testing.T) {
+	p := newPKI(t)
+	srv := mtlsServer(t, p)
+	p.issue(t, "client", &x509.Certificate{ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+	roots := x509.NewCertPool()
+	roots.AddCert(p.cert)
+
+	// an unrelated CA's certificate is no better than none
+	other := newPKI(t)
+	other.issue(t, "client", &x509.Certificate{ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+
+	cases := []struct {
+		name string
+		dir  string
+		ok   bool
+	}{
+		{"no certificate", "", false},
+		{"certificate from another CA", other.dir, false},
+		{"valid certificate", p.dir, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &tls.Config{RootCAs: roots}
+			if tc.dir != "" {
+				cert, err := tls.LoadX509KeyPair(filepath.Join(tc.dir, "client.pem"), filepath.Join(tc.dir, "client-key.pem"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				cfg.Certificates = []tls.Certificate{cert}
+			}
+			conn, err := tls.Dial("tcp", srv.Listener.Addr().String(), cfg)
+			if err == nil {
+				// with TLS 1.3 the server's verdict arrives after the
+				// client's handshake is done
+				_, err = conn.Write([]byte("GET / HTTP/1.0\r\n\r\n"))
+				if err == nil {
+					_, err = conn.Read(make([]byte, 1))
+				}
+				conn.Close()
+			}
+			if tc.ok && err != nil {
+				t.Fatalf("handshake failed: %v", err)
+			}
+			if !tc.ok && err == nil {
+				t.Fatal("handshake succeeded")
+			}
+		})
+	}
+}
+
+func TestClientTrustsCAFile(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	b := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := os.WriteFile(caFile, b, 0o600); err != nil {
+		t.Fatalf("write ca: %v", err)
+	}
+
+	get := func(c Client) error {
+		cfg, err := c.Config()
+		if err != nil {
+			t.Fatalf("config: %v", err)
+		}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}
+		resp, err := client.Get(srv.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	if err := get(Client{}); err == nil {
+		t.Fatal("expected verification failure without CA file")
+	}
+	if err := get(Client{CAFile: caFile}); err != nil {
+		t.Fatalf("expected success with CA file, got %v", err)
+	}
+}
+
+func TestClientCertificate(t *testing.T) {
+	p := newPKI(t)
+	srv := mtlsServer(t, p)
+	p.issue(t, "client", &x509.Certificate{ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+
+	get := func(c Client) error {
+		cfg, err := c.Config()
+		if err != nil {
+			t.Fatalf("config: %v", err)
+		}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}
+		resp, err := client.Get(srv.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	if err := get(Client{CAFile: p.path("ca.pem")}); err == nil {
+		t.Fatal("expected the upstream to refuse a client without certificate")
+	}
+	c := Client{CertFile: p.path("client.pem"), KeyFile: p.path("client-key.pem"), CAFile: p.path("ca.pem")}
+	if err := get(c); err != nil {
+		t.Fatalf("expected success with client certificate, got %v", err)
+	}
+
+	for _, c := range []Client{{CertFile: p.path("client.pem")}, {KeyFile: p.path("client-key.pem")}} {
+		if _, err := c.Config(); err == nil || err.Error() != "both an upstream client certificate and key are required" {
+			t.Errorf("Config(%+v) = %v", c, err)
+		}
+	}
+}
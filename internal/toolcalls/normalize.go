@@ -0,0 +1,178 @@
+package toolcalls
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+// normalizeCalls brings each tool call into Ollama's shape,
+// {"function": {"name": ..., "arguments": {...}}}; ok is false if the
+// calls were fine already.
+func normalizeCalls(raw json.RawMessage) (json.RawMessage, bool) {
+	var calls []map[string]json.RawMessage
+	if len(raw) == 0 || json.Unmarshal(raw, &calls) != nil {
+		return nil, false
+	}
+	changed := false
+	for i, c := range calls {
+		if nc, ok := normalizeCall(c); ok {
+			calls[i], changed = nc, true
+		}
+	}
+	if !changed {
+		return nil, false
+	}
+	b, _ := json.Marshal(calls)
+	return b, true
+}
+
+func normalizeCall(c map[string]json.RawMessage) (map[string]json.RawMessage, bool) {
+	changed := false
+	var fn map[string]json.RawMessage
+	if json.Unmarshal(c["function"], &fn) != nil || fn == nil {
+		// {"name": ..., "arguments": ...} without the wrapper
+		if _, ok := c["name"]; !ok {
+			return nil, false
+		}
+		fn = map[string]json.RawMessage{"name": c["name"]}
+		for _, k := range []string{"arguments", "parameters"} {
+			if v, ok := c[k]; ok {
+				fn[k] = v
+			}
+		}
+		c = map[string]json.RawMessage{}
+		fixed.With("shape").Inc()
+		changed = true
+	}
+	if _, ok := fn["arguments"]; !ok {
+		if p, ok := fn["parameters"]; ok {
+			fn["arguments"] = p
+			delete(fn, "parameters")
+			fixed.With("shape").Inc()
+			changed = true
+		}
+	}
+	if args, ok := arguments(fn["arguments"]); ok {
+		fn["arguments"] = args
+		fixed.With("arguments").Inc()
+		changed = true
+	}
+	if !changed {
+		return nil, false
+	}
+	c["function"], _ = json.Marshal(fn)
+	return c, true
+}
+
+// arguments turns arguments that aren't a JSON object into one: missing
+// or null ones into {}, a JSON-encoded object into the object. A string
+// that doesn't hold an object is left alone; there's no telling what the
+// model meant.
+func arguments(raw json.RawMessage) (json.RawMessage, bool) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || string(raw) == "null" {
+		return json.RawMessage("{}"), true
+	}
+	if raw[0] != '"' {
+		return nil, false
+	}
+	var s string
+	if json.Unmarshal(raw, &s) != nil {
+		return nil, false
+	}
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return json.RawMessage("{}"), true
+	}
+	var obj map[string]json.RawMessage
+	if json.Unmarshal([]byte(s), &obj) != nil {
+		return nil, false
+	}
+	var b bytes.Buffer
+	_ = json.Compact(&b, []byte(s))
+	return b.Bytes(), true
+}
+
+// toolNames returns the function names of a request's tools, or nil if
+// it has none.
+func toolNames(raw json.RawMessage) map[string]bool {
+	var tools []struct {
+		Function struct {
+			Name string `json:"name"`
+		} `json:"function"`
+	}
+	if json.Unmarshal(raw, &tools) != nil || len(tools) == 0 {
+		return nil
+	}
+	names := map[string]bool{}
+	for _, t := range tools {
+		names[t.Function.Name] = true
+	}
+	return names
+}
+
+type textCall struct {
+	Name       string          `json:"name"`
+	Arguments  json.RawMessage `json:"arguments"`
+	Parameters json.RawMessage `json:"parameters"`
+}
+
+// fromText finds tool calls in content: <tool_call> tags, as Hermes and
+// Qwen models write them, or content that is nothing but a call or a list
+// of calls, as Llama models do. It returns the calls and the content
+// left, and ok only if every call names one of tools.
+func fromText(content string, tools map[string]bool) (calls []map[string]any, rest string, ok bool) {
+	var found []textCall
+	if strings.Contains(content, "<tool_call>") {
+		var kept strings.Builder
+		s := content
+		for {
+			before, after, ok := strings.Cut(s, "<tool_call>")
+			kept.WriteString(before)
+			if !ok {
+				break
+			}
+			body, tail, _ := strings.Cut(after, "</tool_call>")
+			var c textCall
+			if json.Unmarshal([]byte(strings.TrimSpace(body)), &c) != nil {
+				return nil, "", false
+			}
+			found = append(found, c)
+			s = tail
+		}
+		rest = strings.TrimSpace(kept.String())
+	} else {
+		s := strings.TrimSpace(content)
+		s = strings.TrimPrefix(s, "```json")
+		s = strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(s, "```"), "```"))
+		if strings.HasPrefix(s, "[") {
+			if json.Unmarshal([]byte(s), &found) != nil {
+				return nil, "", false
+			}
+		} else {
+			var c textCall
+			if !strings.HasPrefix(s, "{") || json.Unmarshal([]byte(s), &c) != nil {
+				return nil, "", false
+			}
+			found = append(found, c)
+		}
+	}
+	if len(found) == 0 {
+		return nil, "", false
+	}
+	for _, c := range found {
+		if !tools[c.Name] {
+			return nil, "", false
+		}
+		args := c.Arguments
+		if len(args) == 0 {
+			args = c.Parameters
+		}
+		if a, ok := arguments(args); ok {
+			args = a
+		}
+		calls = append(calls, map[string]any{"function": map[string]any{"name": c.Name, "arguments": args}})
+	}
+	return calls, rest, true
+}
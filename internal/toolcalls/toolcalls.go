@@ -0,0 +1,175 @@
+// Package toolcalls normalizes the tool calls of /api/chat requests and
+// responses. Backends and models differ in small ways: arguments as a
+// JSON-encoded string instead of an object, calls without the "function"
+// wrapper, "parameters" instead of "arguments", several calls where a
+// client handles one, or calls written into the message text. Clients
+// get the shape Ollama documents, whatever produced the response.
+package toolcalls
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/yeti47/ollama-proxy/internal/apierror"
+	"github.com/yeti47/ollama-proxy/internal/metrics"
+	"github.com/yeti47/ollama-proxy/internal/ndjson"
+	"github.com/yeti47/ollama-proxy/internal/ollama"
+)
+
+var fixed = metrics.NewCounter("ollama_proxy_tool_calls_normalized_total",
+	"Tool call fixes applied to /api/chat requests and responses, by fix (arguments, shape, parallel, text).", "fix")
+
+// What to do with several tool calls in one response.
+const (
+	// Keep passes parallel tool calls on.
+	Keep = "keep"
+	// First keeps only the first tool call of a response, for clients
+	// that run one tool per turn.
+	First = "first"
+)
+
+// Normalizer rewrites the tool calls passing through its middleware.
+// Arguments and the shape of calls are always normalized.
+type Normalizer struct {
+	// Parallel is Keep or First.
+	Parallel string
+	// FromText turns tool calls a model wrote into the text of a
+	// complete response, as <tool_call> tags or a bare JSON call, into
+	// real tool calls. Only names of the request's tools are recognized.
+	FromText bool
+}
+
+// ParseParallel validates a Parallel setting; empty means Keep.
+func ParseParallel(s string) (string, error) {
+	switch s {
+	case "", Keep:
+		return Keep, nil
+	case First:
+		return First, nil
+	}
+	return "", fmt.Errorf("parallel tool calls must be %s or %s, not %q", Keep, First, s)
+}
+
+// Middleware normalizes the tool calls in the messages of /api/chat
+// requests, and in their responses, streamed or not.
+func (n *Normalizer) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/api/chat" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		body, err := ollama.PeekBody(r)
+		if err != nil {
+			apierror.BodyError(w, err)
+			return
+		}
+		var req map[string]json.RawMessage
+		if json.Unmarshal(body, &req) != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if msgs, ok := normalizeMessages(req["messages"]); ok {
+			req["messages"] = msgs
+			b, _ := json.Marshal(req)
+			ollama.ReplaceBody(r, b)
+		}
+		// the response is rewritten here, so it must arrive uncompressed
+		r.Header.Del("Accept-Encoding")
+		resp := &response{n: n}
+		if n.FromText {
+			resp.tools = toolNames(req["tools"])
+		}
+		nw := ndjson.NewWriter(w, resp.rewrite)
+		next.ServeHTTP(nw, r)
+		nw.Finish()
+	})
+}
+
+// normalizeMessages fixes the tool calls of the assistant messages a
+// client sends back, which Ollama rejects with string arguments.
+func normalizeMessages(raw json.RawMessage) (json.RawMessage, bool) {
+	var msgs []map[string]json.RawMessage
+	if json.Unmarshal(raw, &msgs) != nil {
+		return nil, false
+	}
+	changed := false
+	for _, m := range msgs {
+		if calls, ok := normalizeCalls(m["tool_calls"]); ok {
+			m["tool_calls"], changed = calls, true
+		}
+	}
+	if !changed {
+		return nil, false
+	}
+	b, _ := json.Marshal(msgs)
+	return b, true
+}
+
+// response is the state of a response having its tool calls normalized.
+type response struct {
+	n     *Normalizer
+	tools map[string]bool
+	calls int // tool calls sent so far in a stream
+}
+
+// rewrite implements the ndjson.Writer rewrite func.
+func (rs *response) rewrite(b []byte, complete bool) []byte {
+	if out, ok := rs.chunk(b, complete); ok {
+		return out
+	}
+	return b
+}
+
+// chunk normalizes the message of a response or stream chunk; ok is false
+// if nothing changed.
+func (rs *response) chunk(b []byte, complete bool) ([]byte, bool) {
+	if !bytes.Contains(b, []byte(`"tool_calls"`)) && !(complete && rs.tools != nil) {
+		return nil, false
+	}
+	var c map[string]json.RawMessage
+	var m map[string]json.RawMessage
+	if json.Unmarshal(b, &c) != nil || json.Unmarshal(c["message"], &m) != nil || m == nil {
+		return nil, false
+	}
+	changed := false
+	if tc := string(m["tool_calls"]); complete && rs.tools != nil && (tc == "" || tc == "null" || tc == "[]") {
+		var content string
+		_ = json.Unmarshal(m["content"], &content)
+		if calls, rest, ok := fromText(content, rs.tools); ok {
+			m["tool_calls"], _ = json.Marshal(calls)
+			m["content"], _ = json.Marshal(rest)
+			fixed.With("text").Inc()
+			changed = true
+		}
+	}
+	if calls, ok := normalizeCalls(m["tool_calls"]); ok {
+		m["tool_calls"], changed = calls, true
+	}
+	if rs.n.Parallel == First {
+		var calls []json.RawMessage
+		if json.Unmarshal(m["tool_calls"], &calls) == nil && len(calls) > 0 {
+			keep := calls[:0]
+			if rs.calls == 0 {
+				keep = calls[:1]
+			}
+			rs.calls += len(calls)
+			if len(keep) < len(calls) {
+				fixed.With("parallel").Inc()
+				if len(keep) == 0 {
+					delete(m, "tool_calls")
+				} else {
+					m["tool_calls"], _ = json.Marshal(keep)
+				}
+				changed = true
+			}
+		}
+	}
+	if !changed {
+		return nil, false
+	}
+	c["message"], _ = json.Marshal(m)
+	out, _ := json.Marshal(c)
+	return out, true
+}
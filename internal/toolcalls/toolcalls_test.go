@@ -0,0 +1,162 @@
+package toolcalls
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func upstream(got *[]byte, contentType, body string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*got, _ = io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Length", "1")
+		_, _ = io.WriteString(w, body)
+	})
+}
+
+func chat(h http.Handler, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/chat", strings.NewReader(body)))
+	return rec
+}
+
+func toolCalls(t *testing.T, b []byte) []call {
+	t.Helper()
+	var c struct {
+		Message struct {
+			Content   string `json:"content"`
+			ToolCalls []call `json:"tool_calls"`
+		} `json:"message"`
+	}
+	if err := json.Unmarshal(b, &c); err != nil {
+		t.Fatalf("%v: %s", err, b)
+	}
+	return c.Message.ToolCalls
+}
+
+type call struct {
+	Function struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	} `json:"function"`
+}
+
+func TestRequest(t *testing.T) {
+	var got []byte
+	n := &Normalizer{Parallel: Keep}
+	h := n.Middleware(upstream(&got, "application/json", `{"message":{"role":"assistant","content":"ok"},"done":true}`))
+	chat(h, `{"model":"llama3","messages":[
+		{"role":"assistant","content":"","tool_calls":[{"function":{"name":"get_weather","arguments":"{\"city\": \"Oslo\"}"}}]},
+		{"role":"tool","content":"sunny"}]}`)
+	var req struct {
+		Messages []struct {
+			ToolCalls []call `json:"tool_calls"`
+		} `json:"messages"`
+	}
+	if err := json.Unmarshal(got, &req); err != nil || string(req.Messages[0].ToolCalls[0].Function.Arguments) != `{"city":"Oslo"}` {
+		t.Errorf("forwarded %s (%v)", got, err)
+	}
+}
+
+func TestResponse(t *testing.T) {
+	var got []byte
+	n := &Normalizer{Parallel: Keep}
+	h := n.Middleware(upstream(&got, "application/json",
+		`{"message":{"role":"assistant","content":"","tool_calls":[{"name":"a","parameters":{"x":1}},{"function":{"name":"b","arguments":"{\"y\":2}"}},{"function":{"name":"c"}}]},"done":true}`))
+	rec := chat(h, `{"model":"llama3","stream":false,"messages":[]}`)
+	calls := toolCalls(t, rec.Body.Bytes())
+	if len(calls) != 3 || calls[0].Function.Name != "a" || string(calls[0].Function.Arguments) != `{"x":1}` ||
+		string(calls[1].Function.Arguments) != `{"y":2}` || string(calls[2].Function.Arguments) != `{}` {
+		t.Errorf("response %s", rec.Body)
+	}
+	if cl := rec.Header().Get("Content-Length"); cl != strconv.Itoa(rec.Body.Len()) {
+		t.Errorf("Content-Length %s for %d bytes", cl, rec.Body.Len())
+	}
+}
+
+func TestStreamFirst(t *testing.T) {
+	var got []byte
+	n := &Normalizer{Parallel: First}
+	h := n.Middleware(upstream(&got, "application/x-ndjson", strings.Join([]string{
+		`{"message":{"role":"assistant","content":"Checking."},"done":false}`,
+		`{"message":{"role":"assistant","content":"","tool_calls":[{"function":{"name":"a","arguments":"{}"}},{"function":{"name":"b","arguments":{}}}]},"done":false}`,
+		`{"message":{"role":"assistant","content":"","tool_calls":[{"function":{"name":"c","arguments":{}}}]},"done":false}`,
+		`{"message":{"role":"assistant","content":""},"done":true}`,
+	}, "\n")))
+	rec := chat(h, `{"model":"llama3","messages":[]}`)
+	lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+	if len(lines) != 4 || lines[0] != `{"message":{"role":"assistant","content":"Checking."},"done":false}` {
+		t.Fatalf("stream %s", rec.Body)
+	}
+	if calls := toolCalls(t, []byte(lines[1])); len(calls) != 1 || calls[0].Function.Name != "a" || string(calls[0].Function.Arguments) != "{}" {
+		t.Errorf("first tool call line %s", lines[1])
+	}
+	if calls := toolCalls(t, []byte(lines[2])); len(calls) != 0 {
+		t.Errorf("later tool call kept: %s", lines[2])
+	}
+}
+
+func TestFromText(t *testing.T) {
+	tools := `"tools":[{"type":"function","function":{"name":"get_weather"}}]`
+	for _, c := range []struct {
+		content string
+		calls   int
+		rest    string
+	}{
+		{`Let me check.<tool_call>{"name": "get_weather", "arguments": {"city": "Oslo"}}</tool_call>`, 1, "Let me check."},
+		{"```json\n" + `{"name": "get_weather", "parameters": {"city": "Oslo"}}` + "\n```", 1, ""},
+		{`[{"name": "get_weather", "arguments": {"city": "Oslo"}}, {"name": "get_weather", "arguments": "{\"city\": \"Bergen\"}"}]`, 2, ""},
+		{`{"name": "rm_rf", "arguments": {}}`, 0, `{"name": "rm_rf", "arguments": {}}`},
+		{`The weather is sunny.`, 0, `The weather is sunny.`},
+	} {
+		var got []byte
+		content, _ := json.Marshal(c.content)
+		n := &Normalizer{Parallel: Keep, FromText: true}
+		h := n.Middleware(upstream(&got, "application/json; charset=utf-8",
+			`{"message":{"role":"assistant","content":`+string(content)+`},"done":true}`))
+		rec := chat(h, `{"model":"llama3","stream":false,"messages":[],`+tools+`}`)
+		calls := toolCalls(t, rec.Body.Bytes())
+		var resp struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		}
+		_ = json.Unmarshal(rec.Body.Bytes(), &resp)
+		if len(calls) != c.calls || resp.Message.Content != c.rest {
+			t.Errorf("%s: got %s", c.content, rec.Body)
+			continue
+		}
+		for _, call := range calls {
+			if call.Function.Name != "get_weather" || !strings.Contains(string(call.Function.Arguments), `"city":`) {
+				t.Errorf("%s: call %+v", c.content, call)
+			}
+		}
+	}
+}
+
+func TestPassThrough(t *testing.T) {
+	n := &Normalizer{Parallel: First, FromText: true}
+	body := `{"error":"model not found"}`
+	h := n.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = io.WriteString(w, body)
+	}))
+	if rec := chat(h, `{"model":"x","messages":[]}`); rec.Code != http.StatusNotFound || rec.Body.String() != body {
+		t.Errorf("%d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestParseParallel(t *testing.T) {
+	if p, err := ParseParallel(""); p != Keep || err != nil {
+		t.Errorf("empty: %q, %v", p, err)
+	}
+	if _, err := ParseParallel("all"); err == nil {
+		t.Error("invalid setting accepted")
+	}
+}
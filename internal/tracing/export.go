@@ -0,0 +1,266 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yeti47/ollama-proxy/internal/metrics"
+)
+
+var (
+	droppedSpans = metrics.NewCounter("ollama_proxy_trace_spans_dropped_total",
+		"Spans dropped because the export queue was full or the collector rejected them.")
+	exportedSpans = metrics.NewCounter("ollama_proxy_trace_spans_exported_total",
+		"Spans exported to the OTLP collector.")
+)
+
+// Config configures a Tracer.
+type Config struct {
+	// Endpoint is the OTLP/HTTP traces URL, e.g.
+	// http://localhost:4318/v1/traces.
+	Endpoint string
+	// Headers are sent with every export request, e.g. for collector
+	// authentication.
+	Headers map[string]string
+	// ServiceName is reported as the service.name resource attribute.
+	ServiceName string
+	// SampleRatio is the fraction of new traces to record. Requests that
+	// carry a traceparent follow the caller's sampling decision.
+	SampleRatio float64
+	// Interval is how often queued spans are exported (default 5s).
+	Interval time.Duration
+}
+
+// ParseHeaders parses the OTEL_EXPORTER_OTLP_HEADERS format
+// ("k1=v1,k2=v2").
+func ParseHeaders(s string) map[string]string {
+	out := make(map[string]string)
+	for _, kv := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(kv, "=")
+		if ok && strings.TrimSpace(k) != "" {
+			out[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
+	return out
+}
+
+// New returns a tracer exporting to cfg.Endpoint. Call Shutdown to flush
+// the remaining spans on exit.
+func New(cfg Config) *Tracer {
+	if cfg.ServiceName == "" {
+		cfg.ServiceName = "ollama-proxy"
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = 5 * time.Second
+	}
+	e := &exporter{
+		cfg:    cfg,
+		client: &http.Client{Timeout: 10 * time.Second},
+		queue:  make(chan *Span, maxQueue),
+		flush:  make(chan chan struct{}),
+	}
+	go e.run()
+	return &Tracer{exporter: e, ratio: cfg.SampleRatio}
+}
+
+// Shutdown exports queued spans, waiting until ctx is done at most.
+func (t *Tracer) Shutdown(ctx context.Context) {
+	if t == nil || t.exporter == nil {
+		return
+	}
+	done := make(chan struct{})
+	select {
+	case t.exporter.flush <- done:
+		select {
+		case <-done:
+		case <-ctx.Done():
+		}
+	case <-ctx.Done():
+	}
+}
+
+const (
+	maxQueue = 2048
+	maxBatch = 512
+)
+
+type exporter struct {
+	cfg    Config
+	client *http.Client
+	queue  chan *Span
+	flush  chan chan struct{}
+}
+
+func (e *exporter) enqueue(s *Span) {
+	select {
+	case e.queue <- s:
+	default:
+		droppedSpans.With().Inc()
+	}
+}
+
+func (e *exporter) run() {
+	tick := time.NewTicker(e.cfg.Interval)
+	defer tick.Stop()
+	var batch []*Span
+	send := func() {
+		if len(batch) > 0 {
+			e.export(batch)
+			batch = nil
+		}
+	}
+	for {
+		select {
+		case s := <-e.queue:
+			batch = append(batch, s)
+			if len(batch) >= maxBatch {
+				send()
+			}
+		case <-tick.C:
+			send()
+		case done := <-e.flush:
+			for n := len(e.queue); n > 0; n-- {
+				batch = append(batch, <-e.queue)
+			}
+			send()
+			close(done)
+		}
+	}
+}
+
+func (e *exporter) export(spans []*Span) {
+	body, _ := json.Marshal(e.payload(spans))
+	req, err := http.NewRequest(http.MethodPost, e.cfg.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		slog.Error("tracing: building export request", "err", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(req)
+	if err == nil {
+		_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			err = fmt.Errorf("collector returned %s", resp.Status)
+		}
+	}
+	if err != nil {
+		slog.Warn("tracing: export failed", "spans", len(spans), "err", err)
+		droppedSpans.With().Add(float64(len(spans)))
+		return
+	}
+	exportedSpans.With().Add(float64(len(spans)))
+}
+
+// OTLP JSON encoding; see opentelemetry-proto's trace.proto. Ids are hex
+// strings and 64-bit integers are decimal strings.
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpEvent struct {
+	TimeUnixNano string `json:"timeUnixNano"`
+	Name         string `json:"name"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Events            []otlpEvent    `json:"events,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+func value(v any) otlpValue {
+	switch x := v.(type) {
+	case string:
+		return otlpValue{StringValue: &x}
+	case bool:
+		return otlpValue{BoolValue: &x}
+	case int:
+		s := strconv.FormatInt(int64(x), 10)
+		return otlpValue{IntValue: &s}
+	case int64:
+		s := strconv.FormatInt(x, 10)
+		return otlpValue{IntValue: &s}
+	case float64:
+		return otlpValue{DoubleValue: &x}
+	default:
+		s := fmt.Sprint(x)
+		return otlpValue{StringValue: &s}
+	}
+}
+
+func nanos(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+func (e *exporter) payload(spans []*Span) any {
+	out := make([]otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		s.mu.Lock()
+		o := otlpSpan{
+			TraceID:           hex.EncodeToString(s.sc.TraceID[:]),
+			SpanID:            hex.EncodeToString(s.sc.SpanID[:]),
+			Name:              s.name,
+			Kind:              s.kind,
+			StartTimeUnixNano: nanos(s.start),
+			EndTimeUnixNano:   nanos(s.end),
+		}
+		if s.parent != [8]byte{} {
+			o.ParentSpanID = hex.EncodeToString(s.parent[:])
+		}
+		for _, a := range s.attrs {
+			o.Attributes = append(o.Attributes, otlpKeyValue{a.key, value(a.value)})
+		}
+		for _, ev := range s.events {
+			o.Events = append(o.Events, otlpEvent{nanos(ev.time), ev.name})
+		}
+		if s.failed {
+			o.Status = otlpStatus{Code: 2, Message: s.errMsg}
+		}
+		s.mu.Unlock()
+		out = append(out, o)
+	}
+	return map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{
+				"attributes": []otlpKeyValue{{"service.name", value(e.cfg.ServiceName)}},
+			},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": "ollama-proxy"},
+				"spans": out,
+			}},
+		}},
+	}
+}
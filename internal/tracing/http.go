@@ -0,0 +1,142 @@
+package tracing
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Middleware wraps next in a server span per request, continuing the
+// caller's trace if it sent a traceparent header.
+func (t *Tracer) Middleware(next http.Handler) http.Handler {
+	if t == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := t.Start(Extract(r.Context(), r.Header), r.Method+" "+r.URL.Path, KindServer)
+		defer span.End()
+		span.Set("http.request.method", r.Method)
+		span.Set("url.path", r.URL.Path)
+		span.Set("user_agent.original", r.UserAgent())
+		if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			span.Set("client.address", host)
+		}
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r.WithContext(ctx))
+		span.Set("http.response.status_code", sw.status)
+		if sw.status >= 500 {
+			span.SetError(errStatus(sw.status))
+		}
+	})
+}
+
+type errStatus int
+
+func (e errStatus) Error() string { return http.StatusText(int(e)) }
+
+// statusWriter records the response status. It passes Flush through so
+// streamed responses keep streaming.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	wrote  bool
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if !w.wrote {
+		w.status, w.wrote = code, true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.wrote = true
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+// Transport returns a RoundTripper that records a client span for each
+// upstream request and propagates the trace to the upstream. The span
+// stays open until the response body has been read to the end or closed,
+// so it covers the whole stream; time to first byte and stream duration
+// are recorded as attributes.
+func (t *Tracer) Transport(next http.RoundTripper) http.RoundTripper {
+	if t == nil {
+		return next
+	}
+	return &transport{t: t, next: next}
+}
+
+type transport struct {
+	t    *Tracer
+	next http.RoundTripper
+}
+
+func (tr *transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	ctx, span := tr.t.Start(r.Context(), "upstream "+r.Method+" "+r.URL.Path, KindClient)
+	r = r.Clone(ctx)
+	Inject(ctx, r.Header)
+	span.Set("http.request.method", r.Method)
+	span.Set("server.address", r.URL.Hostname())
+	span.Set("url.path", r.URL.Path)
+
+	start := time.Now()
+	resp, err := tr.next.RoundTrip(r)
+	if err != nil {
+		span.SetError(err)
+		span.End()
+		return nil, err
+	}
+	headersAt := time.Now()
+	span.Set("http.response.status_code", resp.StatusCode)
+	span.Set("ollama_proxy.ttfb_ms", float64(headersAt.Sub(start).Microseconds())/1000)
+	if resp.StatusCode >= 500 {
+		span.SetError(errStatus(resp.StatusCode))
+	}
+	resp.Body = &spanBody{ReadCloser: resp.Body, span: span, start: headersAt}
+	return resp, nil
+}
+
+// spanBody ends the upstream span once the body is done.
+type spanBody struct {
+	io.ReadCloser
+	span  *Span
+	start time.Time
+	n     int64
+	once  sync.Once
+}
+
+func (b *spanBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	if err != nil {
+		b.finish(err)
+	}
+	return n, err
+}
+
+func (b *spanBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.finish(nil)
+	return err
+}
+
+func (b *spanBody) finish(err error) {
+	b.once.Do(func() {
+		b.span.Set("ollama_proxy.stream_duration_ms", float64(time.Since(b.start).Microseconds())/1000)
+		b.span.Set("http.response.body.size", b.n)
+		if err != nil && err != io.EOF {
+			b.span.SetError(err)
+		}
+		b.span.End()
+	})
+}
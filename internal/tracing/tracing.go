@@ -0,0 +1,239 @@
+// Package tracing records request spans and exports them to an
+// OpenTelemetry collector over OTLP/HTTP (JSON encoding), with W3C Trace
+// Context propagation. Like package metrics it is a small stand-in for the
+// full SDK: just enough to put the proxy into existing traces.
+//
+// A nil *Tracer is valid and records nothing, and all *Span methods accept
+// a nil receiver, so instrumentation can stay in place when tracing is
+// disabled.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Span kinds, as numbered by OTLP.
+const (
+	KindInternal = 1
+	KindServer   = 2
+	KindClient   = 3
+)
+
+// SpanContext identifies a span within a trace.
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+	// State is the caller's tracestate header, passed on unchanged.
+	State string
+}
+
+// Valid reports whether sc has non-zero ids.
+func (sc SpanContext) Valid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// Span is an operation being timed. Only sampled spans are exported.
+type Span struct {
+	tracer *Tracer
+	sc     SpanContext
+	parent [8]byte
+	name   string
+	kind   int
+	start  time.Time
+
+	mu     sync.Mutex
+	end    time.Time
+	attrs  []attribute
+	events []event
+	errMsg string
+	failed bool
+	ended  bool
+}
+
+type attribute struct {
+	key   string
+	value any
+}
+
+type event struct {
+	name string
+	time time.Time
+}
+
+// Context returns the span's identity.
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.sc
+}
+
+// recording reports whether s will be exported.
+func (s *Span) recording() bool {
+	return s != nil && s.sc.Sampled && s.tracer.exporter != nil
+}
+
+// Set records an attribute. Values may be strings, bools, integers or
+// floats.
+func (s *Span) Set(key string, value any) {
+	if !s.recording() {
+		return
+	}
+	s.mu.Lock()
+	s.attrs = append(s.attrs, attribute{key, value})
+	s.mu.Unlock()
+}
+
+// AddEvent records a timestamped event.
+func (s *Span) AddEvent(name string) {
+	if !s.recording() {
+		return
+	}
+	s.mu.Lock()
+	s.events = append(s.events, event{name, time.Now()})
+	s.mu.Unlock()
+}
+
+// SetError marks the span as failed.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.failed, s.errMsg = true, err.Error()
+	s.mu.Unlock()
+}
+
+// End finishes the span and queues it for export. Only the first call has
+// an effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended, s.end = true, time.Now()
+	s.mu.Unlock()
+	if s.recording() {
+		s.tracer.exporter.enqueue(s)
+	}
+}
+
+type spanKey struct{}
+type remoteKey struct{}
+
+// FromContext returns the current span in ctx, or nil.
+func FromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// TraceID returns the hex trace id of the span in ctx, or "".
+func TraceID(ctx context.Context) string {
+	s := FromContext(ctx)
+	if s == nil {
+		return ""
+	}
+	return hex.EncodeToString(s.sc.TraceID[:])
+}
+
+// Tracer starts spans and hands finished ones to its exporter.
+type Tracer struct {
+	// exporter is nil for a propagation-only tracer
+	exporter *exporter
+	// ratio of new traces to sample, in [0, 1]
+	ratio float64
+}
+
+// NewPropagator returns a tracer that exports nothing but still continues
+// or starts traces and passes traceparent and tracestate on to the
+// upstream, so trace ids show up in logs and the upstream can join the
+// trace. New traces are marked as sampled.
+func NewPropagator() *Tracer {
+	return &Tracer{ratio: 1}
+}
+
+// Start begins a span as a child of the span in ctx, or of a remote parent
+// extracted from an incoming request, or as the root of a new trace.
+func (t *Tracer) Start(ctx context.Context, name string, kind int) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	s := &Span{tracer: t, name: name, kind: kind, start: time.Now()}
+	var parent SpanContext
+	if p := FromContext(ctx); p != nil {
+		parent = p.sc
+	} else if rc, ok := ctx.Value(remoteKey{}).(SpanContext); ok {
+		parent = rc
+	}
+	if parent.Valid() {
+		s.sc.TraceID, s.parent, s.sc.Sampled, s.sc.State = parent.TraceID, parent.SpanID, parent.Sampled, parent.State
+	} else {
+		_, _ = rand.Read(s.sc.TraceID[:])
+		s.sc.Sampled = t.sample(s.sc.TraceID)
+	}
+	_, _ = rand.Read(s.sc.SpanID[:])
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// sample decides from the trace id so every service using the same ratio
+// makes the same choice.
+func (t *Tracer) sample(id [16]byte) bool {
+	if t.ratio >= 1 {
+		return true
+	}
+	return float64(binary.BigEndian.Uint64(id[8:])>>1) < t.ratio*(1<<63)
+}
+
+// Extract returns ctx carrying the remote parent from a W3C traceparent
+// header in h, if there is a valid one.
+func Extract(ctx context.Context, h http.Header) context.Context {
+	parts := strings.Split(strings.TrimSpace(h.Get("traceparent")), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return ctx
+	}
+	var sc SpanContext
+	var flags [1]byte
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return ctx
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return ctx
+	}
+	if _, err := hex.Decode(flags[:], []byte(parts[3])); err != nil || !sc.Valid() {
+		return ctx
+	}
+	sc.Sampled = flags[0]&1 == 1
+	sc.State = strings.TrimSpace(strings.Join(h.Values("tracestate"), ","))
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// Inject writes the traceparent header, and the tracestate received from
+// the caller, for the span in ctx into h.
+func Inject(ctx context.Context, h http.Header) {
+	s := FromContext(ctx)
+	if s == nil {
+		return
+	}
+	flags := "00"
+	if s.sc.Sampled {
+		flags = "01"
+	}
+	h.Set("traceparent", "00-"+hex.EncodeToString(s.sc.TraceID[:])+"-"+hex.EncodeToString(s.sc.SpanID[:])+"-"+flags)
+	if s.sc.State != "" {
+		h.Set("tracestate", s.sc.State)
+	} else {
+		h.Del("tracestate")
+	}
+}
@@ -0,0 +1,156 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type exported struct {
+	ResourceSpans []struct {
+		ScopeSpans []struct {
+			Spans []otlpSpan `json:"spans"`
+		} `json:"scopeSpans"`
+	} `json:"resourceSpans"`
+}
+
+func TestServerAndUpstreamSpans(t *testing.T) {
+	got := make(chan []otlpSpan, 4)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e exported
+		_ = json.NewDecoder(r.Body).Decode(&e)
+		var spans []otlpSpan
+		for _, rs := range e.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				spans = append(spans, ss.Spans...)
+			}
+		}
+		got <- spans
+	}))
+	defer collector.Close()
+
+	traceparent := make(chan string, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent <- r.Header.Get("traceparent")
+		_, _ = io.WriteString(w, `{"done":true}`)
+	}))
+	defer upstream.Close()
+
+	tr := New(Config{Endpoint: collector.URL, SampleRatio: 1, Interval: time.Hour})
+	client := &http.Client{Transport: tr.Transport(http.DefaultTransport)}
+	h := tr.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, _ := http.NewRequestWithContext(r.Context(), "POST", upstream.URL+"/api/chat", strings.NewReader("{}"))
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		_, _ = io.Copy(w, resp.Body)
+		resp.Body.Close()
+	}))
+
+	req := httptest.NewRequest("POST", "/api/chat", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tr.Shutdown(ctx)
+
+	spans := <-got
+	if len(spans) != 2 {
+		t.Fatalf("exported %d spans, want 2", len(spans))
+	}
+	byKind := map[int]otlpSpan{}
+	for _, s := range spans {
+		byKind[s.Kind] = s
+	}
+	server, up := byKind[KindServer], byKind[KindClient]
+	if server.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || server.ParentSpanID != "00f067aa0ba902b7" {
+		t.Errorf("server span not joined to caller's trace: %+v", server)
+	}
+	if up.TraceID != server.TraceID || up.ParentSpanID != server.SpanID {
+		t.Errorf("upstream span not a child of the server span: %+v", up)
+	}
+	if want := "00-" + up.TraceID + "-" + up.SpanID + "-01"; <-traceparent != want {
+		t.Errorf("upstream did not receive traceparent %s", want)
+	}
+	attrs := map[string]bool{}
+	for _, a := range up.Attributes {
+		attrs[a.Key] = true
+	}
+	for _, k := range []string{"ollama_proxy.ttfb_ms", "ollama_proxy.stream_duration_ms", "http.response.status_code"} {
+		if !attrs[k] {
+			t.Errorf("upstream span lacks %s", k)
+		}
+	}
+}
+
+func TestNilTracerAndUnsampled(t *testing.T) {
+	var tr *Tracer
+	ctx, span := tr.Start(context.Background(), "x", KindInternal)
+	span.Set("k", 1)
+	span.End()
+	if FromContext(ctx) != nil {
+		t.Error("nil tracer put a span in the context")
+	}
+
+	tr = &Tracer{exporter: &exporter{queue: make(chan *Span, 1)}, ratio: 1}
+	ctx = Extract(context.Background(), http.Header{"Traceparent": {"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00"}})
+	_, span = tr.Start(ctx, "x", KindServer)
+	span.End()
+	if len(tr.exporter.queue) != 0 {
+		t.Error("span of an unsampled trace was exported")
+	}
+}
+
+func TestPropagator(t *testing.T) {
+	got := make(chan http.Header, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got <- r.Header.Clone()
+	}))
+	defer upstream.Close()
+
+	tr := NewPropagator()
+	client := &http.Client{Transport: tr.Transport(http.DefaultTransport)}
+	var traceID string
+	h := tr.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceID = TraceID(r.Context())
+		req, _ := http.NewRequestWithContext(r.Context(), "GET", upstream.URL, nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		resp.Body.Close()
+	}))
+
+	req := httptest.NewRequest("GET", "/api/tags", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	req.Header.Set("tracestate", "vendor=abc")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	if traceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("trace id %q", traceID)
+	}
+	hdr := <-got
+	tp := strings.Split(hdr.Get("traceparent"), "-")
+	if len(tp) != 4 || tp[1] != traceID || tp[2] == "00f067aa0ba902b7" || tp[3] != "01" {
+		t.Errorf("upstream traceparent %q", hdr.Get("traceparent"))
+	}
+	if hdr.Get("tracestate") != "vendor=abc" {
+		t.Errorf("upstream tracestate %q", hdr.Get("tracestate"))
+	}
+
+	// without an incoming trace a new one is started
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/tags", nil))
+	hdr = <-got
+	if traceID == "" || !strings.Contains(hdr.Get("traceparent"), traceID) || hdr.Get("tracestate") != "" {
+		t.Errorf("new trace %q: traceparent %q tracestate %q", traceID, hdr.Get("traceparent"), hdr.Get("tracestate"))
+	}
+}
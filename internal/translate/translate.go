@@ -0,0 +1,165 @@
+// Package translate holds what the API dialect translations (openai,
+// anthropic) share: forwarding a rewritten request to the native Ollama
+// API and rewriting the response on its way back.
+package translate
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/yeti47/ollama-proxy/internal/apierror"
+	"github.com/yeti47/ollama-proxy/internal/metrics"
+	"github.com/yeti47/ollama-proxy/internal/ollama"
+)
+
+var translated = metrics.NewCounter("ollama_proxy_translations_total",
+	"Requests translated from another API dialect to the native Ollama API, by endpoint.", "endpoint")
+
+// Forward sends body to path, which may carry a query, on next in place
+// of r and counts it under endpoint; a nil body is sent as none. The
+// request is cloned so logging and metrics further out still see the
+// original path.
+func Forward(w http.ResponseWriter, r *http.Request, next http.Handler, endpoint, path string, body []byte) {
+	out := r.Clone(r.Context())
+	out.URL.RawPath = ""
+	out.URL.Path, out.URL.RawQuery, _ = strings.Cut(path, "?")
+	if body != nil {
+		ollama.ReplaceBody(out, body)
+		out.Header.Set("Content-Type", "application/json")
+	}
+	// the response is rewritten here, so it must arrive uncompressed
+	out.Header.Del("Accept-Encoding")
+	translated.With(endpoint).Inc()
+	next.ServeHTTP(w, out)
+}
+
+// ErrorFunc writes an error response in a dialect's format.
+type ErrorFunc func(w http.ResponseWriter, status int, msg string)
+
+// BodyError is apierror.BodyError in the format of writeErr.
+func BodyError(w http.ResponseWriter, err error, writeErr ErrorFunc) {
+	t := NewWriter(w, nil, writeErr)
+	apierror.BodyError(t, err)
+	t.Finish(nil)
+}
+
+// ErrorMessage extracts the message of an error body: Ollama's
+// {"error": "..."}, as written by Ollama and by the proxy itself, or
+// OpenAI's {"error": {"message": "..."}}.
+func ErrorMessage(status int, body []byte) string {
+	var e struct {
+		Error json.RawMessage `json:"error"`
+	}
+	if json.Unmarshal(body, &e) == nil {
+		var msg string
+		var obj struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(e.Error, &msg) == nil && msg != "" {
+			return msg
+		}
+		if json.Unmarshal(e.Error, &obj) == nil && obj.Message != "" {
+			return obj.Message
+		}
+	}
+	if s := strings.TrimSpace(string(body)); s != "" && len(s) < 512 {
+		return s
+	}
+	return http.StatusText(status)
+}
+
+// Writer buffers the response of a translated request: error bodies and
+// complete results are rewritten once finished, streamed ones line by
+// line.
+type Writer struct {
+	// ContentType is the type of a streamed response, server-sent events
+	// unless set.
+	ContentType string
+
+	w        http.ResponseWriter
+	line     func(b []byte)
+	writeErr ErrorFunc
+	status   int
+	buf      bytes.Buffer
+}
+
+// NewWriter returns a Writer for w. A successful response is streamed if
+// line is non-nil: line gets each of its lines as they arrive and writes
+// them, translated, to w. Error responses are rendered by writeErr.
+func NewWriter(w http.ResponseWriter, line func(b []byte), writeErr ErrorFunc) *Writer {
+	return &Writer{w: w, line: line, writeErr: writeErr}
+}
+
+func (t *Writer) Header() http.Header { return t.w.Header() }
+
+func (t *Writer) WriteHeader(code int) {
+	if t.status != 0 {
+		return
+	}
+	t.status = code
+	t.w.Header().Del("Content-Length")
+	if code == http.StatusOK && t.line != nil {
+		ct := t.ContentType
+		if ct == "" {
+			ct = "text/event-stream"
+		}
+		t.w.Header().Set("Content-Type", ct)
+		t.w.Header().Set("Cache-Control", "no-cache")
+		t.w.WriteHeader(code)
+	}
+}
+
+func (t *Writer) Write(b []byte) (int, error) {
+	if t.status == 0 {
+		t.WriteHeader(http.StatusOK)
+	}
+	t.buf.Write(b)
+	if t.status == http.StatusOK && t.line != nil {
+		for {
+			i := bytes.IndexByte(t.buf.Bytes(), '\n')
+			if i == -1 {
+				break
+			}
+			t.line(t.buf.Next(i + 1))
+		}
+	}
+	return len(b), nil
+}
+
+func (t *Writer) Flush() {
+	if f, ok := t.w.(http.Flusher); ok && t.line != nil {
+		f.Flush()
+	}
+}
+
+// Finish writes what is still buffered: the last partial line of a
+// stream, an error, or a complete result through result.
+func (t *Writer) Finish(result func(body []byte)) {
+	switch {
+	case t.status == 0:
+	case t.status != http.StatusOK:
+		t.writeErr(t.w, t.status, ErrorMessage(t.status, t.buf.Bytes()))
+	case t.line != nil:
+		if t.buf.Len() > 0 {
+			t.line(t.buf.Bytes())
+		}
+	default:
+		result(t.buf.Bytes())
+	}
+}
+
+// Streamed reports whether a successful response was streamed through
+// line, so a translation can end the stream even if the upstream didn't.
+func (t *Writer) Streamed() bool { return t.status == http.StatusOK && t.line != nil }
+
+// NewID returns a random identifier with prefix, like the IDs of OpenAI
+// and Anthropic responses.
+func NewID(prefix string) string {
+	b := make([]byte, 12)
+	_, _ = rand.Read(b)
+	return prefix + hex.EncodeToString(b)
+}
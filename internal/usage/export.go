@@ -0,0 +1,71 @@
+package usage
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// ParseTime parses an export bound given as a date (2006-01-02, midnight
+// UTC) or an RFC 3339 timestamp.
+func ParseTime(s string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q: want 2006-01-02 or RFC 3339", s)
+	}
+	return t.UTC(), nil
+}
+
+// Range returns the export range for the given bounds, either of which may
+// be empty. from defaults to the start of the current UTC month and to
+// defaults to the end of the current hour.
+func (l *Ledger) Range(from, to string) (time.Time, time.Time, error) {
+	now := l.now()
+	start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	end := now.Truncate(time.Hour).Add(time.Hour)
+	var err error
+	if from != "" {
+		if start, err = ParseTime(from); err != nil {
+			return start, end, err
+		}
+	}
+	if to != "" {
+		if end, err = ParseTime(to); err != nil {
+			return start, end, err
+		}
+	}
+	if !start.Before(end) {
+		return start, end, fmt.Errorf("from (%s) must be before to (%s)", start.Format(time.RFC3339), end.Format(time.RFC3339))
+	}
+	return start, end, nil
+}
+
+// Export writes rows for the range [from, to) as "csv" or "json". CSV has
+// a header line and one line per key and model, ready for a spreadsheet.
+func Export(w io.Writer, format string, from, to time.Time, rows []Row) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(map[string]any{"from": from, "to": to, "usage": rows})
+	case "csv":
+		cw := csv.NewWriter(w)
+		_ = cw.Write([]string{"from", "to", "key", "model", "requests", "prompt_tokens", "completion_tokens", "total_tokens"})
+		f, t := from.Format(time.RFC3339), to.Format(time.RFC3339)
+		for _, r := range rows {
+			_ = cw.Write([]string{f, t, r.Key, r.Model,
+				strconv.FormatInt(r.Requests, 10), strconv.FormatInt(r.PromptTokens, 10),
+				strconv.FormatInt(r.CompletionTokens, 10), strconv.FormatInt(r.TotalTokens, 10)})
+		}
+		cw.Flush()
+		return cw.Error()
+	default:
+		return fmt.Errorf("unknown format %q: want csv or json", format)
+	}
+}
@@ -0,0 +1,328 @@
+// Package usage keeps a history of requests and tokens per client key and
+// model in hourly buckets, so operators can see who is consuming the
+// shared upstream.
+package usage
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yeti47/ollama-proxy/internal/apierror"
+	"github.com/yeti47/ollama-proxy/internal/auth"
+	"github.com/yeti47/ollama-proxy/internal/ollama"
+)
+
+// anonymousKey tracks usage of unauthenticated clients.
+const anonymousKey = "anonymous"
+
+// bucket is the usage of one key and model during one UTC hour.
+type bucket struct {
+	Hour             time.Time `json:"hour"`
+	Key              string    `json:"key"`
+	Model            string    `json:"model"`
+	Requests         int64     `json:"requests"`
+	PromptTokens     int64     `json:"prompt_tokens"`
+	CompletionTokens int64     `json:"completion_tokens"`
+}
+
+type bucketID struct {
+	hour       int64 // unix seconds
+	key, model string
+}
+
+// Ledger accumulates usage in hourly buckets. Buckets older than Retention
+// are dropped when the ledger is saved. The buckets can be persisted to a
+// JSON file so a restart doesn't lose the history.
+type Ledger struct {
+	Retention time.Duration
+	StateFile string
+
+	mu      sync.Mutex
+	buckets map[bucketID]*bucket
+	dirty   bool
+	now     func() time.Time
+}
+
+// NewLedger returns a Ledger. If stateFile exists, buckets are loaded from
+// it.
+func NewLedger(retention time.Duration, stateFile string) (*Ledger, error) {
+	l := &Ledger{
+		Retention: retention,
+		StateFile: stateFile,
+		buckets:   make(map[bucketID]*bucket),
+		now:       func() time.Time { return time.Now().UTC() },
+	}
+	if stateFile != "" {
+		b, err := os.ReadFile(stateFile)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if len(b) > 0 {
+			var saved []*bucket
+			if err := json.Unmarshal(b, &saved); err != nil {
+				return nil, fmt.Errorf("parsing %s: %w", stateFile, err)
+			}
+			for _, bk := range saved {
+				l.buckets[bucketID{bk.Hour.Unix(), bk.Key, bk.Model}] = bk
+			}
+		}
+	}
+	return l, nil
+}
+
+// Record adds a completed response to the current hour. It matches the
+// proxy's OnUsage hook.
+func (l *Ledger) Record(r *http.Request, u ollama.Usage) {
+	key := auth.ClientName(r.Context())
+	if key == "" {
+		key = anonymousKey
+	}
+	model := u.Model
+	if model == "" {
+		model = "unknown"
+	} else {
+		model = ollama.NormalizeModel(model)
+	}
+	hour := l.now().Truncate(time.Hour)
+	id := bucketID{hour.Unix(), key, model}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	bk, ok := l.buckets[id]
+	if !ok {
+		bk = &bucket{Hour: hour, Key: key, Model: model}
+		l.buckets[id] = bk
+	}
+	bk.Requests++
+	bk.PromptTokens += int64(u.PromptTokens)
+	bk.CompletionTokens += int64(u.CompletionTokens)
+	l.dirty = true
+}
+
+// Row is the usage of one key and model over a time range.
+type Row struct {
+	Key              string `json:"key"`
+	Model            string `json:"model"`
+	Requests         int64  `json:"requests"`
+	PromptTokens     int64  `json:"prompt_tokens"`
+	CompletionTokens int64  `json:"completion_tokens"`
+	TotalTokens      int64  `json:"total_tokens"`
+}
+
+// Query sums the buckets in [from, to) per key and model, sorted by key
+// and model. An empty key matches every key. Buckets are hourly, so from
+// and to are effectively rounded down to the hour.
+func (l *Ledger) Query(key string, from, to time.Time) []Row {
+	from, to = from.Truncate(time.Hour), to.Truncate(time.Hour)
+	sums := make(map[[2]string]*Row)
+	l.mu.Lock()
+	for _, bk := range l.buckets {
+		if (key != "" && bk.Key != key) || bk.Hour.Before(from) || !bk.Hour.Before(to) {
+			continue
+		}
+		row, ok := sums[[2]string{bk.Key, bk.Model}]
+		if !ok {
+			row = &Row{Key: bk.Key, Model: bk.Model}
+			sums[[2]string{bk.Key, bk.Model}] = row
+		}
+		row.Requests += bk.Requests
+		row.PromptTokens += bk.PromptTokens
+		row.CompletionTokens += bk.CompletionTokens
+	}
+	l.mu.Unlock()
+
+	rows := make([]Row, 0, len(sums))
+	for _, row := range sums {
+		row.TotalTokens = row.PromptTokens + row.CompletionTokens
+		rows = append(rows, *row)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Key != rows[j].Key {
+			return rows[i].Key < rows[j].Key
+		}
+		return rows[i].Model < rows[j].Model
+	})
+	return rows
+}
+
+// KeyUsage is the usage of one key over a window, with a breakdown per
+// model.
+type KeyUsage struct {
+	Key              string `json:"key"`
+	Requests         int64  `json:"requests"`
+	PromptTokens     int64  `json:"prompt_tokens"`
+	CompletionTokens int64  `json:"completion_tokens"`
+	TotalTokens      int64  `json:"total_tokens"`
+	Models           []Row  `json:"models"`
+}
+
+// byKey groups rows, which must be sorted by key, into per-key totals.
+func byKey(rows []Row) []KeyUsage {
+	out := []KeyUsage{}
+	for _, row := range rows {
+		if len(out) == 0 || out[len(out)-1].Key != row.Key {
+			out = append(out, KeyUsage{Key: row.Key})
+		}
+		ku := &out[len(out)-1]
+		ku.Requests += row.Requests
+		ku.PromptTokens += row.PromptTokens
+		ku.CompletionTokens += row.CompletionTokens
+		ku.TotalTokens += row.TotalTokens
+		ku.Models = append(ku.Models, row)
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].TotalTokens > out[j].TotalTokens })
+	return out
+}
+
+// ParseWindow parses a window such as "24h", "90m" or "7d". Days are not
+// understood by time.ParseDuration, so they are handled here.
+func ParseWindow(s string) (time.Duration, error) {
+	if n, ok := strings.CutSuffix(s, "d"); ok {
+		days, err := strconv.Atoi(n)
+		if err != nil || days <= 0 {
+			return 0, fmt.Errorf("invalid window %q", s)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid window %q", s)
+	}
+	return d, nil
+}
+
+// AdminHandler serves usage reports. It expects to be mounted at
+// /admin/usage and /admin/usage/:
+//
+//	GET /admin/usage?key=&window=                   per-key totals, heaviest first
+//	GET /admin/usage/export?key=&from=&to=&format=  per-key/per-model rows
+//
+// window defaults to 24h. Exports default to the current month as CSV.
+func (l *Ledger) AdminHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			apierror.Write(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		switch strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/usage"), "/") {
+		case "":
+			l.report(w, r)
+		case "export":
+			l.export(w, r)
+		default:
+			apierror.Write(w, http.StatusNotFound, "not found")
+		}
+	})
+}
+
+func (l *Ledger) report(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	window := 24 * time.Hour
+	if s := q.Get("window"); s != "" {
+		d, err := ParseWindow(s)
+		if err != nil {
+			apierror.Write(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		window = d
+	}
+	// the current hour is still filling up and is included
+	to := l.now().Truncate(time.Hour).Add(time.Hour)
+	from := to.Add(-window)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"from": from,
+		"to":   to,
+		"keys": byKey(l.Query(q.Get("key"), from, to)),
+	})
+}
+
+func (l *Ledger) export(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	from, to, err := l.Range(q.Get("from"), q.Get("to"))
+	if err != nil {
+		apierror.Write(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	format := q.Get("format")
+	switch format {
+	case "", "csv":
+		format = "csv"
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="usage-%s-%s.csv"`, from.Format("20060102"), to.Format("20060102")))
+	case "json":
+		w.Header().Set("Content-Type", "application/json")
+	default:
+		apierror.Write(w, http.StatusBadRequest, "format must be csv or json")
+		return
+	}
+	_ = Export(w, format, from, to, l.Query(q.Get("key"), from, to))
+}
+
+// prune drops buckets older than Retention. Callers must hold l.mu.
+func (l *Ledger) prune() {
+	if l.Retention <= 0 {
+		return
+	}
+	cutoff := l.now().Add(-l.Retention)
+	for id, bk := range l.buckets {
+		if bk.Hour.Add(time.Hour).Before(cutoff) {
+			delete(l.buckets, id)
+			l.dirty = true
+		}
+	}
+}
+
+// Save prunes expired buckets and writes the rest to StateFile if they
+// changed since the last save.
+func (l *Ledger) Save() error {
+	l.mu.Lock()
+	l.prune()
+	if l.StateFile == "" || !l.dirty {
+		l.mu.Unlock()
+		return nil
+	}
+	saved := make([]*bucket, 0, len(l.buckets))
+	for _, bk := range l.buckets {
+		saved = append(saved, bk)
+	}
+	b, err := json.Marshal(saved)
+	l.dirty = false
+	l.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp := l.StateFile + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, l.StateFile)
+}
+
+// SaveEvery persists the buckets every interval until stop is closed, and
+// once more on the way out.
+func (l *Ledger) SaveEvery(interval time.Duration, stop <-chan struct{}) {
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+			if err := l.Save(); err != nil {
+				slog.Error("saving usage state", "err", err)
+			}
+		case <-stop:
+			if err := l.Save(); err != nil {
+				slog.Error("saving usage state", "err", err)
+			}
+			return
+		}
+	}
+}
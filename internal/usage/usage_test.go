@@ -0,0 +1,135 @@
+package usage
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/yeti47/ollama-proxy/internal/auth"
+	"github.com/yeti47/ollama-proxy/internal/ollama"
+)
+
+func TestLedger(t *testing.T) {
+	state := filepath.Join(t.TempDir(), "usage.json")
+	l, err := NewLedger(48*time.Hour, state)
+	if err != nil {
+		t.Fatalf("new ledger: %v", err)
+	}
+	now := time.Date(2026, 3, 31, 10, 30, 0, 0, time.UTC)
+	l.now = func() time.Time { return now }
+
+	req := func(name string) *http.Request {
+		r := httptest.NewRequest("POST", "/api/chat", nil)
+		if name == "" {
+			return r
+		}
+		return r.WithContext(auth.WithIdentity(r.Context(), &auth.Identity{Name: name}))
+	}
+	l.Record(req("alice"), ollama.Usage{Model: "llama3", PromptTokens: 10, CompletionTokens: 20})
+	l.Record(req("alice"), ollama.Usage{Model: "llama3:latest", PromptTokens: 1, CompletionTokens: 2})
+	l.Record(req("alice"), ollama.Usage{Model: "qwen2:7b", PromptTokens: 5, CompletionTokens: 5})
+	l.Record(req(""), ollama.Usage{Model: "llama3", PromptTokens: 100, CompletionTokens: 100})
+	now = now.Add(-30 * time.Hour)
+	l.Record(req("alice"), ollama.Usage{Model: "llama3", PromptTokens: 1000})
+	now = now.Add(30 * time.Hour)
+
+	get := func(query string) (int, []KeyUsage) {
+		rec := httptest.NewRecorder()
+		l.AdminHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/admin/usage"+query, nil))
+		var resp struct{ Keys []KeyUsage }
+		_ = json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec.Code, resp.Keys
+	}
+
+	code, keys := get("")
+	if code != http.StatusOK || len(keys) != 2 {
+		t.Fatalf("status %d keys %+v", code, keys)
+	}
+	if keys[0].Key != "anonymous" || keys[0].TotalTokens != 200 {
+		t.Errorf("keys should be sorted by tokens, got %+v", keys[0])
+	}
+	alice := keys[1]
+	if alice.Requests != 3 || alice.TotalTokens != 43 || len(alice.Models) != 2 {
+		t.Fatalf("alice %+v", alice)
+	}
+	if m := alice.Models[0]; m.Model != "llama3:latest" || m.Requests != 2 || m.PromptTokens != 11 || m.CompletionTokens != 22 {
+		t.Errorf("alice llama3 %+v", m)
+	}
+
+	_, keys = get("?key=alice&window=2d")
+	if len(keys) != 1 || keys[0].PromptTokens != 1016 {
+		t.Errorf("alice over 2d %+v", keys)
+	}
+	if code, _ := get("?window=soon"); code != http.StatusBadRequest {
+		t.Errorf("bad window: status %d", code)
+	}
+
+	// the old bucket falls out of retention on save; the rest survive a
+	// restart
+	now = now.Add(20 * time.Hour)
+	if err := l.Save(); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	l2, err := NewLedger(48*time.Hour, state)
+	if err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	rows := l2.Query("alice", time.Time{}, now.Add(time.Hour))
+	if len(rows) != 2 || rows[0].PromptTokens != 11 {
+		t.Errorf("reloaded rows %+v", rows)
+	}
+}
+
+func TestParseWindow(t *testing.T) {
+	for in, want := range map[string]time.Duration{"90m": 90 * time.Minute, "24h": 24 * time.Hour, "7d": 7 * 24 * time.Hour} {
+		if got, err := ParseWindow(in); err != nil || got != want {
+			t.Errorf("%s: got %v, %v", in, got, err)
+		}
+	}
+	for _, in := range []string{"", "0d", "-1h", "d", "week"} {
+		if _, err := ParseWindow(in); err == nil {
+			t.Errorf("%s: expected error", in)
+		}
+	}
+}
+
+func TestExport(t *testing.T) {
+	l, _ := NewLedger(0, "")
+	now := time.Date(2026, 9, 15, 12, 0, 0, 0, time.UTC)
+	l.now = func() time.Time { return now }
+	r := httptest.NewRequest("POST", "/api/chat", nil)
+	r = r.WithContext(auth.WithIdentity(r.Context(), &auth.Identity{Name: "alice"}))
+	l.Record(r, ollama.Usage{Model: "llama3", PromptTokens: 3, CompletionTokens: 4})
+	now = time.Date(2026, 10, 2, 0, 0, 0, 0, time.UTC)
+	l.Record(r, ollama.Usage{Model: "llama3", PromptTokens: 100})
+
+	rec := httptest.NewRecorder()
+	l.AdminHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/admin/usage/export?from=2026-09-01&to=2026-10-01", nil))
+	want := "from,to,key,model,requests,prompt_tokens,completion_tokens,total_tokens\n" +
+		"2026-09-01T00:00:00Z,2026-10-01T00:00:00Z,alice,llama3:latest,1,3,4,7\n"
+	if rec.Code != http.StatusOK || rec.Body.String() != want {
+		t.Errorf("csv export: status %d body %q", rec.Code, rec.Body.String())
+	}
+
+	// the default range is the current month
+	rec = httptest.NewRecorder()
+	l.AdminHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/admin/usage/export?format=json", nil))
+	var resp struct{ Usage []Row }
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Usage) != 1 || resp.Usage[0].PromptTokens != 100 {
+		t.Errorf("json export %+v", resp.Usage)
+	}
+
+	for _, q := range []string{"from=yesterday", "from=2026-10-01&to=2026-09-01", "format=xlsx"} {
+		rec = httptest.NewRecorder()
+		l.AdminHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/admin/usage/export?"+q, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d", q, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+// Package vcr records upstream exchanges to disk and replays them, so
+// integration tests against the proxy run without network access or
+// token costs. Recordings are keyed by a hash of the request, so a test
+// that sends the same request gets the same answer every time.
+package vcr
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/yeti47/ollama-proxy/internal/apierror"
+	"github.com/yeti47/ollama-proxy/internal/metrics"
+	"github.com/yeti47/ollama-proxy/internal/ollama"
+)
+
+var requests = metrics.NewCounter("ollama_proxy_vcr_requests_total",
+	"Requests seen by record/replay mode by result: recorded, replayed or missing.", "result")
+
+// Mode is what the cassette does with requests.
+type Mode string
+
+const (
+	// Record forwards every request and saves the exchange.
+	Record Mode = "record"
+	// Replay answers from recordings only; requests without one fail.
+	Replay Mode = "replay"
+	// Once replays recorded requests and records the rest.
+	Once Mode = "once"
+)
+
+// ParseMode validates a -vcr flag value.
+func ParseMode(s string) (Mode, error) {
+	switch m := Mode(s); m {
+	case Record, Replay, Once:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown mode %q, want record, replay or once", s)
+}
+
+// skipped are response headers not worth recording.
+var skipped = map[string]bool{"Date": true, "Content-Length": true, "Connection": true, "Transfer-Encoding": true, "Set-Cookie": true}
+
+// Cassette keeps recordings as one JSON file per request in Dir.
+type Cassette struct {
+	Dir  string
+	Mode Mode
+	// OnUsage is called with the token counts of replayed generations, as
+	// the proxy does for forwarded ones.
+	OnUsage func(*http.Request, ollama.Usage)
+}
+
+// recording is the file format.
+type recording struct {
+	Request struct {
+		Method string          `json:"method"`
+		Path   string          `json:"path"`
+		Query  string          `json:"query,omitempty"`
+		Body   json.RawMessage `json:"body,omitempty"`
+	} `json:"request"`
+	Status     int         `json:"status"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       string      `json:"body"`
+	RecordedAt time.Time   `json:"recorded_at"`
+}
+
+// Key identifies a request: its method, path, query and body. JSON
+// bodies are compared by content, so key order and whitespace don't
+// matter; headers, including credentials, are ignored.
+func Key(method, path, query string, body []byte) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s?%s\n", method, path, query)
+	h.Write(canonical(body))
+	return hex.EncodeToString(h.Sum(nil))[:32]
+}
+
+// canonical re-encodes a JSON body with sorted keys and no whitespace;
+// other bodies are returned unchanged.
+func canonical(body []byte) []byte {
+	var v any
+	if len(body) == 0 || json.Unmarshal(body, &v) != nil {
+		return body
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return body
+	}
+	return b
+}
+
+func (c *Cassette) path(key string) string { return filepath.Join(c.Dir, key+".json") }
+
+// Middleware records the exchanges of next or replays them instead.
+func (c *Cassette) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ollama.PeekBody(r)
+		if err != nil {
+			apierror.BodyError(w, err)
+			return
+		}
+		key := Key(r.Method, r.URL.Path, r.URL.RawQuery, body)
+		if c.Mode != Record {
+			rec, err := c.load(key)
+			switch {
+			case err == nil:
+				requests.With("replayed").Inc()
+				c.replay(w, r, rec)
+				return
+			case !errors.Is(err, fs.ErrNotExist):
+				slog.Error("reading recording failed", "key", key, "err", err)
+				apierror.Write(w, http.StatusInternalServerError, "reading recording failed")
+				return
+			case c.Mode == Replay:
+				requests.With("missing").Inc()
+				slog.Warn("no recording for request", "method", r.Method, "path", r.URL.Path, "key", key)
+				apierror.Write(w, http.StatusNotFound, "no recorded response for this request (key "+key+")")
+				return
+			}
+		}
+		// recordings are kept readable, so the response must arrive
+		// uncompressed
+		r.Header.Del("Accept-Encoding")
+		rw := &recorder{ResponseWriter: w}
+		next.ServeHTTP(rw, r)
+		if r.Context().Err() != nil {
+			// the client left; the response is likely cut short
+			return
+		}
+		rec := &recording{Status: rw.status, Header: http.Header{}, Body: rw.body.String(), RecordedAt: time.Now().UTC()}
+		if rec.Status == 0 {
+			rec.Status = http.StatusOK
+		}
+		rec.Request.Method, rec.Request.Path, rec.Request.Query = r.Method, r.URL.Path, r.URL.RawQuery
+		if len(body) > 0 && json.Valid(body) {
+			rec.Request.Body = body
+		}
+		for k, vs := range rw.Header() {
+			if !skipped[k] {
+				rec.Header[k] = vs
+			}
+		}
+		if err := c.save(key, rec); err != nil {
+			slog.Error("saving recording failed", "key", key, "err", err)
+			return
+		}
+		requests.With("recorded").Inc()
+	})
+}
+
+func (c *Cassette) load(key string) (*recording, error) {
+	b, err := os.ReadFile(c.path(key))
+	if err != nil {
+		return nil, err
+	}
+	var rec recording
+	if err := json.Unmarshal(b, &rec); err != nil {
+		return nil, fmt.Errorf("%s: %w", c.path(key), err)
+	}
+	return &rec, nil
+}
+
+// save writes rec through a temporary file, so a replay running
+// alongside never sees half a recording.
+func (c *Cassette) save(key string, rec *recording) error {
+	b, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(c.Dir, ".recording-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), c.path(key))
+}
+
+// replay writes a recorded response, flushing each line so streams
+// arrive as chunks again.
+func (c *Cassette) replay(w http.ResponseWriter, r *http.Request, rec *recording) {
+	for k, vs := range rec.Header {
+		w.Header()[k] = vs
+	}
+	w.WriteHeader(rec.Status)
+	flusher, _ := w.(http.Flusher)
+	br := bufio.NewReader(bytes.NewReader([]byte(rec.Body)))
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(line) > 0 {
+			if _, werr := w.Write(line); werr != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+			if u, ok := ollama.ParseUsage(line); ok && c.OnUsage != nil {
+				c.OnUsage(r, u)
+			}
+		}
+		if err == io.EOF {
+			return
+		}
+	}
+}
+
+// recorder copies the response while passing it on.
+type recorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *recorder) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *recorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *recorder) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *recorder) Unwrap() http.ResponseWriter { return w.ResponseWriter }
@@ -0,0 +1,85 @@
+package vcr
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/yeti47/ollama-proxy/internal/ollama"
+)
+
+const stream = `{"message":{"content":"Hi"},"done":false}
+{"message":{"content":""},"done":true,"prompt_eval_count":3,"eval_count":1}
+`
+
+// upstream answers every request with the stream and counts the calls.
+func upstream(calls *int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*calls++
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Date", "Mon, 01 Jan 2024 00:00:00 GMT")
+		io.WriteString(w, stream)
+	})
+}
+
+func send(h http.Handler, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/chat", strings.NewReader(body)))
+	return w
+}
+
+func TestRecordReplay(t *testing.T) {
+	dir := t.TempDir()
+	calls := 0
+	rec := (&Cassette{Dir: dir, Mode: Record}).Middleware(upstream(&calls))
+	if w := send(rec, `{"model":"m","messages":[{"role":"user","content":"hi"}]}`); w.Body.String() != stream {
+		t.Fatalf("recorded pass-through = %q", w.Body)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Fatalf("%d files recorded", len(entries))
+	}
+
+	var usage ollama.Usage
+	play := (&Cassette{Dir: dir, Mode: Replay, OnUsage: func(_ *http.Request, u ollama.Usage) { usage = u }}).Middleware(upstream(&calls))
+	// the same request with other key order and whitespace
+	w := send(play, `{ "messages": [{"content":"hi","role":"user"}], "model": "m" }`)
+	if w.Code != http.StatusOK || w.Body.String() != stream {
+		t.Fatalf("replay = %d %q", w.Code, w.Body)
+	}
+	if w.Header().Get("Content-Type") != "application/x-ndjson" || w.Header().Get("Date") != "" {
+		t.Errorf("replayed headers = %v", w.Header())
+	}
+	if calls != 1 {
+		t.Errorf("upstream called %d times", calls)
+	}
+	if usage.PromptTokens != 3 || usage.CompletionTokens != 1 {
+		t.Errorf("usage = %+v", usage)
+	}
+
+	if w := send(play, `{"model":"m","messages":[]}`); w.Code != http.StatusNotFound {
+		t.Errorf("missing recording answered %d", w.Code)
+	}
+}
+
+func TestOnce(t *testing.T) {
+	calls := 0
+	h := (&Cassette{Dir: t.TempDir(), Mode: Once}).Middleware(upstream(&calls))
+	send(h, `{"model":"a"}`)
+	send(h, `{"model":"a"}`)
+	send(h, `{"model":"b"}`)
+	if calls != 2 {
+		t.Errorf("upstream called %d times, want 2", calls)
+	}
+}
+
+func TestParseMode(t *testing.T) {
+	if m, err := ParseMode("once"); err != nil || m != Once {
+		t.Errorf("ParseMode(once) = %q, %v", m, err)
+	}
+	if _, err := ParseMode("rewind"); err == nil {
+		t.Error("unknown mode accepted")
+	}
+}